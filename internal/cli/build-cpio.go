@@ -37,22 +37,25 @@ func buildCPIO() *cobra.Command {
 	var sbomPath string
 	var extraKeys []string
 	var extraBuildRepos []string
+	var melangeWorkspace string
 	var extraRepos []string
 	var extraPackages []string
 	var sizeLimits options.SizeLimits
 
 	cmd := &cobra.Command{
-		Use:     "build-cpio",
-		Short:   "Build a cpio file from a YAML configuration file",
-		Long:    "Build a cpio file from a YAML configuration file",
-		Example: `  apko build-cpio <config.yaml> <output.cpio>`,
-		Hidden:  true,
-		Args:    cobra.ExactArgs(2),
+		Use:               "build-cpio",
+		Short:             "Build a cpio file from a YAML configuration file",
+		Long:              "Build a cpio file from a YAML configuration file",
+		Example:           `  apko build-cpio <config.yaml> <output.cpio>`,
+		Hidden:            true,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeConfigFile,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return BuildCPIOCmd(cmd.Context(), args[1],
 				build.WithConfig(args[0], []string{}),
 				build.WithExtraKeys(extraKeys),
 				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
 				build.WithExtraRepos(extraRepos),
 				build.WithExtraPackages(extraPackages),
 				build.WithBuildDate(buildDate),
@@ -68,6 +71,7 @@ func buildCPIO() *cobra.Command {
 	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "generate an SBOM")
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
 	addClientLimitFlags(cmd, &sizeLimits)
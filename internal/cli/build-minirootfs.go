@@ -35,23 +35,28 @@ func buildMinirootFS() *cobra.Command {
 	var buildArch string
 	var sbomPath string
 	var ignoreSignatures bool
+	var fips bool
+	var repositoryTLS repositoryTLSFlag
 	var extraKeys []string
 	var extraBuildRepos []string
+	var melangeWorkspace string
 	var extraRepos []string
 	var extraPackages []string
 	var sizeLimits options.SizeLimits
 
 	cmd := &cobra.Command{
-		Use:     "build-minirootfs",
-		Short:   "Build a minirootfs image from a YAML configuration file",
-		Long:    "Build a minirootfs image from a YAML configuration file",
-		Example: `  apko build-minirootfs <config.yaml> <output.tar.gz>`,
-		Args:    cobra.ExactArgs(2),
+		Use:               "build-minirootfs",
+		Short:             "Build a minirootfs image from a YAML configuration file",
+		Long:              "Build a minirootfs image from a YAML configuration file",
+		Example:           `  apko build-minirootfs <config.yaml> <output.tar.gz>`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeConfigFile,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return BuildMinirootFSCmd(cmd.Context(),
 				build.WithConfig(args[0], []string{}),
 				build.WithExtraKeys(extraKeys),
 				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
 				build.WithExtraRepos(extraRepos),
 				build.WithExtraPackages(extraPackages),
 				build.WithTarball(args[1]),
@@ -59,6 +64,8 @@ func buildMinirootFS() *cobra.Command {
 				build.WithSBOM(sbomPath),
 				build.WithArch(types.ParseArchitecture(buildArch)),
 				build.WithIgnoreSignatures(ignoreSignatures),
+				build.WithFIPS(fips),
+				build.WithRepositoryTLS(repositoryTLS...),
 				build.WithSizeLimits(sizeLimits),
 			)
 		},
@@ -68,8 +75,11 @@ func buildMinirootFS() *cobra.Command {
 	cmd.Flags().StringVar(&buildArch, "build-arch", runtime.GOARCH, "architecture to build for -- default is Go runtime architecture")
 	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "generate an SBOM")
 	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().BoolVar(&fips, "fips", false, "restrict TLS and repository signature verification to FIPS-approved algorithms, failing on repositories only signed with non-approved ones")
+	addRepositoryTLSFlag(cmd, &repositoryTLS)
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
 	addClientLimitFlags(cmd, &sizeLimits)
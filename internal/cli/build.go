@@ -19,27 +19,28 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
-	"sync"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
 	"github.com/spf13/cobra"
-	"go.opentelemetry.io/otel"
-	"golang.org/x/sync/errgroup"
 	"golang.org/x/sys/unix"
 
 	"github.com/chainguard-dev/clog"
 
 	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/apko"
 	"chainguard.dev/apko/pkg/build"
 	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/fsimage"
 	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/remotecache"
+	"chainguard.dev/apko/pkg/sbom"
 	"chainguard.dev/apko/pkg/sbom/generator"
-	"chainguard.dev/apko/pkg/tarfs"
 )
 
 func buildCmd() *cobra.Command {
@@ -49,17 +50,51 @@ func buildCmd() *cobra.Command {
 	var writeSBOM bool
 	var sbomPath string
 	var sbomFormats []string
+	var sbomFiles bool
+	var sbomDetectLicenses bool
+	var sbomCPEs bool
+	var sbomCPEMapping string
+	var sbomSigningKey string
+	var sbomKeyless bool
+	var sbomFulcioURL string
+	var sbomRekorURL string
+	var sbomNameTemplate string
 	var extraKeys []string
 	var extraBuildRepos []string
+	var melangeWorkspace string
 	var extraRepos []string
 	var extraPackages []string
 	var rawAnnotations []string
+	var annotationsAsLabels bool
 	var cacheDir string
+	var layerCacheDir string
+	var noLayerCache bool
+	var remoteCache string
+	var strictReproducibility bool
+	var buildInfo bool
 	var offline bool
 	var lockfile string
+	var bundlePath string
 	var includePaths []string
 	var ignoreSignatures bool
+	var fips bool
+	var repositoryTLS repositoryTLSFlag
+	var packagePolicy string
+	var runScriptlets string
+	var noScripts []string
 	var sizeLimits options.SizeLimits
+	var output string
+	var initramfsCompression string
+	var initPath string
+	var diskSize int64
+	var diskLabel string
+	var kernelCmdline string
+	var reportPath string
+	var timings bool
+	var githubOutputs bool
+	var profile string
+	var variant string
+	var allVariants bool
 
 	cmd := &cobra.Command{
 		Use:   "build",
@@ -72,8 +107,32 @@ command, e.g.
   # docker load < output.tar
 
 Along the image, apko will generate SBOMs (software bill of materials) describing the image contents.
+
+Pass "-" as the configuration file to read it from standard input.
+
+With --output set to a filesystem image format (e.g. squashfs), the root
+filesystem of each architecture is written to the output directory as
+rootfs-<arch>.<format> instead. With --output bootc, the OCI image is laid
+out and labeled for bootc and OSTree hosts: it must be /usr-merged and have a
+kernel, which is moved from /boot to /usr/lib/modules, and composefs is
+enabled.
+
+With --all-variants, each variant of the configuration is built in turn,
+sharing the caches, and written to the output directory as <variant>.tar,
+tagged <tag>-<variant>, with its SBOMs in the <variant> directory of the
+--sbom-path.
 `,
-		Example: `  apko build <config.yaml> <tag> <output.tar|oci-layout-dir/>`,
+		Example: `  apko build <config.yaml> <tag> <output.tar|oci-layout-dir/>
+  generate-config | apko build - <tag> <output.tar|oci-layout-dir/>
+  apko build --output squashfs <config.yaml> <tag> <output-dir/>
+  apko build --output initramfs --initramfs-compression zstd --init /sbin/init <config.yaml> <tag> <output-dir/>
+  apko build --output raw --disk-size 1073741824 --disk-label root <config.yaml> <tag> <output-dir/>
+  apko build --output iso --kernel-cmdline "console=ttyS0 quiet" <config.yaml> <tag> <output-dir/>
+  apko build --output bootc <config.yaml> <tag> <output.tar|oci-layout-dir/>
+  apko build --profile prod <config.yaml> <tag> <output.tar|oci-layout-dir/>
+  apko build --variant debug <config.yaml> <tag> <output.tar|oci-layout-dir/>
+  apko build --all-variants <config.yaml> <tag> <output-dir/>`,
+		ValidArgsFunction: completeConfigFile,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 3 {
 				return fmt.Errorf("requires 3 arg: 1 config file, a tag for the image, and an output path")
@@ -98,48 +157,167 @@ Along the image, apko will generate SBOMs (software bill of materials) describin
 			}
 			defer os.RemoveAll(tmp)
 
-			return BuildCmd(cmd.Context(), args[1], args[2], archs,
-				[]string{args[1]},
-				writeSBOM,
-				sbomPath,
-				build.WithConfig(args[0], includePaths),
+			var bundleOpts []build.Option
+			if bundlePath != "" {
+				b, bundleArchs, err := openBundle(cmd.Context(), bundlePath, filepath.Join(tmp, "bundle"), archstrs)
+				if err != nil {
+					return err
+				}
+				archs = bundleArchs
+				extraKeys = append(slices.Clone(extraKeys), b.Keys...)
+				bundleOpts = b.Options()
+			}
+
+			ctx, finish := startReport(cmd.Context(), reportOutputs{
+				path:    reportPath,
+				summary: timingsWriter(timings),
+				github:  githubOutputs,
+				tags:    []string{args[1]},
+			})
+
+			config := withProfile(withConfig(args[0], includePaths), profile)
+			opts := []build.Option{
 				build.WithBuildDate(buildDate),
-				build.WithSBOM(sbomPath),
+				build.WithSBOM(sbomDir(sbomPath)),
 				build.WithSBOMGenerators(sbomGenerators...),
+				build.WithSBOMFiles(sbomFiles),
+				build.WithSBOMDetectLicenses(sbomDetectLicenses),
+				build.WithSBOMCPEs(sbomCPEs, sbomCPEMapping),
+				build.WithSBOMSigningKey(sbomSigningKey),
+				build.WithSBOMKeyless(sbomKeyless, sbomFulcioURL, sbomRekorURL),
+				build.WithSBOMNameTemplate(sbomNameTemplate),
 				build.WithExtraKeys(extraKeys),
 				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
 				build.WithExtraRepos(extraRepos),
 				build.WithExtraPackages(extraPackages),
 				build.WithTags(args[1]),
 				build.WithVCS(withVCS),
 				build.WithAnnotations(annotations),
+				withAnnotationsAsLabels(cmd, annotationsAsLabels),
 				build.WithCache(cacheDir, offline, apk.NewCache(true)),
+				withLayerCache(cacheDir, layerCacheDir, noLayerCache),
+				withRemoteCache(remoteCache),
+				build.WithStrictReproducibility(strictReproducibility),
+				build.WithBuildInfo(buildInfo),
 				build.WithLockFile(lockfile),
 				build.WithTempDir(tmp),
 				build.WithIncludePaths(includePaths),
 				build.WithIgnoreSignatures(ignoreSignatures),
+				build.WithFIPS(fips),
+				build.WithRepositoryTLS(repositoryTLS...),
+				build.WithPackagePolicy(packagePolicy),
+				build.WithScriptlets(runScriptlets),
+				build.WithNoScripts(noScripts...),
 				build.WithSizeLimits(sizeLimits),
-			)
+			}
+			opts = append(opts, bundleOpts...)
+
+			if allVariants {
+				if output != outputOCI {
+					return fmt.Errorf("--all-variants only builds OCI images")
+				}
+				if sbomPath == sbomStdout {
+					return fmt.Errorf("--all-variants cannot write the SBOMs to stdout")
+				}
+				_, ic, err := build.NewOptions(config)
+				if err != nil {
+					return err
+				}
+				return finish(BuildVariantsCmd(ctx, args[1], args[2], archs, slices.Sorted(maps.Keys(ic.Variants)), writeSBOM, sbomPath, config, opts...))
+			}
+			// The variant is picked before the options changing the configuration.
+			opts = append([]build.Option{config, build.WithVariant(variant)}, opts...)
+
+			switch output {
+			case outputOCI:
+			case outputBootc:
+				opts = append(opts, build.WithBootc(true))
+			default:
+				format, err := fsimage.ParseFormat(output)
+				if err != nil {
+					return err
+				}
+				fsOpts := []fsimage.Option{
+					fsimage.WithCompression(initramfsCompression),
+					fsimage.WithInit(initPath),
+					fsimage.WithSize(diskSize),
+					fsimage.WithLabel(diskLabel),
+					fsimage.WithKernelCmdline(kernelCmdline),
+				}
+				return finish(BuildFSImageCmd(ctx, format, args[2], archs, sbomPath, fsOpts, opts...))
+			}
+
+			return finish(BuildCmd(ctx, args[1], args[2], archs,
+				[]string{args[1]},
+				writeSBOM,
+				sbomPath,
+				opts...,
+			))
 		},
 	}
 
 	cmd.Flags().BoolVar(&withVCS, "vcs", true, "detect and embed VCS URLs")
 	cmd.Flags().StringVar(&buildDate, "build-date", "", "date used for the timestamps of the files inside the image in RFC3339 format")
 	cmd.Flags().BoolVar(&writeSBOM, "sbom", true, "generate SBOMs")
-	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "generate SBOMs in dir (defaults to image directory)")
+	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "generate SBOMs in dir (defaults to image directory), or \"-\" to write the SBOM of a single architecture and format to stdout")
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
 	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", []string{"spdx"}, "SBOM formats to output")
+	cmd.Flags().BoolVar(&sbomFiles, "sbom-files", false, "list the files of the image in the SBOM, with their SHA-1 and SHA-256 checksums and the package that installed them")
+	cmd.Flags().BoolVar(&sbomDetectLicenses, "sbom-detect-licenses", false, "for packages with a missing or UNKNOWN license, record in the SBOM the licenses found in their usr/share/licenses and copyright files")
+	cmd.Flags().BoolVar(&sbomCPEs, "sbom-cpes", false, "add best-effort CPEs to the packages of the SBOM, alongside their purls")
+	cmd.Flags().StringVar(&sbomCPEMapping, "sbom-cpe-mapping", "", "path to a YAML file mapping package names or origins to the vendor and product of their CPEs, used with --sbom-cpes")
+	cmd.Flags().StringVar(&sbomSigningKey, "sbom-signing-key", "", "path to a PEM private key (ECDSA, Ed25519 or RSA), or KMS key URI (awskms://, gcpkms://, azurekms:// or hashivault://), to sign the SBOMs with, writing a detached <sbom>.sig next to each")
+	cmd.Flags().BoolVar(&sbomKeyless, "sbom-keyless", false, "sign the SBOMs keylessly with Sigstore, for the OIDC identity token in $SIGSTORE_ID_TOKEN, writing a <sbom>.sigstore.json bundle next to each")
+	cmd.Flags().StringVar(&sbomFulcioURL, "sbom-fulcio-url", sbom.DefaultFulcioURL, "Fulcio instance certifying the keyless SBOM signatures, used with --sbom-keyless")
+	cmd.Flags().StringVar(&sbomRekorURL, "sbom-rekor-url", sbom.DefaultRekorURL, "Rekor instance logging the keyless SBOM signatures, used with --sbom-keyless; empty to not log them")
+	cmd.Flags().StringVar(&sbomNameTemplate, "sbom-name-template", "", "Go template for the SBOM file names, of {{.Arch}} (\"index\" for the index), {{.Digest}}, {{.Format}} and {{.Ext}} (default sbom-{{.Arch}}.{{.Ext}})")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
 	cmd.Flags().StringSliceVar(&rawAnnotations, "annotations", []string{}, "OCI annotations to add. Separate with colon (key:value)")
+	cmd.Flags().BoolVar(&annotationsAsLabels, "annotations-as-labels", true, "also set the annotations as labels in the image configs (overrides annotations-as-labels of the config)")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
-	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	cmd.Flags().StringVar(&layerCacheDir, "layer-cache-dir", "", "directory to cache built layers in, so rebuilding an image whose packages and configuration have not changed skips installation and compression (default '' means the layers directory of the apk cache directory)")
+	cmd.Flags().BoolVar(&noLayerCache, "no-layer-cache", false, "do not cache built layers")
+	cmd.Flags().StringVar(&remoteCache, "remote-cache", "", "share downloaded apk packages and indexes through a remote cache: s3://bucket/prefix, gs://bucket/prefix or oci://registry/repository")
+	cmd.Flags().BoolVar(&strictReproducibility, "strict-reproducibility", false, "fail the build on input that would make it nondeterministic, such as files with modification times newer than the build date")
+	cmd.Flags().BoolVar(&buildInfo, "build-info", false, "embed a build-info document (apko version and the digests of the config, lockfile and repository indexes) in each image as a label and annotation")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use the network to fetch packages, indexes, keys, advisory feeds or base images, which must all come from the cache or local files")
 	cmd.Flags().StringVar(&lockfile, "lockfile", "", "a path to .lock.json file (e.g. produced by apko lock) that constraints versions of packages to the listed ones (default '' means no additional constraints)")
+	cmd.Flags().StringVar(&bundlePath, "bundle", "", "build offline from a bundle written by apko bundle create, installing its packages as locked after verifying them against the checksums of its lock, for the architectures of the bundle")
 	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append.")
 	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().BoolVar(&fips, "fips", false, "restrict TLS and repository signature verification to FIPS-approved algorithms, failing on repositories only signed with non-approved ones")
+	addRepositoryTLSFlag(cmd, &repositoryTLS)
+	cmd.Flags().StringVar(&packagePolicy, "package-policy", "", "path to a YAML policy file with allow and deny rules (name, origin, repository), license rules, security advisory feeds and scriptlet rules for the resolved packages; the build fails on any package that is not allowed")
+	cmd.Flags().StringVar(&runScriptlets, "run-scriptlets", "", "run the install scriptlets and triggers of the packages in a sandbox without network: bubblewrap or chroot (which requires root); by default scriptlets are not run")
+	cmd.Flags().StringSliceVar(&noScripts, "no-scripts", []string{}, "packages whose install scriptlets are not run with --run-scriptlets, in addition to the no-scripts packages of the configuration (\"*\" skips every package)")
+	cmd.Flags().StringVar(&output, "output", outputOCI, fmt.Sprintf("format to write the image as: %q, %q for an OCI image for bootc and OSTree hosts, or a filesystem image format of the root filesystem (%v)", outputOCI, outputBootc, fsimage.Formats()))
+	completeFlag(cmd, "output", cobra.FixedCompletions(append([]string{outputOCI, outputBootc}, formatNames(fsimage.Formats())...), cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&initramfsCompression, "initramfs-compression", fsimage.CompressionGzip, "compression of initramfs output: gzip, zstd or none")
+	cmd.Flags().StringVar(&initPath, "init", "", "for initramfs output, the program to link /init to (defaults to the /init of the image)")
+	cmd.Flags().Int64Var(&diskSize, "disk-size", 0, "for ext4 and raw output, the size of the image in bytes (default 0 means sized to fit the root filesystem)")
+	cmd.Flags().StringVar(&diskLabel, "disk-label", "", "for ext4 and raw output, the filesystem label and partition name (defaults to rootfs); for iso output, the volume ID (defaults to ROOTFS)")
+	cmd.Flags().StringVar(&kernelCmdline, "kernel-cmdline", "", "for iso output, the kernel command line to boot with (defaults to \"console=tty0 console=ttyS0\")")
+	cmd.Flags().StringVar(&reportPath, "report", "", "write a JSON report of the build (image, layer and package details, timings, cache statistics and warnings) to this file")
+	cmd.Flags().BoolVar(&timings, "timings", false, "print a summary of the time spent in each phase of the build (resolve, download, extract, tar, compress, sbom) to stderr when it finishes")
+	cmd.Flags().BoolVar(&githubOutputs, "github-outputs", false, "when running in GitHub Actions, write the image digests, tags and installed packages as step outputs to $GITHUB_OUTPUT and a summary of the build to $GITHUB_STEP_SUMMARY")
+	cmd.Flags().StringVar(&profile, "profile", "", "apply the named profile of the configuration (see profiles in the configuration), overriding its repositories, packages and environment")
+	cmd.Flags().StringVar(&variant, "variant", "", "build the named variant of the configuration (see variants in the configuration) instead of the configuration itself")
+	cmd.Flags().BoolVar(&allVariants, "all-variants", false, "build every variant of the configuration, into the output directory, instead of the configuration itself")
 	addClientLimitFlags(cmd, &sizeLimits)
+	cmd.MarkFlagsMutuallyExclusive("variant", "all-variants")
+	cmd.MarkFlagsMutuallyExclusive("all-variants", "report")
+	cmd.MarkFlagsMutuallyExclusive("all-variants", "github-outputs")
+	cmd.MarkFlagsMutuallyExclusive("layer-cache-dir", "no-layer-cache")
+	cmd.MarkFlagsMutuallyExclusive("offline", "remote-cache")
+	cmd.MarkFlagsMutuallyExclusive("bundle", "lockfile")
+	cmd.MarkFlagsMutuallyExclusive("bundle", "cache-dir")
+	cmd.MarkFlagsMutuallyExclusive("bundle", "remote-cache")
+
 	return cmd
 }
 
@@ -172,10 +350,32 @@ func BuildCmd(ctx context.Context, imageRef, output string, archs []types.Archit
 	}
 
 	// copy sboms over to the sbomPath target directory
-	for _, sbom := range sboms {
-		// because os.Rename fails across partitions, we do our own
-		if err := rename(sbom.Path, filepath.Join(sbomPath, filepath.Base(sbom.Path))); err != nil {
-			return fmt.Errorf("moving sbom: %w", err)
+	return moveSBOMs(sboms, sbomPath)
+}
+
+// BuildVariantsCmd builds each of the variants of the image configuration set
+// by config in turn, writing variant v to outputDir as <v>.tar, tagged
+// <imageRef>-<v>, and its SBOMs to the v directory of sbomPath.
+func BuildVariantsCmd(ctx context.Context, imageRef, outputDir string, archs []types.Architecture, variants []string, wantSBOM bool, sbomPath string, config build.Option, opts ...build.Option) error {
+	log := clog.FromContext(ctx)
+	if len(variants) == 0 {
+		return fmt.Errorf("the configuration has no variants")
+	}
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+	for _, v := range variants {
+		log.Infof("building variant %s", v)
+		tag := imageRef + "-" + v
+		dir := filepath.Join(sbomPath, v)
+		if wantSBOM {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fmt.Errorf("creating sbom directory: %w", err)
+			}
+		}
+		vopts := slices.Concat([]build.Option{config, build.WithVariant(v)}, opts, []build.Option{build.WithTags(tag)})
+		if err := BuildCmd(ctx, tag, filepath.Join(outputDir, v+".tar"), archs, []string{tag}, wantSBOM, dir, vopts...); err != nil {
+			return fmt.Errorf("building variant %q: %w", v, err)
 		}
 	}
 	return nil
@@ -184,169 +384,70 @@ func BuildCmd(ctx context.Context, imageRef, output string, archs []types.Archit
 // buildImage build all of the components of an image in a single working directory.
 // Each layer is a separate file, as are config, manifests, index and sbom.
 func buildImageComponents(ctx context.Context, workDir string, archs []types.Architecture, opts ...build.Option) (idx v1.ImageIndex, sboms []types.SBOM, err error) {
-	log := clog.FromContext(ctx)
-	ctx, span := otel.Tracer("apko").Start(ctx, "buildImageComponents")
-	defer span.End()
-
-	o, ic, err := build.NewOptions(opts...)
+	b, err := apko.New(ctx, apko.WithWorkDir(workDir), apko.WithArchs(archs...), apko.WithBuildOptions(opts...))
 	if err != nil {
 		return nil, nil, err
 	}
-
-	if ic.Contents.BaseImage != nil && o.Lockfile == "" {
-		return nil, nil, fmt.Errorf("building with base image is supported only with a lockfile")
+	res, err := b.Build(ctx)
+	if err != nil {
+		return nil, nil, err
 	}
+	return res.Index, res.SBOMs, nil
+}
 
-	// cases:
-	// - archs set: use those archs
-	// - archs not set, bc.ImageConfiguration.Archs set: use Config archs
-	// - archs not set, bc.ImageConfiguration.Archs not set: use all archs
-	switch {
-	case len(archs) != 0:
-		ic.Archs = archs
-	case len(ic.Archs) != 0:
-		// do nothing
-	default:
-		ic.Archs = types.AllArchs
-	}
-	// save the final set we will build
-	log.Debugf("Building images for %d architectures: %+v", len(ic.Archs), ic.Archs)
+// sbomStdout is the --sbom-path writing the SBOM to stdout.
+const sbomStdout = "-"
 
-	// Probe the VCS URL if it is not set and we are asked to do so.
-	if o.WithVCS && ic.VCSUrl == "" {
-		ic.ProbeVCSUrl(ctx, o.ImageConfigFile)
+// sbomDir returns the directory to generate SBOMs in for sbomPath.
+func sbomDir(sbomPath string) string {
+	if sbomPath == sbomStdout {
+		return ""
 	}
+	return sbomPath
+}
 
-	// The build context options is sometimes copied in the next functions. Ensure
-	// we have the directory defined and created by invoking the function early.
-
-	// workDir, passed to us, is where we will lay out the various image filesystems
-	// under it we will have:
-	//  <arch>/ - the rootfs for each architecture
-	//  image/ - the summary layer files and sboms for each architecture
-	// imageDir, created here, is where the final artifacts will be: layer tars, indexes, etc.
-
-	log.Debugf("building tags %v", o.Tags)
-
-	var errg errgroup.Group
-	imageDir := filepath.Join(workDir, "image")
-	if err := os.MkdirAll(imageDir, 0755); err != nil {
-		return nil, nil, fmt.Errorf("unable to create working image directory %s: %w", imageDir, err)
+// moveSBOMs moves the sboms, and their signatures if any, to dir, or writes
+// the SBOM to stdout if dir is "-".
+func moveSBOMs(sboms []types.SBOM, dir string) error {
+	if dir == sbomStdout {
+		return writeSBOM(os.Stdout, sboms)
 	}
-	opts = append(opts, build.WithSBOM(imageDir))
-
-	imgs := map[types.Architecture]v1.Image{}
-
-	mtx := sync.Mutex{}
-
-	// We compute the "build date epoch" of the multi-arch image to be the
-	// maximum "build date epoch" of the per-arch images.  If the user has
-	// explicitly set SOURCE_DATE_EPOCH, that will always trump this
-	// computation.
-	multiArchBDE := o.SourceDateEpoch
-
-	configs, _, err := build.LockImageConfiguration(ctx, *ic, opts...)
-	if err != nil {
-		return nil, nil, fmt.Errorf("locking config: %w", err)
+	for _, sbom := range sboms {
+		// because os.Rename fails across partitions, we do our own
+		if err := rename(sbom.Path, filepath.Join(dir, filepath.Base(sbom.Path))); err != nil {
+			return fmt.Errorf("moving sbom: %w", err)
+		}
+		if sbom.SignaturePath == "" {
+			continue
+		}
+		if err := rename(sbom.SignaturePath, filepath.Join(dir, filepath.Base(sbom.SignaturePath))); err != nil {
+			return fmt.Errorf("moving sbom signature: %w", err)
+		}
 	}
+	return nil
+}
 
-	for arch, ic := range configs {
-		errg.Go(func() error {
-			if arch == "index" {
-				return nil
-			}
-
-			arch := types.ParseArchitecture(arch)
-			log := log.With("arch", arch.ToAPK())
-			ctx := clog.WithLogger(ctx, log)
-
-			opts := slices.Clone(opts)
-			opts = append(opts, build.WithArch(arch), build.WithImageConfiguration(*ic))
-
-			bc, err := build.New(ctx, tarfs.New(), opts...)
-			if err != nil {
-				return fmt.Errorf("new build for arch %s: %w", arch, err)
-			}
-			layers, err := bc.BuildLayers(ctx)
-			if err != nil {
-				return fmt.Errorf("building %q layer: %w", arch, err)
-			}
-
-			// Compute the "build date epoch" from the packages that were
-			// installed.  The "build date epoch" is the MAX of the builddate
-			// embedded in the installed APKs.  If SOURCE_DATE_EPOCH is
-			// explicitly set by the user, that trumps this.
-			// This computation will only affect the timestamp of the image
-			// itself and its SBOMs, since the timestamps on files come from the
-			// APKs.
-			bde, err := bc.GetBuildDateEpoch()
-			if err != nil {
-				return fmt.Errorf("failed to determine build date epoch: %w", err)
-			}
-
-			img, err := oci.BuildImageFromLayers(ctx, bc.BaseImage(), layers, bc.ImageConfiguration(), bde, bc.Arch())
-			if err != nil {
-				return fmt.Errorf("failed to build OCI image for %q: %w", arch, err)
-			}
-
-			var outputs []types.SBOM
-			if len(o.SBOMGenerators) != 0 {
-				outputs, err = bc.GenerateImageSBOM(ctx, arch, img)
-				if err != nil {
-					return fmt.Errorf("generating sbom for %s: %w", arch, err)
-				}
-			}
-
-			mtx.Lock()
-			defer mtx.Unlock()
-
-			imgs[arch] = img
-
-			if bde.After(multiArchBDE) {
-				multiArchBDE = bde
-			}
-
-			if len(o.SBOMGenerators) != 0 {
-				sboms = append(sboms, outputs...)
-			}
-
-			return nil
-		})
-	}
-	if err := errg.Wait(); err != nil {
-		return nil, nil, err
+// writeSBOM writes the only image SBOM of sboms to w. The index SBOM, which
+// only refers to the image SBOMs, is not written.
+func writeSBOM(w io.Writer, sboms []types.SBOM) error {
+	var images []types.SBOM
+	for _, sbom := range sboms {
+		if sbom.Arch != "" {
+			images = append(images, sbom)
+		}
 	}
-
-	// generate the index
-	finalDigest, idx, err := oci.GenerateIndex(ctx, *ic, imgs, multiArchBDE)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to generate OCI index: %w", err)
+	if len(images) != 1 {
+		return fmt.Errorf("writing the SBOM to stdout needs a single architecture and SBOM format, but there are %d SBOMs", len(images))
 	}
-
-	opts = append(opts,
-		build.WithImageConfiguration(*ic),       // We mutate Archs above.
-		build.WithSourceDateEpoch(multiArchBDE), // Maximum child's time.
-	)
-
-	o, ic, err = build.NewOptions(opts...)
+	f, err := os.Open(images[0].Path)
 	if err != nil {
-		return nil, nil, err
-	}
-
-	if _, err := build.WriteIndex(ctx, o, idx); err != nil {
-		return nil, nil, fmt.Errorf("failed to write OCI index: %w", err)
+		return fmt.Errorf("opening sbom: %w", err)
 	}
-
-	// the sboms are saved to the same working directory as the image components
-	if len(o.SBOMGenerators) != 0 {
-		files, err := build.GenerateIndexSBOM(ctx, *o, *ic, finalDigest, imgs)
-		if err != nil {
-			return nil, nil, fmt.Errorf("generating index SBOM: %w", err)
-		}
-		sboms = append(sboms, files...)
+	defer f.Close()
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("writing sbom: %w", err)
 	}
-
-	return idx, sboms, nil
+	return nil
 }
 
 // rename just like os.Rename, but does a copy and delete if the rename fails
@@ -375,3 +476,68 @@ func rename(from, to string) error {
 	}
 	return os.Remove(from)
 }
+
+// withAnnotationsAsLabels overrides whether the annotations are mirrored into
+// labels only when the flag was set, leaving the configuration's choice alone
+// otherwise.
+func withAnnotationsAsLabels(cmd *cobra.Command, enabled bool) build.Option {
+	if !cmd.Flags().Changed("annotations-as-labels") {
+		return func(*build.Context) error { return nil }
+	}
+	return build.WithAnnotationsAsLabels(enabled)
+}
+
+// withLayerCache caches built layers in layerCacheDir, or by default in the
+// layers directory of the apk cache directory, unless disabled.
+func withLayerCache(cacheDir, layerCacheDir string, disabled bool) build.Option {
+	if disabled {
+		return build.WithLayerCache("")
+	}
+	if layerCacheDir == "" {
+		if cacheDir == "" {
+			dir, err := os.UserCacheDir()
+			if err != nil {
+				// The apk cache is disabled as well.
+				return build.WithLayerCache("")
+			}
+			cacheDir = filepath.Join(dir, "dev.chainguard.go-apk")
+		}
+		layerCacheDir = filepath.Join(cacheDir, "layers")
+	}
+	return build.WithLayerCache(layerCacheDir)
+}
+
+// withRemoteCache shares downloaded packages and indexes through the remote
+// cache of uri, if it is set.
+func withRemoteCache(uri string) build.Option {
+	if uri == "" {
+		return func(*build.Context) error { return nil }
+	}
+	return func(bc *build.Context) error {
+		rc, err := remotecache.New(uri)
+		if err != nil {
+			return err
+		}
+		return build.WithRemoteCache(rc)(bc)
+	}
+}
+
+// withProfile applies the profile called name to the image configuration
+// loaded by config.
+func withProfile(config build.Option, name string) build.Option {
+	return func(bc *build.Context) error {
+		if err := config(bc); err != nil {
+			return err
+		}
+		return build.WithProfile(name)(bc)
+	}
+}
+
+// withConfig loads the image configuration from configFile, or from stdin
+// when configFile is "-".
+func withConfig(configFile string, includePaths []string) build.Option {
+	if configFile == "-" {
+		return build.WithConfigReader(os.Stdin, includePaths)
+	}
+	return build.WithConfig(configFile, includePaths)
+}
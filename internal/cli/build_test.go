@@ -15,21 +15,33 @@
 package cli_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"io"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/validate"
 	"github.com/stretchr/testify/require"
 
 	"chainguard.dev/apko/internal/cli"
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/baseimg"
 	"chainguard.dev/apko/pkg/build"
 	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/report"
 	"chainguard.dev/apko/pkg/sbom/generator/spdx"
 )
 
@@ -113,11 +125,418 @@ func TestBuild(t *testing.T) {
 	}
 }
 
+func TestBuildWithBuildInfo(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithLockFile(filepath.Join("testdata", "apko.lock.json")),
+		build.WithBuildInfo(true),
+	}
+	require.NoError(t, cli.BuildCmd(ctx, "buildinfo:latest", tmp, archs, []string{}, false, "", opts...))
+
+	idx, err := layout.ImageIndexFromPath(tmp)
+	require.NoError(t, err)
+	m, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, m.Manifests, 2)
+
+	for _, desc := range m.Manifests {
+		img, err := idx.Image(desc.Digest)
+		require.NoError(t, err)
+		cfg, err := img.ConfigFile()
+		require.NoError(t, err)
+		manifest, err := img.Manifest()
+		require.NoError(t, err)
+
+		label := cfg.Config.Labels[build.AnnotationBuildInfo]
+		require.NotEmpty(t, label, "missing build info label for %s", desc.Platform)
+		require.Equal(t, label, manifest.Annotations[build.AnnotationBuildInfo])
+		bi, err := build.ParseBuildInfo(label)
+		require.NoError(t, err)
+		require.NotEmpty(t, bi.Lockfile)
+		require.NotEmpty(t, bi.Config)
+	}
+}
+
+func TestBuildWithReport(t *testing.T) {
+	tmp := t.TempDir()
+	cacheDir := t.TempDir()
+	layerCacheDir := t.TempDir()
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithLockFile(filepath.Join("testdata", "apko.lock.json")),
+		build.WithCache(cacheDir, false, apk.NewCache(true)),
+		build.WithLayerCache(layerCacheDir),
+	}
+
+	// The second build finds the layers of both architectures in the layer cache.
+	for _, want := range []report.CacheStats{{Misses: 2}, {Hits: 2}} {
+		c := report.New()
+		ctx := report.WithCollector(context.Background(), c)
+		require.NoError(t, cli.BuildCmd(ctx, "report:latest", tmp, archs, []string{}, false, "", opts...))
+		r := c.Report()
+
+		idx, err := layout.ImageIndexFromPath(tmp)
+		require.NoError(t, err)
+		m, err := idx.IndexManifest()
+		require.NoError(t, err)
+		digests := map[string]string{}
+		for _, desc := range m.Manifests {
+			digests[desc.Platform.String()] = desc.Digest.String()
+		}
+		require.Len(t, r.Images, 2)
+		for _, img := range r.Images {
+			require.Equal(t, digests[img.Platform], img.Digest)
+			require.NotEmpty(t, img.Layers)
+			var names []string
+			for _, pkg := range img.Packages {
+				require.NotEmpty(t, pkg.Version)
+				names = append(names, pkg.Name)
+			}
+			require.ElementsMatch(t, []string{"pretend-baselayout", "replayout"}, names)
+		}
+		require.Equal(t, want, r.Cache[report.CacheLayers])
+
+		var phases []string
+		for _, timing := range r.Timings {
+			phases = append(phases, timing.Phase+"/"+timing.Arch)
+		}
+		require.Subset(t, phases, []string{"build/x86_64", "compress/x86_64", "build/aarch64", "compress/aarch64"})
+		if want.Misses != 0 {
+			require.Subset(t, phases, []string{"tar/x86_64", "tar/aarch64"})
+		}
+	}
+}
+
+func TestBuildWithPackagePolicy(t *testing.T) {
+	dir := t.TempDir()
+	allow := filepath.Join(dir, "allow.yaml")
+	require.NoError(t, os.WriteFile(allow, []byte("allow:\n  - repository: '*testdata/packages'\n"), 0o644))
+	deny := filepath.Join(dir, "deny.yaml")
+	require.NoError(t, os.WriteFile(deny, []byte("deny:\n  - name: pretend-*\n"), 0o644))
+
+	archs := types.ParseArchitectures([]string{"amd64"})
+	for _, lockfile := range []string{"", filepath.Join("testdata", "apko.lock.json")} {
+		t.Run("lockfile="+lockfile, func(t *testing.T) {
+			opts := []build.Option{
+				build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+				build.WithLockFile(lockfile),
+			}
+
+			err := cli.BuildCmd(context.Background(), "policy:latest", t.TempDir(), archs, []string{}, false, "",
+				append(opts, build.WithPackagePolicy(allow))...)
+			require.NoError(t, err)
+
+			err = cli.BuildCmd(context.Background(), "policy:latest", t.TempDir(), archs, []string{}, false, "",
+				append(opts, build.WithPackagePolicy(deny))...)
+			require.ErrorContains(t, err, "1 package(s) not allowed by the package policy:\n  pretend-baselayout-1.0.0-r0: denied by name=pretend-* (replayout -> pretend-baselayout)")
+		})
+	}
+}
+
+func TestBuildWithLicensePolicy(t *testing.T) {
+	dir := t.TempDir()
+	allow := filepath.Join(dir, "allow.yaml")
+	require.NoError(t, os.WriteFile(allow, []byte("licenses:\n  allow: [MIT]\n"), 0o644))
+	deny := filepath.Join(dir, "deny.yaml")
+	require.NoError(t, os.WriteFile(deny, []byte("licenses:\n  deny: [mit]\n"), 0o644))
+	warn := filepath.Join(dir, "warn.yaml")
+	require.NoError(t, os.WriteFile(warn, []byte("licenses:\n  deny: [MIT]\n  warn: true\n"), 0o644))
+
+	archs := types.ParseArchitectures([]string{"amd64"})
+	opts := []build.Option{build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{})}
+	buildWith := func(policy string) error {
+		return cli.BuildCmd(context.Background(), "licenses:latest", t.TempDir(), archs, []string{}, false, "",
+			append(opts, build.WithPackagePolicy(policy))...)
+	}
+
+	require.NoError(t, buildWith(allow))
+	require.ErrorContains(t, buildWith(deny), "2 package(s) not allowed by the package policy:\n  pretend-baselayout-1.0.0-r0: license MIT is denied by mit (replayout -> pretend-baselayout)")
+	require.NoError(t, buildWith(warn))
+}
+
+func TestBuildWithAdvisories(t *testing.T) {
+	dir := t.TempDir()
+	feed := filepath.Join(dir, "security.json")
+	policy := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(policy, []byte("advisories:\n  feeds: ["+feed+"]\n"), 0o644))
+	writeFeed := func(fixed string) {
+		require.NoError(t, os.WriteFile(feed, []byte(`{"packages": [{"pkg": {"name": "pretend-baselayout", "secfixes": {"`+fixed+`": ["CVE-2025-0001"]}}}]}`), 0o644))
+	}
+
+	archs := types.ParseArchitectures([]string{"amd64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithPackagePolicy(policy),
+		build.WithLayerCache(t.TempDir()),
+	}
+	buildImage := func() error {
+		return cli.BuildCmd(context.Background(), "advisories:latest", t.TempDir(), archs, []string{}, false, "", opts...)
+	}
+
+	writeFeed("1.0.0-r0")
+	require.NoError(t, buildImage())
+
+	// The cached layers are checked against the updated feed as well.
+	writeFeed("1.0.0-r1")
+	require.ErrorContains(t, buildImage(), "pretend-baselayout-1.0.0-r0: vulnerable to CVE-2025-0001 fixed in 1.0.0-r1 (replayout -> pretend-baselayout)")
+}
+
+func TestBuildWithMelangeWorkspace(t *testing.T) {
+	// The configuration has neither repositories nor keys: both come from the
+	// workspace, where melange wrote the test packages.
+	config := filepath.Join(t.TempDir(), "apko.yaml")
+	require.NoError(t, os.WriteFile(config, []byte("contents:\n  packages: [replayout]\n"), 0o644))
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(config, []string{}),
+		build.WithMelangeWorkspace("testdata"),
+	}
+	require.NoError(t, cli.BuildCmd(context.Background(), "melange:latest", t.TempDir(), archs, []string{}, false, "", opts...))
+
+	err := cli.BuildCmd(context.Background(), "melange:latest", t.TempDir(), archs, []string{}, false, "",
+		append(opts, build.WithMelangeWorkspace(filepath.Join("testdata", "foo")))...)
+	require.ErrorContains(t, err, "melange workspace")
+}
+
+func TestBuildWithoutAPKDatabase(t *testing.T) {
+	var ic types.ImageConfiguration
+	require.NoError(t, ic.Load(context.Background(), filepath.Join("testdata", "apko.yaml"), []string{}, sha256.New())) //nolint:staticcheck
+	ic.Contents.OmitAPKDatabase = true
+
+	tmp := t.TempDir()
+	sbomPath := t.TempDir()
+	archs := types.ParseArchitectures([]string{"amd64"})
+	opts := []build.Option{
+		build.WithImageConfiguration(ic),
+		build.WithSBOMGenerators(spdx.New()),
+		build.WithLayerCache(t.TempDir()),
+	}
+
+	// The second build restores the apk database from the layer cache.
+	for range 2 {
+		require.NoError(t, cli.BuildCmd(context.Background(), "nodb:latest", tmp, archs, []string{}, true, sbomPath, opts...))
+
+		files := layerFiles(t, tmp)
+		require.Contains(t, files, "etc/apko.json")
+		for name := range files {
+			require.False(t, strings.HasPrefix(name, "etc/apk/") || name == "etc/apk", name)
+			require.False(t, strings.HasPrefix(name, "usr/lib/apk/db"), name)
+		}
+
+		data, err := os.ReadFile(filepath.Join(sbomPath, "sbom-x86_64.spdx.json"))
+		require.NoError(t, err)
+		require.Contains(t, string(data), `"name": "replayout"`)
+	}
+
+	ic.Contents.BaseImage = &types.BaseImageDescriptor{Image: filepath.Join("testdata", "base_image")}
+	require.ErrorContains(t, ic.Validate(), "omit_apk_database is unsupported with baseimage")
+}
+
+func TestBuildVariants(t *testing.T) {
+	var ic types.ImageConfiguration
+	require.NoError(t, ic.Load(context.Background(), filepath.Join("testdata", "apko.yaml"), []string{}, sha256.New())) //nolint:staticcheck
+	ic.Variants = map[string]types.ImageConfiguration{
+		"debug": {Environment: map[string]string{"DEBUG": "1"}},
+		"prod":  {},
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	sbomPath := t.TempDir()
+	archs := types.ParseArchitectures([]string{"amd64"})
+	opts := []build.Option{
+		build.WithSBOMGenerators(spdx.New()),
+		build.WithSBOM(sbomPath),
+	}
+	require.NoError(t, cli.BuildVariantsCmd(context.Background(), "variants:latest", out, archs, []string{"debug", "prod"}, true, sbomPath, build.WithImageConfiguration(ic), opts...))
+
+	for _, v := range []string{"debug", "prod"} {
+		_, err := os.Stat(filepath.Join(out, v+".tar"))
+		require.NoError(t, err, v)
+		_, err = os.Stat(filepath.Join(sbomPath, v, "sbom-x86_64.spdx.json"))
+		require.NoError(t, err, v)
+	}
+
+	err := cli.BuildVariantsCmd(context.Background(), "variants:latest", out, archs, []string{"test"}, false, "", build.WithImageConfiguration(ic), opts...)
+	require.ErrorContains(t, err, `unknown variant "test"`)
+}
+
+func TestBuildSBOMNameTemplate(t *testing.T) {
+	ctx := context.Background()
+	sbomPath := t.TempDir()
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithSBOMGenerators(spdx.New()),
+		build.WithSBOMNameTemplate("{{.Arch}}.{{.Ext}}"),
+	}
+	// The index SBOM checksums the image SBOMs by their templated names.
+	require.NoError(t, cli.BuildCmd(ctx, "names:latest", t.TempDir(), archs, []string{}, true, sbomPath, opts...))
+
+	entries, err := os.ReadDir(sbomPath)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.Equal(t, []string{"aarch64.spdx.json", "index.spdx.json", "x86_64.spdx.json"}, names)
+}
+
+func TestBuildWithRuntimeRepositories(t *testing.T) {
+	var ic types.ImageConfiguration
+	require.NoError(t, ic.Load(context.Background(), filepath.Join("testdata", "apko.yaml"), []string{}, sha256.New())) //nolint:staticcheck
+	no := false
+	ic.Contents.Runtime = &types.ImageRuntime{
+		Keys:          &no,
+		Substitutions: map[string]string{"./testdata/packages": "https://packages.example.com/os"},
+	}
+
+	tmp := t.TempDir()
+	archs := types.ParseArchitectures([]string{"amd64"})
+	require.NoError(t, cli.BuildCmd(context.Background(), "runtime:latest", tmp, archs, []string{}, false, "", build.WithImageConfiguration(ic)))
+	files := layerFiles(t, tmp)
+	require.Equal(t, "https://packages.example.com/os\n", string(files["etc/apk/repositories"]))
+	for name := range files {
+		require.False(t, strings.HasPrefix(name, "etc/apk/keys"), name)
+	}
+
+	ic.Contents.Runtime = &types.ImageRuntime{Repositories: &no}
+	tmp = t.TempDir()
+	require.NoError(t, cli.BuildCmd(context.Background(), "runtime:latest", tmp, archs, []string{}, false, "", build.WithImageConfiguration(ic)))
+	files = layerFiles(t, tmp)
+	require.NotContains(t, files, "etc/apk/repositories")
+	require.Contains(t, files, "etc/apk/keys/melange.rsa.pub")
+}
+
+// layerFiles returns the contents of the files in the first layer of the first
+// image of the OCI layout in dir, by path.
+func layerFiles(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	idx, err := layout.ImageIndexFromPath(dir)
+	require.NoError(t, err)
+	m, err := idx.IndexManifest()
+	require.NoError(t, err)
+	img, err := idx.Image(m.Manifests[0].Digest)
+	require.NoError(t, err)
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	rc, err := layers[0].Uncompressed()
+	require.NoError(t, err)
+	defer rc.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = b
+	}
+}
+
+func TestBuildWithRemoteBase(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	cacheDir := t.TempDir()
+
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+
+	base, err := layout.ImageIndexFromPath(filepath.Join("testdata", "base_image"))
+	require.NoError(t, err)
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/base:latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(ref, base))
+
+	var ic types.ImageConfiguration
+	require.NoError(t, ic.Load(ctx, filepath.Join("testdata", "image_on_top.apko.yaml"), []string{}, sha256.New())) //nolint:staticcheck
+	ic.Contents.BaseImage = &types.BaseImageDescriptor{Image: ref.String()}
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithImageConfiguration(ic),
+		build.WithTags("remote_top:latest"),
+		build.WithLockFile(filepath.Join("testdata", "image_on_top.apko.lock.json")),
+		build.WithTempDir(t.TempDir()),
+		build.WithCache(cacheDir, false, nil),
+	}
+	require.NoError(t, cli.BuildCmd(ctx, "remote_top:latest", tmp, archs, []string{}, false, "", opts...))
+
+	root, err := layout.ImageIndexFromPath(tmp)
+	require.NoError(t, err)
+	m, err := root.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, m.Manifests, 2)
+
+	for _, desc := range m.Manifests {
+		img, err := root.Image(desc.Digest)
+		require.NoError(t, err)
+		layers, err := img.Layers()
+		require.NoError(t, err)
+
+		baseImg, err := remote.Image(ref, remote.WithPlatform(*desc.Platform))
+		require.NoError(t, err)
+		baseLayers, err := baseImg.Layers()
+		require.NoError(t, err)
+
+		// The base layers come first, followed by the apko layer.
+		require.Len(t, layers, len(baseLayers)+1)
+		for i := range baseLayers {
+			want, err := baseLayers[i].Digest()
+			require.NoError(t, err)
+			got, err := layers[i].Digest()
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		}
+	}
+
+	cached, err := os.ReadDir(filepath.Join(cacheDir, "baseimage"))
+	require.NoError(t, err)
+	require.NotEmpty(t, cached)
+}
+
+func TestBuildWithRemoteBaseMissingPlatform(t *testing.T) {
+	ctx := context.Background()
+
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+
+	base, err := layout.ImageIndexFromPath(filepath.Join("testdata", "base_image"))
+	require.NoError(t, err)
+	ref, err := name.ParseReference(strings.TrimPrefix(s.URL, "http://") + "/base:latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(ref, base))
+
+	var ic types.ImageConfiguration
+	require.NoError(t, ic.Load(ctx, filepath.Join("testdata", "image_on_top.apko.yaml"), []string{}, sha256.New())) //nolint:staticcheck
+	ic.Contents.BaseImage = &types.BaseImageDescriptor{Image: ref.String()}
+
+	// The base image only has amd64 and arm64 images.
+	archs := types.ParseArchitectures([]string{"armv7"})
+	opts := []build.Option{
+		build.WithImageConfiguration(ic),
+		build.WithLockFile(filepath.Join("testdata", "image_on_top.apko.lock.json")),
+		build.WithTempDir(t.TempDir()),
+	}
+	err = cli.BuildCmd(ctx, "remote_top:latest", t.TempDir(), archs, []string{}, false, "", opts...)
+	var pnf *baseimg.PlatformNotFoundError
+	require.ErrorAs(t, err, &pnf)
+	require.ErrorContains(t, err, "no image for platform linux/arm/v7 (available platforms: linux/amd64, linux/arm64)")
+}
+
 func TestBuildWithBase(t *testing.T) {
 	// top_image golden file can be regenerated using ./internal/cli/testdata/regenerate_golden_top_image.sh script.
 
-	// TODO(sfc-gh-mhazy) Check sboms after base image support is reflected in them.
-
 	ctx := context.Background()
 	tmp := t.TempDir()
 	apkoTempDir := t.TempDir()
@@ -154,4 +573,30 @@ func TestBuildWithBase(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, want, got)
+
+	// The base image SBOM is merged into the image SBOM.
+	for _, arch := range []string{"x86_64", "aarch64"} {
+		data, err := os.ReadFile(filepath.Join(sbomPath, "sbom-"+arch+".spdx.json"))
+		require.NoError(t, err)
+		var doc struct {
+			Packages []struct {
+				Name string `json:"name"`
+			} `json:"packages"`
+			Relationships []struct {
+				Type string `json:"relationshipType"`
+			} `json:"relationships"`
+		}
+		require.NoError(t, json.Unmarshal(data, &doc))
+
+		var names, relationships []string
+		for _, p := range doc.Packages {
+			names = append(names, p.Name)
+		}
+		for _, r := range doc.Relationships {
+			relationships = append(relationships, r.Type)
+		}
+		require.Contains(t, names, "package-x", arch)
+		require.Contains(t, names, "replayout", arch)
+		require.Contains(t, relationships, "DESCENDANT_OF", arch)
+	}
 }
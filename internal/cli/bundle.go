@@ -0,0 +1,108 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/bundle"
+)
+
+func bundleCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Create bundles for building images in airgapped environments",
+	}
+	cmd.AddCommand(bundleCreateCmd())
+	return cmd
+}
+
+func bundleCreateCmd() *cobra.Command {
+	var extraKeys []string
+	var extraBuildRepos []string
+	var extraRepos []string
+	var archstrs []string
+	var includePaths []string
+	var ignoreSignatures bool
+	var fips bool
+	var repositoryTLS repositoryTLSFlag
+
+	cmd := &cobra.Command{
+		Use:   "create <config.yaml> <bundle.tar>",
+		Short: "Bundle what building a config fetches over the network",
+		Long: `Resolve the config and write a tarball of the APKINDEX files and APK packages
+it needs, the keys they are signed with, and its base image, for transfer into
+airgapped environments.
+
+Local repositories, keys and base images, along with the other files the config
+refers to, are not bundled, and have to be copied along with the config.`,
+		Example:           `  apko bundle create config.yaml bundle.tar --arch x86_64,aarch64`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeConfigFile,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var archs []types.Architecture
+			if len(archstrs) > 0 {
+				archs = types.ParseArchitectures(archstrs)
+			}
+			return bundle.Create(cmd.Context(), args[1], archs,
+				build.WithConfig(args[0], includePaths),
+				build.WithExtraKeys(extraKeys),
+				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithExtraRepos(extraRepos),
+				build.WithIncludePaths(includePaths),
+				build.WithIgnoreSignatures(ignoreSignatures),
+				build.WithFIPS(fips),
+				build.WithRepositoryTLS(repositoryTLS...),
+			)
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
+	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to bundle for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
+	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append")
+	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().BoolVar(&fips, "fips", false, "restrict TLS and repository signature verification to FIPS-approved algorithms, failing on repositories only signed with non-approved ones")
+	addRepositoryTLSFlag(cmd, &repositoryTLS)
+
+	return cmd
+}
+
+// openBundle extracts the bundle at path into dir to build from it, and
+// returns the architectures to build: those of the bundle, unless archstrs
+// picks some of them.
+func openBundle(ctx context.Context, path, dir string, archstrs []string) (*bundle.Bundle, []types.Architecture, error) {
+	b, err := bundle.Open(ctx, path, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(archstrs) == 0 {
+		return b, b.Manifest.Archs, nil
+	}
+	archs := types.ParseArchitectures(archstrs)
+	for _, arch := range archs {
+		if !slices.Contains(b.Manifest.Archs, arch) {
+			return nil, nil, fmt.Errorf("bundle %s has no packages for %s, only for %v", path, arch, b.Manifest.Archs)
+		}
+	}
+	return b, archs, nil
+}
@@ -0,0 +1,207 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+)
+
+func cacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and prune the apko caches",
+		Long: `Inspect and prune the package cache, of APK packages and APKINDEX files, and
+the layer cache, which is kept in the "layers" directory of the package cache
+unless --layer-cache-dir is given.`,
+	}
+	cmd.AddCommand(cacheInfoCmd())
+	cmd.AddCommand(cachePruneCmd())
+	return cmd
+}
+
+func cacheInfoCmd() *cobra.Command {
+	var cacheDir, layerCacheDir string
+
+	cmd := &cobra.Command{
+		Use:     "info",
+		Short:   "Summarize the contents of the apko caches",
+		Example: `  apko cache info --cache-dir /var/cache/apko`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return CacheInfoImpl(cmd.Context(), cmd.OutOrStdout(), cacheDir, layerCacheDir)
+		},
+	}
+
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory containing the apk cache (defaults to system cache directory)")
+	cmd.Flags().StringVar(&layerCacheDir, "layer-cache-dir", "", "directory containing the layer cache (defaults to the layers directory of the apk cache)")
+
+	return cmd
+}
+
+func cachePruneCmd() *cobra.Command {
+	var cacheDir, layerCacheDir, maxSize string
+	var maxAge time.Duration
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old entries from the apko caches",
+		Long: `Remove the entries of the package and layer caches that were not used for
+longer than --max-age, and then the least recently used entries of each until
+it is no larger than --max-size.
+
+Packages count as used when a build finds them in the cache, indexes when they
+are downloaded or found unchanged, and layer cache entries when a build reuses
+their layers.`,
+		Example: `  apko cache prune --max-age 168h
+  apko cache prune --max-size 10G --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if maxAge <= 0 && maxSize == "" {
+				return errors.New("at least one of --max-age and --max-size is required")
+			}
+			policy := apk.CachePrunePolicy{MaxAge: maxAge, DryRun: dryRun}
+			if maxSize != "" {
+				size, err := parseSize(maxSize)
+				if err != nil {
+					return fmt.Errorf("parsing --max-size: %w", err)
+				}
+				policy.MaxSize = size
+			}
+			return CachePruneImpl(cmd.Context(), cacheDir, layerCacheDir, policy)
+		},
+	}
+
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory containing the apk cache (defaults to system cache directory)")
+	cmd.Flags().StringVar(&layerCacheDir, "layer-cache-dir", "", "directory containing the layer cache (defaults to the layers directory of the apk cache)")
+	cmd.Flags().DurationVar(&maxAge, "max-age", 0, "remove entries not used for longer than this, e.g. 168h")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "remove the least recently used entries until each cache is no larger than this, e.g. 10G")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "show what would be removed without deleting")
+
+	return cmd
+}
+
+// cacheDirs resolves the package and layer cache directories the same way
+// builds do.
+func cacheDirs(cacheDir, layerCacheDir string) (string, string, error) {
+	if cacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to determine user cache directory: %w", err)
+		}
+		cacheDir = filepath.Join(dir, "dev.chainguard.go-apk")
+	}
+	cacheDir, err := filepath.Abs(cacheDir)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve cache directory path: %w", err)
+	}
+	if layerCacheDir == "" {
+		layerCacheDir = filepath.Join(cacheDir, "layers")
+	}
+	layerCacheDir, err = filepath.Abs(layerCacheDir)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve layer cache directory path: %w", err)
+	}
+	return cacheDir, layerCacheDir, nil
+}
+
+func CacheInfoImpl(_ context.Context, w io.Writer, cacheDir, layerCacheDir string) error {
+	cacheDir, layerCacheDir, err := cacheDirs(cacheDir, layerCacheDir)
+	if err != nil {
+		return err
+	}
+
+	stats, err := apk.CacheUsage(cacheDir, layerCacheDir)
+	if err != nil {
+		return fmt.Errorf("reading package cache: %w", err)
+	}
+	printCacheStats(w, "Package cache", cacheDir, stats)
+
+	stats, err = build.LayerCacheUsage(layerCacheDir)
+	if err != nil {
+		return fmt.Errorf("reading layer cache: %w", err)
+	}
+	printCacheStats(w, "Layer cache", layerCacheDir, stats)
+	return nil
+}
+
+func printCacheStats(w io.Writer, title, dir string, stats apk.CacheStats) {
+	fmt.Fprintf(w, "%s: %s\n", title, dir)
+	fmt.Fprintf(w, "  Entries: %d\n", stats.Entries)
+	fmt.Fprintf(w, "  Size:    %s\n", formatBytes(stats.Size))
+	if stats.Entries > 0 {
+		fmt.Fprintf(w, "  Oldest:  %s\n", stats.Oldest.Format(time.RFC3339))
+		fmt.Fprintf(w, "  Newest:  %s\n", stats.Newest.Format(time.RFC3339))
+	}
+}
+
+func CachePruneImpl(ctx context.Context, cacheDir, layerCacheDir string, policy apk.CachePrunePolicy) error {
+	log := clog.FromContext(ctx)
+	cacheDir, layerCacheDir, err := cacheDirs(cacheDir, layerCacheDir)
+	if err != nil {
+		return err
+	}
+
+	verb := "Removed"
+	if policy.DryRun {
+		verb = "Would remove"
+	}
+
+	removed, err := apk.PruneCache(ctx, cacheDir, policy, layerCacheDir)
+	if err != nil {
+		return fmt.Errorf("pruning package cache %s: %w", cacheDir, err)
+	}
+	log.Infof("%s %d entries (%s) from package cache %s", verb, removed.Entries, formatBytes(removed.Size), cacheDir)
+
+	removed, err = build.PruneLayerCache(ctx, layerCacheDir, policy)
+	if err != nil {
+		return fmt.Errorf("pruning layer cache %s: %w", layerCacheDir, err)
+	}
+	log.Infof("%s %d entries (%s) from layer cache %s", verb, removed.Entries, formatBytes(removed.Size), layerCacheDir)
+	return nil
+}
+
+// parseSize parses a size in bytes with an optional binary unit suffix, such
+// as 512M or 10GiB, the opposite of formatBytes.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	mult := int64(1)
+	if i := strings.IndexAny(num, "KMGTPE"); i >= 0 && i == len(num)-1 {
+		for range strings.IndexByte("KMGTPE", num[i]) + 1 {
+			mult *= 1024
+		}
+		num = num[:i]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(mult)), nil
+}
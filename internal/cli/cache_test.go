@@ -0,0 +1,77 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"0":      0,
+		"512":    512,
+		"100B":   100,
+		"1k":     1 << 10,
+		"512M":   512 << 20,
+		"10GiB":  10 << 30,
+		"1.5G":   3 << 29,
+		" 2TB ":  2 << 40,
+		"1.0 KB": 1 << 10,
+	} {
+		got, err := parseSize(in)
+		require.NoError(t, err, in)
+		require.Equal(t, want, got, in)
+	}
+	for _, in := range []string{"", "G", "-1G", "ten", "1X", "Inf"} {
+		_, err := parseSize(in)
+		require.Error(t, err, in)
+	}
+}
+
+func TestCachePruneImpl(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	for _, file := range []string{
+		"https%3A%2F%2Fpackages.wolfi.dev%2Fos/x86_64/APKINDEX/ETAG.tar.gz",
+		"layers/entries/key.json",
+		"layers/lineage/lineage.json",
+	} {
+		path := filepath.Join(cacheDir, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("{}"), 0o644))
+		require.NoError(t, os.Chtimes(path, old, old))
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, CacheInfoImpl(ctx, &out, cacheDir, ""))
+	require.Contains(t, out.String(), "Package cache: "+cacheDir+"\n  Entries: 1\n")
+	require.Contains(t, out.String(), "Layer cache: "+filepath.Join(cacheDir, "layers")+"\n  Entries: 1\n")
+
+	require.NoError(t, CachePruneImpl(ctx, cacheDir, "", apk.CachePrunePolicy{MaxAge: 24 * time.Hour}))
+	out.Reset()
+	require.NoError(t, CacheInfoImpl(ctx, &out, cacheDir, ""))
+	require.Contains(t, out.String(), "Package cache: "+cacheDir+"\n  Entries: 0\n")
+	require.Contains(t, out.String(), "Layer cache: "+filepath.Join(cacheDir, "layers")+"\n  Entries: 0\n")
+}
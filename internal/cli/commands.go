@@ -19,14 +19,33 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
 
 	"github.com/chainguard-dev/clog/slag"
 	charmlog "github.com/charmbracelet/log"
 	cranecmd "github.com/google/go-containerregistry/cmd/crane/cmd"
 	"github.com/spf13/cobra"
 	"sigs.k8s.io/release-utils/version"
+
+	"chainguard.dev/apko/pkg/progress"
+)
+
+var (
+	finalizeOnce sync.Once
+	// finalize stops what the command being run started. The finalizers of
+	// cobra are global to the process, so a single one is registered, which
+	// runs finalize of whichever command tree of New ran last.
+	finalize func()
 )
 
+// runFinalize runs finalize, even if the command failed.
+func runFinalize() {
+	if finalize != nil {
+		finalize()
+		finalize = nil
+	}
+}
+
 func New() *cobra.Command {
 	var workDir string
 	cwd, err := os.Getwd()
@@ -34,11 +53,23 @@ func New() *cobra.Command {
 		cwd = ""
 	}
 	level := slag.Level(slog.LevelInfo)
+	prof := &profiler{}
+	tr := &tracer{}
+	var showProgress bool
+	var bar *progressBar
+	finalizeOnce.Do(func() { cobra.OnFinalize(runFinalize) })
 	cmd := &cobra.Command{
 		Use:               "apko",
 		DisableAutoGenTag: true,
 		SilenceUsage:      true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			finalize = func() {
+				prof.stop()
+				tr.stop()
+				if bar != nil {
+					bar.finish()
+				}
+			}
 			http.DefaultTransport = userAgentTransport{http.DefaultTransport}
 			if workDir != "" {
 				if err := os.Chdir(workDir); err != nil {
@@ -46,26 +77,43 @@ func New() *cobra.Command {
 				}
 			}
 			slog.SetDefault(slog.New(charmlog.NewWithOptions(os.Stderr, charmlog.Options{ReportTimestamp: true, Level: charmlog.Level(level)})))
-			return nil
+			ctx, err := tr.start(cmd)
+			if err != nil {
+				return err
+			}
+			cmd.SetContext(ctx)
+			if showProgress {
+				bar = newProgressBar(os.Stderr)
+				cmd.SetContext(progress.WithReporter(cmd.Context(), bar))
+			}
+			return prof.start()
 		},
 	}
 	cmd.PersistentFlags().Var(&level, "log-level", "log level (e.g. debug, info, warn, error, fatal, panic)")
+	cmd.PersistentFlags().BoolVar(&showProgress, "progress", false, "render progress bars for resolving, downloading, installing and publishing on stderr")
+	addProfileFlags(cmd, prof)
 
 	cmd.AddCommand(cranecmd.NewCmdAuthLogin("apko")) // apko login
 	cmd.AddCommand(buildCmd())
 	cmd.AddCommand(buildMinirootFS())
 	cmd.AddCommand(buildCPIO())
+	cmd.AddCommand(rootfsCmd())
 	cmd.AddCommand(showConfig())
 	cmd.AddCommand(publish())
 	cmd.AddCommand(showPackages())
 	cmd.AddCommand(dotcmd())
 	cmd.AddCommand(lock())
 	cmd.AddCommand(resolve())
+	cmd.AddCommand(rebase())
+	cmd.AddCommand(verifyReproducible())
 	cmd.AddCommand(installKeys())
 	cmd.AddCommand(cleanCmd())
+	cmd.AddCommand(cacheCmd())
+	cmd.AddCommand(bundleCmd())
 	cmd.AddCommand(version.Version())
 
 	cmd.PersistentFlags().StringVarP(&workDir, "workdir", "C", cwd, "working dir (default is current dir where executed)")
+	registerCompletions(cmd)
 	return cmd
 }
 
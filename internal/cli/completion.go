@@ -0,0 +1,136 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/fsimage"
+	"chainguard.dev/apko/pkg/sbom/generator"
+)
+
+// flagCompletions complete the values of the flags of these names, in every
+// command that has them. Flags whose values depend on the command, such as
+// --format, are completed by their command.
+var flagCompletions = map[string]cobra.CompletionFunc{
+	"arch":                  completeArchs,
+	"build-arch":            completeArchs,
+	"log-level":             cobra.FixedCompletions([]string{"debug", "info", "warn", "error"}, cobra.ShellCompDirectiveNoFileComp),
+	"initramfs-compression": cobra.FixedCompletions([]string{fsimage.CompressionGzip, fsimage.CompressionZstd, fsimage.CompressionNone}, cobra.ShellCompDirectiveNoFileComp),
+	"run-scriptlets":        cobra.FixedCompletions([]string{build.ScriptletsBubblewrap, build.ScriptletsChroot}, cobra.ShellCompDirectiveNoFileComp),
+	"sbom-formats":          completeSBOMFormats,
+	"attach-sbom-formats":   completeSBOMFormats,
+	"profile":               completeConfigNames(func(ic *types.ImageConfiguration) []string { return slices.Collect(maps.Keys(ic.Profiles)) }),
+	"variant":               completeConfigNames(func(ic *types.ImageConfiguration) []string { return slices.Collect(maps.Keys(ic.Variants)) }),
+}
+
+// registerCompletions registers the completions of flagCompletions for the
+// flags of cmd and its subcommands.
+func registerCompletions(cmd *cobra.Command) {
+	for name, fn := range flagCompletions {
+		if cmd.LocalFlags().Lookup(name) != nil {
+			completeFlag(cmd, name, fn)
+		}
+	}
+	for _, sub := range cmd.Commands() {
+		registerCompletions(sub)
+	}
+}
+
+// completeFlag completes the values of the flag name of cmd with fn. It panics
+// if cmd has no such flag, or already completes it.
+func completeFlag(cmd *cobra.Command, name string, fn cobra.CompletionFunc) {
+	if err := cmd.RegisterFlagCompletionFunc(name, fn); err != nil {
+		panic(err)
+	}
+}
+
+// completeConfigFile completes the first argument of the commands that take a
+// configuration file with the YAML files and the directories of the one being
+// completed, and leaves the other arguments to the shell.
+func completeConfigFile(_ *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if len(args) == 0 {
+		return []string{"yaml", "yml"}, cobra.ShellCompDirectiveFilterFileExt
+	}
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+// completeList completes the last of the comma-separated values of
+// toComplete with those of values not already given.
+func completeList(toComplete string, values []string) ([]string, cobra.ShellCompDirective) {
+	given := toComplete[:strings.LastIndex(toComplete, ",")+1]
+	var out []string
+	for _, v := range values {
+		if !slices.Contains(strings.Split(given, ","), v) {
+			out = append(out, given+v)
+		}
+	}
+	return out, cobra.ShellCompDirectiveNoFileComp
+}
+
+// formatNames returns the names of formats, for completion.
+func formatNames(formats []fsimage.Format) []string {
+	names := make([]string, 0, len(formats))
+	for _, f := range formats {
+		names = append(names, string(f))
+	}
+	return names
+}
+
+// completeArchs completes the architectures of --arch with their apk names,
+// and "host".
+func completeArchs(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	archs := make([]string, 0, len(types.AllArchs)+1)
+	for _, a := range types.AllArchs {
+		archs = append(archs, a.ToAPK())
+	}
+	return completeList(toComplete, append(archs, "host"))
+}
+
+// completeSBOMFormats completes the SBOM formats with those of the registered
+// generators.
+func completeSBOMFormats(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	var formats []string
+	for _, g := range generator.Generators() {
+		formats = append(formats, g.Key())
+	}
+	slices.Sort(formats)
+	return completeList(toComplete, formats)
+}
+
+// completeConfigNames returns a completion of the names that names returns from
+// the configuration file given as the first argument of the command, such as
+// those of its profiles.
+func completeConfigNames(names func(ic *types.ImageConfiguration) []string) cobra.CompletionFunc {
+	return func(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+		if len(args) == 0 || args[0] == "-" {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		includePaths, _ := cmd.Flags().GetStringSlice("include-paths")
+		_, ic, err := build.NewOptions(build.WithConfig(args[0], includePaths))
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+		out := names(ic)
+		slices.Sort(out)
+		return out, cobra.ShellCompDirectiveNoFileComp
+	}
+}
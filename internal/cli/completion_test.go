@@ -0,0 +1,105 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// complete returns the completions apko offers for args, the last of which is
+// being completed, followed by the directive line.
+func complete(t *testing.T, args ...string) []string {
+	t.Helper()
+	cmd := New()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(append([]string{"__complete"}, args...))
+	require.NoError(t, cmd.Execute())
+	return strings.Split(strings.TrimSpace(out.String()), "\n")
+}
+
+func TestCompletion(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "apko.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`contents:
+  packages:
+    - busybox
+variants:
+  debug: {}
+  slim: {}
+profiles:
+  prod: {}
+`), 0o644))
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		want []string
+	}{{
+		name: "config file",
+		args: []string{"build", ""},
+		want: []string{"yaml", "yml", ":8"},
+	}, {
+		name: "after config file",
+		args: []string{"show-config", config, ""},
+		want: []string{":0"},
+	}, {
+		name: "arch",
+		args: []string{"build", "--arch", "x86_64,a"},
+		want: []string{"x86_64,x86", "x86_64,aarch64", "x86_64,armhf", "x86_64,armv7", "x86_64,loongarch64", "x86_64,ppc64le", "x86_64,riscv64", "x86_64,s390x", "x86_64,host", ":4"},
+	}, {
+		name: "output",
+		args: []string{"build", "--output", ""},
+		want: []string{"oci", "bootc", "erofs", "ext4", "initramfs", "iso", "raw", "squashfs", ":4"},
+	}, {
+		name: "log level",
+		args: []string{"lock", "--log-level", ""},
+		want: []string{"debug", "info", "warn", "error", ":4"},
+	}, {
+		name: "resolve format",
+		args: []string{"resolve", "--format", ""},
+		want: []string{"lock", "purl", "cyclonedx", ":4"},
+	}, {
+		name: "dot format",
+		args: []string{"dot", "--format", ""},
+		want: []string{"dot", "json", ":4"},
+	}, {
+		name: "run scriptlets",
+		args: []string{"publish", "--run-scriptlets", ""},
+		want: []string{"bubblewrap", "chroot", ":4"},
+	}, {
+		name: "variants",
+		args: []string{"build", config, "--variant", ""},
+		want: []string{"debug", "slim", ":4"},
+	}, {
+		name: "profiles",
+		args: []string{"publish", config, "--profile", ""},
+		want: []string{"prod", ":4"},
+	}, {
+		name: "profiles without config",
+		args: []string{"publish", "--profile", ""},
+		want: []string{":4"},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, complete(t, tt.args...))
+		})
+	}
+}
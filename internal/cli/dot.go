@@ -46,11 +46,13 @@ var extRegistryViewer string
 func dotcmd() *cobra.Command {
 	var extraKeys []string
 	var extraBuildRepos []string
+	var melangeWorkspace string
 	var extraRepos []string
 	var archstrs []string
-	var web, span bool
+	var web, span, details bool
 	var cacheDir string
 	var offline bool
+	var format string
 
 	cmd := &cobra.Command{
 		Use:   "dot",
@@ -65,15 +67,33 @@ apko dot --web example.yaml
 
 # Open browser to explore example.yaml, rendering a (almost) minimum spanning tree
 apko dot --web -S example.yaml
+
+# Render example.yaml with the size and license of each package, and whether
+# the packages it requests are pinned to a version
+apko dot --details example.yaml | dot -Tsvg > graph.svg
+
+# Write the resolved graph of example.yaml as JSON
+apko dot --format=json example.yaml > graph.json
 `,
-		Example: `  apko dot <config.yaml>`,
-		Args:    cobra.ExactArgs(1),
+		Example:           `  apko dot <config.yaml>`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeConfigFile,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			archs := types.ParseArchitectures(archstrs)
-			return DotCmd(cmd.Context(), args[0], archs, web, span,
+			switch format {
+			case "dot":
+			case "json":
+				if web {
+					return errors.New("--web cannot be used with --format=json")
+				}
+			default:
+				return fmt.Errorf("unknown format %q, expected dot or json", format)
+			}
+			return DotCmd(cmd.Context(), args[0], archs, web, span, details, format,
 				build.WithConfig(args[0], []string{}),
 				build.WithExtraKeys(extraKeys),
 				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
 				build.WithExtraRepos(extraRepos),
 				build.WithCache(cacheDir, offline, apk.NewCache(true)),
 			)
@@ -82,18 +102,22 @@ apko dot --web -S example.yaml
 
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
 	cmd.Flags().BoolVarP(&span, "spanning-tree", "S", false, "does something like a spanning tree to avoid a huge number of edges")
 	cmd.Flags().BoolVar(&web, "web", false, "launch a browser")
+	cmd.Flags().BoolVar(&details, "details", false, "label packages with their installed size and license, and fill the packages the config requests: green if pinned to a version, yellow if floating")
+	cmd.Flags().StringVar(&format, "format", "dot", "output format: dot for a digraph, or json for the nodes and edges of the resolved graph, with the constraint each edge satisfies")
+	completeFlag(cmd, "format", cobra.FixedCompletions([]string{"dot", "json"}, cobra.ShellCompDirectiveNoFileComp))
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
-	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use the network to fetch packages, indexes, keys, advisory feeds or base images, which must all come from the cache or local files")
 	cmd.Flags().StringVarP(&extRegistryViewer, "registry-explorer", "e", "apk.dag.dev", "FQDN of the registry explorer that rendered nodes in SVG will link to.")
 
 	return cmd
 }
 
-func DotCmd(ctx context.Context, configFile string, archs []types.Architecture, web, span bool, opts ...build.Option) error {
+func DotCmd(ctx context.Context, configFile string, archs []types.Architecture, web, span, details bool, format string, opts ...build.Option) error {
 	log := clog.FromContext(ctx)
 	wd, err := os.MkdirTemp("", "apko-*")
 	if err != nil {
@@ -145,6 +169,10 @@ func DotCmd(ctx context.Context, configFile string, archs []types.Architecture,
 		log.Errorf("failed to get package list for image: %v", resolveErr)
 	}
 
+	if format == "json" {
+		return writeDependencyGraph(os.Stdout, dependencyGraph(configFile, arch.ToAPK(), ic.Contents.Packages, pkgs, resolveErr))
+	}
+
 	dmap := map[string][]string{}
 	pmap := map[string][]string{}
 	pkgMap := map[string]*apk.RepositoryPackage{}
@@ -155,6 +183,19 @@ func DotCmd(ctx context.Context, configFile string, archs []types.Architecture,
 		pkgMap[pkg.Name] = pkg
 	}
 
+	requested := requestedPackages(ic.Contents.Packages)
+	label := func(n *dot.Node, pkg *apk.RepositoryPackage) {
+		if !details {
+			if err := n.Set("label", pkgver(pkg)); err != nil {
+				panic(err)
+			}
+			return
+		}
+		if err := annotateNode(n, pkg, requested); err != nil {
+			panic(err)
+		}
+	}
+
 	args := []string{}
 
 	render := func(args []string) *dot.Graph {
@@ -292,9 +333,7 @@ func DotCmd(ctx context.Context, configFile string, archs []types.Architecture,
 				}
 			}
 
-			if err := n.Set("label", pkgver(pkg)); err != nil {
-				panic(err)
-			}
+			label(n, pkg)
 			if err := n.Set("tooltip", pkg.Description); err != nil {
 				panic(err)
 			}
@@ -386,9 +425,7 @@ func DotCmd(ctx context.Context, configFile string, archs []types.Architecture,
 					panic(err)
 				}
 			}
-			if err := n.Set("label", pkgver(pkg)); err != nil {
-				panic(err)
-			}
+			label(n, pkg)
 			if pkg.Description != "" {
 				if err := n.Set("tooltip", pkg.Description); err != nil {
 					panic(err)
@@ -569,6 +606,41 @@ func pkgver(pkg *apk.RepositoryPackage) string {
 	return fmt.Sprintf("%s-%s", pkg.Name, pkg.Version)
 }
 
+// requestedPackages returns the names of the packages of world, and whether
+// each is pinned to an exact version rather than floating.
+func requestedPackages(world []string) map[string]bool {
+	requested := map[string]bool{}
+	for _, p := range world {
+		name := apk.ResolvePackageNameVersionPin(p).Name
+		requested[name] = requested[name] || (strings.Contains(p, "=") && !strings.ContainsAny(p, "<>~"))
+	}
+	return requested
+}
+
+// annotateNode labels n with the version, installed size and license of pkg,
+// and fills it if pkg is one of the requested packages.
+func annotateNode(n *dot.Node, pkg *apk.RepositoryPackage, requested map[string]bool) error {
+	label := pkgver(pkg) + "\n" + formatBytes(int64(pkg.InstalledSize)) //nolint:gosec // sizes fit
+	if pkg.License != "" {
+		label += "\n" + pkg.License
+	}
+	if err := n.Set("label", label); err != nil {
+		return err
+	}
+	pinned, ok := requested[pkg.Name]
+	if !ok {
+		return nil
+	}
+	color := "lightyellow"
+	if pinned {
+		color = "palegreen"
+	}
+	if err := n.Set("style", "filled"); err != nil {
+		return err
+	}
+	return n.Set("fillcolor", color)
+}
+
 func extURL(pkg *apk.RepositoryPackage) string {
 	// Get the package URL like: https://packages.wolfi.dev/repo/arch/package-version.apk
 	pkgURL := pkg.URL()
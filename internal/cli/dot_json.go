@@ -0,0 +1,141 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// The reasons of the edges of a depGraph.
+const (
+	edgeRequired   = "required"
+	edgeDependency = "dependency"
+	edgeProvides   = "provides"
+	edgeInstallIf  = "install-if"
+)
+
+// depGraph is the resolved dependency graph of a configuration, as written by
+// `apko dot --format=json`.
+type depGraph struct {
+	Config string    `json:"config"`
+	Arch   string    `json:"arch"`
+	Nodes  []depNode `json:"nodes"`
+	Edges  []depEdge `json:"edges"`
+	// Error is why the packages could not be resolved, in which case the
+	// graph is incomplete.
+	Error string `json:"error,omitempty"`
+}
+
+// depNode is a resolved package.
+type depNode struct {
+	Name       string   `json:"name"`
+	Version    string   `json:"version"`
+	Origin     string   `json:"origin,omitempty"`
+	Repository string   `json:"repository,omitempty"`
+	Provides   []string `json:"provides,omitempty"`
+}
+
+// depEdge is a constraint from the configuration or a package, and the
+// package that satisfies it.
+type depEdge struct {
+	// From is the configuration file for the packages it requires, or the
+	// name of a package.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Constraint is the dependency as written, such as "busybox>=1.36" or
+	// "so:libc.so.6".
+	Constraint string `json:"constraint"`
+	// Reason is "required" for the packages of the configuration,
+	// "dependency" for the dependencies of a package, "provides" for those
+	// satisfied by what To provides rather than by its name, and
+	// "install-if" for To installed because From is.
+	Reason string `json:"reason"`
+	// Provides is the entry of To that satisfies a "provides" edge.
+	Provides string `json:"provides,omitempty"`
+}
+
+// dependencyGraph returns the graph of pkgs, resolved for the packages the
+// configuration requires. Constraints that none of pkgs satisfy, such as
+// conflicts, have no edge.
+func dependencyGraph(configFile, arch string, world []string, pkgs []*apk.RepositoryPackage, resolveErr error) depGraph {
+	g := depGraph{Config: configFile, Arch: arch, Nodes: []depNode{}, Edges: []depEdge{}}
+	if resolveErr != nil {
+		g.Error = resolveErr.Error()
+	}
+
+	byName := map[string]*apk.RepositoryPackage{}
+	providers := map[string]*apk.RepositoryPackage{}
+	provided := map[string]string{}
+	for _, pkg := range pkgs {
+		byName[pkg.Name] = pkg
+		for _, prov := range pkg.Provides {
+			name := apk.ResolvePackageNameVersionPin(prov).Name
+			// The first package wins, as when resolving.
+			if _, ok := providers[name]; !ok {
+				providers[name] = pkg
+				provided[name] = prov
+			}
+		}
+	}
+
+	edge := func(from, constraint, reason string) {
+		if strings.HasPrefix(constraint, "!") {
+			return
+		}
+		name := apk.ResolvePackageNameVersionPin(constraint).Name
+		if to, ok := byName[name]; ok {
+			g.Edges = append(g.Edges, depEdge{From: from, To: to.Name, Constraint: constraint, Reason: reason})
+			return
+		}
+		if to, ok := providers[name]; ok {
+			if reason == edgeDependency {
+				reason = edgeProvides
+			}
+			g.Edges = append(g.Edges, depEdge{From: from, To: to.Name, Constraint: constraint, Reason: reason, Provides: provided[name]})
+		}
+	}
+
+	for _, w := range world {
+		edge(configFile, w, edgeRequired)
+	}
+	for _, pkg := range pkgs {
+		node := depNode{Name: pkg.Name, Version: pkg.Version, Origin: pkg.Origin, Provides: pkg.Provides}
+		if repo := pkg.Repository(); repo != nil {
+			node.Repository = repo.URI
+		}
+		g.Nodes = append(g.Nodes, node)
+
+		for _, dep := range pkg.Dependencies {
+			edge(pkg.Name, dep, edgeDependency)
+		}
+		for _, cond := range pkg.InstallIf {
+			if from, ok := byName[apk.ResolvePackageNameVersionPin(cond).Name]; ok {
+				g.Edges = append(g.Edges, depEdge{From: from.Name, To: pkg.Name, Constraint: cond, Reason: edgeInstallIf})
+			}
+		}
+	}
+	return g
+}
+
+// writeDependencyGraph writes g as indented JSON.
+func writeDependencyGraph(w io.Writer, g depGraph) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(g)
+}
@@ -0,0 +1,55 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	repo := (&apk.Repository{URI: "https://example.com/os"}).WithIndex(&apk.APKIndex{})
+	pkg := func(p apk.Package) *apk.RepositoryPackage { return apk.NewRepositoryPackage(&p, repo) }
+	pkgs := []*apk.RepositoryPackage{
+		pkg(apk.Package{Name: "curl", Version: "8.11.0-r0", Origin: "curl", Dependencies: []string{"libcurl-openssl4=8.11.0-r0", "so:libc.so.6", "!curl-minimal"}}),
+		pkg(apk.Package{Name: "libcurl-openssl4", Version: "8.11.0-r0", Origin: "curl", Provides: []string{"so:libcurl.so.4=4.8.0"}, Dependencies: []string{"so:libc.so.6"}}),
+		pkg(apk.Package{Name: "glibc", Version: "2.40-r3", Origin: "glibc", Provides: []string{"so:libc.so.6=6"}}),
+		pkg(apk.Package{Name: "curl-doc", Version: "8.11.0-r0", Origin: "curl", InstallIf: []string{"curl=8.11.0-r0", "docs"}}),
+	}
+
+	g := dependencyGraph("curl.yaml", "x86_64", []string{"curl>8"}, pkgs, errors.New("partial"))
+	require.Equal(t, "partial", g.Error)
+	require.Len(t, g.Nodes, 4)
+	require.Equal(t, depNode{Name: "glibc", Version: "2.40-r3", Origin: "glibc", Repository: "https://example.com/os", Provides: []string{"so:libc.so.6=6"}}, g.Nodes[2])
+	require.Equal(t, []depEdge{
+		{From: "curl.yaml", To: "curl", Constraint: "curl>8", Reason: "required"},
+		{From: "curl", To: "libcurl-openssl4", Constraint: "libcurl-openssl4=8.11.0-r0", Reason: "dependency"},
+		{From: "curl", To: "glibc", Constraint: "so:libc.so.6", Reason: "provides", Provides: "so:libc.so.6=6"},
+		{From: "libcurl-openssl4", To: "glibc", Constraint: "so:libc.so.6", Reason: "provides", Provides: "so:libc.so.6=6"},
+		{From: "curl", To: "curl-doc", Constraint: "curl=8.11.0-r0", Reason: "install-if"},
+	}, g.Edges)
+
+	var buf bytes.Buffer
+	require.NoError(t, writeDependencyGraph(&buf, g))
+	var got depGraph
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &got))
+	require.Equal(t, g, got)
+}
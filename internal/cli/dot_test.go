@@ -0,0 +1,54 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmc/dot"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+func TestRequestedPackages(t *testing.T) {
+	require.Equal(t, map[string]bool{
+		"busybox": false,
+		"curl":    true,
+		"glibc":   false,
+		"tzdata":  false,
+		"local":   true,
+	}, requestedPackages([]string{"busybox", "curl=8.11.0-r0", "glibc>=2.40", "tzdata~2024", "local=1.0-r0@local"}))
+}
+
+func TestAnnotateNode(t *testing.T) {
+	requested := map[string]bool{"curl": true, "busybox": false}
+	for _, tt := range []struct {
+		pkg       apk.Package
+		label     string
+		fillcolor string
+	}{
+		{apk.Package{Name: "curl", Version: "8.11.0-r0", InstalledSize: 2048, License: "curl"}, "curl-8.11.0-r0\n2.0 KB\ncurl", "palegreen"},
+		{apk.Package{Name: "busybox", Version: "1.37.0-r0", InstalledSize: 100}, "busybox-1.37.0-r0\n100 B", "lightyellow"},
+		{apk.Package{Name: "glibc", Version: "2.40-r3", InstalledSize: 3 << 20, License: "LGPL-2.1-or-later"}, "glibc-2.40-r3\n3.0 MB\nLGPL-2.1-or-later", ""},
+	} {
+		t.Run(tt.pkg.Name, func(t *testing.T) {
+			n := dot.NewNode(tt.pkg.Name)
+			require.NoError(t, annotateNode(n, apk.NewRepositoryPackage(&tt.pkg, nil), requested))
+			require.Equal(t, tt.label, n.Get("label"))
+			require.Equal(t, tt.fillcolor, n.Get("fillcolor"))
+		})
+	}
+}
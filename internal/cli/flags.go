@@ -15,8 +15,12 @@
 package cli
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/spf13/cobra"
 
+	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/options"
 )
 
@@ -33,3 +37,48 @@ func addClientLimitFlags(cmd *cobra.Command, limits *options.SizeLimits) {
 	cmd.Flags().Int64Var(&limits.HTTPResponseMaxSize, "max-http-response-size", defaults.HTTPResponseMaxSize,
 		"maximum size for HTTP responses in bytes (0=default, -1=no limit)")
 }
+
+// repositoryTLSFlag collects the values of --repository-tls, each of the form
+// URL[,ca=FILE][,cert=FILE,key=FILE][,min-version=VERSION].
+type repositoryTLSFlag []apk.RepositoryTLS
+
+func (f *repositoryTLSFlag) String() string {
+	urls := make([]string, 0, len(*f))
+	for _, r := range *f {
+		urls = append(urls, r.URL)
+	}
+	return strings.Join(urls, " ")
+}
+
+func (f *repositoryTLSFlag) Set(v string) error {
+	parts := strings.Split(v, ",")
+	r := apk.RepositoryTLS{URL: parts[0]}
+	for _, part := range parts[1:] {
+		k, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("%q is not of the form key=value", part)
+		}
+		switch k {
+		case "ca":
+			r.CAFile = val
+		case "cert":
+			r.CertFile = val
+		case "key":
+			r.KeyFile = val
+		case "min-version":
+			r.MinVersion = val
+		default:
+			return fmt.Errorf("unknown option %q, expected ca, cert, key or min-version", k)
+		}
+	}
+	*f = append(*f, r)
+	return nil
+}
+
+func (f *repositoryTLSFlag) Type() string { return "url[,option=value...]" }
+
+// addRepositoryTLSFlag adds the --repository-tls flag configuring TLS for APK repository and keyring fetches.
+func addRepositoryTLSFlag(cmd *cobra.Command, f *repositoryTLSFlag) {
+	cmd.Flags().Var(f, "repository-tls",
+		"TLS settings for the repositories and keys under a URL: a CA bundle trusted in addition to the system roots (ca=FILE), a client certificate (cert=FILE,key=FILE) and the minimum TLS version (min-version=1.3), e.g. https://apk.example.com/os,ca=ca.pem (may be repeated)")
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build"
+)
+
+func TestRepositoryTLSFlag(t *testing.T) {
+	var f repositoryTLSFlag
+	require.NoError(t, f.Set("https://apk.example.com/os,ca=ca.pem,cert=client.pem,key=client.key,min-version=1.3"))
+	require.NoError(t, f.Set("https://proxy.example.com"))
+	require.Equal(t, repositoryTLSFlag{
+		{URL: "https://apk.example.com/os", CAFile: "ca.pem", CertFile: "client.pem", KeyFile: "client.key", MinVersion: "1.3"},
+		{URL: "https://proxy.example.com"},
+	}, f)
+	require.Equal(t, "https://apk.example.com/os https://proxy.example.com", f.String())
+
+	require.ErrorContains(t, f.Set("https://apk.example.com,ca"), `"ca" is not of the form key=value`)
+	require.ErrorContains(t, f.Set("https://apk.example.com,password=x"), `unknown option "password"`)
+	require.Len(t, f, 2)
+}
+
+func TestWithLayerCache(t *testing.T) {
+	userCacheDir, err := os.UserCacheDir()
+	require.NoError(t, err)
+	for _, tt := range []struct {
+		cacheDir, layerCacheDir string
+		disabled                bool
+		want                    string
+	}{
+		{want: filepath.Join(userCacheDir, "dev.chainguard.go-apk", "layers")},
+		{cacheDir: "/apk", want: filepath.Join("/apk", "layers")},
+		{cacheDir: "/apk", layerCacheDir: "/layers", want: "/layers"},
+		{cacheDir: "/apk", disabled: true, want: ""},
+	} {
+		o, _, err := build.NewOptions(withLayerCache(tt.cacheDir, tt.layerCacheDir, tt.disabled))
+		require.NoError(t, err)
+		require.Equal(t, tt.want, o.LayerCacheDir)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/fsimage"
+)
+
+// outputOCI is the default output of apko build, an OCI image.
+const outputOCI = "oci"
+
+// outputBootc is an OCI image laid out and labeled for bootc and OSTree hosts.
+const outputBootc = "bootc"
+
+// BuildFSImageCmd builds the image for archs and writes the root filesystem of
+// each architecture into the output directory as rootfs-<arch>.<ext>, in the
+// given filesystem image format.
+func BuildFSImageCmd(ctx context.Context, format fsimage.Format, output string, archs []types.Architecture, sbomPath string, fsOpts []fsimage.Option, opts ...build.Option) error {
+	wd, err := os.MkdirTemp("", "apko-*")
+	if err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(wd)
+
+	idx, sboms, err := buildImageComponents(ctx, wd, archs, opts...)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	m, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("getting index manifest: %w", err)
+	}
+	for _, desc := range m.Manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("getting %s image: %w", desc.Platform, err)
+		}
+		dest := filepath.Join(output, fmt.Sprintf("rootfs-%s.%s", platformArch(desc.Platform).ToAPK(), format.Ext(fsOpts...)))
+		if err := fsimage.Write(ctx, format, img, dest, fsOpts...); err != nil {
+			return fmt.Errorf("writing %s image for %s: %w", format, desc.Platform, err)
+		}
+	}
+
+	return moveSBOMs(sboms, sbomPath)
+}
+
+// platformArch returns the architecture of an image built for platform.
+func platformArch(platform *v1.Platform) types.Architecture {
+	if platform.Variant != "" {
+		return types.ParseArchitecture(platform.Architecture + "/" + platform.Variant)
+	}
+	return types.ParseArchitecture(platform.Architecture)
+}
@@ -16,7 +16,6 @@ package cli
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"net/http"
 	"os"
@@ -68,52 +67,76 @@ func RemoveLabel(s string) (string, error) {
 func lockInternal(cmdName string, extension string, deprecated string) *cobra.Command {
 	var extraKeys []string
 	var extraBuildRepos []string
+	var melangeWorkspace string
 	var extraRepos []string
 	var archstrs []string
 	var output string
 	var includePaths []string
 	var ignoreSignatures bool
+	var fips bool
+	var repositoryTLS repositoryTLSFlag
+	var packagePolicy string
 	var cacheDir string
+	var format string
+	var purlNamespace string
 
 	cmd := &cobra.Command{
 		Use: cmdName,
 		// hidden for now until we get some feedback on it.
-		Hidden:     true,
-		Example:    fmt.Sprintf(`apko %v <config.yaml>`, cmdName),
-		Args:       cobra.MinimumNArgs(1),
-		Deprecated: deprecated,
+		Hidden:            true,
+		Example:           fmt.Sprintf(`apko %v <config.yaml>`, cmdName),
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeConfigFile,
+		Deprecated:        deprecated,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			ext := extension
+			if format != resolveFormatLock {
+				var ok bool
+				if ext, ok = resolveFormatExtensions[format]; !ok {
+					return fmt.Errorf("unknown format %q, expected lock, purl or cyclonedx", format)
+				}
+			}
 			if output == "" {
-				output = fmt.Sprintf("%s."+extension, strings.TrimSuffix(args[0], filepath.Ext(args[0])))
+				output = fmt.Sprintf("%s."+ext, strings.TrimSuffix(args[0], filepath.Ext(args[0])))
 			}
 
 			archs := types.ParseArchitectures(archstrs)
 
-			return LockCmd(
-				cmd.Context(),
-				output,
-				archs,
-				[]build.Option{
-					build.WithConfig(args[0], includePaths),
-					build.WithExtraKeys(extraKeys),
-					build.WithExtraBuildRepos(extraBuildRepos),
-					build.WithExtraRepos(extraRepos),
-					build.WithIncludePaths(includePaths),
-					build.WithIgnoreSignatures(ignoreSignatures),
-					build.WithCache(cacheDir, false, apk.NewCache(true)),
-				},
-			)
+			opts := []build.Option{
+				build.WithConfig(args[0], includePaths),
+				build.WithExtraKeys(extraKeys),
+				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
+				build.WithExtraRepos(extraRepos),
+				build.WithIncludePaths(includePaths),
+				build.WithIgnoreSignatures(ignoreSignatures),
+				build.WithFIPS(fips),
+				build.WithRepositoryTLS(repositoryTLS...),
+				build.WithPackagePolicy(packagePolicy),
+				build.WithCache(cacheDir, false, apk.NewCache(true)),
+			}
+			if format != resolveFormatLock {
+				return ResolveCmd(cmd.Context(), output, format, purlNamespace, archs, opts)
+			}
+			return LockCmd(cmd.Context(), output, archs, opts)
 		},
 	}
 
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
 	cmd.Flags().StringVar(&output, "output", "", "path to file where lock file will be written")
 	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append")
 	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().BoolVar(&fips, "fips", false, "restrict TLS and repository signature verification to FIPS-approved algorithms, failing on repositories only signed with non-approved ones")
+	addRepositoryTLSFlag(cmd, &repositoryTLS)
+	cmd.Flags().StringVar(&packagePolicy, "package-policy", "", "path to a YAML policy file with allow and deny rules (name, origin, repository), license rules and security advisory feeds for the resolved packages; the build fails on any package that is not allowed")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().StringVar(&format, "format", resolveFormatLock, "output format: lock for a lock file, purl for the purls of the resolved packages one per line, or cyclonedx for them as the components of a CycloneDX document; with --output=- the purls or CycloneDX document are written to stdout")
+	completeFlag(cmd, "format", cobra.FixedCompletions([]string{resolveFormatLock, resolveFormatPurl, resolveFormatCycloneDX}, cobra.ShellCompDirectiveNoFileComp))
+	cmd.Flags().StringVar(&purlNamespace, "purl-namespace", "", "namespace of the purls of the packages, the distribution such as wolfi or alpine; omitted if empty")
 
 	return cmd
 }
@@ -197,30 +220,7 @@ func LockCmd(ctx context.Context, output string, archs []types.Architecture, opt
 		}
 
 		for _, rpkg := range resolvedPkgs {
-			lockPkg := pkglock.LockPkg{
-				Name:         rpkg.Package.Name,
-				URL:          rpkg.Package.URL(),
-				Architecture: rpkg.Package.Arch,
-				Version:      rpkg.Package.Version,
-				Control: pkglock.LockPkgRangeAndChecksum{
-					Range:    fmt.Sprintf("bytes=%d-%d", rpkg.SignatureSize, rpkg.SignatureSize+rpkg.ControlSize-1),
-					Checksum: "sha1-" + base64.StdEncoding.EncodeToString(rpkg.ControlHash),
-				},
-				Data: pkglock.LockPkgRangeAndChecksum{
-					Range:    fmt.Sprintf("bytes=%d-%d", rpkg.SignatureSize+rpkg.ControlSize, rpkg.SignatureSize+rpkg.ControlSize+rpkg.DataSize-1),
-					Checksum: "sha256-" + base64.StdEncoding.EncodeToString(rpkg.DataHash),
-				},
-				Checksum: rpkg.Package.ChecksumString(),
-			}
-
-			if rpkg.SignatureSize != 0 {
-				lockPkg.Signature = pkglock.LockPkgRangeAndChecksum{
-					Range:    fmt.Sprintf("bytes=0-%d", rpkg.SignatureSize-1),
-					Checksum: "sha1-" + base64.StdEncoding.EncodeToString(rpkg.SignatureHash),
-				}
-			}
-
-			lock.Contents.Packages = append(lock.Contents.Packages, lockPkg)
+			lock.Contents.Packages = append(lock.Contents.Packages, pkglock.NewLockPkg(rpkg))
 		}
 		for _, repositoryURI := range ic.Contents.BuildRepositories {
 			repoLock, err := repoLock(repositoryURI, arch)
@@ -253,6 +253,50 @@ func LockCmd(ctx context.Context, output string, archs []types.Architecture, opt
 	return lock.SaveToFile(output)
 }
 
+// ResolveCmd writes the packages resolved for each architecture to output,
+// as purls or CycloneDX components depending on format.
+func ResolveCmd(ctx context.Context, output, format, purlNamespace string, archs []types.Architecture, opts []build.Option) error {
+	log := clog.FromContext(ctx)
+	wd, err := os.MkdirTemp("", "apko-*")
+	if err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(wd)
+
+	o, ic, err := build.NewOptions(opts...)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(archs) != 0:
+	case len(ic.Archs) != 0:
+		archs = ic.Archs
+	default:
+		archs = types.AllArchs
+	}
+	log.Infof("Determining packages for %d architectures: %+v", len(archs), archs)
+	defer os.RemoveAll(o.TempDir())
+
+	var pkgs []*apk.RepositoryPackage
+	for _, arch := range archs {
+		ctx := clog.WithLogger(ctx, log.With("arch", arch.ToAPK()))
+
+		fs := apkfs.DirFS(ctx, filepath.Join(wd, arch.ToAPK()), apkfs.WithCreateDir())
+		bc, err := build.New(ctx, fs, append(slices.Clone(opts), build.WithArch(arch))...)
+		if err != nil {
+			return err
+		}
+		resolved, err := bc.ResolveWithBase(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get package list for image: %w", err)
+		}
+		for _, r := range resolved {
+			pkgs = append(pkgs, r.Package)
+		}
+	}
+	return writeResolved(output, format, pkgs, purlNamespace)
+}
+
 func repoLock(repositoryURI string, arch types.Architecture) (pkglock.LockRepo, error) {
 	repo := apk.Repository{URI: fmt.Sprintf("%s/%s", repositoryURI, arch.ToAPK())}
 	name, err := RemoveLabel(stripURLScheme(repo.URI))
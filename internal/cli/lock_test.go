@@ -19,6 +19,7 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -27,6 +28,7 @@ import (
 	"chainguard.dev/apko/internal/cli"
 	"chainguard.dev/apko/pkg/build"
 	"chainguard.dev/apko/pkg/build/types"
+	pkglock "chainguard.dev/apko/pkg/lock"
 )
 
 func TestLock(t *testing.T) {
@@ -94,6 +96,32 @@ func TestLockWithBaseImage(t *testing.T) {
 	}
 }
 
+func TestResolveWithBaseImage(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	config := filepath.Join("testdata", "image_on_top.apko.yaml")
+	archs := types.ParseArchitectures([]string{"amd64"})
+	opts := []build.Option{build.WithConfig(config, []string{})}
+
+	lockPath := filepath.Join(tmp, "apko.lock.json")
+	require.NoError(t, cli.LockCmd(ctx, lockPath, archs, opts))
+	lock, err := pkglock.FromFile(lockPath)
+	require.NoError(t, err)
+
+	outputPath := filepath.Join(tmp, "apko.purls.txt")
+	require.NoError(t, cli.ResolveCmd(ctx, outputPath, "purl", "wolfi", archs, opts))
+	got, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+
+	purls := strings.Split(strings.TrimSuffix(string(got), "\n"), "\n")
+	require.Len(t, purls, len(lock.Contents.Packages))
+	for i, p := range purls {
+		pkg := lock.Contents.Packages[i]
+		require.True(t, strings.HasPrefix(p, "pkg:apk/wolfi/"+pkg.Name+"@"+pkg.Version+"?arch="+pkg.Architecture), p)
+	}
+}
+
 func TestRemoveLabel(t *testing.T) {
 	tests := []struct {
 		value string
@@ -1,8 +1,18 @@
 package cli
 
+import (
+	"fmt"
+
+	"chainguard.dev/apko/pkg/build/oci"
+)
+
 type publishOpt struct {
-	local bool
-	tags  []string
+	local             bool
+	tags              []string
+	digestFile        string
+	attachSBOMs       bool
+	attachSBOMFormats []string
+	dryRun            bool
 }
 
 // PublishOption is an option for publishing
@@ -23,3 +33,44 @@ func WithTags(tags ...string) PublishOption {
 		return nil
 	}
 }
+
+// WithDigestFile sets the path of a file to write the digest of the published
+// image index to.
+func WithDigestFile(path string) PublishOption {
+	return func(p *publishOpt) error {
+		p.digestFile = path
+		return nil
+	}
+}
+
+// WithAttachSBOMs sets whether to attach the SBOMs to the published images and
+// index as OCI referrers.
+func WithAttachSBOMs(attach bool) PublishOption {
+	return func(p *publishOpt) error {
+		p.attachSBOMs = attach
+		return nil
+	}
+}
+
+// WithAttachSBOMFormats restricts the SBOMs attached with WithAttachSBOMs to
+// those of formats. With none, the SBOMs of every format are attached.
+func WithAttachSBOMFormats(formats ...string) PublishOption {
+	return func(p *publishOpt) error {
+		for _, format := range formats {
+			if _, ok := oci.SBOMArtifactType(format); !ok {
+				return fmt.Errorf("SBOMs of format %q cannot be attached", format)
+			}
+		}
+		p.attachSBOMFormats = formats
+		return nil
+	}
+}
+
+// WithDryRun sets whether to only print what would be published, without
+// writing to the registry.
+func WithDryRun(dryRun bool) PublishOption {
+	return func(p *publishOpt) error {
+		p.dryRun = dryRun
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+
+	"github.com/spf13/cobra"
+)
+
+// profiler writes Go CPU, heap and execution trace profiles for the duration
+// of a command, as requested by the --profile-cpu, --profile-mem and --profile-trace
+// flags.
+type profiler struct {
+	cpuPath   string
+	memPath   string
+	tracePath string
+
+	cpu   *os.File
+	trace *os.File
+}
+
+func addProfileFlags(cmd *cobra.Command, p *profiler) {
+	cmd.PersistentFlags().StringVar(&p.cpuPath, "profile-cpu", "", "write a pprof CPU profile of the command to this file")
+	cmd.PersistentFlags().StringVar(&p.memPath, "profile-mem", "", "write a pprof heap profile to this file when the command finishes")
+	cmd.PersistentFlags().StringVar(&p.tracePath, "profile-trace", "", "write a Go execution trace of the command to this file (view with 'go tool trace')")
+}
+
+func (p *profiler) start() error {
+	if p.cpuPath != "" {
+		f, err := os.Create(p.cpuPath)
+		if err != nil {
+			return fmt.Errorf("creating CPU profile: %w", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return fmt.Errorf("starting CPU profile: %w", err)
+		}
+		p.cpu = f
+	}
+
+	if p.tracePath != "" {
+		f, err := os.Create(p.tracePath)
+		if err != nil {
+			return fmt.Errorf("creating execution trace: %w", err)
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			return fmt.Errorf("starting execution trace: %w", err)
+		}
+		p.trace = f
+	}
+
+	return nil
+}
+
+// stop flushes any running profiles. It is safe to call more than once, and
+// runs even when the command fails so slow failing builds can be profiled too.
+func (p *profiler) stop() {
+	if err := p.finish(); err != nil {
+		slog.Error("writing profiles", "error", err)
+	}
+}
+
+func (p *profiler) finish() error {
+	var errs []error
+
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+		errs = append(errs, p.cpu.Close())
+		p.cpu = nil
+	}
+
+	if p.trace != nil {
+		trace.Stop()
+		errs = append(errs, p.trace.Close())
+		p.trace = nil
+	}
+
+	if p.memPath != "" {
+		f, err := os.Create(p.memPath)
+		if err != nil {
+			return errors.Join(append(errs, fmt.Errorf("creating heap profile: %w", err))...)
+		}
+		// Get up-to-date statistics for the heap profile.
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			errs = append(errs, fmt.Errorf("writing heap profile: %w", err))
+		}
+		errs = append(errs, f.Close())
+		p.memPath = ""
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiler(t *testing.T) {
+	dir := t.TempDir()
+	p := &profiler{
+		cpuPath:   filepath.Join(dir, "cpu.prof"),
+		memPath:   filepath.Join(dir, "mem.prof"),
+		tracePath: filepath.Join(dir, "trace.out"),
+	}
+
+	require.NoError(t, p.start())
+	require.NoError(t, p.finish())
+	// A second stop must be a no-op.
+	require.NoError(t, p.finish())
+
+	for _, f := range []string{p.cpuPath, filepath.Join(dir, "mem.prof"), p.tracePath} {
+		fi, err := os.Stat(f)
+		require.NoError(t, err)
+		require.NotZero(t, fi.Size(), f)
+	}
+}
+
+func TestProfileFlags(t *testing.T) {
+	// Each command tree finishes its own profiles when it has run, however
+	// many trees were created.
+	for range 2 {
+		dir := t.TempDir()
+		cmd := New()
+		cmd.SetArgs([]string{"version", "--profile-mem", filepath.Join(dir, "mem.prof"), "--profile-trace", filepath.Join(dir, "trace.out")})
+		require.NoError(t, cmd.Execute())
+		for _, f := range []string{"mem.prof", "trace.out"} {
+			fi, err := os.Stat(filepath.Join(dir, f))
+			require.NoError(t, err)
+			require.NotZero(t, fi.Size(), f)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"chainguard.dev/apko/pkg/progress"
+)
+
+// progressBar renders progress events as a single status line that is
+// redrawn in place, e.g.
+//
+//	resolved 2 arch(s) | download 14/14 (3.2 MB) | install [#####-----] 60/120 | publish [##--------] 1.0 MB/4.0 MB
+type progressBar struct {
+	w        io.Writer
+	interval time.Duration
+
+	mu         sync.Mutex
+	lastRender time.Time
+	rendered   bool
+
+	resolved   map[string]bool
+	downloads  map[string]int64
+	downloaded int
+	installs   map[string]progress.Event
+	layers     int
+	layerBytes int64
+	publishes  map[string]progress.Event
+}
+
+func newProgressBar(w io.Writer) *progressBar {
+	return &progressBar{
+		w:         w,
+		interval:  100 * time.Millisecond,
+		resolved:  map[string]bool{},
+		downloads: map[string]int64{},
+		installs:  map[string]progress.Event{},
+		publishes: map[string]progress.Event{},
+	}
+}
+
+// Report implements progress.Reporter.
+func (p *progressBar) Report(e progress.Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch e.Phase {
+	case progress.PhaseResolve:
+		p.resolved[e.Arch] = true
+	case progress.PhaseDownload:
+		p.downloads[e.Name] = e.Current
+		if e.Done {
+			p.downloaded++
+		}
+	case progress.PhaseInstall:
+		p.installs[e.Arch] = e
+	case progress.PhaseLayer:
+		p.layers++
+		p.layerBytes += e.Total
+	case progress.PhasePublish:
+		p.publishes[e.Name] = e
+	}
+
+	if time.Since(p.lastRender) >= p.interval || e.Done {
+		p.render()
+	}
+}
+
+// finish draws the final state and moves to a new line.
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.rendered {
+		return
+	}
+	p.render()
+	fmt.Fprintln(p.w)
+	p.rendered = false
+}
+
+func (p *progressBar) render() {
+	p.lastRender = time.Now()
+	p.rendered = true
+	fmt.Fprintf(p.w, "\r\033[K%s", p.status())
+}
+
+func (p *progressBar) status() string {
+	var parts []string
+
+	if len(p.resolved) != 0 {
+		parts = append(parts, fmt.Sprintf("resolved %d arch(s)", len(p.resolved)))
+	}
+
+	if len(p.downloads) != 0 {
+		var total int64
+		for _, n := range p.downloads {
+			total += n
+		}
+		parts = append(parts, fmt.Sprintf("download %d/%d (%s)", p.downloaded, len(p.downloads), formatBytes(total)))
+	}
+
+	if len(p.installs) != 0 {
+		var current, total int64
+		for _, e := range p.installs {
+			current += e.Current
+			total += e.Total
+		}
+		parts = append(parts, fmt.Sprintf("install %s %d/%d", bar(current, total, 10), current, total))
+	}
+
+	if p.layers != 0 {
+		parts = append(parts, fmt.Sprintf("layers %d (%s)", p.layers, formatBytes(p.layerBytes)))
+	}
+
+	if len(p.publishes) != 0 {
+		var current, total int64
+		for _, e := range p.publishes {
+			current += e.Current
+			total += e.Total
+		}
+		parts = append(parts, fmt.Sprintf("publish %s %s/%s", bar(current, total, 10), formatBytes(current), formatBytes(total)))
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+func bar(current, total int64, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = int(min(current, total) * int64(width) / total)
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
@@ -17,13 +17,13 @@ package cli
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/authn/github"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/spf13/cobra"
@@ -35,36 +35,76 @@ import (
 	"chainguard.dev/apko/pkg/build"
 	"chainguard.dev/apko/pkg/build/oci"
 	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/keychain"
+	"chainguard.dev/apko/pkg/report"
+	"chainguard.dev/apko/pkg/sbom"
 	"chainguard.dev/apko/pkg/sbom/generator"
 )
 
 func publish() *cobra.Command {
 	var imageRefs string
+	var digestFile string
 	var buildDate string
 	var sbomPath string
 	var sbomFormats []string
+	var sbomFiles bool
+	var sbomDetectLicenses bool
+	var sbomCPEs bool
+	var sbomCPEMapping string
+	var sbomSigningKey string
+	var sbomKeyless bool
+	var sbomFulcioURL string
+	var sbomRekorURL string
+	var sbomNameTemplate string
 	var archstrs []string
 	var extraKeys []string
 	var extraBuildRepos []string
+	var melangeWorkspace string
 	var extraRepos []string
 	var extraPackages []string
 	var rawAnnotations []string
+	var annotationsAsLabels bool
 	var withVCS bool
 	var writeSBOM bool
 	var local bool
 	var cacheDir string
+	var layerCacheDir string
+	var noLayerCache bool
+	var remoteCache string
+	var strictReproducibility bool
+	var buildInfo bool
 	var offline bool
 	var lockfile string
+	var bundlePath string
 	var ignoreSignatures bool
+	var fips bool
+	var repositoryTLS repositoryTLSFlag
+	var packagePolicy string
+	var runScriptlets string
+	var noScripts []string
+	var reportPath string
+	var timings bool
+	var githubOutputs bool
+	var profile string
+	var attachSBOMs bool
+	var dryRun bool
+	var attachSBOMFormats []string
+	var uploadConcurrency int
+	var uploadChunkSize int64
 
 	cmd := &cobra.Command{
 		Use:   "publish <config.yaml> <tag...>",
 		Short: "Build and publish an image",
 		Long: `Publish a built image from a YAML configuration file.
 
-It is assumed that you have used "docker login" to store credentials
-in a keychain.`,
-		Example: `  apko publish hello-world.yaml hello:v1.0.0`,
+Registry credentials are read from the docker config, as stored by
+"docker login", or else from the workload identity of the environment
+for Amazon ECR, Google Artifact Registry and Azure Container Registry.
+
+Pass "-" as the configuration file to read it from standard input.`,
+		Example: `  apko publish hello-world.yaml hello:v1.0.0
+  generate-config | apko publish - hello:v1.0.0`,
+		ValidArgsFunction: completeConfigFile,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 2 {
 				return fmt.Errorf("requires at least 2 arg(s), 1 config file and at least 1 tag for the image")
@@ -80,11 +120,17 @@ in a keychain.`,
 				return fmt.Errorf("parsing annotations from command line: %w", err)
 			}
 
-			keychain := authn.NewMultiKeychain(
-				authn.DefaultKeychain,
-				github.Keychain,
-			)
-			remoteOpts := []remote.Option{remote.WithAuthFromKeychain(keychain)}
+			remoteOpts := []remote.Option{remote.WithAuthFromKeychain(keychain.Default)}
+			transport := remote.DefaultTransport
+			if fips {
+				transport = apk.FIPSTransport(transport)
+			}
+			// Chunks are retried on their own when rate limited.
+			transport = oci.ChunkedUploadTransport(oci.RateLimitTransport(transport), uploadChunkSize)
+			remoteOpts = append(remoteOpts, remote.WithTransport(transport))
+			if uploadConcurrency > 0 {
+				remoteOpts = append(remoteOpts, remote.WithJobs(uploadConcurrency))
+			}
 
 			pusher, err := remote.NewPusher(remoteOpts...)
 			if err != nil {
@@ -104,34 +150,73 @@ in a keychain.`,
 			}
 			defer os.RemoveAll(tmp)
 
-			if err := PublishCmd(cmd.Context(), imageRefs, archs, remoteOpts,
+			var bundleOpts []build.Option
+			if bundlePath != "" {
+				b, bundleArchs, err := openBundle(cmd.Context(), bundlePath, filepath.Join(tmp, "bundle"), archstrs)
+				if err != nil {
+					return err
+				}
+				archs = bundleArchs
+				extraKeys = append(slices.Clone(extraKeys), b.Keys...)
+				bundleOpts = b.Options()
+			}
+
+			ctx, finish := startReport(cmd.Context(), reportOutputs{
+				path:    reportPath,
+				summary: timingsWriter(timings),
+				github:  githubOutputs,
+				tags:    args[1:],
+			})
+			if err := PublishCmd(ctx, imageRefs, archs, remoteOpts,
 				sbomPath,
-				[]build.Option{
-					build.WithConfig(args[0], []string{}),
+				append([]build.Option{
+					withProfile(withConfig(args[0], []string{}), profile),
 					build.WithBuildDate(buildDate),
 					build.WithSBOM(sbomPath),
 					build.WithSBOMGenerators(sbomGenerators...),
+					build.WithSBOMFiles(sbomFiles),
+					build.WithSBOMDetectLicenses(sbomDetectLicenses),
+					build.WithSBOMCPEs(sbomCPEs, sbomCPEMapping),
+					build.WithSBOMSigningKey(sbomSigningKey),
+					build.WithSBOMKeyless(sbomKeyless, sbomFulcioURL, sbomRekorURL),
+					build.WithSBOMNameTemplate(sbomNameTemplate),
 					build.WithExtraKeys(extraKeys),
 					build.WithExtraBuildRepos(extraBuildRepos),
+					build.WithMelangeWorkspace(melangeWorkspace),
 					build.WithExtraRepos(extraRepos),
 					build.WithExtraPackages(extraPackages),
 					build.WithTags(args[1:]...),
 					build.WithVCS(withVCS),
+					build.WithKeychain(keychain.Default),
 					build.WithAnnotations(annotations),
+					withAnnotationsAsLabels(cmd, annotationsAsLabels),
 					build.WithCache(cacheDir, offline, apk.NewCache(true)),
+					withLayerCache(cacheDir, layerCacheDir, noLayerCache),
+					withRemoteCache(remoteCache),
+					build.WithStrictReproducibility(strictReproducibility),
+					build.WithBuildInfo(buildInfo),
 					build.WithLockFile(lockfile),
 					build.WithTempDir(tmp),
 					build.WithIgnoreSignatures(ignoreSignatures),
-				},
+					build.WithFIPS(fips),
+					build.WithRepositoryTLS(repositoryTLS...),
+					build.WithPackagePolicy(packagePolicy),
+					build.WithScriptlets(runScriptlets),
+					build.WithNoScripts(noScripts...),
+				}, bundleOpts...),
 				[]PublishOption{
 					// these are extra here just for publish; everything before is the same for BuildCmd as PublishCmd
 					WithLocal(local),
 					WithTags(args[1:]...),
+					WithDigestFile(digestFile),
+					WithAttachSBOMs(attachSBOMs),
+					WithAttachSBOMFormats(attachSBOMFormats...),
+					WithDryRun(dryRun),
 				},
 			); err != nil {
-				return err
+				return finish(err)
 			}
-			return nil
+			return finish(nil)
 		},
 	}
 
@@ -142,18 +227,56 @@ in a keychain.`,
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config.")
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
 	cmd.Flags().StringSliceVar(&sbomFormats, "sbom-formats", []string{"spdx"}, "SBOM formats to output")
+	cmd.Flags().BoolVar(&sbomFiles, "sbom-files", false, "list the files of the image in the SBOM, with their SHA-1 and SHA-256 checksums and the package that installed them")
+	cmd.Flags().BoolVar(&sbomDetectLicenses, "sbom-detect-licenses", false, "for packages with a missing or UNKNOWN license, record in the SBOM the licenses found in their usr/share/licenses and copyright files")
+	cmd.Flags().BoolVar(&sbomCPEs, "sbom-cpes", false, "add best-effort CPEs to the packages of the SBOM, alongside their purls")
+	cmd.Flags().StringVar(&sbomCPEMapping, "sbom-cpe-mapping", "", "path to a YAML file mapping package names or origins to the vendor and product of their CPEs, used with --sbom-cpes")
+	cmd.Flags().StringVar(&sbomSigningKey, "sbom-signing-key", "", "path to a PEM private key (ECDSA, Ed25519 or RSA), or KMS key URI (awskms://, gcpkms://, azurekms:// or hashivault://), to sign the SBOMs with, writing a detached <sbom>.sig next to each")
+	cmd.Flags().BoolVar(&sbomKeyless, "sbom-keyless", false, "sign the SBOMs keylessly with Sigstore, for the OIDC identity token in $SIGSTORE_ID_TOKEN, writing a <sbom>.sigstore.json bundle next to each")
+	cmd.Flags().StringVar(&sbomFulcioURL, "sbom-fulcio-url", sbom.DefaultFulcioURL, "Fulcio instance certifying the keyless SBOM signatures, used with --sbom-keyless")
+	cmd.Flags().StringVar(&sbomRekorURL, "sbom-rekor-url", sbom.DefaultRekorURL, "Rekor instance logging the keyless SBOM signatures, used with --sbom-keyless; empty to not log them")
+	cmd.Flags().StringVar(&sbomNameTemplate, "sbom-name-template", "", "Go template for the SBOM file names, of {{.Arch}} (\"index\" for the index), {{.Digest}}, {{.Format}} and {{.Ext}} (default sbom-{{.Arch}}.{{.Ext}})")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
 	cmd.Flags().StringSliceVar(&rawAnnotations, "annotations", []string{}, "OCI annotations to add. Separate with colon (key:value)")
+	cmd.Flags().BoolVar(&annotationsAsLabels, "annotations-as-labels", true, "also set the annotations as labels in the image configs (overrides annotations-as-labels of the config)")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
-	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	cmd.Flags().StringVar(&layerCacheDir, "layer-cache-dir", "", "directory to cache built layers in, so rebuilding an image whose packages and configuration have not changed skips installation and compression (default '' means the layers directory of the apk cache directory)")
+	cmd.Flags().BoolVar(&noLayerCache, "no-layer-cache", false, "do not cache built layers")
+	cmd.Flags().StringVar(&remoteCache, "remote-cache", "", "share downloaded apk packages and indexes through a remote cache: s3://bucket/prefix, gs://bucket/prefix or oci://registry/repository")
+	cmd.Flags().BoolVar(&strictReproducibility, "strict-reproducibility", false, "fail the build on input that would make it nondeterministic, such as files with modification times newer than the build date")
+	cmd.Flags().BoolVar(&buildInfo, "build-info", false, "embed a build-info document (apko version and the digests of the config, lockfile and repository indexes) in each image as a label and annotation")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use the network to fetch packages, indexes, keys, advisory feeds or base images, which must all come from the cache or local files")
 	cmd.Flags().StringVar(&lockfile, "lockfile", "", "a path to .lock.json file (e.g. produced by apko lock) that constraints versions of packages to the listed ones (default '' means no additional constraints)")
+	cmd.Flags().StringVar(&bundlePath, "bundle", "", "build offline from a bundle written by apko bundle create, installing its packages as locked after verifying them against the checksums of its lock, for the architectures of the bundle")
 	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().BoolVar(&fips, "fips", false, "restrict TLS and repository signature verification to FIPS-approved algorithms, failing on repositories only signed with non-approved ones")
+	addRepositoryTLSFlag(cmd, &repositoryTLS)
+	cmd.Flags().StringVar(&packagePolicy, "package-policy", "", "path to a YAML policy file with allow and deny rules (name, origin, repository), license rules, security advisory feeds and scriptlet rules for the resolved packages; the build fails on any package that is not allowed")
+	cmd.Flags().StringVar(&runScriptlets, "run-scriptlets", "", "run the install scriptlets and triggers of the packages in a sandbox without network: bubblewrap or chroot (which requires root); by default scriptlets are not run")
+	cmd.Flags().StringSliceVar(&noScripts, "no-scripts", []string{}, "packages whose install scriptlets are not run with --run-scriptlets, in addition to the no-scripts packages of the configuration (\"*\" skips every package)")
 
 	// these are extra here just for publish; everything before is the same for BuildCmd as PublishCmd
 	cmd.Flags().BoolVar(&local, "local", false, "publish image just to local Docker daemon")
-	cmd.Flags().StringVar(&imageRefs, "image-refs", "", "path to file where a list of the published image references will be written")
+	cmd.Flags().StringVar(&imageRefs, "image-refs", "", "path to file where a list of the published image references will be written, as fully-qualified ref@digest lines for the image of each architecture followed by the index")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "build the image and print the images, index and SBOMs that would be pushed, with their digests, blob counts and sizes, without writing to the registry")
+	cmd.Flags().BoolVar(&attachSBOMs, "attach-sboms", false, "attach the SBOMs to the image of each architecture and to the index as OCI referrers, so that they are found whichever of them is pulled")
+	cmd.Flags().StringSliceVar(&attachSBOMFormats, "attach-sbom-formats", []string{}, "SBOM formats to attach with --attach-sboms, of those generated (default all of them)")
+	cmd.Flags().IntVar(&uploadConcurrency, "upload-concurrency", 0, "number of blobs of each image uploaded at the same time (0=default of 4)")
+	cmd.Flags().Int64Var(&uploadChunkSize, "upload-chunk-size", 0, "upload blobs in chunks of at most this many bytes, for registries that cap the size of requests (0=each blob in a single request)")
+	cmd.Flags().StringVar(&digestFile, "digest-file", "", "path to file where the digest of the published image index will be written, such as a Tekton IMAGE_DIGEST result")
+	cmd.Flags().StringVar(&reportPath, "report", "", "write a JSON report of the build and publish (image, layer and package details, timings, cache statistics and warnings) to this file")
+	cmd.Flags().BoolVar(&timings, "timings", false, "print a summary of the time spent in each phase of the build (resolve, download, extract, tar, compress, sbom, publish) to stderr when it finishes")
+	cmd.Flags().BoolVar(&githubOutputs, "github-outputs", false, "when running in GitHub Actions, write the image digests, tags and installed packages as step outputs to $GITHUB_OUTPUT and a summary of the build to $GITHUB_STEP_SUMMARY")
+	cmd.Flags().StringVar(&profile, "profile", "", "apply the named profile of the configuration (see profiles in the configuration), overriding its repositories, packages and environment")
+	cmd.MarkFlagsMutuallyExclusive("local", "dry-run")
+	cmd.MarkFlagsMutuallyExclusive("layer-cache-dir", "no-layer-cache")
+	cmd.MarkFlagsMutuallyExclusive("offline", "remote-cache")
+	cmd.MarkFlagsMutuallyExclusive("bundle", "lockfile")
+	cmd.MarkFlagsMutuallyExclusive("bundle", "cache-dir")
+	cmd.MarkFlagsMutuallyExclusive("bundle", "remote-cache")
 
 	return cmd
 }
@@ -199,26 +322,58 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 		return nil
 	}
 
-	// publish each arch-specific image
-	// TODO: This should just happen as part of PublishIndex.
-	ref, err := name.ParseReference(tags[0])
-	if err != nil {
-		return fmt.Errorf("parsing %q as tag: %w", tags[0], err)
-	}
-	refs, err := oci.PublishImagesFromIndex(ctx, idx, ref.Context(), ropt...)
-	if err != nil {
-		return fmt.Errorf("publishing images from index: %w", err)
-	}
-	for _, ref := range refs {
-		builtReferences = append(builtReferences, ref.String())
+	var attach []types.SBOM
+	if opts.attachSBOMs {
+		if attach, err = sbomsOfFormats(sboms, opts.attachSBOMFormats); err != nil {
+			return err
+		}
+		if len(attach) == 0 {
+			log.Warnf("no SBOMs were generated to attach")
+		}
 	}
 
-	// publish the index
-	finalDigest, err := oci.PublishIndex(ctx, idx, tags, ropt...)
-	if err != nil {
-		return fmt.Errorf("publishing image index: %w", err)
+	var finalDigest name.Digest
+	if opts.dryRun {
+		plan, err := oci.PlanPublish(ctx, idx, tags, attach)
+		if err != nil {
+			return fmt.Errorf("planning publish: %w", err)
+		}
+		writePublishPlan(os.Stderr, plan)
+		for _, img := range plan.Images {
+			builtReferences = append(builtReferences, img.Ref.Name())
+		}
+		finalDigest = plan.Index.Ref
+		builtReferences = append(builtReferences, finalDigest.Name())
+	} else {
+		// publish each arch-specific image
+		// TODO: This should just happen as part of PublishIndex.
+		ref, err := name.ParseReference(tags[0])
+		if err != nil {
+			return fmt.Errorf("parsing %q as tag: %w", tags[0], err)
+		}
+		stop := report.FromContext(ctx).Time(report.PhasePublish, "")
+		refs, err := oci.PublishImagesFromIndex(ctx, idx, ref.Context(), ropt...)
+		if err != nil {
+			return fmt.Errorf("publishing images from index: %w", err)
+		}
+		for _, ref := range refs {
+			builtReferences = append(builtReferences, ref.Name())
+		}
+
+		// publish the index
+		finalDigest, err = oci.PublishIndex(ctx, idx, tags, ropt...)
+		if err != nil {
+			return fmt.Errorf("publishing image index: %w", err)
+		}
+		builtReferences = append(builtReferences, finalDigest.Name())
+		if len(attach) > 0 {
+			if _, err := oci.AttachSBOMs(ctx, idx, attach, ref.Context(), ropt...); err != nil {
+				return fmt.Errorf("attaching SBOMs: %w", err)
+			}
+		}
+		stop()
+		report.FromContext(ctx).AddReferences(builtReferences...)
 	}
-	builtReferences = append(builtReferences, finalDigest.String())
 
 	// output any file info requested
 	// If provided, this is the name of the file to write digest referenced into
@@ -228,14 +383,19 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 			return fmt.Errorf("failed to write digest: %w", err)
 		}
 	}
+	// The digest is written without a trailing newline, as Tekton expects of
+	// the IMAGE_DIGEST result.
+	if opts.digestFile != "" {
+		//nolint:gosec // Make digest file readable by non-root
+		if err := os.WriteFile(opts.digestFile, []byte(finalDigest.DigestStr()), 0o666); err != nil {
+			return fmt.Errorf("failed to write digest: %w", err)
+		}
+	}
 
 	// copy sboms over to the sbomPath target directory
 	if sbomPath != "" {
-		for _, sbom := range sboms {
-			// because os.Rename fails across partitions, we do our own
-			if err := rename(sbom.Path, filepath.Join(sbomPath, filepath.Base(sbom.Path))); err != nil {
-				return fmt.Errorf("moving sbom: %w", err)
-			}
+		if err := moveSBOMs(sboms, sbomPath); err != nil {
+			return err
 		}
 	}
 
@@ -246,6 +406,44 @@ func PublishCmd(ctx context.Context, outputRefs string, archs []types.Architectu
 	return nil
 }
 
+// sbomsOfFormats returns the SBOMs of formats, or all of sboms without formats.
+// Each of formats must have been generated.
+func sbomsOfFormats(sboms []types.SBOM, formats []string) ([]types.SBOM, error) {
+	if len(formats) == 0 {
+		return sboms, nil
+	}
+	var selected []types.SBOM
+	for _, format := range formats {
+		n := len(selected)
+		for _, sbom := range sboms {
+			if sbom.Format == format {
+				selected = append(selected, sbom)
+			}
+		}
+		if len(selected) == n {
+			return nil, fmt.Errorf("no %s SBOM was generated to attach, see --sbom and --sbom-formats", format)
+		}
+	}
+	return selected, nil
+}
+
+// writePublishPlan writes what plan would push to w, one manifest per line.
+func writePublishPlan(w io.Writer, plan *oci.PublishPlan) {
+	for _, img := range plan.Images {
+		platform := "unknown platform"
+		if img.Platform != nil {
+			platform = img.Platform.String()
+		}
+		fmt.Fprintf(w, "would push image %s (%s): %d blobs, %d bytes\n", img.Ref, platform, img.Blobs, img.Size)
+	}
+	for _, tag := range plan.Tags {
+		fmt.Fprintf(w, "would push index %s as %s: %d images, %d bytes\n", plan.Index.Ref, tag, len(plan.Images), plan.Index.Size)
+	}
+	for _, sbom := range plan.SBOMs {
+		fmt.Fprintf(w, "would attach SBOM %s to %s: %d blobs, %d bytes\n", sbom.Ref, sbom.Subject, sbom.Blobs, sbom.Size)
+	}
+}
+
 func parseAnnotations(rawAnnotations []string) (map[string]string, error) {
 	annotations := map[string]string{}
 	keyRegex := regexp.MustCompile(`^[a-z0-9-\.]+$`)
@@ -100,7 +100,7 @@ func TestPublish(t *testing.T) {
 
 	// This test will fail if we ever make a change in apko that changes the image.
 	// Sometimes, this is intentional, and we need to change this and bump the version.
-	want := "sha256:91213f5088cd9e4e0b8daa74f86085c7007c5896ade6ef0ef9bcbcdda16f5f2d"
+	want := "sha256:152fd6254f47aa72a7fcb138a7de5f7dd479f2d5d6a14ff4b50d447730232611"
 	require.Equal(t, want, digest.String())
 
 	// Check that the sbomPath is not empty.
@@ -109,6 +109,176 @@ func TestPublish(t *testing.T) {
 	require.NotEmpty(t, sboms)
 }
 
+func TestPublishOutputFiles(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	dst := fmt.Sprintf("%s/test/outputs:latest", u.Host)
+
+	imageRefs := filepath.Join(tmp, "image-refs")
+	digestFile := filepath.Join(tmp, "digest")
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithTags(dst),
+	}
+	publishOpts := []cli.PublishOption{cli.WithTags(dst), cli.WithDigestFile(digestFile)}
+	require.NoError(t, cli.PublishCmd(ctx, imageRefs, archs, nil, "", opts, publishOpts))
+
+	ref, err := name.ParseReference(dst)
+	require.NoError(t, err)
+	idx, err := remote.Index(ref)
+	require.NoError(t, err)
+	digest, err := idx.Digest()
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(digestFile)
+	require.NoError(t, err)
+	require.Equal(t, digest.String(), string(b))
+
+	b, err = os.ReadFile(imageRefs)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	require.Len(t, lines, 3)
+	for _, line := range lines {
+		require.True(t, strings.HasPrefix(line, u.Host+"/test/outputs@sha256:"), line)
+	}
+	require.Equal(t, u.Host+"/test/outputs@"+digest.String(), lines[2])
+}
+
+func TestPublishAttachSBOMs(t *testing.T) {
+	ctx := context.Background()
+
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	dst := fmt.Sprintf("%s/test/attach:latest", u.Host)
+
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithTags(dst),
+		build.WithSBOMGenerators(spdx.New()),
+	}
+	publishOpts := []cli.PublishOption{cli.WithTags(dst), cli.WithAttachSBOMs(true)}
+	require.NoError(t, cli.PublishCmd(ctx, "", archs, nil, "", opts, publishOpts))
+
+	ref, err := name.ParseReference(dst)
+	require.NoError(t, err)
+	idx, err := remote.Index(ref)
+	require.NoError(t, err)
+	idxDigest, err := idx.Digest()
+	require.NoError(t, err)
+	m, err := idx.IndexManifest()
+	require.NoError(t, err)
+
+	// The index and the image of each architecture have an SBOM of their own.
+	subjects := []v1.Hash{idxDigest}
+	for _, desc := range m.Manifests {
+		subjects = append(subjects, desc.Digest)
+	}
+	for _, subject := range subjects {
+		referrers, err := remote.Referrers(ref.Context().Digest(subject.String()))
+		require.NoError(t, err)
+		rm, err := referrers.IndexManifest()
+		require.NoError(t, err)
+		require.Len(t, rm.Manifests, 1, subject)
+		require.Equal(t, "application/spdx+json", rm.Manifests[0].ArtifactType)
+
+		img, err := remote.Image(ref.Context().Digest(rm.Manifests[0].Digest.String()))
+		require.NoError(t, err)
+		im, err := img.Manifest()
+		require.NoError(t, err)
+		require.NotNil(t, im.Subject)
+		require.Equal(t, subject, im.Subject.Digest)
+
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		require.Len(t, layers, 1)
+		rc, err := layers[0].Uncompressed()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.Contains(t, string(b), "SPDX-2.3")
+	}
+}
+
+func TestPublishAttachSBOMFormats(t *testing.T) {
+	ctx := context.Background()
+	dst := "registry.example.com/test/attach:latest"
+	archs := types.ParseArchitectures([]string{"amd64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithTags(dst),
+	}
+
+	err := cli.PublishCmd(ctx, "", archs, nil, "", opts, []cli.PublishOption{cli.WithTags(dst), cli.WithAttachSBOMFormats("cyclonedx")})
+	require.ErrorContains(t, err, `SBOMs of format "cyclonedx" cannot be attached`)
+
+	// Without SBOM generators there is no SPDX SBOM to attach.
+	publishOpts := []cli.PublishOption{cli.WithTags(dst), cli.WithDryRun(true), cli.WithAttachSBOMs(true), cli.WithAttachSBOMFormats("spdx")}
+	err = cli.PublishCmd(ctx, "", archs, nil, "", opts, publishOpts)
+	require.ErrorContains(t, err, "no spdx SBOM was generated to attach")
+
+	opts = append(opts, build.WithSBOMGenerators(spdx.New()))
+	require.NoError(t, cli.PublishCmd(ctx, "", archs, nil, "", opts, publishOpts))
+}
+
+func TestPublishDryRun(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	// Fail on anything but reads.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("unexpected %s %s", req.Method, req.URL)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	dst := fmt.Sprintf("%s/test/dry-run:latest", u.Host)
+
+	imageRefs := filepath.Join(tmp, "image-refs")
+	digestFile := filepath.Join(tmp, "digest")
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithTags(dst),
+		build.WithSBOMGenerators(spdx.New()),
+	}
+	publishOpts := []cli.PublishOption{cli.WithTags(dst), cli.WithDigestFile(digestFile), cli.WithDryRun(true), cli.WithAttachSBOMs(true)}
+	require.NoError(t, cli.PublishCmd(ctx, imageRefs, archs, nil, "", opts, publishOpts))
+
+	refs, err := os.ReadFile(imageRefs)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSuffix(string(refs), "\n"), "\n"), 3)
+	digest, err := os.ReadFile(digestFile)
+	require.NoError(t, err)
+
+	// The digest is the one that publishing pushes.
+	r := httptest.NewServer(registry.New())
+	defer r.Close()
+	u, err = url.Parse(r.URL)
+	require.NoError(t, err)
+	dst = fmt.Sprintf("%s/test/dry-run:latest", u.Host)
+	opts = append(opts, build.WithTags(dst))
+	require.NoError(t, cli.PublishCmd(ctx, "", archs, nil, "", opts, []cli.PublishOption{cli.WithTags(dst)}))
+
+	ref, err := name.ParseReference(dst)
+	require.NoError(t, err)
+	idx, err := remote.Index(ref)
+	require.NoError(t, err)
+	want, err := idx.Digest()
+	require.NoError(t, err)
+	require.Equal(t, want.String(), string(digest))
+}
+
 type sentinel struct {
 	rt http.RoundTripper
 }
@@ -174,7 +344,7 @@ func TestPublishLayering(t *testing.T) {
 
 	// This test will fail if we ever make a change in apko that changes the image.
 	// Sometimes, this is intentional, and we need to change this and bump the version.
-	want := "sha256:72435e8671ef7d79007d93066b54c81b875e567ccd396945d7b9ec083f96cba0"
+	want := "sha256:a4229f093503a260f00c15bcd4f9460014c2fd02c9267ff874107dfc74a48a59"
 	require.Equal(t, want, digest.String())
 
 	im, err := idx.IndexManifest()
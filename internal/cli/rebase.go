@@ -0,0 +1,393 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/spf13/cobra"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/baseimg"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/keychain"
+	"chainguard.dev/apko/pkg/sbom"
+	"chainguard.dev/apko/pkg/sbom/generator/spdx"
+	soptions "chainguard.dev/apko/pkg/sbom/options"
+)
+
+func rebase() *cobra.Command {
+	var newBase string
+	var oldBase string
+	var tags []string
+	var sbomPath string
+
+	cmd := &cobra.Command{
+		Use:   "rebase <image>",
+		Short: "Rebase an apko-built image onto a newer base image",
+		Long: `Rebase an image that apko built on top of a base image onto a newer version of that base.
+
+The base layers of the image are swapped for the layers of --base, while the layers,
+config and annotations apko added on top are kept. The apk database of the image is
+rewritten to list the packages of the new base.
+
+The current base of the image is read from its org.opencontainers.image.base.name and
+org.opencontainers.image.base.digest annotations, unless --old-base is set.`,
+		Example: `  apko rebase registry.example.com/app:latest --base cgr.dev/chainguard/wolfi-base:latest
+  apko rebase registry.example.com/app:latest --base cgr.dev/chainguard/wolfi-base:latest --tag registry.example.com/app:rebased --sbom-path ./sboms`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if newBase == "" {
+				return errors.New("--base is required")
+			}
+			dig, err := RebaseCmd(cmd.Context(), args[0], newBase, oldBase, tags, sbomPath,
+				remote.WithAuthFromKeychain(keychain.Default), remote.WithTransport(oci.RateLimitTransport(remote.DefaultTransport)))
+			if err != nil {
+				return err
+			}
+			fmt.Println(dig.String())
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&newBase, "base", "", "the base image to rebase onto")
+	cmd.Flags().StringVar(&oldBase, "old-base", "", "the base image the image is currently built on (defaults to the base image recorded in its annotations)")
+	cmd.Flags().StringSliceVarP(&tags, "tag", "t", []string{}, "tags to publish the rebased image as (defaults to the image reference)")
+	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "generate an SBOM for each rebased image in this directory")
+
+	return cmd
+}
+
+// RebaseCmd rebases the image (or each image of the index) at ref onto
+// newBase and publishes the result to tags, returning its digest.
+func RebaseCmd(ctx context.Context, ref, newBase, oldBase string, tags []string, sbomPath string, remoteOpts ...remote.Option) (name.Digest, error) {
+	log := clog.FromContext(ctx)
+
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("parsing image reference %q: %w", ref, err)
+	}
+	if len(tags) == 0 {
+		tags = []string{ref}
+	}
+	if sbomPath != "" {
+		if err := os.MkdirAll(sbomPath, 0o755); err != nil {
+			return name.Digest{}, fmt.Errorf("creating SBOM directory: %w", err)
+		}
+	}
+
+	remoteOpts = append(remoteOpts, remote.WithContext(ctx))
+	desc, err := remote.Get(r, remoteOpts...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("fetching image %s: %w", ref, err)
+	}
+
+	rb := &rebaser{
+		newBase:    newBase,
+		oldBase:    oldBase,
+		tags:       tags,
+		sbomPath:   sbomPath,
+		remoteOpts: remoteOpts,
+	}
+
+	if !desc.MediaType.IsIndex() {
+		img, err := desc.Image()
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("fetching image %s: %w", ref, err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("getting image config: %w", err)
+		}
+		platform := cfg.Platform()
+		if platform == nil {
+			return name.Digest{}, fmt.Errorf("image %s has no platform in its config", ref)
+		}
+		rebased, err := rb.rebase(ctx, img, platform)
+		if err != nil {
+			return name.Digest{}, err
+		}
+		return publishImage(ctx, rebased, tags, remoteOpts...)
+	}
+
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("fetching index %s: %w", ref, err)
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("getting index manifest: %w", err)
+	}
+
+	out := mutate.IndexMediaType(empty.Index, im.MediaType)
+	for _, m := range im.Manifests {
+		if !m.MediaType.IsImage() || m.Platform == nil {
+			log.Warnf("skipping index entry %s which is not an image", m.Digest)
+			continue
+		}
+		img, err := idx.Image(m.Digest)
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("fetching image %s: %w", m.Digest, err)
+		}
+		rebased, err := rb.rebase(ctx, img, m.Platform)
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("rebasing %s image: %w", m.Platform, err)
+		}
+		mt, err := rebased.MediaType()
+		if err != nil {
+			return name.Digest{}, err
+		}
+		out = mutate.AppendManifests(out, mutate.IndexAddendum{
+			Add: rebased,
+			Descriptor: v1.Descriptor{
+				MediaType:   mt,
+				Platform:    m.Platform,
+				Annotations: m.Annotations,
+			},
+		})
+	}
+	if len(im.Annotations) != 0 {
+		out = mutate.Annotations(out, im.Annotations).(v1.ImageIndex)
+	}
+
+	return oci.PublishIndex(ctx, out, tags, remoteOpts...)
+}
+
+type rebaser struct {
+	newBase    string
+	oldBase    string
+	tags       []string
+	sbomPath   string
+	remoteOpts []remote.Option
+}
+
+func (rb *rebaser) rebase(ctx context.Context, img v1.Image, platform *v1.Platform) (v1.Image, error) {
+	log := clog.FromContext(ctx)
+
+	oldRef := rb.oldBase
+	if oldRef == "" {
+		m, err := img.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("getting image manifest: %w", err)
+		}
+		baseName, baseDigest := m.Annotations[baseimg.AnnotationBaseName], m.Annotations[baseimg.AnnotationBaseDigest]
+		if baseName == "" || baseDigest == "" {
+			return nil, fmt.Errorf("image has no %s and %s annotations, set --old-base", baseimg.AnnotationBaseName, baseimg.AnnotationBaseDigest)
+		}
+		r, err := name.ParseReference(baseName)
+		if err != nil {
+			return nil, fmt.Errorf("parsing base image annotation %q: %w", baseName, err)
+		}
+		oldRef = r.Context().Digest(baseDigest).String()
+	}
+
+	oldImg, err := baseimg.FetchPlatform(ctx, oldRef, *platform, "", rb.remoteOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("fetching old base image: %w", err)
+	}
+	newImg, err := baseimg.FetchPlatform(ctx, rb.newBase, *platform, "", rb.remoteOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("fetching new base image: %w", err)
+	}
+
+	log.Infof("rebasing %s image from %s onto %s", platform, oldRef, rb.newBase)
+	rebased, err := baseimg.Rebase(ctx, img, oldImg, newImg, rb.newBase)
+	if err != nil {
+		return nil, err
+	}
+
+	if rb.sbomPath != "" {
+		if err := rb.generateSBOM(ctx, rebased, newImg, platform); err != nil {
+			return nil, fmt.Errorf("generating SBOM: %w", err)
+		}
+	}
+	return rebased, nil
+}
+
+// generateSBOM writes the SPDX SBOM of the rebased image, describing the
+// packages of its rewritten apk database and merging in the new base's SBOM.
+func (rb *rebaser) generateSBOM(ctx context.Context, img, newBase v1.Image, platform *v1.Platform) error {
+	arch := platformArch(platform)
+
+	fsys, err := sbomFS(img)
+	if err != nil {
+		return fmt.Errorf("reading image filesystem: %w", err)
+	}
+	pkgs, err := baseimg.Installed(img)
+	if err != nil {
+		return fmt.Errorf("reading apk database: %w", err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return err
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return err
+	}
+	h, err := img.Digest()
+	if err != nil {
+		return err
+	}
+
+	s := sbom.DefaultOptions
+	s.FS = fsys
+	s.OutputDir = rb.sbomPath
+	s.FileName = fmt.Sprintf("sbom-%s", arch.ToAPK())
+	s.Packages = pkgs
+	s.ImageInfo.Arch = arch
+	s.ImageInfo.ImageDigest = h.String()
+	s.ImageInfo.Layers = m.Layers
+	s.ImageInfo.ImageMediaType = ggcrtypes.OCIManifestSchema1
+	s.ImageInfo.SourceDateEpoch = cfg.Created.Time
+	if tag, err := name.NewTag(rb.tags[0]); err == nil {
+		s.ImageInfo.Tag = tag.TagStr()
+		s.ImageInfo.Name = tag.String()
+	}
+
+	s.OS.ID, s.OS.Name, s.OS.Version = "unknown", "apko-generated image", "unknown"
+	if f, err := fsys.Open("etc/os-release"); err == nil {
+		info, err := build.ParseReleaseData(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading release data: %w", err)
+		}
+		s.OS.ID, s.OS.Name, s.OS.Version = info.ID, info.Name, info.VersionID
+	}
+
+	baseDigest, err := newBase.Digest()
+	if err != nil {
+		return err
+	}
+	candidates, err := baseSBOMCandidates(ctx, rb.newBase, baseDigest, rb.remoteOpts...)
+	if err != nil {
+		return err
+	}
+	baseSBOM, err := baseimg.RemoteSBOM(ctx, candidates, rb.remoteOpts...)
+	if err != nil {
+		return fmt.Errorf("locating base image SBOM: %w", err)
+	}
+	if baseSBOM == nil {
+		clog.FromContext(ctx).Warnf("no SBOM found for base image %s, its packages will not be described in the SBOM", rb.newBase)
+	}
+	s.BaseImage = &soptions.BaseImageInfo{
+		Reference: rb.newBase,
+		Digest:    baseDigest.String(),
+		SBOM:      baseSBOM,
+	}
+
+	gen := spdx.New()
+	filename := filepath.Join(s.OutputDir, s.FileName+"."+gen.Ext())
+	if err := gen.Generate(ctx, &s, filename); err != nil {
+		return fmt.Errorf("generating %s sbom: %w", gen.Key(), err)
+	}
+	return nil
+}
+
+// baseSBOMCandidates returns the digests the SBOM of the base image at ref
+// may be attached to: the per-arch image, and the index it was selected from.
+func baseSBOMCandidates(ctx context.Context, ref string, h v1.Hash, opts ...remote.Option) ([]name.Digest, error) {
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base image reference %q: %w", ref, err)
+	}
+	candidates := []name.Digest{r.Context().Digest(h.String())}
+
+	desc, err := remote.Head(r, append(opts, remote.WithContext(ctx))...)
+	if err != nil {
+		return nil, fmt.Errorf("resolving base image %s: %w", ref, err)
+	}
+	if desc.Digest != h {
+		candidates = append(candidates, r.Context().Digest(desc.Digest.String()))
+	}
+	return candidates, nil
+}
+
+// sbomFS extracts the files of img the SBOM generator reads into memory: the
+// os-release file and the SBOMs that packages install.
+func sbomFS(img v1.Image) (apkfs.FullFS, error) {
+	fsys := apkfs.NewMemFS()
+
+	rc := mutate.Extract(img)
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fsys, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		p := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if p != "etc/os-release" && !strings.HasPrefix(p, "var/lib/db/sbom/") {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		if err := fsys.MkdirAll(path.Dir(p), 0o755); err != nil {
+			return nil, err
+		}
+		if err := fsys.WriteFile(p, data, hdr.FileInfo().Mode()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func publishImage(ctx context.Context, img v1.Image, tags []string, remoteOpts ...remote.Option) (name.Digest, error) {
+	log := clog.FromContext(ctx)
+
+	h, err := img.Digest()
+	if err != nil {
+		return name.Digest{}, err
+	}
+	var dig name.Digest
+	for i, tag := range tags {
+		ref, err := name.ParseReference(tag)
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("parsing tag %q: %w", tag, err)
+		}
+		if i == 0 {
+			dig = ref.Context().Digest(h.String())
+		}
+		log.Infof("publishing image tag %v", tag)
+		if err := remote.Write(ref, img, remoteOpts...); err != nil {
+			return name.Digest{}, &oci.PushError{Ref: ref.String(), Err: err}
+		}
+	}
+	return dig, nil
+}
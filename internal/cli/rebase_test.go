@@ -0,0 +1,203 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/internal/cli"
+	"chainguard.dev/apko/pkg/baseimg"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestRebase(t *testing.T) {
+	ctx := context.Background()
+
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	reg := strings.TrimPrefix(s.URL, "http://")
+
+	base, err := layout.ImageIndexFromPath(filepath.Join("testdata", "base_image"))
+	require.NoError(t, err)
+	baseRef, err := name.ParseReference(reg + "/base:v1")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(baseRef, base))
+
+	// Build an image on top of the base and push it.
+	var ic types.ImageConfiguration
+	require.NoError(t, ic.Load(ctx, filepath.Join("testdata", "image_on_top.apko.yaml"), []string{}, sha256.New())) //nolint:staticcheck
+	ic.Contents.BaseImage = &types.BaseImageDescriptor{Image: baseRef.String()}
+
+	out := t.TempDir()
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	opts := []build.Option{
+		build.WithImageConfiguration(ic),
+		build.WithLockFile(filepath.Join("testdata", "image_on_top.apko.lock.json")),
+		build.WithTempDir(t.TempDir()),
+	}
+	require.NoError(t, cli.BuildCmd(ctx, "app:latest", out, archs, []string{}, false, "", opts...))
+	app, err := layout.ImageIndexFromPath(out)
+	require.NoError(t, err)
+	appRef, err := name.ParseReference(reg + "/app:latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(appRef, app))
+
+	// The new base no longer installs package-y.
+	newBase := withoutPackage(t, base, "package-y")
+	newBaseRef, err := name.ParseReference(reg + "/base:v2")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(newBaseRef, newBase))
+
+	sbomDir := t.TempDir()
+	dig, err := cli.RebaseCmd(ctx, appRef.String(), newBaseRef.String(), "", []string{reg + "/app:rebased"}, sbomDir)
+	require.NoError(t, err)
+
+	rebased, err := remote.Index(dig)
+	require.NoError(t, err)
+	m, err := rebased.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, m.Manifests, 2)
+
+	for _, desc := range m.Manifests {
+		img, err := rebased.Image(desc.Digest)
+		require.NoError(t, err)
+
+		newBaseImg, err := remote.Image(newBaseRef, remote.WithPlatform(*desc.Platform))
+		require.NoError(t, err)
+		newBaseDigest, err := newBaseImg.Digest()
+		require.NoError(t, err)
+		newBaseLayers, err := newBaseImg.Layers()
+		require.NoError(t, err)
+
+		// The new base layers come first, followed by the apko layer.
+		layers, err := img.Layers()
+		require.NoError(t, err)
+		require.Len(t, layers, len(newBaseLayers)+1)
+		for i := range newBaseLayers {
+			want, err := newBaseLayers[i].Digest()
+			require.NoError(t, err)
+			got, err := layers[i].Digest()
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+		}
+
+		cfg, err := img.ConfigFile()
+		require.NoError(t, err)
+		require.Len(t, cfg.History, len(layers))
+		require.Len(t, cfg.RootFS.DiffIDs, len(layers))
+
+		mf, err := img.Manifest()
+		require.NoError(t, err)
+		require.Equal(t, newBaseRef.String(), mf.Annotations[baseimg.AnnotationBaseName])
+		require.Equal(t, newBaseDigest.String(), mf.Annotations[baseimg.AnnotationBaseDigest])
+
+		pkgs, err := baseimg.Installed(img)
+		require.NoError(t, err)
+		var names []string
+		for _, pkg := range pkgs {
+			names = append(names, pkg.Name)
+		}
+		require.ElementsMatch(t, []string{"pretend-baselayout", "package-x", "replayout"}, names)
+
+		arch := types.ParseArchitecture(desc.Platform.Architecture)
+		_, err = os.Stat(filepath.Join(sbomDir, "sbom-"+arch.ToAPK()+".spdx.json"))
+		require.NoError(t, err)
+	}
+}
+
+// withoutPackage returns a copy of base with pkg removed from the apk
+// database of each image.
+func withoutPackage(t *testing.T, base v1.ImageIndex, pkg string) v1.ImageIndex {
+	t.Helper()
+
+	m, err := base.IndexManifest()
+	require.NoError(t, err)
+
+	out := mutate.IndexMediaType(empty.Index, m.MediaType)
+	for _, desc := range m.Manifests {
+		img, err := base.Image(desc.Digest)
+		require.NoError(t, err)
+
+		db := readImageFile(t, img, "lib/apk/db/installed")
+		var kept []string
+		for e := range strings.SplitSeq(string(db), "\n\n") {
+			if e != "" && !strings.Contains(e, "\nP:"+pkg+"\n") && !strings.HasPrefix(e, "P:"+pkg+"\n") {
+				kept = append(kept, e)
+			}
+		}
+		data := []byte(strings.Join(kept, "\n\n") + "\n\n")
+
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: "lib/apk/db/installed", Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+		_, err = tw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+		})
+		require.NoError(t, err)
+		img, err = mutate.AppendLayers(img, layer)
+		require.NoError(t, err)
+
+		out = mutate.AppendManifests(out, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{MediaType: desc.MediaType, Platform: desc.Platform},
+		})
+	}
+	return out
+}
+
+func readImageFile(t *testing.T, img v1.Image, path string) []byte {
+	t.Helper()
+
+	rc := mutate.Extract(img)
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			t.Fatalf("%s not found in image", path)
+		}
+		require.NoError(t, err)
+		if strings.TrimPrefix(hdr.Name, "/") == path {
+			data, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			return data
+		}
+	}
+}
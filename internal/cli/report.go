@@ -0,0 +1,115 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chainguard-dev/clog"
+
+	"chainguard.dev/apko/pkg/report"
+)
+
+// reportOutputs are the outputs of the build report requested on the command
+// line.
+type reportOutputs struct {
+	// path is where to write the JSON report, from --report.
+	path string
+	// summary is where to write a summary of the timings, with --timings.
+	summary io.Writer
+	// github writes the GitHub Actions step outputs and summary, with
+	// --github-outputs, for the image tags.
+	github bool
+	tags   []string
+}
+
+// startReport returns a context that collects a build report, including the
+// warnings logged with it, and a function that writes the requested outputs
+// of the report, recording err as the error the build failed with, and
+// returns err. If no output is requested, no report is collected.
+//
+// GitHub Actions outputs are written to the files named by $GITHUB_OUTPUT and
+// $GITHUB_STEP_SUMMARY, when they are set.
+func startReport(ctx context.Context, out reportOutputs) (context.Context, func(err error) error) {
+	githubOutput, githubSummary := "", ""
+	if out.github {
+		githubOutput, githubSummary = os.Getenv("GITHUB_OUTPUT"), os.Getenv("GITHUB_STEP_SUMMARY")
+		if githubOutput == "" && githubSummary == "" {
+			clog.FromContext(ctx).Debugf("--github-outputs: GITHUB_OUTPUT and GITHUB_STEP_SUMMARY are not set, not writing GitHub Actions outputs")
+		}
+	}
+	if out.path == "" && out.summary == nil && githubOutput == "" && githubSummary == "" {
+		return ctx, func(err error) error { return err }
+	}
+
+	c := report.New()
+	ctx = report.WithCollector(ctx, c)
+	ctx = clog.WithLogger(ctx, clog.New(c.Handler(clog.FromContext(ctx).Handler())))
+
+	return ctx, func(err error) error {
+		r := c.Report()
+		if err != nil {
+			r.Error = err.Error()
+		}
+		if out.summary != nil {
+			if werr := r.WriteSummary(out.summary); werr != nil {
+				return errors.Join(err, fmt.Errorf("writing timing summary: %w", werr))
+			}
+		}
+		if out.path != "" {
+			if werr := r.Write(out.path); werr != nil {
+				return errors.Join(err, werr)
+			}
+		}
+		if githubOutput != "" {
+			if werr := appendFile(githubOutput, func(w io.Writer) error { return r.WriteGitHubOutputs(w, out.tags) }); werr != nil {
+				return errors.Join(err, fmt.Errorf("writing GitHub Actions outputs: %w", werr))
+			}
+		}
+		if githubSummary != "" {
+			if werr := appendFile(githubSummary, func(w io.Writer) error { return r.WriteGitHubSummary(w, out.tags) }); werr != nil {
+				return errors.Join(err, fmt.Errorf("writing GitHub Actions step summary: %w", werr))
+			}
+		}
+		return err
+	}
+}
+
+// appendFile appends what write writes to the file at path, creating it if
+// needed, as other steps of the job write to the same files.
+func appendFile(path string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// timingsWriter returns where to write the timing summary if it was requested
+// with --timings.
+func timingsWriter(enabled bool) io.Writer {
+	if !enabled {
+		return nil
+	}
+	return os.Stderr
+}
@@ -0,0 +1,53 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/report"
+)
+
+func TestStartReportGitHubOutputs(t *testing.T) {
+	dir := t.TempDir()
+	output, summary := filepath.Join(dir, "output"), filepath.Join(dir, "summary")
+	require.NoError(t, os.WriteFile(output, []byte("previous=step\n"), 0o644))
+	t.Setenv("GITHUB_OUTPUT", output)
+	t.Setenv("GITHUB_STEP_SUMMARY", summary)
+
+	ctx, finish := startReport(t.Context(), reportOutputs{github: true, tags: []string{"app:latest"}})
+	report.FromContext(ctx).SetIndex("sha256:abc")
+	buildErr := errors.New("failed")
+	require.Equal(t, buildErr, finish(buildErr))
+
+	b, err := os.ReadFile(output)
+	require.NoError(t, err)
+	require.Equal(t, "previous=step\ndigest=sha256:abc\ntags=app:latest\npackages={}\nerror=failed\n", string(b))
+	b, err = os.ReadFile(summary)
+	require.NoError(t, err)
+	require.Contains(t, string(b), "Index: `sha256:abc`")
+
+	// Outside of GitHub Actions nothing is collected.
+	t.Setenv("GITHUB_OUTPUT", "")
+	t.Setenv("GITHUB_STEP_SUMMARY", "")
+	ctx, finish = startReport(t.Context(), reportOutputs{github: true})
+	require.Nil(t, report.FromContext(ctx))
+	require.NoError(t, finish(nil))
+}
@@ -0,0 +1,142 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	purl "github.com/package-url/packageurl-go"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// The formats of the resolved packages written by `apko lock` and `apko
+// resolve`.
+const (
+	resolveFormatLock      = "lock"
+	resolveFormatPurl      = "purl"
+	resolveFormatCycloneDX = "cyclonedx"
+)
+
+// resolveFormatExtensions are the extensions of the default output files of
+// the formats other than the lock.
+var resolveFormatExtensions = map[string]string{
+	resolveFormatPurl:      "purls.txt",
+	resolveFormatCycloneDX: "cdx.json",
+}
+
+// packagePurl returns the purl of pkg, such as
+// "pkg:apk/wolfi/curl@8.11.0-r0?arch=x86_64", with the namespace omitted if
+// it is empty.
+func packagePurl(pkg *apk.RepositoryPackage, namespace string) string {
+	qualifiers := purl.Qualifiers{{Key: "arch", Value: pkg.Arch}}
+	if repo := pkg.Repository(); repo != nil && repo.Repository != nil {
+		qualifiers = append(qualifiers, purl.Qualifier{Key: "repository_url", Value: repo.URI})
+	}
+	return purl.NewPackageURL("apk", namespace, pkg.Name, pkg.Version, qualifiers, "").String()
+}
+
+// writePurls writes the purls of pkgs, one per line.
+func writePurls(w io.Writer, pkgs []*apk.RepositoryPackage, namespace string) error {
+	var b strings.Builder
+	for _, pkg := range pkgs {
+		b.WriteString(packagePurl(pkg, namespace) + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// cdxBOM is a CycloneDX document with only the components of the resolved
+// packages.
+type cdxBOM struct {
+	BOMFormat   string         `json:"bomFormat"`
+	SpecVersion string         `json:"specVersion"`
+	Version     int            `json:"version"`
+	Components  []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	BOMRef             string           `json:"bom-ref"`
+	Type               string           `json:"type"`
+	Name               string           `json:"name"`
+	Version            string           `json:"version"`
+	Description        string           `json:"description,omitempty"`
+	Purl               string           `json:"purl"`
+	Licenses           []cdxLicense     `json:"licenses,omitempty"`
+	ExternalReferences []cdxExternalRef `json:"externalReferences,omitempty"`
+}
+
+type cdxLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cdxExternalRef struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// writeCycloneDX writes pkgs as the components of a CycloneDX document.
+func writeCycloneDX(w io.Writer, pkgs []*apk.RepositoryPackage, namespace string) error {
+	bom := cdxBOM{BOMFormat: "CycloneDX", SpecVersion: "1.5", Version: 1, Components: []cdxComponent{}}
+	for _, pkg := range pkgs {
+		p := packagePurl(pkg, namespace)
+		c := cdxComponent{
+			BOMRef:      p,
+			Type:        "library",
+			Name:        pkg.Name,
+			Version:     pkg.Version,
+			Description: pkg.Description,
+			Purl:        p,
+		}
+		if pkg.License != "" {
+			c.Licenses = []cdxLicense{{Expression: pkg.License}}
+		}
+		if repo := pkg.Repository(); repo != nil && repo.Repository != nil {
+			c.ExternalReferences = []cdxExternalRef{{Type: "distribution", URL: pkg.URL()}}
+		}
+		bom.Components = append(bom.Components, c)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bom)
+}
+
+// writeResolved writes pkgs in format to output, or to stdout if it is "-".
+func writeResolved(output, format string, pkgs []*apk.RepositoryPackage, namespace string) error {
+	write := writePurls
+	switch format {
+	case resolveFormatPurl:
+	case resolveFormatCycloneDX:
+		write = writeCycloneDX
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	if output == "-" {
+		return write(os.Stdout, pkgs, namespace)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", output, err)
+	}
+	if err := write(f, pkgs, namespace); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", output, err)
+	}
+	return f.Close()
+}
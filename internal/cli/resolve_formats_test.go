@@ -0,0 +1,60 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+func TestResolveFormats(t *testing.T) {
+	repo := (&apk.Repository{URI: "https://packages.wolfi.dev/os/x86_64"}).WithIndex(&apk.APKIndex{})
+	pkgs := []*apk.RepositoryPackage{
+		apk.NewRepositoryPackage(&apk.Package{Name: "curl", Version: "8.11.0-r0", Arch: "x86_64", License: "curl", Description: "URL retrieval utility"}, repo),
+		apk.NewRepositoryPackage(&apk.Package{Name: "wolfi-baselayout", Version: "20230201-r15", Arch: "x86_64"}, nil),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writePurls(&buf, pkgs, "wolfi"))
+	require.Equal(t, "pkg:apk/wolfi/curl@8.11.0-r0?arch=x86_64&repository_url=https%3A%2F%2Fpackages.wolfi.dev%2Fos%2Fx86_64\n"+
+		"pkg:apk/wolfi/wolfi-baselayout@20230201-r15?arch=x86_64\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeCycloneDX(&buf, pkgs, ""))
+	var bom cdxBOM
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &bom))
+	require.Equal(t, "CycloneDX", bom.BOMFormat)
+	require.Equal(t, []cdxComponent{{
+		BOMRef:             "pkg:apk/curl@8.11.0-r0?arch=x86_64&repository_url=https%3A%2F%2Fpackages.wolfi.dev%2Fos%2Fx86_64",
+		Type:               "library",
+		Name:               "curl",
+		Version:            "8.11.0-r0",
+		Description:        "URL retrieval utility",
+		Purl:               "pkg:apk/curl@8.11.0-r0?arch=x86_64&repository_url=https%3A%2F%2Fpackages.wolfi.dev%2Fos%2Fx86_64",
+		Licenses:           []cdxLicense{{Expression: "curl"}},
+		ExternalReferences: []cdxExternalRef{{Type: "distribution", URL: "https://packages.wolfi.dev/os/x86_64/curl-8.11.0-r0.apk"}},
+	}, {
+		BOMRef:  "pkg:apk/wolfi-baselayout@20230201-r15?arch=x86_64",
+		Type:    "library",
+		Name:    "wolfi-baselayout",
+		Version: "20230201-r15",
+		Purl:    "pkg:apk/wolfi-baselayout@20230201-r15?arch=x86_64",
+	}}, bom.Components)
+}
@@ -0,0 +1,156 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/fsimage"
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/sbom/generator"
+)
+
+func rootfsCmd() *cobra.Command {
+	var buildDate string
+	var buildArch string
+	var sbomPath string
+	var extraKeys []string
+	var extraBuildRepos []string
+	var melangeWorkspace string
+	var extraRepos []string
+	var extraPackages []string
+	var cacheDir string
+	var offline bool
+	var lockfile string
+	var includePaths []string
+	var ignoreSignatures bool
+	var fips bool
+	var repositoryTLS repositoryTLSFlag
+	var sizeLimits options.SizeLimits
+
+	cmd := &cobra.Command{
+		Use:   "rootfs",
+		Short: "Build a root filesystem tarball from a YAML configuration file",
+		Long: `Build a root filesystem tarball from a YAML configuration file.
+
+Only the flattened root filesystem of the image is written, without the OCI image
+wrapping, so it can be unpacked directly for use with chroot, LXC or WSL. The
+compression is chosen from the extension of the output: .tar, .tar.gz (or .tgz)
+or .tar.zst (or .tzst).
+
+Pass "-" as the configuration file to read it from standard input.`,
+		Example: `  apko rootfs <config.yaml> <output.tar.zst>
+  apko rootfs --arch arm64 <config.yaml> <output.tar.gz>`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: completeConfigFile,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			compression, err := fsimage.TarballCompression(args[1])
+			if err != nil {
+				return err
+			}
+
+			var sbomGenerators []generator.Generator
+			if sbomPath != "" {
+				sbomGenerators = generator.Generators("spdx")
+			}
+
+			tmp, err := os.MkdirTemp(os.TempDir(), "apko-temp-*")
+			if err != nil {
+				return fmt.Errorf("creating tempdir: %w", err)
+			}
+			defer os.RemoveAll(tmp)
+
+			return RootFSCmd(cmd.Context(), args[1], types.ParseArchitecture(buildArch), sbomPath,
+				[]fsimage.Option{fsimage.WithCompression(compression)},
+				withConfig(args[0], includePaths),
+				build.WithBuildDate(buildDate),
+				build.WithSBOM(sbomPath),
+				build.WithSBOMGenerators(sbomGenerators...),
+				build.WithExtraKeys(extraKeys),
+				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
+				build.WithExtraRepos(extraRepos),
+				build.WithExtraPackages(extraPackages),
+				build.WithCache(cacheDir, offline, apk.NewCache(true)),
+				build.WithLockFile(lockfile),
+				build.WithTempDir(tmp),
+				build.WithIncludePaths(includePaths),
+				build.WithIgnoreSignatures(ignoreSignatures),
+				build.WithFIPS(fips),
+				build.WithRepositoryTLS(repositoryTLS...),
+				build.WithSizeLimits(sizeLimits),
+			)
+		},
+	}
+
+	cmd.Flags().StringVar(&buildDate, "build-date", "", "date used for the timestamps of the files inside the image in RFC3339 format")
+	cmd.Flags().StringVar(&buildArch, "arch", runtime.GOARCH, "architecture to build for -- default is Go runtime architecture")
+	cmd.Flags().StringVar(&sbomPath, "sbom-path", "", "generate an SBOM in dir")
+	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
+	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
+	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use the network to fetch packages, indexes, keys, advisory feeds or base images, which must all come from the cache or local files")
+	cmd.Flags().StringVar(&lockfile, "lockfile", "", "a path to .lock.json file (e.g. produced by apko lock) that constraints versions of packages to the listed ones (default '' means no additional constraints)")
+	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append.")
+	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().BoolVar(&fips, "fips", false, "restrict TLS and repository signature verification to FIPS-approved algorithms, failing on repositories only signed with non-approved ones")
+	addRepositoryTLSFlag(cmd, &repositoryTLS)
+	addClientLimitFlags(cmd, &sizeLimits)
+
+	return cmd
+}
+
+// RootFSCmd builds the image for arch and writes its flattened root
+// filesystem to the tarball output.
+func RootFSCmd(ctx context.Context, output string, arch types.Architecture, sbomPath string, fsOpts []fsimage.Option, opts ...build.Option) error {
+	wd, err := os.MkdirTemp("", "apko-*")
+	if err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(wd)
+
+	idx, sboms, err := buildImageComponents(ctx, wd, []types.Architecture{arch}, opts...)
+	if err != nil {
+		return err
+	}
+
+	m, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("getting index manifest: %w", err)
+	}
+	if len(m.Manifests) != 1 {
+		return fmt.Errorf("expected a single image for %s, got %d", arch, len(m.Manifests))
+	}
+	img, err := idx.Image(m.Manifests[0].Digest)
+	if err != nil {
+		return fmt.Errorf("getting %s image: %w", arch, err)
+	}
+	if err := fsimage.WriteTarball(ctx, img, output, fsOpts...); err != nil {
+		return err
+	}
+
+	return moveSBOMs(sboms, sbomPath)
+}
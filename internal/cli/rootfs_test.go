@@ -0,0 +1,74 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli_test
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/internal/cli"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/fsimage"
+	"chainguard.dev/apko/pkg/sbom/generator/spdx"
+)
+
+func TestRootFS(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	sbomPath := filepath.Join(tmp, "sboms")
+	require.NoError(t, os.MkdirAll(sbomPath, 0o750))
+	output := filepath.Join(tmp, "rootfs.tar.zst")
+
+	require.NoError(t, cli.RootFSCmd(ctx, output, types.ParseArchitecture("amd64"), sbomPath,
+		[]fsimage.Option{fsimage.WithCompression(fsimage.CompressionZstd)},
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithSBOM(sbomPath),
+		build.WithSBOMGenerators(spdx.New()),
+	))
+
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	require.NoError(t, err)
+	defer zr.Close()
+
+	files := map[string]bool{}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		files[hdr.Name] = true
+	}
+	require.True(t, files["etc/os-release"], "missing etc/os-release in %v", files)
+	require.True(t, files["usr/lib/apk/db/installed"], "missing apk database")
+
+	sboms, err := os.ReadDir(sbomPath)
+	require.NoError(t, err)
+	require.NotEmpty(t, sboms)
+}
@@ -31,6 +31,7 @@ import (
 func showConfig() *cobra.Command {
 	var extraKeys []string
 	var extraBuildRepos []string
+	var melangeWorkspace string
 	var extraRepos []string
 	var cacheDir string
 	var offline bool
@@ -42,13 +43,15 @@ func showConfig() *cobra.Command {
 
 The derived configuration is rendered in YAML.
 `,
-		Example: `  apko show-config <config.yaml>`,
-		Args:    cobra.ExactArgs(1),
+		Example:           `  apko show-config <config.yaml>`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeConfigFile,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return ShowConfigCmd(cmd.Context(),
 				build.WithConfig(args[0], []string{}),
 				build.WithExtraKeys(extraKeys),
 				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
 				build.WithExtraRepos(extraRepos),
 				build.WithCache(cacheDir, offline, apk.NewCache(true)),
 			)
@@ -57,9 +60,10 @@ The derived configuration is rendered in YAML.
 
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
-	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use the network to fetch packages, indexes, keys, advisory feeds or base images, which must all come from the cache or local files")
 
 	return cmd
 }
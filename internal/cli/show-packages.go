@@ -17,7 +17,9 @@ package cli
 import (
 	"context"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"text/template"
 
 	"github.com/spf13/cobra"
@@ -63,6 +65,7 @@ type pkgInfo struct {
 func showPackages() *cobra.Command {
 	var extraKeys []string
 	var extraBuildRepos []string
+	var melangeWorkspace string
 	var extraRepos []string
 	var archstrs []string
 	var format string
@@ -94,8 +97,9 @@ The default format is name-version.
 
 packagelock and packagelock-source are particularly useful for inserting back into a yaml list of packages.
 `,
-		Example: `  apko show-packages <config.yaml>`,
-		Args:    cobra.ExactArgs(1),
+		Example:           `  apko show-packages <config.yaml>`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeConfigFile,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			archs := types.ParseArchitectures(archstrs)
 			if t, ok := showPkgsFormats[format]; ok {
@@ -108,6 +112,7 @@ packagelock and packagelock-source are particularly useful for inserting back in
 				build.WithConfig(args[0], []string{}),
 				build.WithExtraKeys(extraKeys),
 				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
 				build.WithExtraRepos(extraRepos),
 				build.WithCache(cacheDir, offline, apk.NewCache(true)),
 			)
@@ -116,11 +121,13 @@ packagelock and packagelock-source are particularly useful for inserting back in
 
 	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
 	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
 	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
 	cmd.Flags().StringSliceVar(&archstrs, "arch", nil, "architectures to build for (e.g., x86_64,ppc64le,arm64) -- default is all, unless specified in config. Can also use 'host' to indicate arch of host this is running on")
 	cmd.Flags().StringVar(&format, "format", showPkgsFormatDefault, "format for showing packages; if pre-defined from list, will use that, else go template. See https://pkg.go.dev/text/template for more information. Available vars are `.Name`, `.Version`, `.Source`")
+	completeFlag(cmd, "format", cobra.FixedCompletions(slices.Sorted(maps.Keys(showPkgsFormats)), cobra.ShellCompDirectiveNoFileComp))
 	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
-	cmd.Flags().BoolVar(&offline, "offline", false, "do not use network to fetch packages (cache must be pre-populated)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use the network to fetch packages, indexes, keys, advisory feeds or base images, which must all come from the cache or local files")
 
 	return cmd
 }
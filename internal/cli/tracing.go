@@ -0,0 +1,151 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/release-utils/version"
+)
+
+// tracingShutdownTimeout bounds how long exiting waits for the remaining
+// spans to be exported.
+const tracingShutdownTimeout = 10 * time.Second
+
+// tracer exports the OpenTelemetry spans of a command over OTLP when an
+// endpoint is configured with the standard OTEL_EXPORTER_OTLP_* environment
+// variables. All spans of the command are children of a span for the command
+// itself, which in turn is a child of the trace in TRACEPARENT, if set.
+type tracer struct {
+	tp   *sdktrace.TracerProvider
+	span trace.Span
+}
+
+// tracingEnabled returns whether spans should be exported, following the
+// OpenTelemetry SDK environment variables.
+func tracingEnabled() bool {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		return false
+	}
+	if exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter != "" && exporter != "otlp" {
+		return false
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// newSpanExporter returns an OTLP exporter for the protocol selected by
+// OTEL_EXPORTER_OTLP_TRACES_PROTOCOL or OTEL_EXPORTER_OTLP_PROTOCOL. The
+// endpoint, headers, TLS and timeout settings are read from the environment
+// by the exporter itself.
+func newSpanExporter(ctx context.Context) (sdktrace.SpanExporter, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	switch protocol {
+	case "", "http/protobuf":
+		return otlptracehttp.New(ctx)
+	case "grpc":
+		return otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, expected grpc or http/protobuf", protocol)
+	}
+}
+
+// start installs the global tracer provider, if tracing is enabled, and
+// starts the span for cmd, returning the context to run it with.
+func (t *tracer) start(cmd *cobra.Command) (context.Context, error) {
+	ctx := cmd.Context()
+	if !tracingEnabled() {
+		return ctx, nil
+	}
+
+	exp, err := newSpanExporter(ctx)
+	if err != nil {
+		return ctx, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence.
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName("apko"),
+		semconv.ServiceVersion(version.GetVersionInfo().GitVersion),
+	))
+	if err != nil {
+		return ctx, fmt.Errorf("creating trace resource: %w", err)
+	}
+	if res, err = resource.Merge(res, resource.Environment()); err != nil {
+		return ctx, fmt.Errorf("creating trace resource: %w", err)
+	}
+
+	t.tp = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(t.tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	// Join the trace of whatever launched us, such as a CI pipeline.
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier{
+		"traceparent": os.Getenv("TRACEPARENT"),
+		"tracestate":  os.Getenv("TRACESTATE"),
+	})
+	ctx, t.span = otel.Tracer("apko").Start(ctx, cmd.CommandPath(),
+		trace.WithAttributes(attribute.StringSlice("flags", setFlags(cmd))))
+	return ctx, nil
+}
+
+// setFlags returns the names of the flags set on the command line of cmd. Their
+// values and the arguments are left out, since they may hold credentials, such
+// as those of repository URLs, that must not be exported.
+func setFlags(cmd *cobra.Command) []string {
+	var names []string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		names = append(names, "--"+f.Name)
+	})
+	return names
+}
+
+// stop ends the span of the command and flushes the remaining spans. It is
+// safe to call more than once, and runs even when the command fails.
+func (t *tracer) stop() {
+	if t.span != nil {
+		t.span.End()
+		t.span = nil
+	}
+	if t.tp == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := t.tp.Shutdown(ctx); err != nil {
+		slog.Error("exporting traces", "error", err)
+	}
+	t.tp = nil
+}
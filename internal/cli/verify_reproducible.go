@@ -0,0 +1,224 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/spf13/cobra"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/keychain"
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/reproducible"
+)
+
+func verifyReproducible() *cobra.Command {
+	var withVCS bool
+	var buildInfo bool
+	var buildDate string
+	var extraKeys []string
+	var extraBuildRepos []string
+	var melangeWorkspace string
+	var extraRepos []string
+	var extraPackages []string
+	var rawAnnotations []string
+	var cacheDir string
+	var offline bool
+	var includePaths []string
+	var ignoreSignatures bool
+	var fips bool
+	var repositoryTLS repositoryTLSFlag
+	var sizeLimits options.SizeLimits
+
+	cmd := &cobra.Command{
+		Use:   "verify-reproducible <config.yaml> <lock.json> <image@digest>",
+		Short: "Verify that a published image can be reproduced from its configuration and lockfile",
+		Long: `Verify that a published image can be reproduced from its configuration and lockfile.
+
+The image is rebuilt for each of the platforms of the published image, with the
+package versions pinned by the lockfile, and compared against the published image.
+If the digests differ, the first divergence is reported: the platform, then the
+layer and the file within it whose contents or metadata differ, or the config or
+manifest field that differs.
+
+The build flags must match the ones the image was published with, in particular
+--build-date, --annotations, --vcs and --build-info.`,
+		Example:           `  apko verify-reproducible apko.yaml apko.lock.json registry.example.com/app@sha256:...`,
+		Args:              cobra.ExactArgs(3),
+		ValidArgsFunction: completeConfigFile,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			annotations, err := parseAnnotations(rawAnnotations)
+			if err != nil {
+				return fmt.Errorf("parsing annotations from command line: %w", err)
+			}
+
+			tmp, err := os.MkdirTemp(os.TempDir(), "apko-temp-*")
+			if err != nil {
+				return fmt.Errorf("creating tempdir: %w", err)
+			}
+			defer os.RemoveAll(tmp)
+
+			transport := remote.DefaultTransport
+			if fips {
+				transport = apk.FIPSTransport(transport)
+			}
+			remoteOpts := []remote.Option{remote.WithAuthFromKeychain(keychain.Default), remote.WithTransport(oci.RateLimitTransport(transport))}
+			dig, err := VerifyReproducibleCmd(cmd.Context(), args[2], remoteOpts,
+				withConfig(args[0], includePaths),
+				build.WithLockFile(args[1]),
+				build.WithBuildDate(buildDate),
+				build.WithExtraKeys(extraKeys),
+				build.WithExtraBuildRepos(extraBuildRepos),
+				build.WithMelangeWorkspace(melangeWorkspace),
+				build.WithExtraRepos(extraRepos),
+				build.WithExtraPackages(extraPackages),
+				build.WithVCS(withVCS),
+				build.WithBuildInfo(buildInfo),
+				build.WithAnnotations(annotations),
+				build.WithCache(cacheDir, offline, apk.NewCache(true)),
+				build.WithTempDir(tmp),
+				build.WithIncludePaths(includePaths),
+				build.WithIgnoreSignatures(ignoreSignatures),
+				build.WithFIPS(fips),
+				build.WithRepositoryTLS(repositoryTLS...),
+				build.WithSizeLimits(sizeLimits),
+				build.WithKeychain(keychain.Default),
+			)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s reproduced\n", dig)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&withVCS, "vcs", true, "detect and embed VCS URLs, as apko publish does by default")
+	cmd.Flags().BoolVar(&buildInfo, "build-info", false, "embed a build-info document in each image, as apko build --build-info does")
+	cmd.Flags().StringVar(&buildDate, "build-date", "", "date used for the timestamps of the files inside the image in RFC3339 format")
+	cmd.Flags().StringSliceVarP(&extraKeys, "keyring-append", "k", []string{}, "path to extra keys to include in the keyring")
+	cmd.Flags().StringSliceVarP(&extraBuildRepos, "build-repository-append", "b", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringVar(&melangeWorkspace, "melange-workspace", "", "path to a melange workspace, or its packages directory, whose packages are added as a build repository along with the public keys found there")
+	cmd.Flags().StringSliceVarP(&extraRepos, "repository-append", "r", []string{}, "path to extra repositories to include")
+	cmd.Flags().StringSliceVarP(&extraPackages, "package-append", "p", []string{}, "extra packages to include")
+	cmd.Flags().StringSliceVar(&rawAnnotations, "annotations", []string{}, "OCI annotations to add. Separate with colon (key:value)")
+	cmd.Flags().StringVar(&cacheDir, "cache-dir", "", "directory to use for caching apk packages and indexes (default '' means to use system-defined cache directory)")
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not use the network to fetch packages, indexes, keys, advisory feeds or base images, which must all come from the cache or local files")
+	cmd.Flags().StringSliceVar(&includePaths, "include-paths", []string{}, "Additional include paths where to look for input files (config, base image, etc.). By default apko will search for paths only in workdir. Include paths may be absolute, or relative. Relative paths are interpreted relative to workdir. For adding extra paths for packages, use --repository-append.")
+	cmd.Flags().BoolVar(&ignoreSignatures, "ignore-signatures", false, "ignore repository signature verification")
+	cmd.Flags().BoolVar(&fips, "fips", false, "restrict TLS and repository signature verification to FIPS-approved algorithms, failing on repositories only signed with non-approved ones")
+	addRepositoryTLSFlag(cmd, &repositoryTLS)
+	addClientLimitFlags(cmd, &sizeLimits)
+
+	return cmd
+}
+
+// VerifyReproducibleCmd rebuilds the image published at ref for each of its
+// platforms and compares the result against it. It returns the digest of the
+// published image if the rebuild is identical, and a *reproducible.Divergence
+// describing the first difference otherwise.
+func VerifyReproducibleCmd(ctx context.Context, ref string, remoteOpts []remote.Option, opts ...build.Option) (name.Digest, error) {
+	log := clog.FromContext(ctx)
+
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("parsing image reference %q: %w", ref, err)
+	}
+	remoteOpts = append(remoteOpts, remote.WithContext(ctx))
+	desc, err := remote.Get(r, remoteOpts...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("fetching image %s: %w", ref, err)
+	}
+	dig := r.Context().Digest(desc.Digest.String())
+
+	var archs []types.Architecture
+	var publishedIdx v1.ImageIndex
+	var publishedImg v1.Image
+	if desc.MediaType.IsIndex() {
+		publishedIdx, err = desc.ImageIndex()
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("fetching index %s: %w", ref, err)
+		}
+		m, err := publishedIdx.IndexManifest()
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("getting index manifest: %w", err)
+		}
+		for _, d := range m.Manifests {
+			if d.Platform != nil {
+				archs = append(archs, platformArch(d.Platform))
+			}
+		}
+	} else {
+		publishedImg, err = desc.Image()
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("fetching image %s: %w", ref, err)
+		}
+		cfg, err := publishedImg.ConfigFile()
+		if err != nil {
+			return name.Digest{}, fmt.Errorf("getting image config: %w", err)
+		}
+		platform := cfg.Platform()
+		if platform == nil {
+			return name.Digest{}, fmt.Errorf("image %s has no platform in its config", ref)
+		}
+		archs = append(archs, platformArch(platform))
+	}
+	if len(archs) == 0 {
+		return name.Digest{}, fmt.Errorf("image %s has no platforms to rebuild", ref)
+	}
+
+	wd, err := os.MkdirTemp("", "apko-*")
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(wd)
+
+	log.Infof("rebuilding %s for %v", dig, archs)
+	idx, _, err := buildImageComponents(ctx, wd, archs, opts...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("rebuilding image: %w", err)
+	}
+
+	var d *reproducible.Divergence
+	if publishedIdx != nil {
+		d, err = reproducible.CompareIndex(ctx, publishedIdx, idx)
+	} else {
+		m, merr := idx.IndexManifest()
+		if merr != nil {
+			return name.Digest{}, fmt.Errorf("getting rebuilt index manifest: %w", merr)
+		}
+		img, ierr := idx.Image(m.Manifests[0].Digest)
+		if ierr != nil {
+			return name.Digest{}, fmt.Errorf("getting rebuilt image: %w", ierr)
+		}
+		d, err = reproducible.CompareImage(ctx, publishedImg, img)
+	}
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("comparing against %s: %w", dig, err)
+	}
+	if d != nil {
+		return name.Digest{}, d
+	}
+	return dig, nil
+}
@@ -0,0 +1,73 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/internal/cli"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/reproducible"
+)
+
+func TestVerifyReproducible(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+	repo := fmt.Sprintf("%s/test/verify", strings.TrimPrefix(s.URL, "http://"))
+
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithLockFile(filepath.Join("testdata", "apko.lock.json")),
+	}
+	archs := types.ParseArchitectures([]string{"amd64", "arm64"})
+	require.NoError(t, cli.BuildCmd(ctx, repo+":latest", tmp, archs, nil, false, "", opts...))
+
+	idx, err := layout.ImageIndexFromPath(tmp)
+	require.NoError(t, err)
+	want, err := idx.Digest()
+	require.NoError(t, err)
+	tag, err := name.NewTag(repo + ":latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.WriteIndex(tag, idx))
+	ref := tag.Context().Digest(want.String()).String()
+
+	dig, err := cli.VerifyReproducibleCmd(ctx, ref, nil, opts...)
+	require.NoError(t, err)
+	require.Equal(t, ref, dig.String())
+
+	// A different build date changes the creation time in the config.
+	_, err = cli.VerifyReproducibleCmd(ctx, ref, nil, append(opts, build.WithBuildDate("2030-01-01T00:00:00Z"))...)
+	var d *reproducible.Divergence
+	require.True(t, errors.As(err, &d), "expected a divergence, got %v", err)
+	require.Equal(t, "linux/amd64", d.Platform)
+	require.Equal(t, -1, d.Layer)
+	require.Contains(t, d.Reason, "org.opencontainers.image.created")
+}
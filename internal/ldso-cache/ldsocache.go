@@ -352,8 +352,10 @@ func LDSOCacheEntriesForDirs(fsys fs.FS, libdirs []string) ([]LDSOCacheEntry, er
 	}
 
 	// ld expects entries to be reverse-sorted by name. Otherwise
-	// it may report "No such file or directory"
-	sort.Slice(allEntries, func(i, j int) bool {
+	// it may report "No such file or directory". Libraries of the same
+	// name keep the order of their directories, the first of which ld
+	// loads.
+	sort.SliceStable(allEntries, func(i, j int) bool {
 		return filepath.Base(allEntries[j].Name) < filepath.Base(allEntries[i].Name)
 	})
 
@@ -628,17 +630,25 @@ func ParseLDSOConf(fsys fs.FS, ldsoconf string) ([]string, error) {
 					debugf("Warning: Could not parse config file %s\n", match)
 					continue
 				}
-				libpaths = append(libpaths, incpaths...)
+				for _, libpath := range incpaths {
+					libpaths = appendLibPath(libpaths, libpath)
+				}
 			}
-			return libpaths, nil
-		}
-
-		libpath := line
-		if slices.Contains(libpaths, libpath) {
-			debugf("Warning: Skipping %s because we've already seen it\n", libpath)
+			// Keep going: the directories after an include are searched
+			// too, as ldconfig does.
 			continue
 		}
-		libpaths = append(libpaths, libpath)
+
+		libpaths = appendLibPath(libpaths, line)
 	}
 	return libpaths, scanner.Err()
 }
+
+// appendLibPath appends libpath to libpaths, unless it is already there.
+func appendLibPath(libpaths []string, libpath string) []string {
+	if slices.Contains(libpaths, libpath) {
+		debugf("Warning: Skipping %s because we've already seen it\n", libpath)
+		return libpaths
+	}
+	return append(libpaths, libpath)
+}
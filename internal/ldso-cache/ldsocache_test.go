@@ -129,6 +129,13 @@ func Test_ParseLDSOConf_Glob(t *testing.T) {
 	require.Contains(t, dirs, "/b/libs")
 }
 
+func Test_ParseLDSOConf_AfterInclude(t *testing.T) {
+	fsys := os.DirFS("testdata")
+	dirs, err := ParseLDSOConf(fsys, "ld.so.conf.mixed")
+	require.NoError(t, err)
+	require.Equal(t, []string{"/opt/first/lib", "/a/libs", "/b/libs", "/opt/last/lib"}, dirs)
+}
+
 // Instead of real ELF binaries, our "libraries" are YAML files
 // that are used to populate an elfInfo structure.
 func mockGetElfInfo(r io.ReaderAt) (elfInfo, error) {
@@ -82,6 +82,9 @@ type APKIndex struct { //nolint:revive
 	Signature   []byte
 	Description string
 	Packages    []*Package
+	// Digest is the sha256 digest of the signed index archive, when it was
+	// fetched from a repository.
+	Digest string
 }
 
 // Splitting empty string results in single element array with one empty string, which would
@@ -21,10 +21,13 @@ func ArchToAPK(in string) string {
 		return "x86_64"
 	case "arm64":
 		return "aarch64"
-	case "arm/v6":
+	case "arm/v6", "armv6", "armv6l":
 		return "armhf"
-	case "arm/v7":
+	case "arm/v7", "armv7l", "arm":
+		// A bare arm is arm/v7, as in OCI platforms.
 		return "armv7"
+	case "loong64":
+		return "loongarch64"
 	default:
 		return in
 	}
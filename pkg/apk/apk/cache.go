@@ -181,7 +181,7 @@ func (t *cacheTransport) RoundTrip(request *http.Request) (*http.Response, error
 		f, err := os.Open(cacheFile)
 		if err != nil {
 			if t.offline {
-				return nil, fmt.Errorf("failed to read %q in offline cache: %w", cacheFile, err)
+				return nil, fmt.Errorf("%w: %w", ErrOffline, err)
 			}
 
 			_, span := otel.Tracer("go-apk").Start(ctx, fmt.Sprintf("Request(%q)", request.URL.String()))
@@ -198,7 +198,11 @@ func (t *cacheTransport) RoundTrip(request *http.Request) (*http.Response, error
 	}
 
 	if t.offline {
-		return t.fetchOffline(cacheFile)
+		resp, err := t.fetchOffline(cacheFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrOffline, err)
+		}
+		return resp, nil
 	}
 
 	return t.fetchAndCache(ctx, request, cacheFile)
@@ -299,6 +303,7 @@ func (t *cacheTransport) fetchAndCache(ctx context.Context, request *http.Reques
 	if err != nil {
 		return nil, fmt.Errorf("open(%q): %w", etagFile, err)
 	}
+	touchCacheEntry(etagFile)
 
 	fi, err := f.Stat()
 	if err != nil {
@@ -471,7 +476,7 @@ func (t *cacheTransport) retrieveAndSaveFile(ctx context.Context, request *http.
 	return cacheFile, nil
 }
 
-func cacheDirForPackage(root string, pkg InstallablePackage) (string, error) {
+func cacheDirForPackage(root string, pkg LocatablePackage) (string, error) {
 	u, err := packageAsURL(pkg)
 	if err != nil {
 		return "", err
@@ -489,6 +494,12 @@ func cacheDirForPackage(root string, pkg InstallablePackage) (string, error) {
 	return strings.TrimSuffix(p, ".apk"), nil
 }
 
+// PackageCacheDir returns the directory of the package cache in root that
+// pkg is expanded into.
+func PackageCacheDir(root string, pkg LocatablePackage) (string, error) {
+	return cacheDirForPackage(root, pkg)
+}
+
 // cachePathFromURL given a URL, figure out what the cache path would be
 func cachePathFromURL(root string, u url.URL) (string, error) {
 	// the last two levels are what we append. For example https://example.com/foo/bar/x86_64/baz.apk
@@ -0,0 +1,259 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// CachePrunePolicy selects the entries of a cache to remove: those not used
+// for longer than MaxAge, and then the least recently used ones until the
+// cache is no larger than MaxSize. Zero values disable each.
+type CachePrunePolicy struct {
+	MaxAge  time.Duration
+	MaxSize int64
+	// DryRun only reports what would be removed.
+	DryRun bool
+}
+
+// CacheStats summarizes entries of a cache.
+type CacheStats struct {
+	Entries int
+	Size    int64
+	// Oldest and Newest are the least and most recent uses of the entries.
+	Oldest time.Time
+	Newest time.Time
+}
+
+// Add counts an entry of size last used at used.
+func (s *CacheStats) Add(size int64, used time.Time) {
+	s.Entries++
+	s.Size += size
+	if s.Oldest.IsZero() || used.Before(s.Oldest) {
+		s.Oldest = used
+	}
+	if used.After(s.Newest) {
+		s.Newest = used
+	}
+}
+
+// cacheEntry is a package, or another file, of the package cache, along with
+// the files its symlinks advertise.
+type cacheEntry struct {
+	path  string
+	paths []string
+	size  int64
+	used  time.Time
+}
+
+func (e *cacheEntry) merge(o *cacheEntry) {
+	e.paths = append(e.paths, o.paths...)
+	e.size += o.size
+	if o.used.After(e.used) {
+		e.used = o.used
+	}
+}
+
+// packageFileSuffixes are the suffixes of the files of the expanded packages
+// of the cache, which are kept together in a directory per package.
+var packageFileSuffixes = []string{".ctl.tar.gz", ".sig.tar.gz", ".dat.tar.gz", ".dat.tar"}
+
+// cacheEntries lists the entries of the package cache in dir, skipping the
+// directories of skip, such as a layer cache within it.
+func cacheEntries(dir string, skip ...string) ([]cacheEntry, error) {
+	byPath := map[string]*cacheEntry{}
+	// entryOf is the entry of each file.
+	entryOf := map[string]string{}
+	links := map[string]string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if slices.Contains(skip, path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		symlink := fi.Mode()&fs.ModeSymlink != 0
+		if symlink {
+			// Cached files are advertised by symlinks to where they were
+			// downloaded or expanded, and go with them.
+			if target, err := os.Readlink(path); err == nil {
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(filepath.Dir(path), target)
+				}
+				links[path] = target
+			}
+		}
+		// Package files are removed together, with their directory.
+		entry := path
+		if slices.ContainsFunc(packageFileSuffixes, func(suffix string) bool { return strings.HasSuffix(path, suffix) }) {
+			entry = filepath.Dir(path)
+		}
+		entryOf[path] = entry
+		e, ok := byPath[entry]
+		if !ok {
+			e = &cacheEntry{path: entry, paths: []string{entry}}
+			byPath[entry] = e
+			if entry != path {
+				// Using a cached package touches its directory.
+				if di, err := os.Stat(entry); err == nil {
+					e.used = di.ModTime()
+				}
+			}
+		}
+		if symlink {
+			// Only their targets are touched when used.
+			return nil
+		}
+		e.size += fi.Size()
+		if fi.ModTime().After(e.used) {
+			e.used = fi.ModTime()
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// find returns the entry of path, after merging.
+	mergedInto := map[string]string{}
+	find := func(path string) *cacheEntry {
+		entry := entryOf[path]
+		for {
+			next, ok := mergedInto[entry]
+			if !ok {
+				return byPath[entry]
+			}
+			entry = next
+		}
+	}
+	for link, target := range links {
+		from, to := find(target), find(link)
+		if from == nil || to == nil || from == to {
+			continue
+		}
+		to.merge(from)
+		delete(byPath, from.path)
+		mergedInto[from.path] = to.path
+	}
+
+	entries := make([]cacheEntry, 0, len(byPath))
+	for _, e := range byPath {
+		entries = append(entries, *e)
+	}
+	// Least recently used first.
+	slices.SortFunc(entries, func(a, b cacheEntry) int {
+		if c := a.used.Compare(b.used); c != 0 {
+			return c
+		}
+		return strings.Compare(a.path, b.path)
+	})
+	return entries, nil
+}
+
+// CacheUsage summarizes the packages and indexes of the package cache in dir,
+// skipping the directories of skip.
+func CacheUsage(dir string, skip ...string) (CacheStats, error) {
+	entries, err := cacheEntries(dir, skip...)
+	if err != nil {
+		return CacheStats{}, err
+	}
+	var stats CacheStats
+	for _, e := range entries {
+		stats.Add(e.size, e.used)
+	}
+	return stats, nil
+}
+
+// PruneCache removes the packages and indexes of the package cache in dir
+// that policy selects, skipping the directories of skip, and returns what it
+// removed. Packages count as used when a build finds them in the cache, and
+// indexes when they are downloaded or found again.
+func PruneCache(ctx context.Context, dir string, policy CachePrunePolicy, skip ...string) (CacheStats, error) {
+	log := clog.FromContext(ctx)
+	entries, err := cacheEntries(dir, skip...)
+	if err != nil {
+		return CacheStats{}, err
+	}
+
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+	now := time.Now()
+	var removed CacheStats
+	for _, e := range entries {
+		expired := policy.MaxAge > 0 && now.Sub(e.used) > policy.MaxAge
+		oversized := policy.MaxSize > 0 && size > policy.MaxSize
+		if !expired && !oversized {
+			// The rest are more recently used.
+			break
+		}
+		log.Debugf("removing %s, last used %s", e.path, e.used.Format(time.RFC3339))
+		if !policy.DryRun {
+			for _, p := range e.paths {
+				if err := os.RemoveAll(p); err != nil {
+					return removed, fmt.Errorf("removing %s: %w", p, err)
+				}
+			}
+		}
+		size -= e.size
+		removed.Add(e.size, e.used)
+	}
+	if !policy.DryRun {
+		removeEmptyDirs(dir)
+	}
+	return removed, nil
+}
+
+// removeEmptyDirs removes the directories under dir that are left empty.
+func removeEmptyDirs(dir string) {
+	var dirs []string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && path != dir {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	// Children come after their parents, so remove them first.
+	for _, d := range slices.Backward(dirs) {
+		_ = os.Remove(d)
+	}
+}
+
+// touchCacheEntry records that the cached file or package directory at path
+// was used, for PruneCache.
+func touchCacheEntry(path string) {
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+}
@@ -0,0 +1,110 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPruneCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	repo := "https%3A%2F%2Fpackages.wolfi.dev%2Fos/x86_64"
+	oldPackage := filepath.Join(repo, "old-1.0-r0")
+	newPackage := filepath.Join(repo, "new-1.0-r0")
+	index := filepath.Join(repo, "APKINDEX", "ETAG.tar.gz")
+
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		write := func(path string, size int, age time.Duration) {
+			path = filepath.Join(dir, path)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+			require.NoError(t, os.WriteFile(path, make([]byte, size), 0o644))
+			require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+		}
+		for _, suffix := range packageFileSuffixes {
+			write(filepath.Join(oldPackage, "abc"+suffix), 100, 48*time.Hour)
+			write(filepath.Join(newPackage, "def"+suffix), 100, 48*time.Hour)
+		}
+		for _, pkg := range []string{oldPackage, newPackage} {
+			require.NoError(t, os.Chtimes(filepath.Join(dir, pkg), now.Add(-48*time.Hour), now.Add(-48*time.Hour)))
+		}
+		// The new package was used since it was cached.
+		touchCacheEntry(filepath.Join(dir, newPackage))
+		// Indexes are advertised by a symlink to where they were downloaded.
+		write(filepath.Join(filepath.Dir(index), "123.tmp"), 100, time.Hour)
+		require.NoError(t, os.Symlink("123.tmp", filepath.Join(dir, index)))
+		write(filepath.Join("layers", "entries", "key.json"), 100, 72*time.Hour)
+		return dir
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	t.Run("usage", func(t *testing.T) {
+		dir := setup(t)
+		stats, err := CacheUsage(dir, filepath.Join(dir, "layers"))
+		require.NoError(t, err)
+		require.Equal(t, 3, stats.Entries)
+		require.Equal(t, int64(900), stats.Size)
+		require.True(t, stats.Oldest.Equal(now.Add(-48*time.Hour)), stats.Oldest)
+		require.False(t, stats.Newest.Before(now), stats.Newest)
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		dir := setup(t)
+		removed, err := PruneCache(ctx, dir, CachePrunePolicy{MaxAge: 24 * time.Hour}, filepath.Join(dir, "layers"))
+		require.NoError(t, err)
+		require.Equal(t, 1, removed.Entries)
+		require.Equal(t, int64(400), removed.Size)
+
+		// Packages are removed along with their directory.
+		require.False(t, exists(filepath.Join(dir, oldPackage)))
+		require.True(t, exists(filepath.Join(dir, newPackage)))
+		require.True(t, exists(filepath.Join(dir, index)))
+		require.True(t, exists(filepath.Join(dir, "layers", "entries", "key.json")))
+	})
+
+	t.Run("max size", func(t *testing.T) {
+		dir := setup(t)
+		removed, err := PruneCache(ctx, dir, CachePrunePolicy{MaxSize: 450}, filepath.Join(dir, "layers"))
+		require.NoError(t, err)
+		require.Equal(t, 2, removed.Entries)
+
+		// The index was used less recently than the new package.
+		require.False(t, exists(filepath.Join(dir, oldPackage)))
+		require.False(t, exists(filepath.Join(dir, index)))
+		require.False(t, exists(filepath.Join(dir, repo, "APKINDEX", "123.tmp")))
+		require.False(t, exists(filepath.Join(dir, repo, "APKINDEX")))
+		require.True(t, exists(filepath.Join(dir, newPackage)))
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		dir := setup(t)
+		removed, err := PruneCache(ctx, dir, CachePrunePolicy{MaxAge: time.Minute, DryRun: true}, filepath.Join(dir, "layers"))
+		require.NoError(t, err)
+		require.Equal(t, 2, removed.Entries)
+		require.True(t, exists(filepath.Join(dir, oldPackage)))
+		require.True(t, exists(filepath.Join(dir, index)))
+	})
+}
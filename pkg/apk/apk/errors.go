@@ -17,8 +17,58 @@ package apk
 import (
 	"errors"
 	"fmt"
+	"net/http"
 )
 
+// Sentinel errors for common failures. They are wrapped by the more detailed
+// errors returned from this package, so callers should match them with
+// errors.Is rather than comparing error strings.
+var (
+	// ErrPackageNotFound is returned when no repository provides a requested
+	// package (or a dependency of one).
+	ErrPackageNotFound = errors.New("package not found")
+
+	// ErrUnsatisfiableConstraint is returned when a package constraint, e.g.
+	// "foo>2.0", cannot be satisfied by the configured repositories.
+	ErrUnsatisfiableConstraint = errors.New("unsatisfiable constraint")
+
+	// ErrSignatureVerification is returned when a repository index is not
+	// signed by any of the provided keys.
+	ErrSignatureVerification = errors.New("signature verification failed")
+
+	// ErrAuthRequired is returned when a repository rejects a request as
+	// unauthenticated or unauthorized.
+	ErrAuthRequired = errors.New("authentication required")
+)
+
+// PackageNotFoundError is returned when nothing provides the named package.
+// It matches ErrPackageNotFound.
+type PackageNotFoundError struct {
+	Name string
+}
+
+func (e *PackageNotFoundError) Error() string {
+	return fmt.Sprintf("nothing provides %q", e.Name)
+}
+
+func (e *PackageNotFoundError) Is(target error) bool {
+	return target == ErrPackageNotFound
+}
+
+// HTTPStatusError is returned when a repository responds with an unexpected
+// HTTP status. 401 and 403 responses match ErrAuthRequired.
+type HTTPStatusError struct {
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.StatusCode)
+}
+
+func (e *HTTPStatusError) Is(target error) bool {
+	return target == ErrAuthRequired && (e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden)
+}
+
 type FileExistsError struct {
 	Path string
 	Sha1 []byte
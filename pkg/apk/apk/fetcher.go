@@ -0,0 +1,109 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Fetcher retrieves remote resources by URL. When configured with WithFetcher,
+// it is used for everything that would otherwise be downloaded over http(s):
+// APKINDEX archives, packages and keys. This makes it possible to add bespoke
+// authentication, route through a caching proxy, or replay recorded responses
+// in hermetic tests.
+//
+// Fetch should return an error wrapping fs.ErrNotExist if the resource does not exist.
+type Fetcher interface {
+	Fetch(ctx context.Context, u *url.URL) (io.ReadCloser, error)
+}
+
+// FetcherFunc adapts a function to a Fetcher.
+type FetcherFunc func(ctx context.Context, u *url.URL) (io.ReadCloser, error)
+
+// Fetch implements Fetcher.
+func (f FetcherFunc) Fetch(ctx context.Context, u *url.URL) (io.ReadCloser, error) {
+	return f(ctx, u)
+}
+
+// DirFetcher returns a Fetcher that serves resources from files laid out as
+// <dir>/<host>/<path>, e.g. a previously recorded set of repositories.
+func DirFetcher(dir string) Fetcher {
+	return FetcherFunc(func(_ context.Context, u *url.URL) (io.ReadCloser, error) {
+		p := filepath.Join(dir, u.Host, filepath.FromSlash(strings.TrimPrefix(u.Path, "/")))
+		if rel, err := filepath.Rel(dir, p); err != nil || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("%s escapes %s: %w", u.Redacted(), dir, fs.ErrNotExist)
+		}
+		return os.Open(p)
+	})
+}
+
+// WithFetcher routes all http(s) retrieval through f instead of the network.
+// It replaces any transport set with WithTransport.
+func WithFetcher(f Fetcher) Option {
+	return WithTransport(NewFetcherTransport(f))
+}
+
+// NewFetcherTransport returns an http.RoundTripper that answers GET and HEAD
+// requests using f.
+func NewFetcherTransport(f Fetcher) http.RoundTripper {
+	return &fetcherTransport{f: f}
+}
+
+type fetcherTransport struct {
+	f Fetcher
+}
+
+func (t *fetcherTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("fetcher transport: unsupported method %s", req.Method)
+	}
+
+	resp := &http.Response{
+		Request:    req,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       http.NoBody,
+	}
+
+	rc, err := t.f.Fetch(req.Context(), req.URL)
+	if errors.Is(err, fs.ErrNotExist) {
+		resp.StatusCode = http.StatusNotFound
+		resp.Status = http.StatusText(http.StatusNotFound)
+		return resp, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	resp.StatusCode = http.StatusOK
+	resp.Status = http.StatusText(http.StatusOK)
+	resp.ContentLength = -1
+	if req.Method == http.MethodHead {
+		rc.Close()
+	} else {
+		resp.Body = rc
+	}
+	return resp, nil
+}
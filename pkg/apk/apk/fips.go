@@ -0,0 +1,84 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// fipsMinRSAKeyBits is the smallest RSA modulus approved for verifying
+// signatures by NIST SP 800-131A.
+const fipsMinRSAKeyBits = 2048
+
+// FIPSTLSConfig returns a copy of base, which may be nil, restricted to the
+// FIPS-approved TLS versions, cipher suites and key exchange curves.
+//
+// The TLS 1.3 cipher suites cannot be configured; running with
+// GODEBUG=fips140=on restricts those as well.
+func FIPSTLSConfig(base *tls.Config) *tls.Config {
+	c := &tls.Config{}
+	if base != nil {
+		c = base.Clone()
+	}
+	c.MinVersion = tls.VersionTLS12
+	c.CipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	c.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+	return c
+}
+
+// FIPSTransport returns a copy of rt whose TLS configuration is restricted by
+// FIPSTLSConfig. Only an *http.Transport can be configured; any other round
+// tripper, such as one serving requests from a Fetcher, is returned as is.
+func FIPSTransport(rt http.RoundTripper) http.RoundTripper {
+	t, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+	t = t.Clone()
+	t.TLSClientConfig = FIPSTLSConfig(t.TLSClientConfig)
+	return t
+}
+
+// checkFIPSKey returns an error if the PEM-encoded public key cannot be used
+// to verify signatures in FIPS mode.
+func checkFIPSKey(key []byte) error {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return errors.New("no PEM block found")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parsing public key: %w", err)
+	}
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	if bits := rsaPub.N.BitLen(); bits < fipsMinRSAKeyBits {
+		return fmt.Errorf("%d-bit RSA key is smaller than the %d bits FIPS mode requires", bits, fipsMinRSAKeyBits)
+	}
+	return nil
+}
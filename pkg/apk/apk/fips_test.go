@@ -0,0 +1,84 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFIPSSignatures(t *testing.T) {
+	keys := map[string][]byte{}
+	for k, v := range testKeys {
+		keys[k] = []byte(v)
+	}
+	parse := func(dir string, fips bool) error {
+		b, err := os.ReadFile(filepath.Join(dir, indexFilename))
+		require.NoError(t, err)
+		_, err = parseRepositoryIndex(t.Context(), IndexURL(dir, testArch), keys, testArch, b, &indexOpts{fips: fips})
+		return err
+	}
+
+	// The Alpine index is only signed with RSA over SHA-1.
+	require.NoError(t, parse(testPrimaryPkgDir, false))
+	err := parse(testPrimaryPkgDir, true)
+	require.ErrorIs(t, err, ErrSignatureVerification)
+	require.ErrorContains(t, err, "no FIPS-approved signature found in repository index: .SIGN.RSA.alpine-devel@lists.alpinelinux.org-6165ee59.rsa.pub: SHA-1 digest is not allowed")
+
+	require.NoError(t, parse(testRSA256IndexPkgDir, true))
+}
+
+func TestCheckFIPSKey(t *testing.T) {
+	pemKey := func(bits int) []byte {
+		k, err := rsa.GenerateKey(rand.Reader, bits)
+		require.NoError(t, err)
+		b, err := x509.MarshalPKIXPublicKey(&k.PublicKey)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b})
+	}
+
+	require.NoError(t, checkFIPSKey(pemKey(2048)))
+	require.ErrorContains(t, checkFIPSKey(pemKey(1024)), "1024-bit RSA key is smaller than the 2048 bits FIPS mode requires")
+	require.ErrorContains(t, checkFIPSKey([]byte("not a key")), "no PEM block found")
+}
+
+func TestFIPSTransport(t *testing.T) {
+	base := &http.Transport{TLSClientConfig: &tls.Config{ServerName: "example.com"}}
+	rt := FIPSTransport(base)
+	tr, ok := rt.(*http.Transport)
+	require.True(t, ok)
+	require.NotSame(t, base, tr)
+	require.Equal(t, "example.com", tr.TLSClientConfig.ServerName)
+	require.Equal(t, uint16(tls.VersionTLS12), tr.TLSClientConfig.MinVersion)
+	require.Equal(t, []tls.CurveID{tls.CurveP256, tls.CurveP384}, tr.TLSClientConfig.CurvePreferences)
+	for _, id := range tr.TLSClientConfig.CipherSuites {
+		require.Contains(t, tls.CipherSuiteName(id), "_GCM_")
+	}
+	// The original transport is left alone.
+	require.Zero(t, base.TLSClientConfig.MinVersion)
+
+	// Round trippers that are not an *http.Transport are used as is.
+	other := &testLocalTransport{}
+	require.Same(t, other, FIPSTransport(other))
+}
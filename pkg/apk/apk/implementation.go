@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"maps"
 	"net/http"
 	"net/url"
 	"os"
@@ -49,6 +50,8 @@ import (
 	"chainguard.dev/apko/pkg/apk/auth"
 	"chainguard.dev/apko/pkg/apk/expandapk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/progress"
+	"chainguard.dev/apko/pkg/report"
 
 	"github.com/chainguard-dev/clog"
 )
@@ -67,14 +70,24 @@ type APK struct {
 	client             *http.Client
 	cache              *cache
 	ignoreSignatures   bool
+	fips               bool
 	noSignatureIndexes []string
 	auth               auth.Authenticator
 	packageGetter      PackageGetter
 	sizeLimits         *SizeLimits
+	preferredProviders map[string]string
+	providerTieBreak   ProviderTieBreak
+	omitFile           func(name string) bool
+
+	// the names whose ambiguous providers were already warned about
+	warnedProviders map[string]bool
 
 	// filename to owning package, last write wins
 	installedFiles map[string]*Package
 
+	// the indexes the world was last resolved against
+	resolvedIndexes []NamedIndex
+
 	// This is a map of arch to apk.APK for every arch in a mult-arch situation.
 	// It's stuffed here to avoid plumbing it across every method, but it's optional.
 	ByArch map[string]*APK
@@ -101,19 +114,35 @@ func New(ctx context.Context, options ...Option) (*APK, error) {
 		opt.fs = apkfs.DirFS(ctx, "/")
 	}
 
-	// Wrap transport with response size limiter
-	transport := opt.transport
-	var httpResponseMaxSize int64
-	if opt.sizeLimits != nil {
-		httpResponseMaxSize = opt.sizeLimits.HTTPResponseMaxSize
-	}
-	transport = newLimitedResponseTransport(transport, httpResponseMaxSize)
+	var httpClient *http.Client
+	if opt.cache != nil && opt.cache.offline {
+		// Offline, everything comes from the cache, so nothing may reach
+		// the network, not even to authenticate, or the remote cache.
+		httpClient = &http.Client{Transport: OfflineTransport}
+		opt.auth = auth.MultiAuthenticator()
+	} else {
+		// Wrap transport with response size limiter
+		transport := opt.transport
+		if opt.fips {
+			transport = FIPSTransport(transport)
+		}
+		transport, err := newRepositoryTLSTransport(transport, opt.repositoryTLS)
+		if err != nil {
+			return nil, err
+		}
+		transport = newRemoteCacheTransport(transport, opt.remoteCache)
+		var httpResponseMaxSize int64
+		if opt.sizeLimits != nil {
+			httpResponseMaxSize = opt.sizeLimits.HTTPResponseMaxSize
+		}
+		transport = newLimitedResponseTransport(transport, httpResponseMaxSize)
 
-	client := retryablehttp.NewClient()
-	client.HTTPClient = &http.Client{Transport: transport}
-	client.Logger = clog.FromContext(ctx)
+		client := retryablehttp.NewClient()
+		client.HTTPClient = &http.Client{Transport: transport}
+		client.Logger = clog.FromContext(ctx)
 
-	httpClient := client.StandardClient()
+		httpClient = client.StandardClient()
+	}
 
 	// Create default PackageGetter if none provided
 	packageGetter := opt.packageGetter
@@ -139,11 +168,16 @@ func New(ctx context.Context, options ...Option) (*APK, error) {
 		version:            opt.version,
 		cache:              opt.cache,
 		ignoreSignatures:   opt.ignoreSignatures,
+		fips:               opt.fips,
 		noSignatureIndexes: opt.noSignatureIndexes,
 		installedFiles:     map[string]*Package{},
 		auth:               opt.auth,
 		packageGetter:      packageGetter,
 		sizeLimits:         opt.sizeLimits,
+		preferredProviders: opt.preferredProviders,
+		providerTieBreak:   opt.providerTieBreak,
+		omitFile:           opt.omitFile,
+		warnedProviders:    map[string]bool{},
 	}, nil
 }
 
@@ -584,7 +618,7 @@ func (a *APK) InitKeyring(ctx context.Context, keyFiles, extraKeyFiles []string)
 				defer resp.Body.Close()
 
 				if resp.StatusCode < 200 || resp.StatusCode > 299 {
-					return fmt.Errorf("failed to fetch apk key from %s: http response indicated error code: %d", req.Host, resp.StatusCode)
+					return fmt.Errorf("failed to fetch apk key from %s: %w", req.Host, &HTTPStatusError{StatusCode: resp.StatusCode})
 				}
 
 				data, err = io.ReadAll(resp.Body)
@@ -615,6 +649,7 @@ func (a *APK) ResolveWorld(ctx context.Context) (toInstall []*RepositoryPackage,
 
 	ctx, span := otel.Tracer("go-apk").Start(ctx, "ResolveWorld")
 	defer span.End()
+	defer report.FromContext(ctx).Time(report.PhaseResolve, a.arch)()
 
 	// to fix the world, we need to:
 	// 1. Get the apkIndexes for each repository for the target arch
@@ -624,6 +659,7 @@ func (a *APK) ResolveWorld(ctx context.Context) (toInstall []*RepositoryPackage,
 	}
 	// debugging info, if requested
 	log.Debugf("got %d indexes:\n%s", len(indexes), strings.Join(indexNames(indexes), "\n"))
+	a.resolvedIndexes = indexes
 
 	// 2. Get the dependency tree for each package from the world file
 	directPkgs, err := a.GetWorld()
@@ -631,6 +667,8 @@ func (a *APK) ResolveWorld(ctx context.Context) (toInstall []*RepositoryPackage,
 		return toInstall, conflicts, fmt.Errorf("error getting world packages: %w", err)
 	}
 	resolver := NewPkgResolver(ctx, indexes)
+	resolver.PreferProviders(a.preferredProviders)
+	resolver.BreakTiesBy(a.providerTieBreak)
 
 	// For other architectures we're building (if any), we want to disqualify any packages not present in all archs.
 	allArchs := map[string][]NamedIndex{}
@@ -646,10 +684,51 @@ func (a *APK) ResolveWorld(ctx context.Context) (toInstall []*RepositoryPackage,
 	if err != nil {
 		return
 	}
+	a.warnAmbiguousProviders(ctx, resolver.AmbiguousProviders())
+	progress.Report(ctx, progress.Event{
+		Phase:   progress.PhaseResolve,
+		Arch:    a.arch,
+		Current: int64(len(toInstall)),
+		Total:   int64(len(toInstall)),
+		Done:    true,
+	})
 	log.Debugf("got %d packages to install:\n%s", len(toInstall), strings.Join(packageRefs(toInstall), "\n"))
 	return
 }
 
+// warnAmbiguousProviders warns about each name whose provider was picked by
+// the tie-break among several candidates, once per name.
+func (a *APK) warnAmbiguousProviders(ctx context.Context, ambiguous map[string][]string) {
+	log := clog.FromContext(ctx)
+	for _, name := range slices.Sorted(maps.Keys(ambiguous)) {
+		if a.warnedProviders[name] {
+			continue
+		}
+		a.warnedProviders[name] = true
+		candidates := ambiguous[name]
+		tieBreak := a.providerTieBreak
+		if tieBreak == "" {
+			tieBreak = TieBreakVersion
+		}
+		log.Warnf("%d packages provide %s: %s; picked %s (provider tie-break: %s), set prefer-providers to choose one", len(candidates), name, strings.Join(candidates, ", "), candidates[0], tieBreak)
+	}
+}
+
+// IndexDigests returns the digests of the repository indexes the world was
+// last resolved against, keyed by index URL with any password redacted. It
+// is empty if the world was not resolved, e.g. when installing from a lockfile.
+func (a *APK) IndexDigests() map[string]string {
+	digests := map[string]string{}
+	for _, idx := range a.resolvedIndexes {
+		d, ok := idx.(interface{ Digest() string })
+		if !ok || d.Digest() == "" {
+			continue
+		}
+		digests[redact(idx.Source())] = d.Digest()
+	}
+	return digests
+}
+
 func (a *APK) CalculateWorld(ctx context.Context, allpkgs []*RepositoryPackage) ([]*APKResolved, error) {
 	// TODO: Consider making this configurable option.
 	jobs := runtime.GOMAXPROCS(0)
@@ -724,6 +803,13 @@ func (a *APK) FixateWorld(ctx context.Context, sourceDateEpoch *time.Time) ([]In
 		return nil, fmt.Errorf("error getting package dependencies: %w", err)
 	}
 
+	return a.InstallResolved(ctx, sourceDateEpoch, allpkgs, conflicts)
+}
+
+// InstallResolved installs the packages and conflicts that ResolveWorld
+// resolved the world to, like FixateWorld does, for callers that already
+// resolved it.
+func (a *APK) InstallResolved(ctx context.Context, sourceDateEpoch *time.Time, allpkgs []*RepositoryPackage, conflicts []string) ([]InstalledDiff, error) {
 	// 3. For each name on the list:
 	//     a. Check if it is installed, if so, skip
 	//     b. Get the .apk file
@@ -759,6 +845,9 @@ type InstalledDiff struct {
 }
 
 func (a *APK) InstallPackages(ctx context.Context, sourceDateEpoch *time.Time, allpkgs []InstallablePackage) ([]InstalledDiff, error) {
+	ctx, span := otel.Tracer("go-apk").Start(ctx, "InstallPackages", trace.WithAttributes(attribute.Int("packages", len(allpkgs))))
+	defer span.End()
+
 	// TODO: Consider making this configurable option.
 	jobs := runtime.GOMAXPROCS(0)
 
@@ -818,9 +907,25 @@ func (a *APK) InstallPackages(ctx context.Context, sourceDateEpoch *time.Time, a
 				}
 
 				allFiles[i] = installedFiles
+
+				progress.Report(ctx, progress.Event{
+					Phase:   progress.PhaseInstall,
+					Arch:    a.arch,
+					Name:    pkg.PackageName(),
+					Current: int64(i + 1),
+					Total:   int64(len(allpkgs)),
+				})
 			}
 		}
 
+		progress.Report(ctx, progress.Event{
+			Phase:   progress.PhaseInstall,
+			Arch:    a.arch,
+			Current: int64(len(allpkgs)),
+			Total:   int64(len(allpkgs)),
+			Done:    true,
+		})
+
 		return nil
 	})
 
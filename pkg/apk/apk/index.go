@@ -19,6 +19,8 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -138,7 +140,7 @@ func (i *indexCache) get(ctx context.Context, repoName, repoURL string, keys map
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			return nil, &HTTPStatusError{StatusCode: resp.StatusCode}
 		}
 
 		fetchAndParse := func(etag string) (NamedIndex, error) {
@@ -327,7 +329,7 @@ func fetchRepositoryIndex(ctx context.Context, u string, etag string, opts *inde
 		return nil, err
 	}
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code %d", res.StatusCode)
+		return nil, &HTTPStatusError{StatusCode: res.StatusCode}
 	}
 	defer res.Body.Close()
 
@@ -367,6 +369,8 @@ func parseRepositoryIndex(ctx context.Context, u string, keys map[string][]byte,
 		tarReader := tar.NewReader(gzipReader)
 
 		sigs := make([]Signature, 0, len(keys))
+		// signatures skipped because FIPS mode does not allow them, and why
+		var rejected []string
 
 		for {
 			// read the signature(s)
@@ -415,6 +419,16 @@ func parseRepositoryIndex(ctx context.Context, u string, keys map[string][]byte,
 			default:
 				return nil, fmt.Errorf("unknown signature format: %s", signatureType)
 			}
+			if opts.fips {
+				if digestAlgorithm != crypto.SHA256 {
+					rejected = append(rejected, fmt.Sprintf("%s: SHA-1 digest is not allowed", signatureFile.Name))
+					continue
+				}
+				if err := checkFIPSKey(keys[keyfile]); err != nil {
+					rejected = append(rejected, fmt.Sprintf("%s: %v", signatureFile.Name, err))
+					continue
+				}
+			}
 			signature, err := io.ReadAll(tarReader)
 			if err != nil {
 				return nil, fmt.Errorf("failed to read signature from repository index: %w", err)
@@ -425,8 +439,11 @@ func parseRepositoryIndex(ctx context.Context, u string, keys map[string][]byte,
 				DigestAlgorithm: digestAlgorithm,
 			})
 		}
+		if len(sigs) == 0 && len(rejected) != 0 {
+			return nil, fmt.Errorf("%w: no FIPS-approved signature found in repository index: %s", ErrSignatureVerification, strings.Join(rejected, "; "))
+		}
 		if len(sigs) == 0 {
-			return nil, fmt.Errorf("no signature with known key (one of: %v) found in repository index", slices.Collect(maps.Keys(keys)))
+			return nil, fmt.Errorf("%w: no signature with known key (one of: %v) found in repository index", ErrSignatureVerification, slices.Collect(maps.Keys(keys)))
 		}
 		// we now have the signature bytes and name, get the contents of the rest;
 		// this should be everything else in the raw gzip file as is.
@@ -453,7 +470,7 @@ func parseRepositoryIndex(ctx context.Context, u string, keys map[string][]byte,
 			}
 		}
 		if !verified {
-			return nil, errors.New("signature verification failed for repository index, for all provided keys")
+			return nil, fmt.Errorf("%w for repository index, for all provided keys", ErrSignatureVerification)
 		}
 	}
 	// with a valid signature, convert it to an ApkIndex
@@ -465,12 +482,15 @@ func parseRepositoryIndex(ctx context.Context, u string, keys map[string][]byte,
 	if err != nil {
 		return nil, fmt.Errorf("unable to read convert repository index bytes to index struct: %w", err)
 	}
+	sum := sha256.Sum256(b)
+	index.Digest = "sha256:" + hex.EncodeToString(sum[:])
 
 	return index, err
 }
 
 type indexOpts struct {
 	ignoreSignatures         bool
+	fips                     bool
 	noSignatureIndexes       []string
 	httpClient               *http.Client
 	auth                     auth.Authenticator
@@ -484,6 +504,14 @@ func WithIgnoreSignatures(ignoreSignatures bool) IndexOption {
 	}
 }
 
+// WithFIPSSignatures sets whether to only accept index signatures made with
+// FIPS-approved algorithms: RSA keys of at least 2048 bits over SHA-256.
+func WithFIPSSignatures(fips bool) IndexOption {
+	return func(o *indexOpts) {
+		o.fips = fips
+	}
+}
+
 func WithIgnoreSignatureForIndexes(noSignatureIndexes ...string) IndexOption {
 	return func(o *indexOpts) {
 		o.noSignatureIndexes = append(o.noSignatureIndexes, noSignatureIndexes...)
@@ -25,7 +25,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"slices"
 	"strings"
 
 	"go.opentelemetry.io/otel"
@@ -68,6 +67,64 @@ func (a *APK) writeOneFile(header *tar.Header, r io.Reader, allowOverwrite bool)
 	return nil
 }
 
+// Replacement is what becomes of a file that a package ships with different
+// content than the file another package already installed.
+type Replacement int
+
+const (
+	// ReplaceConflict means the packages conflict on the file.
+	ReplaceConflict Replacement = iota
+	// ReplaceOverwrite means the new package overwrites the file.
+	ReplaceOverwrite
+	// ReplaceKeep means the existing file is kept.
+	ReplaceKeep
+)
+
+// ReplacesFile decides what happens when pkg ships a file that existing
+// already installed with different content, the way apk does: packages of the
+// same name or origin overwrite each other's files. Otherwise each package
+// whose replaces matches the other, by name and version constraint, claims
+// the file with its replaces_priority. The existing file is kept if existing
+// has the higher claim, and is overwritten if pkg has a claim at all. If
+// neither does, the packages conflict.
+func ReplacesFile(existing, pkg *Package) Replacement {
+	if existing.Name == pkg.Name || (existing.Origin != "" && existing.Origin == pkg.Origin) {
+		return ReplaceOverwrite
+	}
+	existingClaims := replacesPackage(existing.Replaces, pkg)
+	pkgClaims := replacesPackage(pkg.Replaces, existing)
+	switch {
+	case existingClaims && (!pkgClaims || existing.ReplacesPriority > pkg.ReplacesPriority):
+		return ReplaceKeep
+	case pkgClaims:
+		return ReplaceOverwrite
+	default:
+		return ReplaceConflict
+	}
+}
+
+// replacesPackage reports whether any of replaces, such as "foo" or
+// "foo<2.0", matches pkg.
+func replacesPackage(replaces []string, pkg *Package) bool {
+	for _, r := range replaces {
+		c := cachedResolvePackageNameVersionPin(r)
+		if c.Name != pkg.Name {
+			continue
+		}
+		if c.Version == "" {
+			return true
+		}
+		v, err := cachedParseVersion(pkg.Version)
+		if err != nil {
+			continue
+		}
+		if ok, err := c.SatisfiedBy(v); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
 // installRegularFile handles the various error modes of writing a regular file
 func (a *APK) installRegularFile(header *tar.Header, tr *tar.Reader, tmpDir string, pkg *Package) (bool, error) {
 	checksum, err := checksumFromHeader(header)
@@ -75,11 +132,6 @@ func (a *APK) installRegularFile(header *tar.Header, tr *tar.Reader, tmpDir stri
 		return false, err
 	}
 
-	replaceMap := map[string]struct{}{}
-	for _, r := range pkg.Replaces {
-		replaceMap[r] = struct{}{}
-	}
-
 	var r io.Reader = tr
 
 	if checksum == nil {
@@ -123,23 +175,16 @@ func (a *APK) installRegularFile(header *tar.Header, tr *tar.Reader, tmpDir stri
 			return false, nil
 		}
 
-		// If the files are not identical, then we can overwrite the file in two situations:
-		// 1. One of the packages replaces the other.
-		// 2. The packages are in the same origin.
-
-		// If the existing file's package replaces the package we want to install, we don't need to write this file.
+		// If the files are not identical, replaces decides which one is kept.
 		pk, ok := a.installedFiles[header.Name]
 		if !ok {
 			return false, fmt.Errorf("found existing file we did not install (this should never happen): %s", header.Name)
 		}
 
-		if slices.Contains(pk.Replaces, pkg.Name) {
+		switch ReplacesFile(pk, pkg) {
+		case ReplaceKeep:
 			return false, nil
-		}
-
-		// Otherwise, we can only overwrite the file if it's in the same origin or if it replaces the existing package.
-		_, isReplaced := replaceMap[pk.Name]
-		if pk.Origin != pkg.Origin && !isReplaced {
+		case ReplaceConflict:
 			return false, FileConflictError{
 				Path: header.Name,
 				Origins: map[string]string{
@@ -212,6 +257,9 @@ func (a *APK) installAPKFiles(ctx context.Context, in io.Reader, pkg *Package) (
 		}
 		// whatever it is now, it is in the data section
 		startedDataSection = true
+		if a.omitted(header) {
+			continue
+		}
 
 		switch header.Typeflag {
 		case tar.TypeDir:
@@ -277,6 +325,18 @@ func (a *APK) installAPKFiles(ctx context.Context, in io.Reader, pkg *Package) (
 	return files, nil
 }
 
+// omitted reports whether the file of header is left out of the installation.
+func (a *APK) omitted(header *tar.Header) bool {
+	if a.omitFile == nil {
+		return false
+	}
+	if a.omitFile(strings.TrimSuffix(header.Name, "/")) {
+		return true
+	}
+	// Hardlinks to omitted files have nothing to link to.
+	return header.Typeflag == tar.TypeLink && a.omitFile(strings.TrimSuffix(header.Linkname, "/"))
+}
+
 func checksumFromHeader(header *tar.Header) ([]byte, error) {
 	pax := header.PAXRecords
 	if pax == nil {
@@ -330,6 +390,9 @@ func (a *APK) lazilyInstallAPKFiles(ctx context.Context, wh WriteHeaderer, tf *t
 		}
 		// whatever it is now, it is in the data section
 		startedDataSection = true
+		if a.omitted(&file.Header) {
+			continue
+		}
 
 		installed, err := wh.WriteHeader(file.Header, tf, pkg)
 		if err != nil {
@@ -26,6 +26,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"strings"
 	"testing"
 	"text/template"
 
@@ -150,6 +151,37 @@ func TestInstallAPKFiles(t *testing.T) {
 		}
 	})
 
+	t.Run("omit files", func(t *testing.T) {
+		apk, src, err := testGetTestAPK()
+		require.NoErrorf(t, err, "failed to get test APK")
+		apk.omitFile = func(name string) bool {
+			return name == "usr/share/doc" || strings.HasPrefix(name, "usr/share/doc/")
+		}
+
+		entries := []testDirEntry{
+			{"usr", 0o755, true, nil, nil},
+			{"usr/share", 0o755, true, nil, nil},
+			{"usr/share/doc", 0o755, true, nil, nil},
+			{"usr/share/doc/README", 0o644, false, []byte("read me"), nil},
+			{"usr/share/kept", 0o644, false, []byte("kept"), nil},
+		}
+
+		r := testCreateTarForPackage(entries)
+		headers, err := apk.installAPKFiles(context.Background(), r, &Package{})
+		require.NoError(t, err)
+
+		names := []string{}
+		for _, h := range headers {
+			names = append(names, h.Name)
+		}
+		require.Equal(t, []string{"usr", "usr/share", "usr/share/kept"}, names)
+
+		_, err = fs.Stat(src, "usr/share/doc")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = fs.Stat(src, "usr/share/kept")
+		require.NoError(t, err)
+	})
+
 	t.Run("overlapping files", func(t *testing.T) {
 		t.Run("different origin and content", func(t *testing.T) {
 			apk, src, err := testGetTestAPK()
@@ -293,11 +325,71 @@ func TestInstallAPKFiles(t *testing.T) {
 			require.NoError(t, err, "error reading %s", overwriteFilename)
 			require.Equal(t, originalContent, actual)
 
+			checkDuplicateIDBEntries(t, apk)
+		})
+		t.Run("both replace, higher replaces_priority wins", func(t *testing.T) {
+			apk, src, err := testGetTestAPK()
+			require.NoErrorf(t, err, "failed to get test APK")
+			originalContent := []byte("hello world")
+			finalContent := []byte("extra long I am here")
+			overwriteFilename := "etc/doublewrite"
+
+			pkg := &Package{Name: "first", Origin: "first", Replaces: []string{"second"}, ReplacesPriority: 10}
+			fp1 := fakePackage(t, pkg, []testDirEntry{
+				{"etc", 0o755, true, nil, nil},
+				{overwriteFilename, 0o755, false, originalContent, nil},
+			})
+
+			pkg2 := &Package{Name: "second", Origin: "second", Replaces: []string{"first"}, ReplacesPriority: 1}
+			fp2 := fakePackage(t, pkg2, []testDirEntry{
+				{"etc", 0o755, true, nil, nil},
+				{overwriteFilename, 0o755, false, finalContent, nil},
+			})
+
+			_, err = apk.InstallPackages(context.Background(), nil, []InstallablePackage{fp1, fp2})
+			require.NoError(t, err)
+
+			actual, err := src.ReadFile(overwriteFilename)
+			require.NoError(t, err, "error reading %s", overwriteFilename)
+			require.Equal(t, originalContent, actual)
+
 			checkDuplicateIDBEntries(t, apk)
 		})
 	})
 }
 
+func TestReplacesFile(t *testing.T) {
+	first := &Package{Name: "first", Version: "1.0-r0", Origin: "first"}
+	for _, tt := range []struct {
+		name          string
+		existing, pkg *Package
+		want          Replacement
+	}{
+		{"unrelated", first, &Package{Name: "second", Origin: "second"}, ReplaceConflict},
+		{"same origin", first, &Package{Name: "first-compat", Origin: "first"}, ReplaceOverwrite},
+		{"replaces existing", first, &Package{Name: "second", Replaces: []string{"first"}}, ReplaceOverwrite},
+		{"replaces matching version", first, &Package{Name: "second", Replaces: []string{"first<2.0"}}, ReplaceOverwrite},
+		{"replaces other version", first, &Package{Name: "second", Replaces: []string{"first>=2.0"}}, ReplaceConflict},
+		{"replaced by existing", &Package{Name: "first", Replaces: []string{"second"}}, &Package{Name: "second"}, ReplaceKeep},
+		{
+			"both replace, existing has priority",
+			&Package{Name: "first", Replaces: []string{"second"}, ReplacesPriority: 2},
+			&Package{Name: "second", Replaces: []string{"first"}, ReplacesPriority: 1},
+			ReplaceKeep,
+		},
+		{
+			"both replace, same priority",
+			&Package{Name: "first", Replaces: []string{"second"}},
+			&Package{Name: "second", Replaces: []string{"first"}},
+			ReplaceOverwrite,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, ReplacesFile(tt.existing, tt.pkg))
+		})
+	}
+}
+
 func checkDuplicateIDBEntries(t *testing.T, apk *APK) {
 	t.Helper()
 
@@ -497,5 +589,8 @@ replaces = {{ $dep }}
 {{- if .ProviderPriority }}
 provider_priority = {{ .Dependencies.ProviderPriority }}
 {{- end }}
+{{- if .ReplacesPriority }}
+replaces_priority = {{ .ReplacesPriority }}
+{{- end }}
 datahash = {{.DataHash}}
 `
@@ -189,18 +189,26 @@ func (a *APK) readScriptsTar() (io.ReadCloser, error) {
 	return a.fs.Open(scriptsFilePath)
 }
 
-// updateTriggers insert the triggers into the triggers file
+// updateTriggers insert the triggers into the triggers file. As in the file
+// apk writes, each package with triggers has a single line with its checksum
+// followed by all of its trigger paths.
 func (a *APK) updateTriggers(pkg *Package, values []string) error {
+	var paths []string
+	for _, value := range values {
+		paths = append(paths, strings.Fields(value)...)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+
 	triggers, err := a.fs.OpenFile(triggersFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0)
 	if err != nil {
 		return fmt.Errorf("unable to open triggers file %s: %w", triggersFilePath, err)
 	}
 	defer triggers.Close()
 
-	for _, value := range values {
-		if _, err := fmt.Fprintf(triggers, "Q1%s %s\n", base64.StdEncoding.EncodeToString(pkg.Checksum), value); err != nil {
-			return fmt.Errorf("unable to write triggers file %s: %w", triggersFilePath, err)
-		}
+	if _, err := fmt.Fprintf(triggers, "Q1%s %s\n", base64.StdEncoding.EncodeToString(pkg.Checksum), strings.Join(paths, " ")); err != nil {
+		return fmt.Errorf("unable to write triggers file %s: %w", triggersFilePath, err)
 	}
 
 	return nil
@@ -296,6 +304,12 @@ func ParseInstalled(installed io.Reader) ([]*InstalledPackage, error) { //nolint
 				return nil, fmt.Errorf("cannot parse provider priority field %s: %w", val, err)
 			}
 			pkg.ProviderPriority = priority
+		case "q":
+			priority, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("cannot parse replaces priority field %s: %w", val, err)
+			}
+			pkg.ReplacesPriority = priority
 		case "C":
 			// Handle SHA1 checksums:
 			if strings.HasPrefix(val, "Q1") {
@@ -179,19 +179,15 @@ func TestAddInstalledPackageAdded(t *testing.T) {
 		"P:testpkg",
 		"V:1.0.0",
 		"A:x86_64",
-		"L:GPL-2.0-only",
+		"S:13282",
+		"I:123541",
 		"T:my-description",
-		"o:testpkg",
-		"m:",
 		"U:https://example.com/testpkg",
-		"D:testlib",
-		"p:",
-		"c:a2020bf03d408b2ef1585d7dc52c29ce88524a76",
-		"i:[]",
+		"L:GPL-2.0-only",
+		"o:testpkg",
 		"t:1754874000",
-		"S:13282",
-		"I:123541",
-		"k:0",
+		"c:a2020bf03d408b2ef1585d7dc52c29ce88524a76",
+		"D:testlib",
 	}, "\n")
 
 	cases := []struct {
@@ -459,6 +455,28 @@ func TestUpdateTriggers(t *testing.T) {
 	t.Errorf("could not find entry for checksum: %s", cksum)
 }
 
+func TestUpdateTriggersSingleLine(t *testing.T) {
+	a, src, err := testGetTestAPK()
+	require.NoError(t, err, "unable to initialize APK implementation")
+	pkg := &Package{
+		Name:     "testpkg",
+		Version:  "1.0.0",
+		Checksum: []byte("0123456789abcdefghij"),
+	}
+
+	// apk writes all the trigger paths of a package on one line, and nothing
+	// for a package without triggers.
+	err = a.updateTriggers(pkg, []string{"/bin /usr/bin", "/usr/lib/*"})
+	require.NoError(t, err, "unable to update triggers")
+	err = a.updateTriggers(&Package{Name: "other", Checksum: []byte("x")}, []string{" "})
+	require.NoError(t, err, "unable to update triggers")
+
+	b, err := src.ReadFile(triggersFilePath)
+	require.NoError(t, err, "unable to read triggers")
+	cksum := "Q1" + base64.StdEncoding.EncodeToString(pkg.Checksum)
+	require.True(t, strings.HasSuffix(string(b), "\n"+cksum+" /bin /usr/bin /usr/lib/*\n"), "unexpected triggers:\n%s", b)
+}
+
 func TestPathCompare(t *testing.T) {
 	cases := []struct {
 		name     string
@@ -740,23 +758,21 @@ func TestAddInstalledPackageFromAPK(t *testing.T) {
 		{
 			name:    "alpine-baselayout-3.4.0-r0",
 			apkFile: "testdata/alpine-317/alpine-baselayout-3.4.0-r0.apk",
-			expected: `P:alpine-baselayout
+			expected: `C:Q1LLq2qDNrS/qRnhxQ3hsY/sHbQnc=
+P:alpine-baselayout
 V:3.2.0-r23
 A:aarch64
-L:GPL-2.0-only
+S:11012
+I:339968
 T:Alpine base dir structure and init scripts
+U:https://git.alpinelinux.org/cgit/aports/tree/main/alpine-baselayout
+L:GPL-2.0-only
 o:alpine-baselayout
 m:Natanael Copa <ncopa@alpinelinux.org>
-U:https://git.alpinelinux.org/cgit/aports/tree/main/alpine-baselayout
+t:1662926906
+c:348653a9ba0701e8e968b3344e72313a9ef334e4
 D:alpine-baselayout-data=3.2.0-r23 /bin/sh so:libc.musl-aarch64.so.1
 p:cmd:mkmntdirs=3.2.0-r23
-c:348653a9ba0701e8e968b3344e72313a9ef334e4
-i:[]
-t:1662926906
-S:11012
-I:339968
-k:0
-C:Q1LLq2qDNrS/qRnhxQ3hsY/sHbQnc=
 F:dev
 F:dev/pts
 F:dev/shm
@@ -869,23 +885,16 @@ M:0:0:1777
 		{
 			name:    "hello-0.1.0-r0",
 			apkFile: "testdata/hello-0.1.0-r0.apk",
-			expected: `P:hello
+			expected: `C:Q1DNWZeWkviN7MJedLpYM8yBvmnGM=
+P:hello
 V:0.1.0-r0
 A:x86_64
-L:Apache-2.0
+S:499
+I:4117
 T:just a test package
-o:
-m:
 U:
+L:Apache-2.0
 D:busybox
-p:
-c:
-i:[]
-t:0
-S:499
-I:4117
-k:0
-C:Q1DNWZeWkviN7MJedLpYM8yBvmnGM=
 F:
 R:hello
 a:0:0:0755
@@ -896,23 +905,21 @@ Z:Q1nbbwdPygqQMTe5HHyGayHU5yBac=
 		{
 			name:    "alpine-baselayout-3.2.0-r23",
 			apkFile: "testdata/alpine-317/alpine-baselayout-3.2.0-r23.apk",
-			expected: `P:alpine-baselayout
+			expected: `C:Q1LLq2qDNrS/qRnhxQ3hsY/sHbQnc=
+P:alpine-baselayout
 V:3.2.0-r23
 A:aarch64
-L:GPL-2.0-only
+S:11012
+I:339968
 T:Alpine base dir structure and init scripts
+U:https://git.alpinelinux.org/cgit/aports/tree/main/alpine-baselayout
+L:GPL-2.0-only
 o:alpine-baselayout
 m:Natanael Copa <ncopa@alpinelinux.org>
-U:https://git.alpinelinux.org/cgit/aports/tree/main/alpine-baselayout
+t:1662926906
+c:348653a9ba0701e8e968b3344e72313a9ef334e4
 D:alpine-baselayout-data=3.2.0-r23 /bin/sh so:libc.musl-aarch64.so.1
 p:cmd:mkmntdirs=3.2.0-r23
-c:348653a9ba0701e8e968b3344e72313a9ef334e4
-i:[]
-t:1662926906
-S:11012
-I:339968
-k:0
-C:Q1LLq2qDNrS/qRnhxQ3hsY/sHbQnc=
 F:dev
 F:dev/pts
 F:dev/shm
@@ -0,0 +1,83 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// authFunc is an Authenticator of a function.
+type authFunc func(context.Context, *http.Request) error
+
+func (f authFunc) AddAuth(ctx context.Context, req *http.Request) error { return f(ctx, req) }
+
+func TestOffline(t *testing.T) {
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, testDemoKey)
+	}))
+	defer s.Close()
+	key := s.URL + "/keys/alpine-devel@lists.alpinelinux.org-5e69ca50.rsa.pub"
+
+	cacheDir := t.TempDir()
+	authenticated := 0
+	newAPK := func(offline bool) *APK {
+		t.Helper()
+		a, err := New(t.Context(),
+			WithFS(apkfs.NewMemFS()),
+			WithIgnoreMknodErrors(ignoreMknodErrors),
+			WithCache(cacheDir, offline, NewCache(true)),
+			WithAuthenticator(authFunc(func(context.Context, *http.Request) error {
+				authenticated++
+				return nil
+			})),
+		)
+		require.NoError(t, err)
+		return a
+	}
+
+	// A miss names what is missing, without falling back to the network.
+	err := newAPK(true).InitKeyring(t.Context(), []string{key}, nil)
+	require.ErrorIs(t, err, ErrOffline)
+	require.ErrorContains(t, err, key)
+	require.Zero(t, requests)
+	require.Zero(t, authenticated)
+
+	// Once it is in the cache, it is read from there.
+	require.NoError(t, newAPK(false).InitKeyring(t.Context(), []string{key}, nil))
+	online := requests
+	require.NotZero(t, online)
+	require.Equal(t, 1, authenticated)
+	require.NoError(t, newAPK(true).InitKeyring(t.Context(), []string{key}, nil))
+	require.Equal(t, online, requests)
+	require.Equal(t, 1, authenticated)
+}
+
+func TestOfflineTransport(t *testing.T) {
+	_, err := (&http.Client{Transport: OfflineTransport}).Get("https://packages.wolfi.dev/os/x86_64/APKINDEX.tar.gz")
+	require.True(t, errors.Is(err, ErrOffline), err)
+	require.ErrorContains(t, err, "https://packages.wolfi.dev/os/x86_64/APKINDEX.tar.gz")
+}
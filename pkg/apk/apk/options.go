@@ -36,9 +36,15 @@ type opts struct {
 	noSignatureIndexes []string
 	auth               auth.Authenticator
 	ignoreSignatures   bool
+	fips               bool
+	repositoryTLS      []RepositoryTLS
 	transport          http.RoundTripper
+	remoteCache        RemoteCache
 	packageGetter      PackageGetter
 	sizeLimits         *SizeLimits
+	preferredProviders map[string]string
+	providerTieBreak   ProviderTieBreak
+	omitFile           func(name string) bool
 }
 
 // SizeLimits configures maximum sizes for various APK operations.
@@ -130,6 +136,26 @@ func WithIgnoreIndexSignatures(ignore bool) Option {
 	}
 }
 
+// WithFIPS restricts TLS and index signature verification to FIPS-approved
+// algorithms. Indexes that are only signed with non-approved algorithms fail
+// to load. Default is false.
+func WithFIPS(fips bool) Option {
+	return func(o *opts) error {
+		o.fips = fips
+		return nil
+	}
+}
+
+// WithRepositoryTLS configures TLS for the requests to the repositories, and
+// keyring URLs, matching each of configs. It requires the transport to be an
+// *http.Transport.
+func WithRepositoryTLS(configs ...RepositoryTLS) Option {
+	return func(o *opts) error {
+		o.repositoryTLS = append(o.repositoryTLS, configs...)
+		return nil
+	}
+}
+
 func WithNoSignatureIndexes(noSignatureIndex ...string) Option {
 	return func(o *opts) error {
 		o.noSignatureIndexes = append(o.noSignatureIndexes, noSignatureIndex...)
@@ -171,6 +197,41 @@ func WithSizeLimits(limits *SizeLimits) Option {
 	}
 }
 
+// WithPreferredProviders sets the package to prefer among the providers of
+// each name, such as "python-3.12" for "cmd:python3", over the provider
+// priorities and versions of the indexes.
+func WithPreferredProviders(providers map[string]string) Option {
+	return func(o *opts) error {
+		o.preferredProviders = providers
+		return nil
+	}
+}
+
+// WithProviderTieBreak sets how to decide between packages of different names
+// providing the same name with the same provider priority: "version" (the
+// default), "alphabetical", "origin" or "pinned".
+func WithProviderTieBreak(tieBreak string) Option {
+	return func(o *opts) error {
+		t, err := ParseProviderTieBreak(tieBreak)
+		if err != nil {
+			return err
+		}
+		o.providerTieBreak = t
+		return nil
+	}
+}
+
+// WithOmitFiles leaves the files, directories and links of the packages whose
+// paths omit reports true for out of the installation, like the --no-docs of
+// some package managers. Omitted files are not listed in the installed
+// database either.
+func WithOmitFiles(omit func(name string) bool) Option {
+	return func(o *opts) error {
+		o.omitFile = omit
+		return nil
+	}
+}
+
 func defaultOpts() *opts {
 	return &opts{
 		arch:              ArchToAPK(runtime.GOARCH),
@@ -31,33 +31,65 @@ import (
 )
 
 // PackageToInstalled takes a Package and returns it as the string representation of lines in a /usr/lib/apk/db/installed file.
+// The fields are written in the order apk writes them, and optional fields are omitted when empty, as apk does.
 func PackageToInstalled(pkg *Package) (out []string) {
+	if len(pkg.Checksum) > 0 {
+		out = append(out, fmt.Sprintf("C:%s", pkg.ChecksumString()))
+	}
 	out = append(out, fmt.Sprintf("P:%s", pkg.Name))
 	out = append(out, fmt.Sprintf("V:%s", pkg.Version))
 	out = append(out, fmt.Sprintf("A:%s", pkg.Arch))
-	out = append(out, fmt.Sprintf("L:%s", pkg.License))
-	out = append(out, fmt.Sprintf("T:%s", pkg.Description))
-	out = append(out, fmt.Sprintf("o:%s", pkg.Origin))
-	out = append(out, fmt.Sprintf("m:%s", pkg.Maintainer))
-	out = append(out, fmt.Sprintf("U:%s", pkg.URL))
-	out = append(out, fmt.Sprintf("D:%s", strings.Join(pkg.Dependencies, " ")))
-	out = append(out, fmt.Sprintf("p:%s", strings.Join(pkg.Provides, " ")))
-	if len(pkg.Replaces) != 0 {
-		out = append(out, fmt.Sprintf("r:%s", strings.Join(pkg.Replaces, " ")))
-	}
-	out = append(out, fmt.Sprintf("c:%s", pkg.RepoCommit))
-	out = append(out, fmt.Sprintf("i:%s", pkg.InstallIf))
-	out = append(out, fmt.Sprintf("t:%d", pkg.BuildTime.Unix()))
 	out = append(out, fmt.Sprintf("S:%d", pkg.Size))
 	out = append(out, fmt.Sprintf("I:%d", pkg.InstalledSize))
-	out = append(out, fmt.Sprintf("k:%d", pkg.ProviderPriority))
-	if len(pkg.Checksum) > 0 {
-		out = append(out, fmt.Sprintf("C:%s", pkg.ChecksumString()))
+	out = append(out, fmt.Sprintf("T:%s", pkg.Description))
+	out = append(out, fmt.Sprintf("U:%s", pkg.URL))
+	out = append(out, fmt.Sprintf("L:%s", pkg.License))
+	if pkg.Origin != "" {
+		out = append(out, fmt.Sprintf("o:%s", pkg.Origin))
+	}
+	if pkg.Maintainer != "" {
+		out = append(out, fmt.Sprintf("m:%s", pkg.Maintainer))
+	}
+	if !pkg.BuildTime.IsZero() && pkg.BuildTime.Unix() != 0 {
+		out = append(out, fmt.Sprintf("t:%d", pkg.BuildTime.Unix()))
+	}
+	if pkg.RepoCommit != "" {
+		out = append(out, fmt.Sprintf("c:%s", pkg.RepoCommit))
+	}
+	if pkg.ProviderPriority != 0 {
+		out = append(out, fmt.Sprintf("k:%d", pkg.ProviderPriority))
+	}
+	for _, f := range []struct {
+		key    string
+		values []string
+	}{
+		{"D", pkg.Dependencies},
+		{"p", pkg.Provides},
+		{"i", pkg.InstallIf},
+		{"r", pkg.Replaces},
+	} {
+		if values := nonEmpty(f.values); len(values) != 0 {
+			out = append(out, fmt.Sprintf("%s:%s", f.key, strings.Join(values, " ")))
+		}
+	}
+	if pkg.ReplacesPriority != 0 {
+		out = append(out, fmt.Sprintf("q:%d", pkg.ReplacesPriority))
 	}
 
 	return
 }
 
+// nonEmpty returns the values that are not empty.
+func nonEmpty(values []string) []string {
+	var out []string
+	for _, v := range values {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
 // LocatablePackage represents a minimal set of information needed to locate a
 // package for retrieval over a network.
 type LocatablePackage interface {
@@ -133,6 +165,7 @@ func ParsePackage(ctx context.Context, apkPackage io.Reader, size uint64) (*Pack
 		BuildDate:        pkginfo.BuildDate,
 		RepoCommit:       pkginfo.RepoCommit,
 		Replaces:         pkginfo.Replaces,
+		ReplacesPriority: pkginfo.ReplacesPriority,
 		DataHash:         pkginfo.DataHash,
 	}, nil
 }
@@ -26,6 +26,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -35,6 +36,8 @@ import (
 	"chainguard.dev/apko/pkg/apk/expandapk"
 	"chainguard.dev/apko/pkg/apk/expandapk/tarfs"
 	"chainguard.dev/apko/pkg/paths"
+	"chainguard.dev/apko/pkg/progress"
+	"chainguard.dev/apko/pkg/report"
 
 	"github.com/chainguard-dev/clog"
 )
@@ -142,21 +145,28 @@ func (d *defaultPackageGetter) getPackageImpl(ctx context.Context, pkg Installab
 		exp, err := d.cachedPackage(ctx, pkg, cacheDir)
 		if err == nil {
 			log.Debugf("cache hit (%s)", pkg.PackageName())
+			touchCacheEntry(cacheDir)
+			report.FromContext(ctx).CacheHit(report.CachePackages)
 			return exp, nil
 		}
 
 		log.Debugf("cache miss (%s): %v", pkg.PackageName(), err)
+		report.FromContext(ctx).CacheMiss(report.CachePackages)
 
 		if err := os.MkdirAll(cacheDir, 0o755); err != nil {
 			return nil, fmt.Errorf("unable to create cache directory %q: %w", cacheDir, err)
 		}
 	}
 
+	start := time.Now()
 	rc, err := d.fetchPackage(ctx, pkg)
 	if err != nil {
 		return nil, fmt.Errorf("fetching package %q: %w", pkg.PackageName(), err)
 	}
 	defer rc.Close()
+	fetched := time.Now()
+	// Downloading and expanding are interleaved, so time the reads apart.
+	tr := &timedReader{r: rc}
 
 	var expandOpts []expandapk.Option
 	if d.apkControlMaxSize != 0 {
@@ -165,10 +175,13 @@ func (d *defaultPackageGetter) getPackageImpl(ctx context.Context, pkg Installab
 	if d.apkDataMaxSize != 0 {
 		expandOpts = append(expandOpts, expandapk.WithMaxDataSize(d.apkDataMaxSize))
 	}
-	exp, err := expandapk.ExpandApkWithOptions(ctx, rc, cacheDir, expandOpts...)
+	exp, err := expandapk.ExpandApkWithOptions(ctx, tr, cacheDir, expandOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("expanding %s: %w", pkg.PackageName(), err)
 	}
+	rep := report.FromContext(ctx)
+	rep.Add(report.PhaseDownload, "", fetched.Sub(start)+tr.elapsed)
+	rep.Add(report.PhaseExtract, "", time.Since(fetched)-tr.elapsed)
 
 	// If we don't have a cache, we're done.
 	if d.cache == nil {
@@ -202,7 +215,11 @@ func (d *defaultPackageGetter) fetchPackage(ctx context.Context, pkg FetchablePa
 		if err != nil {
 			return nil, fmt.Errorf("failed to read repository package apk %s: %w", u, err)
 		}
-		return f, nil
+		var size int64
+		if fi, err := f.Stat(); err == nil {
+			size = fi.Size()
+		}
+		return progress.NewReader(ctx, f, progress.Event{Phase: progress.PhaseDownload, Name: pkg.PackageName(), Total: size}), nil
 	case "https", "http":
 		client := d.client
 		if d.cache != nil {
@@ -224,9 +241,9 @@ func (d *defaultPackageGetter) fetchPackage(ctx context.Context, pkg FetchablePa
 		}
 		if res.StatusCode != http.StatusOK {
 			res.Body.Close()
-			return nil, fmt.Errorf("unable to get package apk at %s: %v", u, res.Status)
+			return nil, fmt.Errorf("unable to get package apk at %s: %w", u, &HTTPStatusError{StatusCode: res.StatusCode})
 		}
-		return res.Body, nil
+		return progress.NewReader(ctx, res.Body, progress.Event{Phase: progress.PhaseDownload, Name: pkg.PackageName(), Total: max(res.ContentLength, 0)}), nil
 	default:
 		return nil, fmt.Errorf("repository scheme %s not supported", asURL.Scheme)
 	}
@@ -388,3 +405,16 @@ func (d *defaultPackageGetter) cachedPackage(ctx context.Context, pkg Installabl
 
 	return &exp, nil
 }
+
+// timedReader adds up the time spent reading from r.
+type timedReader struct {
+	r       io.Reader
+	elapsed time.Duration
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.elapsed += time.Since(start)
+	return n, err
+}
@@ -88,3 +88,42 @@ func TestParsePackage(t *testing.T) {
 		})
 	}
 }
+
+func TestPackageToInstalled(t *testing.T) {
+	pkg := &Package{
+		Name:             "gcc-doc",
+		Version:          "14.2.0-r0",
+		Arch:             "x86_64",
+		Description:      "GCC documentation",
+		License:          "GPL-3.0-or-later",
+		Origin:           "gcc",
+		Checksum:         []byte{0x01, 0x02, 0x03},
+		Dependencies:     []string{"gcc", ""},
+		InstallIf:        []string{"gcc=14.2.0-r0", "docs"},
+		ProviderPriority: 10,
+		Replaces:         []string{"gcc-docs"},
+		ReplacesPriority: 5,
+		Size:             100,
+		InstalledSize:    200,
+	}
+	want := []string{
+		"C:Q1AQID",
+		"P:gcc-doc",
+		"V:14.2.0-r0",
+		"A:x86_64",
+		"S:100",
+		"I:200",
+		"T:GCC documentation",
+		"U:",
+		"L:GPL-3.0-or-later",
+		"o:gcc",
+		"k:10",
+		"D:gcc",
+		"i:gcc=14.2.0-r0 docs",
+		"r:gcc-docs",
+		"q:5",
+	}
+	if diff := cmp.Diff(want, PackageToInstalled(pkg)); diff != "" {
+		t.Errorf("PackageToInstalled() mismatch (-want +got):\n%s", diff)
+	}
+}
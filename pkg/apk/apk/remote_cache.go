@@ -0,0 +1,193 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+)
+
+// RemoteCache is a cache of downloaded packages and indexes shared between
+// machines, such as a bucket or a registry that ephemeral CI runners all use.
+type RemoteCache interface {
+	// Get returns the contents cached under key, or an error wrapping
+	// fs.ErrNotExist if there are none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put caches the contents of the file at path under key.
+	Put(ctx context.Context, key, path string) error
+}
+
+// WithRemoteCache reads the packages and indexes that are not in the local
+// cache through rc, and writes those it misses back to it once they are
+// downloaded. Packages are cached by URL, and indexes by URL and ETag, so
+// indexes without an ETag are not cached.
+func WithRemoteCache(rc RemoteCache) Option {
+	return func(o *opts) error {
+		o.remoteCache = rc
+		return nil
+	}
+}
+
+// newRemoteCacheTransport returns a transport that answers the GET requests
+// of packages and indexes from rc, or else from rt and writes them back to rc.
+func newRemoteCacheTransport(rt http.RoundTripper, rc RemoteCache) http.RoundTripper {
+	if rc == nil {
+		return rt
+	}
+	return &remoteCacheTransport{rt: rt, rc: rc}
+}
+
+type remoteCacheTransport struct {
+	rt http.RoundTripper
+	rc RemoteCache
+}
+
+func (t *remoteCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" {
+		return t.rt.RoundTrip(req)
+	}
+	ctx, span := otel.Tracer("go-apk").Start(req.Context(), "remoteCacheTransport.RoundTrip")
+	defer span.End()
+	log := clog.FromContext(ctx)
+
+	key, header, err := t.key(req)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return t.rt.RoundTrip(req)
+	}
+
+	body, err := t.rc.Get(ctx, key)
+	if err == nil {
+		log.Debugf("remote cache hit for %s", key)
+		return &http.Response{
+			Request:       req,
+			StatusCode:    http.StatusOK,
+			Status:        http.StatusText(http.StatusOK),
+			Header:        header,
+			Body:          body,
+			ContentLength: -1,
+		}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		log.Warnf("reading %s from the remote cache: %v", key, err)
+	}
+
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	if strings.HasSuffix(req.URL.Path, indexFilename) {
+		// Skip the index if it changed since the HEAD request.
+		if etag, ok := etagFromResponse(resp); !ok || path.Base(key) != etag+".tar.gz" {
+			return resp, nil
+		}
+	}
+	tmp, err := os.CreateTemp("", "apko-remote-cache-*")
+	if err != nil {
+		log.Warnf("not writing %s back to the remote cache: %v", key, err)
+		return resp, nil
+	}
+	resp.Body = &writeBackReader{ctx: ctx, body: resp.Body, tmp: tmp, rc: t.rc, key: key}
+	return resp, nil
+}
+
+// key returns the key req is cached under, and the headers of a response
+// served from the cache, or "" if req is not cacheable: only packages, and
+// indexes with an ETag, are.
+func (t *remoteCacheTransport) key(req *http.Request) (string, http.Header, error) {
+	base := req.URL.Host + req.URL.Path
+	switch {
+	case strings.HasSuffix(req.URL.Path, ".apk"):
+		return base, http.Header{}, nil
+	case strings.HasSuffix(req.URL.Path, indexFilename):
+		// Indexes change in place, so they are cached under their ETag,
+		// which responses from the cache have too.
+		head := req.Clone(req.Context())
+		head.Method = http.MethodHead
+		head.Body = nil
+		resp, err := t.rt.RoundTrip(head)
+		if err != nil {
+			return "", nil, err
+		}
+		resp.Body.Close()
+		etag, ok := etagFromResponse(resp)
+		if resp.StatusCode != http.StatusOK || !ok {
+			return "", nil, nil
+		}
+		header := http.Header{}
+		header.Set("ETag", resp.Header.Get("ETag"))
+		return path.Join(base, "..", "APKINDEX", etag+".tar.gz"), header, nil
+	}
+	return "", nil, nil
+}
+
+// writeBackReader reads body while copying it to tmp, and writes it to the
+// remote cache once it has been read in full.
+type writeBackReader struct {
+	ctx  context.Context
+	body io.ReadCloser
+	tmp  *os.File
+	rc   RemoteCache
+	key  string
+	err  error
+}
+
+func (r *writeBackReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if n > 0 && r.tmp != nil && r.err == nil {
+		_, r.err = r.tmp.Write(p[:n])
+	}
+	if errors.Is(err, io.EOF) && r.tmp != nil {
+		r.writeBack()
+	}
+	return n, err
+}
+
+func (r *writeBackReader) writeBack() {
+	log := clog.FromContext(r.ctx)
+	name := r.tmp.Name()
+	defer os.Remove(name)
+	err := errors.Join(r.err, r.tmp.Close())
+	r.tmp = nil
+	if err == nil {
+		err = r.rc.Put(r.ctx, r.key, name)
+	}
+	if err != nil {
+		log.Warnf("writing %s back to the remote cache: %v", r.key, err)
+		return
+	}
+	log.Debugf("wrote %s back to the remote cache", r.key)
+}
+
+func (r *writeBackReader) Close() error {
+	if r.tmp != nil {
+		// The body was not read in full, so there is nothing to write back.
+		r.tmp.Close()
+		os.Remove(r.tmp.Name())
+		r.tmp = nil
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,128 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// memoryRemoteCache is a RemoteCache in memory.
+type memoryRemoteCache struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (c *memoryRemoteCache) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.files[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (c *memoryRemoteCache) Put(_ context.Context, key, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[key] = b
+	return nil
+}
+
+func TestRemoteCacheTransport(t *testing.T) {
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		switch req.URL.Path {
+		case "/os/x86_64/APKINDEX.tar.gz":
+			w.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(w, "index")
+		case "/noetag/x86_64/APKINDEX.tar.gz":
+			fmt.Fprint(w, "index")
+		case "/os/x86_64/hello-1.0-r0.apk":
+			fmt.Fprint(w, "package")
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer s.Close()
+
+	rc := &memoryRemoteCache{files: map[string][]byte{}}
+	client := &http.Client{Transport: newRemoteCacheTransport(s.Client().Transport, rc)}
+	get := func(path string) (string, http.Header) {
+		t.Helper()
+		resp, err := client.Get(s.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b), resp.Header
+	}
+	host := strings.TrimPrefix(s.URL, "http://")
+
+	// Packages are read through the cache and written back to it.
+	body, _ := get("/os/x86_64/hello-1.0-r0.apk")
+	require.Equal(t, "package", body)
+	body, _ = get("/os/x86_64/hello-1.0-r0.apk")
+	require.Equal(t, "package", body)
+	require.Equal(t, []string{"GET /os/x86_64/hello-1.0-r0.apk"}, requests)
+	require.Equal(t, "package", string(rc.files[host+"/os/x86_64/hello-1.0-r0.apk"]))
+
+	// Indexes are cached under their ETag, which is checked every time.
+	requests = nil
+	body, _ = get("/os/x86_64/APKINDEX.tar.gz")
+	require.Equal(t, "index", body)
+	body, header := get("/os/x86_64/APKINDEX.tar.gz")
+	require.Equal(t, "index", body)
+	require.Equal(t, `"v1"`, header.Get("ETag"))
+	require.Equal(t, []string{
+		"HEAD /os/x86_64/APKINDEX.tar.gz",
+		"GET /os/x86_64/APKINDEX.tar.gz",
+		"HEAD /os/x86_64/APKINDEX.tar.gz",
+	}, requests)
+	etag, _ := etagFromResponse(&http.Response{Header: header})
+	require.Contains(t, rc.files, host+"/os/x86_64/APKINDEX/"+etag+".tar.gz")
+
+	// Indexes without an ETag are not cached.
+	requests = nil
+	get("/noetag/x86_64/APKINDEX.tar.gz")
+	get("/noetag/x86_64/APKINDEX.tar.gz")
+	require.Len(t, requests, 4)
+	require.Len(t, rc.files, 2)
+
+	// Missing files are not cached.
+	resp, err := client.Get(s.URL + "/os/x86_64/missing-1.0-r0.apk")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+	require.Len(t, rc.files, 2)
+}
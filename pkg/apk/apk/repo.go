@@ -92,6 +92,14 @@ func (n *namedRepositoryWithIndex) Source() string {
 	return n.repo.IndexURI()
 }
 
+// Digest returns the digest of the index, if known.
+func (n *namedRepositoryWithIndex) Digest() string {
+	if n.repo == nil {
+		return ""
+	}
+	return n.repo.Digest()
+}
+
 // repositoryPackage is a package that is part of a repository.
 // it is nearly identical to RepositoryPackage, but it includes the pinned name of the repository.
 type repositoryPackage struct {
@@ -185,6 +193,7 @@ func (a *APK) GetRepositoryIndexes(ctx context.Context, ignoreSignatures bool) (
 	opts := []IndexOption{
 		WithIgnoreSignatures(ignoreSignatures),
 		WithIgnoreSignatureForIndexes(a.noSignatureIndexes...),
+		WithFIPSSignatures(a.fips),
 		WithHTTPClient(httpClient),
 		WithIndexAuthenticator(a.auth),
 	}
@@ -210,6 +219,46 @@ type PkgResolver struct {
 
 	// Short-circuit providers we have already selected.
 	selected map[string]*RepositoryPackage
+
+	// preferred is the package to prefer among the providers of a name.
+	preferred map[string]string
+	// tieBreak decides between providers of the same priority.
+	tieBreak ProviderTieBreak
+	// ambiguous records, by name, the providers that had to be decided
+	// between by the tie-break, the picked one first.
+	ambiguous map[string][]string
+}
+
+// ProviderTieBreak decides between packages of different names that provide
+// the same name, such as a so: or cmd: virtual, with the same provider
+// priority.
+type ProviderTieBreak string
+
+const (
+	// TieBreakVersion picks the provider with the highest provided version,
+	// then the first by name. It is the default.
+	TieBreakVersion ProviderTieBreak = "version"
+	// TieBreakAlphabetical picks the first provider by name, whatever its
+	// version.
+	TieBreakAlphabetical ProviderTieBreak = "alphabetical"
+	// TieBreakOrigin picks the first provider by origin, then as
+	// TieBreakVersion among those of the same origin.
+	TieBreakOrigin ProviderTieBreak = "origin"
+	// TieBreakPinned picks a provider from a tagged repository, such as
+	// "@local", over the others, then as TieBreakVersion.
+	TieBreakPinned ProviderTieBreak = "pinned"
+)
+
+// ParseProviderTieBreak parses a tie-break name. The empty name is
+// TieBreakVersion.
+func ParseProviderTieBreak(s string) (ProviderTieBreak, error) {
+	switch t := ProviderTieBreak(s); t {
+	case "":
+		return TieBreakVersion, nil
+	case TieBreakVersion, TieBreakAlphabetical, TieBreakOrigin, TieBreakPinned:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown provider tie-break %q, expected %s, %s, %s or %s", s, TieBreakVersion, TieBreakAlphabetical, TieBreakOrigin, TieBreakPinned)
 }
 
 // Clone returns a copy of PkgResolver.
@@ -219,9 +268,31 @@ func (p *PkgResolver) Clone() *PkgResolver {
 		nameMap:      p.nameMap,
 		installIfMap: p.installIfMap,
 		selected:     map[string]*RepositoryPackage{},
+		preferred:    p.preferred,
+		tieBreak:     p.tieBreak,
 	}
 }
 
+// PreferProviders sets the package to prefer among the providers of each name,
+// by name. A preferred package wins over every other criterion, including the
+// provider_priority of the indexes, as long as it satisfies the constraint; a
+// preferred package that does not provide the name is ignored.
+func (p *PkgResolver) PreferProviders(providers map[string]string) {
+	p.preferred = providers
+}
+
+// BreakTiesBy sets how to decide between providers of the same priority.
+func (p *PkgResolver) BreakTiesBy(tieBreak ProviderTieBreak) {
+	p.tieBreak = tieBreak
+}
+
+// AmbiguousProviders returns, by name, the providers that were decided
+// between by the tie-break since the resolver was created, the picked one
+// first. Names with a preferred provider are never ambiguous.
+func (p *PkgResolver) AmbiguousProviders() map[string][]string {
+	return p.ambiguous
+}
+
 // NewPkgResolver creates a new pkgResolver from a list of indexes.
 // The indexes are anything that implements NamedIndex.
 func NewPkgResolver(ctx context.Context, indexes []NamedIndex) *PkgResolver {
@@ -294,7 +365,7 @@ func (p *PkgResolver) nextPackage(packages []string, dq map[*RepositoryPackage]s
 			return "", &ConstraintError{pkgName, err}
 		}
 		if len(pkgs) == 0 {
-			return "", fmt.Errorf("could not find package %s", pkgName)
+			return "", &PackageNotFoundError{Name: pkgName}
 		}
 
 		if next == "" {
@@ -645,7 +716,7 @@ func (p *PkgResolver) ResolvePackage(pkgName string, dq map[*RepositoryPackage]s
 	name, version, compare, pin := constraint.Name, constraint.Version, constraint.dep, constraint.pin
 	pkgsWithVersions, ok := p.nameMap[name]
 	if !ok {
-		return nil, fmt.Errorf("nothing provides %q", name)
+		return nil, &PackageNotFoundError{Name: name}
 	}
 
 	// pkgsWithVersions contains a map of all versions of the package
@@ -672,7 +743,7 @@ func (p *PkgResolver) resolvePackage(pkgName string, dq map[*RepositoryPackage]s
 
 	pkgsWithVersions, ok := p.nameMap[name]
 	if !ok {
-		return nil, fmt.Errorf("nothing provides %q", name)
+		return nil, &PackageNotFoundError{Name: name}
 	}
 
 	// pkgsWithVersions contains a map of all versions of the package
@@ -830,7 +901,7 @@ func (p *PkgResolver) getPackageDependencies(ctx context.Context, pkg *Repositor
 			// first see if it is a name of a package
 			depPkgWithVersions, ok := p.nameMap[name]
 			if !ok {
-				return nil, nil, &ConstraintError{dep, fmt.Errorf("nothing provides %q", name)}
+				return nil, nil, &ConstraintError{dep, &PackageNotFoundError{Name: name}}
 			}
 			// pkgsWithVersions contains a map of all versions of the package
 			// get the one that most matches what was requested
@@ -873,7 +944,7 @@ func (p *PkgResolver) getPackageDependencies(ctx context.Context, pkg *Repositor
 
 		best := p.bestPackage(pkgs, nil, name, existing, existingOrigins, "")
 		if best == nil {
-			return nil, nil, &ConstraintError{name, fmt.Errorf("could not find package for %q", name)}
+			return nil, nil, &ConstraintError{name, &PackageNotFoundError{Name: name}}
 		}
 
 		depPkg := best.RepositoryPackage
@@ -950,6 +1021,15 @@ func (p *PkgResolver) sortPackages(pkgs []*repositoryPackage, compare *Repositor
 
 func (p *PkgResolver) comparePackages(compare *RepositoryPackage, name string, existing map[string]*RepositoryPackage, existingOrigins map[string]bool, pin string) func(a, b *repositoryPackage) int { //nolint:gocyclo
 	return func(a, b *repositoryPackage) int {
+		// an explicitly preferred provider wins
+		if preferred, ok := p.preferred[name]; ok && a.Name != b.Name {
+			if a.Name == preferred {
+				return -1
+			}
+			if b.Name == preferred {
+				return 1
+			}
+		}
 		// determine versions
 		iVersionStr := p.getDepVersionForName(a, name)
 		jVersionStr := p.getDepVersionForName(b, name)
@@ -1000,11 +1080,15 @@ func (p *PkgResolver) comparePackages(compare *RepositoryPackage, name string, e
 			return 1
 		}
 
-		if a.pinnedName == pin && b.pinnedName != pin {
-			return -1
-		}
-		if a.pinnedName != pin && b.pinnedName == pin {
-			return 1
+		// unless pinned providers are preferred, which is decided after the
+		// provider priority, prefer the requested repository tag
+		if p.tieBreak != TieBreakPinned || pin != "" {
+			if a.pinnedName == pin && b.pinnedName != pin {
+				return -1
+			}
+			if a.pinnedName != pin && b.pinnedName == pin {
+				return 1
+			}
 		}
 
 		// check provider priority
@@ -1016,6 +1100,23 @@ func (p *PkgResolver) comparePackages(compare *RepositoryPackage, name string, e
 			// a < b
 			return 1
 		}
+		if a.Name != b.Name {
+			switch p.tieBreak {
+			case TieBreakAlphabetical:
+				return cmp.Compare(a.Name, b.Name)
+			case TieBreakOrigin:
+				if a.Origin != b.Origin {
+					return cmp.Compare(a.Origin, b.Origin)
+				}
+			case TieBreakPinned:
+				if (a.pinnedName != "") != (b.pinnedName != "") {
+					if a.pinnedName != "" {
+						return -1
+					}
+					return 1
+				}
+			}
+		}
 		// both matched or both did not, so just compare versions
 		// version priority
 		iVersion, err := cachedParseVersion(iVersionStr)
@@ -1056,7 +1157,42 @@ func (p *PkgResolver) bestPackage(pkgs []*repositoryPackage, compare *Repository
 	if len(pkgs) == 0 {
 		return nil
 	}
-	return slices.MinFunc(pkgs, p.comparePackages(compare, name, existing, existingOrigins, pin))
+	best := slices.MinFunc(pkgs, p.comparePackages(compare, name, existing, existingOrigins, pin))
+	if _, installed := existing[best.Name]; !installed {
+		p.recordAmbiguous(pkgs, name, best)
+	}
+	return best
+}
+
+// recordAmbiguous records the providers of name among pkgs if several of
+// them, by name, have the same provider priority and repository tag as best,
+// unless name has a preferred provider.
+func (p *PkgResolver) recordAmbiguous(pkgs []*repositoryPackage, name string, best *repositoryPackage) {
+	if name == "" || best.Name == name {
+		return
+	}
+	if _, ok := p.preferred[name]; ok {
+		return
+	}
+	candidates := []string{best.Name}
+	for _, pkg := range pkgs {
+		if pkg.Name == name {
+			// A package of that name is not a tie.
+			return
+		}
+		if slices.Contains(candidates, pkg.Name) || pkg.ProviderPriority != best.ProviderPriority || pkg.pinnedName != best.pinnedName {
+			continue
+		}
+		candidates = append(candidates, pkg.Name)
+	}
+	if len(candidates) < 2 {
+		return
+	}
+	slices.Sort(candidates[1:])
+	if p.ambiguous == nil {
+		p.ambiguous = map[string][]string{}
+	}
+	p.ambiguous[name] = candidates
 }
 
 // getDepVersionForName get the version of the package that provides the given name.
@@ -1085,6 +1221,8 @@ func (p *PkgResolver) getDepVersionForName(pkg *repositoryPackage, name string)
 	return ""
 }
 
+// ConstraintError is returned when a constraint cannot be solved. It matches
+// ErrUnsatisfiableConstraint, and wraps the reason, e.g. a PackageNotFoundError.
 type ConstraintError struct {
 	Constraint string
 	Wrapped    error
@@ -1094,6 +1232,10 @@ func (e *ConstraintError) Unwrap() error {
 	return e.Wrapped
 }
 
+func (e *ConstraintError) Is(target error) bool {
+	return target == ErrUnsatisfiableConstraint
+}
+
 func (e *ConstraintError) Error() string {
 	return fmt.Sprintf("solving %q constraint: %s", e.Constraint, e.Wrapped.Error())
 }
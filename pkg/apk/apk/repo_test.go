@@ -334,6 +334,7 @@ func TestIndexAuth_bad(t *testing.T) {
 	require.NoError(t, err, "unable to set repositories")
 	_, err = a.GetRepositoryIndexes(ctx, true)
 	require.Error(t, err, "should fail with bad auth")
+	require.ErrorIs(t, err, ErrAuthRequired)
 	require.True(t, called, "did not make request")
 }
 
@@ -556,6 +557,18 @@ func TestGetPackagesWithDependences(t *testing.T) {
 		sort.Strings(names)
 		_, _, err := resolver.GetPackagesWithDependencies(context.Background(), names, nil)
 		require.Error(t, err, "Packages should conflict")
+		require.ErrorIs(t, err, ErrUnsatisfiableConstraint)
+	})
+	t.Run("missing package", func(t *testing.T) {
+		_, index := testGetPackagesAndIndex()
+		resolver := NewPkgResolver(context.Background(), testNamedRepositoryFromIndexes(index))
+		_, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"does-not-exist"}, nil)
+		require.ErrorIs(t, err, ErrUnsatisfiableConstraint)
+		require.ErrorIs(t, err, ErrPackageNotFound)
+
+		var nf *PackageNotFoundError
+		require.ErrorAs(t, err, &nf)
+		require.Equal(t, "does-not-exist", nf.Name)
 	})
 }
 
@@ -629,6 +642,7 @@ func TestResolvePackage(t *testing.T) {
 		resolver := NewPkgResolver(context.Background(), testNamedRepositoryFromIndexes(index))
 		pkgs, err := resolver.ResolvePackage("package12", map[*RepositoryPackage]string{})
 		require.Error(t, err)
+		require.ErrorIs(t, err, ErrPackageNotFound)
 		require.Len(t, pkgs, 0)
 	})
 	t.Run("any version", func(t *testing.T) {
@@ -900,6 +914,94 @@ func TestHigherProvidedVersion(t *testing.T) {
 	}
 }
 
+func TestPreferProviders(t *testing.T) {
+	repo := Repository{}
+	repoWithIndex := repo.WithIndex(&APKIndex{Packages: []*Package{
+		{Name: "python-3.13", Version: "3.13.1-r0", Provides: []string{"cmd:python3=3.13.1-r0"}, ProviderPriority: 10},
+		{Name: "python-3.12", Version: "3.12.8-r0", Provides: []string{"cmd:python3=3.12.8-r0"}, ProviderPriority: 5},
+		{Name: "python-3.11", Version: "3.11.11-r0", Provides: []string{"cmd:python3=3.11.11-r0"}},
+		{Name: "app", Version: "1.0-r0", Dependencies: []string{"cmd:python3"}},
+	}})
+	indexes := testNamedRepositoryFromIndexes([]*RepositoryWithIndex{repoWithIndex})
+
+	for _, tt := range []struct {
+		name      string
+		preferred map[string]string
+		want      string
+	}{
+		{name: "provider priority", want: "python-3.13-3.13.1-r0.apk"},
+		{name: "preferred", preferred: map[string]string{"cmd:python3": "python-3.11"}, want: "python-3.11-3.11.11-r0.apk"},
+		{name: "preferred for another name", preferred: map[string]string{"cmd:python": "python-3.11"}, want: "python-3.13-3.13.1-r0.apk"},
+		{name: "preferred does not provide", preferred: map[string]string{"cmd:python3": "app"}, want: "python-3.13-3.13.1-r0.apk"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := NewPkgResolver(context.Background(), indexes)
+			resolver.PreferProviders(tt.preferred)
+			pkgs, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"app"}, nil)
+			require.NoError(t, err)
+			require.Len(t, pkgs, 2)
+			require.Equal(t, tt.want, pkgs[0].Filename())
+		})
+	}
+}
+
+func TestProviderTieBreak(t *testing.T) {
+	main := (&Repository{}).WithIndex(&APKIndex{Packages: []*Package{
+		{Name: "mawk", Version: "1.3.4-r0", Origin: "mawk", Provides: []string{"cmd:awk=1.3.4-r0"}},
+		{Name: "gawk", Version: "5.3.1-r0", Origin: "gawk", Provides: []string{"cmd:awk=5.3.1-r0"}},
+		{Name: "busybox-awk", Version: "1.37.0-r0", Origin: "busybox", Provides: []string{"cmd:awk=1.37.0-r0"}},
+		{Name: "app", Version: "1.0-r0", Dependencies: []string{"cmd:awk"}},
+	}})
+	local := (&Repository{}).WithIndex(&APKIndex{Packages: []*Package{
+		{Name: "local-awk", Version: "0.1-r0", Origin: "local-awk", Provides: []string{"cmd:awk=0.1-r0"}},
+		{Name: "local-app", Version: "1.0-r0", Dependencies: []string{"cmd:awk"}},
+	}})
+	indexes := []NamedIndex{NewNamedRepositoryWithIndex("", main), NewNamedRepositoryWithIndex("local", local)}
+
+	for _, tt := range []struct {
+		tieBreak ProviderTieBreak
+		world    string
+		want     string
+	}{
+		{tieBreak: "", world: "app", want: "gawk"},
+		{tieBreak: TieBreakVersion, world: "app", want: "gawk"},
+		{tieBreak: TieBreakAlphabetical, world: "app", want: "busybox-awk"},
+		{tieBreak: TieBreakOrigin, world: "app", want: "busybox-awk"},
+		{tieBreak: TieBreakVersion, world: "local-app@local", want: "gawk"},
+		{tieBreak: TieBreakPinned, world: "local-app@local", want: "local-awk"},
+	} {
+		t.Run(string(tt.tieBreak)+" "+tt.world, func(t *testing.T) {
+			resolver := NewPkgResolver(context.Background(), indexes)
+			resolver.BreakTiesBy(tt.tieBreak)
+			pkgs, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{tt.world}, nil)
+			require.NoError(t, err)
+			require.Len(t, pkgs, 2)
+			require.Equal(t, tt.want, pkgs[0].Name)
+			if tt.tieBreak != TieBreakPinned {
+				require.Equal(t, []string{tt.want, "busybox-awk", "gawk", "mawk"}[:1], resolver.AmbiguousProviders()["cmd:awk"][:1])
+				require.ElementsMatch(t, []string{"busybox-awk", "gawk", "mawk"}, resolver.AmbiguousProviders()["cmd:awk"])
+			}
+		})
+	}
+
+	resolver := NewPkgResolver(context.Background(), indexes)
+	resolver.PreferProviders(map[string]string{"cmd:awk": "mawk"})
+	_, _, err := resolver.GetPackagesWithDependencies(context.Background(), []string{"app"}, nil)
+	require.NoError(t, err)
+	require.Empty(t, resolver.AmbiguousProviders())
+}
+
+func TestParseProviderTieBreak(t *testing.T) {
+	tieBreak, err := ParseProviderTieBreak("")
+	require.NoError(t, err)
+	require.Equal(t, TieBreakVersion, tieBreak)
+	tieBreak, err = ParseProviderTieBreak("origin")
+	require.NoError(t, err)
+	require.Equal(t, TieBreakOrigin, tieBreak)
+	_, err = ParseProviderTieBreak("random")
+	require.ErrorContains(t, err, `unknown provider tie-break "random"`)
+}
+
 func TestConstrains(t *testing.T) {
 	providers := map[string][]string{
 		"ld-linux=2.38-r10": {"so:ld-linux-aarch64.so.1=1.0"},
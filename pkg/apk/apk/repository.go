@@ -65,6 +65,11 @@ func (r *RepositoryWithIndex) Count() int {
 	return len(r.index.Packages)
 }
 
+// Digest returns the digest of the index of this repository, if known.
+func (r *RepositoryWithIndex) Digest() string {
+	return r.index.Digest
+}
+
 // RepoAbbr returns a short name of this repository consisting of the repo name
 // and the architecture.
 func (r *RepositoryWithIndex) RepoAbbr() string {
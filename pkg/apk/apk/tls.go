@@ -0,0 +1,160 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// RepositoryTLS configures TLS for the requests to a repository, or any
+// other URL such as a keyring URL, for example to trust the CA of a corporate
+// proxy or to authenticate to an internal repository with a client certificate.
+type RepositoryTLS struct {
+	// URL is the prefix of the URLs the configuration applies to, such as
+	// "https://apk.example.com/os". When several configurations match a
+	// request, the one with the longest URL is used.
+	URL string `json:"url" yaml:"url"`
+	// CAFile is a PEM bundle of CA certificates to trust in addition to the
+	// system roots.
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// CertFile and KeyFile are the PEM certificate and private key to present
+	// to the server. They must be set together.
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	// MinVersion is the minimum TLS version to accept: "1.0", "1.1", "1.2"
+	// or "1.3". The default is the Go default, TLS 1.2.
+	MinVersion string `json:"minVersion,omitempty" yaml:"minVersion,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// config returns a copy of base, which may be nil, with the settings of r.
+func (r RepositoryTLS) config(base *tls.Config) (*tls.Config, error) {
+	c := &tls.Config{}
+	if base != nil {
+		c = base.Clone()
+	}
+	if r.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("loading system CA certificates: %w", err)
+		}
+		b, err := os.ReadFile(r.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", r.CAFile)
+		}
+		c.RootCAs = pool
+	}
+	if (r.CertFile == "") != (r.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+	if r.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	if r.MinVersion != "" {
+		v, ok := tlsVersions[r.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported minimum TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", r.MinVersion)
+		}
+		// Never loosen a minimum set by the base configuration, such as the
+		// one of FIPS mode.
+		c.MinVersion = max(c.MinVersion, v)
+	}
+	return c, nil
+}
+
+type tlsRoute struct {
+	prefix string
+	rt     http.RoundTripper
+}
+
+// repositoryTLSTransport sends each request with the transport configured
+// for the longest matching URL prefix, or the base transport.
+type repositoryTLSTransport struct {
+	base   http.RoundTripper
+	routes []tlsRoute
+}
+
+// newRepositoryTLSTransport returns a transport that applies configs to the
+// requests they match, on top of the TLS configuration of base, which must be
+// an *http.Transport.
+func newRepositoryTLSTransport(base http.RoundTripper, configs []RepositoryTLS) (http.RoundTripper, error) {
+	if len(configs) == 0 {
+		return base, nil
+	}
+	t, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("repository TLS configuration requires an *http.Transport, got %T", base)
+	}
+
+	routes := make([]tlsRoute, 0, len(configs))
+	for _, r := range configs {
+		prefix, err := tlsPrefix(r.URL)
+		if err != nil {
+			return nil, fmt.Errorf("repository TLS configuration for %q: %w", r.URL, err)
+		}
+		c, err := r.config(t.TLSClientConfig)
+		if err != nil {
+			return nil, fmt.Errorf("repository TLS configuration for %s: %w", prefix, err)
+		}
+		rt := t.Clone()
+		rt.TLSClientConfig = c
+		routes = append(routes, tlsRoute{prefix: prefix, rt: rt})
+	}
+	slices.SortStableFunc(routes, func(a, b tlsRoute) int { return len(b.prefix) - len(a.prefix) })
+	return &repositoryTLSTransport{base: base, routes: routes}, nil
+}
+
+// tlsPrefix returns the URL u to match requests against, without any
+// credentials or trailing slash.
+func tlsPrefix(u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return "", fmt.Errorf("URL must be an https:// URL")
+	}
+	return "https://" + parsed.Host + strings.TrimSuffix(parsed.Path, "/"), nil
+}
+
+func (t *repositoryTLSTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := req.URL.Scheme + "://" + req.URL.Host + req.URL.Path
+	for _, r := range t.routes {
+		if u == r.prefix || strings.HasPrefix(u, r.prefix+"/") {
+			return r.rt.RoundTrip(req)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
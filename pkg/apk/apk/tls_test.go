@@ -0,0 +1,143 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeClientCert writes a self-signed client certificate and its key to dir.
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "apko"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return cert, certFile, keyFile
+}
+
+func TestRepositoryTLS(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := writeClientCert(t, dir)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		MaxVersion: tls.VersionTLS12,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+
+	get := func(t *testing.T, configs ...RepositoryTLS) error {
+		rt, err := newRepositoryTLSTransport(&http.Transport{}, configs)
+		require.NoError(t, err)
+		resp, err := (&http.Client{Transport: rt}).Get(srv.URL + "/os/x86_64/APKINDEX.tar.gz")
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	t.Run("no configuration", func(t *testing.T) {
+		require.ErrorContains(t, get(t), "certificate")
+	})
+	t.Run("ca only", func(t *testing.T) {
+		// The server trusts the CA, but requires a client certificate.
+		require.Error(t, get(t, RepositoryTLS{URL: srv.URL, CAFile: caFile}))
+	})
+	t.Run("mtls", func(t *testing.T) {
+		require.NoError(t, get(t, RepositoryTLS{URL: srv.URL + "/os/", CAFile: caFile, CertFile: certFile, KeyFile: keyFile}))
+	})
+	t.Run("non-matching prefix", func(t *testing.T) {
+		require.Error(t, get(t, RepositoryTLS{URL: srv.URL + "/o", CAFile: caFile, CertFile: certFile, KeyFile: keyFile}))
+	})
+	t.Run("longest prefix wins", func(t *testing.T) {
+		require.NoError(t, get(t,
+			RepositoryTLS{URL: srv.URL, MinVersion: "1.3"},
+			RepositoryTLS{URL: srv.URL + "/os", CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+		))
+	})
+	t.Run("min version", func(t *testing.T) {
+		require.ErrorContains(t, get(t, RepositoryTLS{URL: srv.URL, CAFile: caFile, CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3"}), "protocol version")
+	})
+}
+
+func TestRepositoryTLSErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config RepositoryTLS
+		want   string
+	}{
+		{"http url", RepositoryTLS{URL: "http://apk.example.com"}, "must be an https:// URL"},
+		{"cert without key", RepositoryTLS{URL: "https://apk.example.com", CertFile: "client.pem"}, "must be set together"},
+		{"missing ca", RepositoryTLS{URL: "https://apk.example.com", CAFile: filepath.Join(t.TempDir(), "ca.pem")}, "reading CA bundle"},
+		{"bad version", RepositoryTLS{URL: "https://apk.example.com", MinVersion: "1.4"}, `unsupported minimum TLS version "1.4"`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newRepositoryTLSTransport(&http.Transport{}, []RepositoryTLS{tc.config})
+			require.ErrorContains(t, err, tc.want)
+		})
+	}
+
+	_, err := newRepositoryTLSTransport(&testLocalTransport{}, []RepositoryTLS{{URL: "https://apk.example.com"}})
+	require.ErrorContains(t, err, "requires an *http.Transport")
+}
+
+func TestRepositoryTLSKeepsFIPSMinimum(t *testing.T) {
+	c, err := RepositoryTLS{URL: "https://apk.example.com", MinVersion: "1.0"}.config(FIPSTLSConfig(nil))
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), c.MinVersion)
+}
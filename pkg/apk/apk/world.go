@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 
@@ -45,12 +46,16 @@ func (a *APK) SetWorld(ctx context.Context, packages []string) error {
 	log := clog.FromContext(ctx)
 	log.Debug("setting apk world")
 
-	// sort them before writing
+	// sort and deduplicate them before writing, as apk does
 	copied := make([]string, len(packages))
 	copy(copied, packages)
 	sort.Strings(copied)
+	copied = slices.Compact(copied)
 
-	data := strings.Join(copied, "\n") + "\n"
+	var data string
+	if len(copied) != 0 {
+		data = strings.Join(copied, "\n") + "\n"
+	}
 
 	// #nosec G306 -- apk world must be publicly readable
 	if err := a.fs.WriteFile(filepath.Join("etc", "apk", "world"),
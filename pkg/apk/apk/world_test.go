@@ -36,3 +36,23 @@ func TestGetWorld(t *testing.T) {
 	require.NoError(t, err, "unable to get world packages")
 	require.Equal(t, strings.Join(packages, " "), strings.Join(pkgs, " "), "expected packages %v, got %v", packages, pkgs)
 }
+
+func TestSetWorldDeduplicates(t *testing.T) {
+	src := apkfs.NewMemFS()
+	err := src.MkdirAll("etc/apk", 0o755)
+	require.NoError(t, err, "unable to mkdir /etc/apk")
+	a, err := New(t.Context(), WithFS(src), WithIgnoreMknodErrors(ignoreMknodErrors))
+	require.NoError(t, err, "unable to create APK")
+
+	err = a.SetWorld(t.Context(), []string{"package2", "package1", "package2"})
+	require.NoError(t, err, "unable to set world packages")
+	world, err := src.ReadFile(worldFilePath)
+	require.NoError(t, err, "unable to read world file")
+	require.Equal(t, "package1\npackage2\n", string(world))
+
+	err = a.SetWorld(t.Context(), nil)
+	require.NoError(t, err, "unable to set world packages")
+	world, err = src.ReadFile(worldFilePath)
+	require.NoError(t, err, "unable to read world file")
+	require.Empty(t, world)
+}
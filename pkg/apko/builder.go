@@ -0,0 +1,429 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apko
+
+import (
+	"context"
+	"crypto/fips140"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/sync/errgroup"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/keychain"
+	"chainguard.dev/apko/pkg/report"
+	"chainguard.dev/apko/pkg/tarfs"
+)
+
+// Builder builds multi-architecture OCI images from an apko configuration.
+//
+// Builder is the supported entry point for embedding apko in other programs.
+// Its exported API (New, the Option constructors, Build and Result) follows
+// semantic versioning: it will not change incompatibly within a major version,
+// even when the packages it is implemented with (such as pkg/build) are refactored.
+type Builder struct {
+	archs      []types.Architecture
+	opts       []build.Option
+	workDir    string
+	keychain   authn.Keychain
+	remoteOpts []remote.Option
+}
+
+// Result holds the outputs of a build. The image layers are backed by files in
+// the builder's working directory, so Close must only be called once the
+// result has been written or published.
+type Result struct {
+	// Index is the multi-architecture image index.
+	Index v1.ImageIndex
+	// SBOMs are the SBOM files that were generated, if any.
+	SBOMs []types.SBOM
+
+	remoteOpts []remote.Option
+	cleanup    func() error
+}
+
+// New creates a Builder. At least a configuration source (WithConfigFile or
+// WithImageConfiguration) is required.
+func New(_ context.Context, opts ...Option) (*Builder, error) {
+	b := &Builder{}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+
+	// Surface bad options (e.g. an unreadable config file) early.
+	if _, _, err := build.NewOptions(b.opts...); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Build builds the image for every requested architecture and assembles the
+// index (and SBOMs, if requested).
+func (b *Builder) Build(ctx context.Context) (*Result, error) {
+	kc := b.keychain
+	if kc == nil {
+		kc = keychain.Default
+	}
+	o, _, err := build.NewOptions(b.opts...)
+	if err != nil {
+		return nil, err
+	}
+	transport := remote.DefaultTransport
+	if o.FIPS {
+		transport = apk.FIPSTransport(transport)
+	}
+	res := &Result{
+		remoteOpts: append([]remote.Option{
+			remote.WithAuthFromKeychain(kc),
+			remote.WithTransport(oci.RateLimitTransport(transport)),
+		}, b.remoteOpts...),
+		cleanup: func() error { return nil },
+	}
+
+	workDir := b.workDir
+	if workDir == "" {
+		wd, err := os.MkdirTemp("", "apko-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create working directory: %w", err)
+		}
+		workDir = wd
+		res.cleanup = func() error { return os.RemoveAll(wd) }
+	}
+
+	// Keep the layer blobs in the working directory unless the caller picked
+	// a temporary directory of their own (later options override earlier ones).
+	tmp := filepath.Join(workDir, "tmp")
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return nil, fmt.Errorf("creating temporary directory: %w", err)
+	}
+	opts := append([]build.Option{build.WithTempDir(tmp)}, b.opts...)
+
+	idx, sboms, err := buildImageComponents(ctx, workDir, b.archs, opts...)
+	if err != nil {
+		_ = res.cleanup()
+		return nil, err
+	}
+	res.Index = idx
+	res.SBOMs = sboms
+	return res, nil
+}
+
+// WriteTarball writes the index as a "docker load" compatible tarball.
+func (r *Result) WriteTarball(path string, tags ...string) (name.Digest, error) {
+	return oci.BuildIndex(path, r.Index, tags)
+}
+
+// WriteLayout writes the index as an OCI image layout in dir.
+func (r *Result) WriteLayout(dir string) error {
+	if _, err := layout.Write(dir, r.Index); err != nil {
+		return fmt.Errorf("writing image layout: %w", err)
+	}
+	return nil
+}
+
+// Publish pushes the image of each architecture and then the index to every
+// one of tags, and returns the digest of the index. Registries are
+// authenticated with the keychain and remote options of the Builder, then
+// opts.
+func (r *Result) Publish(ctx context.Context, tags []string, opts ...remote.Option) (name.Digest, error) {
+	if len(tags) == 0 {
+		return name.Digest{}, fmt.Errorf("no tags to publish to")
+	}
+	ref, err := name.ParseReference(tags[0])
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("parsing %q as tag: %w", tags[0], err)
+	}
+	ropts := append(slices.Clone(r.remoteOpts), opts...)
+	if _, err := oci.PublishImagesFromIndex(ctx, r.Index, ref.Context(), ropts...); err != nil {
+		return name.Digest{}, fmt.Errorf("publishing images from index: %w", err)
+	}
+	dig, err := oci.PublishIndex(ctx, r.Index, tags, ropts...)
+	if err != nil {
+		return name.Digest{}, fmt.Errorf("publishing image index: %w", err)
+	}
+	return dig, nil
+}
+
+// Close removes the working directory, if the Builder created it.
+func (r *Result) Close() error {
+	return r.cleanup()
+}
+
+// buildImageComponents build all of the components of an image in a single working directory.
+// Each layer is a separate file, as are config, manifests, index and sbom.
+func buildImageComponents(ctx context.Context, workDir string, archs []types.Architecture, opts ...build.Option) (idx v1.ImageIndex, sboms []types.SBOM, err error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "buildImageComponents")
+	defer span.End()
+	rep := report.FromContext(ctx)
+
+	o, ic, err := build.NewOptions(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if o.FIPS && !fips140.Enabled() {
+		log.Warnf("FIPS mode is enabled, but the Go FIPS 140-3 module is not; set GODEBUG=fips140=on to use it")
+	}
+
+	if ic.Contents.BaseImage != nil && o.Lockfile == "" {
+		return nil, nil, fmt.Errorf("building with base image is supported only with a lockfile")
+	}
+
+	// cases:
+	// - archs set: use those archs
+	// - archs not set, bc.ImageConfiguration.Archs set: use Config archs
+	// - archs not set, bc.ImageConfiguration.Archs not set: use all archs
+	switch {
+	case len(archs) != 0:
+		ic.Archs = archs
+	case len(ic.Archs) != 0:
+		// do nothing
+	default:
+		ic.Archs = types.AllArchs
+	}
+	// save the final set we will build
+	log.Debugf("Building images for %d architectures: %+v", len(ic.Archs), ic.Archs)
+
+	// Probe the VCS URL if it is not set and we are asked to do so.
+	if o.WithVCS && ic.VCSUrl == "" {
+		ic.ProbeVCSUrl(ctx, o.ImageConfigFile)
+	}
+
+	// The build context options is sometimes copied in the next functions. Ensure
+	// we have the directory defined and created by invoking the function early.
+
+	// workDir, passed to us, is where we will lay out the various image filesystems
+	// under it we will have:
+	//  <arch>/ - the rootfs for each architecture
+	//  image/ - the summary layer files and sboms for each architecture
+	// imageDir, created here, is where the final artifacts will be: layer tars, indexes, etc.
+
+	log.Debugf("building tags %v", o.Tags)
+
+	var errg errgroup.Group
+	imageDir := filepath.Join(workDir, "image")
+	if err := os.MkdirAll(imageDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("unable to create working image directory %s: %w", imageDir, err)
+	}
+	opts = append(opts, build.WithSBOM(imageDir))
+
+	imgs := map[types.Architecture]v1.Image{}
+
+	mtx := sync.Mutex{}
+
+	// We compute the "build date epoch" of the multi-arch image to be the
+	// maximum "build date epoch" of the per-arch images.  If the user has
+	// explicitly set SOURCE_DATE_EPOCH, that will always trump this
+	// computation.
+	multiArchBDE := o.SourceDateEpoch
+
+	configs, _, err := build.LockImageConfiguration(ctx, *ic, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("locking config: %w", err)
+	}
+
+	for arch, ic := range configs {
+		errg.Go(func() error {
+			if arch == "index" {
+				return nil
+			}
+
+			arch := types.ParseArchitecture(arch)
+			log := log.With("arch", arch.ToAPK())
+			ctx := clog.WithLogger(ctx, log)
+
+			opts := slices.Clone(opts)
+			opts = append(opts, build.WithArch(arch), build.WithImageConfiguration(*ic))
+
+			bc, err := build.New(ctx, tarfs.New(), opts...)
+			if err != nil {
+				return fmt.Errorf("new build for arch %s: %w", arch, err)
+			}
+			stop := rep.Time(report.PhaseBuild, arch.ToAPK())
+			layers, err := bc.BuildLayers(ctx)
+			if err != nil {
+				return fmt.Errorf("building %q layer: %w", arch, err)
+			}
+			stop()
+
+			if rep != nil {
+				// Compress the layers now, rather than when the image digest
+				// is first needed, so that compression is timed on its own.
+				stop := rep.Time(report.PhaseCompress, arch.ToAPK())
+				for _, l := range layers {
+					if _, err := l.Digest(); err != nil {
+						return fmt.Errorf("compressing %q layer: %w", arch, err)
+					}
+				}
+				stop()
+			}
+
+			// Compute the "build date epoch" from the packages that were
+			// installed.  The "build date epoch" is the MAX of the builddate
+			// embedded in the installed APKs.  If SOURCE_DATE_EPOCH is
+			// explicitly set by the user, that trumps this.
+			// This computation will only affect the timestamp of the image
+			// itself and its SBOMs, since the timestamps on files come from the
+			// APKs.
+			bde, err := bc.GetBuildDateEpoch()
+			if err != nil {
+				return fmt.Errorf("failed to determine build date epoch: %w", err)
+			}
+
+			img, err := oci.BuildImageFromLayers(ctx, bc.BaseImage(), layers, bc.ImageConfiguration(), bde, bc.Arch())
+			if err != nil {
+				return fmt.Errorf("failed to build OCI image for %q: %w", arch, err)
+			}
+
+			baseAnnotations, err := bc.BaseImageAnnotations()
+			if err != nil {
+				return fmt.Errorf("failed to annotate base image for %q: %w", arch, err)
+			}
+			if len(baseAnnotations) != 0 {
+				img = mutate.Annotations(img, baseAnnotations).(v1.Image)
+			}
+
+			if bc.WantBuildInfo() {
+				bi, err := bc.BuildInfo()
+				if err != nil {
+					return fmt.Errorf("determining build info for %q: %w", arch, err)
+				}
+				if img, err = build.AddBuildInfo(img, bi); err != nil {
+					return fmt.Errorf("adding build info for %q: %w", arch, err)
+				}
+			}
+
+			// The artifactType is lost by mutating the image, so it is set
+			// once the image is complete.
+			img = oci.WithArtifactType(img, bc.ImageConfiguration().ArtifactType)
+
+			if rep != nil {
+				if err := reportImage(rep, bc, img); err != nil {
+					return fmt.Errorf("reporting %q image: %w", arch, err)
+				}
+			}
+
+			var outputs []types.SBOM
+			if len(o.SBOMGenerators) != 0 {
+				stop := rep.Time(report.PhaseSBOM, arch.ToAPK())
+				outputs, err = bc.GenerateImageSBOM(ctx, arch, img)
+				if err != nil {
+					return fmt.Errorf("generating sbom for %s: %w", arch, err)
+				}
+				stop()
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+
+			imgs[arch] = img
+
+			if bde.After(multiArchBDE) {
+				multiArchBDE = bde
+			}
+
+			if len(o.SBOMGenerators) != 0 {
+				sboms = append(sboms, outputs...)
+			}
+
+			return nil
+		})
+	}
+	if err := errg.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	// generate the index
+	finalDigest, idx, err := oci.GenerateIndex(ctx, *ic, imgs, multiArchBDE)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate OCI index: %w", err)
+	}
+	rep.SetIndex(finalDigest.DigestStr())
+
+	opts = append(opts,
+		build.WithImageConfiguration(*ic),       // We mutate Archs above.
+		build.WithSourceDateEpoch(multiArchBDE), // Maximum child's time.
+	)
+
+	o, ic, err = build.NewOptions(opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := build.WriteIndex(ctx, o, idx); err != nil {
+		return nil, nil, fmt.Errorf("failed to write OCI index: %w", err)
+	}
+
+	// the sboms are saved to the same working directory as the image components
+	if len(o.SBOMGenerators) != 0 {
+		stop := rep.Time(report.PhaseSBOM, "")
+		files, err := build.GenerateIndexSBOM(ctx, *o, *ic, finalDigest, imgs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generating index SBOM: %w", err)
+		}
+		stop()
+		sboms = append(sboms, files...)
+	}
+
+	return idx, sboms, nil
+}
+
+// reportImage records the image built by bc, and the packages installed in
+// it, in the build report.
+func reportImage(rep *report.Collector, bc *build.Context, img v1.Image) error {
+	arch := bc.Arch().ToAPK()
+	if err := rep.AddImage(arch, img); err != nil {
+		return err
+	}
+	installed, err := bc.InstalledPackages()
+	if err != nil {
+		return fmt.Errorf("getting installed packages: %w", err)
+	}
+	pkgs := make([]report.Package, 0, len(installed))
+	for _, pkg := range installed {
+		pkgs = append(pkgs, report.Package{
+			Name:          pkg.Name,
+			Version:       pkg.Version,
+			Origin:        pkg.Origin,
+			License:       pkg.License,
+			Size:          pkg.Size,
+			InstalledSize: pkg.InstalledSize,
+		})
+	}
+	rep.AddPackages(arch, pkgs)
+	rep.AddSkippedScriptlets(arch, bc.SkippedScriptlets())
+	rep.AddStrippedSetuid(arch, bc.StrippedSetuid())
+	rep.AddExcludedPaths(arch, bc.ExcludedPaths())
+	return nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apko_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apko"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestBuilder(t *testing.T) {
+	ctx := context.Background()
+
+	b, err := apko.New(ctx,
+		apko.WithImageConfiguration(types.ImageConfiguration{
+			Contents: types.ImageContents{
+				Repositories: []string{"../build/testdata/packages"},
+				Keyring:      []string{"../build/testdata/melange.rsa.pub"},
+				Packages:     []string{"replayout"},
+			},
+		}),
+		apko.WithArchs(types.ParseArchitecture("amd64"), types.ParseArchitecture("arm64")),
+		apko.WithCache(t.TempDir(), false),
+	)
+	require.NoError(t, err)
+
+	res, err := b.Build(ctx)
+	require.NoError(t, err)
+	defer res.Close()
+
+	m, err := res.Index.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, m.Manifests, 2)
+
+	out := filepath.Join(t.TempDir(), "image.tar")
+	_, err = res.WriteTarball(out, "example.com/test:latest")
+	require.NoError(t, err)
+	_, err = os.Stat(out)
+	require.NoError(t, err)
+
+	require.NoError(t, res.WriteLayout(filepath.Join(t.TempDir(), "layout")))
+}
+
+func TestBuilderConfigReader(t *testing.T) {
+	ctx := context.Background()
+
+	config := `contents:
+  repositories:
+    - ../build/testdata/packages
+  keyring:
+    - ../build/testdata/melange.rsa.pub
+  packages:
+    - replayout
+archs:
+  - amd64
+  - arm64
+`
+
+	// The reader is consumed once but the configuration is needed for every arch.
+	b, err := apko.New(ctx,
+		apko.WithConfigReader(strings.NewReader(config)),
+		apko.WithCache(t.TempDir(), false),
+	)
+	require.NoError(t, err)
+
+	res, err := b.Build(ctx)
+	require.NoError(t, err)
+	defer res.Close()
+
+	m, err := res.Index.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, m.Manifests, 2)
+}
+
+// tokenKeychain hands out a new password each time it is resolved.
+type tokenKeychain struct {
+	mu       sync.Mutex
+	resolved int
+}
+
+func (k *tokenKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.resolved++
+	return &authn.Basic{Username: "apko", Password: fmt.Sprintf("token-%d", k.resolved)}, nil
+}
+
+func TestResultPublish(t *testing.T) {
+	ctx := context.Background()
+
+	r := registry.New()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, password, ok := req.BasicAuth(); !ok || user != "apko" || !strings.HasPrefix(password, "token-") {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.Contains(t, req.UserAgent(), "embedder/1.0")
+		r.ServeHTTP(w, req)
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	dst := u.Host + "/test/publish:latest"
+
+	kc := &tokenKeychain{}
+	b, err := apko.New(ctx,
+		apko.WithImageConfiguration(types.ImageConfiguration{
+			Contents: types.ImageContents{
+				Repositories: []string{"../build/testdata/packages"},
+				Keyring:      []string{"../build/testdata/melange.rsa.pub"},
+				Packages:     []string{"replayout"},
+			},
+		}),
+		apko.WithArchs(types.ParseArchitecture("amd64")),
+		apko.WithCache(t.TempDir(), false),
+		apko.WithKeychain(kc),
+		apko.WithRemoteOptions(remote.WithUserAgent("embedder/1.0")),
+	)
+	require.NoError(t, err)
+
+	res, err := b.Build(ctx)
+	require.NoError(t, err)
+	defer res.Close()
+
+	dig, err := res.Publish(ctx, []string{dst})
+	require.NoError(t, err)
+	want, err := res.Index.Digest()
+	require.NoError(t, err)
+	require.Equal(t, want.String(), dig.DigestStr())
+	require.Positive(t, kc.resolved)
+}
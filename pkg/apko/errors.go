@@ -0,0 +1,45 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apko
+
+import (
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/baseimg"
+	"chainguard.dev/apko/pkg/build/oci"
+)
+
+// Errors returned by builds can be matched with errors.Is against these
+// sentinels, and with errors.As against the error types below for details.
+var (
+	ErrPackageNotFound         = apk.ErrPackageNotFound
+	ErrUnsatisfiableConstraint = apk.ErrUnsatisfiableConstraint
+	ErrSignatureVerification   = apk.ErrSignatureVerification
+	ErrAuthRequired            = apk.ErrAuthRequired
+	ErrRateLimited             = oci.ErrRateLimited
+)
+
+type (
+	// PackageNotFoundError names a package that no repository provides.
+	PackageNotFoundError = apk.PackageNotFoundError
+	// ConstraintError names a constraint that could not be solved.
+	ConstraintError = apk.ConstraintError
+	// HTTPStatusError carries the status of a failed repository request.
+	HTTPStatusError = apk.HTTPStatusError
+	// PushError names the reference that failed to be written to a registry.
+	PushError = oci.PushError
+	// PlatformNotFoundError lists the platforms of a base image that has
+	// none matching the architecture being built.
+	PlatformNotFoundError = baseimg.PlatformNotFoundError
+)
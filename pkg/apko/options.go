@@ -0,0 +1,153 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apko
+
+import (
+	"io"
+	"io/fs"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/sbom/generator"
+)
+
+// Option configures a Builder.
+type Option func(*Builder) error
+
+// WithConfigFile loads the image configuration from a YAML file, resolving
+// includes and relative paths against includePaths.
+func WithConfigFile(path string, includePaths ...string) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, build.WithConfig(path, includePaths), build.WithIncludePaths(includePaths))
+		return nil
+	}
+}
+
+// WithConfigReader parses the image configuration from r, e.g. a generated
+// configuration held in memory. Includes are resolved against includePaths.
+func WithConfigReader(r io.Reader, includePaths ...string) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, build.WithConfigReader(r, includePaths), build.WithIncludePaths(includePaths))
+		return nil
+	}
+}
+
+// WithConfigFS loads the image configuration and its includes from fsys.
+// Repositories and keyring paths in the configuration are still read from
+// the local filesystem.
+func WithConfigFS(fsys fs.FS, path string) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, build.WithConfigFS(fsys, path))
+		return nil
+	}
+}
+
+// WithImageConfiguration uses an already parsed image configuration.
+func WithImageConfiguration(ic types.ImageConfiguration) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, build.WithImageConfiguration(ic))
+		return nil
+	}
+}
+
+// WithKeychain sets the keychain used to authenticate to registries, e.g. to
+// pull a remote base image, and by Result.Publish. It is resolved anew for
+// every pull and push, so it can hand out short-lived credentials.
+func WithKeychain(kc authn.Keychain) Option {
+	return func(b *Builder) error {
+		b.keychain = kc
+		b.opts = append(b.opts, build.WithKeychain(kc))
+		return nil
+	}
+}
+
+// WithRemoteOptions adds options to the registry requests of the build and of
+// Result.Publish, such as a transport or user agent. They are applied after
+// apko's own, so remote.WithAuth or remote.WithAuthFromKeychain override the
+// keychain.
+func WithRemoteOptions(opts ...remote.Option) Option {
+	return func(b *Builder) error {
+		b.remoteOpts = append(b.remoteOpts, opts...)
+		b.opts = append(b.opts, build.WithRemoteOptions(opts...))
+		return nil
+	}
+}
+
+// WithArchs restricts the build to the given architectures. By default the
+// architectures from the configuration are built, or all of them if it has none.
+func WithArchs(archs ...types.Architecture) Option {
+	return func(b *Builder) error {
+		b.archs = archs
+		return nil
+	}
+}
+
+// WithCache sets where packages and indexes are cached, and whether the
+// network may be used at all.
+func WithCache(dir string, offline bool) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, build.WithCache(dir, offline, apk.NewCache(true)))
+		return nil
+	}
+}
+
+// WithRemoteCache shares downloaded packages and indexes through rc, such as
+// one returned by remotecache.New, in addition to the local cache.
+func WithRemoteCache(rc apk.RemoteCache) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, build.WithRemoteCache(rc))
+		return nil
+	}
+}
+
+// WithLayerCache caches built layers in dir between builds.
+func WithLayerCache(dir string) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, build.WithLayerCache(dir))
+		return nil
+	}
+}
+
+// WithSBOMFormats generates SBOMs in the given formats (e.g. "spdx").
+// The generated files are listed in Result.SBOMs.
+func WithSBOMFormats(formats ...string) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, build.WithSBOMGenerators(generator.Generators(formats...)...))
+		return nil
+	}
+}
+
+// WithWorkDir sets the directory where image filesystems and layer blobs are
+// written. The caller owns it; by default a temporary directory is created,
+// which Result.Close removes.
+func WithWorkDir(dir string) Option {
+	return func(b *Builder) error {
+		b.workDir = dir
+		return nil
+	}
+}
+
+// WithBuildOptions passes lower-level options through to pkg/build.
+// These are not covered by Builder's compatibility guarantees.
+func WithBuildOptions(opts ...build.Option) Option {
+	return func(b *Builder) error {
+		b.opts = append(b.opts, opts...)
+		return nil
+	}
+}
@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package apko exposes high level functions like apko's module version information.
+// Package apko exposes high level functions like building images and apko's module version information.
 package apko
 
 import (
@@ -18,9 +18,15 @@ import (
 	"archive/tar"
 	"bytes"
 	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
@@ -36,8 +42,20 @@ type BaseImage struct {
 	installedPackages         []*apk.InstalledPackage
 	materizalizedApkIndexPath string
 	arch                      types.Architecture
+
+	// ref is the base image as configured, and sbom locates its SBOM.
+	ref    string
+	remote bool
+	sbom   func(ctx context.Context) ([]byte, error)
 }
 
+// Annotations recording the base image of an image, see
+// https://github.com/opencontainers/image-spec/blob/main/annotations.md
+const (
+	AnnotationBaseName   = "org.opencontainers.image.base.name"
+	AnnotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
 // See https://github.com/opencontainers/image-spec/blob/main/image-index.md#image-index-property-descriptions
 // Briefly: index.json can either list manifest of per arch images, or redirect to actual image index (nested case)
 func getUnnestedImageIndex(imgPath string) (v1.ImageIndex, error) {
@@ -67,23 +85,43 @@ func getImageForArch(imgPath string, arch types.Architecture) (v1.Image, error)
 		return nil, err
 	}
 
+	imgs := make([]v1.Image, 0, len(indexManifest.Manifests))
+	platforms := make([]v1.Platform, 0, len(indexManifest.Manifests))
 	for _, m := range indexManifest.Manifests {
-		img, err := index.Image(m.Digest)
-		if err != nil {
-			return nil, err
+		if !m.MediaType.IsImage() {
+			continue
 		}
-		config, err := img.ConfigFile()
+		img, err := index.Image(m.Digest)
 		if err != nil {
 			return nil, err
 		}
-		if config == nil {
-			return nil, fmt.Errorf("got image without config")
-		}
-		if config.Architecture == arch.ToOCIPlatform().Architecture {
-			return img, nil
+		// Prefer the platform of the index entry, falling back to the config
+		// of the image for layouts that don't record it.
+		platform := m.Platform
+		if platform == nil {
+			config, err := img.ConfigFile()
+			if err != nil {
+				return nil, err
+			}
+			if config == nil {
+				return nil, fmt.Errorf("got image without config")
+			}
+			platform = config.Platform()
 		}
+		imgs = append(imgs, img)
+		platforms = append(platforms, *platform)
 	}
-	return nil, fmt.Errorf("image for arch not found")
+
+	i, err := selectPlatform(imgPath, *arch.ToOCIPlatform(), platforms)
+	if err != nil {
+		return nil, err
+	}
+	return imgs[i], nil
+}
+
+// LocalImage returns the image for arch of the local OCI layout at imgPath.
+func LocalImage(imgPath string, arch types.Architecture) (v1.Image, error) {
+	return getImageForArch(imgPath, arch)
 }
 
 // New creates an instance of BaseImage base on provided parameters:
@@ -98,7 +136,59 @@ func New(imgPath string, apkIndexPath string, arch types.Architecture, materizal
 	if err != nil {
 		return nil, err
 	}
-	contents, err := os.ReadFile(path.Join(apkIndexPath, arch.ToAPK(), "APKINDEX"))
+	baseImg, err := NewFromImage(img, apkIndexPath, arch, materizalizedApkIndexPath)
+	if err != nil {
+		return nil, err
+	}
+	baseImg.ref = imgPath
+	baseImg.sbom = func(context.Context) ([]byte, error) {
+		return localSBOM(imgPath, arch)
+	}
+	return baseImg, nil
+}
+
+// NewMirror creates an instance of BaseImage for the registry reference ref
+// from imgPath, a local OCI layout mirroring it, such as that of an airgap
+// bundle. Images built on it are annotated as based on ref, like when it is
+// pulled. See NewFromImage for apkIndexPath.
+func NewMirror(ref string, imgPath string, apkIndexPath string, arch types.Architecture, materizalizedApkIndexPath string) (*BaseImage, error) {
+	img, err := getImageForArch(imgPath, arch)
+	if err != nil {
+		return nil, err
+	}
+	baseImg, err := NewFromImage(img, apkIndexPath, arch, materizalizedApkIndexPath)
+	if err != nil {
+		return nil, err
+	}
+	baseImg.ref = ref
+	baseImg.remote = true
+	baseImg.sbom = func(context.Context) ([]byte, error) {
+		return localSBOM(imgPath, arch)
+	}
+	return baseImg, nil
+}
+
+// localSBOM reads the SBOM stored next to a local OCI layout, where
+// "apko build --sbom-path <layout>/sboms" puts it.
+func localSBOM(imgPath string, arch types.Architecture) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(imgPath, "sboms", fmt.Sprintf("sbom-%s.spdx.json", arch.ToAPK())))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// NewFromImage creates an instance of BaseImage from an already fetched image, e.g. one
+// returned by Fetch. When apkIndexPath is empty, the installed packages are read from the
+// apk database inside the image instead.
+func NewFromImage(img v1.Image, apkIndexPath string, arch types.Architecture, materizalizedApkIndexPath string) (*BaseImage, error) {
+	var contents []byte
+	var err error
+	if apkIndexPath != "" {
+		contents, err = os.ReadFile(path.Join(apkIndexPath, arch.ToAPK(), "APKINDEX"))
+	} else {
+		contents, err = installedFromImage(img)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -121,10 +211,102 @@ func New(imgPath string, apkIndexPath string, arch types.Architecture, materizal
 	return &baseImg, nil
 }
 
+// Installed returns the packages in the apk database of img.
+func Installed(img v1.Image) ([]*apk.InstalledPackage, error) {
+	data, err := installedFromImage(img)
+	if err != nil {
+		return nil, err
+	}
+	return apk.ParseInstalled(bytes.NewReader(data))
+}
+
+// installedFromImage returns the apk database of the image, looking at the
+// topmost layer that contains one.
+func installedFromImage(img v1.Image) ([]byte, error) {
+	data, err := optionalInstalled(img)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no apk database found in base image, set apkindex to describe its packages")
+	}
+	return data, nil
+}
+
+// optionalInstalled is like installedFromImage, but returns nil if the image
+// has no apk database.
+func optionalInstalled(img v1.Image) ([]byte, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		data, err := installedFromLayer(layers[i])
+		if err != nil || data != nil {
+			return data, err
+		}
+	}
+	return nil, nil
+}
+
+func installedFromLayer(layer v1.Layer) ([]byte, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch strings.TrimPrefix(path.Clean("/"+hdr.Name), "/") {
+		case "lib/apk/db/installed", "usr/lib/apk/db/installed":
+			return io.ReadAll(tr)
+		}
+	}
+}
+
 func (baseImg *BaseImage) Image() v1.Image {
 	return baseImg.img
 }
 
+// Reference returns the base image as configured: a local path or a
+// registry reference.
+func (baseImg *BaseImage) Reference() string {
+	return baseImg.ref
+}
+
+// Annotations returns the OCI base image annotations for images built on
+// this base. Local layouts have no reference to record, so they get none.
+func (baseImg *BaseImage) Annotations() (map[string]string, error) {
+	if !baseImg.remote {
+		return nil, nil
+	}
+	h, err := baseImg.img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		AnnotationBaseName:   baseImg.ref,
+		AnnotationBaseDigest: h.String(),
+	}, nil
+}
+
+// SBOM returns the SPDX SBOM describing the base image, or nil if none could
+// be found.
+func (baseImg *BaseImage) SBOM(ctx context.Context) ([]byte, error) {
+	if baseImg.sbom == nil {
+		return nil, nil
+	}
+	return baseImg.sbom(ctx)
+}
+
 func (baseImg *BaseImage) InstalledPackages() []*apk.InstalledPackage {
 	return baseImg.installedPackages
 }
@@ -0,0 +1,89 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package baseimg
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// PlatformNotFoundError is returned when a base image has no image for the
+// platform being built.
+type PlatformNotFoundError struct {
+	Ref       string
+	Platform  v1.Platform
+	Available []v1.Platform
+}
+
+func (e *PlatformNotFoundError) Error() string {
+	available := make([]string, 0, len(e.Available))
+	for _, p := range e.Available {
+		available = append(available, platformString(p))
+	}
+	if len(available) == 0 {
+		available = append(available, "none")
+	}
+	return fmt.Sprintf("base image %s has no image for platform %s (available platforms: %s)",
+		e.Ref, platformString(e.Platform), strings.Join(available, ", "))
+}
+
+// matchPlatform reports whether an image for got can be used when building
+// for want. The OS, architecture and variant must all match, so that e.g. an
+// arm/v6 image is never used for arm/v7.
+func matchPlatform(want, got v1.Platform) bool {
+	return normalizeOS(want.OS) == normalizeOS(got.OS) &&
+		want.Architecture == got.Architecture &&
+		normalizeVariant(want) == normalizeVariant(got)
+}
+
+// selectPlatform returns the index of the entry of platforms matching want.
+func selectPlatform(ref string, want v1.Platform, platforms []v1.Platform) (int, error) {
+	for i, p := range platforms {
+		if matchPlatform(want, p) {
+			return i, nil
+		}
+	}
+	return -1, &PlatformNotFoundError{Ref: ref, Platform: want, Available: platforms}
+}
+
+func normalizeOS(os string) string {
+	if os == "" {
+		return "linux"
+	}
+	return os
+}
+
+// normalizeVariant treats arm64 and arm64/v8 as the same platform, as the
+// variant is frequently omitted for it, and arm as arm/v7, which it means by
+// convention.
+func normalizeVariant(p v1.Platform) string {
+	switch {
+	case p.Architecture == "arm64" && p.Variant == "v8":
+		return ""
+	case p.Architecture == "arm" && p.Variant == "":
+		return "v7"
+	}
+	return p.Variant
+}
+
+func platformString(p v1.Platform) string {
+	s := normalizeOS(p.OS) + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
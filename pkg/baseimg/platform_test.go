@@ -0,0 +1,65 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package baseimg
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestSelectPlatform(t *testing.T) {
+	platforms := []v1.Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm", Variant: "v6"},
+		{OS: "linux", Architecture: "arm", Variant: "v7"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	}
+
+	for _, tt := range []struct {
+		arch string
+		want int
+	}{
+		{arch: "amd64", want: 0},
+		{arch: "armhf", want: 1},
+		{arch: "armv7", want: 2},
+		{arch: "arm64", want: 3},
+	} {
+		t.Run(tt.arch, func(t *testing.T) {
+			got, err := selectPlatform("base", *types.ParseArchitecture(tt.arch).ToOCIPlatform(), platforms)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("arm without variant", func(t *testing.T) {
+		unvaried := []v1.Platform{platforms[1], {OS: "linux", Architecture: "arm"}}
+		got, err := selectPlatform("base", *types.ParseArchitecture("armv7").ToOCIPlatform(), unvaried)
+		require.NoError(t, err)
+		require.Equal(t, 1, got)
+		_, err = selectPlatform("base", *types.ParseArchitecture("armv6").ToOCIPlatform(), unvaried[1:])
+		require.Error(t, err)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		_, err := selectPlatform("base", *types.ParseArchitecture("armv7").ToOCIPlatform(), platforms[:2])
+		var pnf *PlatformNotFoundError
+		require.ErrorAs(t, err, &pnf)
+		require.EqualError(t, err, "base image base has no image for platform linux/arm/v7 (available platforms: linux/amd64, linux/arm/v6)")
+	})
+}
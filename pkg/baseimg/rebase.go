@@ -0,0 +1,279 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package baseimg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+)
+
+// Rebase swaps the oldBase layers at the bottom of orig for newBase, keeping
+// the layers, config and annotations that orig added on top.
+//
+// orig's apk database lists the packages of the old base as well as its own,
+// so it is rewritten to list the new base's packages instead. newBaseRef is
+// recorded in the base image annotations of the result.
+func Rebase(ctx context.Context, orig, oldBase, newBase v1.Image, newBaseRef string) (v1.Image, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "baseimg.Rebase")
+	defer span.End()
+
+	origLayers, err := orig.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("getting image layers: %w", err)
+	}
+	oldBaseLayers, err := oldBase.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("getting old base layers: %w", err)
+	}
+	if len(oldBaseLayers) >= len(origLayers) {
+		return nil, fmt.Errorf("image is not based on the old base image (too few layers)")
+	}
+	for i, l := range oldBaseLayers {
+		want, err := l.Digest()
+		if err != nil {
+			return nil, err
+		}
+		got, err := origLayers[i].Digest()
+		if err != nil {
+			return nil, err
+		}
+		if want != got {
+			return nil, fmt.Errorf("image is not based on the old base image (layer %d is %s, want %s)", i, got, want)
+		}
+	}
+	top := origLayers[len(oldBaseLayers):]
+
+	origCfg, err := orig.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("getting image config: %w", err)
+	}
+	oldCfg, err := oldBase.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("getting old base config: %w", err)
+	}
+	var topHistory []v1.History
+	if len(origCfg.History) > len(oldCfg.History) {
+		topHistory = origCfg.History[len(oldCfg.History):]
+	}
+
+	top, err = rebaseInstalled(ctx, top, oldBase, newBase)
+	if err != nil {
+		return nil, fmt.Errorf("rewriting apk database: %w", err)
+	}
+
+	img := mutate.MediaType(newBase, ggcrtypes.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, ggcrtypes.OCIConfigJSON)
+
+	adds := make([]mutate.Addendum, 0, len(top))
+	for i, l := range top {
+		add := mutate.Addendum{Layer: l}
+		if i < len(topHistory) {
+			add.History = topHistory[i]
+		}
+		adds = append(adds, add)
+	}
+	img, err = mutate.Append(img, adds...)
+	if err != nil {
+		return nil, fmt.Errorf("appending layers to new base: %w", err)
+	}
+
+	// Keep the original config, but with the layers and history of the rebased image.
+	appended, err := img.ConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg := origCfg.DeepCopy()
+	cfg.RootFS = appended.RootFS
+	cfg.History = appended.History
+	img, err = mutate.ConfigFile(img, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("setting config: %w", err)
+	}
+
+	m, err := orig.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	h, err := newBase.Digest()
+	if err != nil {
+		return nil, err
+	}
+	annotations := maps.Clone(m.Annotations)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AnnotationBaseName] = newBaseRef
+	annotations[AnnotationBaseDigest] = h.String()
+
+	return mutate.Annotations(img, annotations).(v1.Image), nil
+}
+
+// rebaseInstalled rewrites the apk database in the topmost of layers that has
+// one, replacing the entries of oldBase's packages with newBase's.
+func rebaseInstalled(ctx context.Context, layers []v1.Layer, oldBase, newBase v1.Image) ([]v1.Layer, error) {
+	log := clog.FromContext(ctx)
+
+	idx := -1
+	var db []byte
+	for i := len(layers) - 1; i >= 0; i-- {
+		data, err := installedFromLayer(layers[i])
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			idx, db = i, data
+			break
+		}
+	}
+	if idx == -1 {
+		log.Warnf("no apk database found above the base image, leaving it as is")
+		return layers, nil
+	}
+
+	oldDB, err := optionalInstalled(oldBase)
+	if err != nil {
+		return nil, fmt.Errorf("reading old base apk database: %w", err)
+	}
+	newDB, err := optionalInstalled(newBase)
+	if err != nil {
+		return nil, fmt.Errorf("reading new base apk database: %w", err)
+	}
+
+	oldNames := map[string]bool{}
+	for _, e := range installedEntries(oldDB) {
+		oldNames[installedEntryName(e)] = true
+	}
+
+	var own []string
+	ownNames := map[string]bool{}
+	for _, e := range installedEntries(db) {
+		name := installedEntryName(e)
+		if oldNames[name] {
+			continue
+		}
+		own = append(own, e)
+		ownNames[name] = true
+	}
+
+	var entries []string
+	for _, e := range installedEntries(newDB) {
+		name := installedEntryName(e)
+		if ownNames[name] {
+			// The image's own layers overwrite the base's files for this package.
+			log.Warnf("package %s is installed by both the new base image and the image, keeping the image's", name)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	entries = append(entries, own...)
+
+	var b strings.Builder
+	for _, e := range entries {
+		b.WriteString(e)
+		b.WriteString("\n\n")
+	}
+
+	rewritten, err := replaceInstalled(layers[idx], []byte(b.String()))
+	if err != nil {
+		return nil, err
+	}
+
+	out := append([]v1.Layer{}, layers...)
+	out[idx] = rewritten
+	return out, nil
+}
+
+// installedEntries splits an apk database into its per-package entries.
+func installedEntries(db []byte) []string {
+	var entries []string
+	for e := range strings.SplitSeq(string(db), "\n\n") {
+		if e = strings.Trim(e, "\n"); e != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func installedEntryName(entry string) string {
+	for line := range strings.SplitSeq(entry, "\n") {
+		if name, ok := strings.CutPrefix(line, "P:"); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// replaceInstalled returns a copy of layer with the apk database replaced by db.
+func replaceInstalled(layer v1.Layer, db []byte) (v1.Layer, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	tr := tar.NewReader(rc)
+	tw := tar.NewWriter(&buf)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var body io.Reader = tr
+		switch strings.TrimPrefix(path.Clean("/"+hdr.Name), "/") {
+		case "lib/apk/db/installed", "usr/lib/apk/db/installed":
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size = int64(len(db))
+				body = bytes.NewReader(db)
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(tw, body); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	mt, err := layer.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, tarball.WithMediaType(mt))
+}
@@ -0,0 +1,290 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package baseimg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// SPDX media and predicate types used to find the SBOM of a remote base image.
+const (
+	spdxArtifactType     = "application/spdx+json"
+	spdxCosignMediaType  = "text/spdx+json"
+	spdxPredicateType    = "https://spdx.dev/Document"
+	inTotoPayloadType    = "application/vnd.in-toto+json"
+	cosignSBOMTagSuffix  = ".sbom"
+	cosignAttestationTag = ".att"
+)
+
+// Fetch returns the image for arch from the registry reference ref. If ref
+// points to an index, the image matching arch's platform is selected.
+//
+// Tags are resolved to a digest once, which is logged so it can be pinned in
+// the configuration (e.g. "cgr.dev/chainguard/wolfi-base@sha256:...").
+// When cacheDir is set, layers are cached there and reused across builds.
+func Fetch(ctx context.Context, ref string, arch types.Architecture, cacheDir string, opts ...remote.Option) (v1.Image, error) {
+	img, _, err := fetch(ctx, ref, *arch.ToOCIPlatform(), cacheDir, opts...)
+	return img, err
+}
+
+// FetchPlatform is like Fetch, but selects the image matching platform.
+func FetchPlatform(ctx context.Context, ref string, platform v1.Platform, cacheDir string, opts ...remote.Option) (v1.Image, error) {
+	img, _, err := fetch(ctx, ref, platform, cacheDir, opts...)
+	return img, err
+}
+
+// NewRemote creates an instance of BaseImage from the registry reference ref,
+// as fetched by Fetch. See NewFromImage for apkIndexPath. The SBOM of the base
+// image is looked up in the registry next to it.
+func NewRemote(ctx context.Context, ref string, apkIndexPath string, arch types.Architecture, cacheDir string, materizalizedApkIndexPath string, opts ...remote.Option) (*BaseImage, error) {
+	img, top, err := fetch(ctx, ref, *arch.ToOCIPlatform(), cacheDir, opts...)
+	if err != nil {
+		return nil, err
+	}
+	baseImg, err := NewFromImage(img, apkIndexPath, arch, materizalizedApkIndexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := img.Digest()
+	if err != nil {
+		return nil, err
+	}
+	baseImg.ref = ref
+	baseImg.remote = true
+	baseImg.sbom = func(ctx context.Context) ([]byte, error) {
+		// SBOMs may be attached to the per-arch image, or to the index.
+		candidates := []name.Digest{top.Context().Digest(h.String())}
+		if top.DigestStr() != h.String() {
+			candidates = append(candidates, top)
+		}
+		return RemoteSBOM(ctx, candidates, opts...)
+	}
+	return baseImg, nil
+}
+
+func fetch(ctx context.Context, ref string, platform v1.Platform, cacheDir string, opts ...remote.Option) (v1.Image, name.Digest, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "baseimg.Fetch")
+	defer span.End()
+	log := clog.FromContext(ctx)
+
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, name.Digest{}, fmt.Errorf("parsing base image reference %q: %w", ref, err)
+	}
+
+	opts = append(opts, remote.WithContext(ctx))
+	desc, err := remote.Get(r, opts...)
+	if err != nil {
+		return nil, name.Digest{}, fmt.Errorf("fetching base image %s: %w", ref, err)
+	}
+	top := r.Context().Digest(desc.Digest.String())
+	if _, ok := r.(name.Tag); ok {
+		log.Infof("resolved base image %s to %s", ref, top)
+	}
+
+	img, err := imageForPlatform(ref, desc, platform)
+	if err != nil {
+		return nil, name.Digest{}, fmt.Errorf("fetching base image %s for %s: %w", ref, platformString(platform), err)
+	}
+
+	if cacheDir != "" {
+		img = cache.Image(img, cache.NewFilesystemCache(cacheDir))
+	}
+
+	return img, top, nil
+}
+
+// imageForPlatform returns the image of desc for platform, selecting it from
+// the index if desc is one. Unlike remote.WithPlatform, it fails rather than
+// falling back to an image of another platform.
+func imageForPlatform(ref string, desc *remote.Descriptor, platform v1.Platform) (v1.Image, error) {
+	if !desc.MediaType.IsIndex() {
+		img, err := desc.Image()
+		if err != nil {
+			return nil, err
+		}
+		config, err := img.ConfigFile()
+		if err != nil {
+			return nil, err
+		}
+		if got := config.Platform(); got != nil {
+			if _, err := selectPlatform(ref, platform, []v1.Platform{*got}); err != nil {
+				return nil, err
+			}
+		}
+		return img, nil
+	}
+
+	idx, err := desc.ImageIndex()
+	if err != nil {
+		return nil, err
+	}
+	im, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	var digests []v1.Hash
+	var platforms []v1.Platform
+	for _, m := range im.Manifests {
+		if !m.MediaType.IsImage() || m.Platform == nil {
+			continue
+		}
+		digests = append(digests, m.Digest)
+		platforms = append(platforms, *m.Platform)
+	}
+	i, err := selectPlatform(ref, platform, platforms)
+	if err != nil {
+		return nil, err
+	}
+	return idx.Image(digests[i])
+}
+
+// RemoteSBOM returns the SPDX SBOM of the first of digests that has one, as
+// found by remoteSBOM, or nil if none of them do.
+func RemoteSBOM(ctx context.Context, digests []name.Digest, opts ...remote.Option) ([]byte, error) {
+	for _, dig := range digests {
+		data, err := remoteSBOM(ctx, dig, opts...)
+		if err != nil || data != nil {
+			return data, err
+		}
+	}
+	return nil, nil
+}
+
+// remoteSBOM looks for an SPDX SBOM of dig: first as an OCI referrer, then as
+// a cosign attached SBOM ("sha256-<hex>.sbom") and finally as a cosign SPDX
+// attestation ("sha256-<hex>.att"). It returns nil if there is none.
+func remoteSBOM(ctx context.Context, dig name.Digest, opts ...remote.Option) ([]byte, error) {
+	log := clog.FromContext(ctx)
+	opts = append(opts, remote.WithContext(ctx))
+
+	if idx, err := remote.Referrers(dig, opts...); err != nil {
+		log.Debugf("listing referrers of %s: %v", dig, err)
+	} else if m, err := idx.IndexManifest(); err == nil {
+		for _, desc := range m.Manifests {
+			if desc.ArtifactType != spdxArtifactType && desc.ArtifactType != spdxCosignMediaType {
+				continue
+			}
+			img, err := remote.Image(dig.Context().Digest(desc.Digest.String()), opts...)
+			if err != nil {
+				return nil, fmt.Errorf("fetching SBOM referrer %s: %w", desc.Digest, err)
+			}
+			return firstLayer(img)
+		}
+	}
+
+	tag := strings.Replace(dig.DigestStr(), ":", "-", 1)
+
+	img, err := remote.Image(dig.Context().Tag(tag+cosignSBOMTagSuffix), opts...)
+	switch {
+	case err == nil:
+		return firstLayer(img)
+	case !isNotFound(err):
+		return nil, fmt.Errorf("fetching attached SBOM of %s: %w", dig, err)
+	}
+
+	img, err = remote.Image(dig.Context().Tag(tag+cosignAttestationTag), opts...)
+	switch {
+	case err == nil:
+		return spdxAttestation(img)
+	case !isNotFound(err):
+		return nil, fmt.Errorf("fetching attestations of %s: %w", dig, err)
+	}
+
+	return nil, nil
+}
+
+func firstLayer(img v1.Image) ([]byte, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("SBOM image has no layers")
+	}
+	rc, err := layers[0].Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// spdxAttestation returns the predicate of the first SPDX in-toto
+// attestation in a cosign attestation image, or nil if there is none.
+func spdxAttestation(img v1.Image) ([]byte, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range layers {
+		rc, err := l.Uncompressed()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var envelope struct {
+			PayloadType string `json:"payloadType"`
+			Payload     string `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &envelope); err != nil || envelope.PayloadType != inTotoPayloadType {
+			continue
+		}
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			continue
+		}
+		var statement struct {
+			PredicateType string          `json:"predicateType"`
+			Predicate     json.RawMessage `json:"predicate"`
+		}
+		if err := json.NewDecoder(bytes.NewReader(payload)).Decode(&statement); err != nil {
+			continue
+		}
+		if statement.PredicateType == spdxPredicateType {
+			return statement.Predicate, nil
+		}
+	}
+	return nil, nil
+}
+
+func isNotFound(err error) bool {
+	var terr *transport.Error
+	return errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound
+}
@@ -60,6 +60,30 @@ func userToUserEntry(user types.User) passwd.UserEntry {
 	}
 }
 
+// mutateShadow writes the /etc/shadow entries of the users whose password
+// hash or lock is set, replacing any existing entry of the same name.
+func mutateShadow(fsys apkfs.FullFS, users []types.User) error {
+	var entries []passwd.ShadowEntry
+	for _, u := range users {
+		if password, ok := u.ShadowPassword(); ok {
+			entries = append(entries, passwd.ShadowEntry{UserName: u.UserName, Password: password})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	path := filepath.Join("etc", "shadow")
+	sf, err := passwd.ReadOrCreateShadowFile(fsys, path)
+	if err != nil {
+		return err
+	}
+	for _, se := range entries {
+		sf.Set(se)
+	}
+	return sf.WriteFile(fsys, path)
+}
+
 func mutateAccounts(fsys apkfs.FullFS, ic *types.ImageConfiguration) error {
 	var eg errgroup.Group
 
@@ -120,12 +144,13 @@ func mutateAccounts(fsys apkfs.FullFS, ic *types.ImageConfiguration) error {
 			} else if !os.IsNotExist(err) {
 				return fmt.Errorf("checking homedir exists: %w", err)
 			}
-			// Create the directory. Only the directory should be 0o700; parents, if they are missing, should be 0o755.
+			// Create the directory. Only the directory should be 0o700; parents, if they are missing, should be 0o755,
+			// unless the modes of the image say otherwise.
 			parent := filepath.Dir(targetHomedir)
-			if err := fsys.MkdirAll(parent, 0o755); err != nil {
+			if err := fsys.MkdirAll(parent, ic.Modes.Directory()); err != nil {
 				return fmt.Errorf("creating parent %s: %w", parent, err)
 			}
-			if err := fsys.Mkdir(targetHomedir, 0o700); err != nil {
+			if err := fsys.Mkdir(targetHomedir, ic.Modes.HomeDir()); err != nil {
 				return fmt.Errorf("creating homedir: %w", err)
 			}
 			if err := fsys.Chown(targetHomedir, int(ue.UID), int(ue.GID)); err != nil {
@@ -137,6 +162,10 @@ func mutateAccounts(fsys apkfs.FullFS, ic *types.ImageConfiguration) error {
 			return err
 		}
 
+		if err := mutateShadow(fsys, ic.Accounts.Users); err != nil {
+			return err
+		}
+
 		// Resolve run-as user if requested.
 		if ic.Accounts.RunAs != "" {
 			for _, ue := range uf.Entries {
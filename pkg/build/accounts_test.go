@@ -15,8 +15,12 @@
 package build
 
 import (
+	"io/fs"
 	"testing"
 
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
 	"chainguard.dev/apko/pkg/build/types"
 )
 
@@ -78,3 +82,43 @@ func Test_userToUserEntry_UID_GID_mapping(t *testing.T) {
 		}
 	}
 }
+
+func TestMutateShadow(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("etc", 0o755))
+	require.NoError(t, fsys.WriteFile("etc/shadow", []byte("root:*::0:::::\nadmin:!::0:::::\n"), 0o640))
+
+	locked, unlocked := true, false
+	require.NoError(t, mutateShadow(fsys, []types.User{
+		{UserName: "admin", UID: id1234, PasswordHash: "$6$salt$hash"},
+		{UserName: "kiosk", UID: id1235, Locked: &unlocked},
+		{UserName: "disabled", UID: 1236, PasswordHash: "$6$salt$old", Locked: &locked},
+		{UserName: "service", UID: 1237},
+	}))
+
+	got, err := fsys.ReadFile("etc/shadow")
+	require.NoError(t, err)
+	require.Equal(t, "root:*::0:::::\nadmin:$6$salt$hash:::::::\nkiosk::::::::\ndisabled:!$6$salt$old:::::::\n", string(got))
+
+	// Without passwords or locks, there is no /etc/shadow to write.
+	fsys = apkfs.NewMemFS()
+	require.NoError(t, mutateShadow(fsys, []types.User{{UserName: "service", UID: 1237}}))
+	_, err = fsys.Stat("etc/shadow")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestWriteEtcApkoConfigRedactsPasswordHashes(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("etc", 0o755))
+	bc := &Context{fs: fsys, ic: types.ImageConfiguration{Accounts: types.ImageAccounts{
+		Users: []types.User{{UserName: "admin", UID: id1234, PasswordHash: "$6$salt$hash"}},
+	}}}
+	require.NoError(t, bc.WriteEtcApkoConfig(t.Context()))
+
+	got, err := fsys.ReadFile("etc/apko.json")
+	require.NoError(t, err)
+	require.Contains(t, string(got), `"username":"admin"`)
+	require.NotContains(t, string(got), "$6$salt$hash")
+	// The configuration of the build keeps the hash for /etc/shadow.
+	require.Equal(t, "$6$salt$hash", bc.ic.Accounts.Users[0].PasswordHash)
+}
@@ -17,6 +17,8 @@ package build
 import (
 	"context"
 	"fmt"
+	"path/filepath"
+	"slices"
 
 	"go.opentelemetry.io/otel"
 	"golang.org/x/sync/errgroup"
@@ -30,10 +32,24 @@ func (bc *Context) postBuildSetApk(ctx context.Context) error {
 	//
 	// We do not include the build-time repositories here, because this is
 	// what defines the /etc/apk/repositories file in the final image.
-	runtimeRepos := sets.List(
-		sets.New(bc.ic.Contents.Repositories...).
-			Insert(bc.ic.Contents.RuntimeOnlyRepositories...).
-			Insert(bc.o.ExtraRepos...))
+	//
+	// The repositories are substituted with those to use at runtime, if the
+	// image configuration does so.
+	runtime := bc.ic.Contents.Runtime
+	repos := sets.New[string]()
+	for _, repo := range slices.Concat(bc.ic.Contents.Repositories, bc.ic.Contents.RuntimeOnlyRepositories, bc.o.ExtraRepos) {
+		repos.Insert(runtime.Substitute(repo))
+	}
+	runtimeRepos := sets.List(repos)
+	if len(runtimeRepos) == 0 {
+		// All the packages came from build repositories, such as a melange
+		// workspace, so the image has no repositories.
+		//nolint:gosec // apk repositories must be publicly readable
+		if err := bc.fs.WriteFile(filepath.Join("etc", "apk", "repositories"), nil, 0o644); err != nil {
+			return fmt.Errorf("failed to clear apk repositories: %w", err)
+		}
+		return nil
+	}
 	if err := bc.apk.SetRepositories(ctx, runtimeRepos); err != nil {
 		return fmt.Errorf("failed to set apk repositories: %w", err)
 	}
@@ -51,11 +67,16 @@ func (bc *Context) initializeApk(ctx context.Context) error {
 	//
 	// We do not include the runtime-only repositories here, because those repos
 	// should not be used at build time.
+	melangeRepos, melangeKeys, err := appendMelangeWorkspace(bc.o.MelangeWorkspace, nil, nil)
+	if err != nil {
+		return err
+	}
 	buildRepos := sets.List(
 		sets.New(bc.ic.Contents.BuildRepositories...).
 			Insert(bc.ic.Contents.Repositories...).
 			Insert(bc.o.ExtraBuildRepos...).
-			Insert(bc.o.ExtraRepos...),
+			Insert(bc.o.ExtraRepos...).
+			Insert(melangeRepos...),
 	)
 	if err := bc.apk.InitDB(ctx, buildRepos...); err != nil {
 		return fmt.Errorf("failed to initialize apk database: %w", err)
@@ -64,7 +85,7 @@ func (bc *Context) initializeApk(ctx context.Context) error {
 	var eg errgroup.Group
 
 	eg.Go(func() error {
-		keyring := sets.List(sets.New(bc.ic.Contents.Keyring...).Insert(bc.o.ExtraKeyFiles...))
+		keyring := sets.List(sets.New(bc.ic.Contents.Keyring...).Insert(bc.o.ExtraKeyFiles...).Insert(melangeKeys...))
 		if err := bc.apk.InitKeyring(ctx, keyring, nil); err != nil {
 			return fmt.Errorf("failed to initialize apk keyring: %w", err)
 		}
@@ -0,0 +1,120 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/baseimg"
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/keychain"
+	"chainguard.dev/apko/pkg/paths"
+)
+
+// loadBaseImage loads the configured base image for the build architecture.
+// The image is read from a local OCI layout if one exists at the configured
+// path, or from its mirror, otherwise it is treated as a registry reference
+// and pulled.
+func (bc *Context) loadBaseImage(ctx context.Context) (*baseimg.BaseImage, error) {
+	desc := bc.ic.Contents.BaseImage
+
+	var apkindexPath string
+	if desc.APKIndex != "" {
+		p, err := paths.ResolvePath(desc.APKIndex, bc.o.IncludePaths)
+		if err != nil {
+			return nil, fmt.Errorf("baseImage apk path %s: %w", desc.APKIndex, err)
+		}
+		apkindexPath = p
+	}
+
+	if imgPath, err := paths.ResolvePath(desc.Image, bc.o.IncludePaths); err == nil {
+		if apkindexPath == "" {
+			return nil, fmt.Errorf("baseImage apkindex is required for local image %s", desc.Image)
+		}
+		return baseimg.New(imgPath, apkindexPath, bc.Arch(), bc.o.TempDir())
+	}
+	if dir, ok := bc.o.BaseImageMirrors[desc.Image]; ok {
+		baseImg, err := baseimg.NewMirror(desc.Image, dir, apkindexPath, bc.Arch(), bc.o.TempDir())
+		if err != nil {
+			return nil, fmt.Errorf("baseImage %s from %s: %w", desc.Image, dir, err)
+		}
+		return baseImg, nil
+	}
+	if bc.o.Offline {
+		return nil, fmt.Errorf("baseImage %s: %w: offline builds need a local OCI layout", desc.Image, apk.ErrOffline)
+	}
+
+	baseImg, err := baseimg.NewRemote(ctx, desc.Image, apkindexPath, bc.Arch(), bc.imageCacheDir(), bc.o.TempDir(), bc.registryOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("baseImage %s: %w", desc.Image, err)
+	}
+	return baseImg, nil
+}
+
+// loadImage loads the image for the build architecture of ref, a local OCI
+// layout or a registry reference, like loadBaseImage.
+func (bc *Context) loadImage(ctx context.Context, ref string) (v1.Image, error) {
+	if imgPath, err := paths.ResolvePath(ref, bc.o.IncludePaths); err == nil {
+		return baseimg.LocalImage(imgPath, bc.Arch())
+	}
+	if bc.o.Offline {
+		return nil, fmt.Errorf("%w: offline builds need a local OCI layout", apk.ErrOffline)
+	}
+	return baseimg.Fetch(ctx, ref, bc.Arch(), bc.imageCacheDir(), bc.registryOptions()...)
+}
+
+// imageCacheDir is where pulled images are cached, if there is a cache.
+func (bc *Context) imageCacheDir() string {
+	if bc.o.CacheDir == "" {
+		return ""
+	}
+	return filepath.Join(bc.o.CacheDir, "baseimage")
+}
+
+// registryOptions are the options of the requests pulling images.
+func (bc *Context) registryOptions() []remote.Option {
+	transport := remote.DefaultTransport
+	if bc.o.FIPS {
+		transport = apk.FIPSTransport(transport)
+	}
+	ropts := []remote.Option{remote.WithAuthFromKeychain(bc.keychain()), remote.WithTransport(oci.RateLimitTransport(transport))}
+	return append(ropts, bc.o.RemoteOptions...)
+}
+
+// BaseImageAnnotations returns the OCI annotations recording the base image
+// the build is layered on, if it was pulled from a registry.
+func (bc *Context) BaseImageAnnotations() (map[string]string, error) {
+	if bc.baseimg == nil {
+		return nil, nil
+	}
+	return bc.baseimg.Annotations()
+}
+
+// keychain returns the keychain used to authenticate to registries, which
+// defaults to the docker config, GitHub and cloud workload identity
+// credentials.
+func (bc *Context) keychain() authn.Keychain {
+	if bc.o.Keychain != nil {
+		return bc.o.Keychain
+	}
+	return keychain.Default
+}
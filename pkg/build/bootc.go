@@ -0,0 +1,188 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// bootcLabels are the labels bootc and rpm-ostree recognize bootable
+// container images by.
+var bootcLabels = map[string]string{
+	"containers.bootc": "1",
+	"ostree.bootable":  "true",
+}
+
+// bootcPrepareRoot is the configuration of ostree-prepare-root, which mounts
+// the deployments of the image with composefs.
+const bootcPrepareRoot = "usr/lib/ostree/prepare-root.conf"
+
+// usrMergedDirs are the directories of the root that a /usr-merged image has
+// as links into /usr.
+var usrMergedDirs = []string{"bin", "sbin", "lib", "lib64"}
+
+// layoutBootc lays the root filesystem out for bootc and OSTree hosts, which
+// deploy it as a read-only /usr with composefs: it checks that the image is
+// /usr-merged, moves the kernel of /boot to /usr/lib/modules, enables
+// composefs and adds the links of the OSTree sysroot.
+func (bc *Context) layoutBootc(ctx context.Context) error {
+	if !bc.o.Bootc {
+		return nil
+	}
+	ctx, span := otel.Tracer("apko").Start(ctx, "layoutBootc")
+	defer span.End()
+
+	return layoutBootc(ctx, bc.fs, bc.ic.Modes.Directory())
+}
+
+func layoutBootc(ctx context.Context, fsys apkfs.FullFS, dirMode fs.FileMode) error {
+	log := clog.FromContext(ctx)
+
+	if err := checkUsrMerge(fsys); err != nil {
+		return err
+	}
+	if err := layoutBootcKernel(ctx, fsys); err != nil {
+		return err
+	}
+
+	if _, err := fsys.Stat(bootcPrepareRoot); errors.Is(err, fs.ErrNotExist) {
+		if err := fsys.MkdirAll(path.Dir(bootcPrepareRoot), dirMode); err != nil {
+			return err
+		}
+		if err := fsys.WriteFile(bootcPrepareRoot, []byte("[composefs]\nenabled = yes\n"), 0o644); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	if err := fsys.MkdirAll("sysroot", dirMode); err != nil {
+		return err
+	}
+	if err := fsys.Remove("ostree"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := fsys.Symlink("sysroot/ostree", "ostree"); err != nil {
+		return err
+	}
+
+	// /var is only copied into a deployment the first time, so files there
+	// are not updated with the image.
+	return fs.WalkDir(fsys, "var", func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == "var" {
+			return fs.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			log.Warnf("/%s is in /var, which bootc only populates on the first deployment of the image; consider systemd-tmpfiles", p)
+		}
+		return nil
+	})
+}
+
+// checkUsrMerge returns an error unless the directories of usrMergedDirs that
+// the image has are links into /usr.
+func checkUsrMerge(fsys apkfs.FullFS) error {
+	for _, dir := range usrMergedDirs {
+		target, err := fsys.Readlink(dir)
+		if err == nil {
+			if !strings.HasPrefix(path.Join("/", dir, "..", target), "/usr/") {
+				return fmt.Errorf("bootc images must be /usr-merged, but /%s links to %s, outside of /usr", dir, target)
+			}
+			continue
+		}
+		if _, err := fsys.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		return fmt.Errorf("bootc images must be /usr-merged, but /%s is a directory instead of a link to /usr/%s", dir, dir)
+	}
+	return nil
+}
+
+// layoutBootcKernel checks that the image has a single kernel in
+// /usr/lib/modules, moving its vmlinuz and initramfs there from /boot if the
+// kernel package installs them there.
+func layoutBootcKernel(ctx context.Context, fsys apkfs.FullFS) error {
+	log := clog.FromContext(ctx)
+
+	entries, err := fsys.ReadDir("usr/lib/modules")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	var versions []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			versions = append(versions, entry.Name())
+		}
+	}
+	switch len(versions) {
+	case 0:
+		return fmt.Errorf("bootc images boot the kernel of /usr/lib/modules/<version>, but the image has none (add a kernel package)")
+	case 1:
+	default:
+		return fmt.Errorf("bootc images have a single kernel, but /usr/lib/modules has %d: %s", len(versions), strings.Join(versions, ", "))
+	}
+	version := versions[0]
+
+	for _, f := range []struct {
+		name   string
+		boot   []string
+		needed bool
+	}{
+		{name: "vmlinuz", boot: []string{"boot/vmlinuz-" + version, "boot/vmlinuz"}, needed: true},
+		{name: "initramfs.img", boot: []string{"boot/initramfs-" + version + ".img", "boot/initramfs.img"}},
+	} {
+		dest := path.Join("usr/lib/modules", version, f.name)
+		if _, err := fsys.Stat(dest); err == nil {
+			continue
+		}
+		moved := false
+		for _, src := range f.boot {
+			if _, err := fsys.Stat(src); err != nil {
+				continue
+			}
+			log.Infof("moving /%s to /%s", src, dest)
+			if err := fsys.Link(src, dest); err != nil {
+				return fmt.Errorf("moving /%s to /%s: %w", src, dest, err)
+			}
+			if err := fsys.Remove(src); err != nil {
+				return fmt.Errorf("moving /%s to /%s: %w", src, dest, err)
+			}
+			moved = true
+			break
+		}
+		switch {
+		case moved:
+		case f.needed:
+			return fmt.Errorf("bootc images boot /%s, but the image has neither it nor /%s", dest, f.boot[0])
+		default:
+			log.Warnf("the image has no /%s, which ostree-prepare-root runs from to mount composefs", dest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestLayoutBootc(t *testing.T) {
+	ctx := context.Background()
+
+	// bootcFS returns a /usr-merged root with the kernel 6.12.1 in /boot.
+	bootcFS := func(t *testing.T) apkfs.FullFS {
+		fsys := apkfs.NewMemFS()
+		for _, dir := range []string{"usr/bin", "usr/lib/modules/6.12.1/kernel", "boot", "var/lib"} {
+			require.NoError(t, fsys.MkdirAll(dir, 0o755))
+		}
+		require.NoError(t, fsys.Symlink("usr/bin", "bin"))
+		require.NoError(t, fsys.Symlink("/usr/bin", "sbin"))
+		require.NoError(t, fsys.Symlink("usr/lib", "lib"))
+		require.NoError(t, fsys.WriteFile("boot/vmlinuz-6.12.1", []byte("kernel"), 0o644))
+		return fsys
+	}
+
+	t.Run("layout", func(t *testing.T) {
+		fsys := bootcFS(t)
+		require.NoError(t, fsys.WriteFile("boot/initramfs-6.12.1.img", []byte("initramfs"), 0o644))
+		require.NoError(t, layoutBootc(ctx, fsys, 0o755))
+
+		b, err := fsys.ReadFile("usr/lib/modules/6.12.1/vmlinuz")
+		require.NoError(t, err)
+		require.Equal(t, "kernel", string(b))
+		b, err = fsys.ReadFile("usr/lib/modules/6.12.1/initramfs.img")
+		require.NoError(t, err)
+		require.Equal(t, "initramfs", string(b))
+		_, err = fsys.Stat("boot/vmlinuz-6.12.1")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+
+		b, err = fsys.ReadFile("usr/lib/ostree/prepare-root.conf")
+		require.NoError(t, err)
+		require.Equal(t, "[composefs]\nenabled = yes\n", string(b))
+		target, err := fsys.Readlink("ostree")
+		require.NoError(t, err)
+		require.Equal(t, "sysroot/ostree", target)
+		fi, err := fsys.Stat("sysroot")
+		require.NoError(t, err)
+		require.True(t, fi.IsDir())
+	})
+
+	t.Run("existing prepare-root", func(t *testing.T) {
+		fsys := bootcFS(t)
+		require.NoError(t, fsys.MkdirAll("usr/lib/ostree", 0o755))
+		require.NoError(t, fsys.WriteFile("usr/lib/ostree/prepare-root.conf", []byte("[composefs]\nenabled = verity\n"), 0o644))
+		require.NoError(t, layoutBootc(ctx, fsys, 0o755))
+
+		b, err := fsys.ReadFile("usr/lib/ostree/prepare-root.conf")
+		require.NoError(t, err)
+		require.Equal(t, "[composefs]\nenabled = verity\n", string(b))
+	})
+
+	for _, tt := range []struct {
+		name    string
+		mutate  func(t *testing.T, fsys apkfs.FullFS)
+		wantErr string
+	}{{
+		name: "not usr-merged",
+		mutate: func(t *testing.T, fsys apkfs.FullFS) {
+			require.NoError(t, fsys.MkdirAll("lib64", 0o755))
+		},
+		wantErr: "bootc images must be /usr-merged, but /lib64 is a directory instead of a link to /usr/lib64",
+	}, {
+		name: "link outside usr",
+		mutate: func(t *testing.T, fsys apkfs.FullFS) {
+			require.NoError(t, fsys.Symlink("opt/lib64", "lib64"))
+		},
+		wantErr: "bootc images must be /usr-merged, but /lib64 links to opt/lib64, outside of /usr",
+	}, {
+		name: "no vmlinuz",
+		mutate: func(t *testing.T, fsys apkfs.FullFS) {
+			require.NoError(t, fsys.Remove("boot/vmlinuz-6.12.1"))
+		},
+		wantErr: "bootc images boot /usr/lib/modules/6.12.1/vmlinuz, but the image has neither it nor /boot/vmlinuz-6.12.1",
+	}, {
+		name: "two kernels",
+		mutate: func(t *testing.T, fsys apkfs.FullFS) {
+			require.NoError(t, fsys.MkdirAll("usr/lib/modules/6.6.60", 0o755))
+		},
+		wantErr: "bootc images have a single kernel, but /usr/lib/modules has 2: 6.12.1, 6.6.60",
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := bootcFS(t)
+			tt.mutate(t, fsys)
+			require.EqualError(t, layoutBootc(ctx, fsys, 0o755), tt.wantErr)
+		})
+	}
+
+	t.Run("no kernel", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.EqualError(t, layoutBootc(ctx, fsys, 0o755), "bootc images boot the kernel of /usr/lib/modules/<version>, but the image has none (add a kernel package)")
+	})
+}
@@ -21,6 +21,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -33,6 +34,8 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	v1types "github.com/google/go-containerregistry/pkg/v1/types"
 	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 
 	"github.com/chainguard-dev/clog"
@@ -41,9 +44,10 @@ import (
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
 	"chainguard.dev/apko/pkg/baseimg"
 	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/lock"
 	"chainguard.dev/apko/pkg/options"
-	"chainguard.dev/apko/pkg/paths"
-	"chainguard.dev/apko/pkg/s6"
+	"chainguard.dev/apko/pkg/progress"
+	"chainguard.dev/apko/pkg/report"
 )
 
 // compressionCache stores descriptor information for already-compressed layers,
@@ -53,23 +57,62 @@ var compressionCache sync.Map // map[string]*v1.Descriptor
 // Context contains all of the information necessary to build an
 // OCI image. Includes the configuration for the build,
 // the path to the config file, the executor for root jails and
-// architecture emulation, the s6 supervisor to add to the image,
-// build options, and the `buildImplementation`, which handles the actual build.
+// architecture emulation, build options, and the `buildImplementation`,
+// which handles the actual build.
 type Context struct {
 	// ImageConfiguration instructions to use for the build, normally from an apko.yaml file, but can be set directly.
 	ic types.ImageConfiguration
 	o  options.Options
 
-	s6      *s6.Context
 	fs      apkfs.FullFS
 	apk     *apk.APK
 	baseimg *baseimg.BaseImage
+
+	// skippedScriptlets are the scriptlets that were not run, as
+	// "<name>-<version>.<scriptlet>".
+	skippedScriptlets []string
+	// strippedSetuid are the files whose setuid and setgid bits were
+	// cleared.
+	strippedSetuid []string
+	// excludedPaths are the paths removed from the image by its
+	// exclude-paths.
+	excludedPaths []string
+	// pathImages are the images of the image paths by reference, so that
+	// each is only loaded once per build.
+	pathImages map[string]v1.Image
+	// world and worldConflicts are what the world resolved to, resolved once
+	// per build so that the layer cache key, the package policy and the
+	// installation agree on the packages.
+	world          []*apk.RepositoryPackage
+	worldConflicts []string
+	// lockfile is the lockfile of the build, loaded and checked once.
+	lockfile *lock.Lock
 }
 
 func (bc *Context) Summarize(ctx context.Context) {
 	bc.ic.Summarize(ctx)
 }
 
+// SkippedScriptlets returns the install scriptlets that were not run when
+// building the image, because of the no-scripts packages or the package
+// policy, as "<name>-<version>.<scriptlet>". It is empty when scriptlets are
+// not run at all.
+func (bc *Context) SkippedScriptlets() []string {
+	return bc.skippedScriptlets
+}
+
+// StrippedSetuid returns the files whose setuid and setgid bits were cleared
+// when building the image, because its modes strip them.
+func (bc *Context) StrippedSetuid() []string {
+	return bc.strippedSetuid
+}
+
+// ExcludedPaths returns the paths removed from the image when building it,
+// because they match its exclude-paths.
+func (bc *Context) ExcludedPaths() []string {
+	return bc.excludedPaths
+}
+
 func (bc *Context) BaseImage() v1.Image {
 	if bc.baseimg != nil {
 		return bc.baseimg.Image()
@@ -142,9 +185,49 @@ func (bc *Context) BuildLayer(ctx context.Context) (string, v1.Layer, error) {
 
 // BuildLayers is like BuildLayer but has the potential to return multiple layers.
 func (bc *Context) BuildLayers(ctx context.Context) ([]v1.Layer, error) {
-	ctx, span := otel.Tracer("apko").Start(ctx, "BuildLayers")
+	ctx, span := otel.Tracer("apko").Start(ctx, "BuildLayers", trace.WithAttributes(attribute.String("arch", bc.Arch().String())))
 	defer span.End()
 
+	var (
+		layers []v1.Layer
+		err    error
+	)
+	// Layers built on top of a base image depend on the base image too,
+	// which isn't part of the cache key, so don't cache those.
+	if bc.o.LayerCacheDir == "" || bc.baseimg != nil {
+		layers, err = bc.buildLayersUncached(ctx)
+	} else {
+		layers, err = bc.buildLayersCached(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, l := range layers {
+		bc.reportLayer(ctx, l)
+	}
+
+	return layers, nil
+}
+
+// reportLayer emits a progress event with the uncompressed size of l.
+func (bc *Context) reportLayer(ctx context.Context, l v1.Layer) {
+	if !progress.Enabled(ctx) {
+		return
+	}
+	e := progress.Event{Phase: progress.PhaseLayer, Arch: bc.Arch().ToAPK(), Done: true}
+	if diffid, err := l.DiffID(); err == nil {
+		e.Name = diffid.String()
+	}
+	if ll, ok := l.(*layer); ok {
+		if fi, err := os.Stat(ll.uncompressed); err == nil {
+			e.Current, e.Total = fi.Size(), fi.Size()
+		}
+	}
+	progress.Report(ctx, e)
+}
+
+func (bc *Context) buildLayersUncached(ctx context.Context) ([]v1.Layer, error) {
 	// Use the legacy (single-layer) strategy when:
 	// 1. Layering is nil (original behavior)
 	// 2. Layering is empty (i.e., layering: {})
@@ -187,9 +270,15 @@ func (bc *Context) ImageLayoutToLayer(ctx context.Context) (string, v1.Layer, er
 	bc.o.TarballPath = outfile.Name()
 	defer outfile.Close()
 
+	det, err := bc.determinism()
+	if err != nil {
+		return "", nil, err
+	}
+
 	lw := newLayerWriter(outfile)
 
-	if err := writeTar(ctx, lw.w, bc.fs); err != nil {
+	defer report.FromContext(ctx).Time(report.PhaseTar, bc.Arch().ToAPK())()
+	if err := writeTar(ctx, lw.w, bc.fs, det, bc.omittedPaths()); err != nil {
 		return "", nil, fmt.Errorf("generating tarball: %w", err)
 	}
 
@@ -201,6 +290,37 @@ func (bc *Context) ImageLayoutToLayer(ctx context.Context) (string, v1.Layer, er
 	return outfile.Name(), l, nil
 }
 
+// omittedPaths returns the paths that are left out of the layers, although
+// they are in the filesystem the image is built in: the apk database, if the
+// image configuration omits it, which is still read to generate the SBOM, and
+// the runtime repositories and keys it excludes.
+func (bc *Context) omittedPaths() []string {
+	if bc.ic.Contents.OmitAPKDatabase {
+		return []string{"etc/apk", "lib/apk/db", "usr/lib/apk/db"}
+	}
+	var omit []string
+	if !bc.ic.Contents.Runtime.InstallRepositories() {
+		omit = append(omit, "etc/apk/repositories")
+	}
+	if !bc.ic.Contents.Runtime.InstallKeys() {
+		omit = append(omit, "etc/apk/keys")
+	}
+	return omit
+}
+
+// determinism returns how layers are checked for nondeterministic input,
+// against the build date of the image in strict mode.
+func (bc *Context) determinism() (determinism, error) {
+	if !bc.o.StrictReproducibility {
+		return determinism{}, nil
+	}
+	epoch, err := bc.GetBuildDateEpoch()
+	if err != nil {
+		return determinism{}, fmt.Errorf("determining build date: %w", err)
+	}
+	return determinism{strict: true, epoch: epoch}, nil
+}
+
 func (bc *Context) checkPaths(ctx context.Context) error {
 	log := clog.FromContext(ctx)
 
@@ -279,6 +399,8 @@ func New(ctx context.Context, fs apkfs.FullFS, opts ...Option) (*Context, error)
 		apk.WithArch(bc.o.Arch.ToAPK()),
 		apk.WithIgnoreMknodErrors(true),
 		apk.WithIgnoreIndexSignatures(bc.o.IgnoreSignatures),
+		apk.WithFIPS(bc.o.FIPS),
+		apk.WithRepositoryTLS(bc.o.RepositoryTLS...),
 		apk.WithAuthenticator(bc.o.Auth),
 		apk.WithTransport(bc.o.Transport),
 		apk.WithPackageGetter(bc.o.PackageGetter),
@@ -288,11 +410,20 @@ func New(ctx context.Context, fs apkfs.FullFS, opts ...Option) (*Context, error)
 			APKDataMaxSize:              bc.o.SizeLimits.APKDataMaxSize,
 			HTTPResponseMaxSize:         bc.o.SizeLimits.HTTPResponseMaxSize,
 		}),
+		apk.WithPreferredProviders(bc.ic.Contents.PreferProviders),
+		apk.WithProviderTieBreak(bc.ic.Contents.ProviderTieBreak),
+	}
+	if len(bc.ic.Contents.Omit) != 0 {
+		apkOpts = append(apkOpts, apk.WithOmitFiles(bc.ic.Contents.OmitsFile))
 	}
 	// only try to pass the cache dir if one of the following is true:
 	// - the user has explicitly set a cache dir
 	// - the user's system-determined cachedir, as set by os.UserCacheDir(), can be found
 	// if neither of these are true, then we don't want to pass a cache dir, because
+	if bc.o.RemoteCache != nil {
+		apkOpts = append(apkOpts, apk.WithRemoteCache(bc.o.RemoteCache))
+	}
+
 	// go-apk will try to set it to os.UserCacheDir() which returns an error if $HOME
 	// is not set.
 
@@ -302,20 +433,14 @@ func New(ctx context.Context, fs apkfs.FullFS, opts ...Option) (*Context, error)
 		apkOpts = append(apkOpts, apk.WithCache(bc.o.CacheDir, bc.o.Offline, bc.o.SharedCache))
 	} else if _, err := os.UserCacheDir(); err == nil {
 		apkOpts = append(apkOpts, apk.WithCache(bc.o.CacheDir, bc.o.Offline, bc.o.SharedCache))
+	} else if bc.o.Offline {
+		return nil, fmt.Errorf("offline builds need a cache directory, and cannot determine system default: %w", err)
 	} else {
 		log.Warnf("cache disabled because cache dir was not set, and cannot determine system default: %v", err)
 	}
 
 	if bc.ic.Contents.BaseImage != nil {
-		imgPath, err := paths.ResolvePath(bc.ic.Contents.BaseImage.Image, bc.o.IncludePaths)
-		if err != nil {
-			return nil, fmt.Errorf("baseImage path %s: %w", bc.ic.Contents.BaseImage.Image, err)
-		}
-		apkindexPath, err := paths.ResolvePath(bc.ic.Contents.BaseImage.APKIndex, bc.o.IncludePaths)
-		if err != nil {
-			return nil, fmt.Errorf("baseImage apk path %s: %w", bc.ic.Contents.BaseImage.Image, err)
-		}
-		baseImg, err := baseimg.New(imgPath, apkindexPath, bc.Arch(), bc.o.TempDir())
+		baseImg, err := bc.loadBaseImage(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -337,13 +462,19 @@ func New(ctx context.Context, fs apkfs.FullFS, opts ...Option) (*Context, error)
 	if err := bc.ic.Validate(); err != nil {
 		return nil, fmt.Errorf("failed to validate configuration: %w", err)
 	}
+	if bc.o.Bootc {
+		labels := maps.Clone(bc.ic.Labels)
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		maps.Copy(labels, bootcLabels)
+		bc.ic.Labels = labels
+	}
 
 	if err := bc.initializeApk(ctx); err != nil {
 		return nil, fmt.Errorf("initializing apk: %w", err)
 	}
 
-	bc.s6 = s6.New(bc.fs)
-
 	return &bc, nil
 }
 
@@ -367,6 +498,33 @@ type layer struct {
 	compressed   string
 	diffid       *v1.Hash
 	desc         *v1.Descriptor
+	// packages are the packages whose files are in the layer, as
+	// "<name>=<version>", when the image is split into layers by package.
+	packages []string
+	// origins are the origins of the packages, which name the group of
+	// packages the layer holds.
+	origins []string
+	// created is the newest build date of the packages.
+	created time.Time
+	// fingerprint identifies the contents of a layer of packages, see
+	// layerWriter.
+	fingerprint string
+}
+
+// Packages returns the packages whose files are in the layer.
+func (l *layer) Packages() []string {
+	return l.packages
+}
+
+// Origins returns the origins of the packages whose files are in the layer.
+func (l *layer) Origins() []string {
+	return l.origins
+}
+
+// Created returns the newest build date of the packages whose files are in
+// the layer, or the zero time if it has none.
+func (l *layer) Created() time.Time {
+	return l.created
 }
 
 func (l *layer) compress() error {
@@ -383,10 +541,14 @@ func (l *layer) compress() error {
 	}
 	defer in.Close()
 
-	out, err := os.Create(l.uncompressed + ".gz")
+	// The uncompressed layer may be a blob of the layer cache, so write the
+	// compressed one next to it atomically, for other builds to read it.
+	out, err := os.CreateTemp(filepath.Dir(l.uncompressed), ".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(out.Name())
+	defer out.Close()
 
 	buf := pooledBufioWriter(out)
 	defer bufioPool.Put(buf)
@@ -411,6 +573,12 @@ func (l *layer) compress() error {
 	if err != nil {
 		return fmt.Errorf("statting %s: %w", out.Name(), err)
 	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(out.Name(), l.uncompressed+".gz"); err != nil {
+		return err
+	}
 
 	h := v1.Hash{
 		Algorithm: "sha256",
@@ -426,7 +594,7 @@ func (l *layer) compress() error {
 
 	l.compressed = l.uncompressed + ".gz"
 
-	return out.Close()
+	return nil
 }
 
 func (l *layer) DiffID() (v1.Hash, error) {
@@ -511,3 +679,24 @@ func (bc *Context) WantSBOM() bool {
 func (bc *Context) APK() *apk.APK {
 	return bc.apk
 }
+
+// Keyring returns the keys installed for the build: those of its keyring,
+// and those discovered from its repositories.
+func (bc *Context) Keyring() ([]apk.Key, error) {
+	des, err := bc.fs.ReadDir(apk.DefaultKeyRingPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading keyring: %w", err)
+	}
+	keys := make([]apk.Key, 0, len(des))
+	for _, de := range des {
+		if de.IsDir() {
+			continue
+		}
+		b, err := bc.fs.ReadFile(filepath.Join(apk.DefaultKeyRingPath, de.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading key %s: %w", de.Name(), err)
+		}
+		keys = append(keys, apk.Key{ID: de.Name(), Bytes: b})
+	}
+	return keys, nil
+}
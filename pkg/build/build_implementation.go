@@ -22,10 +22,12 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sync"
 
 	"github.com/chainguard-dev/clog"
@@ -36,8 +38,10 @@ import (
 	ldsocache "chainguard.dev/apko/internal/ldso-cache"
 	"chainguard.dev/apko/pkg/apk/apk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/lock"
 	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/supervision"
 )
 
 // pgzip's default is GOMAXPROCS(0)
@@ -83,9 +87,13 @@ func pooledBufioWriter(w io.Writer) *bufio.Writer {
 // of doing everything in one pass, this is necessary for multi-layer
 // images where we are writing to multiple layers at the same time.
 type layerWriter struct {
-	w        *tar.Writer
-	stack    []*file // only used by multi-layer builds
-	finalize func() (*layer, error)
+	w     *tar.Writer // nil if the layer is only fingerprinted
+	stack []*file     // only used by multi-layer builds
+	// fingerprint hashes what is written to the layer, with the checksums
+	// of package files in place of their contents, so that a layer of
+	// package files can be matched to a cached one without writing it.
+	fingerprint hash.Hash // only used by multi-layer builds
+	finalize    func() (*layer, error)
 }
 
 // newLayerWriter wraps a file with a gzipping tar writer that computes
@@ -154,15 +162,11 @@ func (bc *Context) buildImage(ctx context.Context) ([]apk.InstalledDiff, error)
 	)
 	if bc.o.Lockfile != "" {
 		log.Debugf("Using lockfile: %s", bc.o.Lockfile)
-		lock, err := lock.FromFile(bc.o.Lockfile)
-		if err != nil {
-			return nil, fmt.Errorf("failed to load lock-file: %w", err)
-		}
-		err = bc.VerifyLockfileConsistency(ctx, lock.Config)
+		lock, err := bc.loadLockfile(ctx)
 		if err != nil {
 			return nil, err
 		}
-		allPkgs, err := installablePackagesForArch(lock, bc.Arch())
+		allPkgs, err := installablePackagesForArch(*lock, bc.Arch())
 		if err != nil {
 			return nil, fmt.Errorf("failed getting packages for install from lockfile %s: %w", bc.o.Lockfile, err)
 		}
@@ -170,8 +174,20 @@ func (bc *Context) buildImage(ctx context.Context) ([]apk.InstalledDiff, error)
 		if err != nil {
 			return nil, fmt.Errorf("failed installation from lockfile %s: %w", bc.o.Lockfile, err)
 		}
+		// The lockfile lacks the origins and dependencies of the packages, so
+		// they can only be checked once installed.
+		if err := bc.checkPackagePolicy(ctx, lockedPolicyPackages(pkgs, *lock, bc.Arch().ToAPK())); err != nil {
+			return nil, err
+		}
 	} else {
-		pkgs, err = bc.apk.FixateWorld(ctx, &bc.o.SourceDateEpoch)
+		if err := bc.checkResolvedPackagePolicy(ctx); err != nil {
+			return nil, err
+		}
+		world, conflicts, err := bc.resolveWorld(ctx)
+		if err != nil {
+			return nil, err
+		}
+		pkgs, err = bc.apk.InstallResolved(ctx, &bc.o.SourceDateEpoch, world, conflicts)
 		if err != nil {
 			return nil, fmt.Errorf("installing apk packages: %w", err)
 		}
@@ -190,7 +206,15 @@ func (bc *Context) buildImage(ctx context.Context) ([]apk.InstalledDiff, error)
 		return nil, fmt.Errorf("failed to install apko config: %w", err)
 	}
 
-	if err := mutatePaths(bc.fs, &bc.o, &bc.ic); err != nil {
+	// Before paths, so that they can still replace the generated files.
+	if err := bc.writeEtcFiles(ctx); err != nil {
+		return nil, fmt.Errorf("failed to write /etc files: %w", err)
+	}
+
+	loadImage := func(ref string) (v1.Image, error) {
+		return bc.pathImage(ctx, ref)
+	}
+	if err := mutatePaths(bc.fs, &bc.o, &bc.ic, loadImage); err != nil {
 		return nil, fmt.Errorf("failed to mutate paths: %w", err)
 	}
 
@@ -198,7 +222,7 @@ func (bc *Context) buildImage(ctx context.Context) ([]apk.InstalledDiff, error)
 		return nil, fmt.Errorf("failed to install certificates: %w", err)
 	}
 
-	if err := bc.s6.WriteSupervisionTree(ctx, bc.ic.Entrypoint.Services); err != nil {
+	if err := bc.writeSupervisionTree(ctx); err != nil {
 		return nil, fmt.Errorf("failed to write supervision tree: %w", err)
 	}
 
@@ -217,10 +241,39 @@ func (bc *Context) buildImage(ctx context.Context) ([]apk.InstalledDiff, error)
 		return nil, err
 	}
 
+	// The scriptlets run once busybox provides a shell.
+	if err := bc.runScriptlets(ctx, installed); err != nil {
+		return nil, err
+	}
+
+	if err := bc.generateLocales(ctx); err != nil {
+		return nil, err
+	}
+
+	// After paths, so that the units they add can be enabled too.
+	if err := bc.setupSystemd(ctx); err != nil {
+		return nil, err
+	}
+
+	// After paths and scriptlets, so that the files they create can be
+	// excluded too.
+	if err := bc.excludePaths(ctx); err != nil {
+		return nil, err
+	}
+
+	// Last, so that files created by paths and scriptlets are stripped too.
+	if err := bc.stripSetuid(ctx); err != nil {
+		return nil, err
+	}
+
 	if err := updateCache(ctx, bc.fs); err != nil {
 		return nil, err
 	}
 
+	if err := bc.layoutBootc(ctx); err != nil {
+		return nil, err
+	}
+
 	log.Debug("finished building filesystem")
 
 	return pkgs, nil
@@ -244,12 +297,17 @@ func updateCache(ctx context.Context, fsys apkfs.FullFS) error {
 		return nil
 	}
 
-	libdirs := []string{"/lib"}
-	dirs, err := ldsocache.ParseLDSOConf(fsys, "etc/ld.so.conf")
+	libdirs, err := ldsocache.ParseLDSOConf(fsys, "etc/ld.so.conf")
 	if err != nil {
 		return fmt.Errorf("parsing /etc/ld.so.conf: %w", err)
 	}
-	libdirs = append(libdirs, dirs...)
+	// Like ldconfig, search the trusted directories after those of
+	// ld.so.conf and ld.so.conf.d.
+	for _, dir := range []string{"/lib", "/usr/lib"} {
+		if !slices.Contains(libdirs, dir) {
+			libdirs = append(libdirs, dir)
+		}
+	}
 	cacheFile, err := ldsocache.BuildCacheFileForDirs(fsys, libdirs)
 	if err != nil {
 		return fmt.Errorf("generating ldsocache: %w", err)
@@ -274,7 +332,7 @@ func (bc *Context) WriteEtcApkoConfig(_ context.Context) error {
 	if err != nil {
 		return fmt.Errorf("creating /etc/apko.json: %w", err)
 	}
-	if err := json.NewEncoder(f).Encode(bc.ic); err != nil {
+	if err := json.NewEncoder(f).Encode(redactedConfig(bc.ic)); err != nil {
 		return fmt.Errorf("encoding image config: %w", err)
 	}
 	if err := f.Close(); err != nil {
@@ -286,6 +344,18 @@ func (bc *Context) WriteEtcApkoConfig(_ context.Context) error {
 	return nil
 }
 
+// redactedConfig returns a copy of ic without the password hashes of its
+// users, which only /etc/shadow may hold, as /etc/apko.json is readable by
+// every user of the image.
+func redactedConfig(ic types.ImageConfiguration) types.ImageConfiguration {
+	users := slices.Clone(ic.Accounts.Users)
+	for i := range users {
+		users[i].PasswordHash = ""
+	}
+	ic.Accounts.Users = users
+	return ic
+}
+
 // WriteIndex saves the index file from the given image configuration.
 func WriteIndex(ctx context.Context, o *options.Options, idx v1.ImageIndex) (string, error) {
 	log := clog.FromContext(ctx)
@@ -303,6 +373,35 @@ func WriteIndex(ctx context.Context, o *options.Options, idx v1.ImageIndex) (str
 	return outfile, nil
 }
 
+// resolveWorld returns the packages and conflicts the world resolves to,
+// resolving it on first use.
+func (bc *Context) resolveWorld(ctx context.Context) ([]*apk.RepositoryPackage, []string, error) {
+	if bc.world == nil {
+		world, conflicts, err := bc.apk.ResolveWorld(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolving apk packages: %w", err)
+		}
+		bc.world, bc.worldConflicts = world, conflicts
+	}
+	return bc.world, bc.worldConflicts, nil
+}
+
+// loadLockfile returns the lockfile of the build, loading it and checking
+// that it matches the configuration on first use.
+func (bc *Context) loadLockfile(ctx context.Context) (*lock.Lock, error) {
+	if bc.lockfile == nil {
+		l, err := lock.FromFile(bc.o.Lockfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load lock-file: %w", err)
+		}
+		if err := bc.VerifyLockfileConsistency(ctx, l.Config); err != nil {
+			return nil, err
+		}
+		bc.lockfile = &l
+	}
+	return bc.lockfile, nil
+}
+
 func (bc *Context) BuildPackageList(ctx context.Context) (toInstall []*apk.RepositoryPackage, conflicts []string, err error) {
 	log := clog.FromContext(ctx)
 
@@ -330,6 +429,9 @@ func (bc *Context) ResolveWithBase(ctx context.Context) ([]*apk.APKResolved, err
 	if err != nil {
 		return nil, err
 	}
+	if err := bc.checkPackagePolicy(ctx, resolvedPolicyPackages(allPkgs, bc.Arch().ToAPK())); err != nil {
+		return nil, err
+	}
 	var existingPkgs []*apk.InstalledPackage
 	if bc.baseimg != nil {
 		existingPkgs = bc.baseimg.InstalledPackages()
@@ -358,3 +460,16 @@ func (bc *Context) ResolveWithBase(ctx context.Context) ([]*apk.APKResolved, err
 func (bc *Context) InstalledPackages() ([]*apk.InstalledPackage, error) {
 	return bc.apk.GetInstalled()
 }
+
+// writeSupervisionTree writes the files the supervisor of the image runs its
+// services from.
+func (bc *Context) writeSupervisionTree(ctx context.Context) error {
+	if len(bc.ic.Entrypoint.Services) == 0 {
+		return nil
+	}
+	sup, err := supervision.New(bc.ic.Entrypoint.Supervisor)
+	if err != nil {
+		return err
+	}
+	return sup.WriteTree(ctx, bc.fs, bc.ic.Entrypoint.Services)
+}
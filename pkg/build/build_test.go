@@ -16,14 +16,20 @@ package build_test
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/stretchr/testify/require"
 
+	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/auth"
 	"chainguard.dev/apko/pkg/apk/fs"
 	"chainguard.dev/apko/pkg/build"
@@ -96,6 +102,48 @@ func TestBuildLayerWithLayeringStrategy(t *testing.T) {
 	require.Contains(t, err.Error(), "cannot use BuildLayer with a layering strategy")
 }
 
+func TestBuildLayersWithLayerCache(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+
+	for _, config := range []string{"apko.yaml", "layering.yaml"} {
+		t.Run(config, func(t *testing.T) {
+			build := func() ([]v1.Layer, *build.Context) {
+				bc, err := build.New(ctx, fs.NewMemFS(),
+					build.WithConfig(config, []string{"testdata"}),
+					build.WithLayerCache(cacheDir),
+				)
+				require.NoError(t, err)
+
+				layers, err := bc.BuildLayers(ctx)
+				require.NoError(t, err)
+				return layers, bc
+			}
+
+			first, _ := build()
+			second, bc := build()
+
+			require.Len(t, second, len(first))
+			for i := range first {
+				want, err := first[i].Digest()
+				require.NoError(t, err)
+				got, err := second[i].Digest()
+				require.NoError(t, err)
+				require.Equal(t, want, got)
+			}
+
+			// The filesystem is restored from the cache on a hit.
+			installed, err := bc.InstalledPackages()
+			require.NoError(t, err)
+			require.Len(t, installed, 2)
+		})
+	}
+
+	entries, err := os.ReadDir(filepath.Join(cacheDir, "entries"))
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+}
+
 func TestBuildImage(t *testing.T) {
 	ctx := context.Background()
 
@@ -153,6 +201,20 @@ func TestBuildImageFromLockFile(t *testing.T) {
 	require.Equal(t, installed[1].Version, "1.0.0-r0")
 }
 
+func TestBuildLayerStrictReproducibility(t *testing.T) {
+	ctx := context.Background()
+
+	opts := []build.Option{
+		build.WithConfig(filepath.Join("testdata", "apko.yaml"), []string{}),
+		build.WithStrictReproducibility(true),
+	}
+
+	bc, err := build.New(ctx, fs.NewMemFS(), opts...)
+	require.NoError(t, err)
+	_, _, err = bc.BuildLayer(ctx)
+	require.NoError(t, err)
+}
+
 func TestBuildImageFromTooOldResolvedFile(t *testing.T) {
 	ctx := context.Background()
 
@@ -243,3 +305,40 @@ func TestAuth_bad(t *testing.T) {
 	require.Error(t, err, "build should have failed to init keyring")
 	require.True(t, called)
 }
+
+func TestWithFetcher(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		fetched []string
+	)
+	fetcher := apk.FetcherFunc(func(_ context.Context, u *url.URL) (io.ReadCloser, error) {
+		if u.Host != "packages.example.com" {
+			return nil, os.ErrNotExist
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fetched = append(fetched, u.Path)
+		return os.Open(filepath.Join("testdata/packages", u.Path))
+	})
+
+	ctx := context.Background()
+	bc, err := build.New(ctx, fs.NewMemFS(),
+		build.WithImageConfiguration(types.ImageConfiguration{
+			Contents: types.ImageContents{
+				Repositories: []string{"https://packages.example.com"},
+				Keyring:      []string{"https://packages.example.com/melange.rsa.pub"},
+				Packages:     []string{"pretend-baselayout"},
+			},
+			Archs: types.ParseArchitectures([]string{"amd64"}),
+		}),
+		build.WithArch(types.ParseArchitecture("amd64")),
+		build.WithCache(t.TempDir(), false, apk.NewCache(false)),
+		build.WithFetcher(fetcher),
+	)
+	require.NoError(t, err)
+	require.NoError(t, bc.BuildImage(ctx))
+
+	require.Contains(t, fetched, "/melange.rsa.pub")
+	require.Contains(t, fetched, "/x86_64/APKINDEX.tar.gz")
+	require.Contains(t, fetched, "/x86_64/pretend-baselayout-1.0.0-r0.apk")
+}
@@ -0,0 +1,120 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"sigs.k8s.io/release-utils/version"
+)
+
+// AnnotationBuildInfo is the image label and manifest annotation holding the
+// build-info document of an image built with WithBuildInfo.
+const AnnotationBuildInfo = "dev.chainguard.apko.build-info"
+
+// BuildInfo describes the inputs an image was built from, so that an image is
+// self-describing without external attestations.
+type BuildInfo struct {
+	// Version is the version of apko that built the image.
+	Version string `json:"version"`
+	// Config is the digest of the image configuration, including the files
+	// it includes.
+	Config string `json:"config,omitempty"`
+	// Lockfile is the digest of the lockfile the packages were installed from.
+	Lockfile string `json:"lockfile,omitempty"`
+	// Indexes are the digests of the repository indexes the packages were
+	// resolved against, by index URL. They are not set when installing from
+	// a lockfile.
+	Indexes map[string]string `json:"indexes,omitempty"`
+}
+
+// String returns the canonical encoding of the build info: compact JSON with
+// the index URLs sorted.
+func (bi *BuildInfo) String() string {
+	b, err := json.Marshal(bi)
+	if err != nil {
+		// Marshaling strings and a map of strings can't fail.
+		panic(err)
+	}
+	return string(b)
+}
+
+// ParseBuildInfo parses the build info of an image from the value of its
+// AnnotationBuildInfo label or annotation.
+func ParseBuildInfo(s string) (*BuildInfo, error) {
+	var bi BuildInfo
+	if err := json.Unmarshal([]byte(s), &bi); err != nil {
+		return nil, fmt.Errorf("parsing build info: %w", err)
+	}
+	return &bi, nil
+}
+
+// BuildInfo returns the build info of the image built by this context. It
+// must be called after the layers are built.
+func (bc *Context) BuildInfo() (*BuildInfo, error) {
+	bi := &BuildInfo{
+		Version: version.GetVersionInfo().GitVersion,
+		Indexes: bc.apk.IndexDigests(),
+	}
+	if sum, ok := strings.CutPrefix(bc.o.ImageConfigChecksum, "sha256-"); ok {
+		b, err := base64.StdEncoding.DecodeString(sum)
+		if err != nil {
+			return nil, fmt.Errorf("decoding config checksum: %w", err)
+		}
+		bi.Config = "sha256:" + hex.EncodeToString(b)
+	}
+	if bc.o.Lockfile != "" {
+		b, err := os.ReadFile(bc.o.Lockfile)
+		if err != nil {
+			return nil, fmt.Errorf("reading lockfile: %w", err)
+		}
+		sum := sha256.Sum256(b)
+		bi.Lockfile = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return bi, nil
+}
+
+// WantBuildInfo returns whether the image should carry its build info.
+func (bc *Context) WantBuildInfo() bool {
+	return bc.o.BuildInfo
+}
+
+// AddBuildInfo records bi in the AnnotationBuildInfo label of the config of
+// img and in the annotation of the same name on its manifest.
+func AddBuildInfo(img v1.Image, bi *BuildInfo) (v1.Image, error) {
+	s := bi.String()
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("getting config file: %w", err)
+	}
+	cfg = cfg.DeepCopy()
+	if cfg.Config.Labels == nil {
+		cfg.Config.Labels = map[string]string{}
+	}
+	cfg.Config.Labels[AnnotationBuildInfo] = s
+	img, err = mutate.ConfigFile(img, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("updating config file: %w", err)
+	}
+	return mutate.Annotations(img, map[string]string{AnnotationBuildInfo: s}).(v1.Image), nil
+}
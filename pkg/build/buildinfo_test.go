@@ -0,0 +1,95 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build"
+)
+
+func TestBuildInfo(t *testing.T) {
+	ctx := context.Background()
+	config := filepath.Join("testdata", "apko.yaml")
+
+	t.Run("resolved", func(t *testing.T) {
+		bc, err := build.New(ctx, fs.NewMemFS(), build.WithConfig(config, []string{}))
+		require.NoError(t, err)
+		_, _, err = bc.BuildLayer(ctx)
+		require.NoError(t, err)
+
+		bi, err := bc.BuildInfo()
+		require.NoError(t, err)
+		require.NotEmpty(t, bi.Version)
+		require.True(t, strings.HasPrefix(bi.Config, "sha256:"), bi.Config)
+		require.Empty(t, bi.Lockfile)
+		require.Len(t, bi.Indexes, 1)
+		for u, d := range bi.Indexes {
+			require.True(t, strings.HasSuffix(u, "/APKINDEX.tar.gz"), u)
+			require.True(t, strings.HasPrefix(d, "sha256:"), d)
+		}
+	})
+
+	t.Run("lockfile", func(t *testing.T) {
+		bc, err := build.New(ctx, fs.NewMemFS(),
+			build.WithConfig(config, []string{}),
+			build.WithLockFile(filepath.Join("testdata", "apko.lock.json")),
+		)
+		require.NoError(t, err)
+		_, _, err = bc.BuildLayer(ctx)
+		require.NoError(t, err)
+
+		bi, err := bc.BuildInfo()
+		require.NoError(t, err)
+		require.True(t, strings.HasPrefix(bi.Lockfile, "sha256:"), bi.Lockfile)
+		require.Empty(t, bi.Indexes)
+	})
+}
+
+func TestAddBuildInfo(t *testing.T) {
+	bi := &build.BuildInfo{
+		Version:  "v1.0.0",
+		Config:   "sha256:aaaa",
+		Lockfile: "sha256:bbbb",
+		Indexes: map[string]string{
+			"https://b.example.com/x86_64/APKINDEX.tar.gz": "sha256:cccc",
+			"https://a.example.com/x86_64/APKINDEX.tar.gz": "sha256:dddd",
+		},
+	}
+	want := `{"version":"v1.0.0","config":"sha256:aaaa","lockfile":"sha256:bbbb","indexes":{"https://a.example.com/x86_64/APKINDEX.tar.gz":"sha256:dddd","https://b.example.com/x86_64/APKINDEX.tar.gz":"sha256:cccc"}}`
+	require.Equal(t, want, bi.String())
+
+	img, err := build.AddBuildInfo(empty.Image, bi)
+	require.NoError(t, err)
+
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, want, cfg.Config.Labels[build.AnnotationBuildInfo])
+
+	m, err := img.Manifest()
+	require.NoError(t, err)
+	require.Equal(t, want, m.Annotations[build.AnnotationBuildInfo])
+
+	got, err := build.ParseBuildInfo(m.Annotations[build.AnnotationBuildInfo])
+	require.NoError(t, err)
+	require.Equal(t, bi, got)
+}
@@ -17,8 +17,11 @@ package build
 import (
 	"bytes"
 	"context"
+	"crypto/sha1" //nolint:gosec // OpenSSL hashes subjects with SHA-1.
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
@@ -27,14 +30,22 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf16"
 
 	"github.com/pavlo-v-chernykh/keystore-go/v4"
 	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
 )
 
 const (
 	// Directory for individual certificate files (used by update-ca-certificates).
 	caCertsDir = "usr/local/share/ca-certificates"
+
+	// Directory of the links to certificates by subject hash (used by OpenSSL).
+	hashedCertsDir = "etc/ssl/certs"
 )
 
 var (
@@ -51,6 +62,10 @@ var (
 
 	// Default password for Java cacerts truststore.
 	javaTruststorePassword = []byte("changeit")
+
+	// Names of the links to certificates of hashedCertsDir, as c_rehash and
+	// openssl rehash create them.
+	hashedCertRegex = regexp.MustCompile(`^[0-9a-f]{8}\.[0-9]+$`)
 )
 
 // parsedCertificate represents a parsed certificate with its metadata.
@@ -72,7 +87,7 @@ func (bc *Context) installCertificates(ctx context.Context) error {
 	_, span := otel.Tracer("apko").Start(ctx, "installCertificates")
 	defer span.End()
 
-	if bc.ic.Certificates == nil || len(bc.ic.Certificates.Additional) == 0 {
+	if bc.ic.Certificates == nil || (len(bc.ic.Certificates.Additional) == 0 && !bc.ic.Certificates.Replace) {
 		// No configuration, nothing to do.
 		return nil
 	}
@@ -87,10 +102,15 @@ func (bc *Context) installCertificates(ctx context.Context) error {
 		return fmt.Errorf("failed to create ca-certificates directory: %w", err)
 	}
 
-	// Open handles for all existing CA bundles to append to.
+	// Open handles for all existing CA bundles to append to, or to replace
+	// the contents of.
+	bundleFlag := os.O_APPEND
+	if bc.ic.Certificates.Replace {
+		bundleFlag = os.O_TRUNC
+	}
 	existingBundles := make([]io.WriteSeeker, 0, len(caBundlePaths))
 	for _, caBundlePath := range caBundlePaths {
-		file, err := bc.fs.OpenFile(caBundlePath, os.O_WRONLY|os.O_APPEND, 0o644)
+		file, err := bc.fs.OpenFile(caBundlePath, os.O_WRONLY|bundleFlag, 0o644)
 		if err != nil {
 			if errors.Is(err, fs.ErrNotExist) {
 				// If the bundle doesn't exist, nothing to do, we just ignore that.
@@ -110,6 +130,18 @@ func (bc *Context) installCertificates(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to load Java truststores: %w", err)
 	}
+	if bc.ic.Certificates.Replace {
+		for _, ts := range existingTruststores {
+			for _, alias := range ts.ks.Aliases() {
+				ts.ks.DeleteEntry(alias)
+			}
+		}
+	}
+
+	hashedCerts, err := bc.loadHashedCerts()
+	if err != nil {
+		return fmt.Errorf("failed to load hashed certificates: %w", err)
+	}
 
 	for _, additional := range bc.ic.Certificates.Additional {
 		cert, err := parseCertificates(additional.Content)
@@ -128,6 +160,14 @@ func (bc *Context) installCertificates(ctx context.Context) error {
 			return fmt.Errorf("failed to change times on certificate file %s: %w", certPath, err)
 		}
 
+		// Link the certificate file by the hash of its subject, if the image
+		// has a hashed certificate directory.
+		if hashedCerts != nil {
+			if err := hashedCerts.link(bc.fs, cert, certPath); err != nil {
+				return fmt.Errorf("failed to link certificate file %s: %w", certPath, err)
+			}
+		}
+
 		// Append to all existing CA bundles.
 		for _, bundle := range existingBundles {
 			if _, err := bundle.Write(cert.pem); err != nil {
@@ -178,6 +218,169 @@ func (bc *Context) installCertificates(ctx context.Context) error {
 	return nil
 }
 
+// hashedCertDir is the hashed certificate directory of an image, in which
+// OpenSSL looks certificates up by the hash of their subject.
+type hashedCertDir struct {
+	// names are the names of the links of the directory.
+	names map[string]bool
+}
+
+// loadHashedCerts loads the hashed certificate directory of the image, if
+// there are links in it, removing them if the certificates are replaced.
+// It returns nil if there are no links, as the image does not use it then.
+func (bc *Context) loadHashedCerts() (*hashedCertDir, error) {
+	des, err := bc.fs.ReadDir(hashedCertsDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for _, de := range des {
+		if hashedCertRegex.MatchString(de.Name()) {
+			names[de.Name()] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	if bc.ic.Certificates.Replace {
+		for name := range names {
+			if err := bc.fs.Remove(filepath.Join(hashedCertsDir, name)); err != nil {
+				return nil, err
+			}
+		}
+		clear(names)
+	}
+	return &hashedCertDir{names: names}, nil
+}
+
+// link links the certificate at certPath by the hash of its subject, with
+// the first suffix that is not taken, as c_rehash does.
+func (d *hashedCertDir) link(fsys apkfs.FullFS, cert *parsedCertificate, certPath string) error {
+	hash, err := subjectHash(cert.structured)
+	if err != nil {
+		return err
+	}
+	for n := 0; ; n++ {
+		name := fmt.Sprintf("%s.%d", hash, n)
+		if d.names[name] {
+			continue
+		}
+		d.names[name] = true
+		return fsys.Symlink(filepath.Join("/", certPath), filepath.Join(hashedCertsDir, name))
+	}
+}
+
+// The ASN.1 string types that encoding/asn1 has no constants for.
+const (
+	tagVisibleString   = 26
+	tagUniversalString = 28
+)
+
+// canonicalAttribute is an attribute of a distinguished name, of any type.
+type canonicalAttribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// canonicalRDNSET is a relative distinguished name, encoded as a SET OF.
+type canonicalRDNSET []canonicalAttribute
+
+// subjectHash returns the hash of the subject of cert, as OpenSSL names the
+// links to it in hashed certificate directories: the first four bytes, as a
+// little-endian number, of the SHA-1 of its canonical encoding.
+func subjectHash(cert *x509.Certificate) (string, error) {
+	var rdns []canonicalRDNSET
+	if rest, err := asn1.Unmarshal(cert.RawSubject, &rdns); err != nil {
+		return "", fmt.Errorf("parsing subject: %w", err)
+	} else if len(rest) != 0 {
+		return "", fmt.Errorf("parsing subject: trailing data")
+	}
+
+	// The canonical encoding is that of the relative distinguished names,
+	// without the SEQUENCE around them, with their string values as
+	// UTF8String, trimmed, with runs of spaces collapsed and in lower case.
+	h := sha1.New() //nolint:gosec // This is the hash OpenSSL uses.
+	for _, rdn := range rdns {
+		for i, attr := range rdn {
+			if value, ok := canonicalString(attr.Value); ok {
+				rdn[i].Value = asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(value)}
+			}
+		}
+		der, err := asn1.Marshal(rdn)
+		if err != nil {
+			return "", fmt.Errorf("encoding subject: %w", err)
+		}
+		h.Write(der)
+	}
+	sum := h.Sum(nil)
+	return fmt.Sprintf("%08x", binary.LittleEndian.Uint32(sum[:4])), nil
+}
+
+// canonicalString returns the canonical form of the string value v, or
+// false if v is not a string.
+func canonicalString(v asn1.RawValue) (string, bool) {
+	if v.Class != asn1.ClassUniversal {
+		return "", false
+	}
+
+	var s string
+	switch v.Tag {
+	case asn1.TagUTF8String, asn1.TagPrintableString, asn1.TagIA5String, tagVisibleString:
+		s = string(v.Bytes)
+	case asn1.TagT61String:
+		// Latin-1, as OpenSSL reads it.
+		runes := make([]rune, len(v.Bytes))
+		for i, b := range v.Bytes {
+			runes[i] = rune(b)
+		}
+		s = string(runes)
+	case asn1.TagBMPString:
+		if len(v.Bytes)%2 != 0 {
+			return "", false
+		}
+		units := make([]uint16, len(v.Bytes)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(v.Bytes[2*i:])
+		}
+		s = string(utf16.Decode(units))
+	case tagUniversalString:
+		if len(v.Bytes)%4 != 0 {
+			return "", false
+		}
+		runes := make([]rune, len(v.Bytes)/4)
+		for i := range runes {
+			runes[i] = rune(binary.BigEndian.Uint32(v.Bytes[4*i:]))
+		}
+		s = string(runes)
+	default:
+		return "", false
+	}
+
+	// Only ASCII spaces are collapsed, and only ASCII letters lowered.
+	isSpace := func(r rune) bool { return r == ' ' || (r >= '\t' && r <= '\r') }
+	var b strings.Builder
+	space := false
+	for _, r := range strings.TrimFunc(s, isSpace) {
+		switch {
+		case isSpace(r):
+			space = true
+			continue
+		case space:
+			b.WriteByte(' ')
+			space = false
+		}
+		if r >= 'A' && r <= 'Z' {
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), true
+}
+
 // loadJavaTruststores loads all existing Java truststores from the configured paths.
 // It is ok if no truststores exist; in that case, an empty slice is returned.
 func (bc *Context) loadJavaTruststores() ([]loadedTruststore, error) {
@@ -86,6 +86,24 @@ vbtH7QiVzeKCOTQPINyRql6P
 -----END CERTIFICATE-----
 `
 	testCertPEM2Fingerprint = "9b2a339fe6a3e85585c4cd75536cb8c1cf7cd603b9a64bec2521858ae48da85d"
+
+	// Self-signed, with a subject of upper case and runs of spaces:
+	// /C=DE/O=  Acme   Widgets  GmbH /OU=RoottCA/CN=ACME Root   CA 2025
+	testCertPEMSpaces = `-----BEGIN CERTIFICATE-----
+MIICEjCCAbmgAwIBAgIULoXKuQF4vI2S+HGThYPTUkXumc4wCgYIKoZIzj0EAwIw
+XzELMAkGA1UEBhMCREUxIDAeBgNVBAoMFyAgQWNtZSAgIFdpZGdldHMgIEdtYkgg
+MRAwDgYDVQQLDAdSb290dENBMRwwGgYDVQQDDBNBQ01FIFJvb3QgICBDQSAyMDI1
+MB4XDTI2MTAxNTAwNDQyNVoXDTI2MTAxNjAwNDQyNVowXzELMAkGA1UEBhMCREUx
+IDAeBgNVBAoMFyAgQWNtZSAgIFdpZGdldHMgIEdtYkggMRAwDgYDVQQLDAdSb290
+dENBMRwwGgYDVQQDDBNBQ01FIFJvb3QgICBDQSAyMDI1MFkwEwYHKoZIzj0CAQYI
+KoZIzj0DAQcDQgAEZY2q3w421PZFnrTPW1yHktDPwa4tvuDFFkayNVePfjXMjXpP
+irS1Qg3pcpU50IeUiWgJBTnM3cZnC2jmwf0ZHKNTMFEwHQYDVR0OBBYEFDJe5gvZ
+OHWY7M6oDh1CUxVqNkNxMB8GA1UdIwQYMBaAFDJe5gvZOHWY7M6oDh1CUxVqNkNx
+MA8GA1UdEwEB/wQFMAMBAf8wCgYIKoZIzj0EAwIDRwAwRAIgeokd7M55QqtWIgI3
+oxtExSIkLd4vHUdSQfkfYpB2TpECIDBpZzPpa7076anHIlv/H2PcaI3pl7KiKRX5
+gfYzo/kV
+-----END CERTIFICATE-----
+`
 )
 
 func TestParseCertificates(t *testing.T) {
@@ -141,6 +159,27 @@ func TestParseCertificates(t *testing.T) {
 	}
 }
 
+func TestSubjectHash(t *testing.T) {
+	// The hashes are those of openssl x509 -subject_hash.
+	for pemData, want := range map[string]string{
+		testCertPEM:       "63bc25b1",
+		testCertPEM2:      "b5023534",
+		testCertPEMSpaces: "2f7a694b",
+	} {
+		cert, err := parseCertificates(pemData)
+		if err != nil {
+			t.Fatalf("failed to parse certificate: %v", err)
+		}
+		got, err := subjectHash(cert.structured)
+		if err != nil {
+			t.Fatalf("subjectHash() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("subjectHash() = %s, want %s", got, want)
+		}
+	}
+}
+
 func TestInstallCertificates(t *testing.T) {
 	epoch := time.Unix(1337, 0)
 	t.Setenv("SOURCE_DATE_EPOCH", fmt.Sprintf("%d", epoch.Unix()))
@@ -174,6 +213,7 @@ func TestInstallCertificates(t *testing.T) {
 		name          string
 		cfg           *types.ImageCertificates
 		existingFiles map[string][]byte
+		existingLinks map[string]string
 		wantFiles     map[string][]byte
 		wantErr       bool
 	}{{
@@ -299,6 +339,49 @@ func TestInstallCertificates(t *testing.T) {
 				"test-cert-2-" + testCertPEM2Fingerprint: testCertPEM2,
 			}),
 		},
+	}, {
+		name: "certificate with hashed certificate directory",
+		cfg: &types.ImageCertificates{
+			Additional: []types.AdditionalCertificateEntry{
+				{Name: "test-cert", Content: testCertPEM},
+			},
+		},
+		existingFiles: map[string][]byte{
+			caBundlePaths[0]: []byte("# Existing CA Bundle\n"),
+		},
+		existingLinks: map[string]string{
+			// Another certificate of the same subject hash.
+			filepath.Join(hashedCertsDir, "63bc25b1.0"): "/" + caBundlePaths[0],
+		},
+		wantFiles: map[string][]byte{
+			caBundlePaths[0]: []byte("# Existing CA Bundle\n" + testCertPEM + "\n"),
+			filepath.Join(hashedCertsDir, "63bc25b1.0"):                                        []byte("# Existing CA Bundle\n" + testCertPEM + "\n"),
+			filepath.Join(hashedCertsDir, "63bc25b1.1"):                                        []byte(testCertPEM),
+			filepath.Join(caCertsDir, fmt.Sprintf("test-cert-%s.crt", testCertPEMFingerprint)): []byte(testCertPEM),
+		},
+	}, {
+		name: "replace certificates",
+		cfg: &types.ImageCertificates{
+			Additional: []types.AdditionalCertificateEntry{
+				{Name: "test-cert", Content: testCertPEM},
+			},
+			Replace: true,
+		},
+		existingFiles: map[string][]byte{
+			caBundlePaths[0]: []byte("# Existing CA Bundle\n" + testCertPEM2 + "\n"),
+			filepath.Join(hashedCertsDir, "b5023534.0"): []byte(testCertPEM2),
+			javaTruststorePaths[0]: createTruststore(map[string]string{
+				"existing": testCertPEM2,
+			}),
+		},
+		wantFiles: map[string][]byte{
+			caBundlePaths[0]: []byte(testCertPEM + "\n"),
+			filepath.Join(hashedCertsDir, "63bc25b1.0"):                                        []byte(testCertPEM),
+			filepath.Join(caCertsDir, fmt.Sprintf("test-cert-%s.crt", testCertPEMFingerprint)): []byte(testCertPEM),
+			javaTruststorePaths[0]: createTruststore(map[string]string{
+				"test-cert-" + testCertPEMFingerprint: testCertPEM,
+			}),
+		},
 	}}
 
 	for _, tt := range tests {
@@ -322,6 +405,11 @@ func TestInstallCertificates(t *testing.T) {
 					t.Fatalf("failed to write existing file %s: %v", path, err)
 				}
 			}
+			for path, target := range tt.existingLinks {
+				if err := fsys.Symlink(target, path); err != nil {
+					t.Fatalf("failed to link existing file %s: %v", path, err)
+				}
+			}
 
 			err := bc.installCertificates(context.Background())
 			if (err != nil) != tt.wantErr {
@@ -371,6 +459,12 @@ func TestInstallCertificates(t *testing.T) {
 				}
 				return nil
 			})
+
+			for path := range tt.wantFiles {
+				if _, err := fsys.Lstat(path); err != nil {
+					t.Errorf("expected file %s: %v", path, err)
+				}
+			}
 		})
 	}
 }
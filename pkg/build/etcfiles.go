@@ -0,0 +1,330 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// defaultNSSwitch are the sources of the databases of a generated
+// /etc/nsswitch.conf: local files, and DNS for hosts.
+var defaultNSSwitch = map[string]string{
+	"passwd":    "files",
+	"group":     "files",
+	"shadow":    "files",
+	"hosts":     "files dns",
+	"networks":  "files",
+	"protocols": "files",
+	"services":  "files",
+	"ethers":    "files",
+	"rpc":       "files",
+}
+
+// osReleaseFields are the fields of os-release written first, in this order,
+// when the existing file does not have them.
+var osReleaseFields = []string{"NAME", "ID", "VERSION_ID", "PRETTY_NAME"}
+
+// osReleaseBareValue matches the os-release values that need no quotes.
+var osReleaseBareValue = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// writeEtcFiles writes the configuration files of /etc that the image
+// configuration asks apko to generate, overwriting those of the packages, and
+// removes those it asks to omit.
+func (bc *Context) writeEtcFiles(ctx context.Context) error {
+	_, span := otel.Tracer("apko").Start(ctx, "writeEtcFiles")
+	defer span.End()
+
+	if err := writeMachineID(bc.fs, bc.ic.MachineID, bc.ic.Modes.Directory()); err != nil {
+		return err
+	}
+	if err := writeTimezone(bc.fs, bc.ic.Timezone, bc.ic.Modes.Directory()); err != nil {
+		return err
+	}
+
+	files := map[string]string{}
+	var omitted []string
+	if h := bc.ic.Hostname; h != nil && h.Omit {
+		omitted = append(omitted, "etc/hostname")
+	} else if h != nil {
+		files["etc/hostname"] = h.Name + "\n"
+	}
+	if h := bc.ic.Hosts; h != nil && h.Omit {
+		omitted = append(omitted, "etc/hosts")
+	} else if h != nil {
+		files["etc/hosts"] = hostsFile(h, bc.ic.Hostname)
+	}
+	if rc := bc.ic.ResolvConf; rc != nil && rc.Omit {
+		omitted = append(omitted, "etc/resolv.conf")
+	} else if rc != nil {
+		files["etc/resolv.conf"] = resolvConf(rc)
+	}
+	if bc.ic.NSSwitch != nil {
+		files["etc/nsswitch.conf"] = nsswitchConf(bc.ic.NSSwitch)
+	}
+	if bc.ic.LoginDefs != nil {
+		files["etc/login.defs"] = loginDefs(&bc.ic)
+	}
+	if bc.ic.OSRelease != nil {
+		existing, err := bc.fs.ReadFile("etc/os-release")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("reading /etc/os-release: %w", err)
+		}
+		files["etc/os-release"] = osRelease(string(existing), bc.ic.OSRelease)
+	}
+	for _, name := range omitted {
+		if err := bc.fs.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("removing /%s: %w", name, err)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	if err := bc.fs.MkdirAll("etc", bc.ic.Modes.Directory()); err != nil {
+		return fmt.Errorf("creating /etc: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := writeEtcFile(bc.fs, name, files[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEtcFile replaces the file at name with content, readable by all.
+func writeEtcFile(fsys apkfs.FullFS, name, content string) error {
+	// A package may have installed a symlink there, which must not be
+	// followed.
+	if err := fsys.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing /%s: %w", name, err)
+	}
+	if err := fsys.WriteFile(name, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("writing /%s: %w", name, err)
+	}
+	return nil
+}
+
+// nsswitchConf returns the content of the /etc/nsswitch.conf of ns.
+func nsswitchConf(ns *types.ImageNSSwitch) string {
+	databases := maps.Clone(defaultNSSwitch)
+	maps.Copy(databases, ns.Databases)
+
+	var b strings.Builder
+	b.WriteString("# Generated by apko\n")
+	for _, db := range slices.Sorted(maps.Keys(databases)) {
+		fmt.Fprintf(&b, "%s: %s\n", db, databases[db])
+	}
+	return b.String()
+}
+
+// hostsFile returns the content of the /etc/hosts of h: the entries of
+// localhost, that of the host name, if set, and the entries of h.
+func hostsFile(h *types.ImageHosts, hostname *types.ImageHostname) string {
+	var b strings.Builder
+	b.WriteString("# Generated by apko\n")
+	b.WriteString("127.0.0.1\tlocalhost localhost.localdomain\n")
+	b.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	if hostname != nil && hostname.Name != "" {
+		fmt.Fprintf(&b, "127.0.1.1\t%s\n", hostname.Name)
+	}
+	for _, entry := range h.Entries {
+		fmt.Fprintf(&b, "%s\t%s\n", entry.Address, strings.Join(entry.Names, " "))
+	}
+	return b.String()
+}
+
+// resolvConf returns the content of the /etc/resolv.conf of rc.
+func resolvConf(rc *types.ImageResolvConf) string {
+	var b strings.Builder
+	b.WriteString("# Generated by apko\n")
+	for _, ns := range rc.Nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	if len(rc.Search) != 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(rc.Search, " "))
+	}
+	if len(rc.Options) != 0 {
+		fmt.Fprintf(&b, "options %s\n", strings.Join(rc.Options, " "))
+	}
+	return b.String()
+}
+
+// loginDefs returns the content of the /etc/login.defs of ic. The defaults
+// follow the modes of the image, and its accounts ID range for the system
+// accounts.
+func loginDefs(ic *types.ImageConfiguration) string {
+	settings := map[string]string{
+		"PASS_MAX_DAYS":   "99999",
+		"PASS_MIN_DAYS":   "0",
+		"PASS_WARN_AGE":   "7",
+		"UID_MIN":         "1000",
+		"UID_MAX":         "60000",
+		"SYS_UID_MIN":     "100",
+		"SYS_UID_MAX":     "999",
+		"GID_MIN":         "1000",
+		"GID_MAX":         "60000",
+		"SYS_GID_MIN":     "100",
+		"SYS_GID_MAX":     "999",
+		"ENCRYPT_METHOD":  "SHA512",
+		"USERGROUPS_ENAB": "yes",
+		"UMASK":           fmt.Sprintf("%03o", 0o777&^ic.Modes.Directory()),
+		"HOME_MODE":       fmt.Sprintf("%04o", ic.Modes.HomeDir()),
+	}
+	if r := ic.Accounts.IDRange; r != nil {
+		for _, kind := range []string{"UID", "GID"} {
+			settings["SYS_"+kind+"_MIN"] = strconv.FormatUint(uint64(r.Min), 10)
+			settings["SYS_"+kind+"_MAX"] = strconv.FormatUint(uint64(r.Max), 10)
+		}
+	}
+	maps.Copy(settings, ic.LoginDefs.Settings)
+
+	var b strings.Builder
+	b.WriteString("# Generated by apko\n")
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		fmt.Fprintf(&b, "%s\t%s\n", name, settings[name])
+	}
+	return b.String()
+}
+
+// osRelease returns the content of the /etc/os-release of o, setting its
+// fields over those of the existing content, in place, and appending the
+// others.
+func osRelease(existing string, o *types.ImageOSRelease) string {
+	fields := o.AllFields()
+
+	var b strings.Builder
+	for line := range strings.Lines(existing) {
+		name, _, ok := strings.Cut(strings.TrimSuffix(line, "\n"), "=")
+		if value, set := fields[name]; ok && set {
+			writeOSReleaseField(&b, name, value)
+			delete(fields, name)
+			continue
+		}
+		b.WriteString(line)
+		if !strings.HasSuffix(line, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	for _, name := range osReleaseFields {
+		if value, set := fields[name]; set {
+			writeOSReleaseField(&b, name, value)
+			delete(fields, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(fields)) {
+		writeOSReleaseField(&b, name, fields[name])
+	}
+	return b.String()
+}
+
+// writeOSReleaseField writes the line of an os-release field, quoting and
+// escaping its value as a shell would.
+func writeOSReleaseField(b *strings.Builder, name, value string) {
+	if !osReleaseBareValue.MatchString(value) {
+		value = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(value) + `"`
+	}
+	fmt.Fprintf(b, "%s=%s\n", name, value)
+}
+
+// writeMachineID provides the /etc/machine-id of the image as machineID
+// asks, removing the one that a package may have generated at build time,
+// which every container of the image would otherwise share.
+func writeMachineID(fsys apkfs.FullFS, machineID string, dirMode fs.FileMode) error {
+	const dbusMachineID = "var/lib/dbus/machine-id"
+
+	switch machineID {
+	case "":
+		return nil
+	case types.MachineIDOmit:
+		for _, name := range []string{"etc/machine-id", dbusMachineID} {
+			if err := fsys.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("removing /%s: %w", name, err)
+			}
+		}
+		return nil
+	}
+
+	if err := fsys.MkdirAll("etc", dirMode); err != nil {
+		return fmt.Errorf("creating /etc: %w", err)
+	}
+	if err := writeEtcFile(fsys, "etc/machine-id", ""); err != nil {
+		return err
+	}
+	// systemd creates it read-only.
+	if err := fsys.Chmod("etc/machine-id", 0o444); err != nil {
+		return fmt.Errorf("chmod /etc/machine-id: %w", err)
+	}
+	if machineID != types.MachineIDSymlink {
+		return nil
+	}
+
+	if err := fsys.MkdirAll(path.Dir(dbusMachineID), dirMode); err != nil {
+		return fmt.Errorf("creating /%s: %w", path.Dir(dbusMachineID), err)
+	}
+	if err := fsys.Remove(dbusMachineID); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing /%s: %w", dbusMachineID, err)
+	}
+	if err := fsys.Symlink("/etc/machine-id", dbusMachineID); err != nil {
+		return fmt.Errorf("linking /%s: %w", dbusMachineID, err)
+	}
+	return nil
+}
+
+// writeTimezone links /etc/localtime to the zoneinfo file of tz, and names
+// it in /etc/timezone. UTC needs no zoneinfo, as it is the default of the C
+// libraries without /etc/localtime.
+func writeTimezone(fsys apkfs.FullFS, tz string, dirMode fs.FileMode) error {
+	if tz == "" {
+		return nil
+	}
+
+	zoneinfo := path.Join("usr/share/zoneinfo", tz)
+	_, err := fsys.Stat(zoneinfo)
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && types.IsUTC(tz):
+		zoneinfo = ""
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("timezone %q has no zoneinfo file /%s, which tzdata installs", tz, zoneinfo)
+	case err != nil:
+		return fmt.Errorf("checking /%s: %w", zoneinfo, err)
+	}
+
+	if err := fsys.MkdirAll("etc", dirMode); err != nil {
+		return fmt.Errorf("creating /etc: %w", err)
+	}
+	if err := fsys.Remove("etc/localtime"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing /etc/localtime: %w", err)
+	}
+	if zoneinfo != "" {
+		if err := fsys.Symlink("/"+zoneinfo, "etc/localtime"); err != nil {
+			return fmt.Errorf("linking /etc/localtime: %w", err)
+		}
+	}
+	return writeEtcFile(fsys, "etc/timezone", tz+"\n")
+}
@@ -0,0 +1,214 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestNSSwitchConf(t *testing.T) {
+	conf := nsswitchConf(&types.ImageNSSwitch{Databases: map[string]string{
+		"hosts":    "files myhostname dns",
+		"netmasks": "files",
+	}})
+	require.Equal(t, `# Generated by apko
+ethers: files
+group: files
+hosts: files myhostname dns
+netmasks: files
+networks: files
+passwd: files
+protocols: files
+rpc: files
+services: files
+shadow: files
+`, conf)
+}
+
+func TestLoginDefs(t *testing.T) {
+	umask := uint32(0o027)
+	defs := loginDefs(&types.ImageConfiguration{
+		Modes:     &types.ImageModes{Umask: &umask},
+		Accounts:  types.ImageAccounts{IDRange: &types.IDRange{Min: 200, Max: 499}},
+		LoginDefs: &types.ImageLoginDefs{Settings: map[string]string{"PASS_MAX_DAYS": "90"}},
+	})
+	require.Equal(t, `# Generated by apko
+ENCRYPT_METHOD	SHA512
+GID_MAX	60000
+GID_MIN	1000
+HOME_MODE	0700
+PASS_MAX_DAYS	90
+PASS_MIN_DAYS	0
+PASS_WARN_AGE	7
+SYS_GID_MAX	499
+SYS_GID_MIN	200
+SYS_UID_MAX	499
+SYS_UID_MIN	200
+UID_MAX	60000
+UID_MIN	1000
+UMASK	027
+USERGROUPS_ENAB	yes
+`, defs)
+}
+
+func TestWriteEtcFile(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("etc", 0o755))
+	require.NoError(t, fsys.Symlink("/usr/share/defaults/nsswitch.conf", "etc/nsswitch.conf"))
+
+	require.NoError(t, writeEtcFile(fsys, "etc/nsswitch.conf", "hosts: files\n"))
+
+	fi, err := fsys.Lstat("etc/nsswitch.conf")
+	require.NoError(t, err)
+	require.True(t, fi.Mode().IsRegular())
+	content, err := fsys.ReadFile("etc/nsswitch.conf")
+	require.NoError(t, err)
+	require.Equal(t, "hosts: files\n", string(content))
+}
+
+func TestOSRelease(t *testing.T) {
+	existing := `NAME="Wolfi"
+ID=wolfi
+VERSION_ID=20230201
+PRETTY_NAME="Wolfi"
+HOME_URL="https://wolfi.dev"`
+
+	conf := osRelease(existing, &types.ImageOSRelease{
+		Name:       "Acme OS",
+		ID:         "acme",
+		PrettyName: `Acme "Road Runner" OS`,
+		Fields:     map[string]string{"ID_LIKE": "wolfi", "VARIANT_ID": "fips"},
+	})
+	require.Equal(t, `NAME="Acme OS"
+ID=acme
+VERSION_ID=20230201
+PRETTY_NAME="Acme \"Road Runner\" OS"
+HOME_URL="https://wolfi.dev"
+ID_LIKE=wolfi
+VARIANT_ID=fips
+`, conf)
+
+	conf = osRelease("", &types.ImageOSRelease{PrettyName: "Acme $HOME", ID: "acme", VersionID: "1.0"})
+	require.Equal(t, `ID=acme
+VERSION_ID=1.0
+PRETTY_NAME="Acme \$HOME"
+`, conf)
+}
+
+func TestWriteMachineID(t *testing.T) {
+	for _, tt := range []struct {
+		machineID string
+		wantEtc   bool
+		wantDBus  string
+		wantKept  bool
+	}{
+		{machineID: "", wantEtc: true, wantKept: true},
+		{machineID: types.MachineIDEmpty, wantEtc: true},
+		{machineID: types.MachineIDSymlink, wantEtc: true, wantDBus: "/etc/machine-id"},
+		{machineID: types.MachineIDOmit},
+	} {
+		t.Run(tt.machineID, func(t *testing.T) {
+			fsys := apkfs.NewMemFS()
+			require.NoError(t, fsys.MkdirAll("etc", 0o755))
+			require.NoError(t, fsys.MkdirAll("var/lib/dbus", 0o755))
+			// A machine ID generated when the packages were installed.
+			require.NoError(t, fsys.WriteFile("etc/machine-id", []byte("0123456789abcdef0123456789abcdef\n"), 0o444))
+
+			require.NoError(t, writeMachineID(fsys, tt.machineID, 0o755))
+
+			content, err := fsys.ReadFile("etc/machine-id")
+			if !tt.wantEtc {
+				require.ErrorIs(t, err, fs.ErrNotExist)
+			} else if tt.wantKept {
+				require.NoError(t, err)
+				require.NotEmpty(t, content)
+			} else {
+				require.NoError(t, err)
+				require.Empty(t, content)
+				fi, err := fsys.Stat("etc/machine-id")
+				require.NoError(t, err)
+				require.Equal(t, fs.FileMode(0o444), fi.Mode().Perm())
+			}
+
+			target, err := fsys.Readlink("var/lib/dbus/machine-id")
+			if tt.wantDBus == "" {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.wantDBus, target)
+			}
+		})
+	}
+}
+
+func TestWriteTimezone(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("usr/share/zoneinfo/Europe", 0o755))
+	require.NoError(t, fsys.WriteFile("usr/share/zoneinfo/Europe/Berlin", []byte("TZif"), 0o644))
+	require.NoError(t, fsys.MkdirAll("etc", 0o755))
+	require.NoError(t, fsys.Symlink("/usr/share/zoneinfo/UTC", "etc/localtime"))
+
+	require.NoError(t, writeTimezone(fsys, "Europe/Berlin", 0o755))
+	target, err := fsys.Readlink("etc/localtime")
+	require.NoError(t, err)
+	require.Equal(t, "/usr/share/zoneinfo/Europe/Berlin", target)
+	content, err := fsys.ReadFile("etc/timezone")
+	require.NoError(t, err)
+	require.Equal(t, "Europe/Berlin\n", string(content))
+
+	// UTC is the default without zoneinfo.
+	require.NoError(t, writeTimezone(fsys, "UTC", 0o755))
+	_, err = fsys.Lstat("etc/localtime")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	content, err = fsys.ReadFile("etc/timezone")
+	require.NoError(t, err)
+	require.Equal(t, "UTC\n", string(content))
+
+	require.EqualError(t, writeTimezone(fsys, "America/New_York", 0o755), `timezone "America/New_York" has no zoneinfo file /usr/share/zoneinfo/America/New_York, which tzdata installs`)
+}
+
+func TestHostsFile(t *testing.T) {
+	hosts := hostsFile(&types.ImageHosts{Entries: []types.HostsEntry{
+		{Address: "10.0.0.5", Names: []string{"db", "db.internal"}},
+		{Address: "fd00::5", Names: []string{"cache"}},
+	}}, &types.ImageHostname{Name: "builder"})
+	require.Equal(t, `# Generated by apko
+127.0.0.1	localhost localhost.localdomain
+::1	localhost ip6-localhost ip6-loopback
+127.0.1.1	builder
+10.0.0.5	db db.internal
+fd00::5	cache
+`, hosts)
+}
+
+func TestResolvConf(t *testing.T) {
+	conf := resolvConf(&types.ImageResolvConf{
+		Nameservers: []string{"10.0.0.53", "2001:db8::53"},
+		Search:      []string{"corp.example.com", "example.com"},
+		Options:     []string{"ndots:2", "edns0"},
+	})
+	require.Equal(t, `# Generated by apko
+nameserver 10.0.0.53
+nameserver 2001:db8::53
+search corp.example.com example.com
+options ndots:2 edns0
+`, conf)
+}
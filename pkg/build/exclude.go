@@ -0,0 +1,79 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// excludePaths removes the paths of the image matching its exclude-paths, and
+// records the paths it removed.
+func (bc *Context) excludePaths(ctx context.Context) error {
+	if len(bc.ic.ExcludePaths) == 0 {
+		return nil
+	}
+	ctx, span := otel.Tracer("apko").Start(ctx, "excludePaths")
+	defer span.End()
+
+	excluded, err := excludePaths(bc.fs, bc.ic.ExcludePaths)
+	if err != nil {
+		return fmt.Errorf("excluding paths: %w", err)
+	}
+	if len(excluded) != 0 {
+		clog.FromContext(ctx).Infof("Excluded %d paths from the image", len(excluded))
+	}
+	bc.excludedPaths = excluded
+	return nil
+}
+
+// excludePaths removes the paths of fsys matching one of the globs of
+// patterns, with the contents of the directories among them, and returns the
+// removed paths, in lexical order. The contents of a removed directory are
+// not listed.
+func excludePaths(fsys apkfs.FullFS, patterns []string) ([]string, error) {
+	var matches []string
+	for _, pattern := range patterns {
+		m, err := globPaths(fsys, pattern)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		matches = append(matches, m...)
+	}
+	slices.Sort(matches)
+
+	var excluded []string
+	for _, p := range slices.Compact(matches) {
+		if len(excluded) != 0 && strings.HasPrefix("/"+p, excluded[len(excluded)-1]+"/") {
+			continue
+		}
+		if err := removeAll(fsys, p); err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, "/"+p)
+	}
+	return excluded, nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestExcludePaths(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("usr/share/zoneinfo/right/Europe", 0o755))
+	require.NoError(t, fsys.MkdirAll("usr/share/zoneinfo/Europe", 0o755))
+	for _, name := range []string{
+		"usr/share/zoneinfo/right/UTC",
+		"usr/share/zoneinfo/right/Europe/Paris",
+		"usr/share/zoneinfo/Europe/Paris",
+		"usr/share/zoneinfo/UTC",
+		"usr/share/zoneinfo/zone.tab",
+	} {
+		require.NoError(t, fsys.WriteFile(name, nil, 0o644))
+	}
+
+	excluded, err := excludePaths(fsys, []string{"/usr/share/zoneinfo/right/**", "/usr/share/zoneinfo/*.tab", "/opt/missing"})
+	require.NoError(t, err)
+	// The contents of the removed directories are not listed.
+	require.Equal(t, []string{"/usr/share/zoneinfo/right", "/usr/share/zoneinfo/zone.tab"}, excluded)
+
+	for name, exists := range map[string]bool{
+		"usr/share/zoneinfo/right":        false,
+		"usr/share/zoneinfo/zone.tab":     false,
+		"usr/share/zoneinfo/UTC":          true,
+		"usr/share/zoneinfo/Europe/Paris": true,
+	} {
+		_, err := fsys.Lstat(name)
+		if exists {
+			require.NoError(t, err, name)
+		} else {
+			require.ErrorIs(t, err, fs.ErrNotExist, name)
+		}
+	}
+}
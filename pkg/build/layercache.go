@@ -0,0 +1,818 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	v1types "github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/sys/unix"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/report"
+)
+
+// layerCacheVersion is mixed into every layer cache key so that changes to
+// how apko lays out the filesystem invalidate previously cached layers.
+const layerCacheVersion = "apko-layer-cache-v4"
+
+// installedPath is the apk database of installed packages.
+const installedPath = "usr/lib/apk/db/installed"
+
+// layerCacheEntry is the metadata stored for each cache key. The layer blobs
+// themselves are stored content-addressed by diffID next to it.
+type layerCacheEntry struct {
+	Layers []layerCacheLayer `json:"layers"`
+	// Packages are the resolved packages the layers were built from, used to
+	// report what changed between incremental rebuilds.
+	Packages []string `json:"packages,omitempty"`
+	// SkippedScriptlets are the scriptlets skipped when building the layers,
+	// which are reported again on a cache hit.
+	SkippedScriptlets []string `json:"skippedScriptlets,omitempty"`
+	// StrippedSetuid are the files whose setuid and setgid bits were
+	// cleared when building the layers, which are reported again on a cache
+	// hit.
+	StrippedSetuid []string `json:"strippedSetuid,omitempty"`
+	// ExcludedPaths are the paths removed when building the layers, which
+	// are reported again on a cache hit.
+	ExcludedPaths []string `json:"excludedPaths,omitempty"`
+}
+
+// layerCacheLineage records the most recent cache key used to build a given
+// config file for a given architecture.
+type layerCacheLineage struct {
+	Key string `json:"key"`
+}
+
+type layerCacheLayer struct {
+	DiffID    v1.Hash `json:"diffID"`
+	Digest    v1.Hash `json:"digest"`
+	Size      int64   `json:"size"`
+	MediaType string  `json:"mediaType"`
+	// Packages are the packages whose files are in the layer.
+	Packages []string `json:"packages,omitempty"`
+	// Origins are the origins of those packages.
+	Origins []string `json:"origins,omitempty"`
+	// Created is the newest build date of those packages.
+	Created time.Time `json:"created"`
+	// Fingerprint identifies the contents of a layer of packages, for it to
+	// be reused by builds of other keys with the same packages.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// layerCacheInput is hashed (as JSON) to produce the layer cache key.
+type layerCacheInput struct {
+	Version         string            `json:"version"`
+	Arch            string            `json:"arch"`
+	SourceDateEpoch int64             `json:"sourceDateEpoch"`
+	Config          json.RawMessage   `json:"config"`
+	ExtraRepos      []string          `json:"extraRepos,omitempty"`
+	ExtraPackages   []string          `json:"extraPackages,omitempty"`
+	Keys            map[string]string `json:"keys,omitempty"`
+	Packages        []string          `json:"packages"`
+	Strict          bool              `json:"strict,omitempty"`
+	PackagePolicy   string            `json:"packagePolicy,omitempty"`
+	Scriptlets      string            `json:"scriptlets,omitempty"`
+	NoScripts       []string          `json:"noScripts,omitempty"`
+	Images          map[string]string `json:"images,omitempty"`
+}
+
+func (bc *Context) layerCacheEntryPath(key string) string {
+	return filepath.Join(bc.o.LayerCacheDir, "entries", key+".json")
+}
+
+// layerCacheInstalledPath returns where the apk database of the entry for key
+// is stored when the image omits it from its layers, since it is still needed
+// to generate the SBOM on a cache hit.
+func (bc *Context) layerCacheInstalledPath(key string) string {
+	return filepath.Join(bc.o.LayerCacheDir, "entries", key+".installed")
+}
+
+// layerCacheLineagePath returns where the last cache key for this config file and
+// architecture is recorded, or "" if the config didn't come from a file.
+func (bc *Context) layerCacheLineagePath() string {
+	if bc.o.ImageConfigFile == "" {
+		return ""
+	}
+	cfg, err := filepath.Abs(bc.o.ImageConfigFile)
+	if err != nil {
+		cfg = bc.o.ImageConfigFile
+	}
+	sum := sha256.Sum256([]byte(bc.o.Arch.ToAPK() + "\x00" + cfg))
+	return filepath.Join(bc.o.LayerCacheDir, "lineage", hex.EncodeToString(sum[:])+".json")
+}
+
+func (bc *Context) layerCacheBlobPath(diffid v1.Hash, compressed bool) string {
+	return layerCacheBlobPath(bc.o.LayerCacheDir, diffid, compressed)
+}
+
+func layerCacheBlobPath(dir string, diffid v1.Hash, compressed bool) string {
+	name := diffid.Hex + ".tar"
+	if compressed {
+		name += ".gz"
+	}
+	return filepath.Join(dir, "blobs", diffid.Algorithm, name)
+}
+
+// layerCacheKey computes the cache key for the layers this context would
+// produce. The key covers the resolved package set (names, versions and
+// checksums) along with everything else that ends up in the image filesystem:
+// the full image configuration (which is written to /etc/apko.json), the
+// architecture, the source date epoch, the installed keyring, and the digests
+// of the images that image paths copy from, since their references may be
+// tags that move.
+// The sorted package references that went into the key are returned so they
+// can be recorded alongside the cache entry.
+func (bc *Context) layerCacheKey(ctx context.Context) (string, []string, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "layerCacheKey")
+	defer span.End()
+
+	pkgs, err := bc.resolvedInstallables(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	refs := make([]string, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		refs = append(refs, fmt.Sprintf("%s %s %s", pkg.PackageName(), filepath.Base(pkg.URL()), pkg.ChecksumString()))
+	}
+	slices.Sort(refs)
+
+	cfg, err := json.Marshal(bc.ic)
+	if err != nil {
+		return "", nil, fmt.Errorf("marshaling image configuration: %w", err)
+	}
+
+	keys, err := hashKeyring(bc.fs)
+	if err != nil {
+		return "", nil, fmt.Errorf("hashing keyring: %w", err)
+	}
+
+	policy, err := bc.packagePolicyDigest()
+	if err != nil {
+		return "", nil, err
+	}
+
+	images, err := bc.pathImageDigests(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	in := layerCacheInput{
+		Version:         layerCacheVersion,
+		Arch:            bc.o.Arch.ToAPK(),
+		SourceDateEpoch: bc.o.SourceDateEpoch.Unix(),
+		Config:          cfg,
+		ExtraRepos:      bc.o.ExtraRepos,
+		ExtraPackages:   bc.o.ExtraPackages,
+		Keys:            keys,
+		Packages:        refs,
+		Strict:          bc.o.StrictReproducibility,
+		PackagePolicy:   policy,
+		Scriptlets:      bc.o.Scriptlets,
+		NoScripts:       bc.o.NoScripts,
+		Images:          images,
+	}
+	b, err := json.Marshal(in)
+	if err != nil {
+		return "", nil, fmt.Errorf("marshaling layer cache input: %w", err)
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), refs, nil
+}
+
+// buildLayersCached is BuildLayers with the layer cache enabled. On a cache
+// miss, the image is installed again, but the layers of the groups of packages
+// that are cached with the same fingerprint are used instead of being tarred,
+// and the layers whose diffID is already cached are not compressed.
+func (bc *Context) buildLayersCached(ctx context.Context) ([]v1.Layer, error) {
+	log := clog.FromContext(ctx)
+
+	key, refs, err := bc.layerCacheKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("computing layer cache key: %w", err)
+	}
+
+	if cached, err := bc.loadCachedLayers(ctx, key); err != nil {
+		log.Warnf("ignoring unusable layer cache entry %s: %v", key, err)
+	} else if cached != nil {
+		log.Infof("using cached layers for %s (key %s)", bc.Arch().ToAPK(), key)
+		// Advisory feeds change independently of the cache key, so check the
+		// packages again.
+		if err := bc.checkResolvedPackagePolicy(ctx); err != nil {
+			return nil, err
+		}
+		report.FromContext(ctx).CacheHit(report.CacheLayers)
+		// Record the use of the entry for PruneLayerCache.
+		now := time.Now()
+		_ = os.Chtimes(bc.layerCacheEntryPath(key), now, now)
+		if err := bc.restoreCachedLayers(ctx, key, cached); err != nil {
+			return nil, err
+		}
+		if err := bc.storeLayerCacheLineage(key); err != nil {
+			log.Warnf("failed to record layer cache lineage: %v", err)
+		}
+		layers := make([]v1.Layer, 0, len(cached))
+		for _, l := range cached {
+			layers = append(layers, l)
+		}
+		return layers, nil
+	}
+
+	report.FromContext(ctx).CacheMiss(report.CacheLayers)
+	bc.logPackageDelta(ctx, refs)
+
+	layers, err := bc.buildLayersUncached(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bc.storeCachedLayers(ctx, key, refs, layers); err != nil {
+		log.Warnf("failed to store layers in cache: %v", err)
+	}
+
+	return layers, nil
+}
+
+// resolvedInstallables returns the packages that would be installed, either
+// from the lockfile or by resolving the world, without installing anything.
+// The build installs the same packages.
+func (bc *Context) resolvedInstallables(ctx context.Context) ([]apk.InstallablePackage, error) {
+	if bc.o.Lockfile != "" {
+		l, err := bc.loadLockfile(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return installablePackagesForArch(*l, bc.Arch())
+	}
+
+	resolved, _, err := bc.resolveWorld(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make([]apk.InstallablePackage, 0, len(resolved))
+	for _, pkg := range resolved {
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+func hashKeyring(fsys apkfs.FullFS) (map[string]string, error) {
+	keys := map[string]string{}
+	entries, err := fsys.ReadDir("etc/apk/keys")
+	if errors.Is(err, fs.ErrNotExist) {
+		return keys, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		b, err := fsys.ReadFile(filepath.Join("etc/apk/keys", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		keys[e.Name()] = hex.EncodeToString(sum[:])
+	}
+	return keys, nil
+}
+
+// loadCachedLayers returns the cached layers for key, or nil if there is no
+// usable cache entry.
+func (bc *Context) loadCachedLayers(ctx context.Context, key string) ([]*layer, error) {
+	_, span := otel.Tracer("apko").Start(ctx, "loadCachedLayers")
+	defer span.End()
+
+	b, err := os.ReadFile(bc.layerCacheEntryPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var entry layerCacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, fmt.Errorf("parsing layer cache entry %s: %w", key, err)
+	}
+	if bc.ic.Contents.OmitAPKDatabase {
+		if _, err := os.Stat(bc.layerCacheInstalledPath(key)); errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	layers := make([]*layer, 0, len(entry.Layers))
+	for _, cl := range entry.Layers {
+		l, err := bc.cachedLayer(cl)
+		if err != nil {
+			return nil, err
+		} else if l == nil {
+			// A blob was pruned out from under us, treat it as a miss.
+			return nil, nil
+		}
+		layers = append(layers, l)
+	}
+
+	bc.skippedScriptlets = entry.SkippedScriptlets
+	bc.strippedSetuid = entry.StrippedSetuid
+	bc.excludedPaths = entry.ExcludedPaths
+	return layers, nil
+}
+
+// cachedLayer returns the layer of the cached blobs of cl, or nil if they are
+// missing.
+func (bc *Context) cachedLayer(cl layerCacheLayer) (*layer, error) {
+	uncompressed := bc.layerCacheBlobPath(cl.DiffID, false)
+	compressed := bc.layerCacheBlobPath(cl.DiffID, true)
+	for _, p := range []string{uncompressed, compressed} {
+		if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	diffid, digest := cl.DiffID, cl.Digest
+	return &layer{
+		uncompressed: uncompressed,
+		compressed:   compressed,
+		diffid:       &diffid,
+		desc: &v1.Descriptor{
+			MediaType: v1types.MediaType(cl.MediaType),
+			Digest:    digest,
+			Size:      cl.Size,
+		},
+		packages:    cl.Packages,
+		origins:     cl.Origins,
+		created:     cl.Created,
+		fingerprint: cl.Fingerprint,
+	}, nil
+}
+
+// cachedPackageLayers returns the cached layers of packages by fingerprint,
+// from the entries of all keys, for the groups of packages that did not change
+// to be reused on a cache miss. It returns nil without the layer cache.
+func (bc *Context) cachedPackageLayers() (map[string]*layer, error) {
+	if bc.o.LayerCacheDir == "" {
+		return nil, nil
+	}
+	des, err := os.ReadDir(filepath.Join(bc.o.LayerCacheDir, "entries"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	layers := map[string]*layer{}
+	for _, de := range des {
+		if !strings.HasSuffix(de.Name(), ".json") {
+			continue
+		}
+		// Unreadable entries are not used by builds either.
+		var entry layerCacheEntry
+		b, err := os.ReadFile(filepath.Join(bc.o.LayerCacheDir, "entries", de.Name()))
+		if err != nil || json.Unmarshal(b, &entry) != nil {
+			continue
+		}
+		for _, cl := range entry.Layers {
+			if cl.Fingerprint == "" || len(cl.Packages) == 0 || layers[cl.Fingerprint] != nil {
+				continue
+			}
+			l, err := bc.cachedLayer(cl)
+			if err != nil {
+				return nil, err
+			} else if l != nil {
+				// The compressed blob was recorded by an entry of
+				// another key, so storeCachedLayers checks it before
+				// it is used.
+				l.compressed = ""
+				layers[cl.Fingerprint] = l
+			}
+		}
+	}
+	return layers, nil
+}
+
+// restoreCachedLayers unpacks cached layers into bc.fs, so anything that
+// inspects the built filesystem afterwards (e.g. SBOM generation) still works.
+// The apk database the layers omit, if any, is restored from the entry for key.
+func (bc *Context) restoreCachedLayers(ctx context.Context, key string, layers []*layer) error {
+	ctx, span := otel.Tracer("apko").Start(ctx, "restoreCachedLayers")
+	defer span.End()
+
+	for _, l := range layers {
+		if err := restoreLayer(ctx, bc.fs, l); err != nil {
+			return fmt.Errorf("restoring cached layer %s: %w", l.diffid, err)
+		}
+	}
+	if !bc.ic.Contents.OmitAPKDatabase {
+		return nil
+	}
+	b, err := os.ReadFile(bc.layerCacheInstalledPath(key))
+	if err != nil {
+		return fmt.Errorf("restoring cached apk database: %w", err)
+	}
+	if err := bc.fs.MkdirAll(filepath.Dir(installedPath), 0o755); err != nil {
+		return fmt.Errorf("restoring cached apk database: %w", err)
+	}
+	// #nosec G306 -- the apk database is publicly readable
+	if err := bc.fs.WriteFile(installedPath, b, 0o644); err != nil {
+		return fmt.Errorf("restoring cached apk database: %w", err)
+	}
+	return nil
+}
+
+// storeCachedLayers compresses (if necessary) and saves layers under key.
+// Layers whose diffID matches a blob cached by a previous build, such as the
+// cached layers of unchanged package groups, reuse the cached compressed blob
+// instead of being compressed again, once its digest is checked.
+func (bc *Context) storeCachedLayers(ctx context.Context, key string, refs []string, layers []v1.Layer) error {
+	_, span := otel.Tracer("apko").Start(ctx, "storeCachedLayers")
+	defer span.End()
+
+	digests, err := bc.cachedBlobDigests()
+	if err != nil {
+		return fmt.Errorf("reading layer cache entries: %w", err)
+	}
+
+	entry := layerCacheEntry{Packages: refs, SkippedScriptlets: bc.skippedScriptlets, StrippedSetuid: bc.strippedSetuid, ExcludedPaths: bc.excludedPaths}
+	for _, v1l := range layers {
+		l, ok := v1l.(*layer)
+		if !ok {
+			return fmt.Errorf("unexpected layer type %T", v1l)
+		}
+
+		if err := bc.reuseCachedBlob(l, digests[*l.diffid]); err != nil {
+			return fmt.Errorf("reusing cached layer blob: %w", err)
+		}
+
+		// Force compression so that a cache hit can skip it entirely.
+		if err := l.compress(); err != nil {
+			return fmt.Errorf("compressing layer: %w", err)
+		}
+
+		if err := copyIntoCache(l.uncompressed, bc.layerCacheBlobPath(*l.diffid, false)); err != nil {
+			return err
+		}
+		if err := copyIntoCache(l.compressed, bc.layerCacheBlobPath(*l.diffid, true)); err != nil {
+			return err
+		}
+
+		entry.Layers = append(entry.Layers, layerCacheLayer{
+			DiffID:      *l.diffid,
+			Digest:      l.desc.Digest,
+			Size:        l.desc.Size,
+			MediaType:   string(l.desc.MediaType),
+			Packages:    l.packages,
+			Origins:     l.origins,
+			Created:     l.created,
+			Fingerprint: l.fingerprint,
+		})
+	}
+
+	// The entry is only used if the omitted apk database is stored too, so
+	// store it first.
+	if bc.ic.Contents.OmitAPKDatabase {
+		installed, err := bc.fs.ReadFile(installedPath)
+		if err != nil {
+			return fmt.Errorf("reading apk database: %w", err)
+		}
+		if err := writeFileAtomic(bc.layerCacheInstalledPath(key), installed); err != nil {
+			return err
+		}
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(bc.layerCacheEntryPath(key), b); err != nil {
+		return err
+	}
+	return bc.storeLayerCacheLineage(key)
+}
+
+// cachedBlobDigests returns the digests of the compressed blobs of the layer
+// cache by diffID, as recorded by the entries that refer to them.
+func (bc *Context) cachedBlobDigests() (map[v1.Hash][]v1.Hash, error) {
+	des, err := os.ReadDir(filepath.Join(bc.o.LayerCacheDir, "entries"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	digests := map[v1.Hash][]v1.Hash{}
+	for _, de := range des {
+		if !strings.HasSuffix(de.Name(), ".json") {
+			continue
+		}
+		// Unreadable entries are not used by builds either.
+		var entry layerCacheEntry
+		b, err := os.ReadFile(filepath.Join(bc.o.LayerCacheDir, "entries", de.Name()))
+		if err != nil || json.Unmarshal(b, &entry) != nil {
+			continue
+		}
+		for _, l := range entry.Layers {
+			if !slices.Contains(digests[l.DiffID], l.Digest) {
+				digests[l.DiffID] = append(digests[l.DiffID], l.Digest)
+			}
+		}
+	}
+	return digests, nil
+}
+
+// reuseCachedBlob points l at an already compressed blob in the cache with the
+// same diffID, if there is one whose digest is one of digests, those the cache
+// entries recorded for it. A blob with another digest was corrupted on disk, so
+// it is removed for l to be compressed and cached again.
+func (bc *Context) reuseCachedBlob(l *layer, digests []v1.Hash) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.compressed != "" || len(digests) == 0 {
+		return nil
+	}
+
+	blob := bc.layerCacheBlobPath(*l.diffid, true)
+	f, err := os.Open(blob)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Hashing is much cheaper than compressing.
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	digest := v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h.Sum(nil))}
+	if !slices.Contains(digests, digest) {
+		return os.Remove(blob)
+	}
+
+	l.compressed = blob
+	l.desc.Size = size
+	l.desc.Digest = digest
+	return nil
+}
+
+func (bc *Context) storeLayerCacheLineage(key string) error {
+	p := bc.layerCacheLineagePath()
+	if p == "" {
+		return nil
+	}
+	b, err := json.Marshal(layerCacheLineage{Key: key})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, b)
+}
+
+// logPackageDelta reports which packages changed since the last build of the
+// same config file and architecture, if that build is still in the cache.
+func (bc *Context) logPackageDelta(ctx context.Context, refs []string) {
+	log := clog.FromContext(ctx)
+
+	p := bc.layerCacheLineagePath()
+	if p == "" {
+		return
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return
+	}
+	var lineage layerCacheLineage
+	if err := json.Unmarshal(b, &lineage); err != nil {
+		return
+	}
+	b, err = os.ReadFile(bc.layerCacheEntryPath(lineage.Key))
+	if err != nil {
+		return
+	}
+	var prev layerCacheEntry
+	if err := json.Unmarshal(b, &prev); err != nil {
+		return
+	}
+
+	added, removed, changed := diffPackageRefs(prev.Packages, refs)
+	if len(added)+len(removed)+len(changed) == 0 {
+		log.Infof("packages unchanged since the last build, only the configuration differs")
+		return
+	}
+	log.Infof("packages changed since the last build: %d added, %d removed, %d changed", len(added), len(removed), len(changed))
+	for _, ref := range added {
+		log.Debugf("  + %s", ref)
+	}
+	for _, ref := range removed {
+		log.Debugf("  - %s", ref)
+	}
+	for _, ref := range changed {
+		log.Debugf("  ~ %s", ref)
+	}
+}
+
+// diffPackageRefs compares two sets of package references (as produced by
+// layerCacheKey) by package name.
+func diffPackageRefs(prev, next []string) (added, removed, changed []string) {
+	byName := func(refs []string) map[string]string {
+		m := make(map[string]string, len(refs))
+		for _, ref := range refs {
+			name, _, _ := strings.Cut(ref, " ")
+			m[name] = ref
+		}
+		return m
+	}
+	before, after := byName(prev), byName(next)
+
+	for name, ref := range after {
+		if old, ok := before[name]; !ok {
+			added = append(added, ref)
+		} else if old != ref {
+			changed = append(changed, ref)
+		}
+	}
+	for name, ref := range before {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, ref)
+		}
+	}
+	slices.Sort(added)
+	slices.Sort(removed)
+	slices.Sort(changed)
+	return added, removed, changed
+}
+
+// copyIntoCache copies src to dst via a temporary file so that concurrent
+// readers never observe a partially written blob. Existing blobs are kept.
+func copyIntoCache(src, dst string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return fmt.Errorf("copying %s to layer cache: %w", src, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+func writeFileAtomic(dst string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// restoreLayer unpacks the uncompressed layer tarball into fsys.
+func restoreLayer(ctx context.Context, fsys apkfs.FullFS, l *layer) error {
+	rc, err := l.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := filepath.Clean(hdr.Name)
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := fsys.MkdirAll(name, mode.Perm()); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeRestoredFile(fsys, name, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			_ = fsys.Remove(name)
+			if err := fsys.Symlink(hdr.Linkname, name); err != nil {
+				return err
+			}
+			// Symlinks don't carry permissions or times worth restoring.
+			continue
+		case tar.TypeLink:
+			_ = fsys.Remove(name)
+			if err := fsys.Link(hdr.Linkname, name); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeChar:
+			_ = fsys.Remove(name)
+			dev := int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))) //nolint:gosec
+			if err := fsys.Mknod(name, uint32(unix.S_IFCHR|mode.Perm()), dev); err != nil {
+				return err
+			}
+		default:
+			clog.FromContext(ctx).Debugf("skipping unsupported tar entry %s of type %c", hdr.Name, hdr.Typeflag)
+			continue
+		}
+
+		if err := fsys.Chmod(name, mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return err
+		}
+		if err := fsys.Chown(name, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+		if err := fsys.Chtimes(name, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
+		}
+		for k, v := range hdr.PAXRecords {
+			if attr, ok := strings.CutPrefix(k, xattrTarPAXRecordsPrefix); ok {
+				if err := fsys.SetXattr(name, attr, []byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func writeRestoredFile(fsys apkfs.FullFS, name string, r io.Reader) error {
+	f, err := fsys.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
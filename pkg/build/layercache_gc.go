@@ -0,0 +1,244 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// orphanGrace is how long blobs no entry refers to are kept, since builds
+// store the blobs of an entry before the entry itself.
+const orphanGrace = time.Hour
+
+// layerCacheFiles are the entries and blobs of a layer cache.
+type layerCacheFiles struct {
+	entries []layerCacheFile
+	// blobs are the sizes and modification times of the blobs.
+	blobs map[string]os.FileInfo
+	// refs counts the entries that refer to each blob.
+	refs map[string]int
+}
+
+// layerCacheFile is an entry of a layer cache: its metadata, the apk database
+// stored next to it, if any, and the blobs it refers to.
+type layerCacheFile struct {
+	key   string
+	paths []string
+	size  int64
+	used  time.Time
+	blobs []string
+}
+
+func readLayerCache(dir string) (*layerCacheFiles, error) {
+	c := &layerCacheFiles{blobs: map[string]os.FileInfo{}, refs: map[string]int{}}
+
+	err := filepath.WalkDir(filepath.Join(dir, "blobs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		c.blobs[path] = fi
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	des, err := os.ReadDir(filepath.Join(dir, "entries"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, de := range des {
+		key, ok := strings.CutSuffix(de.Name(), ".json")
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, "entries", de.Name())
+		fi, err := de.Info()
+		if err != nil {
+			return nil, err
+		}
+		f := layerCacheFile{key: key, paths: []string{path}, size: fi.Size(), used: fi.ModTime()}
+		installed := filepath.Join(dir, "entries", key+".installed")
+		if ii, err := os.Stat(installed); err == nil {
+			f.paths = append(f.paths, installed)
+			f.size += ii.Size()
+		}
+
+		// An unreadable entry is not used by builds either, and is removed
+		// like any other.
+		var entry layerCacheEntry
+		if b, err := os.ReadFile(path); err == nil && json.Unmarshal(b, &entry) == nil {
+			for _, l := range entry.Layers {
+				for _, compressed := range []bool{false, true} {
+					blob := layerCacheBlobPath(dir, l.DiffID, compressed)
+					if _, ok := c.blobs[blob]; ok && !slices.Contains(f.blobs, blob) {
+						f.blobs = append(f.blobs, blob)
+						c.refs[blob]++
+					}
+				}
+			}
+		}
+		c.entries = append(c.entries, f)
+	}
+	// Least recently used first.
+	slices.SortFunc(c.entries, func(a, b layerCacheFile) int {
+		if c := a.used.Compare(b.used); c != 0 {
+			return c
+		}
+		return strings.Compare(a.key, b.key)
+	})
+	return c, nil
+}
+
+// size returns the size of the entries and of the blobs they refer to.
+func (c *layerCacheFiles) size() int64 {
+	var size int64
+	for _, e := range c.entries {
+		size += e.size
+	}
+	for blob, n := range c.refs {
+		if n > 0 {
+			size += c.blobs[blob].Size()
+		}
+	}
+	return size
+}
+
+// LayerCacheUsage summarizes the entries of the layer cache in dir, whose
+// size includes all the blobs.
+func LayerCacheUsage(dir string) (apk.CacheStats, error) {
+	c, err := readLayerCache(dir)
+	if err != nil {
+		return apk.CacheStats{}, err
+	}
+	stats := apk.CacheStats{Entries: len(c.entries)}
+	for _, e := range c.entries {
+		if stats.Oldest.IsZero() || e.used.Before(stats.Oldest) {
+			stats.Oldest = e.used
+		}
+		if e.used.After(stats.Newest) {
+			stats.Newest = e.used
+		}
+	}
+	for _, fi := range c.blobs {
+		stats.Size += fi.Size()
+	}
+	for _, e := range c.entries {
+		stats.Size += e.size
+	}
+	return stats, nil
+}
+
+// PruneLayerCache removes the entries of the layer cache in dir that policy
+// selects, counting the blobs they refer to in their size, along with the
+// blobs no entry refers to any more. Entries count as used when a build
+// finds its layers in them. It returns the entries it removed, and the space
+// their files and blobs took.
+func PruneLayerCache(ctx context.Context, dir string, policy apk.CachePrunePolicy) (apk.CacheStats, error) {
+	log := clog.FromContext(ctx)
+	c, err := readLayerCache(dir)
+	if err != nil {
+		return apk.CacheStats{}, err
+	}
+
+	remove := func(path string) error {
+		log.Debugf("removing %s", path)
+		if policy.DryRun {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	size := c.size()
+	var removed apk.CacheStats
+	kept := map[string]bool{}
+	for i, e := range c.entries {
+		expired := policy.MaxAge > 0 && now.Sub(e.used) > policy.MaxAge
+		oversized := policy.MaxSize > 0 && size > policy.MaxSize
+		if !expired && !oversized {
+			// The rest are more recently used.
+			for _, e := range c.entries[i:] {
+				kept[e.key] = true
+			}
+			break
+		}
+		freed := e.size
+		for _, p := range e.paths {
+			if err := remove(p); err != nil {
+				return removed, err
+			}
+		}
+		for _, blob := range e.blobs {
+			c.refs[blob]--
+			if c.refs[blob] == 0 {
+				if err := remove(blob); err != nil {
+					return removed, err
+				}
+				freed += c.blobs[blob].Size()
+			}
+		}
+		size -= freed
+		removed.Add(freed, e.used)
+	}
+
+	// Blobs of no entry, such as those of entries that were removed by
+	// hand, or left behind by interrupted builds.
+	for blob, fi := range c.blobs {
+		if _, ok := c.refs[blob]; !ok && now.Sub(fi.ModTime()) > orphanGrace {
+			if err := remove(blob); err != nil {
+				return removed, err
+			}
+			removed.Size += fi.Size()
+		}
+	}
+
+	// The lineage of the removed entries.
+	des, err := os.ReadDir(filepath.Join(dir, "lineage"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return removed, err
+	}
+	for _, de := range des {
+		path := filepath.Join(dir, "lineage", de.Name())
+		var lineage layerCacheLineage
+		if b, err := os.ReadFile(path); err == nil && json.Unmarshal(b, &lineage) == nil && kept[lineage.Key] {
+			continue
+		}
+		if err := remove(path); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
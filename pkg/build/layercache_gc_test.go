@@ -0,0 +1,125 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+func TestPruneLayerCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	write := func(path string, b []byte, age time.Duration) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, b, 0o644))
+		require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+	}
+	hash := func(c string) v1.Hash {
+		return v1.Hash{Algorithm: "sha256", Hex: strings.Repeat(c, 64)}
+	}
+	// setup creates entries old, using blobs a and b, and new, using blobs b
+	// and c, along with a blob of no entry and the lineage of both entries.
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		for key, e := range map[string]struct {
+			blobs []string
+			age   time.Duration
+		}{
+			"old": {[]string{"a", "b"}, 48 * time.Hour},
+			"new": {[]string{"b", "c"}, time.Minute},
+		} {
+			var entry layerCacheEntry
+			for _, b := range e.blobs {
+				entry.Layers = append(entry.Layers, layerCacheLayer{DiffID: hash(b), Digest: hash(b)})
+				write(layerCacheBlobPath(dir, hash(b), true), make([]byte, 100), 72*time.Hour)
+			}
+			j, err := json.Marshal(entry)
+			require.NoError(t, err)
+			write(filepath.Join(dir, "entries", key+".json"), j, e.age)
+			j, err = json.Marshal(layerCacheLineage{Key: key})
+			require.NoError(t, err)
+			write(filepath.Join(dir, "lineage", key+".json"), j, e.age)
+		}
+		write(layerCacheBlobPath(dir, hash("d"), false), make([]byte, 100), 2*orphanGrace)
+		write(layerCacheBlobPath(dir, hash("e"), false), make([]byte, 100), 0)
+		return dir
+	}
+	exists := func(t *testing.T, path string) bool {
+		t.Helper()
+		_, err := os.Stat(path)
+		return err == nil
+	}
+
+	t.Run("usage", func(t *testing.T) {
+		dir := setup(t)
+		stats, err := LayerCacheUsage(dir)
+		require.NoError(t, err)
+		require.Equal(t, 2, stats.Entries)
+		require.Greater(t, stats.Size, int64(500))
+		require.True(t, stats.Oldest.Before(stats.Newest))
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		dir := setup(t)
+		removed, err := PruneLayerCache(ctx, dir, apk.CachePrunePolicy{MaxAge: 24 * time.Hour})
+		require.NoError(t, err)
+		require.Equal(t, 1, removed.Entries)
+
+		require.False(t, exists(t, filepath.Join(dir, "entries", "old.json")))
+		require.True(t, exists(t, filepath.Join(dir, "entries", "new.json")))
+		// The blob only the removed entry used goes with it.
+		require.False(t, exists(t, layerCacheBlobPath(dir, hash("a"), true)))
+		require.True(t, exists(t, layerCacheBlobPath(dir, hash("b"), true)))
+		require.True(t, exists(t, layerCacheBlobPath(dir, hash("c"), true)))
+		// Blobs of no entry are removed after the grace period.
+		require.False(t, exists(t, layerCacheBlobPath(dir, hash("d"), false)))
+		require.True(t, exists(t, layerCacheBlobPath(dir, hash("e"), false)))
+		require.False(t, exists(t, filepath.Join(dir, "lineage", "old.json")))
+		require.True(t, exists(t, filepath.Join(dir, "lineage", "new.json")))
+	})
+
+	t.Run("max size", func(t *testing.T) {
+		dir := setup(t)
+		removed, err := PruneLayerCache(ctx, dir, apk.CachePrunePolicy{MaxSize: 1})
+		require.NoError(t, err)
+		require.Equal(t, 2, removed.Entries)
+		for _, b := range []string{"a", "b", "c"} {
+			require.False(t, exists(t, layerCacheBlobPath(dir, hash(b), true)), b)
+		}
+		require.False(t, exists(t, filepath.Join(dir, "lineage", "new.json")))
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		dir := setup(t)
+		removed, err := PruneLayerCache(ctx, dir, apk.CachePrunePolicy{MaxSize: 1, DryRun: true})
+		require.NoError(t, err)
+		require.Equal(t, 2, removed.Entries)
+		require.True(t, exists(t, filepath.Join(dir, "entries", "old.json")))
+		require.True(t, exists(t, layerCacheBlobPath(dir, hash("a"), true)))
+	})
+}
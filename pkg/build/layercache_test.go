@@ -0,0 +1,246 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/tarfs"
+)
+
+func TestDiffPackageRefs(t *testing.T) {
+	prev := []string{
+		"bar bar-1.0-r0.apk Q1aaa",
+		"baz baz-1.0-r0.apk Q1bbb",
+		"foo foo-1.0-r0.apk Q1ccc",
+	}
+	next := []string{
+		"bar bar-1.0-r0.apk Q1aaa",
+		"foo foo-1.1-r0.apk Q1ddd",
+		"qux qux-1.0-r0.apk Q1eee",
+	}
+
+	added, removed, changed := diffPackageRefs(prev, next)
+	require.Equal(t, []string{"qux qux-1.0-r0.apk Q1eee"}, added)
+	require.Equal(t, []string{"baz baz-1.0-r0.apk Q1bbb"}, removed)
+	require.Equal(t, []string{"foo foo-1.1-r0.apk Q1ddd"}, changed)
+}
+
+func TestLayerCacheReusesUnchangedLayers(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+
+	build := func(annotations map[string]string) *Context {
+		bc, err := New(ctx, fs.NewMemFS(),
+			WithConfig("layering.yaml", []string{"testdata"}),
+			WithAnnotations(annotations),
+			WithLayerCache(cacheDir),
+		)
+		require.NoError(t, err)
+		return bc
+	}
+
+	first := build(nil)
+	firstLayers, err := first.BuildLayers(ctx)
+	require.NoError(t, err)
+	require.Len(t, firstLayers, 2)
+
+	// Changing the config invalidates the top layer (it contains /etc/apko.json)
+	// but the package layer is unchanged and should come from the cache.
+	second := build(map[string]string{"org.opencontainers.image.title": "changed"})
+	secondLayers, err := second.BuildLayers(ctx)
+	require.NoError(t, err)
+	require.Len(t, secondLayers, 2)
+
+	pkgLayer := secondLayers[0].(*layer)
+	require.True(t, strings.HasPrefix(pkgLayer.compressed, filepath.Join(cacheDir, "blobs")), "package layer should reuse the cached blob")
+
+	topLayer := secondLayers[1].(*layer)
+	require.False(t, strings.HasPrefix(topLayer.compressed, filepath.Join(cacheDir, "blobs")), "top layer should have been recompressed")
+
+	for i := range firstLayers {
+		want, err := firstLayers[i].DiffID()
+		require.NoError(t, err)
+		got, err := secondLayers[i].DiffID()
+		require.NoError(t, err)
+		if i == 0 {
+			require.Equal(t, want, got)
+		} else {
+			require.NotEqual(t, want, got)
+		}
+	}
+}
+
+func TestLayerCacheComposesUnchangedPackageLayers(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+
+	build := func(cache bool, annotations map[string]string, paths ...types.PathMutation) []v1.Layer {
+		opts := []Option{
+			WithConfig("layering.yaml", []string{"testdata"}),
+			WithAnnotations(annotations),
+		}
+		if cache {
+			opts = append(opts, WithLayerCache(cacheDir))
+		}
+		bc, err := New(ctx, tarfs.New(), opts...)
+		require.NoError(t, err)
+		bc.ic.Paths = append(bc.ic.Paths, paths...)
+		layers, err := bc.BuildLayers(ctx)
+		require.NoError(t, err)
+		require.Len(t, layers, 2)
+		return layers
+	}
+	diffIDs := func(layers []v1.Layer) []v1.Hash {
+		var hs []v1.Hash
+		for _, l := range layers {
+			h, err := l.DiffID()
+			require.NoError(t, err)
+			hs = append(hs, h)
+		}
+		return hs
+	}
+	written := func(l v1.Layer) bool {
+		return !strings.HasPrefix(l.(*layer).uncompressed, filepath.Join(cacheDir, "blobs"))
+	}
+	changed := map[string]string{"org.opencontainers.image.title": "changed"}
+
+	first := build(true, nil)
+	require.NotEmpty(t, first[0].(*layer).fingerprint)
+
+	// Only the configuration changed, so the package layer is not written
+	// again: the cached one is used as is, and the image is the same as if
+	// it had been.
+	second := build(true, changed)
+	require.False(t, written(second[0]), "package layer should not have been written")
+	require.True(t, written(second[1]), "top layer should have been written")
+	require.Equal(t, diffIDs(build(false, changed)), diffIDs(second))
+
+	// The configuration changes a file of the same packages, so the package
+	// layer is written again.
+	chmod := types.PathMutation{Type: "permissions", Path: "/var/lib/db/sbom/replayout-1.0.0-r0.spdx.json", Permissions: 0o600}
+	third := build(true, nil, chmod)
+	require.True(t, written(third[0]), "stale package layer should have been written")
+	require.Equal(t, diffIDs(build(false, nil, chmod)), diffIDs(third))
+	require.NotEqual(t, diffIDs(first)[0], diffIDs(third)[0])
+}
+
+func TestLayerCacheKeyCoversPathImages(t *testing.T) {
+	ctx := context.Background()
+
+	bc, err := New(ctx, fs.NewMemFS(),
+		WithConfig("layering.yaml", []string{"testdata"}),
+		WithLayerCache(t.TempDir()),
+	)
+	require.NoError(t, err)
+	bc.ic.Paths = append(bc.ic.Paths, types.PathMutation{Type: "image", Image: "example.com/app:latest", Path: "/opt/app", Source: "/opt/app"})
+
+	key := func(img v1.Image) string {
+		bc.pathImages = map[string]v1.Image{"example.com/app:latest": img}
+		k, _, err := bc.layerCacheKey(ctx)
+		require.NoError(t, err)
+		return k
+	}
+
+	// Moving the tag to another image changes the key, although the
+	// configuration is the same.
+	first := key(testImage(t, &tar.Header{Name: "opt/app/v1", Typeflag: tar.TypeReg, Mode: 0o644}))
+	second := key(testImage(t, &tar.Header{Name: "opt/app/v2", Typeflag: tar.TypeReg, Mode: 0o644}))
+	require.NotEqual(t, first, second)
+	require.Equal(t, second, key(testImage(t, &tar.Header{Name: "opt/app/v2", Typeflag: tar.TypeReg, Mode: 0o644})))
+}
+
+func TestLayerCacheRecompressesCorruptedBlobs(t *testing.T) {
+	ctx := context.Background()
+	cacheDir := t.TempDir()
+
+	build := func(annotations map[string]string) []v1.Layer {
+		bc, err := New(ctx, fs.NewMemFS(),
+			WithConfig("layering.yaml", []string{"testdata"}),
+			WithAnnotations(annotations),
+			WithLayerCache(cacheDir),
+		)
+		require.NoError(t, err)
+		layers, err := bc.BuildLayers(ctx)
+		require.NoError(t, err)
+		return layers
+	}
+
+	first := build(nil)
+	diffid, err := first[0].DiffID()
+	require.NoError(t, err)
+	want, err := first[0].Digest()
+	require.NoError(t, err)
+	blob := layerCacheBlobPath(cacheDir, diffid, true)
+	require.NoError(t, os.WriteFile(blob, []byte("corrupted"), 0o644))
+
+	// The corrupted blob of the unchanged package layer is compressed again
+	// instead of being published under a digest of its own.
+	second := build(map[string]string{"org.opencontainers.image.title": "changed"})
+	pkgLayer := second[0].(*layer)
+	got, err := pkgLayer.Digest()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	b, err := os.ReadFile(pkgLayer.compressed)
+	require.NoError(t, err)
+	require.Equal(t, want.Hex, fmt.Sprintf("%x", sha256.Sum256(b)), "corrupted blob should not be reused")
+
+	b, err = os.ReadFile(blob)
+	require.NoError(t, err)
+	require.Equal(t, want.Hex, fmt.Sprintf("%x", sha256.Sum256(b)))
+}
+
+func TestLayerCacheInstallsKeyedPackages(t *testing.T) {
+	ctx := context.Background()
+
+	bc, err := New(ctx, fs.NewMemFS(),
+		WithConfig("layering.yaml", []string{"testdata"}),
+		WithLayerCache(t.TempDir()),
+	)
+	require.NoError(t, err)
+	_, refs, err := bc.layerCacheKey(ctx)
+	require.NoError(t, err)
+	require.NotEmpty(t, refs)
+
+	// The world is resolved once: changing it now does not change what the
+	// build keyed on and installs.
+	require.NoError(t, bc.apk.SetWorld(ctx, nil))
+	_, err = bc.BuildLayers(ctx)
+	require.NoError(t, err)
+
+	installed, err := bc.apk.GetInstalled()
+	require.NoError(t, err)
+	var names []string
+	for _, pkg := range installed {
+		names = append(names, pkg.Name)
+	}
+	for _, ref := range refs {
+		name, _, _ := strings.Cut(ref, " ")
+		require.Contains(t, names, name)
+	}
+}
@@ -19,22 +19,32 @@ import (
 	"bytes"
 	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"maps"
 	"os"
 	"path"
 	"slices"
+	"strings"
 
 	"chainguard.dev/apko/pkg/apk/apk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/report"
 
 	"github.com/chainguard-dev/clog"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.opentelemetry.io/otel"
 )
 
 func (bc *Context) buildLayers(ctx context.Context) ([]v1.Layer, error) {
 	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "buildLayers")
+	defer span.End()
 
 	if strategy := bc.ic.Layering.Strategy; strategy != "origin" {
 		return nil, fmt.Errorf("unrecognized layering strategy %q", strategy)
@@ -84,8 +94,29 @@ func (bc *Context) buildLayers(ctx context.Context) ([]v1.Layer, error) {
 		}
 	}
 
+	det, err := bc.determinism()
+	if err != nil {
+		return nil, err
+	}
+
+	// With the layer cache, the groups of packages that were already cached
+	// are not written again, only fingerprinted.
+	cached, err := bc.cachedPackageLayers()
+	if err != nil {
+		log.Warnf("ignoring unreadable layer cache: %v", err)
+		cached = nil
+	}
+
 	// Then partition that single fs.FS into multiple layers based on our layering strategy.
-	return splitLayers(ctx, bc.fs, groups, pkgToDiff, bc.o.TempDir())
+	defer report.FromContext(ctx).Time(report.PhaseTar, bc.Arch().ToAPK())()
+	layers, err := splitLayers(ctx, bc.fs, groups, pkgToDiff, bc.o.TempDir(), det, bc.omittedPaths(), cached)
+	if errors.Is(err, errStaleLayer) {
+		// The files of packages are changed by the rest of the image at
+		// times, such as by scriptlets or a new /etc/passwd, so write them.
+		log.Infof("cached layer is stale, writing all layers")
+		return splitLayers(ctx, bc.fs, groups, pkgToDiff, bc.o.TempDir(), det, bc.omittedPaths(), nil)
+	}
+	return layers, err
 }
 
 func replacesGroup(rep string, g *group) (bool, error) {
@@ -253,21 +284,51 @@ func merge(groups ...*group) *group {
 	return merged
 }
 
-func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToDiff map[*apk.Package][]byte, tmpdir string) ([]v1.Layer, error) {
+// packages returns the sorted "<name>=<version>" of the packages of g.
+func (g *group) packages() []string {
+	pkgs := make([]string, 0, len(g.pkgs))
+	for _, pkg := range g.pkgs {
+		pkgs = append(pkgs, pkg.Name+"="+pkg.Version)
+	}
+	slices.Sort(pkgs)
+	return pkgs
+}
+
+// errStaleLayer is returned by splitLayers when a group of packages that was
+// cached has another fingerprint now, so it has to be written after all.
+var errStaleLayer = errors.New("stale cached layer")
+
+// splitLayers writes a layer for each group of packages, with their files,
+// and a top layer with the rest of fsys. cached holds the cached layers of
+// packages by fingerprint: a group with the packages of one of them is only
+// fingerprinted, and the cached layer of its fingerprint is used instead. If
+// there is none, errStaleLayer is returned.
+func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToDiff map[*apk.Package][]byte, tmpdir string, det determinism, omit []string, cached map[string]*layer) ([]v1.Layer, error) {
 	buf := make([]byte, 1<<20)
 
+	cachedPackages := map[string]bool{}
+	for _, l := range cached {
+		cachedPackages[strings.Join(l.packages, " ")] = true
+	}
+
 	// We'll create a writer for each layer and a map to quickly access the writer given a package or group.
 	packageToWriter := map[string]*layerWriter{}
 	groupToWriter := map[*group]*layerWriter{}
 
 	for _, g := range groups {
-		f, err := os.CreateTemp(tmpdir, "layer-*.tar.gz")
-		if err != nil {
-			return nil, err
-		}
-		defer f.Close()
+		var w *layerWriter
+		if cachedPackages[strings.Join(g.packages(), " ")] {
+			w = newFingerprintWriter()
+		} else {
+			f, err := os.CreateTemp(tmpdir, "layer-*.tar.gz")
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
 
-		w := newLayerWriter(f)
+			w = newLayerWriter(f)
+			w.fingerprint = newFingerprint()
+		}
 		groupToWriter[g] = w
 
 		for _, pkg := range g.pkgs {
@@ -291,7 +352,7 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 	// any missing directory entries to the layer before we write the actual file entry.
 	stack := []*file{}
 
-	for f, err := range walkFS(ctx, fsys) {
+	for f, err := range walkFS(ctx, fsys, det, omit) {
 		if err != nil {
 			return nil, err
 		}
@@ -352,28 +413,19 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 			// timestamp with the timestamp of the "f" file we're about to write to this layer.
 			todo.header.ModTime = f.header.ModTime
 
-			if err := w.w.WriteHeader(todo.header); err != nil {
+			if err := w.writeHeader(todo.header); err != nil {
 				return nil, fmt.Errorf("writing header %s: %w", todo.header.Name, err)
 			}
 		}
 
 		// Now we're back to normal tar stuff.
-		if err := w.w.WriteHeader(f.header); err != nil {
+		if err := w.writeHeader(f.header); err != nil {
 			return nil, fmt.Errorf("writing header %s: %w", f.header.Name, err)
 		}
 
 		if f.header.Typeflag == tar.TypeReg && f.header.Size > 0 {
-			data, err := fsys.Open(f.path)
-			if err != nil {
-				return nil, fmt.Errorf("opening %s: %w", f.path, err)
-			}
-			if _, err := io.CopyBuffer(w.w, data, buf); err != nil {
-				return nil, fmt.Errorf("copying %s: %w", f.path, err)
-			}
-
-			// Should never fail in practice.
-			if err := data.Close(); err != nil {
-				return nil, fmt.Errorf("closing %s: %w", f.path, err)
+			if err := w.writeFile(fsys, f, buf); err != nil {
+				return nil, err
 			}
 		}
 
@@ -386,7 +438,7 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 				for _, todo := range w.alignStacks(stack) {
 					todo.header.ModTime = f.header.ModTime
 
-					if err := w.w.WriteHeader(todo.header); err != nil {
+					if err := w.writeHeader(todo.header); err != nil {
 						return nil, fmt.Errorf("writing header %s: %w", todo.header.Name, err)
 					}
 				}
@@ -403,11 +455,11 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 				idb := *f.header
 				idb.Size = int64(buf.Len())
 
-				if err := w.w.WriteHeader(&idb); err != nil {
+				if err := w.writeHeader(&idb); err != nil {
 					return nil, err
 				}
 
-				if _, err := io.Copy(w.w, &buf); err != nil {
+				if err := w.write(buf.Bytes()); err != nil {
 					return nil, err
 				}
 			}
@@ -423,6 +475,26 @@ func splitLayers(ctx context.Context, fsys apkfs.FullFS, groups []*group, pkgToD
 		if err != nil {
 			return nil, fmt.Errorf("finalizing group[%d] layer: %w", i, err)
 		}
+		l.fingerprint = hex.EncodeToString(w.fingerprint.Sum(nil))
+		if w.w == nil {
+			cl, ok := cached[l.fingerprint]
+			if !ok {
+				return nil, errStaleLayer
+			}
+			clog.FromContext(ctx).Infof("using cached layer[%d] %s", i, cl.diffid)
+			layers = append(layers, cl)
+			continue
+		}
+		l.packages = g.packages()
+		for _, pkg := range g.pkgs {
+			if !slices.Contains(l.origins, pkg.Origin) {
+				l.origins = append(l.origins, pkg.Origin)
+			}
+			if pkg.BuildTime.After(l.created) {
+				l.created = pkg.BuildTime
+			}
+		}
+		slices.Sort(l.origins)
 		layers = append(layers, l)
 	}
 
@@ -485,3 +557,86 @@ func (w *layerWriter) alignStacks(stack []*file) []*file {
 
 	return nil
 }
+
+// newFingerprint returns the hash of a layer fingerprint, which covers the
+// layer cache version as the way layers are written changes with it.
+func newFingerprint() hash.Hash {
+	h := sha256.New()
+	h.Write([]byte(layerCacheVersion))
+	return h
+}
+
+// newFingerprintWriter returns a layerWriter that only fingerprints the layer.
+func newFingerprintWriter() *layerWriter {
+	return &layerWriter{
+		fingerprint: newFingerprint(),
+		finalize: func() (*layer, error) {
+			return &layer{}, nil
+		},
+	}
+}
+
+// writeHeader writes hdr to the layer and its fingerprint.
+func (w *layerWriter) writeHeader(hdr *tar.Header) error {
+	if w.fingerprint != nil {
+		if err := json.NewEncoder(w.fingerprint).Encode(hdr); err != nil {
+			return err
+		}
+	}
+	if w.w == nil {
+		return nil
+	}
+	return w.w.WriteHeader(hdr)
+}
+
+// write writes b to the layer and its fingerprint.
+func (w *layerWriter) write(b []byte) error {
+	if w.fingerprint != nil {
+		w.fingerprint.Write(b)
+	}
+	if w.w == nil {
+		return nil
+	}
+	_, err := w.w.Write(b)
+	return err
+}
+
+// writeFile writes the contents of the regular file f of fsys to the layer.
+// The fingerprint gets the checksum of the contents from the package of f
+// instead, if it has one, so that the contents need not be read when the
+// layer is only fingerprinted.
+func (w *layerWriter) writeFile(fsys apkfs.FullFS, f *file, buf []byte) error {
+	var checksum []byte
+	if cs, ok := f.info.(interface{ Checksum() []byte }); ok {
+		checksum = cs.Checksum()
+	}
+	if w.fingerprint != nil && checksum != nil {
+		w.fingerprint.Write(checksum)
+		if w.w == nil {
+			return nil
+		}
+	}
+
+	var dst io.Writer = io.Discard
+	if w.w != nil {
+		dst = w.w
+	}
+	if w.fingerprint != nil && checksum == nil {
+		dst = io.MultiWriter(dst, w.fingerprint)
+	}
+
+	data, err := fsys.Open(f.path)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", f.path, err)
+	}
+	if _, err := io.CopyBuffer(dst, data, buf); err != nil {
+		data.Close()
+		return fmt.Errorf("copying %s: %w", f.path, err)
+	}
+
+	// Should never fail in practice.
+	if err := data.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", f.path, err)
+	}
+	return nil
+}
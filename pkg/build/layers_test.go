@@ -17,12 +17,15 @@ package build
 import (
 	"archive/tar"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"slices"
 	"testing"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+
 	"chainguard.dev/apko/pkg/apk/apk"
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
 )
@@ -280,7 +283,7 @@ func TestSplitLayersDirectoryCreation(t *testing.T) {
 
 	// Call splitLayers to create the layers
 	ctx := context.Background()
-	layers, err := splitLayers(ctx, fsys, groups, pkgToDiff, tmpDir)
+	layers, err := splitLayers(ctx, fsys, groups, pkgToDiff, tmpDir, determinism{}, nil, nil)
 	if err != nil {
 		t.Fatalf("splitLayers failed: %v", err)
 	}
@@ -290,6 +293,18 @@ func TestSplitLayersDirectoryCreation(t *testing.T) {
 		t.Fatalf("expected 3 layers, got %d", len(layers))
 	}
 
+	// The package layers know their packages, for the layer annotations.
+	for i, want := range [][]string{{"pkg1=1.0.0"}, {"pkg2=1.0.0"}, nil} {
+		if got := layers[i].(*layer).Packages(); !slices.Equal(got, want) {
+			t.Errorf("layer %d packages: got %v, want %v", i, got, want)
+		}
+	}
+	for i, want := range [][]string{{"pkg1"}, {"pkg2"}, nil} {
+		if got := layers[i].(*layer).Origins(); !slices.Equal(got, want) {
+			t.Errorf("layer %d origins: got %v, want %v", i, got, want)
+		}
+	}
+
 	wantDirs := map[string]struct{}{
 		"usr":            {},
 		"usr/lib":        {},
@@ -348,3 +363,49 @@ func TestSplitLayersDirectoryCreation(t *testing.T) {
 		}
 	}
 }
+
+func TestSplitLayersReusesCachedLayers(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	if err := fsys.MkdirAll("usr/lib/apk/db", 0755); err != nil {
+		t.Fatalf("failed to create parent directories: %v", err)
+	}
+	if err := fsys.WriteFile("usr/lib/apk/db/installed", []byte("test db content"), 0644); err != nil {
+		t.Fatalf("failed to create installed DB file: %v", err)
+	}
+
+	pkg1 := &apk.Package{Name: "pkg1", Origin: "pkg1", Version: "1.0.0"}
+	pkg2 := &apk.Package{Name: "pkg2", Origin: "pkg2", Version: "1.0.0"}
+	groups := []*group{{pkgs: []*apk.Package{pkg1}}, {pkgs: []*apk.Package{pkg2}}}
+	pkgToDiff := map[*apk.Package][]byte{
+		pkg1: []byte("pkg1 info\n"),
+		pkg2: []byte("pkg2 info\n"),
+	}
+
+	ctx := context.Background()
+	first, err := splitLayers(ctx, fsys, groups, pkgToDiff, t.TempDir(), determinism{}, nil, nil)
+	if err != nil {
+		t.Fatalf("splitLayers failed: %v", err)
+	}
+	cached := first[0].(*layer)
+	if cached.fingerprint == "" {
+		t.Fatalf("package layer has no fingerprint")
+	}
+
+	// The layer of pkg1 is not written again, the cached one is used.
+	second, err := splitLayers(ctx, fsys, groups, pkgToDiff, t.TempDir(), determinism{}, nil, map[string]*layer{cached.fingerprint: cached})
+	if err != nil {
+		t.Fatalf("splitLayers failed: %v", err)
+	}
+	if second[0] != v1.Layer(cached) {
+		t.Errorf("layer of pkg1 was written again")
+	}
+	if second[1].(*layer).uncompressed == first[1].(*layer).uncompressed {
+		t.Errorf("layer of pkg2 was not written")
+	}
+
+	// The same packages with other contents are written again.
+	pkgToDiff[pkg1] = []byte("pkg1 other info\n")
+	if _, err := splitLayers(ctx, fsys, groups, pkgToDiff, t.TempDir(), determinism{}, nil, map[string]*layer{cached.fingerprint: cached}); !errors.Is(err, errStaleLayer) {
+		t.Errorf("splitLayers with a stale layer: got %v, want %v", err, errStaleLayer)
+	}
+}
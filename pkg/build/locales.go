@@ -0,0 +1,66 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+)
+
+// generateLocales compiles the locales of the image configuration into the
+// locale archive of the image with its localedef, as locale-gen would, in the
+// scriptlet sandbox of the build.
+func (bc *Context) generateLocales(ctx context.Context) error {
+	if len(bc.ic.Locales) == 0 {
+		return nil
+	}
+	if bc.o.Scriptlets == "" {
+		return fmt.Errorf("locales are generated with localedef in the scriptlet sandbox, which must be set (--run-scriptlets)")
+	}
+	ctx, span := otel.Tracer("apko").Start(ctx, "generateLocales")
+	defer span.End()
+	log := clog.FromContext(ctx)
+
+	return bc.inSandbox(ctx, "locales", func(_ string, run sandboxRunner) error {
+		for _, locale := range bc.ic.Locales {
+			log.Infof("generating locale %s", locale)
+			out, err := run(localedefArgs(locale)...)
+			if err != nil {
+				return fmt.Errorf("generating locale %s: %w\n%s", locale, err, out)
+			}
+		}
+		return nil
+	})
+}
+
+// localedefArgs returns the localedef command compiling locale, such as
+// en_US.UTF-8 or de_DE@euro, from the source of its language and modifier
+// and its charset, UTF-8 if it has none.
+func localedefArgs(locale string) []string {
+	input, modifier, _ := strings.Cut(locale, "@")
+	input, charset, ok := strings.Cut(input, ".")
+	if !ok {
+		charset = "UTF-8"
+	}
+	if modifier != "" {
+		input += "@" + modifier
+	}
+	// Without -c, localedef fails on the warnings of some locale sources.
+	return []string{"localedef", "-c", "-i", input, "-f", charset, locale}
+}
@@ -0,0 +1,43 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestLocaledefArgs(t *testing.T) {
+	for locale, want := range map[string][]string{
+		"en_US.UTF-8":            {"localedef", "-c", "-i", "en_US", "-f", "UTF-8", "en_US.UTF-8"},
+		"de_DE":                  {"localedef", "-c", "-i", "de_DE", "-f", "UTF-8", "de_DE"},
+		"de_DE.ISO-8859-15@euro": {"localedef", "-c", "-i", "de_DE@euro", "-f", "ISO-8859-15", "de_DE.ISO-8859-15@euro"},
+	} {
+		require.Equal(t, want, localedefArgs(locale), locale)
+	}
+}
+
+func TestGenerateLocalesWithoutSandbox(t *testing.T) {
+	bc := &Context{
+		ic: types.ImageConfiguration{Locales: []string{"en_US.UTF-8"}},
+		fs: apkfs.NewMemFS(),
+	}
+	require.ErrorContains(t, bc.generateLocales(context.Background()), "--run-scriptlets")
+}
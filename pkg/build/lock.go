@@ -40,9 +40,13 @@ func LockImageConfiguration(ctx context.Context, ic types.ImageConfiguration, op
 		return nil, nil, err
 	}
 
-	input.Contents.BuildRepositories = sets.List(sets.New(input.Contents.BuildRepositories...).Insert(o.ExtraBuildRepos...))
+	buildRepos, keys, err := appendMelangeWorkspace(o.MelangeWorkspace, o.ExtraBuildRepos, o.ExtraKeyFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+	input.Contents.BuildRepositories = sets.List(sets.New(input.Contents.BuildRepositories...).Insert(buildRepos...))
 	input.Contents.Repositories = sets.List(sets.New(input.Contents.Repositories...).Insert(o.ExtraRepos...))
-	input.Contents.Keyring = sets.List(sets.New(input.Contents.Keyring...).Insert(o.ExtraKeyFiles...))
+	input.Contents.Keyring = sets.List(sets.New(input.Contents.Keyring...).Insert(keys...))
 
 	mc, err := NewMultiArch(ctx, input.Archs, append(opts, WithImageConfiguration(*input))...)
 	if err != nil {
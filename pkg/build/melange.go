@@ -0,0 +1,94 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// melangeWorkspace returns the repository of the packages built by melange
+// in the workspace dir, and the public keys they are signed with.
+//
+// dir is either the workspace, with the packages in its packages/ directory
+// as melange writes them by default, or that directory itself. The packages
+// directory must have an APKINDEX.tar.gz in at least one architecture
+// directory. The keys are the *.rsa.pub files of the workspace, where
+// `melange keygen` writes them, and of the packages directory.
+func melangeWorkspace(dir string) (string, []string, error) {
+	packages := filepath.Join(dir, "packages")
+	if fi, err := os.Stat(packages); err != nil || !fi.IsDir() {
+		packages = dir
+	}
+
+	archs, err := melangeArchs(packages)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading melange workspace %s: %w", dir, err)
+	}
+	if len(archs) == 0 {
+		return "", nil, fmt.Errorf("melange workspace %s: no architecture directory with an APKINDEX.tar.gz found in %s", dir, packages)
+	}
+
+	var keys []string
+	seen := map[string]bool{}
+	for _, d := range slices.Compact([]string{filepath.Clean(dir), filepath.Clean(packages)}) {
+		found, err := filepath.Glob(filepath.Join(d, "*.rsa.pub"))
+		if err != nil {
+			return "", nil, err
+		}
+		for _, k := range found {
+			// Keys are installed by name, so the first one wins.
+			if !seen[filepath.Base(k)] {
+				seen[filepath.Base(k)] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	return packages, keys, nil
+}
+
+// melangeArchs returns the architecture directories of packages that have an
+// index.
+func melangeArchs(packages string) ([]string, error) {
+	entries, err := os.ReadDir(packages)
+	if err != nil {
+		return nil, err
+	}
+	var archs []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(packages, e.Name(), "APKINDEX.tar.gz")); err == nil {
+			archs = append(archs, e.Name())
+		}
+	}
+	return archs, nil
+}
+
+// appendMelangeWorkspace returns repos and keys with the repository and keys
+// of the melange workspace dir, if it is set.
+func appendMelangeWorkspace(dir string, repos, keys []string) ([]string, []string, error) {
+	if dir == "" {
+		return repos, keys, nil
+	}
+	repo, wkeys, err := melangeWorkspace(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(slices.Clone(repos), repo), append(slices.Clone(keys), wkeys...), nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMelangeWorkspace(t *testing.T) {
+	ws := t.TempDir()
+	packages := filepath.Join(ws, "packages")
+	require.NoError(t, os.MkdirAll(filepath.Join(packages, "x86_64"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(packages, "aarch64"), 0o755))
+	for _, f := range []string{
+		filepath.Join(packages, "x86_64", "APKINDEX.tar.gz"),
+		filepath.Join(ws, "melange.rsa"),
+		filepath.Join(ws, "melange.rsa.pub"),
+		filepath.Join(packages, "melange.rsa.pub"),
+		filepath.Join(packages, "other.rsa.pub"),
+	} {
+		require.NoError(t, os.WriteFile(f, nil, 0o644))
+	}
+
+	want := []string{filepath.Join(ws, "melange.rsa.pub"), filepath.Join(packages, "other.rsa.pub")}
+	repo, keys, err := melangeWorkspace(ws)
+	require.NoError(t, err)
+	require.Equal(t, packages, repo)
+	require.Equal(t, want, keys)
+
+	// The packages directory itself works as well, without the keys of the
+	// workspace.
+	repo, keys, err = melangeWorkspace(packages)
+	require.NoError(t, err)
+	require.Equal(t, packages, repo)
+	require.Equal(t, []string{filepath.Join(packages, "melange.rsa.pub"), filepath.Join(packages, "other.rsa.pub")}, keys)
+
+	_, _, err = melangeWorkspace(filepath.Join(packages, "aarch64"))
+	require.ErrorContains(t, err, "no architecture directory with an APKINDEX.tar.gz found")
+	_, _, err = melangeWorkspace(filepath.Join(ws, "missing"))
+	require.ErrorContains(t, err, "reading melange workspace")
+
+	repos, keys, err := appendMelangeWorkspace("", []string{"https://example.com/os"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://example.com/os"}, repos)
+	require.Empty(t, keys)
+}
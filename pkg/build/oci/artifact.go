@@ -0,0 +1,83 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"encoding/json"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// artifactImage is an image whose manifest has an artifactType, which the
+// manifests of go-containerregistry do not have a field for. Mutating it drops
+// the artifactType, so it is set last.
+type artifactImage struct {
+	v1.Image
+	artifactType string
+}
+
+var _ v1.Image = (*artifactImage)(nil)
+
+// artifactManifest is v1.Manifest with its artifactType.
+type artifactManifest struct {
+	SchemaVersion int64             `json:"schemaVersion"`
+	MediaType     types.MediaType   `json:"mediaType,omitempty"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        v1.Descriptor     `json:"config"`
+	Layers        []v1.Descriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+	Subject       *v1.Descriptor    `json:"subject,omitempty"`
+}
+
+// WithArtifactType sets the artifactType of the manifest of img, and of its
+// descriptor in the indexes it is added to.
+func WithArtifactType(img v1.Image, artifactType string) v1.Image {
+	if artifactType == "" {
+		return img
+	}
+	return &artifactImage{Image: img, artifactType: artifactType}
+}
+
+// ArtifactType implements the interface go-containerregistry uses for the
+// artifactType of index descriptors.
+func (i *artifactImage) ArtifactType() (string, error) {
+	return i.artifactType, nil
+}
+
+func (i *artifactImage) RawManifest() ([]byte, error) {
+	m, err := i.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(artifactManifest{
+		SchemaVersion: m.SchemaVersion,
+		MediaType:     m.MediaType,
+		ArtifactType:  i.artifactType,
+		Config:        m.Config,
+		Layers:        m.Layers,
+		Annotations:   m.Annotations,
+		Subject:       m.Subject,
+	})
+}
+
+func (i *artifactImage) Digest() (v1.Hash, error) {
+	return partial.Digest(i)
+}
+
+func (i *artifactImage) Size() (int64, error) {
+	return partial.Size(i)
+}
@@ -0,0 +1,79 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestArtifactType(t *testing.T) {
+	ctx := context.Background()
+	layer := static.NewLayer([]byte("\x00asm"), ggcrtypes.OCILayer)
+	ic := types.ImageConfiguration{
+		ConfigMediaType: "application/vnd.wasm.config.v1+json",
+		ArtifactType:    "application/vnd.wasm.content.layer.v1+wasm",
+	}
+	arch := types.ParseArchitecture("amd64")
+
+	img, err := BuildImageFromLayer(ctx, empty.Image, layer, ic, time.Now(), arch)
+	require.NoError(t, err)
+	img = WithArtifactType(img, ic.ArtifactType)
+
+	raw, err := img.RawManifest()
+	require.NoError(t, err)
+	var mf struct {
+		MediaType    ggcrtypes.MediaType `json:"mediaType"`
+		ArtifactType string              `json:"artifactType"`
+		Config       v1.Descriptor       `json:"config"`
+	}
+	require.NoError(t, json.Unmarshal(raw, &mf))
+	require.Equal(t, ggcrtypes.OCIManifestSchema1, mf.MediaType)
+	require.Equal(t, ic.ArtifactType, mf.ArtifactType)
+	require.Equal(t, ggcrtypes.MediaType(ic.ConfigMediaType), mf.Config.MediaType)
+
+	sum := sha256.Sum256(raw)
+	digest, err := img.Digest()
+	require.NoError(t, err)
+	require.Equal(t, hex.EncodeToString(sum[:]), digest.Hex)
+	size, err := img.Size()
+	require.NoError(t, err)
+	require.Equal(t, int64(len(raw)), size)
+
+	_, idx, err := GenerateIndex(ctx, ic, map[types.Architecture]v1.Image{arch: img}, time.Now())
+	require.NoError(t, err)
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Len(t, im.Manifests, 1)
+	require.Equal(t, ic.ArtifactType, im.Manifests[0].ArtifactType)
+	require.Equal(t, digest, im.Manifests[0].Digest)
+
+	// Without an artifactType, images are left as they are.
+	plain, err := BuildImageFromLayer(ctx, empty.Image, layer, types.ImageConfiguration{}, time.Now(), arch)
+	require.NoError(t, err)
+	require.Equal(t, plain, WithArtifactType(plain, ""))
+}
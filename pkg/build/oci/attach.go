@@ -0,0 +1,191 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/chainguard-dev/clog"
+
+	"chainguard.dev/apko/pkg/build/types"
+	apkosbom "chainguard.dev/apko/pkg/sbom"
+)
+
+const (
+	// SBOMSignatureAnnotation holds the detached signature of an attached
+	// SBOM, when it was signed with a key.
+	SBOMSignatureAnnotation = "dev.chainguard.apko.sbom.signature"
+	// SBOMBundleAnnotation holds the Sigstore bundle of an attached SBOM,
+	// when it was signed keylessly.
+	SBOMBundleAnnotation = "dev.chainguard.apko.sbom.bundle"
+)
+
+// sbomArtifactTypes are the artifact types SBOMs are attached with, by format.
+var sbomArtifactTypes = map[string]string{
+	"spdx": "application/spdx+json",
+}
+
+// SBOMArtifactType returns the artifact type SBOMs of format are attached
+// with, and whether they can be attached at all.
+func SBOMArtifactType(format string) (string, bool) {
+	t, ok := sbomArtifactTypes[format]
+	return t, ok
+}
+
+// AttachSBOMs pushes each of sboms to repo as an OCI referrer of the image or
+// index it describes: the image of its architecture in idx, or idx itself for
+// the SBOMs of the index. Registries without the referrers API get the
+// referrers tag scheme instead. It returns the digests of the attached SBOMs.
+func AttachSBOMs(ctx context.Context, idx v1.ImageIndex, sboms []types.SBOM, repo name.Repository, remoteOpts ...remote.Option) ([]name.Digest, error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "AttachSBOMs")
+	defer span.End()
+
+	referrers, err := sbomReferrers(ctx, idx, sboms)
+	if err != nil {
+		return nil, err
+	}
+
+	attached := make([]name.Digest, len(referrers))
+	var g errgroup.Group
+	for i, r := range referrers {
+		h, err := r.img.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("attaching SBOM %s: %w", r.sbom.Path, err)
+		}
+		dig := repo.Digest(h.String())
+		attached[i] = dig
+
+		g.Go(func() error {
+			if err := remote.Write(dig, r.img, withProgress(ctx, dig.String(), remoteOpts)...); err != nil {
+				return &PushError{Ref: dig.String(), Err: err}
+			}
+			log.Infof("attached %s SBOM of %s as %s", r.sbom.Format, r.subject.Digest, dig)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return attached, nil
+}
+
+// sbomReferrer is the artifact an SBOM is attached as.
+type sbomReferrer struct {
+	sbom    types.SBOM
+	subject v1.Descriptor
+	img     v1.Image
+}
+
+// sbomReferrers returns the artifacts sboms are attached as, skipping those of
+// formats without an artifact type.
+func sbomReferrers(ctx context.Context, idx v1.ImageIndex, sboms []types.SBOM) ([]sbomReferrer, error) {
+	log := clog.FromContext(ctx)
+
+	subjects, err := sbomSubjects(idx)
+	if err != nil {
+		return nil, err
+	}
+
+	var referrers []sbomReferrer
+	for _, sbom := range sboms {
+		artifactType, ok := SBOMArtifactType(sbom.Format)
+		if !ok {
+			log.Warnf("not attaching %s SBOM %s: no artifact type for the format", sbom.Format, sbom.Path)
+			continue
+		}
+		subject, ok := subjects[sbom.Digest]
+		if !ok {
+			return nil, fmt.Errorf("attaching SBOM %s: %s is not in the index", sbom.Path, sbom.Digest)
+		}
+		img, err := sbomImage(sbom, artifactType, subject)
+		if err != nil {
+			return nil, fmt.Errorf("attaching SBOM %s: %w", sbom.Path, err)
+		}
+		referrers = append(referrers, sbomReferrer{sbom: sbom, subject: subject, img: img})
+	}
+	return referrers, nil
+}
+
+// sbomSubjects returns the descriptors of idx and of its manifests, by digest.
+func sbomSubjects(idx v1.ImageIndex) (map[v1.Hash]v1.Descriptor, error) {
+	m, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	subjects := make(map[v1.Hash]v1.Descriptor, len(m.Manifests)+1)
+	for _, desc := range m.Manifests {
+		subjects[desc.Digest] = v1.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}
+	}
+
+	mt, err := idx.MediaType()
+	if err != nil {
+		return nil, err
+	}
+	h, err := idx.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := idx.Size()
+	if err != nil {
+		return nil, err
+	}
+	subjects[h] = v1.Descriptor{MediaType: mt, Digest: h, Size: size}
+	return subjects, nil
+}
+
+// sbomImage returns an artifact manifest of the SBOM as its only layer, that
+// refers to subject. Its config media type is the artifact type too, as
+// registries predating artifactType took the artifact type from it.
+func sbomImage(sbom types.SBOM, artifactType string, subject v1.Descriptor) (v1.Image, error) {
+	b, err := os.ReadFile(sbom.Path)
+	if err != nil {
+		return nil, err
+	}
+	img, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: static.NewLayer(b, ggcrtypes.MediaType(artifactType)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	img = mutate.MediaType(img, ggcrtypes.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, ggcrtypes.MediaType(artifactType))
+	if sbom.SignaturePath != "" {
+		sig, err := os.ReadFile(sbom.SignaturePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading signature: %w", err)
+		}
+		annotation := SBOMSignatureAnnotation
+		if strings.HasSuffix(sbom.SignaturePath, apkosbom.BundleExt) {
+			annotation = SBOMBundleAnnotation
+		}
+		img = mutate.Annotations(img, map[string]string{annotation: string(sig)}).(v1.Image)
+	}
+	img = mutate.Subject(img, subject).(v1.Image)
+	return WithArtifactType(img, artifactType), nil
+}
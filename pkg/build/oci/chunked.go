@@ -0,0 +1,96 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// chunkedUploadTransport splits the blob uploads of go-containerregistry,
+// which are a single PATCH of the whole blob, into PATCHes of at most
+// chunkSize bytes, for registries that cap the size of requests.
+type chunkedUploadTransport struct {
+	rt        http.RoundTripper
+	chunkSize int64
+}
+
+// ChunkedUploadTransport returns a transport that uploads blobs through rt in
+// chunks of at most chunkSize bytes, or rt itself if chunkSize is not
+// positive.
+func ChunkedUploadTransport(rt http.RoundTripper, chunkSize int64) http.RoundTripper {
+	if chunkSize <= 0 {
+		return rt
+	}
+	return &chunkedUploadTransport{rt: rt, chunkSize: chunkSize}
+}
+
+func (t *chunkedUploadTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPatch || req.Body == nil || !strings.Contains(req.URL.Path, "/blobs/uploads/") {
+		return t.rt.RoundTrip(req)
+	}
+	if req.ContentLength >= 0 && req.ContentLength <= t.chunkSize {
+		return t.rt.RoundTrip(req)
+	}
+	defer req.Body.Close()
+
+	location := req.URL
+	body := bufio.NewReader(req.Body)
+	buf := make([]byte, t.chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(body, buf)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+		_, err = body.Peek(1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		last := err != nil
+
+		chunk := req.Clone(req.Context())
+		chunk.URL = location
+		chunk.Host = ""
+		data := buf[:n]
+		chunk.Body = io.NopCloser(bytes.NewReader(data))
+		chunk.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+		chunk.ContentLength = int64(n)
+		if n > 0 {
+			chunk.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+		}
+		resp, err := t.rt.RoundTrip(chunk)
+		if err != nil {
+			return nil, err
+		}
+		offset += int64(n)
+		// Registries answer chunks with 202 Accepted, though some with 204.
+		if last || (resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent) {
+			return resp, nil
+		}
+
+		next, err := location.Parse(resp.Header.Get("Location"))
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing upload location: %w", err)
+		}
+		location = next
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedUploadTransport(t *testing.T) {
+	require.Same(t, http.DefaultTransport, ChunkedUploadTransport(http.DefaultTransport, 0))
+
+	var mu sync.Mutex
+	var patches []int64
+	r := registry.New()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPatch {
+			mu.Lock()
+			patches = append(patches, req.ContentLength)
+			mu.Unlock()
+		}
+		r.ServeHTTP(w, req)
+	}))
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	// A blob of two and a half chunks, and one of exactly two.
+	for i, size := range []int{2560, 2048} {
+		patches = nil
+		blob := bytes.Repeat([]byte("apko"), size/4)
+		img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: static.NewLayer(blob, ggcrtypes.OCILayer)})
+		require.NoError(t, err)
+		ref, err := name.ParseReference(fmt.Sprintf("%s/test/chunked:%d", u.Host, i))
+		require.NoError(t, err)
+
+		require.NoError(t, remote.Write(ref, img, remote.WithTransport(ChunkedUploadTransport(s.Client().Transport, 1024))))
+
+		// The config is smaller than a chunk, and sent as it is.
+		m, err := img.Manifest()
+		require.NoError(t, err)
+		require.ElementsMatch(t, append(chunks(size, 1024), m.Config.Size), patches)
+
+		got, err := remote.Image(ref)
+		require.NoError(t, err)
+		require.Equal(t, mustDigest(t, img), mustDigest(t, got))
+		l, err := got.LayerByDigest(m.Layers[0].Digest)
+		require.NoError(t, err)
+		rc, err := l.Compressed()
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.Equal(t, blob, b)
+	}
+}
+
+func chunks(size, chunkSize int) []int64 {
+	var sizes []int64
+	for ; size > chunkSize; size -= chunkSize {
+		sizes = append(sizes, int64(chunkSize))
+	}
+	return append(sizes, int64(size))
+}
+
+func mustDigest(t *testing.T, img v1.Image) v1.Hash {
+	t.Helper()
+	h, err := img.Digest()
+	require.NoError(t, err)
+	return h
+}
@@ -0,0 +1,84 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// defaultEnvironment is set in images unless configured otherwise.
+var defaultEnvironment = map[string]string{
+	"PATH":          "/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+	"SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
+}
+
+var envReferenceRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvironment returns the environment of an image: the configured env
+// on top of the defaults, with the ${NAME} references of its values replaced
+// by the value of NAME. A variable referencing itself gets the default value,
+// so that "/opt/bin:${PATH}" extends the default PATH. References to variables
+// that are neither configured nor defaulted are left as they are.
+func expandEnvironment(env map[string]string) (map[string]string, error) {
+	merged := maps.Clone(defaultEnvironment)
+	maps.Copy(merged, env)
+
+	expanded := make(map[string]string, len(merged))
+	var expand func(key string, stack []string) (string, error)
+	expand = func(key string, stack []string) (string, error) {
+		if v, ok := expanded[key]; ok {
+			return v, nil
+		}
+		if slices.Contains(stack, key) {
+			return "", fmt.Errorf("environment variable %s references itself through %s", key, strings.Join(append(stack, key), " -> "))
+		}
+		stack = append(stack, key)
+
+		var err error
+		v := envReferenceRe.ReplaceAllStringFunc(merged[key], func(ref string) string {
+			name := envReferenceRe.FindStringSubmatch(ref)[1]
+			if name == key {
+				if def, ok := defaultEnvironment[name]; ok {
+					return def
+				}
+				return ref
+			}
+			if _, ok := merged[name]; !ok {
+				return ref
+			}
+			value, rerr := expand(name, stack)
+			if rerr != nil && err == nil {
+				err = rerr
+			}
+			return value
+		})
+		if err != nil {
+			return "", err
+		}
+		expanded[key] = v
+		return v, nil
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(merged)) {
+		if _, err := expand(key, nil); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
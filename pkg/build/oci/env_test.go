@@ -0,0 +1,82 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnvironment(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		env     map[string]string
+		want    map[string]string
+		wantErr string
+	}{{
+		name: "defaults",
+		want: defaultEnvironment,
+	}, {
+		name: "extend default",
+		env:  map[string]string{"PATH": "/opt/tool/bin:${PATH}"},
+		want: map[string]string{
+			"PATH":          "/opt/tool/bin:/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+			"SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
+		},
+	}, {
+		name: "other variables",
+		env: map[string]string{
+			"JAVA_HOME": "/usr/lib/jvm/default-jvm",
+			"PATH":      "${JAVA_HOME}/bin:${PATH}",
+			"TOOL":      "${UNDEFINED}/tool $HOME",
+		},
+		want: map[string]string{
+			"JAVA_HOME":     "/usr/lib/jvm/default-jvm",
+			"PATH":          "/usr/lib/jvm/default-jvm/bin:/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+			"SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
+			"TOOL":          "${UNDEFINED}/tool $HOME",
+		},
+	}, {
+		name: "chained",
+		env: map[string]string{
+			"A": "${B}/a",
+			"B": "${SSL_CERT_FILE}",
+		},
+		want: map[string]string{
+			"A":             "/etc/ssl/certs/ca-certificates.crt/a",
+			"B":             "/etc/ssl/certs/ca-certificates.crt",
+			"PATH":          "/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+			"SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
+		},
+	}, {
+		name: "cycle",
+		env: map[string]string{
+			"A": "${B}",
+			"B": "${A}",
+		},
+		wantErr: "environment variable A references itself through A -> B -> A",
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandEnvironment(tt.env)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
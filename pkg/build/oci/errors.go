@@ -0,0 +1,62 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// ErrRateLimited is matched by the errors of requests a registry rate limited,
+// even after retrying them.
+var ErrRateLimited = errors.New("rate limited by the registry")
+
+// PushError is returned when writing an image or index to a registry fails.
+// It matches apk.ErrAuthRequired when the registry rejected the credentials,
+// and ErrRateLimited when it rate limited the push.
+type PushError struct {
+	Ref string
+	Err error
+}
+
+func (e *PushError) Error() string {
+	if errors.Is(e, ErrRateLimited) {
+		return fmt.Sprintf("pushing %s: %v (rate limited by the registry: authenticate, or run fewer builds in parallel)", e.Ref, e.Err)
+	}
+	return fmt.Sprintf("pushing %s: %v", e.Ref, e.Err)
+}
+
+func (e *PushError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PushError) Is(target error) bool {
+	var terr *transport.Error
+	if !errors.As(e.Err, &terr) {
+		return false
+	}
+	switch target {
+	case apk.ErrAuthRequired:
+		return terr.StatusCode == http.StatusUnauthorized || terr.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return terr.StatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+func TestPublishIndexPushError(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer s.Close()
+
+	tag := strings.TrimPrefix(s.URL, "http://") + "/test:latest"
+	_, err := PublishIndex(context.Background(), empty.Index, []string{tag})
+	require.Error(t, err)
+
+	var perr *PushError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, tag, perr.Ref)
+	require.ErrorIs(t, err, apk.ErrAuthRequired)
+}
+
+func TestPublishIndexRateLimited(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer s.Close()
+
+	tag := strings.TrimPrefix(s.URL, "http://") + "/test:latest"
+	_, err := PublishIndex(context.Background(), empty.Index, []string{tag})
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.NotErrorIs(t, err, apk.ErrAuthRequired)
+	require.ErrorContains(t, err, "run fewer builds in parallel")
+}
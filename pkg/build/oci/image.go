@@ -16,9 +16,12 @@ package oci
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"maps"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -37,6 +40,40 @@ import (
 	"chainguard.dev/apko/pkg/options"
 )
 
+// The annotations of the layer descriptors of an image split into layers by
+// package, and the digest of their list of packages.
+const (
+	AnnotationLayerPackages       = "dev.chainguard.apko.packages"
+	AnnotationLayerPackagesDigest = "dev.chainguard.apko.packages.digest"
+)
+
+// layerPackages returns the packages whose files are in layer, as
+// "<name>=<version>", if it knows them.
+func layerPackages(layer v1.Layer) []string {
+	if l, ok := layer.(interface{ Packages() []string }); ok {
+		return l.Packages()
+	}
+	return nil
+}
+
+// layerCreated returns the newest build date of the packages of a layer, if
+// it knows them.
+func layerCreated(layer v1.Layer) time.Time {
+	if l, ok := layer.(interface{ Created() time.Time }); ok {
+		return l.Created()
+	}
+	return time.Time{}
+}
+
+// layerOrigins returns the origins of the packages of a layer, if it knows
+// them.
+func layerOrigins(layer v1.Layer) []string {
+	if l, ok := layer.(interface{ Origins() []string }); ok {
+		return l.Origins()
+	}
+	return nil
+}
+
 func BuildImageFromLayer(ctx context.Context, baseImage v1.Image, layer v1.Layer, oic types.ImageConfiguration, created time.Time, arch types.Architecture) (v1.Image, error) {
 	return BuildImageFromLayers(ctx, baseImage, []v1.Layer{layer}, oic, created, arch)
 }
@@ -53,13 +90,22 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 	// Compute comment
 	comment := "This is an apko single-layer image"
 	if len(layers) > 1 {
-		comment = ""
+		comment = "files not owned by packages"
 	}
 	title, titleok := ic.Annotations["org.opencontainers.image.title"]
 	vendor, vendorok := ic.Annotations["org.opencontainers.image.vendor"]
 	if titleok && vendorok {
 		comment = title + " by " + vendor
 	}
+	commentTmpl, err := ic.Layering.CommentTemplate()
+	if err != nil {
+		return nil, err
+	}
+	createdBy := "apko"
+	if ic.Layering != nil && ic.Layering.CreatedBy != "" {
+		createdBy = ic.Layering.CreatedBy
+	}
+	perLayerCreated := ic.Layering != nil && ic.Layering.Created == types.LayerCreatedPackages
 
 	adds := make([]mutate.Addendum, 0, len(layers))
 	for _, layer := range layers {
@@ -76,20 +122,51 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 		log.Infof("layer digest: %v", digest)
 		log.Infof("layer diffID: %v", diffid)
 
-		adds = append(adds, mutate.Addendum{
+		add := mutate.Addendum{
 			Layer: layer,
 			History: v1.History{
 				Author:    "apko",
 				Comment:   comment,
-				CreatedBy: "apko",
-				Created:   v1.Time{Time: created}, // TODO: Consider per-layer creation time?
+				CreatedBy: createdBy,
+				Created:   v1.Time{Time: created},
 			},
-		})
+		}
+		// Layers of packages can be dated by their packages, so that they
+		// keep their history when only other packages change, though never
+		// after the image itself.
+		if lc := layerCreated(layer); perLayerCreated && !lc.IsZero() && lc.Before(created) {
+			add.History.Created = v1.Time{Time: lc}
+		}
+		if pkgs := layerPackages(layer); len(pkgs) > 0 {
+			list := strings.Join(pkgs, ",")
+			sum := sha256.Sum256([]byte(list))
+			add.Annotations = map[string]string{
+				AnnotationLayerPackages:       list,
+				AnnotationLayerPackagesDigest: "sha256:" + hex.EncodeToString(sum[:]),
+			}
+			origins := strings.Join(layerOrigins(layer), " ")
+			if origins == "" {
+				origins = "packages"
+			}
+			add.History.Comment = origins + ": " + strings.Join(pkgs, " ")
+		}
+		if commentTmpl != nil {
+			var b strings.Builder
+			if err := commentTmpl.Execute(&b, types.LayerHistory{Origins: layerOrigins(layer), Packages: layerPackages(layer)}); err != nil {
+				return nil, fmt.Errorf("executing layering comment template: %w", err)
+			}
+			add.History.Comment = b.String()
+		}
+		adds = append(adds, add)
 	}
 
 	// If building an OCI layer, then we should assume OCI manifest and config too
 	baseImage = mutate.MediaType(baseImage, ggcrtypes.OCIManifestSchema1)
-	baseImage = mutate.ConfigMediaType(baseImage, ggcrtypes.OCIConfigJSON)
+	configMediaType := ggcrtypes.OCIConfigJSON
+	if ic.ConfigMediaType != "" {
+		configMediaType = ggcrtypes.MediaType(ic.ConfigMediaType)
+	}
+	baseImage = mutate.ConfigMediaType(baseImage, configMediaType)
 
 	v1Image, err := mutate.Append(baseImage, adds...)
 	if err != nil {
@@ -117,16 +194,23 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 
 	cfg = cfg.DeepCopy()
 	cfg.Author = "github.com/chainguard-dev/apko"
-	platform := arch.ToOCIPlatform()
+	platform := ic.OCIPlatform(arch)
 	cfg.Architecture = platform.Architecture
 	cfg.Variant = platform.Variant
 	cfg.Created = v1.Time{Time: created}
+	cfg.OS = platform.OS
+	cfg.OSVersion = platform.OSVersion
+	cfg.OSFeatures = platform.OSFeatures
 	cfg.Config.Labels = make(map[string]string)
-	cfg.OS = "linux"
-	cfg.Config.Labels = annotations
+	if ic.WantAnnotationsAsLabels() {
+		maps.Copy(cfg.Config.Labels, annotations)
+	}
+	maps.Copy(cfg.Config.Labels, ic.Labels)
 
 	// NOTE: Need to allow empty Entrypoints. The runtime will override to `/bin/sh -c` and handle quoting
 	switch {
+	case len(ic.Entrypoint.Exec) != 0:
+		cfg.Config.Entrypoint = slices.Clone(ic.Entrypoint.Exec)
 	case ic.Entrypoint.ShellFragment != "":
 		cfg.Config.Entrypoint = []string{"/bin/sh", "-c", ic.Entrypoint.ShellFragment}
 	case ic.Entrypoint.Command != "":
@@ -137,7 +221,9 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 		cfg.Config.Entrypoint = splitcmd
 	}
 
-	if ic.Cmd != "" {
+	if len(ic.CmdExec) != 0 {
+		cfg.Config.Cmd = slices.Clone(ic.CmdExec)
+	} else if ic.Cmd != "" {
 		splitcmd, err := shlex.Split(ic.Cmd)
 		if err != nil {
 			return nil, fmt.Errorf("unable to parse cmd: %w", err)
@@ -156,18 +242,9 @@ func BuildImageFromLayers(ctx context.Context, baseImage v1.Image, layers []v1.L
 		}
 	}
 
-	env := maps.Clone(ic.Environment)
-	// Set these environment variables if they are not already set.
-	if env == nil {
-		env = map[string]string{}
-	}
-	for k, v := range map[string]string{
-		"PATH":          "/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
-		"SSL_CERT_FILE": "/etc/ssl/certs/ca-certificates.crt",
-	} {
-		if _, found := env[k]; !found {
-			env[k] = v
-		}
+	env, err := expandEnvironment(ic.Environment)
+	if err != nil {
+		return nil, fmt.Errorf("expanding environment: %w", err)
 	}
 	envs := []string{}
 	for k, v := range env {
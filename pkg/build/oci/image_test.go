@@ -155,6 +155,92 @@ func TestBuildImageFromLayer(t *testing.T) {
 				},
 			},
 		},
+	}, {
+		desc: "exec form",
+		cfg: types.ImageConfiguration{
+			Entrypoint: types.ImageEntrypoint{
+				Exec: []string{"/bin/sh", "-c", `echo "hello world" 'and more'`},
+			},
+			CmdExec: []string{"two words"},
+		},
+		want: &v1.ConfigFile{
+			Author: "github.com/chainguard-dev/apko",
+			History: []v1.History{{
+				Created:   v1now,
+				Author:    "apko",
+				CreatedBy: "apko",
+				Comment:   "This is an apko single-layer image",
+			}},
+			Created: v1now,
+			OS:      "linux",
+			RootFS:  v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{diffID}},
+			Config: v1.Config{
+				Entrypoint: []string{"/bin/sh", "-c", `echo "hello world" 'and more'`},
+				Cmd:        []string{"two words"},
+				Env: []string{
+					"PATH=/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+					"SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt",
+				},
+				Labels: map[string]string{
+					"org.opencontainers.image.created": now.Format(time.RFC3339),
+				},
+			},
+		},
+	}, {
+		desc: "labels",
+		cfg: types.ImageConfiguration{
+			Annotations: map[string]string{"foo": "bar", "baz": "annotation"},
+			Labels:      map[string]string{"baz": "label"},
+		},
+		want: &v1.ConfigFile{
+			Author: "github.com/chainguard-dev/apko",
+			History: []v1.History{{
+				Created:   v1now,
+				Author:    "apko",
+				CreatedBy: "apko",
+				Comment:   "This is an apko single-layer image",
+			}},
+			Created: v1now,
+			OS:      "linux",
+			RootFS:  v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{diffID}},
+			Config: v1.Config{
+				Env: []string{
+					"PATH=/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+					"SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt",
+				},
+				Labels: map[string]string{
+					"org.opencontainers.image.created": now.Format(time.RFC3339),
+					"foo":                              "bar",
+					"baz":                              "label",
+				},
+			},
+		},
+	}, {
+		desc: "annotations not mirrored into labels",
+		cfg: types.ImageConfiguration{
+			Annotations:         map[string]string{"foo": "bar"},
+			Labels:              map[string]string{"baz": "qux"},
+			AnnotationsAsLabels: ptr(false),
+		},
+		want: &v1.ConfigFile{
+			Author: "github.com/chainguard-dev/apko",
+			History: []v1.History{{
+				Created:   v1now,
+				Author:    "apko",
+				CreatedBy: "apko",
+				Comment:   "This is an apko single-layer image",
+			}},
+			Created: v1now,
+			OS:      "linux",
+			RootFS:  v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{diffID}},
+			Config: v1.Config{
+				Env: []string{
+					"PATH=/usr/local/sbin:/usr/local/bin:/usr/bin:/usr/sbin:/sbin:/bin",
+					"SSL_CERT_FILE=/etc/ssl/certs/ca-certificates.crt",
+				},
+				Labels: map[string]string{"baz": "qux"},
+			},
+		},
 	}} {
 		t.Run(c.desc, func(t *testing.T) {
 			ctx := context.Background()
@@ -169,3 +255,147 @@ func TestBuildImageFromLayer(t *testing.T) {
 		})
 	}
 }
+
+// packagesLayer is a layer that knows the packages whose files are in it.
+type packagesLayer struct {
+	v1.Layer
+	packages []string
+}
+
+func (l packagesLayer) Packages() []string { return l.packages }
+
+func TestBuildImageFromLayersPackages(t *testing.T) {
+	pkgLayer := packagesLayer{static.NewLayer([]byte("glibc"), ggcrtypes.OCILayer), []string{"glibc=2.40-r3", "glibc-locale-posix=2.40-r3"}}
+	top := static.NewLayer([]byte("top"), ggcrtypes.OCILayer)
+	img, err := BuildImageFromLayers(context.Background(), empty.Image, []v1.Layer{pkgLayer, top}, types.ImageConfiguration{}, time.Now(), types.ParseArchitecture("amd64"))
+	require.NoError(t, err)
+
+	m, err := img.Manifest()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		AnnotationLayerPackages:       "glibc=2.40-r3,glibc-locale-posix=2.40-r3",
+		AnnotationLayerPackagesDigest: "sha256:000b9b475f3eb79d18a37af57482874d73a5e243a7831f51a6a7f2cec1c8bfc0",
+	}, m.Layers[0].Annotations)
+	require.Empty(t, m.Layers[1].Annotations)
+
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, "packages: glibc=2.40-r3 glibc-locale-posix=2.40-r3", cfg.History[0].Comment)
+	require.Equal(t, "files not owned by packages", cfg.History[1].Comment)
+}
+
+// originsLayer is a packagesLayer that also knows the origins of its packages.
+type originsLayer struct {
+	packagesLayer
+	origins []string
+}
+
+func (l originsLayer) Origins() []string { return l.origins }
+
+func TestBuildImageFromLayersHistory(t *testing.T) {
+	pkgLayer := originsLayer{packagesLayer{static.NewLayer([]byte("glibc"), ggcrtypes.OCILayer), []string{"glibc=2.40-r3", "glibc-locale-posix=2.40-r3"}}, []string{"glibc"}}
+	top := static.NewLayer([]byte("top"), ggcrtypes.OCILayer)
+	layers := []v1.Layer{pkgLayer, top}
+	arch := types.ParseArchitecture("amd64")
+
+	img, err := BuildImageFromLayers(context.Background(), empty.Image, layers, types.ImageConfiguration{}, time.Now(), arch)
+	require.NoError(t, err)
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, "glibc: glibc=2.40-r3 glibc-locale-posix=2.40-r3", cfg.History[0].Comment)
+	require.Equal(t, "apko", cfg.History[0].CreatedBy)
+
+	ic := types.ImageConfiguration{Layering: &types.Layering{
+		Strategy:  "origin",
+		Comment:   `{{if .Packages}}{{join .Origins ","}} ({{len .Packages}} packages){{else}}top{{end}}`,
+		CreatedBy: "apko build example.apko.yaml",
+	}}
+	_, err = BuildImageFromLayers(context.Background(), empty.Image, layers, ic, time.Now(), arch)
+	require.ErrorContains(t, err, "parsing layering comment template")
+
+	ic.Layering.Comment = `{{if .Packages}}{{index .Origins 0}} ({{len .Packages}} packages){{else}}top{{end}}`
+	img, err = BuildImageFromLayers(context.Background(), empty.Image, layers, ic, time.Now(), arch)
+	require.NoError(t, err)
+	cfg, err = img.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, "glibc (2 packages)", cfg.History[0].Comment)
+	require.Equal(t, "top", cfg.History[1].Comment)
+	for _, h := range cfg.History {
+		require.Equal(t, "apko build example.apko.yaml", h.CreatedBy)
+	}
+}
+
+func ptr[T any](v T) *T { return &v }
+
+// createdLayer is a packagesLayer that also knows the build date of its
+// packages.
+type createdLayer struct {
+	packagesLayer
+	created time.Time
+}
+
+func (l createdLayer) Created() time.Time { return l.created }
+
+func TestBuildImageFromLayersCreated(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	built := now.Add(-48 * time.Hour)
+	layers := []v1.Layer{
+		createdLayer{packagesLayer{static.NewLayer([]byte("glibc"), ggcrtypes.OCILayer), []string{"glibc=2.40-r3"}}, built},
+		createdLayer{packagesLayer{static.NewLayer([]byte("future"), ggcrtypes.OCILayer), []string{"future=1.0-r0"}}, now.Add(time.Hour)},
+		static.NewLayer([]byte("top"), ggcrtypes.OCILayer),
+	}
+	arch := types.ParseArchitecture("amd64")
+
+	created := func(ic types.ImageConfiguration) []time.Time {
+		img, err := BuildImageFromLayers(context.Background(), empty.Image, layers, ic, now, arch)
+		require.NoError(t, err)
+		cfg, err := img.ConfigFile()
+		require.NoError(t, err)
+		var times []time.Time
+		for _, h := range cfg.History {
+			times = append(times, h.Created.Time)
+		}
+		return times
+	}
+
+	// By default every layer is created with the image.
+	require.Equal(t, []time.Time{now, now, now}, created(types.ImageConfiguration{}))
+
+	// Layers of packages are dated by their packages, but never after the
+	// image.
+	ic := types.ImageConfiguration{Layering: &types.Layering{Strategy: "origin", Created: types.LayerCreatedPackages}}
+	require.Equal(t, []time.Time{built, now, now}, created(ic))
+}
+
+func TestBuildImageFromLayersPlatform(t *testing.T) {
+	ctx := context.Background()
+	layer := static.NewLayer([]byte("hello"), ggcrtypes.OCILayer)
+	arch := types.ParseArchitecture("arm64")
+	ic := types.ImageConfiguration{Platform: &types.ImagePlatform{
+		OSVersion:  "10.0.20348.2582",
+		OSFeatures: []string{"sse4"},
+		Variants:   map[string]string{"aarch64": "v8.2", "arm/v7": "v7"},
+	}}
+
+	img, err := BuildImageFromLayer(ctx, empty.Image, layer, ic, time.Now(), arch)
+	require.NoError(t, err)
+	cfg, err := img.ConfigFile()
+	require.NoError(t, err)
+	require.Equal(t, "linux", cfg.OS)
+	require.Equal(t, "arm64", cfg.Architecture)
+	require.Equal(t, "v8.2", cfg.Variant)
+	require.Equal(t, "10.0.20348.2582", cfg.OSVersion)
+	require.Equal(t, []string{"sse4"}, cfg.OSFeatures)
+
+	_, idx, err := GenerateIndex(ctx, ic, map[types.Architecture]v1.Image{arch: img}, time.Now())
+	require.NoError(t, err)
+	im, err := idx.IndexManifest()
+	require.NoError(t, err)
+	require.Equal(t, &v1.Platform{
+		OS:           "linux",
+		Architecture: "arm64",
+		Variant:      "v8.2",
+		OSVersion:    "10.0.20348.2582",
+		OSFeatures:   []string{"sse4"},
+	}, im.Manifests[0].Platform)
+}
@@ -30,6 +30,7 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
 	"go.opentelemetry.io/otel"
@@ -97,13 +98,19 @@ func generateIndexWithMediaType(mediaType ggcrtypes.MediaType, ic types.ImageCon
 			return name.Digest{}, nil, fmt.Errorf("failed to compute size: %w", err)
 		}
 
+		artifactType, err := partial.ArtifactType(img)
+		if err != nil {
+			return name.Digest{}, nil, fmt.Errorf("failed to get artifact type: %w", err)
+		}
+
 		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
 			Add: img,
 			Descriptor: v1.Descriptor{
-				MediaType: mt,
-				Digest:    h,
-				Size:      size,
-				Platform:  arch.ToOCIPlatform(),
+				MediaType:    mt,
+				Digest:       h,
+				Size:         size,
+				Platform:     ic.OCIPlatform(arch),
+				ArtifactType: artifactType,
 			},
 		})
 	}
@@ -0,0 +1,140 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// PublishPlan is what publishing an index would push, without pushing it.
+type PublishPlan struct {
+	// Images are the images of the index.
+	Images []PlannedManifest
+	// Index is the index itself, pushed to each of Tags.
+	Index PlannedManifest
+	Tags  []name.Reference
+	// SBOMs are the SBOMs that would be attached, if any.
+	SBOMs []PlannedManifest
+}
+
+// PlannedManifest is a manifest of a PublishPlan.
+type PlannedManifest struct {
+	Ref name.Digest
+	// Platform is the platform of an image, or nil.
+	Platform *v1.Platform
+	// Subject is the digest of the image or index an SBOM refers to.
+	Subject v1.Hash
+	// Blobs is the number of distinct blobs, layers and config, of an image.
+	Blobs int
+	// Size is the size of the manifest and of its blobs, in bytes.
+	Size int64
+}
+
+// PlanPublish returns what PublishImagesFromIndex, PublishIndex and, for
+// sboms, AttachSBOMs would push for idx to tags. Only the index is pushed to
+// each tag; the images and SBOMs go to the repository of the first tag.
+func PlanPublish(ctx context.Context, idx v1.ImageIndex, tags []string, sboms []types.SBOM) (*PublishPlan, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "PlanPublish")
+	defer span.End()
+
+	var plan PublishPlan
+	for _, tag := range tags {
+		ref, err := name.ParseReference(tag)
+		if err != nil {
+			return nil, fmt.Errorf("parsing tag %q: %w", tag, err)
+		}
+		plan.Tags = append(plan.Tags, ref)
+	}
+	if len(plan.Tags) == 0 {
+		return nil, fmt.Errorf("no tags to publish to")
+	}
+	repo := plan.Tags[0].Context()
+
+	m, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index manifest: %w", err)
+	}
+	for _, desc := range m.Manifests {
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get image for %v from index: %w", desc, err)
+		}
+		planned, err := planImage(repo, img)
+		if err != nil {
+			return nil, err
+		}
+		planned.Platform = desc.Platform
+		plan.Images = append(plan.Images, planned)
+	}
+
+	h, err := idx.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := idx.Size()
+	if err != nil {
+		return nil, err
+	}
+	plan.Index = PlannedManifest{Ref: repo.Digest(h.String()), Size: size}
+
+	referrers, err := sbomReferrers(ctx, idx, sboms)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range referrers {
+		planned, err := planImage(repo, r.img)
+		if err != nil {
+			return nil, err
+		}
+		planned.Subject = r.subject.Digest
+		plan.SBOMs = append(plan.SBOMs, planned)
+	}
+	return &plan, nil
+}
+
+// planImage returns the manifest of img in repo, with its blobs.
+func planImage(repo name.Repository, img v1.Image) (PlannedManifest, error) {
+	h, err := img.Digest()
+	if err != nil {
+		return PlannedManifest{}, err
+	}
+	size, err := img.Size()
+	if err != nil {
+		return PlannedManifest{}, err
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		return PlannedManifest{}, err
+	}
+
+	planned := PlannedManifest{Ref: repo.Digest(h.String()), Size: size}
+	seen := map[v1.Hash]bool{}
+	for _, desc := range append([]v1.Descriptor{m.Config}, m.Layers...) {
+		if seen[desc.Digest] {
+			continue
+		}
+		seen[desc.Digest] = true
+		planned.Blobs++
+		planned.Size += desc.Size
+	}
+	return planned, nil
+}
@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
@@ -28,6 +29,8 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/chainguard-dev/clog"
+
+	"chainguard.dev/apko/pkg/progress"
 )
 
 func LoadImage(ctx context.Context, image v1.Image, tags []string) (name.Reference, error) {
@@ -70,6 +73,8 @@ func LoadImage(ctx context.Context, image v1.Image, tags []string) (name.Referen
 // PublishIndex will determine that platform and use it to publish the updated index.
 func PublishIndex(ctx context.Context, idx v1.ImageIndex, tags []string, remoteOpts ...remote.Option) (name.Digest, error) {
 	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "PublishIndex")
+	defer span.End()
 
 	// TODO(jason): Also set annotations on the index.
 
@@ -97,7 +102,10 @@ func PublishIndex(ctx context.Context, idx v1.ImageIndex, tags []string, remoteO
 		}
 
 		g.Go(func() error {
-			return remote.WriteIndex(ref, idx, remoteOpts...)
+			if err := remote.WriteIndex(ref, idx, withProgress(ctx, ref.String(), remoteOpts)...); err != nil {
+				return &PushError{Ref: ref.String(), Err: err}
+			}
+			return nil
 		})
 	}
 	if err := g.Wait(); err != nil {
@@ -171,7 +179,10 @@ func PublishImagesFromIndex(ctx context.Context, idx v1.ImageIndex, repo name.Re
 				return fmt.Errorf("failed to get image for %v from index: %w", m, err)
 			}
 
-			return remote.Write(dig, img, remoteOpts...)
+			if err := remote.Write(dig, img, withProgress(ctx, dig.String(), remoteOpts)...); err != nil {
+				return &PushError{Ref: dig.String(), Err: err}
+			}
+			return nil
 		})
 	}
 	if err := g.Wait(); err != nil {
@@ -179,3 +190,27 @@ func PublishImagesFromIndex(ctx context.Context, idx v1.ImageIndex, repo name.Re
 	}
 	return digests, nil
 }
+
+// withProgress appends a remote.WithProgress option that forwards upload
+// progress for ref to the progress.Reporter on ctx, if there is one.
+// Each remote write closes its progress channel, so every call needs its own.
+func withProgress(ctx context.Context, ref string, remoteOpts []remote.Option) []remote.Option {
+	if !progress.Enabled(ctx) {
+		return remoteOpts
+	}
+
+	updates := make(chan v1.Update, 16)
+	go func() {
+		var last v1.Update
+		for u := range updates {
+			if u.Error != nil {
+				continue
+			}
+			last = u
+			progress.Report(ctx, progress.Event{Phase: progress.PhasePublish, Name: ref, Current: u.Complete, Total: u.Total})
+		}
+		progress.Report(ctx, progress.Event{Phase: progress.PhasePublish, Name: ref, Current: last.Complete, Total: last.Total, Done: true})
+	}()
+
+	return append(slices.Clone(remoteOpts), remote.WithProgress(updates))
+}
@@ -0,0 +1,114 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// Rate limited requests are retried up to rateLimitRetries times, after the
+// Retry-After of the response or else an exponential backoff from
+// rateLimitBackoff, with jitter, of at most maxRateLimitBackoff. Requests told
+// to retry after longer than that are not retried.
+const (
+	rateLimitRetries    = 5
+	rateLimitBackoff    = 2 * time.Second
+	maxRateLimitBackoff = time.Minute
+)
+
+// rateLimitTransport retries the requests registries answer with 429 Too Many
+// Requests. The retries of go-containerregistry span seconds, which is too
+// soon for registries shared by many parallel builds.
+type rateLimitTransport struct {
+	rt http.RoundTripper
+	// sleep waits for d, or returns false if req was canceled first.
+	sleep func(req *http.Request, d time.Duration) bool
+}
+
+// RateLimitTransport returns a transport that sends requests through rt,
+// retrying those that are rate limited after a jittered backoff.
+func RateLimitTransport(rt http.RoundTripper) http.RoundTripper {
+	return &rateLimitTransport{rt: rt, sleep: sleepFor}
+}
+
+func sleepFor(req *http.Request, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-req.Context().Done():
+		return false
+	}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	log := clog.FromContext(req.Context())
+	for attempt := 0; ; attempt++ {
+		resp, err := t.rt.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || attempt == rateLimitRetries {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			// The body is consumed and cannot be sent again.
+			return resp, nil
+		}
+
+		wait := retryAfter(resp, time.Now())
+		if wait > maxRateLimitBackoff {
+			log.Warnf("%s rate limited %s %s, and asks to retry in %s: not waiting that long", req.URL.Host, req.Method, req.URL.Path, wait.Round(time.Second))
+			return resp, nil
+		}
+		if wait <= 0 {
+			wait = rateLimitBackoff << attempt
+		}
+		wait = min(wait+rand.N(wait/4+1), maxRateLimitBackoff) //nolint:gosec // Jitter does not need to be secure
+		log.Warnf("%s rate limited %s %s, retrying in %s (%d of %d)", req.URL.Host, req.Method, req.URL.Path, wait.Round(time.Second), attempt+1, rateLimitRetries)
+		resp.Body.Close()
+
+		if !t.sleep(req, wait) {
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryAfter returns how long the Retry-After header of resp asks to wait,
+// in seconds or until a date, or 0 if it has none.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
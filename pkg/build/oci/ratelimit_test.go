@@ -0,0 +1,113 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package oci
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitTransport(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		limited    int
+		retryAfter string
+		wantStatus int
+		wantWaits  int
+	}{{
+		name:       "recovers",
+		limited:    2,
+		wantStatus: http.StatusOK,
+		wantWaits:  2,
+	}, {
+		name:       "retry after",
+		limited:    1,
+		retryAfter: "3",
+		wantStatus: http.StatusOK,
+		wantWaits:  1,
+	}, {
+		name:       "exhausted",
+		limited:    rateLimitRetries + 1,
+		wantStatus: http.StatusTooManyRequests,
+		wantWaits:  rateLimitRetries,
+	}, {
+		name:       "retry after too long",
+		limited:    1,
+		retryAfter: "3600",
+		wantStatus: http.StatusTooManyRequests,
+		wantWaits:  0,
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				requests++
+				body, err := io.ReadAll(req.Body)
+				require.NoError(t, err)
+				require.Equal(t, "blob", string(body))
+				if requests <= tt.limited {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(http.StatusTooManyRequests)
+				}
+			}))
+			defer s.Close()
+
+			var waits []time.Duration
+			rt := &rateLimitTransport{rt: s.Client().Transport, sleep: func(_ *http.Request, d time.Duration) bool {
+				waits = append(waits, d)
+				return true
+			}}
+			req, err := http.NewRequest(http.MethodPatch, s.URL+"/v2/test/blobs/uploads/1", strings.NewReader("blob"))
+			require.NoError(t, err)
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			require.Len(t, waits, tt.wantWaits)
+			for i, d := range waits {
+				want := rateLimitBackoff << i
+				if tt.retryAfter != "" {
+					want = 3 * time.Second
+				}
+				require.GreaterOrEqual(t, d, min(want, maxRateLimitBackoff))
+				require.LessOrEqual(t, d, min(want+want/4, maxRateLimitBackoff))
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for v, want := range map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"Wed, 01 Jan 2025 00:00:30 GMT": 30 * time.Second,
+		"soon":                          0,
+	} {
+		resp := &http.Response{Header: http.Header{}}
+		if v != "" {
+			resp.Header.Set("Retry-After", v)
+		}
+		require.Equal(t, want, retryAfter(resp, now), v)
+	}
+}
@@ -15,14 +15,22 @@
 package build
 
 import (
+	"bytes"
 	"context"
 	sha2562 "crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"io"
+	"io/fs"
 	"maps"
 	"net/http"
+	"sync"
+	"text/template"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/auth"
 	"chainguard.dev/apko/pkg/build/types"
@@ -58,6 +66,100 @@ func WithConfig(configFile string, includePaths []string) Option {
 	}
 }
 
+// WithConfigReader sets the image configuration for the build context,
+// parsed from r instead of a file on disk. Includes are resolved against
+// includePaths on the local filesystem.
+//
+// r is read once, the first time the option is applied, so the option can be
+// reused for each architecture of a multi-arch build.
+func WithConfigReader(r io.Reader, includePaths []string) Option {
+	read := sync.OnceValues(func() ([]byte, error) { return io.ReadAll(r) })
+	return func(bc *Context) error {
+		data, err := read()
+		if err != nil {
+			return fmt.Errorf("reading image configuration: %w", err)
+		}
+
+		var ic types.ImageConfiguration
+		hasher := sha2562.New()
+		if err := ic.LoadReader(context.Background(), bytes.NewReader(data), includePaths, hasher); err != nil {
+			return fmt.Errorf("failed to load image configuration: %w", err)
+		}
+
+		bc.ic = ic
+		bc.o.ImageConfigChecksum = "sha256-" + base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+
+		return nil
+	}
+}
+
+// WithConfigFS sets the image configuration for the build context, parsed
+// from the file at configFile in fsys. Includes are also read from fsys.
+func WithConfigFS(fsys fs.FS, configFile string) Option {
+	return func(bc *Context) error {
+		var ic types.ImageConfiguration
+		hasher := sha2562.New()
+		if err := ic.LoadFS(context.Background(), fsys, configFile, hasher); err != nil {
+			return fmt.Errorf("failed to load image configuration: %w", err)
+		}
+
+		bc.ic = ic
+		bc.o.ImageConfigFile = configFile
+		bc.o.ImageConfigChecksum = "sha256-" + base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+
+		return nil
+	}
+}
+
+// WithKeychain sets the keychain used to authenticate to registries, e.g.
+// when pulling a remote base image.
+func WithKeychain(kc authn.Keychain) Option {
+	return func(bc *Context) error {
+		bc.o.Keychain = kc
+		return nil
+	}
+}
+
+// WithRemoteOptions adds opts to the options of registry requests, e.g. when
+// pulling a remote base image. They are applied after those apko sets, so
+// they can override its transport and keychain.
+func WithRemoteOptions(opts ...remote.Option) Option {
+	return func(bc *Context) error {
+		bc.o.RemoteOptions = append(bc.o.RemoteOptions, opts...)
+		return nil
+	}
+}
+
+// WithStrictReproducibility makes the build fail on input that would make the
+// layers nondeterministic, such as files newer than the build date, instead
+// of writing it as is.
+func WithStrictReproducibility(strict bool) Option {
+	return func(bc *Context) error {
+		bc.o.StrictReproducibility = strict
+		return nil
+	}
+}
+
+// WithBuildInfo embeds a build-info document (the apko version and the
+// digests of the configuration, lockfile and repository indexes) in each image
+// as a label and an annotation.
+func WithBuildInfo(enabled bool) Option {
+	return func(bc *Context) error {
+		bc.o.BuildInfo = enabled
+		return nil
+	}
+}
+
+// WithBootc lays the image out for bootc and OSTree hosts, with composefs
+// enabled, and labels it as a bootable container. The build fails if the image
+// is not /usr-merged or has no kernel in /usr/lib/modules.
+func WithBootc(enabled bool) Option {
+	return func(bc *Context) error {
+		bc.o.Bootc = enabled
+		return nil
+	}
+}
+
 // WithTags sets the tags for the build context.
 func WithTags(tags ...string) Option {
 	return func(bc *Context) error {
@@ -130,6 +232,70 @@ func WithSBOMGenerators(generators ...generator.Generator) Option {
 	}
 }
 
+// WithSBOMDetectLicenses sets whether the SBOM records the licenses found in
+// the license files of packages that declare none.
+func WithSBOMDetectLicenses(detect bool) Option {
+	return func(bc *Context) error {
+		bc.o.SBOMDetectLicenses = detect
+		return nil
+	}
+}
+
+// WithSBOMCPEs sets whether the SBOM has CPEs for the packages, using the
+// CPE vendors and products of the YAML file at mapping, if any, before the
+// built-in ones.
+func WithSBOMCPEs(cpes bool, mapping string) Option {
+	return func(bc *Context) error {
+		bc.o.SBOMCPEs = cpes
+		bc.o.SBOMCPEMapping = mapping
+		return nil
+	}
+}
+
+// WithSBOMSigningKey signs the SBOMs with the PEM private key at path, or the
+// KMS key of that URI, writing a detached signature next to each of them.
+func WithSBOMSigningKey(path string) Option {
+	return func(bc *Context) error {
+		bc.o.SBOMSigningKey = path
+		return nil
+	}
+}
+
+// WithSBOMKeyless signs the SBOMs keylessly, with a certificate from the
+// Fulcio at fulcioURL for the identity token in $SIGSTORE_ID_TOKEN, logging
+// the signatures in the Rekor at rekorURL if set. A Sigstore bundle is
+// written next to each of them.
+func WithSBOMKeyless(keyless bool, fulcioURL, rekorURL string) Option {
+	return func(bc *Context) error {
+		bc.o.SBOMKeyless = keyless
+		bc.o.SBOMFulcioURL = fulcioURL
+		bc.o.SBOMRekorURL = rekorURL
+		return nil
+	}
+}
+
+// WithSBOMNameTemplate names the SBOMs with a text/template of their .Arch
+// ("index" for the index), .Digest, .Format and .Ext, rather than
+// sbom-<arch>.<ext>.
+func WithSBOMNameTemplate(tmpl string) Option {
+	return func(bc *Context) error {
+		if _, err := template.New("sbom").Parse(tmpl); err != nil {
+			return fmt.Errorf("parsing SBOM name template: %w", err)
+		}
+		bc.o.SBOMNameTemplate = tmpl
+		return nil
+	}
+}
+
+// WithSBOMFiles sets whether the SBOM lists the files of the image, with
+// their checksums and the package that installed them.
+func WithSBOMFiles(files bool) Option {
+	return func(bc *Context) error {
+		bc.o.SBOMFiles = files
+		return nil
+	}
+}
+
 func WithExtraKeys(keys []string) Option {
 	return func(bc *Context) error {
 		bc.o.ExtraKeyFiles = keys
@@ -174,6 +340,33 @@ func WithImageConfiguration(ic types.ImageConfiguration) Option {
 	}
 }
 
+// WithVariant builds the variant called name of the image configuration,
+// which must already be set, instead of the configuration itself. The empty
+// name builds the configuration without its variants.
+func WithVariant(name string) Option {
+	return func(bc *Context) error {
+		ic, err := bc.ic.Variant(name)
+		if err != nil {
+			return err
+		}
+		bc.ic = ic
+		return nil
+	}
+}
+
+// WithProfile applies the profile called name to the image configuration,
+// which must already be set. The empty name applies none.
+func WithProfile(name string) Option {
+	return func(bc *Context) error {
+		ic, err := bc.ic.Profile(name)
+		if err != nil {
+			return err
+		}
+		bc.ic = ic
+		return nil
+	}
+}
+
 // WithArch sets the architecture for the build context.
 func WithArch(arch types.Architecture) Option {
 	return func(bc *Context) error {
@@ -202,6 +395,15 @@ func WithAnnotations(annotations map[string]string) Option {
 	}
 }
 
+// WithAnnotationsAsLabels overrides whether the annotations are also set as
+// labels in the image configs.
+func WithAnnotationsAsLabels(enabled bool) Option {
+	return func(bc *Context) error {
+		bc.ic.AnnotationsAsLabels = &enabled
+		return nil
+	}
+}
+
 // WithCache set the cache directory to use
 func WithCache(cacheDir string, offline bool, shared *apk.Cache) Option {
 	return func(bc *Context) error {
@@ -212,6 +414,15 @@ func WithCache(cacheDir string, offline bool, shared *apk.Cache) Option {
 	}
 }
 
+// WithRemoteCache shares the downloaded packages and indexes through rc,
+// such as a bucket that ephemeral CI runners all use.
+func WithRemoteCache(rc apk.RemoteCache) Option {
+	return func(bc *Context) error {
+		bc.o.RemoteCache = rc
+		return nil
+	}
+}
+
 func WithLockFile(lockFile string) Option {
 	return func(bc *Context) error {
 		bc.o.Lockfile = lockFile
@@ -242,6 +453,74 @@ func WithIgnoreSignatures(ignore bool) Option {
 	}
 }
 
+// WithFIPS restricts TLS, and the verification of repository signatures, to
+// FIPS-approved algorithms. Repositories that are only signed with
+// non-approved algorithms fail to load.
+func WithFIPS(fips bool) Option {
+	return func(bc *Context) error {
+		bc.o.FIPS = fips
+		return nil
+	}
+}
+
+// WithRepositoryTLS configures TLS, such as custom CAs, client certificates
+// or the minimum TLS version, for the requests to the repositories and
+// keyring URLs matching each of configs.
+func WithRepositoryTLS(configs ...apk.RepositoryTLS) Option {
+	return func(bc *Context) error {
+		bc.o.RepositoryTLS = append(bc.o.RepositoryTLS, configs...)
+		return nil
+	}
+}
+
+// WithPackagePolicy sets the path to a policy file with allow and deny rules
+// for the resolved packages. The build fails if any package is not allowed,
+// reporting the dependency chains that pull it in. An empty path disables the
+// check.
+func WithPackagePolicy(path string) Option {
+	return func(bc *Context) error {
+		bc.o.PackagePolicy = path
+		return nil
+	}
+}
+
+// WithMelangeWorkspace adds the packages built by melange in dir, the
+// workspace or its packages directory, as a build repository, and the public
+// keys found there, such as those of `melange keygen`, to the keyring. An empty
+// dir adds nothing.
+func WithMelangeWorkspace(dir string) Option {
+	return func(bc *Context) error {
+		bc.o.MelangeWorkspace = dir
+		return nil
+	}
+}
+
+// WithScriptlets runs the install scriptlets of the packages in sandbox,
+// ScriptletsBubblewrap or ScriptletsChroot, once they are installed. The
+// scriptlets of the packages the package policy does not allow to run them
+// are skipped. An empty sandbox does not run any scriptlet.
+func WithScriptlets(sandbox string) Option {
+	return func(bc *Context) error {
+		switch sandbox {
+		case "", ScriptletsBubblewrap, ScriptletsChroot:
+		default:
+			return fmt.Errorf("unknown scriptlet sandbox %q, expected %s or %s", sandbox, ScriptletsBubblewrap, ScriptletsChroot)
+		}
+		bc.o.Scriptlets = sandbox
+		return nil
+	}
+}
+
+// WithNoScripts skips the install scriptlets of the packages matching names,
+// in addition to the no-scripts packages of the image configuration. "*"
+// skips the scriptlets of every package.
+func WithNoScripts(names ...string) Option {
+	return func(bc *Context) error {
+		bc.o.NoScripts = append(bc.o.NoScripts, names...)
+		return nil
+	}
+}
+
 // WithTransport allows explicitly setting the inner HTTP transport.
 func WithTransport(t http.RoundTripper) Option {
 	return func(bc *Context) error {
@@ -266,3 +545,36 @@ func WithSizeLimits(limits options.SizeLimits) Option {
 		return nil
 	}
 }
+
+// WithLayerCache enables caching of built layers in the given directory.
+// Layers are keyed by the resolved package set and every other input that
+// affects the image filesystem, so rebuilding an unchanged image skips
+// installation and compression. An empty dir disables the cache.
+func WithLayerCache(dir string) Option {
+	return func(bc *Context) error {
+		bc.o.LayerCacheDir = dir
+		return nil
+	}
+}
+
+// WithBaseImageMirror loads the base image ref from the local OCI layout at
+// dir, with an image per architecture, instead of pulling it, while still
+// annotating the images built on it as based on ref.
+func WithBaseImageMirror(ref, dir string) Option {
+	return func(bc *Context) error {
+		if bc.o.BaseImageMirrors == nil {
+			bc.o.BaseImageMirrors = map[string]string{}
+		}
+		bc.o.BaseImageMirrors[ref] = dir
+		return nil
+	}
+}
+
+// WithFetcher routes all http(s) retrieval of indexes, packages and keys
+// through f instead of the network. It replaces any transport set with WithTransport.
+func WithFetcher(f apk.Fetcher) Option {
+	return func(bc *Context) error {
+		bc.o.Transport = apk.NewFetcherTransport(f)
+		return nil
+	}
+}
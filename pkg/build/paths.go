@@ -18,7 +18,11 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"path"
 	"path/filepath"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
 
@@ -33,13 +37,131 @@ var pathMutators = map[string]PathMutator{
 	"empty-file":  mutateEmptyFile,
 	"hardlink":    mutateHardLink,
 	"symlink":     mutateSymLink,
-	"permissions": mutatePermissions,
+	"permissions": mutatePermissionsPaths,
 }
 
 func mutatePermissions(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
 	return mutatePermissionsDirect(fsys, mut.Path, mut.Permissions, mut.UID, mut.GID)
 }
 
+// mutatePermissionsPaths applies a permissions mutation to the paths its
+// path matches, which may be a glob where "**" matches any number of
+// directories, and with mut.Recursive to everything under them too. Those
+// entries only change the modes when mut.Permissions is set, so that the
+// ownership of trees of files and directories can be changed alone.
+func mutatePermissionsPaths(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation) error {
+	if !mut.Recursive && !isGlob(mut.Path) {
+		return mutatePermissions(fsys, o, mut)
+	}
+	matches, err := globPaths(fsys, mut.Path)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no paths match %q", mut.Path)
+	}
+	apply := func(path string) error {
+		if mut.Permissions != 0 {
+			if err := fsys.Chmod(path, fs.FileMode(mut.Permissions)); err != nil {
+				return fmt.Errorf("chmod %q: %w", path, err)
+			}
+		}
+		if err := fsys.Chown(path, int(mut.UID), int(mut.GID)); err != nil {
+			return fmt.Errorf("chown %q: %w", path, err)
+		}
+		return nil
+	}
+	for _, match := range matches {
+		if !mut.Recursive {
+			if err := apply(match); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fs.WalkDir(fsys, match, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Changing a symlink would change its target instead.
+			if d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			return apply(path)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isGlob reports whether pattern has any of the special characters of
+// path.Match.
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// globPaths returns the paths of fsys that pattern matches, in lexical order.
+func globPaths(fsys apkfs.FullFS, pattern string) ([]string, error) {
+	pattern = strings.TrimPrefix(path.Clean("/"+pattern), "/")
+	if !isGlob(pattern) {
+		if _, err := fsys.Lstat(pattern); err != nil {
+			return nil, err
+		}
+		return []string{pattern}, nil
+	}
+	if _, err := path.Match(strings.ReplaceAll(pattern, "**", "*"), ""); err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+	}
+
+	// Walk from the longest directory without special characters.
+	segments := strings.Split(pattern, "/")
+	root := "."
+	for i, seg := range segments {
+		if isGlob(seg) {
+			if i > 0 {
+				root = path.Join(segments[:i]...)
+			}
+			break
+		}
+	}
+	var matches []string
+	err := fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == root {
+			return fs.SkipAll
+		} else if err != nil {
+			return err
+		}
+		if p != "." && matchGlob(segments, strings.Split(p, "/")) {
+			matches = append(matches, p)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// matchGlob reports whether the segments of a path match those of a
+// pattern, where a "**" segment matches any number of segments.
+func matchGlob(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchGlob(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchGlob(pattern[1:], segments[1:])
+}
+
 func mutatePermissionsDirect(fsys apkfs.FullFS, path string, perms, uid, gid uint32) error {
 	target := path
 
@@ -129,13 +251,117 @@ func mutateSymLink(fsys apkfs.FullFS, o *options.Options, mut types.PathMutation
 	return nil
 }
 
-func mutatePaths(fsys apkfs.FullFS, o *options.Options, ic *types.ImageConfiguration) error {
+// whiteoutPrefix marks the files of a layer that delete the path after the
+// prefix from the layers below, see
+// https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+const whiteoutPrefix = ".wh."
+
+// mutateDelete removes the file or directory tree at mut.Path, and, when
+// building on a base image, adds a whiteout for it so that it is deleted from
+// the base image too. Paths that don't exist are not an error, since the base
+// image may not have them either.
+func mutateDelete(fsys apkfs.FullFS, mut types.PathMutation, onBaseImage bool) error {
+	target := strings.TrimPrefix(path.Clean("/"+mut.Path), "/")
+	if target == "" {
+		return fmt.Errorf("cannot delete the root directory")
+	}
+	if err := removeAll(fsys, target); err != nil {
+		return err
+	}
+	if !onBaseImage {
+		return nil
+	}
+	if err := ensureParentDirectory(fsys, target); err != nil {
+		return fmt.Errorf("ensuring parent directory for %q: %w", target, err)
+	}
+	whiteout := path.Join(path.Dir(target), whiteoutPrefix+path.Base(target))
+	if err := fsys.WriteFile(whiteout, nil, 0o644); err != nil {
+		return fmt.Errorf("writing whiteout %q: %w", whiteout, err)
+	}
+	return nil
+}
+
+// removeAll removes name and everything under it from fsys.
+func removeAll(fsys apkfs.FullFS, name string) error {
+	fi, err := fsys.Lstat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		des, err := fsys.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, de := range des {
+			if err := removeAll(fsys, filepath.Join(name, de.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := fsys.Remove(name); err != nil {
+		return fmt.Errorf("removing %q: %w", name, err)
+	}
+	return nil
+}
+
+// applyModes creates the missing parents of mut.Path with the directory mode
+// of modes, rather than 0o755, and gives directory and empty-file paths
+// without permissions the modes of modes.
+func applyModes(fsys apkfs.FullFS, modes *types.ImageModes, mut *types.PathMutation) error {
+	if err := fsys.MkdirAll(filepath.Dir(mut.Path), modes.Directory()); err != nil {
+		return fmt.Errorf("ensuring parent directory for %q: %w", mut.Path, err)
+	}
+	if mut.Permissions == 0 && modes.Umask != nil {
+		switch mut.Type {
+		case "directory":
+			mut.Permissions = uint32(modes.Directory())
+		case "empty-file":
+			mut.Permissions = uint32(modes.File())
+		}
+	}
+	return nil
+}
+
+// mutatePaths applies the path mutations of ic, loading the images that
+// image mutations copy from with images.
+func mutatePaths(fsys apkfs.FullFS, o *options.Options, ic *types.ImageConfiguration, images func(ref string) (v1.Image, error)) error {
 	for _, mut := range ic.Paths {
+		if mut.Type == "image" {
+			if ic.Modes != nil {
+				if err := applyModes(fsys, ic.Modes, &mut); err != nil {
+					return fmt.Errorf("copying to path %q: %w", mut.Path, err)
+				}
+			}
+			img, err := images(mut.Image)
+			if err != nil {
+				return fmt.Errorf("loading image %s for path %q: %w", mut.Image, mut.Path, err)
+			}
+			if err := mutateFromImage(fsys, img, mut); err != nil {
+				return fmt.Errorf("copying %q from image %s to %q: %w", mut.Source, mut.Image, mut.Path, err)
+			}
+			continue
+		}
+
+		if mut.Type == "delete" {
+			if err := mutateDelete(fsys, mut, ic.Contents.BaseImage != nil); err != nil {
+				return fmt.Errorf("deleting path %q: %w", mut.Path, err)
+			}
+			continue
+		}
+
 		pm, ok := pathMutators[mut.Type]
 		if !ok {
 			return fmt.Errorf("unsupported path mutation type %q", mut.Type)
 		}
 
+		if ic.Modes != nil {
+			if err := applyModes(fsys, ic.Modes, &mut); err != nil {
+				return fmt.Errorf("mutating path %q: %w", mut.Path, err)
+			}
+		}
+
 		if err := pm(fsys, o, mut); err != nil {
 			if errors.Is(err, fs.ErrExist) {
 				err = &PathMutationFileConflictError{Path: mut.Path}
@@ -0,0 +1,185 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// xattrPrefix prefixes the PAX records of the extended attributes of files.
+const xattrPrefix = "SCHILY.xattr."
+
+// pathImage returns the image of reference ref that image paths copy from,
+// loading it on first use.
+func (bc *Context) pathImage(ctx context.Context, ref string) (v1.Image, error) {
+	if img, ok := bc.pathImages[ref]; ok {
+		return img, nil
+	}
+	img, err := bc.loadImage(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if bc.pathImages == nil {
+		bc.pathImages = map[string]v1.Image{}
+	}
+	bc.pathImages[ref] = img
+	return img, nil
+}
+
+// pathImageDigests returns the digests of the images that the image paths of
+// the configuration copy from, by reference.
+func (bc *Context) pathImageDigests(ctx context.Context) (map[string]string, error) {
+	var digests map[string]string
+	for _, mut := range bc.ic.Paths {
+		if mut.Type != "image" {
+			continue
+		}
+		img, err := bc.pathImage(ctx, mut.Image)
+		if err != nil {
+			return nil, fmt.Errorf("loading image %s for path %q: %w", mut.Image, mut.Path, err)
+		}
+		h, err := img.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("computing digest of image %s: %w", mut.Image, err)
+		}
+		if digests == nil {
+			digests = map[string]string{}
+		}
+		digests[mut.Image] = h.String()
+	}
+	return digests, nil
+}
+
+// mutateFromImage copies the file or directory tree at mut.Source in the
+// filesystem of img to mut.Path, like COPY --from in a Dockerfile. Files keep
+// their modes, ownership and extended attributes, except that mut.UID and
+// mut.GID own them when set.
+func mutateFromImage(fsys apkfs.FullFS, img v1.Image, mut types.PathMutation) error {
+	src := strings.Trim(path.Clean("/"+mut.Source), "/")
+	// rel returns the path of name relative to src, if it is src or below it.
+	rel := func(name string) (string, bool) {
+		name = strings.Trim(path.Clean("/"+name), "/")
+		if src == "" {
+			return name, true
+		}
+		if name == src {
+			return "", true
+		}
+		r, ok := strings.CutPrefix(name, src+"/")
+		return r, ok
+	}
+
+	rc := mutate.Extract(img)
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading image filesystem: %w", err)
+		}
+		r, ok := rel(hdr.Name)
+		if !ok {
+			continue
+		}
+		found = true
+		target := path.Join(mut.Path, r)
+
+		if hdr.Typeflag != tar.TypeDir {
+			if err := ensureParentDirectory(fsys, target); err != nil {
+				return fmt.Errorf("ensuring parent directory for %q: %w", target, err)
+			}
+			// Files of the image replace those of the packages.
+			if fi, err := fsys.Lstat(target); err == nil && !fi.IsDir() {
+				if err := fsys.Remove(target); err != nil {
+					return fmt.Errorf("removing %q: %w", target, err)
+				}
+			}
+		}
+
+		mode := hdr.FileInfo().Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := fsys.MkdirAll(target, mode.Perm()); err != nil {
+				return fmt.Errorf("creating directory %q: %w", target, err)
+			}
+		case tar.TypeReg:
+			f, err := fsys.Create(target)
+			if err != nil {
+				return fmt.Errorf("creating file %q: %w", target, err)
+			}
+			if _, err := io.Copy(f, tr); err != nil { // #nosec G110 -- the image is configured
+				f.Close()
+				return fmt.Errorf("writing file %q: %w", target, err)
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("writing file %q: %w", target, err)
+			}
+		case tar.TypeSymlink:
+			if err := fsys.Symlink(hdr.Linkname, target); err != nil {
+				return fmt.Errorf("symlinking %q -> %q: %w", hdr.Linkname, target, err)
+			}
+			continue
+		case tar.TypeLink:
+			lr, ok := rel(hdr.Linkname)
+			if !ok {
+				return fmt.Errorf("%q is a hardlink to %q, which is not copied", hdr.Name, hdr.Linkname)
+			}
+			if err := fsys.Link(path.Join(mut.Path, lr), target); err != nil {
+				return fmt.Errorf("linking %q -> %q: %w", path.Join(mut.Path, lr), target, err)
+			}
+			continue
+		default:
+			return fmt.Errorf("%q has unsupported type %c", hdr.Name, hdr.Typeflag)
+		}
+
+		uid, gid := uint32(hdr.Uid), uint32(hdr.Gid) // #nosec G115 -- tar ids are non-negative
+		if mut.UID != 0 {
+			uid = mut.UID
+		}
+		if mut.GID != 0 {
+			gid = mut.GID
+		}
+		if err := mutatePermissionsDirect(fsys, target, uint32(mode), uid, gid); err != nil {
+			return err
+		}
+		for k, v := range hdr.PAXRecords {
+			if attr, ok := strings.CutPrefix(k, xattrPrefix); ok {
+				if err := fsys.SetXattr(target, attr, []byte(v)); err != nil {
+					return fmt.Errorf("setting xattr %s of %q: %w", attr, target, err)
+				}
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("%q: %w", mut.Source, fs.ErrNotExist)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func testImage(t *testing.T, hdrs ...*tar.Header) v1.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range hdrs {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(hdr.Name))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	require.NoError(t, err)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	return img
+}
+
+func TestMutateFromImage(t *testing.T) {
+	img := testImage(t,
+		&tar.Header{Name: "opt/", Typeflag: tar.TypeDir, Mode: 0o755},
+		&tar.Header{Name: "opt/app/", Typeflag: tar.TypeDir, Mode: 0o750},
+		&tar.Header{Name: "opt/app/tool", Typeflag: tar.TypeReg, Mode: 0o755, Uid: 1000},
+		&tar.Header{Name: "opt/app/link", Typeflag: tar.TypeSymlink, Linkname: "tool"},
+		&tar.Header{Name: "opt/app/hard", Typeflag: tar.TypeLink, Linkname: "opt/app/tool"},
+		&tar.Header{Name: "etc/other", Typeflag: tar.TypeReg, Mode: 0o644},
+	)
+
+	t.Run("directory", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, mutateFromImage(fsys, img, types.PathMutation{Type: "image", Path: "/usr/lib/app", Source: "/opt/app"}))
+
+		b, err := fsys.ReadFile("usr/lib/app/tool")
+		require.NoError(t, err)
+		require.Equal(t, "opt/app/tool", string(b))
+		fi, err := fsys.Stat("usr/lib/app/tool")
+		require.NoError(t, err)
+		require.Equal(t, fs.FileMode(0o755), fi.Mode().Perm())
+		fi, err = fsys.Stat("usr/lib/app")
+		require.NoError(t, err)
+		require.Equal(t, fs.FileMode(0o750), fi.Mode().Perm())
+
+		link, err := fsys.Readlink("usr/lib/app/link")
+		require.NoError(t, err)
+		require.Equal(t, "tool", link)
+		b, err = fsys.ReadFile("usr/lib/app/hard")
+		require.NoError(t, err)
+		require.Equal(t, "opt/app/tool", string(b))
+
+		_, err = fsys.Stat("usr/lib/app/other")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = fsys.Stat("etc/other")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, fsys.MkdirAll("usr/bin", 0o755))
+		require.NoError(t, fsys.WriteFile("usr/bin/tool", []byte("packaged"), 0o755))
+		require.NoError(t, mutateFromImage(fsys, img, types.PathMutation{Type: "image", Path: "/usr/bin/tool", Source: "opt/app/tool"}))
+
+		// The file of the image replaces the one of the packages.
+		b, err := fsys.ReadFile("usr/bin/tool")
+		require.NoError(t, err)
+		require.Equal(t, "opt/app/tool", string(b))
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		err := mutateFromImage(apkfs.NewMemFS(), img, types.PathMutation{Type: "image", Path: "/x", Source: "/opt/missing"})
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("hardlink outside", func(t *testing.T) {
+		err := mutateFromImage(apkfs.NewMemFS(), img, types.PathMutation{Type: "image", Path: "/x", Source: "/opt/app/hard"})
+		require.ErrorContains(t, err, "which is not copied")
+	})
+}
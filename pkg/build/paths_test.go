@@ -0,0 +1,164 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestMutateDelete(t *testing.T) {
+	setup := func(t *testing.T) apkfs.FullFS {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, fsys.MkdirAll("etc/app/conf.d", 0o755))
+		require.NoError(t, fsys.WriteFile("etc/app/conf.d/sample.conf", []byte("sample"), 0o644))
+		require.NoError(t, fsys.WriteFile("etc/app/app.conf", []byte("app"), 0o644))
+		return fsys
+	}
+
+	t.Run("without base image", func(t *testing.T) {
+		fsys := setup(t)
+		require.NoError(t, mutateDelete(fsys, types.PathMutation{Type: "delete", Path: "/etc/app/conf.d"}, false))
+		_, err := fsys.Lstat("etc/app/conf.d")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = fsys.Lstat("etc/app/.wh.conf.d")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = fsys.Lstat("etc/app/app.conf")
+		require.NoError(t, err)
+
+		// Paths that don't exist are already deleted.
+		require.NoError(t, mutateDelete(fsys, types.PathMutation{Type: "delete", Path: "/etc/missing"}, false))
+	})
+
+	t.Run("on base image", func(t *testing.T) {
+		fsys := setup(t)
+		require.NoError(t, mutateDelete(fsys, types.PathMutation{Type: "delete", Path: "/etc/app/conf.d"}, true))
+		_, err := fsys.Lstat("etc/app/conf.d")
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		fi, err := fsys.Lstat("etc/app/.wh.conf.d")
+		require.NoError(t, err)
+		require.True(t, fi.Mode().IsRegular())
+		require.Zero(t, fi.Size())
+
+		// Paths only in the base image are whited out along with their
+		// parent directories.
+		require.NoError(t, mutateDelete(fsys, types.PathMutation{Type: "delete", Path: "/usr/share/doc/sample"}, true))
+		_, err = fsys.Lstat("usr/share/doc/.wh.sample")
+		require.NoError(t, err)
+	})
+
+	t.Run("root", func(t *testing.T) {
+		require.Error(t, mutateDelete(setup(t), types.PathMutation{Type: "delete", Path: "/"}, true))
+	})
+}
+
+func TestMutatePermissionsPaths(t *testing.T) {
+	setup := func(t *testing.T) apkfs.FullFS {
+		fsys := apkfs.NewMemFS()
+		require.NoError(t, fsys.MkdirAll("var/lib/app/data", 0o755))
+		require.NoError(t, fsys.WriteFile("var/lib/app/app.db", nil, 0o644))
+		require.NoError(t, fsys.WriteFile("var/lib/app/data/a.json", nil, 0o644))
+		require.NoError(t, fsys.WriteFile("var/lib/app/data/b.txt", nil, 0o644))
+		require.NoError(t, fsys.Symlink("/etc/passwd", "var/lib/app/data/passwd"))
+		return fsys
+	}
+	mode := func(t *testing.T, fsys apkfs.FullFS, path string) fs.FileMode {
+		t.Helper()
+		fi, err := fsys.Stat(path)
+		require.NoError(t, err)
+		return fi.Mode().Perm()
+	}
+
+	t.Run("recursive", func(t *testing.T) {
+		fsys := setup(t)
+		require.NoError(t, fsys.MkdirAll("etc", 0o755))
+		require.NoError(t, fsys.WriteFile("etc/passwd", nil, 0o644))
+		require.NoError(t, mutatePermissionsPaths(fsys, nil, types.PathMutation{Type: "permissions", Path: "/var/lib/app", Recursive: true, UID: 65532, GID: 65532}))
+		// Without permissions, the modes are kept.
+		require.Equal(t, fs.FileMode(0o755), mode(t, fsys, "var/lib/app/data"))
+		require.Equal(t, fs.FileMode(0o644), mode(t, fsys, "var/lib/app/data/a.json"))
+		// The targets of symlinks are left alone.
+		require.Equal(t, fs.FileMode(0o644), mode(t, fsys, "etc/passwd"))
+	})
+
+	t.Run("glob", func(t *testing.T) {
+		fsys := setup(t)
+		require.NoError(t, mutatePermissionsPaths(fsys, nil, types.PathMutation{Type: "permissions", Path: "/var/lib/**/*.json", Permissions: 0o600}))
+		require.Equal(t, fs.FileMode(0o600), mode(t, fsys, "var/lib/app/data/a.json"))
+		require.Equal(t, fs.FileMode(0o644), mode(t, fsys, "var/lib/app/data/b.txt"))
+		require.Equal(t, fs.FileMode(0o644), mode(t, fsys, "var/lib/app/app.db"))
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		err := mutatePermissionsPaths(setup(t), nil, types.PathMutation{Type: "permissions", Path: "/var/lib/*/missing*", Permissions: 0o600})
+		require.ErrorContains(t, err, "no paths match")
+	})
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"usr/**", "usr/bin/app", true},
+		{"usr/**/app", "usr/app", true},
+		{"usr/**/app", "usr/lib/x/app", true},
+		{"usr/*/app", "usr/lib/x/app", false},
+		{"usr/*/app", "usr/lib/app", true},
+		{"usr/lib/*.so", "usr/lib/libc.so", true},
+		{"usr/lib/*.so", "usr/lib/sub/libc.so", false},
+	} {
+		require.Equal(t, tc.want, matchGlob(strings.Split(tc.pattern, "/"), strings.Split(tc.path, "/")), "%s %s", tc.pattern, tc.path)
+	}
+}
+
+func TestMutatePathsModes(t *testing.T) {
+	umask := uint32(0o027)
+	ic := &types.ImageConfiguration{
+		Modes: &types.ImageModes{Umask: &umask, HomeDirs: 0o750},
+		Paths: []types.PathMutation{
+			{Type: "directory", Path: "/srv/app/data"},
+			{Type: "empty-file", Path: "/srv/app/app.log"},
+			{Type: "directory", Path: "/srv/app/private", Permissions: 0o700},
+		},
+		Accounts: types.ImageAccounts{
+			Users: []types.User{{UserName: "app", UID: 1000, HomeDir: "/home/app"}},
+		},
+	}
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("etc", 0o755))
+	require.NoError(t, mutatePaths(fsys, nil, ic, nil))
+	require.NoError(t, mutateAccounts(fsys, ic))
+
+	for path, want := range map[string]fs.FileMode{
+		"srv":             0o750,
+		"srv/app/data":    0o750,
+		"srv/app/app.log": 0o640,
+		// Explicit permissions win.
+		"srv/app/private": 0o700,
+		"home":            0o750,
+		"home/app":        0o750,
+	} {
+		fi, err := fsys.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, want, fi.Mode().Perm(), path)
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/hashicorp/go-cleanhttp"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/lock"
+	"chainguard.dev/apko/pkg/policy"
+)
+
+// checkPackagePolicy checks the packages to be installed against the package
+// policy, if one is configured, logging the license and advisory violations
+// that are only warnings. The advisory feeds of the policy are fetched anew on
+// every check.
+func (bc *Context) checkPackagePolicy(ctx context.Context, pkgs []policy.Package) error {
+	if bc.o.PackagePolicy == "" {
+		return nil
+	}
+	p, err := policy.Load(bc.o.PackagePolicy)
+	if err != nil {
+		return err
+	}
+	if p.Advisories != nil {
+		var rt http.RoundTripper = cleanhttp.DefaultPooledTransport()
+		if bc.o.FIPS {
+			rt = apk.FIPSTransport(rt)
+		}
+		if bc.o.Offline {
+			// Feeds that are local files are still read.
+			rt = apk.OfflineTransport
+		}
+		if err := p.Advisories.Fetch(ctx, &http.Client{Transport: rt}); err != nil {
+			return err
+		}
+	}
+	warnings, err := p.Check(pkgs)
+	log := clog.FromContext(ctx)
+	for _, w := range warnings {
+		log.Warnf("package %s-%s: %s (%s)", w.Package.Name, w.Package.Version, w.Reason, strings.Join(w.Chain, " -> "))
+	}
+	return err
+}
+
+// checkResolvedPackagePolicy resolves the world and checks the result
+// against the package policy, if one is configured, before anything is
+// installed.
+func (bc *Context) checkResolvedPackagePolicy(ctx context.Context) error {
+	if bc.o.PackagePolicy == "" {
+		return nil
+	}
+	resolved, _, err := bc.resolveWorld(ctx)
+	if err != nil {
+		return err
+	}
+	return bc.checkPackagePolicy(ctx, resolvedPolicyPackages(resolved, bc.Arch().ToAPK()))
+}
+
+// resolvedPolicyPackages converts resolved packages for checking against a
+// package policy.
+func resolvedPolicyPackages(pkgs []*apk.RepositoryPackage, arch string) []policy.Package {
+	out := make([]policy.Package, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		var repo string
+		if r := pkg.Repository(); r != nil {
+			repo = strings.TrimSuffix(r.URI, "/"+arch)
+		}
+		out = append(out, policy.Package{
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			Origin:       pkg.Origin,
+			Repository:   repo,
+			License:      pkg.License,
+			Dependencies: pkg.Dependencies,
+			Provides:     pkg.Provides,
+		})
+	}
+	return out
+}
+
+// lockedPolicyPackages converts packages installed from a lockfile for
+// checking against a package policy. The lockfile records where each package
+// was fetched from, and the installed package the rest.
+func lockedPolicyPackages(diffs []apk.InstalledDiff, l lock.Lock, arch string) []policy.Package {
+	repos := map[string]string{}
+	for _, p := range l.Contents.Packages {
+		if p.Architecture == arch {
+			// Strip the file name and the architecture directory.
+			repo := p.URL
+			for range 2 {
+				if i := strings.LastIndex(repo, "/"); i >= 0 {
+					repo = repo[:i]
+				}
+			}
+			repos[p.Name] = repo
+		}
+	}
+	out := make([]policy.Package, 0, len(diffs))
+	for _, d := range diffs {
+		out = append(out, policy.Package{
+			Name:         d.Package.Name,
+			Version:      d.Package.Version,
+			Origin:       d.Package.Origin,
+			Repository:   repos[d.Package.Name],
+			License:      d.Package.License,
+			Dependencies: d.Package.Dependencies,
+			Provides:     d.Package.Provides,
+		})
+	}
+	return out
+}
+
+// packagePolicyDigest returns the digest of the package policy file, or "" if
+// there is none, so that changing the policy invalidates cached layers.
+func (bc *Context) packagePolicyDigest() (string, error) {
+	if bc.o.PackagePolicy == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(bc.o.PackagePolicy)
+	if err != nil {
+		return "", fmt.Errorf("reading package policy: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
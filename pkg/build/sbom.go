@@ -24,6 +24,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
@@ -38,6 +39,7 @@ import (
 	"chainguard.dev/apko/pkg/build/types"
 	"chainguard.dev/apko/pkg/options"
 	"chainguard.dev/apko/pkg/sbom"
+	"chainguard.dev/apko/pkg/sbom/generator"
 	soptions "chainguard.dev/apko/pkg/sbom/options"
 )
 
@@ -112,6 +114,25 @@ func (bc *Context) GenerateImageSBOM(ctx context.Context, arch types.Architectur
 	}
 
 	s.Packages = pkgs
+	s.SkippedScriptlets = bc.skippedScriptlets
+	s.ExcludedPaths = bc.excludedPaths
+	s.Files = bc.o.SBOMFiles
+	s.DetectLicenses = bc.o.SBOMDetectLicenses
+	s.CPEs = bc.o.SBOMCPEs
+	if bc.o.SBOMCPEMapping != "" {
+		s.CPEMapping, err = soptions.LoadCPEMapping(bc.o.SBOMCPEMapping)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if bc.baseimg != nil {
+		base, err := bc.baseImageInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.BaseImage = base
+	}
 
 	// Get the image digest
 	h, err := img.Digest()
@@ -122,22 +143,122 @@ func (bc *Context) GenerateImageSBOM(ctx context.Context, arch types.Architectur
 	s.ImageInfo.ImageDigest = h.String()
 	s.ImageInfo.Arch = arch
 
+	signer, err := sbomSigner(ctx, bc.o)
+	if err != nil {
+		return nil, err
+	}
+
 	var sboms = make([]types.SBOM, 0)
 	for _, gen := range bc.o.SBOMGenerators {
-		filename := filepath.Join(s.OutputDir, s.FileName+"."+gen.Ext())
+		sbomName, err := sbomFileName(bc.o, arch.ToAPK(), h, gen)
+		if err != nil {
+			return nil, err
+		}
+		filename := filepath.Join(s.OutputDir, sbomName)
 		if err := gen.Generate(ctx, &s, filename); err != nil {
 			return nil, fmt.Errorf("generating %s sbom: %w", gen.Key(), err)
 		}
+		sigPath, err := signSBOM(ctx, signer, filename)
+		if err != nil {
+			return nil, err
+		}
 		sboms = append(sboms, types.SBOM{
-			Path:   filename,
-			Format: gen.Key(),
-			Arch:   arch.String(),
-			Digest: h,
+			Path:          filename,
+			SignaturePath: sigPath,
+			Format:        gen.Key(),
+			Arch:          arch.String(),
+			Digest:        h,
 		})
 	}
 	return sboms, nil
 }
 
+// sbomNameData are the fields of the SBOM file name template.
+type sbomNameData struct {
+	// Arch is the architecture of the image, or "index".
+	Arch string
+	// Digest is the hex digest of the image or index.
+	Digest string
+	// Format is the SBOM format, such as "spdx".
+	Format string
+	// Ext is the file extension of the format, such as "spdx.json".
+	Ext string
+}
+
+// sbomFileName returns the file name of the SBOM of arch, or the index, in the
+// format of gen, from o.SBOMNameTemplate if set.
+func sbomFileName(o options.Options, arch string, digest v1.Hash, gen generator.Generator) (string, error) {
+	if o.SBOMNameTemplate == "" {
+		return fmt.Sprintf("sbom-%s.%s", arch, gen.Ext()), nil
+	}
+	tmpl, err := template.New("sbom").Option("missingkey=error").Parse(o.SBOMNameTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parsing SBOM name template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, sbomNameData{Arch: arch, Digest: digest.Hex, Format: gen.Key(), Ext: gen.Ext()}); err != nil {
+		return "", fmt.Errorf("executing SBOM name template: %w", err)
+	}
+	sbomName := b.String()
+	if sbomName == "" || sbomName == "." || sbomName == ".." || strings.ContainsRune(sbomName, '/') {
+		return "", fmt.Errorf("SBOM name template gave %q, which is not a file name", sbomName)
+	}
+	return sbomName, nil
+}
+
+// sbomSigner returns the signer of the SBOMs, or nil if they are not signed.
+func sbomSigner(ctx context.Context, o options.Options) (sbom.Signer, error) {
+	switch {
+	case o.SBOMKeyless && o.SBOMSigningKey != "":
+		return nil, errors.New("SBOMs can be signed with a key or keylessly, not both")
+	case o.SBOMKeyless:
+		return sbom.NewKeylessSigner(sbom.KeylessOptions{
+			FulcioURL: o.SBOMFulcioURL,
+			RekorURL:  o.SBOMRekorURL,
+		})
+	case o.SBOMSigningKey != "":
+		return sbom.LoadSigningKey(ctx, o.SBOMSigningKey)
+	}
+	return nil, nil
+}
+
+// signSBOM signs the SBOM at path with signer, if any, and returns the path of
+// the signature.
+func signSBOM(ctx context.Context, signer sbom.Signer, path string) (string, error) {
+	if signer == nil {
+		return "", nil
+	}
+	sigPath, err := signer.SignFile(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("signing sbom: %w", err)
+	}
+	return sigPath, nil
+}
+
+// baseImageInfo describes the base image for the SBOM, including the base
+// image's own SBOM so its contents can be merged in.
+func (bc *Context) baseImageInfo(ctx context.Context) (*soptions.BaseImageInfo, error) {
+	h, err := bc.baseimg.Image().Digest()
+	if err != nil {
+		return nil, fmt.Errorf("getting base image digest: %w", err)
+	}
+
+	sbom, err := bc.baseimg.SBOM(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("locating base image SBOM: %w", err)
+	}
+	if sbom == nil {
+		clog.FromContext(ctx).Warnf("no SBOM found for base image %s, its packages will not be described in the SBOM", bc.baseimg.Reference())
+	}
+
+	return &soptions.BaseImageInfo{
+		Reference: bc.baseimg.Reference(),
+		Digest:    h.String(),
+		SBOM:      sbom,
+		Packages:  bc.baseimg.InstalledPackages(),
+	}, nil
+}
+
 type ReleaseData struct {
 	ID         string
 	Name       string
@@ -230,19 +351,28 @@ func GenerateIndexSBOM(ctx context.Context, o options.Options, ic types.ImageCon
 		return archs[i].String() < archs[j].String()
 	})
 
+	signer, err := sbomSigner(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+
 	var sboms = make([]types.SBOM, 0, len(o.SBOMGenerators))
 	for _, gen := range o.SBOMGenerators {
 		archImageInfos := make([]soptions.ArchImageInfo, 0, len(archs))
 		for _, arch := range archs {
 			i := imgs[arch]
-			sbomHash, err := khash.SHA256ForFile(filepath.Join(s.OutputDir, fmt.Sprintf("sbom-%s.%s", arch.ToAPK(), gen.Ext())))
+			d, err := i.Digest()
 			if err != nil {
-				return nil, fmt.Errorf("checksumming %s SBOM: %w", arch, err)
+				return nil, fmt.Errorf("getting arch image digest: %w", err)
 			}
 
-			d, err := i.Digest()
+			sbomName, err := sbomFileName(o, arch.ToAPK(), d, gen)
 			if err != nil {
-				return nil, fmt.Errorf("getting arch image digest: %w", err)
+				return nil, err
+			}
+			sbomHash, err := khash.SHA256ForFile(filepath.Join(s.OutputDir, sbomName))
+			if err != nil {
+				return nil, fmt.Errorf("checksumming %s SBOM: %w", arch, err)
 			}
 
 			info := soptions.ArchImageInfo{
@@ -254,14 +384,23 @@ func GenerateIndexSBOM(ctx context.Context, o options.Options, ic types.ImageCon
 		}
 		s.ImageInfo.Images = archImageInfos
 
-		filename := filepath.Join(s.OutputDir, "sbom-index."+gen.Ext())
+		sbomName, err := sbomFileName(o, "index", h, gen)
+		if err != nil {
+			return nil, err
+		}
+		filename := filepath.Join(s.OutputDir, sbomName)
 		if err := gen.GenerateIndex(&s, filename); err != nil {
 			return nil, fmt.Errorf("generating %s sbom: %w", gen.Key(), err)
 		}
+		sigPath, err := signSBOM(ctx, signer, filename)
+		if err != nil {
+			return nil, err
+		}
 		sboms = append(sboms, types.SBOM{
-			Path:   filename,
-			Format: gen.Key(),
-			Digest: h,
+			Path:          filename,
+			SignaturePath: sigPath,
+			Format:        gen.Key(),
+			Digest:        h,
 		})
 	}
 
@@ -19,9 +19,13 @@ import (
 	"path/filepath"
 	"testing"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/stretchr/testify/require"
 
 	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/sbom"
+	"chainguard.dev/apko/pkg/sbom/generator/spdx"
 )
 
 func TestFetchFSReleaseData(t *testing.T) {
@@ -63,3 +67,35 @@ func TestBadFSReleaseData(t *testing.T) {
 	_, err := fetchFSReleaseData(fsys)
 	require.Error(t, err)
 }
+
+func TestSBOMFileName(t *testing.T) {
+	digest := v1.Hash{Algorithm: "sha256", Hex: "5a99438a9ced8193f1d71209d0b558fdc0b184aee5cf258e5f7aa9a6ab0f0671"}
+	gen := spdx.New()
+
+	name, err := sbomFileName(options.Options{}, "x86_64", digest, gen)
+	require.NoError(t, err)
+	require.Equal(t, "sbom-x86_64.spdx.json", name)
+
+	name, err = sbomFileName(options.Options{SBOMNameTemplate: "{{.Arch}}-{{.Digest}}.{{.Format}}.json"}, "index", digest, gen)
+	require.NoError(t, err)
+	require.Equal(t, "index-5a99438a9ced8193f1d71209d0b558fdc0b184aee5cf258e5f7aa9a6ab0f0671.spdx.json", name)
+
+	_, err = sbomFileName(options.Options{SBOMNameTemplate: "sboms/{{.Arch}}.json"}, "x86_64", digest, gen)
+	require.ErrorContains(t, err, "not a file name")
+	_, err = sbomFileName(options.Options{SBOMNameTemplate: "{{.Tag}}.json"}, "x86_64", digest, gen)
+	require.ErrorContains(t, err, "executing SBOM name template")
+}
+
+func TestSBOMSigner(t *testing.T) {
+	signer, err := sbomSigner(t.Context(), options.Options{})
+	require.NoError(t, err)
+	require.Nil(t, signer)
+
+	t.Setenv("SIGSTORE_ID_TOKEN", "token")
+	signer, err = sbomSigner(t.Context(), options.Options{SBOMKeyless: true, SBOMFulcioURL: sbom.DefaultFulcioURL})
+	require.NoError(t, err)
+	require.IsType(t, &sbom.KeylessSigner{}, signer)
+
+	_, err = sbomSigner(t.Context(), options.Options{SBOMKeyless: true, SBOMFulcioURL: sbom.DefaultFulcioURL, SBOMSigningKey: "key.pem"})
+	require.ErrorContains(t, err, "not both")
+}
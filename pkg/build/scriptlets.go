@@ -0,0 +1,539 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/policy"
+)
+
+// The sandboxes install scriptlets can be run in.
+const (
+	// ScriptletsBubblewrap runs scriptlets with bubblewrap (bwrap), which
+	// does not require root, in new namespaces with no network and only
+	// /dev and /proc mounted besides the image filesystem.
+	ScriptletsBubblewrap = "bubblewrap"
+	// ScriptletsChroot runs scriptlets chrooted into the image filesystem,
+	// in new namespaces with no network and nothing mounted. It requires
+	// root and is only supported on Linux.
+	ScriptletsChroot = "chroot"
+)
+
+// installScriptlets are the scriptlets apk runs when installing a package, in
+// the order it runs them.
+var installScriptlets = []string{".pre-install", ".post-install"}
+
+// triggerScriptlet is the scriptlet apk runs, once every package is installed,
+// for the package's triggers: the directories matching its trigger patterns.
+const triggerScriptlet = ".trigger"
+
+// scriptletPath is where a scriptlet is written in the image filesystem to
+// run it.
+const scriptletPath = ".apko-scriptlet"
+
+// sandboxedDirs are the directories the sandboxes provide themselves, which
+// are neither copied to nor from the image filesystem.
+var sandboxedDirs = []string{"dev", "proc"}
+
+type scriptlet struct {
+	pkg    *apk.InstalledPackage
+	name   string
+	script []byte
+	args   []string
+}
+
+// runScriptlets runs the install scriptlets of the installed packages that the
+// package policy allows to, in the sandbox the build is configured with, if
+// any. apk runs the pre-install scriptlet of a package before installing its
+// files, but here all scriptlets run once every package is installed, in the
+// order the packages were installed. The triggers of the packages then run, as
+// apk runs them at the end of an installation, with the directories of the
+// image matching the trigger patterns of each package.
+//
+// The scriptlets run on a copy of the image filesystem on disk, whose changes
+// are then copied back. Files created by the scriptlets are owned by root,
+// since their ownership in an unprivileged sandbox is not meaningful.
+func (bc *Context) runScriptlets(ctx context.Context, installed []*apk.InstalledPackage) error {
+	if bc.o.Scriptlets == "" {
+		return nil
+	}
+	ctx, span := otel.Tracer("apko").Start(ctx, "runScriptlets")
+	defer span.End()
+	log := clog.FromContext(ctx)
+
+	scriptlets, skipped, err := bc.scriptletsToRun(ctx, installed)
+	if err != nil {
+		return err
+	}
+	bc.skippedScriptlets = skipped
+	if len(scriptlets) == 0 {
+		return nil
+	}
+
+	return bc.inSandbox(ctx, "scriptlets", func(root string, run sandboxRunner) error {
+		for _, s := range scriptlets {
+			name := strings.TrimPrefix(s.name, ".")
+			log.Infof("running %s %s scriptlet", s.pkg.Name, name)
+			//nolint:gosec // the scriptlet must be executable
+			if err := os.WriteFile(filepath.Join(root, scriptletPath), s.script, 0o755); err != nil {
+				return fmt.Errorf("writing %s %s scriptlet: %w", s.pkg.Name, name, err)
+			}
+			out, err := run(append([]string{"/" + scriptletPath}, s.args...)...)
+			if len(out) != 0 {
+				log.Debugf("%s %s scriptlet output:\n%s", s.pkg.Name, name, out)
+			}
+			if err != nil {
+				return fmt.Errorf("running %s %s scriptlet: %w\n%s", s.pkg.Name, name, err, out)
+			}
+		}
+		return os.Remove(filepath.Join(root, scriptletPath))
+	})
+}
+
+// sandboxRunner runs argv in the sandbox, returning its output.
+type sandboxRunner func(argv ...string) ([]byte, error)
+
+// inSandbox calls fn with a copy of the image filesystem on disk at root,
+// and a sandboxRunner running commands in it with the scriptlet sandbox of
+// the build. The changes fn makes are then copied back, as the changes of
+// what: files created are owned by root.
+func (bc *Context) inSandbox(ctx context.Context, what string, fn func(root string, run sandboxRunner) error) error {
+	root, err := os.MkdirTemp(bc.o.TempDir(), what+"-")
+	if err != nil {
+		return fmt.Errorf("creating %s root: %w", what, err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := exportFS(bc.fs, root); err != nil {
+		return fmt.Errorf("copying the image filesystem to run %s: %w", what, err)
+	}
+	before, err := snapshotDir(root)
+	if err != nil {
+		return err
+	}
+
+	env := []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		fmt.Sprintf("SOURCE_DATE_EPOCH=%d", bc.o.SourceDateEpoch.Unix()),
+	}
+	if err := fn(root, func(argv ...string) ([]byte, error) {
+		return runSandboxed(ctx, bc.o.Scriptlets, root, env, argv...)
+	}); err != nil {
+		return err
+	}
+
+	if err := importFS(bc.fs, root, before, bc.o.SourceDateEpoch); err != nil {
+		return fmt.Errorf("copying the changes of the %s: %w", what, err)
+	}
+	return nil
+}
+
+// scriptletsToRun returns the install scriptlets and triggers of installed, in
+// the order they run, leaving out those of the no-scripts packages and of the
+// packages the package policy does not allow to run scriptlets. The scriptlets
+// left out are returned as "<name>-<version>.<scriptlet>".
+func (bc *Context) scriptletsToRun(ctx context.Context, installed []*apk.InstalledPackage) ([]scriptlet, []string, error) {
+	log := clog.FromContext(ctx)
+
+	var p *policy.Policy
+	if bc.o.PackagePolicy != "" {
+		var err error
+		if p, err = policy.Load(bc.o.PackagePolicy); err != nil {
+			return nil, nil, err
+		}
+	}
+	noScripts := slices.Concat(bc.ic.Contents.NoScripts, bc.o.NoScripts)
+
+	scripts, err := readScripts(bc.fs)
+	if err != nil {
+		return nil, nil, err
+	}
+	triggers, err := readTriggers(bc.fs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var out, triggered []scriptlet
+	var skipped []string
+	skip := func(found []scriptlet, trigger *scriptlet) {
+		if trigger != nil {
+			found = append(found, *trigger)
+		}
+		for _, s := range found {
+			skipped = append(skipped, fmt.Sprintf("%s-%s%s", s.pkg.Name, s.pkg.Version, s.name))
+		}
+	}
+	for _, pkg := range installed {
+		checksum := "Q1" + base64.StdEncoding.EncodeToString(pkg.Checksum)
+		prefix := fmt.Sprintf("%s-%s.%s", pkg.Name, pkg.Version, checksum)
+		var found []scriptlet
+		for _, name := range installScriptlets {
+			if script, ok := scripts[prefix+name]; ok {
+				found = append(found, scriptlet{pkg: pkg, name: name, script: script, args: []string{pkg.Version}})
+			}
+		}
+		var trigger *scriptlet
+		if script, ok := scripts[prefix+triggerScriptlet]; ok && len(triggers[checksum]) != 0 {
+			dirs, err := triggerDirs(bc.fs, triggers[checksum])
+			if err != nil {
+				return nil, nil, fmt.Errorf("finding the triggered directories of %s: %w", pkg.Name, err)
+			}
+			// Like apk, a trigger only runs if some directory matches.
+			if len(dirs) != 0 {
+				trigger = &scriptlet{pkg: pkg, name: triggerScriptlet, script: script, args: dirs}
+			}
+		}
+		if len(found) == 0 && trigger == nil {
+			continue
+		}
+		no, err := matchesNoScripts(noScripts, pkg.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if no {
+			log.Infof("skipping the scriptlets of %s, which is listed in no-scripts", pkg.Name)
+			skip(found, trigger)
+			continue
+		}
+		if !p.AllowsScriptlets(policy.Package{Name: pkg.Name, Version: pkg.Version, Origin: pkg.Origin, License: pkg.License}) {
+			log.Infof("skipping the scriptlets of %s, which the package policy does not allow to run them", pkg.Name)
+			skip(found, trigger)
+			continue
+		}
+		out = append(out, found...)
+		if trigger != nil {
+			triggered = append(triggered, *trigger)
+		}
+	}
+	return append(out, triggered...), skipped, nil
+}
+
+// readTriggers returns the trigger patterns of the apk database by package
+// checksum, as "Q1" followed by the base64 checksum.
+func readTriggers(fsys apkfs.FullFS) (map[string][]string, error) {
+	b, err := fsys.ReadFile("usr/lib/apk/db/triggers")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading apk triggers: %w", err)
+	}
+	triggers := map[string][]string{}
+	for line := range strings.Lines(string(b)) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		triggers[fields[0]] = append(triggers[fields[0]], fields[1:]...)
+	}
+	return triggers, nil
+}
+
+// triggerDirs returns the directories of fsys matching any of the trigger
+// patterns, as absolute paths. As with apk, "*" in a pattern does not match
+// "/".
+func triggerDirs(fsys apkfs.FullFS, patterns []string) ([]string, error) {
+	var dirs []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || p == "." {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if slices.Contains(sandboxedDirs, p) {
+			return fs.SkipDir
+		}
+		for _, pattern := range patterns {
+			ok, err := path.Match(pattern, "/"+p)
+			if err != nil {
+				return fmt.Errorf("invalid trigger %q: %w", pattern, err)
+			}
+			if ok {
+				dirs = append(dirs, "/"+p)
+				break
+			}
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// matchesNoScripts reports whether the package name matches one of the
+// no-scripts patterns.
+func matchesNoScripts(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid no-scripts pattern %q: %w", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// readScripts returns the scripts of the apk database by name.
+func readScripts(fsys apkfs.FullFS) (map[string][]byte, error) {
+	b, err := fsys.ReadFile("usr/lib/apk/db/scripts.tar")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading apk scripts: %w", err)
+	}
+	scripts := map[string][]byte{}
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return scripts, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading apk scripts: %w", err)
+		}
+		script, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading apk script %s: %w", hdr.Name, err)
+		}
+		scripts[hdr.Name] = script
+	}
+}
+
+// runSandboxed runs argv in root with the sandbox, returning its output.
+func runSandboxed(ctx context.Context, sandbox, root string, env []string, argv ...string) ([]byte, error) {
+	switch sandbox {
+	case ScriptletsBubblewrap:
+		args := []string{
+			"--unshare-all", "--die-with-parent", "--new-session",
+			"--bind", root, "/",
+			"--dev", "/dev",
+			"--proc", "/proc",
+			"--uid", "0", "--gid", "0",
+			"--chdir", "/",
+			"--clearenv",
+		}
+		for _, e := range env {
+			k, v, _ := strings.Cut(e, "=")
+			args = append(args, "--setenv", k, v)
+		}
+		cmd := exec.CommandContext(ctx, "bwrap", append(args, argv...)...)
+		return cmd.CombinedOutput()
+	case ScriptletsChroot:
+		return runChroot(ctx, root, env, argv...)
+	}
+	return nil, fmt.Errorf("unknown scriptlet sandbox %q", sandbox)
+}
+
+// hostFile is the state of a file on disk, to find the files a scriptlet
+// changed.
+// permissionBits are the bits of a file mode that chmod sets, which the files
+// the scriptlets write or change keep.
+const permissionBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+type hostFile struct {
+	mode    fs.FileMode
+	size    int64
+	modTime time.Time
+	link    string
+}
+
+// exportFS copies fsys to the directory root, leaving out the sandboxed
+// directories and the files that cannot be created without privileges, such
+// as devices. Directories and files are made writable by their owner so that
+// the scriptlets can change them.
+func exportFS(fsys apkfs.FullFS, root string) error {
+	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == "." {
+			return err
+		}
+		if slices.Contains(sandboxedDirs, path) {
+			return fs.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(root, path)
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.Mkdir(dst, 0o700); err != nil {
+				return err
+			}
+			return os.Chmod(dst, mode&permissionBits|0o700)
+		case mode&fs.ModeSymlink != 0:
+			target, err := fsys.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		case mode.IsRegular():
+			b, err := fsys.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(dst, b, 0o600); err != nil {
+				return err
+			}
+			return os.Chmod(dst, mode&permissionBits|0o600)
+		}
+		return nil
+	})
+}
+
+// snapshotDir returns the state of the files under root, by path relative to
+// it.
+func snapshotDir(root string) (map[string]hostFile, error) {
+	files := map[string]hostFile{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(sandboxedDirs, rel) {
+			return fs.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		f := hostFile{mode: info.Mode(), size: info.Size(), modTime: info.ModTime()}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if f.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		files[filepath.ToSlash(rel)] = f
+		return nil
+	})
+	return files, err
+}
+
+// importFS copies the files under root that changed since the before snapshot
+// into fsys, with the modification time mtime, and removes those that were
+// removed.
+func importFS(fsys apkfs.FullFS, root string, before map[string]hostFile, mtime time.Time) error {
+	after, err := snapshotDir(root)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(after))
+	for path := range after {
+		paths = append(paths, path)
+	}
+	// Parents sort before their children.
+	slices.Sort(paths)
+	for _, path := range paths {
+		f := after[path]
+		old, existed := before[path]
+		if existed && old == f {
+			continue
+		}
+		existing, err := fsys.Lstat(path)
+		exists := err == nil
+		// Keep the permissions exportFS widened, unless the scriptlet changed
+		// them.
+		perm := f.mode & permissionBits
+		if exists && existed && old.mode&permissionBits == perm {
+			perm = existing.Mode() & permissionBits
+		}
+		switch {
+		case f.mode.IsDir():
+			if exists && !existing.IsDir() {
+				if err := fsys.Remove(path); err != nil {
+					return err
+				}
+				exists = false
+			}
+			if !exists {
+				if err := fsys.MkdirAll(path, perm); err != nil {
+					return err
+				}
+			} else if err := fsys.Chmod(path, perm); err != nil {
+				return err
+			}
+		case f.mode&fs.ModeSymlink != 0:
+			if exists {
+				if err := fsys.Remove(path); err != nil {
+					return err
+				}
+			}
+			if err := fsys.Symlink(f.link, path); err != nil {
+				return err
+			}
+			// The modification time of a symlink cannot be set.
+			continue
+		case f.mode.IsRegular():
+			if exists && !existing.Mode().IsRegular() {
+				if err := fsys.Remove(path); err != nil {
+					return err
+				}
+			}
+			b, err := os.ReadFile(filepath.Join(root, path))
+			if err != nil {
+				return err
+			}
+			if err := fsys.WriteFile(path, b, perm); err != nil {
+				return err
+			}
+			if err := fsys.Chmod(path, perm); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+		if err := fsys.Chtimes(path, mtime, mtime); err != nil {
+			return err
+		}
+	}
+
+	var removed []string
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			removed = append(removed, path)
+		}
+	}
+	// Children are removed before their parents.
+	slices.Sort(removed)
+	slices.Reverse(removed)
+	for _, path := range removed {
+		if err := fsys.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/options"
+	"chainguard.dev/apko/pkg/tarfs"
+)
+
+func TestScriptletsToRun(t *testing.T) {
+	pkgs := []*apk.InstalledPackage{}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, p := range []struct{ name, origin string }{{"glibc", "glibc"}, {"curl", "curl"}, {"libcurl-openssl4", "curl"}, {"nothing", "nothing"}} {
+		pkg := &apk.InstalledPackage{Package: apk.Package{Name: p.name, Version: "1.0-r0", Origin: p.origin, Checksum: []byte(p.name)}}
+		pkgs = append(pkgs, pkg)
+		if p.name == "nothing" {
+			continue
+		}
+		for _, s := range []string{".post-install", ".pre-install", ".trigger"} {
+			name := fmt.Sprintf("%s-%s.Q1%s%s", pkg.Name, pkg.Version, base64.StdEncoding.EncodeToString(pkg.Checksum), s)
+			body := []byte(p.name + s)
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(body))}))
+			_, err := tw.Write(body)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+
+	fsys := tarfs.New()
+	require.NoError(t, fsys.MkdirAll("usr/lib/apk/db", 0o755))
+	require.NoError(t, fsys.WriteFile("usr/lib/apk/db/scripts.tar", buf.Bytes(), 0o644))
+
+	policyPath := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(policyPath, []byte("scriptlets:\n  allow:\n    - origin: curl\n  deny:\n    - name: libcurl-*\n"), 0o644))
+
+	for _, tt := range []struct {
+		name        string
+		policy      string
+		config      []string
+		noScripts   []string
+		want        []string
+		wantSkipped []string
+	}{{
+		name: "no policy",
+		want: []string{
+			"glibc.pre-install", "glibc.post-install",
+			"curl.pre-install", "curl.post-install",
+			"libcurl-openssl4.pre-install", "libcurl-openssl4.post-install",
+		},
+	}, {
+		name:   "policy",
+		policy: policyPath,
+		want:   []string{"curl.pre-install", "curl.post-install"},
+		wantSkipped: []string{
+			"glibc-1.0-r0.pre-install", "glibc-1.0-r0.post-install",
+			"libcurl-openssl4-1.0-r0.pre-install", "libcurl-openssl4-1.0-r0.post-install",
+		},
+	}, {
+		name:      "no-scripts",
+		config:    []string{"glibc"},
+		noScripts: []string{"lib*"},
+		want:      []string{"curl.pre-install", "curl.post-install"},
+		wantSkipped: []string{
+			"glibc-1.0-r0.pre-install", "glibc-1.0-r0.post-install",
+			"libcurl-openssl4-1.0-r0.pre-install", "libcurl-openssl4-1.0-r0.post-install",
+		},
+	}, {
+		name:      "no-scripts everything",
+		noScripts: []string{"*"},
+		wantSkipped: []string{
+			"glibc-1.0-r0.pre-install", "glibc-1.0-r0.post-install",
+			"curl-1.0-r0.pre-install", "curl-1.0-r0.post-install",
+			"libcurl-openssl4-1.0-r0.pre-install", "libcurl-openssl4-1.0-r0.post-install",
+		},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			bc := &Context{
+				o:  options.Options{PackagePolicy: tt.policy, NoScripts: tt.noScripts},
+				ic: types.ImageConfiguration{Contents: types.ImageContents{NoScripts: tt.config}},
+				fs: fsys,
+			}
+			scriptlets, skipped, err := bc.scriptletsToRun(context.Background(), pkgs)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSkipped, skipped)
+			var got []string
+			for _, s := range scriptlets {
+				require.Equal(t, s.pkg.Name+s.name, string(s.script))
+				got = append(got, string(s.script))
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestScriptletsToRunTriggers(t *testing.T) {
+	fsys := tarfs.New()
+	for _, dir := range []string{"usr/lib/apk/db", "usr/share/fonts/misc", "usr/share/fonts/ttf/deeper", "usr/share/fontsx", "usr/share/man"} {
+		require.NoError(t, fsys.MkdirAll(dir, 0o755))
+	}
+
+	var pkgs []*apk.InstalledPackage
+	var scripts bytes.Buffer
+	var triggers strings.Builder
+	tw := tar.NewWriter(&scripts)
+	for _, p := range []struct {
+		name     string
+		triggers string
+		scripts  []string
+	}{
+		{name: "fontconfig", triggers: "/usr/share/fonts/*", scripts: []string{".post-install", ".trigger"}},
+		{name: "foo", scripts: []string{".post-install"}},
+		{name: "nomatch", triggers: "/usr/share/nothing/*", scripts: []string{".trigger"}},
+		{name: "man-db", triggers: "/usr/share/man", scripts: []string{".trigger"}},
+	} {
+		pkg := &apk.InstalledPackage{Package: apk.Package{Name: p.name, Version: "1.0-r0", Checksum: []byte(p.name)}}
+		pkgs = append(pkgs, pkg)
+		checksum := "Q1" + base64.StdEncoding.EncodeToString(pkg.Checksum)
+		if p.triggers != "" {
+			fmt.Fprintf(&triggers, "%s %s\n", checksum, p.triggers)
+		}
+		for _, s := range p.scripts {
+			body := []byte(p.name + s)
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: pkg.Name + "-" + pkg.Version + "." + checksum + s, Mode: 0o755, Size: int64(len(body))}))
+			_, err := tw.Write(body)
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, fsys.WriteFile("usr/lib/apk/db/scripts.tar", scripts.Bytes(), 0o644))
+	require.NoError(t, fsys.WriteFile("usr/lib/apk/db/triggers", []byte(triggers.String()), 0o644))
+
+	bc := &Context{fs: fsys}
+	scriptlets, skipped, err := bc.scriptletsToRun(context.Background(), pkgs)
+	require.NoError(t, err)
+	require.Empty(t, skipped)
+	type run struct {
+		script string
+		args   []string
+	}
+	var got []run
+	for _, s := range scriptlets {
+		got = append(got, run{string(s.script), s.args})
+	}
+	require.Equal(t, []run{
+		{"fontconfig.post-install", []string{"1.0-r0"}},
+		{"foo.post-install", []string{"1.0-r0"}},
+		{"fontconfig.trigger", []string{"/usr/share/fonts/misc", "/usr/share/fonts/ttf"}},
+		{"man-db.trigger", []string{"/usr/share/man"}},
+	}, got)
+
+	bc.o.NoScripts = []string{"fontconfig"}
+	_, skipped, err = bc.scriptletsToRun(context.Background(), pkgs)
+	require.NoError(t, err)
+	require.Equal(t, []string{"fontconfig-1.0-r0.post-install", "fontconfig-1.0-r0.trigger"}, skipped)
+}
+
+func TestScriptletsWithoutScripts(t *testing.T) {
+	bc := &Context{o: options.Options{Scriptlets: ScriptletsChroot}, fs: tarfs.New()}
+	scriptlets, skipped, err := bc.scriptletsToRun(context.Background(), []*apk.InstalledPackage{{Package: apk.Package{Name: "foo"}}})
+	require.NoError(t, err)
+	require.Empty(t, scriptlets)
+	require.Empty(t, skipped)
+}
+
+func TestNoScriptsInvalidPattern(t *testing.T) {
+	_, err := matchesNoScripts([]string{"[glibc"}, "glibc")
+	require.ErrorContains(t, err, `invalid no-scripts pattern "[glibc"`)
+}
+
+func TestExportImportFS(t *testing.T) {
+	epoch := time.Unix(1700000000, 0).UTC()
+
+	fsys := tarfs.New()
+	require.NoError(t, fsys.MkdirAll("etc/keep", 0o755))
+	require.NoError(t, fsys.MkdirAll("var/gone/deeper", 0o755))
+	require.NoError(t, fsys.MkdirAll("dev", 0o755))
+	require.NoError(t, fsys.WriteFile("etc/readonly", []byte("ro"), 0o444))
+	require.NoError(t, fsys.WriteFile("etc/changed", []byte("before"), 0o444))
+	require.NoError(t, fsys.MkdirAll("usr/bin", 0o755))
+	require.NoError(t, fsys.WriteFile("usr/bin/su", []byte("su"), 0o755))
+	require.NoError(t, fsys.WriteFile("usr/bin/kept", []byte("kept"), 0o755))
+	require.NoError(t, fsys.Chmod("usr/bin/kept", 0o755|fs.ModeSetgid))
+	require.NoError(t, fsys.WriteFile("var/gone/deeper/file", []byte("x"), 0o644))
+	require.NoError(t, fsys.WriteFile("dev/null", nil, 0o666))
+	require.NoError(t, fsys.Symlink("readonly", "etc/link"))
+
+	root := t.TempDir()
+	require.NoError(t, exportFS(fsys, root))
+	_, err := os.Stat(filepath.Join(root, "dev"))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	target, err := os.Readlink(filepath.Join(root, "etc/link"))
+	require.NoError(t, err)
+	require.Equal(t, "readonly", target)
+
+	before, err := snapshotDir(root)
+	require.NoError(t, err)
+
+	// What a scriptlet might do.
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc/changed"), []byte("after"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc/new"), 0o750))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "etc/new/file"), []byte("new"), 0o600))
+	require.NoError(t, os.Remove(filepath.Join(root, "etc/link")))
+	require.NoError(t, os.Symlink("changed", filepath.Join(root, "etc/link")))
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "var/gone")))
+	require.NoError(t, os.Chmod(filepath.Join(root, "usr/bin/su"), 0o755|fs.ModeSetuid))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tmp"), 0o755))
+	require.NoError(t, os.Chmod(filepath.Join(root, "tmp"), 0o777|fs.ModeSticky))
+
+	require.NoError(t, importFS(fsys, root, before, epoch))
+
+	for path, want := range map[string]string{
+		"etc/readonly": "ro",
+		"etc/changed":  "after",
+		"etc/new/file": "new",
+	} {
+		got, err := fsys.ReadFile(path)
+		require.NoError(t, err, path)
+		require.Equal(t, want, string(got), path)
+	}
+	for path, want := range map[string]fs.FileMode{
+		"etc/readonly": 0o444,
+		"etc/changed":  0o444,
+		"etc/new":      0o750,
+		"etc/new/file": 0o600,
+		// The special bits the scriptlet set, and those of the files it did
+		// not change, are kept.
+		"usr/bin/su":   0o755 | fs.ModeSetuid,
+		"usr/bin/kept": 0o755 | fs.ModeSetgid,
+		"tmp":          0o777 | fs.ModeSticky,
+	} {
+		fi, err := fsys.Stat(path)
+		require.NoError(t, err, path)
+		require.Equal(t, want, fi.Mode()&permissionBits, path)
+	}
+	fi, err := fsys.Stat("etc/new/file")
+	require.NoError(t, err)
+	require.True(t, fi.ModTime().Equal(epoch))
+
+	target, err = fsys.Readlink("etc/link")
+	require.NoError(t, err)
+	require.Equal(t, "changed", target)
+
+	_, err = fsys.Stat("var/gone")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = fsys.Stat("dev/null")
+	require.NoError(t, err)
+}
@@ -0,0 +1,87 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// stripSetuid clears the setuid and setgid bits of the files of the image
+// when its modes ask for it, and records the files it changed.
+func (bc *Context) stripSetuid(ctx context.Context) error {
+	if bc.ic.Modes == nil || !bc.ic.Modes.StripSetuid {
+		return nil
+	}
+	ctx, span := otel.Tracer("apko").Start(ctx, "stripSetuid")
+	defer span.End()
+
+	stripped, err := stripSetuid(bc.fs, bc.ic.Modes.SetuidAllow)
+	if err != nil {
+		return fmt.Errorf("stripping setuid bits: %w", err)
+	}
+	if len(stripped) != 0 {
+		clog.FromContext(ctx).Infof("Cleared the setuid and setgid bits of %d files", len(stripped))
+	}
+	bc.strippedSetuid = stripped
+	return nil
+}
+
+// stripSetuid clears the setuid and setgid bits of the regular files of
+// fsys, except those whose paths match one of the globs of allow, and
+// returns the paths of the files it changed, in lexical order.
+func stripSetuid(fsys apkfs.FullFS, allow []string) ([]string, error) {
+	patterns := make([][]string, 0, len(allow))
+	for _, a := range allow {
+		patterns = append(patterns, strings.Split(strings.TrimPrefix(path.Clean("/"+a), "/"), "/"))
+	}
+
+	var stripped []string
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		mode := fi.Mode()
+		if mode&(fs.ModeSetuid|fs.ModeSetgid) == 0 {
+			return nil
+		}
+		segments := strings.Split(p, "/")
+		if slices.ContainsFunc(patterns, func(pattern []string) bool { return matchGlob(pattern, segments) }) {
+			return nil
+		}
+		if err := fsys.Chmod(p, mode&^(fs.ModeSetuid|fs.ModeSetgid)&(fs.ModePerm|fs.ModeSticky)); err != nil {
+			return fmt.Errorf("chmod %q: %w", p, err)
+		}
+		stripped = append(stripped, "/"+p)
+		return nil
+	})
+	return stripped, err
+}
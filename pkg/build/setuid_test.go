@@ -0,0 +1,59 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestStripSetuid(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("usr/bin", 0o755))
+	require.NoError(t, fsys.MkdirAll("var/mail", 0o775))
+	require.NoError(t, fsys.Chmod("var/mail", 0o775|fs.ModeSetgid))
+	for name, mode := range map[string]fs.FileMode{
+		"usr/bin/su":      0o755 | fs.ModeSetuid,
+		"usr/bin/wall":    0o755 | fs.ModeSetgid,
+		"usr/bin/sudo":    0o755 | fs.ModeSetuid,
+		"usr/bin/ls":      0o755,
+		"usr/bin/crontab": 0o711 | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky,
+	} {
+		require.NoError(t, fsys.WriteFile(name, nil, 0o755))
+		require.NoError(t, fsys.Chmod(name, mode))
+	}
+
+	stripped, err := stripSetuid(fsys, []string{"/usr/**/sudo"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"/usr/bin/crontab", "/usr/bin/su", "/usr/bin/wall"}, stripped)
+
+	for name, want := range map[string]fs.FileMode{
+		"usr/bin/su":      0o755,
+		"usr/bin/wall":    0o755,
+		"usr/bin/sudo":    0o755 | fs.ModeSetuid,
+		"usr/bin/ls":      0o755,
+		"usr/bin/crontab": 0o711 | fs.ModeSticky,
+		// Only files are stripped.
+		"var/mail": 0o775 | fs.ModeSetgid | fs.ModeDir,
+	} {
+		fi, err := fsys.Stat(name)
+		require.NoError(t, err)
+		require.Equal(t, want, fi.Mode(), name)
+	}
+}
@@ -0,0 +1,411 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// systemdConfDir is the directory of the unit links that enable units.
+const systemdConfDir = "etc/systemd/system"
+
+// systemdUnitDirs are the directories of unit files, in order of precedence.
+var systemdUnitDirs = []string{systemdConfDir, "usr/lib/systemd/system", "lib/systemd/system"}
+
+// systemdPresetDirs are the directories of preset files, in order of
+// precedence.
+var systemdPresetDirs = []string{"etc/systemd/system-preset", "usr/lib/systemd/system-preset", "lib/systemd/system-preset"}
+
+// errSystemdUnitMasked is the error of the units linked to /dev/null.
+var errSystemdUnitMasked = errors.New("unit is masked")
+
+// systemdUnitTypes are the suffixes of unit files.
+var systemdUnitTypes = []string{".service", ".socket", ".device", ".mount", ".automount", ".swap", ".target", ".path", ".timer", ".slice", ".scope"}
+
+// setupSystemd enables and disables the systemd units of the image as the
+// image configuration asks, so that a booted image starts the right units
+// without running systemctl on first boot.
+func (bc *Context) setupSystemd(ctx context.Context) error {
+	if bc.ic.Systemd == nil {
+		return nil
+	}
+	ctx, span := otel.Tracer("apko").Start(ctx, "setupSystemd")
+	defer span.End()
+
+	return applySystemd(ctx, bc.fs, bc.ic.Systemd, bc.ic.Modes.Directory())
+}
+
+// applySystemd applies the presets of fsys if sd asks for them, then disables
+// and enables the units of sd, and links its default target, like systemctl.
+func applySystemd(ctx context.Context, fsys apkfs.FullFS, sd *types.ImageSystemd, dirMode fs.FileMode) error {
+	log := clog.FromContext(ctx)
+	u := &systemdUnits{fsys: fsys, dirMode: dirMode}
+
+	if sd.Presets {
+		presets, err := u.readPresets()
+		if err != nil {
+			return err
+		}
+		units, err := u.list()
+		if err != nil {
+			return err
+		}
+		for _, unit := range units {
+			rule := presets.lookup(unit)
+			switch rule.action {
+			case "enable":
+				names := []string{unit}
+				if len(rule.instances) != 0 && strings.Contains(unit, "@.") {
+					names = nil
+					for _, instance := range rule.instances {
+						names = append(names, strings.Replace(unit, "@.", "@"+instance+".", 1))
+					}
+				}
+				for _, name := range names {
+					if err := u.enable(name, false); err != nil {
+						return fmt.Errorf("applying preset of systemd unit %s: %w", name, err)
+					}
+				}
+			case "disable":
+				if err := u.disable(unit); err != nil {
+					return fmt.Errorf("applying preset of systemd unit %s: %w", unit, err)
+				}
+			}
+		}
+	}
+
+	for _, unit := range sd.Disable {
+		log.Infof("disabling systemd unit %s", unit)
+		if _, err := u.find(unit); err != nil && !errors.Is(err, errSystemdUnitMasked) {
+			return err
+		}
+		if err := u.disable(unit); err != nil {
+			return fmt.Errorf("disabling systemd unit %s: %w", unit, err)
+		}
+	}
+	for _, unit := range sd.Enable {
+		log.Infof("enabling systemd unit %s", unit)
+		if err := u.enable(unit, true); err != nil {
+			return fmt.Errorf("enabling systemd unit %s: %w", unit, err)
+		}
+	}
+
+	if sd.DefaultTarget != "" {
+		target, err := u.find(sd.DefaultTarget)
+		if err != nil {
+			return err
+		}
+		if err := u.link(path.Join(systemdConfDir, "default.target"), target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// systemdUnits enables and disables the units of an image.
+type systemdUnits struct {
+	fsys    apkfs.FullFS
+	dirMode fs.FileMode
+}
+
+// list returns the names of the unit files of the image, sorted, leaving out
+// the masked ones.
+func (u *systemdUnits) list() ([]string, error) {
+	var units []string
+	for _, dir := range systemdUnitDirs {
+		entries, err := u.fsys.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || !slices.Contains(systemdUnitTypes, path.Ext(name)) || slices.Contains(units, name) {
+				continue
+			}
+			if _, err := u.find(name); errors.Is(err, errSystemdUnitMasked) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			units = append(units, name)
+		}
+	}
+	slices.Sort(units)
+	return units, nil
+}
+
+// find returns the absolute path of the unit file of unit, which for an
+// instance of a template unit is the template unit file unless the instance
+// has its own.
+func (u *systemdUnits) find(unit string) (string, error) {
+	names := []string{unit}
+	if prefix, rest, ok := strings.Cut(unit, "@"); ok && !strings.HasPrefix(rest, ".") {
+		names = append(names, prefix+"@"+path.Ext(unit))
+	}
+	for _, name := range names {
+		for _, dir := range systemdUnitDirs {
+			p := path.Join(dir, name)
+			if target, err := u.fsys.Readlink(p); err == nil && target == "/dev/null" {
+				return "", fmt.Errorf("systemd unit %s: %w", unit, errSystemdUnitMasked)
+			}
+			if _, err := u.fsys.Stat(p); err == nil {
+				return "/" + p, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("systemd unit %s is not installed", unit)
+}
+
+// systemdInstall is the [Install] section of a unit file.
+type systemdInstall struct {
+	wantedBy, requiredBy, alias, also []string
+	defaultInstance                   string
+}
+
+// readInstall reads the [Install] section of the unit file at p.
+func (u *systemdUnits) readInstall(p string) (*systemdInstall, error) {
+	f, err := u.fsys.Open(strings.TrimPrefix(p, "/"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	install := &systemdInstall{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+			continue
+		case strings.HasPrefix(line, "["):
+			section = strings.Trim(line, "[]")
+			continue
+		case section != "Install":
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		values := strings.Fields(value)
+		switch strings.TrimSpace(key) {
+		case "WantedBy":
+			install.wantedBy = append(install.wantedBy, values...)
+		case "RequiredBy":
+			install.requiredBy = append(install.requiredBy, values...)
+		case "Alias":
+			install.alias = append(install.alias, values...)
+		case "Also":
+			install.also = append(install.also, values...)
+		case "DefaultInstance":
+			install.defaultInstance = strings.TrimSpace(value)
+		}
+	}
+	return install, scanner.Err()
+}
+
+// enable links unit into the wants and requires directories of the units that
+// its [Install] section names, links its aliases and enables the units it
+// names with Also=, as systemctl enable does. A template unit is enabled as
+// its default instance. Unless explicit, units that cannot be enabled, such as
+// masked units and templates without a default instance, are skipped.
+func (u *systemdUnits) enable(unit string, explicit bool) error {
+	return u.enableOnce(unit, explicit, map[string]bool{})
+}
+
+func (u *systemdUnits) enableOnce(unit string, explicit bool, seen map[string]bool) error {
+	if seen[unit] {
+		return nil
+	}
+	seen[unit] = true
+
+	unitPath, err := u.find(unit)
+	if err != nil {
+		if explicit {
+			return err
+		}
+		return nil
+	}
+	install, err := u.readInstall(unitPath)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(unit, "@.") {
+		if install.defaultInstance == "" {
+			if explicit {
+				return fmt.Errorf("systemd unit %s is a template without a default instance, enable an instance of it", unit)
+			}
+			return nil
+		}
+		unit = strings.Replace(unit, "@.", "@"+install.defaultInstance+".", 1)
+	}
+
+	prefix, instance, _ := strings.Cut(strings.TrimSuffix(unit, path.Ext(unit)), "@")
+	specifiers := strings.NewReplacer("%%", "%", "%n", unit, "%N", strings.TrimSuffix(unit, path.Ext(unit)), "%p", prefix, "%i", instance)
+
+	for _, by := range install.wantedBy {
+		if err := u.link(path.Join(systemdConfDir, specifiers.Replace(by)+".wants", unit), unitPath); err != nil {
+			return err
+		}
+	}
+	for _, by := range install.requiredBy {
+		if err := u.link(path.Join(systemdConfDir, specifiers.Replace(by)+".requires", unit), unitPath); err != nil {
+			return err
+		}
+	}
+	for _, alias := range install.alias {
+		if err := u.link(path.Join(systemdConfDir, specifiers.Replace(alias)), unitPath); err != nil {
+			return err
+		}
+	}
+	for _, also := range install.also {
+		if err := u.enableOnce(specifiers.Replace(also), explicit, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disable removes the links that enable unit, as systemctl disable does: the
+// links named after it in wants and requires directories and, unless it is
+// an instance, all the links to its unit file. Masks are left alone.
+func (u *systemdUnits) disable(unit string) error {
+	instance := !strings.Contains(unit, "@.") && strings.Contains(unit, "@")
+	var links []string
+	err := fs.WalkDir(u.fsys, systemdConfDir, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == systemdConfDir {
+			return fs.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := u.fsys.Readlink(p)
+		if err != nil {
+			return err
+		}
+		if target == "/dev/null" {
+			return nil
+		}
+		dir := path.Dir(p)
+		named := path.Base(p) == unit && (strings.HasSuffix(dir, ".wants") || strings.HasSuffix(dir, ".requires"))
+		if named || (!instance && path.Base(target) == unit) {
+			links = append(links, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if err := u.fsys.Remove(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// link creates the symlink name to target, replacing any file there.
+func (u *systemdUnits) link(name, target string) error {
+	if err := u.fsys.MkdirAll(path.Dir(name), u.dirMode); err != nil {
+		return err
+	}
+	if err := u.fsys.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return u.fsys.Symlink(target, name)
+}
+
+// systemdPresetRule is a line of a preset file.
+type systemdPresetRule struct {
+	action    string
+	pattern   string
+	instances []string
+}
+
+type systemdPresets []systemdPresetRule
+
+// readPresets reads the rules of the preset files of the image, in the order
+// of their file names. A file in an earlier directory of systemdPresetDirs
+// hides the files of the same name in later ones.
+func (u *systemdUnits) readPresets() (systemdPresets, error) {
+	files := map[string]string{}
+	for _, dir := range systemdPresetDirs {
+		entries, err := u.fsys.ReadDir(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if _, ok := files[entry.Name()]; !ok && path.Ext(entry.Name()) == ".preset" {
+				files[entry.Name()] = path.Join(dir, entry.Name())
+			}
+		}
+	}
+
+	var presets systemdPresets
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		b, err := u.fsys.ReadFile(files[name])
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range strings.Split(string(b), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+				continue
+			}
+			switch {
+			case len(fields) < 2:
+				return nil, fmt.Errorf("/%s:%d: %q is not a preset rule", files[name], i+1, line)
+			case fields[0] == "enable":
+			case fields[0] == "disable" || fields[0] == "ignore":
+				if len(fields) != 2 {
+					return nil, fmt.Errorf("/%s:%d: %q is not a preset rule", files[name], i+1, line)
+				}
+			default:
+				return nil, fmt.Errorf("/%s:%d: %q is not a preset rule", files[name], i+1, line)
+			}
+			presets = append(presets, systemdPresetRule{action: fields[0], pattern: fields[1], instances: fields[2:]})
+		}
+	}
+	return presets, nil
+}
+
+// lookup returns the first rule that matches unit. Units that no rule matches
+// are enabled.
+func (p systemdPresets) lookup(unit string) systemdPresetRule {
+	for _, rule := range p {
+		if ok, _ := path.Match(rule.pattern, unit); ok {
+			return rule
+		}
+	}
+	return systemdPresetRule{action: "enable", pattern: unit}
+}
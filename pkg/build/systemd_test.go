@@ -0,0 +1,194 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package build
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+// systemdTestUnits are the unit files of the image of the systemd tests.
+var systemdTestUnits = map[string]string{
+	"usr/lib/systemd/system/multi-user.target": "[Unit]\nDescription=Multi-User System\n",
+	"usr/lib/systemd/system/graphical.target":  "[Unit]\nDescription=Graphical Interface\n",
+	"usr/lib/systemd/system/sshd.service": `[Unit]
+Description=OpenSSH server
+
+[Service]
+ExecStart=/usr/sbin/sshd -D
+
+[Install]
+WantedBy=multi-user.target
+Alias=ssh.service
+Also=sshd-keygen.service
+`,
+	"usr/lib/systemd/system/sshd-keygen.service": `[Service]
+Type=oneshot
+ExecStart=/usr/bin/ssh-keygen -A
+
+[Install]
+RequiredBy=sshd.service
+`,
+	"usr/lib/systemd/system/getty@.service": `[Service]
+ExecStart=-/sbin/agetty %I
+
+[Install]
+WantedBy=getty.target
+DefaultInstance=tty1
+`,
+	"usr/lib/systemd/system/serial-getty@.service": `[Service]
+ExecStart=-/sbin/agetty %I 115200
+
+[Install]
+WantedBy=getty.target
+`,
+	"usr/lib/systemd/system/cron.service":   "[Service]\nExecStart=/usr/sbin/crond -f\n\n[Install]\nWantedBy=multi-user.target\n",
+	"usr/lib/systemd/system/debug.service":  "[Service]\nExecStart=/bin/sh\n\n[Install]\nWantedBy=multi-user.target\n",
+	"usr/lib/systemd/system/static.service": "[Service]\nExecStart=/bin/true\n",
+}
+
+func systemdTestFS(t *testing.T) apkfs.FullFS {
+	t.Helper()
+	fsys := apkfs.NewMemFS()
+	for name, content := range systemdTestUnits {
+		require.NoError(t, fsys.MkdirAll(path.Dir(name), 0o755))
+		require.NoError(t, fsys.WriteFile(name, []byte(content), 0o644))
+	}
+	return fsys
+}
+
+// systemdLinks returns the links of /etc/systemd/system by name.
+func systemdLinks(t *testing.T, fsys apkfs.FullFS) map[string]string {
+	t.Helper()
+	links := map[string]string{}
+	require.NoError(t, fs.WalkDir(fsys, systemdConfDir, func(p string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := fsys.Readlink(p)
+			require.NoError(t, err)
+			links[p] = target
+		}
+		return nil
+	}))
+	return links
+}
+
+func TestApplySystemd(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("enable", func(t *testing.T) {
+		fsys := systemdTestFS(t)
+		require.NoError(t, applySystemd(ctx, fsys, &types.ImageSystemd{
+			Enable:        []string{"sshd.service", "getty@.service", "serial-getty@ttyS0.service"},
+			DefaultTarget: "multi-user.target",
+		}, 0o755))
+		require.Equal(t, map[string]string{
+			"etc/systemd/system/default.target":                                "/usr/lib/systemd/system/multi-user.target",
+			"etc/systemd/system/multi-user.target.wants/sshd.service":          "/usr/lib/systemd/system/sshd.service",
+			"etc/systemd/system/ssh.service":                                   "/usr/lib/systemd/system/sshd.service",
+			"etc/systemd/system/sshd.service.requires/sshd-keygen.service":     "/usr/lib/systemd/system/sshd-keygen.service",
+			"etc/systemd/system/getty.target.wants/getty@tty1.service":         "/usr/lib/systemd/system/getty@.service",
+			"etc/systemd/system/getty.target.wants/serial-getty@ttyS0.service": "/usr/lib/systemd/system/serial-getty@.service",
+		}, systemdLinks(t, fsys))
+	})
+
+	t.Run("presets", func(t *testing.T) {
+		fsys := systemdTestFS(t)
+		// A link of the package of debug.service, which the presets disable.
+		require.NoError(t, fsys.MkdirAll("etc/systemd/system/multi-user.target.wants", 0o755))
+		require.NoError(t, fsys.Symlink("/usr/lib/systemd/system/debug.service", "etc/systemd/system/multi-user.target.wants/debug.service"))
+		// The presets of the image hide the vendor presets of the same name.
+		require.NoError(t, fsys.MkdirAll("usr/lib/systemd/system-preset", 0o755))
+		require.NoError(t, fsys.WriteFile("usr/lib/systemd/system-preset/90-default.preset", []byte("enable debug.service\n"), 0o644))
+		require.NoError(t, fsys.WriteFile("usr/lib/systemd/system-preset/99-default.preset", []byte("# Disable everything else.\ndisable *\n"), 0o644))
+		require.NoError(t, fsys.MkdirAll("etc/systemd/system-preset", 0o755))
+		require.NoError(t, fsys.WriteFile("etc/systemd/system-preset/90-default.preset", []byte(`enable sshd.service
+enable serial-getty@.service ttyS0 ttyS1
+enable c*.service
+`), 0o644))
+
+		require.NoError(t, applySystemd(ctx, fsys, &types.ImageSystemd{
+			Presets: true,
+			Disable: []string{"cron.service"},
+		}, 0o755))
+		require.Equal(t, map[string]string{
+			"etc/systemd/system/multi-user.target.wants/sshd.service":          "/usr/lib/systemd/system/sshd.service",
+			"etc/systemd/system/ssh.service":                                   "/usr/lib/systemd/system/sshd.service",
+			"etc/systemd/system/sshd.service.requires/sshd-keygen.service":     "/usr/lib/systemd/system/sshd-keygen.service",
+			"etc/systemd/system/getty.target.wants/serial-getty@ttyS0.service": "/usr/lib/systemd/system/serial-getty@.service",
+			"etc/systemd/system/getty.target.wants/serial-getty@ttyS1.service": "/usr/lib/systemd/system/serial-getty@.service",
+		}, systemdLinks(t, fsys))
+	})
+
+	t.Run("disable keeps masks", func(t *testing.T) {
+		fsys := systemdTestFS(t)
+		require.NoError(t, fsys.MkdirAll("etc/systemd/system/multi-user.target.wants", 0o755))
+		require.NoError(t, fsys.Symlink("/usr/lib/systemd/system/debug.service", "etc/systemd/system/multi-user.target.wants/debug.service"))
+		require.NoError(t, fsys.Symlink("/dev/null", "etc/systemd/system/cron.service"))
+
+		require.NoError(t, applySystemd(ctx, fsys, &types.ImageSystemd{
+			Disable: []string{"debug.service", "cron.service"},
+		}, 0o755))
+		require.Equal(t, map[string]string{
+			"etc/systemd/system/cron.service": "/dev/null",
+		}, systemdLinks(t, fsys))
+	})
+
+	for _, tt := range []struct {
+		name    string
+		sd      *types.ImageSystemd
+		wantErr string
+	}{{
+		name:    "not installed",
+		sd:      &types.ImageSystemd{Enable: []string{"nginx.service"}},
+		wantErr: "enabling systemd unit nginx.service: systemd unit nginx.service is not installed",
+	}, {
+		name:    "template without default instance",
+		sd:      &types.ImageSystemd{Enable: []string{"serial-getty@.service"}},
+		wantErr: "enabling systemd unit serial-getty@.service: systemd unit serial-getty@.service is a template without a default instance, enable an instance of it",
+	}, {
+		name:    "masked",
+		sd:      &types.ImageSystemd{Enable: []string{"debug.service"}},
+		wantErr: "enabling systemd unit debug.service: systemd unit debug.service: unit is masked",
+	}, {
+		name:    "missing default target",
+		sd:      &types.ImageSystemd{DefaultTarget: "rescue.target"},
+		wantErr: "systemd unit rescue.target is not installed",
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys := systemdTestFS(t)
+			require.NoError(t, fsys.MkdirAll(systemdConfDir, 0o755))
+			require.NoError(t, fsys.Symlink("/dev/null", "etc/systemd/system/debug.service"))
+			require.EqualError(t, applySystemd(ctx, fsys, tt.sd, 0o755), tt.wantErr)
+		})
+	}
+}
+
+func TestSystemdPresetsLookup(t *testing.T) {
+	presets := systemdPresets{
+		{action: "ignore", pattern: "foo.service"},
+		{action: "disable", pattern: "*"},
+	}
+	require.Equal(t, "ignore", presets.lookup("foo.service").action)
+	require.Equal(t, "disable", presets.lookup("bar.service").action)
+	require.Equal(t, "enable", systemdPresets{}.lookup("bar.service").action)
+}
@@ -22,6 +22,8 @@ import (
 	"io/fs"
 	"iter"
 	"os"
+	"slices"
+	"time"
 
 	"go.opentelemetry.io/otel"
 	"golang.org/x/sys/unix"
@@ -32,15 +34,39 @@ import (
 
 const xattrTarPAXRecordsPrefix = "SCHILY.xattr."
 
-// writeTar writes a tarball to the provided io.Writer from the provided fs.FS.
+// determinism configures how walkFS treats inputs that would make the layers
+// depend on the host or on when the build ran.
+type determinism struct {
+	// strict makes such inputs an error instead of writing them as is.
+	strict bool
+	// epoch is the build date, which no file may be newer than in strict mode.
+	epoch time.Time
+}
+
+// check returns an error if hdr holds nondeterministic input in strict mode.
+func (d determinism) check(hdr *tar.Header) error {
+	if !d.strict {
+		return nil
+	}
+	if hdr.ModTime.After(d.epoch) {
+		return fmt.Errorf("%s: modification time %s is newer than the build date %s", hdr.Name, hdr.ModTime.UTC().Format(time.RFC3339), d.epoch.UTC().Format(time.RFC3339))
+	}
+	if hdr.ModTime.Nanosecond() != 0 {
+		return fmt.Errorf("%s: modification time %s has sub-second precision", hdr.Name, hdr.ModTime.UTC().Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+// writeTar writes a tarball to the provided io.Writer from the provided fs.FS,
+// leaving out the omit paths and everything under them.
 // The etc/passwd and etc/group file provide username and group name mappings for the tar.
-func writeTar(ctx context.Context, tw *tar.Writer, fsys apkfs.FullFS) error { //nolint:gocyclo
+func writeTar(ctx context.Context, tw *tar.Writer, fsys apkfs.FullFS, det determinism, omit []string) error { //nolint:gocyclo
 	ctx, span := otel.Tracer("go-apk").Start(ctx, "writeTar")
 	defer span.End()
 
 	buf := make([]byte, 1<<20)
 
-	for f, err := range walkFS(ctx, fsys) {
+	for f, err := range walkFS(ctx, fsys, det, omit) {
 		if err != nil {
 			return err
 		}
@@ -78,7 +104,18 @@ type file struct {
 	header *tar.Header
 }
 
-func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
+// walkFS yields the files of fsys in lexical order with canonical tar
+// headers: only the fields apko controls are set, so the headers (and the PAX
+// records the tar writer emits for them, which it sorts by key) do not depend
+// on the host or the Go version.
+//
+// The omit paths, relative to the root of fsys, are skipped along with
+// everything under them.
+//
+// Hardlinks are resolved in walk order: the first path of a hardlinked file is
+// written with its contents and the later ones link to it, whichever path the
+// package recorded as the link target.
+func walkFS(ctx context.Context, fsys apkfs.FullFS, det determinism, omit []string) iter.Seq2[*file, error] {
 	return func(yield func(*file, error) bool) {
 		usersFile, _ := passwd.ReadUserFile(fsys, "etc/passwd")
 		groupsFile, _ := passwd.ReadGroupFile(fsys, "etc/group")
@@ -91,6 +128,12 @@ func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
 			groups[int(g.GID)] = g.GroupName
 		}
 
+		// written holds the hardlink targets that have been yielded, and
+		// promoted maps the targets that have not to the first path linking
+		// to them, which was yielded with the contents instead.
+		written := map[string]bool{}
+		promoted := map[string]string{}
+
 		if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 			if err := ctx.Err(); err != nil {
 				return err
@@ -104,6 +147,13 @@ func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
 				return err
 			}
 
+			if slices.Contains(omit, path) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+
 			info, err := d.Info()
 			if err != nil {
 				return err
@@ -144,6 +194,12 @@ func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
 
 			header.ModTime = info.ModTime()
 
+			// These are only set from the host's stat data, which apko does
+			// not control.
+			header.AccessTime = time.Time{}
+			header.ChangeTime = time.Time{}
+			header.Xattrs = nil //nolint:staticcheck // Superseded by PAXRecords, but still honored by the writer.
+
 			if name, ok := users[header.Uid]; ok {
 				header.Uname = name
 			}
@@ -155,6 +211,30 @@ func walkFS(ctx context.Context, fsys apkfs.FullFS) iter.Seq2[*file, error] {
 				header.Typeflag = tar.TypeSymlink
 			}
 
+			switch header.Typeflag {
+			case tar.TypeLink:
+				if target, ok := promoted[header.Linkname]; ok {
+					header.Linkname = target
+				} else if !written[header.Linkname] {
+					promoted[header.Linkname] = path
+					header.Typeflag = tar.TypeReg
+					header.Linkname = ""
+					header.Size = info.Size()
+				}
+			case tar.TypeReg:
+				if target, ok := promoted[path]; ok {
+					header.Typeflag = tar.TypeLink
+					header.Linkname = target
+					header.Size = 0
+				} else {
+					written[path] = true
+				}
+			}
+
+			if err := det.check(header); err != nil {
+				return err
+			}
+
 			if header.PAXRecords == nil {
 				header.PAXRecords = map[string]string{}
 			}
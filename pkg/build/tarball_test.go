@@ -4,7 +4,12 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -29,7 +34,7 @@ func TestWriteTar(t *testing.T) {
 	err = m.SetXattr(file, "user.file", []byte("bar"))
 	require.NoError(t, err, "error setting xattr on %s", file)
 	tw := tar.NewWriter(&buf)
-	err = writeTar(context.Background(), tw, m)
+	err = writeTar(context.Background(), tw, m, determinism{}, nil)
 	require.NoError(t, err, "error writing tar")
 	err = tw.Close()
 	require.NoError(t, err, "error closing tar writer")
@@ -46,3 +51,142 @@ func TestWriteTar(t *testing.T) {
 	require.Equal(t, file, hdr.Name, "tar file header name mismatch")
 	require.Equal(t, "bar", hdr.PAXRecords[xattrTarPAXRecordsPrefix+"user.file"], "tar header for file xattr mismatch")
 }
+
+func TestWriteTarXattrOrder(t *testing.T) {
+	names := []string{"user.c", "security.capability", "user.a", "trusted.b"}
+	var outputs [][]byte
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}, {2, 0, 3, 1}} {
+		m := fs.NewMemFS()
+		require.NoError(t, m.WriteFile("file", []byte("hello"), 0o644))
+		for _, i := range order {
+			require.NoError(t, m.SetXattr("file", names[i], []byte(names[i])))
+		}
+		var buf bytes.Buffer
+		require.NoError(t, writeTar(context.Background(), tar.NewWriter(&buf), m, determinism{}, nil))
+		outputs = append(outputs, buf.Bytes())
+	}
+	for _, out := range outputs[1:] {
+		require.Equal(t, outputs[0], out, "tarball depends on the order xattrs were set in")
+	}
+
+	// The PAX records are written sorted by key.
+	prev := -1
+	for _, name := range []string{"security.capability", "trusted.b", "user.a", "user.c"} {
+		i := bytes.Index(outputs[0], []byte(xattrTarPAXRecordsPrefix+name+"="))
+		require.Greater(t, i, prev, "PAX record for %s is out of order", name)
+		prev = i
+	}
+}
+
+// hardlinkFS reports the paths in links as hardlinks to their targets, the
+// way tarfs does for hardlinks installed from packages.
+type hardlinkFS struct {
+	fs.FullFS
+	links map[string]string
+}
+
+func (h *hardlinkFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	entries, err := h.FullFS.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		if target, ok := h.links[path.Join(name, e.Name())]; ok {
+			entries[i] = hardlinkEntry{DirEntry: e, target: target}
+		}
+	}
+	return entries, nil
+}
+
+type hardlinkEntry struct {
+	iofs.DirEntry
+	target string
+}
+
+func (e hardlinkEntry) Info() (iofs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	if err != nil {
+		return nil, err
+	}
+	return hardlinkInfo{FileInfo: info, target: e.target}, nil
+}
+
+type hardlinkInfo struct {
+	iofs.FileInfo
+	target string
+}
+
+func (i hardlinkInfo) Sys() any {
+	hdr := *i.FileInfo.Sys().(*tar.Header)
+	hdr.Typeflag = tar.TypeLink
+	hdr.Linkname = i.target
+	return &hdr
+}
+
+func TestWriteTarHardlinks(t *testing.T) {
+	m := fs.NewMemFS()
+	require.NoError(t, m.MkdirAll("usr/bin", 0o755))
+	require.NoError(t, m.WriteFile("usr/bin/z", []byte("binary"), 0o755))
+	require.NoError(t, m.Link("usr/bin/z", "usr/bin/a"))
+	require.NoError(t, m.Link("usr/bin/z", "usr/bin/m"))
+
+	// The package recorded usr/bin/a and usr/bin/m as links to usr/bin/z,
+	// which is walked last.
+	fsys := &hardlinkFS{FullFS: m, links: map[string]string{
+		"usr/bin/a": "usr/bin/z",
+		"usr/bin/m": "usr/bin/z",
+	}}
+	var buf bytes.Buffer
+	require.NoError(t, writeTar(context.Background(), tar.NewWriter(&buf), fsys, determinism{}, nil))
+
+	type entry struct {
+		typeflag byte
+		linkname string
+		content  string
+	}
+	got := map[string]entry{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		got[hdr.Name] = entry{hdr.Typeflag, hdr.Linkname, string(b)}
+	}
+	require.Equal(t, entry{tar.TypeReg, "", "binary"}, got["usr/bin/a"])
+	require.Equal(t, entry{tar.TypeLink, "usr/bin/a", ""}, got["usr/bin/m"])
+	require.Equal(t, entry{tar.TypeLink, "usr/bin/a", ""}, got["usr/bin/z"])
+}
+
+func TestWriteTarStrict(t *testing.T) {
+	epoch := time.Unix(1700000000, 0)
+	for _, tt := range []struct {
+		name    string
+		mtime   time.Time
+		wantErr string
+	}{
+		{name: "older", mtime: epoch.Add(-time.Hour)},
+		{name: "same", mtime: epoch},
+		{name: "newer", mtime: epoch.Add(time.Second), wantErr: "newer than the build date"},
+		{name: "sub-second", mtime: epoch.Add(-time.Millisecond), wantErr: "sub-second precision"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			m := fs.NewMemFS()
+			require.NoError(t, m.WriteFile("file", []byte("hello"), 0o644))
+			require.NoError(t, m.Chtimes("file", tt.mtime, tt.mtime))
+
+			// Without strict mode, the file is written as is.
+			require.NoError(t, writeTar(context.Background(), tar.NewWriter(io.Discard), m, determinism{epoch: epoch}, nil))
+
+			err := writeTar(context.Background(), tar.NewWriter(io.Discard), m, determinism{strict: true, epoch: epoch}, nil)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// LookupArchitecture parses s like ParseArchitecture, and reports whether it
+// is one of AllArchs.
+func LookupArchitecture(s string) (Architecture, bool) {
+	a := ParseArchitecture(strings.TrimSpace(s))
+	return a, slices.Contains(AllArchs, a)
+}
+
+// ArchitectureFromGo returns the architecture of a GOARCH and, for arm, a
+// GOARM: arm/v6 for GOARM 5 and 6, and arm/v7 otherwise.
+func ArchitectureFromGo(goarch, goarm string) (Architecture, error) {
+	if goarch == "arm" {
+		if strings.HasPrefix(goarm, "5") || strings.HasPrefix(goarm, "6") {
+			return armv6, nil
+		}
+		return armv7, nil
+	}
+	a, ok := LookupArchitecture(goarch)
+	if !ok {
+		return "", fmt.Errorf("unsupported GOARCH %q", goarch)
+	}
+	return a, nil
+}
+
+// ToGo returns the GOARCH of the architecture and, for arm/v6 and arm/v7,
+// its GOARM.
+func (a Architecture) ToGo() (goarch, goarm string) {
+	switch a := ParseArchitecture(a.String()); a {
+	case armv6:
+		return "arm", "6"
+	case armv7:
+		return "arm", "7"
+	default:
+		return string(a), ""
+	}
+}
+
+// ArchitectureFromPlatform returns the architecture of an OCI platform. Its
+// OS must be linux (or unset), and arm platforms without a variant are
+// arm/v7. Other variants, such as the v8.2 of arm64, are ignored.
+func ArchitectureFromPlatform(p v1.Platform) (Architecture, error) {
+	if p.OS != "" && p.OS != "linux" {
+		return "", fmt.Errorf("unsupported OS %q", p.OS)
+	}
+	if p.Architecture == "arm" {
+		switch p.Variant {
+		case "v6":
+			return armv6, nil
+		case "", "v7":
+			return armv7, nil
+		default:
+			return "", fmt.Errorf("unsupported arm variant %q", p.Variant)
+		}
+	}
+	a, ok := LookupArchitecture(p.Architecture)
+	if !ok || a == armv6 || a == armv7 {
+		return "", fmt.Errorf("unsupported architecture %q", p.Architecture)
+	}
+	return a, nil
+}
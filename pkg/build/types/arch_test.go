@@ -0,0 +1,102 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchitectureNames(t *testing.T) {
+	for _, a := range AllArchs {
+		t.Run(a.String(), func(t *testing.T) {
+			for _, name := range []string{a.String(), a.ToAPK()} {
+				got, ok := LookupArchitecture(name)
+				require.True(t, ok, name)
+				require.Equal(t, a, got, name)
+			}
+
+			goarch, goarm := a.ToGo()
+			got, err := ArchitectureFromGo(goarch, goarm)
+			require.NoError(t, err)
+			require.Equal(t, a, got)
+
+			got, err = ArchitectureFromPlatform(*a.ToOCIPlatform())
+			require.NoError(t, err)
+			require.Equal(t, a, got)
+		})
+	}
+}
+
+func TestLookupArchitecture(t *testing.T) {
+	for in, want := range map[string]Architecture{
+		"i686":         _386,
+		"x86_64":       amd64,
+		" aarch64 ":    arm64,
+		"arm":          armv7,
+		"armv7l":       armv7,
+		"armv6l":       armv6,
+		"loongarch64":  loong64,
+		"ppc64le":      ppc64le,
+		"riscv64":      riscv64,
+		"s390x":        s390x,
+		"unknown-arch": "unknown-arch",
+	} {
+		got, ok := LookupArchitecture(in)
+		require.Equal(t, want, got, in)
+		require.Equal(t, in != "unknown-arch", ok, in)
+	}
+}
+
+func TestArchitectureFromGo(t *testing.T) {
+	got, err := ArchitectureFromGo("arm", "6,softfloat")
+	require.NoError(t, err)
+	require.Equal(t, armv6, got)
+	got, err = ArchitectureFromGo("arm", "")
+	require.NoError(t, err)
+	require.Equal(t, armv7, got)
+	_, err = ArchitectureFromGo("wasm", "")
+	require.EqualError(t, err, `unsupported GOARCH "wasm"`)
+
+	goarch, goarm := amd64.ToGo()
+	require.Equal(t, "amd64", goarch)
+	require.Empty(t, goarm)
+}
+
+func TestArchitectureFromPlatform(t *testing.T) {
+	for _, tt := range []struct {
+		platform v1.Platform
+		want     Architecture
+		wantErr  string
+	}{
+		{platform: v1.Platform{Architecture: "arm"}, want: armv7},
+		{platform: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, want: armv6},
+		{platform: v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8.2"}, want: arm64},
+		{platform: v1.Platform{OS: "linux", Architecture: "arm", Variant: "v5"}, wantErr: `unsupported arm variant "v5"`},
+		{platform: v1.Platform{OS: "windows", Architecture: "amd64"}, wantErr: `unsupported OS "windows"`},
+		{platform: v1.Platform{OS: "linux", Architecture: "armhf"}, wantErr: `unsupported architecture "armhf"`},
+		{platform: v1.Platform{OS: "linux", Architecture: "mips"}, wantErr: `unsupported architecture "mips"`},
+	} {
+		got, err := ArchitectureFromPlatform(tt.platform)
+		if tt.wantErr != "" {
+			require.EqualError(t, err, tt.wantErr)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.want, got)
+	}
+}
@@ -18,8 +18,14 @@ import (
 	"context"
 	"fmt"
 	"hash"
+	"hash/fnv"
+	"io"
+	"io/fs"
 	"maps"
+	"mime"
+	"net/netip"
 	"os"
+	"path"
 	"reflect"
 	"regexp"
 	"slices"
@@ -31,6 +37,7 @@ import (
 	"github.com/chainguard-dev/clog"
 
 	"chainguard.dev/apko/pkg/paths"
+	"chainguard.dev/apko/pkg/supervision"
 	"chainguard.dev/apko/pkg/vcs"
 )
 
@@ -38,6 +45,24 @@ import (
 // as a filename, we restrict it to a safe subset of characters.
 var certNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
+var osReleaseFieldRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// Regex for valid locales: a language, an optional charset and an optional
+// modifier, such as en_US.UTF-8 or de_DE@euro.
+var localeRegex = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9-]+)?(@[A-Za-z0-9]+)?$`)
+
+// Regex for valid host names, of dot-separated labels of letters, digits and
+// hyphens.
+var hostnameRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
+// Regex for valid timezones, which are relative paths in the zoneinfo
+// directory.
+var timezoneRegex = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+
+// Regex for valid systemd unit names: a name, with an instance after an '@'
+// for an instance of a template unit, and the suffix of the unit type.
+var systemdUnitRegex = regexp.MustCompile(`^[A-Za-z0-9:_.\\-]+(@[A-Za-z0-9:_.\\-]*)?\.(service|socket|device|mount|automount|swap|target|path|timer|slice|scope)$`)
+
 // Attempt to probe an upstream VCS URL if known.
 func (ic *ImageConfiguration) ProbeVCSUrl(ctx context.Context, imageConfigPath string) {
 	log := clog.FromContext(ctx)
@@ -54,8 +79,46 @@ func (ic *ImageConfiguration) ProbeVCSUrl(ctx context.Context, imageConfigPath s
 	}
 }
 
+// readFunc reads a configuration file (the top-level one, or an include) by path.
+type readFunc func(path string) ([]byte, error)
+
+// localReader reads configuration files from the local filesystem, resolving
+// relative paths against includePaths.
+func localReader(includePaths []string) readFunc {
+	return func(imageconfigPath string) ([]byte, error) {
+		resolvedPath, err := paths.ResolvePath(imageconfigPath, includePaths)
+		if err != nil {
+			return nil, err
+		}
+		return os.ReadFile(resolvedPath)
+	}
+}
+
+// read sets the content of the additional certificates of c with a path to
+// that of the file.
+func (c *ImageCertificates) read(read readFunc, configHasher hash.Hash) error {
+	if c == nil {
+		return nil
+	}
+	for i, additional := range c.Additional {
+		if additional.Path == "" {
+			continue
+		}
+		if additional.Content != "" {
+			return fmt.Errorf("configured additional certificate %q has both content and a path", additional.Name)
+		}
+		data, err := read(additional.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read certificate file: %w", err)
+		}
+		configHasher.Write(data)
+		c.Additional[i].Content = string(data)
+	}
+	return nil
+}
+
 // Parse a configuration blob into an ImageConfiguration struct.
-func (ic *ImageConfiguration) parse(ctx context.Context, configData []byte, includePaths []string, configHasher hash.Hash) error {
+func (ic *ImageConfiguration) parse(ctx context.Context, configData []byte, read readFunc, configHasher hash.Hash) error {
 	log := clog.FromContext(ctx)
 	configHasher.Write(configData)
 	dec := yaml.NewDecoder(strings.NewReader(string(configData)))
@@ -64,12 +127,44 @@ func (ic *ImageConfiguration) parse(ctx context.Context, configData []byte, incl
 		return fmt.Errorf("failed to parse image configuration: %w", err)
 	}
 
+	if len(ic.EnvironmentFiles) != 0 {
+		env := map[string]string{}
+		for _, path := range ic.EnvironmentFiles {
+			log.Infof("reading environment from %s", path)
+			data, err := read(path)
+			if err != nil {
+				return fmt.Errorf("failed to read environment file: %w", err)
+			}
+			configHasher.Write(data)
+			fileEnv, err := parseEnvFile(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse environment file %s: %w", path, err)
+			}
+			maps.Copy(env, fileEnv)
+		}
+		maps.Copy(env, ic.Environment)
+		ic.Environment = env
+	}
+
+	if err := ic.Certificates.read(read, configHasher); err != nil {
+		return err
+	}
+	for _, v := range ic.Variants {
+		if err := v.Certificates.read(read, configHasher); err != nil {
+			return err
+		}
+	}
+
 	if ic.Include != "" {
 		log.Infof("including %s for configuration", ic.Include)
 
 		included := &ImageConfiguration{}
 
-		if err := included.Load(ctx, ic.Include, includePaths, configHasher); err != nil {
+		data, err := read(ic.Include)
+		if err != nil {
+			return fmt.Errorf("failed to read include file: %w", err)
+		}
+		if err := included.parse(ctx, data, read, configHasher); err != nil {
 			return fmt.Errorf("failed to read include file: %w", err)
 		}
 
@@ -87,12 +182,14 @@ func (ic *ImageConfiguration) parse(ctx context.Context, configData []byte, incl
 	if ic.Contents.BaseImage != nil {
 		if !cmp.Equal((ImageEntrypoint{}), ic.Entrypoint) ||
 			ic.Cmd != "" ||
+			len(ic.CmdExec) != 0 ||
 			ic.StopSignal != "" ||
 			ic.WorkDir != "" ||
 			!cmp.Equal((ImageAccounts{}), ic.Accounts) ||
 			len(ic.Environment) != 0 ||
 			len(ic.Paths) != 0 ||
-			len(ic.Annotations) != 0 {
+			len(ic.Annotations) != 0 ||
+			len(ic.Labels) != 0 {
 			return fmt.Errorf("when using base image, the only supported image specification are: contents, archs and includes")
 		}
 	}
@@ -109,13 +206,84 @@ func trimRepos(repos []string) []string {
 	return result
 }
 
+// Variant returns the configuration of the variant called name: the variant
+// with ic merged into it, so that the variant takes precedence. The empty
+// name is ic itself. The variants are left out of the returned configuration.
+func (ic *ImageConfiguration) Variant(name string) (ImageConfiguration, error) {
+	base := *ic
+	base.Variants = nil
+	if name == "" {
+		return base, nil
+	}
+	v, ok := ic.Variants[name]
+	if !ok {
+		return ImageConfiguration{}, fmt.Errorf("unknown variant %q, the variants are %q", name, slices.Sorted(maps.Keys(ic.Variants)))
+	}
+	if len(v.Variants) != 0 || len(v.Profiles) != 0 || v.Include != "" || len(v.EnvironmentFiles) != 0 {
+		return ImageConfiguration{}, fmt.Errorf("variant %q cannot have variants, profiles, includes or environment files", name)
+	}
+	v.Environment = maps.Clone(v.Environment)
+	v.Annotations = maps.Clone(v.Annotations)
+	v.Labels = maps.Clone(v.Labels)
+	if err := base.MergeInto(&v); err != nil {
+		return ImageConfiguration{}, fmt.Errorf("merging variant %q: %w", name, err)
+	}
+	v.Contents.BuildRepositories = trimRepos(v.Contents.BuildRepositories)
+	v.Contents.RuntimeOnlyRepositories = trimRepos(v.Contents.RuntimeOnlyRepositories)
+	v.Contents.Repositories = trimRepos(v.Contents.Repositories)
+	return v, nil
+}
+
+// Profile returns ic with the profile called name applied, after the profiles
+// it inherits from, each overriding the one it inherits. The empty name is ic
+// itself. The profiles are left out of the returned configuration.
+func (ic *ImageConfiguration) Profile(name string) (ImageConfiguration, error) {
+	out := *ic
+	out.Profiles = nil
+	if name == "" {
+		return out, nil
+	}
+
+	// The chain of profiles, from name to the profile inheriting no other.
+	var chain []ImageProfile
+	seen := map[string]bool{}
+	for p := name; p != ""; {
+		if seen[p] {
+			return ImageConfiguration{}, fmt.Errorf("profile %q inherits from itself", p)
+		}
+		seen[p] = true
+		profile, ok := ic.Profiles[p]
+		if !ok {
+			return ImageConfiguration{}, fmt.Errorf("unknown profile %q, the profiles are %q", p, slices.Sorted(maps.Keys(ic.Profiles)))
+		}
+		chain = append(chain, profile)
+		p = profile.Inherits
+	}
+
+	out.Environment = maps.Clone(ic.Environment)
+	for _, profile := range slices.Backward(chain) {
+		if profile.Repositories != nil {
+			out.Contents.Repositories = trimRepos(profile.Repositories)
+		}
+		if profile.Packages != nil {
+			out.Contents.Packages = profile.Packages
+		}
+		if len(profile.Environment) != 0 && out.Environment == nil {
+			out.Environment = map[string]string{}
+		}
+		maps.Copy(out.Environment, profile.Environment)
+	}
+	return out, nil
+}
+
 // Merge this configuration into the target, with the target taking precedence.
 func (ic *ImageConfiguration) MergeInto(target *ImageConfiguration) error {
 	if reflect.ValueOf(target.Entrypoint).IsZero() {
 		target.Entrypoint = ic.Entrypoint
 	}
-	if target.Cmd == "" {
+	if target.Cmd == "" && len(target.CmdExec) == 0 {
 		target.Cmd = ic.Cmd
+		target.CmdExec = ic.CmdExec
 	}
 	if target.StopSignal == "" {
 		target.StopSignal = ic.StopSignal
@@ -129,6 +297,36 @@ func (ic *ImageConfiguration) MergeInto(target *ImageConfiguration) error {
 	if target.Certificates == nil {
 		target.Certificates = ic.Certificates
 	}
+	if target.Modes == nil {
+		target.Modes = ic.Modes
+	}
+	if target.NSSwitch == nil {
+		target.NSSwitch = ic.NSSwitch
+	}
+	if target.LoginDefs == nil {
+		target.LoginDefs = ic.LoginDefs
+	}
+	if target.OSRelease == nil {
+		target.OSRelease = ic.OSRelease
+	}
+	if target.MachineID == "" {
+		target.MachineID = ic.MachineID
+	}
+	if target.Timezone == "" {
+		target.Timezone = ic.Timezone
+	}
+	if target.Hosts == nil {
+		target.Hosts = ic.Hosts
+	}
+	if target.Hostname == nil {
+		target.Hostname = ic.Hostname
+	}
+	if target.ResolvConf == nil {
+		target.ResolvConf = ic.ResolvConf
+	}
+	if target.Systemd == nil {
+		target.Systemd = ic.Systemd
+	}
 	if len(target.Archs) == 0 {
 		target.Archs = ic.Archs
 	}
@@ -145,6 +343,8 @@ func (ic *ImageConfiguration) MergeInto(target *ImageConfiguration) error {
 		}
 	}
 	target.Paths = slices.Concat(ic.Paths, target.Paths)
+	target.ExcludePaths = slices.Concat(ic.ExcludePaths, target.ExcludePaths)
+	target.Locales = slices.Concat(ic.Locales, target.Locales)
 	if target.Annotations == nil && ic.Annotations != nil {
 		target.Annotations = maps.Clone(ic.Annotations)
 	} else {
@@ -155,7 +355,34 @@ func (ic *ImageConfiguration) MergeInto(target *ImageConfiguration) error {
 		}
 	}
 
+	if target.Labels == nil && ic.Labels != nil {
+		target.Labels = maps.Clone(ic.Labels)
+	} else {
+		for k, v := range ic.Labels {
+			if _, ok := target.Labels[k]; !ok {
+				target.Labels[k] = v
+			}
+		}
+	}
+	if target.Platform == nil {
+		target.Platform = ic.Platform
+	}
+	if target.ConfigMediaType == "" {
+		target.ConfigMediaType = ic.ConfigMediaType
+	}
+	if target.ArtifactType == "" {
+		target.ArtifactType = ic.ArtifactType
+	}
+	if target.AnnotationsAsLabels == nil {
+		target.AnnotationsAsLabels = ic.AnnotationsAsLabels
+	}
+
 	target.Volumes = slices.Concat(ic.Volumes, target.Volumes)
+	if len(ic.Profiles) != 0 {
+		profiles := maps.Clone(ic.Profiles)
+		maps.Copy(profiles, target.Profiles)
+		target.Profiles = profiles
+	}
 
 	// Update the contents.
 	return ic.Contents.MergeInto(&target.Contents)
@@ -167,9 +394,83 @@ func (a *ImageAccounts) MergeInto(target *ImageAccounts) error {
 	}
 	target.Users = slices.Concat(a.Users, target.Users)
 	target.Groups = slices.Concat(a.Groups, target.Groups)
+	if target.IDRange == nil {
+		target.IDRange = a.IDRange
+	}
+	return nil
+}
+
+// rootAccount is the name of the user and group of ID 0.
+const rootAccount = "root"
+
+// allocateIDs gives the users without a UID and the groups without a GID the
+// ID of their name in the ID range of the accounts: one derived from a hash
+// of the name, or the next free one of the range if it is taken. The names
+// are allocated in order, so that the ID of a name only changes when another
+// name takes it. The accounts named root keep the ID 0, which is theirs,
+// since a UID or GID of 0 is the same as none.
+func (a *ImageAccounts) allocateIDs() error {
+	r := a.IDRange
+	if r == nil {
+		return nil
+	}
+	if r.Min == 0 || r.Max < r.Min {
+		return fmt.Errorf("accounts id-range %d-%d is not a range of IDs above 0", r.Min, r.Max)
+	}
+
+	uids, gids := map[uint32]bool{}, map[uint32]bool{}
+	var users, groups []int
+	for i, u := range a.Users {
+		if u.UID != 0 {
+			uids[u.UID] = true
+		} else if u.UserName != rootAccount {
+			users = append(users, i)
+		}
+	}
+	for i, g := range a.Groups {
+		if g.GID != 0 {
+			gids[g.GID] = true
+		} else if g.GroupName != rootAccount {
+			groups = append(groups, i)
+		}
+	}
+	slices.SortFunc(users, func(i, j int) int { return strings.Compare(a.Users[i].UserName, a.Users[j].UserName) })
+	slices.SortFunc(groups, func(i, j int) int { return strings.Compare(a.Groups[i].GroupName, a.Groups[j].GroupName) })
+
+	for _, i := range users {
+		id, err := r.allocate(a.Users[i].UserName, uids)
+		if err != nil {
+			return err
+		}
+		a.Users[i].UID = id
+	}
+	for _, i := range groups {
+		id, err := r.allocate(a.Groups[i].GroupName, gids)
+		if err != nil {
+			return err
+		}
+		a.Groups[i].GID = id
+	}
 	return nil
 }
 
+// allocate returns the first ID of the range, starting from the one the hash
+// of name picks, that is not taken yet, and takes it.
+func (r *IDRange) allocate(name string, taken map[uint32]bool) (uint32, error) {
+	size := uint64(r.Max-r.Min) + 1
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	start := h.Sum64() % size
+	for i := range size {
+		id := r.Min + uint32((start+i)%size)
+		if !taken[id] {
+			taken[id] = true
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("accounts id-range %d-%d has no free ID left for %q", r.Min, r.Max, name)
+}
+
 func (i *ImageContents) MergeInto(target *ImageContents) error {
 	target.Keyring = slices.Concat(i.Keyring, target.Keyring)
 	target.BuildRepositories = slices.Concat(i.BuildRepositories, target.BuildRepositories)
@@ -179,15 +480,21 @@ func (i *ImageContents) MergeInto(target *ImageContents) error {
 	if target.BaseImage == nil {
 		target.BaseImage = i.BaseImage
 	}
-	return nil
-}
-
-func (ic *ImageConfiguration) readLocal(imageconfigPath string, includePaths []string) ([]byte, error) {
-	resolvedPath, err := paths.ResolvePath(imageconfigPath, includePaths)
-	if err != nil {
-		return nil, err
+	target.OmitAPKDatabase = target.OmitAPKDatabase || i.OmitAPKDatabase
+	if target.Runtime == nil {
+		target.Runtime = i.Runtime
 	}
-	return os.ReadFile(resolvedPath)
+	target.NoScripts = slices.Concat(i.NoScripts, target.NoScripts)
+	if len(i.PreferProviders) != 0 {
+		preferred := maps.Clone(i.PreferProviders)
+		maps.Copy(preferred, target.PreferProviders)
+		target.PreferProviders = preferred
+	}
+	if target.ProviderTieBreak == "" {
+		target.ProviderTieBreak = i.ProviderTieBreak
+	}
+	target.Omit = slices.Concat(i.Omit, target.Omit)
+	return nil
 }
 
 // Load - loads an image configuration given a configuration file path.
@@ -196,20 +503,236 @@ func (ic *ImageConfiguration) readLocal(imageconfigPath string, includePaths []s
 //
 // Deprecated: This will be removed in a future release.
 func (ic *ImageConfiguration) Load(ctx context.Context, imageConfigPath string, includePaths []string, configHasher hash.Hash) error {
-	data, err := ic.readLocal(imageConfigPath, includePaths)
+	read := localReader(includePaths)
+	data, err := read(imageConfigPath)
+	if err != nil {
+		return err
+	}
+
+	return ic.parse(ctx, data, read, configHasher)
+}
+
+// LoadReader loads an image configuration from r, e.g. stdin or a generated
+// configuration held in memory. Files it includes are read from the local
+// filesystem, resolving relative paths against includePaths.
+// Populates configHasher the same way as Load.
+func (ic *ImageConfiguration) LoadReader(ctx context.Context, r io.Reader, includePaths []string, configHasher hash.Hash) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("reading image configuration: %w", err)
+	}
+
+	return ic.parse(ctx, data, localReader(includePaths), configHasher)
+}
+
+// LoadFS loads the image configuration at path in fsys. Files it includes
+// are also read from fsys. Populates configHasher the same way as Load.
+func (ic *ImageConfiguration) LoadFS(ctx context.Context, fsys fs.FS, path string, configHasher hash.Hash) error {
+	read := func(p string) ([]byte, error) {
+		return fs.ReadFile(fsys, p)
+	}
+	data, err := read(path)
 	if err != nil {
 		return err
 	}
 
-	return ic.parse(ctx, data, includePaths, configHasher)
+	return ic.parse(ctx, data, read, configHasher)
 }
 
 // Do preflight checks and mutations on an image configuration.
 func (ic *ImageConfiguration) Validate() error {
+	if len(ic.Entrypoint.Exec) != 0 && (ic.Entrypoint.Type != "" || ic.Entrypoint.Command != "" || ic.Entrypoint.ShellFragment != "") {
+		return fmt.Errorf("entrypoint exec cannot be combined with type, command or shell-fragment")
+	}
+	if len(ic.CmdExec) != 0 && ic.Cmd != "" {
+		return fmt.Errorf("cmd-exec cannot be combined with cmd")
+	}
+	for field, mediaType := range map[string]string{"config-media-type": ic.ConfigMediaType, "artifact-type": ic.ArtifactType} {
+		if mediaType == "" {
+			continue
+		}
+		if _, _, err := mime.ParseMediaType(mediaType); err != nil || !strings.Contains(mediaType, "/") {
+			return fmt.Errorf("%s %q is not a media type", field, mediaType)
+		}
+	}
+
+	if _, err := ic.Layering.CommentTemplate(); err != nil {
+		return err
+	}
+	if ic.Platform != nil {
+		seen := map[Architecture]string{}
+		for a := range ic.Platform.Variants {
+			arch := ParseArchitecture(a)
+			if !slices.Contains(AllArchs, arch) {
+				return fmt.Errorf("platform variant of unsupported architecture %q", a)
+			}
+			if other, ok := seen[arch]; ok {
+				return fmt.Errorf("platform variants of both %q and %q, which are the same architecture", min(a, other), max(a, other))
+			}
+			seen[arch] = a
+		}
+	}
+	if ic.Layering != nil {
+		switch ic.Layering.Created {
+		case "", LayerCreatedImage, LayerCreatedPackages:
+		default:
+			return fmt.Errorf("layering created %q must be %q or %q", ic.Layering.Created, LayerCreatedImage, LayerCreatedPackages)
+		}
+	}
+
 	if ic.Entrypoint.Type == "service-bundle" {
 		if err := ic.ValidateServiceBundle(); err != nil {
 			return err
 		}
+	} else if ic.Entrypoint.Supervisor != "" {
+		return fmt.Errorf("entrypoint supervisor %q needs the service-bundle type", ic.Entrypoint.Supervisor)
+	}
+
+	if ic.Hostname != nil {
+		switch {
+		case ic.Hostname.Omit && ic.Hostname.Name != "":
+			return fmt.Errorf("hostname %q cannot be omitted", ic.Hostname.Name)
+		case !ic.Hostname.Omit && !hostnameRegex.MatchString(ic.Hostname.Name):
+			return fmt.Errorf("hostname %q is not a host name", ic.Hostname.Name)
+		}
+	}
+	if ic.Hosts != nil {
+		if ic.Hosts.Omit && len(ic.Hosts.Entries) != 0 {
+			return fmt.Errorf("hosts with entries cannot be omitted")
+		}
+		for _, entry := range ic.Hosts.Entries {
+			if _, err := netip.ParseAddr(entry.Address); err != nil {
+				return fmt.Errorf("hosts entry address %q: %w", entry.Address, err)
+			}
+			if len(entry.Names) == 0 {
+				return fmt.Errorf("hosts entry of %s has no names", entry.Address)
+			}
+			for _, name := range entry.Names {
+				if !hostnameRegex.MatchString(name) {
+					return fmt.Errorf("hosts entry of %s: %q is not a host name", entry.Address, name)
+				}
+			}
+		}
+	}
+
+	if rc := ic.ResolvConf; rc != nil {
+		if rc.Omit && (len(rc.Nameservers) != 0 || len(rc.Search) != 0 || len(rc.Options) != 0) {
+			return fmt.Errorf("resolv-conf with settings cannot be omitted")
+		}
+		if len(rc.Nameservers) > 3 {
+			return fmt.Errorf("resolv-conf has %d nameservers, but the resolver only uses 3", len(rc.Nameservers))
+		}
+		for _, ns := range rc.Nameservers {
+			if _, err := netip.ParseAddr(ns); err != nil {
+				return fmt.Errorf("resolv-conf nameserver %q: %w", ns, err)
+			}
+		}
+		for _, domain := range rc.Search {
+			if !hostnameRegex.MatchString(domain) {
+				return fmt.Errorf("resolv-conf search domain %q is not a domain name", domain)
+			}
+		}
+		for _, option := range rc.Options {
+			if option == "" || strings.ContainsAny(option, " \t\n") {
+				return fmt.Errorf("resolv-conf option %q is not a resolver option", option)
+			}
+		}
+	}
+
+	if sd := ic.Systemd; sd != nil {
+		for _, unit := range slices.Concat(sd.Enable, sd.Disable) {
+			if !systemdUnitRegex.MatchString(unit) {
+				return fmt.Errorf("systemd unit %q is not a unit name, such as sshd.service", unit)
+			}
+		}
+		for _, unit := range sd.Enable {
+			if slices.Contains(sd.Disable, unit) {
+				return fmt.Errorf("systemd unit %q cannot be both enabled and disabled", unit)
+			}
+		}
+		if sd.DefaultTarget != "" && (!systemdUnitRegex.MatchString(sd.DefaultTarget) || !strings.HasSuffix(sd.DefaultTarget, ".target")) {
+			return fmt.Errorf("systemd default-target %q is not a target, such as multi-user.target", sd.DefaultTarget)
+		}
+	}
+
+	for _, locale := range ic.Locales {
+		if !localeRegex.MatchString(locale) {
+			return fmt.Errorf("locale %q is not a locale name, such as en_US.UTF-8", locale)
+		}
+	}
+	if _, ok := ic.Environment["LANG"]; len(ic.Locales) != 0 && !ok {
+		if ic.Environment == nil {
+			ic.Environment = map[string]string{}
+		}
+		ic.Environment["LANG"] = ic.Locales[0]
+	}
+
+	if ic.Timezone != "" {
+		if !timezoneRegex.MatchString(ic.Timezone) {
+			return fmt.Errorf("timezone %q is not a timezone name, such as Europe/Berlin", ic.Timezone)
+		}
+		if !IsUTC(ic.Timezone) && !slices.Contains(ic.Contents.Packages, "tzdata") {
+			ic.Contents.Packages = append(ic.Contents.Packages, "tzdata")
+		}
+	}
+
+	for _, p := range ic.Paths {
+		if p.Type == "image" && (p.Image == "" || p.Source == "") {
+			return fmt.Errorf("image path mutation on %q needs an image and a source path", p.Path)
+		}
+	}
+
+	for name := range ic.Variants {
+		if _, err := ic.Variant(name); err != nil {
+			return err
+		}
+	}
+	for name := range ic.Profiles {
+		if _, err := ic.Profile(name); err != nil {
+			return err
+		}
+	}
+
+	for _, exclude := range ic.ExcludePaths {
+		if _, err := path.Match(strings.ReplaceAll(exclude, "**", "*"), ""); err != nil {
+			return fmt.Errorf("exclude-paths %q is not a glob: %w", exclude, err)
+		}
+	}
+
+	if ic.NSSwitch != nil {
+		for db, sources := range ic.NSSwitch.Databases {
+			if db == "" || strings.ContainsAny(db, ": \t\n") || strings.Contains(sources, "\n") {
+				return fmt.Errorf("nsswitch database %q: %q is not a database and its sources", db, sources)
+			}
+		}
+	}
+	if ic.LoginDefs != nil {
+		for name, value := range ic.LoginDefs.Settings {
+			if name == "" || strings.ContainsAny(name, " \t\n") || strings.Contains(value, "\n") {
+				return fmt.Errorf("login-defs setting %q: %q is not a setting and its value", name, value)
+			}
+		}
+	}
+
+	if ic.OSRelease != nil {
+		for name, value := range ic.OSRelease.AllFields() {
+			if !osReleaseFieldRegex.MatchString(name) {
+				return fmt.Errorf("os-release field %q must be upper case letters, digits and underscores", name)
+			}
+			if strings.Contains(value, "\n") {
+				return fmt.Errorf("os-release field %q must be a single line", name)
+			}
+		}
+	}
+
+	switch ic.MachineID {
+	case "", MachineIDEmpty, MachineIDSymlink, MachineIDOmit:
+	default:
+		return fmt.Errorf("machine-id %q must be %q, %q or %q", ic.MachineID, MachineIDEmpty, MachineIDSymlink, MachineIDOmit)
+	}
+
+	if err := ic.Accounts.allocateIDs(); err != nil {
+		return err
 	}
 
 	for i, u := range ic.Accounts.Users {
@@ -224,6 +747,10 @@ func (ic *ImageConfiguration) Validate() error {
 		if u.HomeDir == "" {
 			ic.Accounts.Users[i].HomeDir = "/home/" + u.UserName
 		}
+
+		if strings.ContainsAny(u.PasswordHash, ":\n") || strings.HasPrefix(u.PasswordHash, "!") {
+			return fmt.Errorf("configured user %v has a password hash that is not a crypt(3) hash (to lock it, use `locked: true`)", u.UserName)
+		}
 	}
 
 	for _, g := range ic.Accounts.Groups {
@@ -232,6 +759,31 @@ func (ic *ImageConfiguration) Validate() error {
 		}
 	}
 
+	// The apk database of the base image is in its layers, and extended
+	// by the packages installed on top of it.
+	if ic.Contents.OmitAPKDatabase && ic.Contents.BaseImage != nil {
+		return fmt.Errorf("omit_apk_database is unsupported with baseimage")
+	}
+	for _, kind := range ic.Contents.Omit {
+		if _, ok := omitDirs[kind]; !ok {
+			return fmt.Errorf("contents omit %q must be one of %q", kind, slices.Sorted(maps.Keys(omitDirs)))
+		}
+	}
+
+	if ic.Modes != nil {
+		if ic.Modes.Umask != nil && *ic.Modes.Umask > 0o777 {
+			return fmt.Errorf("modes umask %#o is not a umask", *ic.Modes.Umask)
+		}
+		if ic.Modes.HomeDirs > 0o7777 {
+			return fmt.Errorf("modes home-dirs %#o is not a mode", ic.Modes.HomeDirs)
+		}
+		for _, allow := range ic.Modes.SetuidAllow {
+			if _, err := path.Match(strings.ReplaceAll(allow, "**", "*"), ""); err != nil {
+				return fmt.Errorf("modes setuid-allow %q is not a glob: %w", allow, err)
+			}
+		}
+	}
+
 	if ic.Certificates != nil {
 		for _, additional := range ic.Certificates.Additional {
 			if additional.Name == "" {
@@ -248,11 +800,20 @@ func (ic *ImageConfiguration) Validate() error {
 // Do preflight checks and mutations on an image configured to manage
 // a service bundle.
 func (ic *ImageConfiguration) ValidateServiceBundle() error {
-	ic.Entrypoint.Command = "/bin/s6-svscan /sv"
+	sup, err := supervision.New(ic.Entrypoint.Supervisor)
+	if err != nil {
+		return err
+	}
+	if err := supervision.ValidateServices(ic.Entrypoint.Services); err != nil {
+		return err
+	}
+	if ic.Entrypoint.Command, err = sup.Entrypoint(ic.Entrypoint.Services); err != nil {
+		return err
+	}
 
 	// It's harmless to have a duplicate entry in /etc/apk/world,
 	// apk will fix it up when the fixate op happens.
-	ic.Contents.Packages = append(ic.Contents.Packages, "s6")
+	ic.Contents.Packages = append(ic.Contents.Packages, sup.Packages()...)
 
 	return nil
 }
@@ -267,16 +828,24 @@ func (ic *ImageConfiguration) Summarize(ctx context.Context) {
 	log.Infof("    repositories: %v", ic.Contents.Repositories)
 	log.Infof("    keyring:      %v", ic.Contents.Keyring)
 	log.Infof("    packages:     %v", ic.Contents.Packages)
-	if ic.Entrypoint.Type != "" || ic.Entrypoint.Command != "" || len(ic.Entrypoint.Services) != 0 {
+	if ic.Contents.OmitAPKDatabase {
+		log.Infof("    omit apk database: true")
+	}
+	if ic.Entrypoint.Type != "" || ic.Entrypoint.Command != "" || len(ic.Entrypoint.Exec) != 0 || len(ic.Entrypoint.Services) != 0 {
 		log.Infof("  entrypoint:")
 		log.Infof("    type:    %s", ic.Entrypoint.Type)
 		log.Infof("    command:     %s", ic.Entrypoint.Command)
+		log.Infof("    exec:    %q", ic.Entrypoint.Exec)
 		log.Infof("    service: %v", ic.Entrypoint.Services)
+		log.Infof("    supervisor: %s", ic.Entrypoint.Supervisor)
 		log.Infof("    shell fragment: %v", ic.Entrypoint.ShellFragment)
 	}
 	if ic.Cmd != "" {
 		log.Infof("  cmd: %s", ic.Cmd)
 	}
+	if len(ic.CmdExec) != 0 {
+		log.Infof("  cmd exec: %q", ic.CmdExec)
+	}
 	if ic.StopSignal != "" {
 		log.Infof("  stop signal: %s", ic.StopSignal)
 	}
@@ -299,6 +868,18 @@ func (ic *ImageConfiguration) Summarize(ctx context.Context) {
 			log.Infof("      %s: %s", k, v)
 		}
 	}
+	if len(ic.Labels) > 0 {
+		log.Infof("    labels:")
+		for k, v := range ic.Labels {
+			log.Infof("      %s: %s", k, v)
+		}
+	}
+}
+
+// WantAnnotationsAsLabels returns whether the annotations of the image are
+// also set as labels in its config, which they are unless disabled.
+func (ic *ImageConfiguration) WantAnnotationsAsLabels() bool {
+	return ic.AnnotationsAsLabels == nil || *ic.AnnotationsAsLabels
 }
 
 func gidToInt(gid GID) uint32 {
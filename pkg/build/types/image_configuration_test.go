@@ -3,7 +3,10 @@ package types_test
 import (
 	"context"
 	"crypto/sha256"
+	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -48,6 +51,103 @@ func TestOverlayWithAdditionalPackages(t *testing.T) {
 	require.ElementsMatch(t, ic.Contents.Packages, []string{"package", "other_package"})
 }
 
+func TestLoadFS(t *testing.T) {
+	ctx := context.Background()
+
+	configPath := filepath.Join("testdata", "overlay", "overlay_with_package.apko.yaml")
+	want, wantHasher := types.ImageConfiguration{}, sha256.New()
+	require.NoError(t, want.Load(ctx, configPath, []string{}, wantHasher))
+
+	fsys := os.DirFS(".")
+	got, gotHasher := types.ImageConfiguration{}, sha256.New()
+	require.NoError(t, got.LoadFS(ctx, fsys, filepath.ToSlash(configPath), gotHasher))
+	require.Equal(t, want, got)
+	require.Equal(t, wantHasher.Sum(nil), gotHasher.Sum(nil))
+
+	require.Error(t, got.LoadFS(ctx, fsys, "missing.apko.yaml", sha256.New()))
+}
+
+func TestLoadReader(t *testing.T) {
+	ctx := context.Background()
+
+	config := `include: overlay/overlay.apko.yaml
+contents:
+  packages:
+    - other_package
+`
+	ic := types.ImageConfiguration{}
+	require.NoError(t, ic.LoadReader(ctx, strings.NewReader(config), []string{"testdata"}, sha256.New()))
+	require.ElementsMatch(t, ic.Contents.Repositories, []string{"repository"})
+	require.ElementsMatch(t, ic.Contents.Packages, []string{"package", "other_package"})
+
+	require.Error(t, ic.LoadReader(ctx, strings.NewReader("bogus: field\n"), nil, sha256.New()))
+}
+
+func TestEnvironmentFiles(t *testing.T) {
+	ctx := context.Background()
+
+	config := `environment-file:
+  - env/common.env
+  - env/override.env
+environment:
+  FOO: bar
+`
+	ic := types.ImageConfiguration{}
+	require.NoError(t, ic.LoadReader(ctx, strings.NewReader(config), []string{"testdata"}, sha256.New()))
+	require.Equal(t, map[string]string{
+		"FOO":       "bar",
+		"GREETING":  "hello again",
+		"JAVA_HOME": "/usr/lib/jvm/default-jvm",
+		"LANG":      "C.UTF-8",
+	}, ic.Environment)
+
+	ic = types.ImageConfiguration{}
+	require.ErrorContains(t, ic.LoadReader(ctx, strings.NewReader("environment-file: [env/missing.env]\n"), []string{"testdata"}, sha256.New()), "failed to read environment file")
+	ic = types.ImageConfiguration{}
+	require.ErrorContains(t, ic.LoadReader(ctx, strings.NewReader("environment-file: [overlay/overlay.apko.yaml]\n"), []string{"testdata"}, sha256.New()), "line 1: expected KEY=VALUE")
+}
+
+func TestTimezonePackage(t *testing.T) {
+	ic := types.ImageConfiguration{Timezone: "Europe/Berlin"}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, []string{"tzdata"}, ic.Contents.Packages)
+	require.NoError(t, ic.Validate())
+	require.Equal(t, []string{"tzdata"}, ic.Contents.Packages)
+
+	ic = types.ImageConfiguration{Timezone: "UTC"}
+	require.NoError(t, ic.Validate())
+	require.Empty(t, ic.Contents.Packages)
+}
+
+func TestLocalesLang(t *testing.T) {
+	ic := types.ImageConfiguration{Locales: []string{"de_DE.UTF-8", "en_US.UTF-8"}}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, map[string]string{"LANG": "de_DE.UTF-8"}, ic.Environment)
+
+	ic = types.ImageConfiguration{
+		Locales:     []string{"de_DE.UTF-8"},
+		Environment: map[string]string{"LANG": "C.UTF-8"},
+	}
+	require.NoError(t, ic.Validate())
+	require.Equal(t, map[string]string{"LANG": "C.UTF-8"}, ic.Environment)
+}
+
+func TestCertificateFiles(t *testing.T) {
+	ctx := context.Background()
+
+	config := `certificates:
+  additional:
+    - name: internal-root
+      path: certs/internal-root.pem
+`
+	ic := types.ImageConfiguration{}
+	require.NoError(t, ic.LoadReader(ctx, strings.NewReader(config), []string{"testdata"}, sha256.New()))
+	require.Equal(t, "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n", ic.Certificates.Additional[0].Content)
+
+	ic = types.ImageConfiguration{}
+	require.ErrorContains(t, ic.LoadReader(ctx, strings.NewReader(config+"      content: inline\n"), []string{"testdata"}, sha256.New()), `certificate "internal-root" has both content and a path`)
+}
+
 func TestUserContents(t *testing.T) {
 	ctx := context.Background()
 
@@ -253,6 +353,139 @@ func TestMergeInto(t *testing.T) {
 	}
 }
 
+func TestVariant(t *testing.T) {
+	ic := types.ImageConfiguration{
+		Contents:    types.ImageContents{Packages: []string{"app"}},
+		Environment: map[string]string{"MODE": "prod", "PATH": "/usr/bin"},
+		Variants: map[string]types.ImageConfiguration{
+			"debug": {
+				Contents:    types.ImageContents{Packages: []string{"busybox", "strace"}},
+				Environment: map[string]string{"MODE": "debug"},
+			},
+			"nested": {
+				Variants: map[string]types.ImageConfiguration{"other": {}},
+			},
+		},
+	}
+
+	base, err := ic.Variant("")
+	require.NoError(t, err)
+	require.Nil(t, base.Variants)
+	require.Equal(t, []string{"app"}, base.Contents.Packages)
+
+	debug, err := ic.Variant("debug")
+	require.NoError(t, err)
+	require.Nil(t, debug.Variants)
+	require.Equal(t, []string{"app", "busybox", "strace"}, debug.Contents.Packages)
+	require.Equal(t, map[string]string{"MODE": "debug", "PATH": "/usr/bin"}, debug.Environment)
+	// The variant itself is left alone.
+	require.Equal(t, map[string]string{"MODE": "debug"}, ic.Variants["debug"].Environment)
+
+	_, err = ic.Variant("prod")
+	require.EqualError(t, err, `unknown variant "prod", the variants are ["debug" "nested"]`)
+	_, err = ic.Variant("nested")
+	require.EqualError(t, err, `variant "nested" cannot have variants, profiles, includes or environment files`)
+	require.EqualError(t, ic.Validate(), `variant "nested" cannot have variants, profiles, includes or environment files`)
+}
+
+func TestProfile(t *testing.T) {
+	ic := types.ImageConfiguration{
+		Contents: types.ImageContents{
+			Repositories: []string{"https://packages.example.com/os"},
+			Packages:     []string{"app"},
+		},
+		Environment: map[string]string{"TIER": "base", "PATH": "/usr/bin"},
+		Profiles: map[string]types.ImageProfile{
+			"dev": {
+				Repositories: []string{"https://staging.example.com/os/"},
+				Packages:     []string{"app", "busybox"},
+				Environment:  map[string]string{"TIER": "dev"},
+			},
+			"stage": {
+				Inherits:    "dev",
+				Environment: map[string]string{"TIER": "stage", "LOG": "debug"},
+			},
+			"loop": {Inherits: "loop"},
+		},
+	}
+
+	stage, err := ic.Profile("stage")
+	require.NoError(t, err)
+	require.Nil(t, stage.Profiles)
+	require.Equal(t, []string{"https://staging.example.com/os"}, stage.Contents.Repositories)
+	require.Equal(t, []string{"app", "busybox"}, stage.Contents.Packages)
+	require.Equal(t, map[string]string{"TIER": "stage", "LOG": "debug", "PATH": "/usr/bin"}, stage.Environment)
+	// The configuration itself is left alone.
+	require.Equal(t, map[string]string{"TIER": "base", "PATH": "/usr/bin"}, ic.Environment)
+
+	none, err := ic.Profile("")
+	require.NoError(t, err)
+	require.Nil(t, none.Profiles)
+	require.Equal(t, []string{"app"}, none.Contents.Packages)
+
+	_, err = ic.Profile("prod")
+	require.EqualError(t, err, `unknown profile "prod", the profiles are ["dev" "loop" "stage"]`)
+	_, err = ic.Profile("loop")
+	require.EqualError(t, err, `profile "loop" inherits from itself`)
+}
+
+func TestAllocateIDs(t *testing.T) {
+	accounts := func() types.ImageAccounts {
+		return types.ImageAccounts{
+			Users: []types.User{
+				{UserName: "web"},
+				{UserName: "admin", UID: 1000},
+				{UserName: "worker"},
+			},
+			Groups: []types.Group{
+				{GroupName: "web"},
+				{GroupName: "wheel", GID: 10},
+				{GroupName: "root", GID: 0, Members: []string{"admin"}},
+			},
+			IDRange: &types.IDRange{Min: 100, Max: 999},
+		}
+	}
+
+	ic := types.ImageConfiguration{Accounts: accounts()}
+	require.NoError(t, ic.Validate())
+	users := ic.Accounts.Users
+	require.Equal(t, uint32(1000), users[1].UID)
+	require.NotEqual(t, users[0].UID, users[2].UID)
+	for _, u := range []types.User{users[0], users[2]} {
+		require.GreaterOrEqual(t, u.UID, uint32(100), u.UserName)
+		require.LessOrEqual(t, u.UID, uint32(999), u.UserName)
+	}
+	// A group and a user of the same name get the same ID.
+	require.Equal(t, users[0].UID, ic.Accounts.Groups[0].GID)
+	require.Equal(t, uint32(10), ic.Accounts.Groups[1].GID)
+	// The root group keeps its ID.
+	require.Equal(t, uint32(0), ic.Accounts.Groups[2].GID)
+
+	// The IDs are stable, whatever the order of the accounts.
+	again := types.ImageConfiguration{Accounts: accounts()}
+	slices.Reverse(again.Accounts.Users)
+	require.NoError(t, again.Validate())
+	require.Equal(t, users[0].UID, again.Accounts.Users[2].UID)
+	require.Equal(t, users[2].UID, again.Accounts.Users[0].UID)
+
+	// Taken IDs are skipped, until there are none left.
+	full := types.ImageConfiguration{Accounts: types.ImageAccounts{
+		Users:   []types.User{{UserName: "a"}, {UserName: "b"}, {UserName: "c"}},
+		IDRange: &types.IDRange{Min: 100, Max: 101},
+	}}
+	require.EqualError(t, full.Validate(), `accounts id-range 100-101 has no free ID left for "c"`)
+
+	// The root user is not moved to another ID, and cannot be configured.
+	root := types.ImageConfiguration{Accounts: types.ImageAccounts{
+		Users:   []types.User{{UserName: "root", UID: 0}},
+		IDRange: &types.IDRange{Min: 100, Max: 999},
+	}}
+	require.ErrorContains(t, root.Validate(), "has UID 0")
+
+	bad := types.ImageConfiguration{Accounts: types.ImageAccounts{IDRange: &types.IDRange{Min: 999, Max: 100}}}
+	require.EqualError(t, bad.Validate(), "accounts id-range 999-100 is not a range of IDs above 0")
+}
+
 func TestValidate(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -291,6 +524,156 @@ func TestValidate(t *testing.T) {
 			},
 		},
 		expectError: `configured additional certificate "my-cert@123!" has an invalid name, it must match ^[a-zA-Z0-9_-]+$`,
+	}, {
+		name: "entrypoint exec and command",
+		configuration: types.ImageConfiguration{
+			Entrypoint: types.ImageEntrypoint{
+				Command: "/bin/foo",
+				Exec:    []string{"/bin/foo"},
+			},
+		},
+		expectError: "entrypoint exec cannot be combined with type, command or shell-fragment",
+	}, {
+		name: "cmd exec and cmd",
+		configuration: types.ImageConfiguration{
+			Cmd:     "--help",
+			CmdExec: []string{"--help"},
+		},
+		expectError: "cmd-exec cannot be combined with cmd",
+	}, {
+		name: "bad artifact type",
+		configuration: types.ImageConfiguration{
+			ArtifactType: "wasm",
+		},
+		expectError: `artifact-type "wasm" is not a media type`,
+	}, {
+		name: "bad layering created",
+		configuration: types.ImageConfiguration{
+			Layering: &types.Layering{Strategy: "origin", Created: "layer"},
+		},
+		expectError: `layering created "layer" must be "image" or "packages"`,
+	}, {
+		name: "variant of unknown architecture",
+		configuration: types.ImageConfiguration{
+			Platform: &types.ImagePlatform{Variants: map[string]string{"vax": "v2"}},
+		},
+		expectError: `platform variant of unsupported architecture "vax"`,
+	}, {
+		name: "variants of the same architecture",
+		configuration: types.ImageConfiguration{
+			Platform: &types.ImagePlatform{Variants: map[string]string{"arm64": "v8.2", "aarch64": "v8.0"}},
+		},
+		expectError: `platform variants of both "aarch64" and "arm64", which are the same architecture`,
+	}, {
+		name: "unknown contents omit",
+		configuration: types.ImageConfiguration{
+			Contents: types.ImageContents{Omit: []string{"docs", "headers"}},
+		},
+		expectError: `contents omit "headers" must be one of ["docs" "locales" "man" "static"]`,
+	}, {
+		name: "bad exclude-paths glob",
+		configuration: types.ImageConfiguration{
+			ExcludePaths: []string{"/usr/share/[a-"},
+		},
+		expectError: `exclude-paths "/usr/share/[a-" is not a glob: syntax error in pattern`,
+	}, {
+		name: "login-defs setting with spaces",
+		configuration: types.ImageConfiguration{
+			LoginDefs: &types.ImageLoginDefs{Settings: map[string]string{"PASS MAX": "90"}},
+		},
+		expectError: `login-defs setting "PASS MAX": "90" is not a setting and its value`,
+	}, {
+		name: "lower case os-release field",
+		configuration: types.ImageConfiguration{
+			OSRelease: &types.ImageOSRelease{Fields: map[string]string{"home_url": "https://example.com"}},
+		},
+		expectError: `os-release field "home_url" must be upper case letters, digits and underscores`,
+	}, {
+		name: "unknown machine-id",
+		configuration: types.ImageConfiguration{
+			MachineID: "random",
+		},
+		expectError: `machine-id "random" must be "empty", "symlink" or "omit"`,
+	}, {
+		name: "timezone outside of zoneinfo",
+		configuration: types.ImageConfiguration{
+			Timezone: "../../etc/passwd",
+		},
+		expectError: `timezone "../../etc/passwd" is not a timezone name, such as Europe/Berlin`,
+	}, {
+		name: "locale with a path",
+		configuration: types.ImageConfiguration{
+			Locales: []string{"en_US.UTF-8", "../de_DE"},
+		},
+		expectError: `locale "../de_DE" is not a locale name, such as en_US.UTF-8`,
+	}, {
+		name: "hostname with spaces",
+		configuration: types.ImageConfiguration{
+			Hostname: &types.ImageHostname{Name: "my host"},
+		},
+		expectError: `hostname "my host" is not a host name`,
+	}, {
+		name: "hosts entry without an address",
+		configuration: types.ImageConfiguration{
+			Hosts: &types.ImageHosts{Entries: []types.HostsEntry{{Address: "db", Names: []string{"db"}}}},
+		},
+		expectError: `hosts entry address "db": ParseAddr("db"): unable to parse IP`,
+	}, {
+		name: "too many nameservers",
+		configuration: types.ImageConfiguration{
+			ResolvConf: &types.ImageResolvConf{Nameservers: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}},
+		},
+		expectError: `resolv-conf has 4 nameservers, but the resolver only uses 3`,
+	}, {
+		name: "omitted resolv-conf with nameservers",
+		configuration: types.ImageConfiguration{
+			ResolvConf: &types.ImageResolvConf{Nameservers: []string{"10.0.0.1"}, Omit: true},
+		},
+		expectError: `resolv-conf with settings cannot be omitted`,
+	}, {
+		name: "supervisor without service-bundle",
+		configuration: types.ImageConfiguration{
+			Entrypoint: types.ImageEntrypoint{Supervisor: "s6-overlay"},
+		},
+		expectError: `entrypoint supervisor "s6-overlay" needs the service-bundle type`,
+	}, {
+		name: "unknown supervisor",
+		configuration: types.ImageConfiguration{
+			Entrypoint: types.ImageEntrypoint{
+				Type:       "service-bundle",
+				Supervisor: "runit",
+				Services:   map[string]string{"nginx": "/usr/sbin/nginx"},
+			},
+		},
+		expectError: `unknown supervisor "runit", expected one of ["dumb-init" "rc" "s6" "s6-overlay"]`,
+	}, {
+		name: "dumb-init with two services",
+		configuration: types.ImageConfiguration{
+			Entrypoint: types.ImageEntrypoint{
+				Type:       "service-bundle",
+				Supervisor: "dumb-init",
+				Services:   map[string]string{"nginx": "/usr/sbin/nginx", "php": "/usr/sbin/php-fpm"},
+			},
+		},
+		expectError: `the dumb-init supervisor runs a single service, not 2`,
+	}, {
+		name: "bad systemd unit",
+		configuration: types.ImageConfiguration{
+			Systemd: &types.ImageSystemd{Enable: []string{"sshd"}},
+		},
+		expectError: `systemd unit "sshd" is not a unit name, such as sshd.service`,
+	}, {
+		name: "systemd unit enabled and disabled",
+		configuration: types.ImageConfiguration{
+			Systemd: &types.ImageSystemd{Enable: []string{"sshd.service"}, Disable: []string{"sshd.service"}},
+		},
+		expectError: `systemd unit "sshd.service" cannot be both enabled and disabled`,
+	}, {
+		name: "systemd default target not a target",
+		configuration: types.ImageConfiguration{
+			Systemd: &types.ImageSystemd{DefaultTarget: "sshd.service"},
+		},
+		expectError: `systemd default-target "sshd.service" is not a target, such as multi-user.target`,
 	}}
 
 	for _, tt := range tests {
@@ -16,10 +16,14 @@ package types
 
 import (
 	"fmt"
+	"io/fs"
+	"maps"
 	"net/url"
 	"runtime"
+	"runtime/debug"
 	"slices"
 	"strings"
+	"text/template"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
@@ -64,6 +68,32 @@ type User struct {
 	Shell string `json:"shell,omitempty"`
 	// Optional: The user's home directory
 	HomeDir string `json:"homedir,omitempty"`
+	// Optional: The crypt(3) hash of the user's password, such as one
+	// from "mkpasswd -m sha-512", written to /etc/shadow
+	PasswordHash string `json:"password-hash,omitempty" yaml:"password-hash,omitempty"`
+	// Optional: Whether the user's password is locked in /etc/shadow. An
+	// unlocked user without a password hash logs in without a password.
+	// Defaults to locked when unset, unless there is a password hash.
+	Locked *bool `json:"locked,omitempty" yaml:"locked,omitempty"`
+}
+
+// ShadowPassword returns the password field of the /etc/shadow entry of the
+// user, and whether the user has one, which it does when its password hash
+// or whether it is locked is set.
+func (u User) ShadowPassword() (string, bool) {
+	locked := u.Locked != nil && *u.Locked
+	switch {
+	case u.PasswordHash != "" && locked:
+		return "!" + u.PasswordHash, true
+	case u.PasswordHash != "":
+		return u.PasswordHash, true
+	case u.Locked == nil:
+		return "", false
+	case locked:
+		return "!", true
+	default:
+		return "", true
+	}
 }
 
 type GID *uint32
@@ -78,11 +108,11 @@ type Group struct {
 }
 
 type PathMutation struct {
-	// The target path to mutate
+	// The target path to mutate, or a glob of the paths for permissions
 	Path string `json:"path,omitempty"`
 	// The type of mutation to perform
 	//
-	// This can be one of: directory, empty-file, hardlink, symlink, permissions
+	// This can be one of: directory, empty-file, hardlink, symlink, permissions, image, delete
 	Type string `json:"type,omitempty"`
 	// The mutation's desired user ID
 	UID uint32 `json:"uid,omitempty"`
@@ -94,13 +124,19 @@ type PathMutation struct {
 	Source string `json:"source,omitempty"`
 	// Toggle whether to mutate recursively
 	Recursive bool `json:"recursive,omitempty"`
+	// The image to copy the source path from, for the image type: a local OCI
+	// layout, or a registry reference
+	Image string `json:"image,omitempty"`
 }
 
 type BaseImageDescriptor struct {
-	// Required: Path to the base image OCI layout. Right now only local files are supported.
+	// Required: Path to the base image OCI layout, or a registry reference (e.g.
+	// cgr.dev/chainguard/wolfi-base@sha256:...) to pull it from. Pin remote references by digest for
+	// reproducible builds.
 	Image string `json:"image,omitempty" yaml:"image,omitempty"`
-	// Required: Path to file representing installed packages in the base image in APKINDEX format.
+	// Path to file representing installed packages in the base image in APKINDEX format.
 	// (Assumes regular Alpine repository layout, that is: set /foo/bar if the index is /foo/bor/{aarch64|x86_64}/APKINDEX
+	// Required for local images. For registry references it defaults to the apk database in the image.
 	APKIndex string `json:"apkindex,omitempty" yaml:"apkindex,omitempty"`
 }
 
@@ -122,6 +158,106 @@ type ImageContents struct {
 	Packages []string `json:"packages,omitempty" yaml:"packages,omitempty"`
 	// Optional: Base image to build on top of. Warning: Experimental.
 	BaseImage *BaseImageDescriptor `json:"baseimage,omitempty" yaml:"baseimage,omitempty" apko:"experimental"`
+	// Optional: Leave the apk database (/usr/lib/apk/db and /etc/apk) out of
+	// the image, so that packages cannot be managed with apk at runtime. The
+	// SBOM still lists the installed packages.
+	OmitAPKDatabase bool `json:"omit_apk_database,omitempty" yaml:"omit_apk_database,omitempty"`
+	// Optional: How apk is configured in the image to install packages at
+	// runtime.
+	Runtime *ImageRuntime `json:"runtime,omitempty" yaml:"runtime,omitempty"`
+	// Optional: Packages whose install scriptlets are not run when scriptlets
+	// are run, by name. "*" matches any run of characters, so "*" alone
+	// skips the scriptlets of every package.
+	NoScripts []string `json:"no-scripts,omitempty" yaml:"no-scripts,omitempty"`
+	// Optional: The package to install among the packages providing a name,
+	// such as a virtual like "cmd:python3", by name. It takes precedence
+	// over the provider priorities of the indexes.
+	PreferProviders map[string]string `json:"prefer-providers,omitempty" yaml:"prefer-providers,omitempty"`
+	// Optional: How to decide between packages of different names providing
+	// the same name with the same provider priority: "version" (the
+	// default) picks the highest provided version, "alphabetical" the first
+	// by name, "origin" the first by origin, and "pinned" one from a tagged
+	// repository.
+	ProviderTieBreak string `json:"provider-tiebreak,omitempty" yaml:"provider-tiebreak,omitempty"`
+	// Optional: Kinds of files of the packages to leave out when installing
+	// them: "docs" (/usr/share/doc and /usr/share/info), "man" (man
+	// pages), "locales" (message catalogs) and "static" (static libraries).
+	Omit []string `json:"omit,omitempty" yaml:"omit,omitempty"`
+}
+
+// omitDirs maps the kinds of files of contents.omit to the directories they
+// are installed in. Static libraries are matched by their suffix instead.
+var omitDirs = map[string][]string{
+	"docs":    {"usr/share/doc", "usr/share/info", "usr/share/gtk-doc"},
+	"man":     {"usr/share/man"},
+	"locales": {"usr/share/locale"},
+	"static":  nil,
+}
+
+// OmitsFile reports whether the file of the packages at name, relative to the
+// root of the image, is left out of the image by the kinds of files of Omit.
+func (i *ImageContents) OmitsFile(name string) bool {
+	for _, kind := range i.Omit {
+		if kind == "static" && strings.HasSuffix(name, ".a") && (strings.HasPrefix(name, "lib/") || strings.HasPrefix(name, "usr/lib/")) {
+			return true
+		}
+		for _, dir := range omitDirs[kind] {
+			if name == dir || strings.HasPrefix(name, dir+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ImageRuntime configures the /etc/apk/repositories and /etc/apk/keys files of
+// the image, independently of the repositories and keys used to build it.
+type ImageRuntime struct {
+	// Optional: Whether to write the runtime repositories to
+	// /etc/apk/repositories in the image. Defaults to true.
+	Repositories *bool `json:"repositories,omitempty" yaml:"repositories,omitempty"`
+	// Optional: Whether to keep the keys of the keyring in /etc/apk/keys in
+	// the image. Defaults to true.
+	Keys *bool `json:"keys,omitempty" yaml:"keys,omitempty"`
+	// Optional: Repository URL prefixes to replace in /etc/apk/repositories,
+	// such as the URL of a build-time mirror with the URL of the repository
+	// to use at runtime. The longest matching prefix is replaced.
+	Substitutions map[string]string `json:"substitutions,omitempty" yaml:"substitutions,omitempty"`
+}
+
+// InstallRepositories reports whether /etc/apk/repositories is kept in the
+// image.
+func (r *ImageRuntime) InstallRepositories() bool {
+	return r == nil || r.Repositories == nil || *r.Repositories
+}
+
+// InstallKeys reports whether /etc/apk/keys is kept in the image.
+func (r *ImageRuntime) InstallKeys() bool {
+	return r == nil || r.Keys == nil || *r.Keys
+}
+
+// Substitute returns repo with the longest of the substituted prefixes that
+// it starts with replaced. A repository tag, as in "@local /path", is kept.
+func (r *ImageRuntime) Substitute(repo string) string {
+	if r == nil || len(r.Substitutions) == 0 {
+		return repo
+	}
+	tag, url := "", repo
+	if strings.HasPrefix(repo, "@") {
+		if t, u, ok := strings.Cut(repo, " "); ok {
+			tag, url = t+" ", strings.TrimSpace(u)
+		}
+	}
+	longest := ""
+	for prefix := range r.Substitutions {
+		if strings.HasPrefix(url, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return repo
+	}
+	return tag + r.Substitutions[longest] + strings.TrimPrefix(url, longest)
 }
 
 // MarshalYAML implements yaml.Marshaler for ImageContents, redacting URLs in
@@ -157,8 +293,14 @@ type ImageEntrypoint struct {
 	Command string `json:"command,omitempty"`
 	// Optional: The shell fragment of the entrypoint command
 	ShellFragment string `json:"shell-fragment,omitempty" yaml:"shell-fragment"`
+	// Optional: The command of the entrypoint in exec form, as a list of
+	// arguments that are used as they are rather than split like Command
+	Exec []string `json:"exec,omitempty" yaml:"exec,omitempty"`
 
 	Services map[string]string `json:"services,omitempty"`
+	// Optional: The supervisor of the services of a service-bundle: "s6"
+	// (the default), "s6-overlay", "dumb-init" or "rc"
+	Supervisor string `json:"supervisor,omitempty" yaml:"supervisor,omitempty"`
 }
 
 type ImageAccounts struct {
@@ -168,6 +310,18 @@ type ImageAccounts struct {
 	Users []User `json:"users,omitempty" yaml:"users"`
 	// Required: List of groups to populate the image with
 	Groups []Group `json:"groups,omitempty" yaml:"groups"`
+	// Optional: The range to allocate the IDs of the users without a UID
+	// and the groups without a GID from, derived from their names so that
+	// they are stable across builds
+	IDRange *IDRange `json:"id-range,omitempty" yaml:"id-range,omitempty"`
+}
+
+// IDRange is a range of user or group IDs.
+type IDRange struct {
+	// Required: The lowest ID of the range
+	Min uint32 `json:"min,omitempty" yaml:"min,omitempty"`
+	// Required: The highest ID of the range
+	Max uint32 `json:"max,omitempty" yaml:"max,omitempty"`
 }
 
 type ImageConfiguration struct {
@@ -183,6 +337,11 @@ type ImageConfiguration struct {
 	//
 	// These are the additional arguments to pass to the entrypoint.
 	Cmd string `json:"cmd,omitempty" yaml:"cmd,omitempty"`
+	// Optional: The command of the container image in exec form
+	//
+	// These are the additional arguments to pass to the entrypoint, as a list
+	// that is used as it is rather than split like Cmd.
+	CmdExec []string `json:"cmd-exec,omitempty" yaml:"cmd-exec,omitempty"`
 	// Optional: The stop signal used to suspend the execution of the containers process
 	StopSignal string `json:"stop-signal,omitempty" yaml:"stop-signal,omitempty"`
 	// Optional: The working directory of the container
@@ -195,12 +354,34 @@ type ImageConfiguration struct {
 	Archs []Architecture `json:"archs,omitempty" yaml:"archs,omitempty"`
 	// Optional: Environment variables to set in the container image
 	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
+	// Optional: Files of KEY=VALUE lines to read environment variables from
+	//
+	// These are read when the configuration is loaded, and resolved like
+	// includes. Values set in environment take precedence, and later files
+	// take precedence over earlier ones.
+	EnvironmentFiles []string `json:"environment-file,omitempty" yaml:"environment-file,omitempty"`
 	// Optional: List of paths mutations
 	Paths []PathMutation `json:"paths,omitempty" yaml:"paths,omitempty"`
 	// Optional: The link to version control system for this container's source code
 	VCSUrl string `json:"vcs-url,omitempty" yaml:"vcs-url,omitempty"`
 	// Optional: Annotations to apply to the images manifests
 	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	// Optional: The media type of the images configs, instead of
+	// application/vnd.oci.image.config.v1+json
+	ConfigMediaType string `json:"config-media-type,omitempty" yaml:"config-media-type,omitempty"`
+	// Optional: The artifactType of the images manifests, identifying
+	// images that package artifacts rather than runnable containers
+	ArtifactType string `json:"artifact-type,omitempty" yaml:"artifact-type,omitempty"`
+	// Optional: Platform details of the images, in addition to their OS
+	// and architecture
+	Platform *ImagePlatform `json:"platform,omitempty" yaml:"platform,omitempty"`
+	// Optional: Labels to set in the images configs
+	//
+	// These take precedence over the annotations mirrored into labels.
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Optional: Whether to also set the annotations as labels in the images
+	// configs (default true)
+	AnnotationsAsLabels *bool `json:"annotations-as-labels,omitempty" yaml:"annotations-as-labels,omitempty"`
 	// Optional: Path to a local file containing additional image configuration
 	//
 	// The included configuration is deep merged with the parent configuration
@@ -222,10 +403,214 @@ type ImageConfiguration struct {
 
 	// Optional: Certificates to install in the container image
 	Certificates *ImageCertificates `json:"certificates,omitempty" yaml:"certificates,omitempty"`
+
+	// Optional: The modes of the directories and files that apko creates
+	// without explicit permissions
+	Modes *ImageModes `json:"modes,omitempty" yaml:"modes,omitempty"`
+
+	// Optional: Globs of the paths to remove from the image once the
+	// packages are installed, with everything under them, where "**"
+	// matches any number of directories
+	ExcludePaths []string `json:"exclude-paths,omitempty" yaml:"exclude-paths,omitempty"`
+
+	// Optional: Named variants of the image, such as a "debug" variant
+	// adding a shell. Each variant is merged on top of the rest of the
+	// configuration, as the configuration is on top of an include, and is
+	// built instead of it when selected.
+	Variants map[string]ImageConfiguration `json:"variants,omitempty" yaml:"variants,omitempty"`
+
+	// Optional: Named profiles overriding the repositories, packages and
+	// environment of the image, such as one for each deployment tier. A
+	// profile is only applied when selected.
+	Profiles map[string]ImageProfile `json:"profiles,omitempty" yaml:"profiles,omitempty"`
+
+	// Optional: Generate /etc/nsswitch.conf, which glibc needs to resolve
+	// names, from defaults and the databases set here
+	NSSwitch *ImageNSSwitch `json:"nsswitch,omitempty" yaml:"nsswitch,omitempty"`
+
+	// Optional: Generate /etc/login.defs, the configuration of the shadow
+	// password suite, from defaults and the settings set here
+	LoginDefs *ImageLoginDefs `json:"login-defs,omitempty" yaml:"login-defs,omitempty"`
+
+	// Optional: Fields to set in /etc/os-release, over those of the
+	// packages, so that the image identifies as a derivative distribution
+	OSRelease *ImageOSRelease `json:"os-release,omitempty" yaml:"os-release,omitempty"`
+
+	// Optional: How to provide /etc/machine-id: "empty" for an empty file,
+	// which systemd fills in on first boot, "symlink" for an empty file that
+	// /var/lib/dbus/machine-id links to, or "omit" to remove it. By default,
+	// it is left as the packages install it.
+	MachineID string `json:"machine-id,omitempty" yaml:"machine-id,omitempty"`
+
+	// Optional: The timezone of the image, such as "Europe/Berlin", which
+	// /etc/localtime links to the zoneinfo file of, and /etc/timezone
+	// names. The tzdata package is installed for it, unless it is UTC.
+	Timezone string `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+
+	// Optional: The glibc locales to generate, such as "en_US.UTF-8", with
+	// the localedef of the image in the scriptlet sandbox. The first is the
+	// default LANG of the image.
+	Locales []string `json:"locales,omitempty" yaml:"locales,omitempty"`
+
+	// Optional: Generate /etc/hosts, or remove it
+	Hosts *ImageHosts `json:"hosts,omitempty" yaml:"hosts,omitempty"`
+
+	// Optional: Generate /etc/hostname, or remove it
+	Hostname *ImageHostname `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+
+	// Optional: Generate /etc/resolv.conf, or remove it
+	ResolvConf *ImageResolvConf `json:"resolv-conf,omitempty" yaml:"resolv-conf,omitempty"`
+
+	// Optional: The systemd units to enable and disable at build time, for
+	// bootable images
+	Systemd *ImageSystemd `json:"systemd,omitempty" yaml:"systemd,omitempty"`
+}
+
+// ImageSystemd configures the systemd units of the image, as systemctl
+// preset-all, enable and disable would on first boot.
+type ImageSystemd struct {
+	// Optional: Apply the preset files of the image to all its units first
+	Presets bool `json:"presets,omitempty" yaml:"presets,omitempty"`
+	// Optional: The units to enable, such as "sshd.service" or
+	// "getty@tty1.service"
+	Enable []string `json:"enable,omitempty" yaml:"enable,omitempty"`
+	// Optional: The units to disable
+	Disable []string `json:"disable,omitempty" yaml:"disable,omitempty"`
+	// Optional: The target the image boots into, such as
+	// "multi-user.target"
+	DefaultTarget string `json:"default-target,omitempty" yaml:"default-target,omitempty"`
+}
+
+// ImageResolvConf configures the /etc/resolv.conf of the image.
+type ImageResolvConf struct {
+	// Optional: The addresses of the name servers, up to three
+	Nameservers []string `json:"nameservers,omitempty" yaml:"nameservers,omitempty"`
+	// Optional: The domains to search for names without dots
+	Search []string `json:"search,omitempty" yaml:"search,omitempty"`
+	// Optional: The resolver options, such as "ndots:2"
+	Options []string `json:"options,omitempty" yaml:"options,omitempty"`
+	// Optional: Remove the file instead
+	Omit bool `json:"omit,omitempty" yaml:"omit,omitempty"`
+}
+
+// ImageHosts configures the /etc/hosts of the image.
+type ImageHosts struct {
+	// Optional: The entries of the file, after those of localhost and of
+	// the hostname
+	Entries []HostsEntry `json:"entries,omitempty" yaml:"entries,omitempty"`
+	// Optional: Remove the file instead
+	Omit bool `json:"omit,omitempty" yaml:"omit,omitempty"`
+}
+
+// HostsEntry is a line of /etc/hosts.
+type HostsEntry struct {
+	// Required: The IPv4 or IPv6 address
+	Address string `json:"address" yaml:"address"`
+	// Required: The host names of the address
+	Names []string `json:"names" yaml:"names"`
+}
+
+// ImageHostname configures the /etc/hostname of the image.
+type ImageHostname struct {
+	// Optional: The host name, which /etc/hosts also maps to 127.0.1.1
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Optional: Remove the file instead
+	Omit bool `json:"omit,omitempty" yaml:"omit,omitempty"`
+}
+
+// IsUTC returns whether tz is the UTC timezone, which needs no zoneinfo.
+func IsUTC(tz string) bool {
+	return tz == "UTC" || tz == "Etc/UTC"
+}
+
+// The ways to provide the /etc/machine-id of the image.
+const (
+	MachineIDEmpty   = "empty"
+	MachineIDSymlink = "symlink"
+	MachineIDOmit    = "omit"
+)
+
+// ImageOSRelease configures the /etc/os-release of the image.
+type ImageOSRelease struct {
+	// Optional: The name of the operating system, as NAME
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Optional: The identifier of the operating system, as ID
+	ID string `json:"id,omitempty" yaml:"id,omitempty"`
+	// Optional: The version of the operating system, as VERSION_ID
+	VersionID string `json:"version-id,omitempty" yaml:"version-id,omitempty"`
+	// Optional: The name of the operating system to display, as PRETTY_NAME
+	PrettyName string `json:"pretty-name,omitempty" yaml:"pretty-name,omitempty"`
+	// Optional: Other fields, such as "HOME_URL", by name
+	Fields map[string]string `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// AllFields returns the fields set by o, by name.
+func (o *ImageOSRelease) AllFields() map[string]string {
+	fields := maps.Clone(o.Fields)
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	for name, value := range map[string]string{
+		"NAME":        o.Name,
+		"ID":          o.ID,
+		"VERSION_ID":  o.VersionID,
+		"PRETTY_NAME": o.PrettyName,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	return fields
+}
+
+// ImageNSSwitch configures the /etc/nsswitch.conf of the image.
+type ImageNSSwitch struct {
+	// Optional: The sources of the databases, such as "files dns" for
+	// "hosts", overriding those of the defaults
+	Databases map[string]string `json:"databases,omitempty" yaml:"databases,omitempty"`
+}
+
+// ImageLoginDefs configures the /etc/login.defs of the image.
+type ImageLoginDefs struct {
+	// Optional: The settings, such as "PASS_MAX_DAYS", overriding those of
+	// the defaults
+	Settings map[string]string `json:"settings,omitempty" yaml:"settings,omitempty"`
+}
+
+// ImageProfile overrides some of the settings of an image configuration.
+type ImageProfile struct {
+	// Optional: The profile whose settings this profile inherits, and
+	// overrides
+	Inherits string `json:"inherits,omitempty" yaml:"inherits,omitempty"`
+	// Optional: The repositories to use instead of those of the contents
+	Repositories []string `json:"repositories,omitempty" yaml:"repositories,omitempty"`
+	// Optional: The packages to install instead of those of the contents
+	Packages []string `json:"packages,omitempty" yaml:"packages,omitempty"`
+	// Optional: Environment variables to set, overriding those of the
+	// configuration with the same names
+	Environment map[string]string `json:"environment,omitempty" yaml:"environment,omitempty"`
 }
 
 // Architecture represents a CPU architecture for the container image.
 // TODO(kaniini): Maybe this should be its own package at this point?
+//
+// An Architecture has several names, which the functions of this package
+// convert between:
+//
+//	Architecture  apk          GOARCH/GOARM  OCI platform
+//	386           x86          386           linux/386
+//	amd64         x86_64       amd64         linux/amd64
+//	arm64         aarch64      arm64         linux/arm64
+//	arm/v6        armhf        arm/6         linux/arm/v6
+//	arm/v7        armv7        arm/7         linux/arm/v7
+//	loong64       loongarch64  loong64       linux/loong64
+//	ppc64le       ppc64le      ppc64le       linux/ppc64le
+//	riscv64       riscv64      riscv64       linux/riscv64
+//	s390x         s390x        s390x         linux/s390x
+//
+// ParseArchitecture accepts any of the Architecture, apk and GOARCH names, as
+// well as the aliases users commonly give (such as armv7l, or arm for
+// arm/v7). ToAPK, ToGo and ToOCIPlatform convert back.
 type Architecture string
 
 func (a Architecture) String() string { return string(a) }
@@ -286,6 +671,8 @@ func (a Architecture) ToAPK() string {
 	}
 }
 
+// ToOCIPlatform returns the linux OCI platform of the architecture, with the
+// variant of arm/v6 and arm/v7.
 func (a Architecture) ToOCIPlatform() *v1.Platform {
 	plat := v1.Platform{OS: "linux"}
 	switch a := ParseArchitecture(a.String()); a {
@@ -301,6 +688,24 @@ func (a Architecture) ToOCIPlatform() *v1.Platform {
 	return &plat
 }
 
+// OCIPlatform returns the OCI platform of the image for arch, with the
+// platform details of the configuration.
+func (ic *ImageConfiguration) OCIPlatform(arch Architecture) *v1.Platform {
+	plat := arch.ToOCIPlatform()
+	if ic.Platform != nil {
+		plat.OSVersion = ic.Platform.OSVersion
+		plat.OSFeatures = slices.Clone(ic.Platform.OSFeatures)
+		for a, variant := range ic.Platform.Variants {
+			if ParseArchitecture(a) == ParseArchitecture(arch.String()) {
+				plat.Variant = variant
+			}
+		}
+	}
+	return plat
+}
+
+// ToQEmu returns the name QEMU gives the architecture, as in
+// qemu-<name>-static.
 func (a Architecture) ToQEmu() string {
 	switch a := ParseArchitecture(a.String()); a {
 	case _386:
@@ -313,11 +718,15 @@ func (a Architecture) ToQEmu() string {
 		return "arm"
 	case armv7:
 		return "arm"
+	case loong64:
+		return "loongarch64"
 	default:
 		return string(a)
 	}
 }
 
+// ToTriplet returns the GNU target triplet of the architecture, ending with
+// suffix (such as gnu or musl).
 func (a Architecture) ToTriplet(suffix string) string {
 	switch a := ParseArchitecture(a.String()); a {
 	case _386:
@@ -339,6 +748,8 @@ func (a Architecture) ToTriplet(suffix string) string {
 	}
 }
 
+// ToRustTriplet returns the Rust target triple of the architecture, ending
+// with suffix (such as gnu or musl).
 func (a Architecture) ToRustTriplet(suffix string) string {
 	switch a := ParseArchitecture(a.String()); a {
 	case _386:
@@ -360,6 +771,7 @@ func (a Architecture) ToRustTriplet(suffix string) string {
 	}
 }
 
+// Compatible reports whether binaries of a run on b.
 func (a Architecture) Compatible(b Architecture) bool {
 	switch ParseArchitecture(b.String()) {
 	case _386:
@@ -372,6 +784,8 @@ func (a Architecture) Compatible(b Architecture) bool {
 		return a == b
 	case armv7:
 		return a == armv6 || a == b
+	case loong64:
+		return a == b
 	default:
 		return false
 	}
@@ -380,19 +794,21 @@ func (a Architecture) Compatible(b Architecture) bool {
 // ParseArchitecture parses a single architecture in string form, and returns
 // the equivalent Architecture value.
 //
-// Any apk-style arch string (e.g., "x86_64") is converted to the OCI-style
-// equivalent ("amd64").
+// Any apk-style arch string (e.g., "x86_64") or common alias (e.g., "armv7l")
+// is converted to the OCI-style equivalent ("amd64", "arm/v7"). Unknown
+// strings are returned as they are; use LookupArchitecture to reject them.
 func ParseArchitecture(s string) Architecture {
 	switch s {
-	case "x86":
+	case "x86", "i386", "i686":
 		return _386
 	case "x86_64", "amd64":
 		return amd64
 	case "aarch64", "arm64":
 		return arm64
-	case "armhf":
+	case "armhf", "armv6", "armv6l":
 		return armv6
-	case "armv7":
+	case "armv7", "armv7l", "arm":
+		// A bare arm is arm/v7, as in OCI platforms.
 		return armv7
 	case "loong64", "loongarch64":
 		return loong64
@@ -400,6 +816,25 @@ func ParseArchitecture(s string) Architecture {
 	return Architecture(s)
 }
 
+// hostArchitecture returns the architecture apko runs on. For 32-bit ARM, it
+// is the variant apko was built for: arm/v6 for GOARM 5 and 6, and arm/v7
+// otherwise.
+func hostArchitecture() string {
+	var goarm string
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == "GOARM" {
+				goarm = s.Value
+			}
+		}
+	}
+	a, err := ArchitectureFromGo(runtime.GOARCH, goarm)
+	if err != nil {
+		return runtime.GOARCH
+	}
+	return string(a)
+}
+
 // ParseArchitectures parses architecture values in string form, and returns
 // the equivalent slice of Architectures.
 //
@@ -412,7 +847,7 @@ func ParseArchitectures(in []string) []Architecture {
 	}
 
 	if len(in) == 1 && in[0] == "host" {
-		in[0] = runtime.GOARCH
+		in[0] = hostArchitecture()
 	}
 
 	uniq := map[Architecture]struct{}{}
@@ -429,15 +864,67 @@ func ParseArchitectures(in []string) []Architecture {
 }
 
 type SBOM struct {
-	Arch   string
-	Path   string
-	Format string
-	Digest v1.Hash
+	Arch string
+	Path string
+	// SignaturePath is the detached signature or Sigstore bundle of the SBOM,
+	// if it was signed.
+	SignaturePath string
+	Format        string
+	Digest        v1.Hash
+}
+
+type ImagePlatform struct {
+	// Optional: The os.version of the images, which some platforms match on
+	OSVersion string `json:"os-version,omitempty" yaml:"os-version,omitempty"`
+	// Optional: The os.features of the images, which some platforms match on
+	OSFeatures []string `json:"os-features,omitempty" yaml:"os-features,omitempty"`
+	// Optional: The variants of the images by architecture, overriding the
+	// default variant of the architecture, such as v8.2 for arm64
+	Variants map[string]string `json:"variants,omitempty" yaml:"variants,omitempty"`
 }
 
 type Layering struct {
 	Strategy string `json:"strategy,omitempty" yaml:"strategy,omitempty"`
 	Budget   int    `json:"budget,omitempty" yaml:"budget,omitempty"`
+	// Optional: Go template of the comment of the history entry of each
+	// layer, of {{.Origins}} and {{.Packages}} (empty for the top layer)
+	Comment string `json:"comment,omitempty" yaml:"comment,omitempty"`
+	// Optional: The created_by of the history entry of each layer (default
+	// "apko")
+	CreatedBy string `json:"created-by,omitempty" yaml:"created-by,omitempty"`
+	// Optional: The creation time of the history entry of each layer:
+	// "image" for the creation time of the image (the default), or
+	// "packages" for the newest build date of the packages of the layer
+	Created string `json:"created,omitempty" yaml:"created,omitempty"`
+}
+
+// The creation times of the layers of the images.
+const (
+	LayerCreatedImage    = "image"
+	LayerCreatedPackages = "packages"
+)
+
+// LayerHistory is what the comment template of the layering is executed
+// with, for each layer.
+type LayerHistory struct {
+	// Origins are the origins of the packages of the layer, which name its
+	// group of packages.
+	Origins []string
+	// Packages are the packages of the layer, as "<name>=<version>".
+	Packages []string
+}
+
+// CommentTemplate parses the comment template of the layering, which is nil
+// if it has none.
+func (l *Layering) CommentTemplate() (*template.Template, error) {
+	if l == nil || l.Comment == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("comment").Option("missingkey=error").Parse(l.Comment)
+	if err != nil {
+		return nil, fmt.Errorf("parsing layering comment template: %w", err)
+	}
+	return tmpl, nil
 }
 
 type AdditionalCertificateEntry struct {
@@ -449,9 +936,64 @@ type AdditionalCertificateEntry struct {
 	// 1. Appended to the default certificate bundles (e.g., /etc/ssl/certs/ca-certificates.crt)
 	// 2. Installed as an individual file in the ca-certificates.
 	Content string `json:"content,omitempty" yaml:"content,omitempty"`
+	// Optional: Path of a PEM file to read the content from when the
+	// configuration is loaded, found like includes, instead of content
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
 }
 
 type ImageCertificates struct {
 	// Additional certificates to install in the image
 	Additional []AdditionalCertificateEntry `json:"additional,omitempty" yaml:"additional,omitempty"`
+	// Optional: Replace the certificates of the bundles, Java truststores
+	// and hashed certificate directory of the image with the additional
+	// certificates, rather than appending them
+	Replace bool `json:"replace,omitempty" yaml:"replace,omitempty"`
+}
+
+// ImageModes is the mode policy of the directories and files that apko
+// creates without explicit permissions: the parents of paths and home
+// directories, home directories, and directory and empty-file paths.
+type ImageModes struct {
+	// Optional: The umask of the modes of the directories and files, which
+	// are 0o777 for directories and 0o666 for files before it (default 0o022)
+	//
+	// When set, directory and empty-file paths without permissions get these
+	// modes too, rather than none.
+	Umask *uint32 `json:"umask,omitempty" yaml:"umask,omitempty"`
+	// Optional: The permissions of the home directories of the accounts
+	// (default 0o700)
+	HomeDirs uint32 `json:"home-dirs,omitempty" yaml:"home-dirs,omitempty"`
+	// Optional: Whether to clear the setuid and setgid bits of all the files
+	// of the image, once built, except those of setuid-allow
+	StripSetuid bool `json:"strip-setuid,omitempty" yaml:"strip-setuid,omitempty"`
+	// Optional: Globs of the paths of the files that keep their setuid and
+	// setgid bits with strip-setuid, where "**" matches any number of
+	// directories
+	SetuidAllow []string `json:"setuid-allow,omitempty" yaml:"setuid-allow,omitempty"`
+}
+
+// Directory returns the mode of the directories created without explicit
+// permissions.
+func (m *ImageModes) Directory() fs.FileMode {
+	return 0o777 &^ m.umask()
+}
+
+// File returns the mode of the files created without explicit permissions.
+func (m *ImageModes) File() fs.FileMode {
+	return 0o666 &^ m.umask()
+}
+
+// HomeDir returns the mode of the home directories of the accounts.
+func (m *ImageModes) HomeDir() fs.FileMode {
+	if m == nil || m.HomeDirs == 0 {
+		return 0o700
+	}
+	return fs.FileMode(m.HomeDirs)
+}
+
+func (m *ImageModes) umask() fs.FileMode {
+	if m == nil || m.Umask == nil {
+		return 0o022
+	}
+	return fs.FileMode(*m.Umask)
 }
@@ -123,6 +123,14 @@ func TestParseArchitectures(t *testing.T) {
 		desc: "dedupe w/ apk style",
 		in:   []string{"x86_64", "amd64", "arm64", "arm/v6", "armhf"},
 		want: []Architecture{amd64, armv6, arm64},
+	}, {
+		desc: "32-bit arm",
+		in:   []string{"arm", "armv7l", "arm/v7", "armv7", "armv6l", "armhf", "arm/v6"},
+		want: []Architecture{armv6, armv7},
+	}, {
+		desc: "dedupe loongarch64",
+		in:   []string{"loongarch64", "loong64"},
+		want: []Architecture{loong64},
 	}, {
 		// Unknown arch strings are accepted.
 		desc: "unknown arch",
@@ -145,6 +153,61 @@ func TestParseArchitectures(t *testing.T) {
 	}
 }
 
+func TestLoong64(t *testing.T) {
+	a := ParseArchitecture("loongarch64")
+	require.Equal(t, "loongarch64", a.ToAPK())
+	require.Equal(t, "loongarch64", a.ToQEmu())
+	require.Equal(t, "loongarch64-unknown-linux-gnu", a.ToTriplet("gnu"))
+	require.Equal(t, "loongarch64-unknown-linux-gnu", a.ToRustTriplet("gnu"))
+	require.True(t, a.Compatible(loong64))
+	require.False(t, a.Compatible(amd64))
+}
+
+func TestImageRuntime(t *testing.T) {
+	var unset *ImageRuntime
+	require.True(t, unset.InstallRepositories())
+	require.True(t, unset.InstallKeys())
+	require.Equal(t, "https://example.com/os", unset.Substitute("https://example.com/os"))
+
+	no := false
+	r := &ImageRuntime{
+		Keys: &no,
+		Substitutions: map[string]string{
+			"https://mirror.internal":        "https://example.com",
+			"https://mirror.internal/extras": "https://extras.example.com",
+			"/work/packages":                 "https://packages.example.com/os",
+		},
+	}
+	require.True(t, r.InstallRepositories())
+	require.False(t, r.InstallKeys())
+	for in, want := range map[string]string{
+		"https://mirror.internal/os":            "https://example.com/os",
+		"https://mirror.internal/extras/x86_64": "https://extras.example.com/x86_64",
+		"@local /work/packages":                 "@local https://packages.example.com/os",
+		"https://other.example.com/os":          "https://other.example.com/os",
+	} {
+		require.Equal(t, want, r.Substitute(in), in)
+	}
+}
+
+func TestImageContentsOmitsFile(t *testing.T) {
+	c := &ImageContents{Omit: []string{"docs", "static"}}
+	for name, want := range map[string]bool{
+		"usr/share/doc":                true,
+		"usr/share/doc/busybox/README": true,
+		"usr/share/info/dir":           true,
+		"usr/lib/libc.a":               true,
+		"lib/libz.a":                   true,
+		"usr/share/man/man1/ls.1":      false,
+		"usr/share/docs":               false,
+		"usr/lib/libc.so":              false,
+		"etc/data.a":                   false,
+	} {
+		require.Equal(t, want, c.OmitsFile(name), name)
+	}
+	require.False(t, (&ImageContents{}).OmitsFile("usr/share/doc"))
+}
+
 func TestOCIPlatform(t *testing.T) {
 	for _, c := range []struct {
 		desc string
@@ -166,6 +229,10 @@ func TestOCIPlatform(t *testing.T) {
 		desc: "aarch64",
 		in:   "aarch64",
 		want: "arm64",
+	}, {
+		desc: "loongarch64",
+		in:   "loongarch64",
+		want: "loong64",
 	}} {
 		t.Run(c.desc, func(t *testing.T) {
 			got := Architecture(c.in)
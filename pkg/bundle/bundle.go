@@ -0,0 +1,272 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bundle creates and opens airgap bundles: tarballs of everything
+// that builds of a configuration fetch over the network, for building it
+// offline.
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/baseimg"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+	"chainguard.dev/apko/pkg/lock"
+)
+
+const (
+	// ManifestFile is the Manifest of a bundle.
+	ManifestFile = "bundle.json"
+	// LockFile locks the packages of a bundle, with the checksums the files
+	// of its cache are verified against.
+	LockFile = "lock.json"
+	// CacheDir is the package cache of a bundle, of the indexes, packages
+	// and keys the builds fetch.
+	CacheDir = "cache"
+	// KeysDir holds the keys installed by the builds, including those
+	// discovered from their repositories.
+	KeysDir = "keys"
+	// BaseImageDir is the OCI layout of the base image of a bundle, with an
+	// image per architecture.
+	BaseImageDir = "base-image"
+
+	// annotationRefName names the images of the base image layout.
+	annotationRefName = "org.opencontainers.image.ref.name"
+)
+
+// Manifest describes a bundle.
+type Manifest struct {
+	Version string `json:"version"`
+	// Config is the configuration file the bundle was created for.
+	Config string               `json:"config,omitempty"`
+	Archs  []types.Architecture `json:"archs"`
+	// Packages are the name=version of the packages resolved for each
+	// architecture.
+	Packages map[string][]string `json:"packages"`
+	// BaseImage is the reference of the base image, if it was pulled from a
+	// registry.
+	BaseImage string `json:"baseImage,omitempty"`
+}
+
+// Create resolves the configuration of opts for archs, or those of the
+// configuration if there are none, and writes a bundle of what its builds
+// fetch to output: the indexes and packages of its repositories, the keys
+// they are signed with, and its base image, along with a lock of the
+// packages. Local repositories and other
+// files the configuration refers to are not bundled.
+func Create(ctx context.Context, output string, archs []types.Architecture, opts ...build.Option) error {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "bundle.Create")
+	defer span.End()
+
+	o, ic, err := build.NewOptions(opts...)
+	if err != nil {
+		return err
+	}
+	if len(archs) == 0 {
+		archs = ic.Archs
+	}
+	if len(archs) == 0 {
+		archs = types.AllArchs
+	}
+	archs = slices.Clone(archs)
+	slices.SortFunc(archs, func(a, b types.Architecture) int { return strings.Compare(a.String(), b.String()) })
+
+	dir, err := os.MkdirTemp("", "apko-bundle-*")
+	if err != nil {
+		return fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp, err := os.MkdirTemp("", "apko-*")
+	if err != nil {
+		return fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	// Fetch into an empty cache, which then holds exactly what the builds
+	// need.
+	opts = append(slices.Clone(opts),
+		build.WithCache(filepath.Join(dir, CacheDir), false, apk.NewCache(true)),
+		build.WithTempDir(tmp),
+	)
+	log.Infof("Fetching packages for %d architectures: %+v", len(archs), archs)
+	mc, err := build.NewMultiArch(ctx, archs, opts...)
+	if err != nil {
+		return err
+	}
+
+	m := Manifest{
+		Version:  "v1",
+		Config:   o.ImageConfigFile,
+		Archs:    archs,
+		Packages: make(map[string][]string, len(archs)),
+	}
+	l := lock.Lock{
+		Version: "v1",
+		Config: &lock.Config{
+			Name:         o.ImageConfigFile,
+			DeepChecksum: o.ImageConfigChecksum,
+		},
+		Contents: lock.LockContents{
+			Keyrings:                []lock.LockKeyring{},
+			BuildRepositories:       []lock.LockRepo{},
+			RuntimeOnlyRepositories: []lock.LockRepo{},
+			Repositories:            []lock.LockRepo{},
+		},
+	}
+	var lp layout.Path
+	for _, arch := range archs {
+		bc := mc.Contexts[arch]
+		resolved, err := bc.ResolveWithBase(ctx)
+		if err != nil {
+			return fmt.Errorf("fetching packages for %s: %w", arch, err)
+		}
+		pkgs := make([]string, 0, len(resolved))
+		for _, r := range resolved {
+			pkgs = append(pkgs, r.Package.Name+"="+r.Package.Version)
+			l.Contents.Packages = append(l.Contents.Packages, lock.NewLockPkg(r))
+		}
+		slices.Sort(pkgs)
+		m.Packages[arch.String()] = pkgs
+
+		keys, err := bc.Keyring()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Join(dir, KeysDir), 0o755); err != nil {
+			return err
+		}
+		for _, k := range keys {
+			// #nosec G306 -- apk keys are public
+			if err := os.WriteFile(filepath.Join(dir, KeysDir, k.ID), k.Bytes, 0o644); err != nil {
+				return fmt.Errorf("writing key %s: %w", k.ID, err)
+			}
+		}
+
+		annotations, err := bc.BaseImageAnnotations()
+		if err != nil {
+			return err
+		}
+		ref := annotations[baseimg.AnnotationBaseName]
+		if ref == "" {
+			// There is no base image, or it is a local layout.
+			continue
+		}
+		if lp == "" {
+			if lp, err = layout.Write(filepath.Join(dir, BaseImageDir), empty.Index); err != nil {
+				return fmt.Errorf("writing base image layout: %w", err)
+			}
+		}
+		log.Infof("Adding base image %s for %s", ref, arch)
+		if err := lp.AppendImage(bc.BaseImage(),
+			layout.WithPlatform(*arch.ToOCIPlatform()),
+			layout.WithAnnotations(map[string]string{annotationRefName: ref}),
+		); err != nil {
+			return fmt.Errorf("writing base image %s for %s: %w", ref, arch, err)
+		}
+		m.BaseImage = ref
+	}
+
+	if err := l.SaveToFile(filepath.Join(dir, LockFile)); err != nil {
+		return fmt.Errorf("writing %s: %w", LockFile, err)
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFile), b, 0o644); err != nil {
+		return fmt.Errorf("writing %s: %w", ManifestFile, err)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("creating bundle %s: %w", output, err)
+	}
+	defer f.Close()
+	if err := writeTar(f, dir); err != nil {
+		return fmt.Errorf("writing bundle %s: %w", output, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing bundle %s: %w", output, err)
+	}
+	log.Infof("Wrote bundle %s", output)
+	return nil
+}
+
+// writeTar writes the files under dir to w, keeping their modification
+// times, by which the offline cache tells the latest index.
+func writeTar(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,111 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"archive/tar"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/build/types"
+)
+
+func TestCreate(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.FileServer(http.Dir("../build/testdata/packages")).ServeHTTP(w, r)
+	}))
+	ic := types.ImageConfiguration{
+		Contents: types.ImageContents{
+			Repositories: []string{s.URL},
+			Keyring:      []string{s.URL + "/melange.rsa.pub"},
+			Packages:     []string{"pretend-baselayout"},
+		},
+		Archs: types.ParseArchitectures([]string{"amd64", "arm64"}),
+	}
+
+	ctx := t.Context()
+	output := filepath.Join(t.TempDir(), "bundle.tar")
+	require.NoError(t, Create(ctx, output, nil, build.WithImageConfiguration(ic)))
+	s.Close()
+
+	bundle, err := Open(ctx, output, t.TempDir())
+	require.NoError(t, err)
+	require.Equal(t, Manifest{
+		Version: "v1",
+		Archs:   ic.Archs,
+		Packages: map[string][]string{
+			"amd64": {"pretend-baselayout=1.0.0-r0"},
+			"arm64": {"pretend-baselayout=1.0.0-r0"},
+		},
+	}, bundle.Manifest)
+	require.Equal(t, []string{filepath.Join(bundle.Dir, KeysDir, "melange.rsa.pub")}, bundle.Keys)
+
+	// The bundle is enough to build the config offline.
+	for _, arch := range ic.Archs {
+		bc, err := build.New(ctx, fs.NewMemFS(), append([]build.Option{
+			build.WithImageConfiguration(ic),
+			build.WithArch(arch),
+		}, bundle.Options()...)...)
+		require.NoError(t, err)
+		require.NoError(t, bc.BuildImage(ctx))
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		// Rewrite the bundle with a package whose data differs from the lock.
+		dir := bundle.Dir
+		matches, err := filepath.Glob(filepath.Join(dir, CacheDir, "*", "x86_64", "pretend-baselayout-1.0.0-r0", "*.dat.tar.gz"))
+		require.NoError(t, err)
+		require.Len(t, matches, 1)
+		require.NoError(t, os.Remove(matches[0]))
+		require.NoError(t, os.WriteFile(matches[0], []byte("tampered"), 0o644))
+		tampered := filepath.Join(t.TempDir(), "bundle.tar")
+		f, err := os.Create(tampered)
+		require.NoError(t, err)
+		require.NoError(t, writeTar(f, dir))
+		require.NoError(t, f.Close())
+
+		_, err = Open(ctx, tampered, t.TempDir())
+		require.ErrorContains(t, err, "but the lock wants sha256-")
+	})
+}
+
+func TestOpenOutside(t *testing.T) {
+	for _, hdr := range []*tar.Header{
+		{Name: "../escape", Typeflag: tar.TypeReg},
+		{Name: "cache/link", Typeflag: tar.TypeSymlink, Linkname: "../../escape"},
+		{Name: "cache/abs", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	} {
+		t.Run(hdr.Name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bundle.tar")
+			f, err := os.Create(path)
+			require.NoError(t, err)
+			tw := tar.NewWriter(f)
+			require.NoError(t, tw.WriteHeader(hdr))
+			require.NoError(t, tw.Close())
+			require.NoError(t, f.Close())
+
+			_, err = Open(t.Context(), path, t.TempDir())
+			require.ErrorContains(t, err, "outside of the bundle")
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha1" //nolint:gosec // this is what apk tools is using
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chainguard-dev/clog"
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/build"
+	"chainguard.dev/apko/pkg/lock"
+)
+
+// Bundle is an extracted bundle.
+type Bundle struct {
+	// Dir is where the bundle was extracted.
+	Dir      string
+	Manifest Manifest
+	// Keys are the paths of the bundled keys.
+	Keys []string
+}
+
+// Open extracts the bundle at path into dir, and verifies the packages of its
+// cache against the checksums of its lock.
+func Open(ctx context.Context, path, dir string) (*Bundle, error) {
+	log := clog.FromContext(ctx)
+	ctx, span := otel.Tracer("apko").Start(ctx, "bundle.Open")
+	defer span.End()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening bundle %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := extractTar(f, dir); err != nil {
+		return nil, fmt.Errorf("extracting bundle %s: %w", path, err)
+	}
+
+	b := &Bundle{Dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s of bundle %s: %w", ManifestFile, path, err)
+	}
+	if err := json.Unmarshal(data, &b.Manifest); err != nil {
+		return nil, fmt.Errorf("parsing %s of bundle %s: %w", ManifestFile, path, err)
+	}
+	if b.Manifest.Version != "v1" {
+		return nil, fmt.Errorf("bundle %s has unsupported version %q", path, b.Manifest.Version)
+	}
+
+	l, err := lock.FromFile(filepath.Join(dir, LockFile))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s of bundle %s: %w", LockFile, path, err)
+	}
+	if err := verifyCache(ctx, filepath.Join(dir, CacheDir), l); err != nil {
+		return nil, fmt.Errorf("verifying bundle %s: %w", path, err)
+	}
+
+	des, err := os.ReadDir(filepath.Join(dir, KeysDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, de := range des {
+		b.Keys = append(b.Keys, filepath.Join(dir, KeysDir, de.Name()))
+	}
+
+	log.Infof("Opened bundle %s of %d packages for %v", path, len(l.Contents.Packages), b.Manifest.Archs)
+	return b, nil
+}
+
+// Options builds from the bundle, offline: its packages are installed from
+// its cache as locked, and its base image is loaded from its layout. The keys
+// of the bundle are to be added along with any other extra keys.
+func (b *Bundle) Options() []build.Option {
+	opts := []build.Option{
+		build.WithCache(filepath.Join(b.Dir, CacheDir), true, apk.NewCache(true)),
+		build.WithLockFile(filepath.Join(b.Dir, LockFile)),
+	}
+	if b.Manifest.BaseImage != "" {
+		opts = append(opts, build.WithBaseImageMirror(b.Manifest.BaseImage, filepath.Join(b.Dir, BaseImageDir)))
+	}
+	return opts
+}
+
+// lockedPackage locates a locked package in the cache.
+type lockedPackage string
+
+func (p lockedPackage) URL() string { return string(p) }
+
+// verifyCache checks the cached files of the packages of l fetched from
+// repositories against their checksums, and expands their data again from
+// the verified files, since builds install from the expanded data.
+func verifyCache(ctx context.Context, root string, l lock.Lock) error {
+	log := clog.FromContext(ctx)
+	for _, p := range l.Contents.Packages {
+		if !strings.HasPrefix(p.URL, "https://") && !strings.HasPrefix(p.URL, "http://") {
+			// Packages of local repositories are not bundled.
+			continue
+		}
+		dir, err := apk.PackageCacheDir(root, lockedPackage(p.URL))
+		if err != nil {
+			return err
+		}
+		log.Debugf("verifying %s-%s in %s", p.Name, p.Version, dir)
+
+		control, err := checksumHex(p.Control.Checksum, "sha1-")
+		if err != nil {
+			return fmt.Errorf("package %s-%s: %w", p.Name, p.Version, err)
+		}
+		if err := verifyFile(filepath.Join(dir, control+".ctl.tar.gz"), p.Control.Checksum, sha1.New()); err != nil { //nolint:gosec // this is what apk tools is using
+			return fmt.Errorf("package %s-%s: %w", p.Name, p.Version, err)
+		}
+		if p.Signature.Checksum != "" {
+			if err := verifyFile(filepath.Join(dir, control+".sig.tar.gz"), p.Signature.Checksum, sha1.New()); err != nil { //nolint:gosec // this is what apk tools is using
+				return fmt.Errorf("package %s-%s: %w", p.Name, p.Version, err)
+			}
+		}
+		data, err := checksumHex(p.Data.Checksum, "sha256-")
+		if err != nil {
+			return fmt.Errorf("package %s-%s: %w", p.Name, p.Version, err)
+		}
+		dat := filepath.Join(dir, data+".dat.tar.gz")
+		if err := verifyFile(dat, p.Data.Checksum, sha256.New()); err != nil {
+			return fmt.Errorf("package %s-%s: %w", p.Name, p.Version, err)
+		}
+		if err := gunzipFile(dat, strings.TrimSuffix(dat, ".gz")); err != nil {
+			return fmt.Errorf("package %s-%s: expanding data: %w", p.Name, p.Version, err)
+		}
+	}
+	return nil
+}
+
+// checksumHex returns the hex digest of a lock checksum, the base64 digest
+// after prefix.
+func checksumHex(checksum, prefix string) (string, error) {
+	b64, ok := strings.CutPrefix(checksum, prefix)
+	if !ok {
+		return "", fmt.Errorf("unexpected checksum %q, want %s", checksum, prefix)
+	}
+	digest, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return "", fmt.Errorf("decoding checksum %q: %w", checksum, err)
+	}
+	return hex.EncodeToString(digest), nil
+}
+
+// verifyFile checks that the contents of path hash to want, a lock checksum.
+func verifyFile(path, want string, h hash.Hash) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("missing from bundle: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	prefix, _, _ := strings.Cut(want, "-")
+	if got := prefix + "-" + base64.StdEncoding.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("%s has checksum %s, but the lock wants %s", filepath.Base(path), got, want)
+	}
+	return nil
+}
+
+// gunzipFile writes the decompressed contents of src to dst, replacing it.
+func gunzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	zr, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	// #nosec G110 -- the data was verified against the lock
+	if _, err := io.Copy(out, zr); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// extractTar extracts the tarball r, as written by writeTar, into dir,
+// rejecting entries and symlinks that point outside of it.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("entry %q is outside of the bundle", hdr.Name)
+		}
+		path := filepath.Join(dir, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil { // #nosec G110 -- bundles are written by apko
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			// The offline cache picks the latest index by modification time.
+			if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(hdr.Linkname) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), hdr.Linkname)) {
+				return fmt.Errorf("symlink %q to %q is outside of the bundle", hdr.Name, hdr.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("entry %q has unsupported type %c", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"path"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/u-root/u-root/pkg/cpio"
@@ -33,10 +34,21 @@ func FromLayer(layer v1.Layer, dest io.Writer) error {
 	}
 	defer u.Close()
 
-	tarReader := tar.NewReader(u)
+	return FromTar(u, dest)
+}
+
+// FromTar converts a tar stream to CPIO format, appending extra records after
+// the contents of the tar stream. File ownership and modification times are
+// taken from the tar headers.
+func FromTar(r io.Reader, dest io.Writer, extra ...cpio.Record) error {
+	tarReader := tar.NewReader(r)
 
 	w := cpio.NewDedupWriter(cpio.Newc.Writer(dest))
 
+	// Hardlinks are written as copies of the file they link to, so keep the
+	// contents of regular files around to resolve them.
+	contents := map[string]string{}
+
 	// Iterate through the tar archive entries
 	for {
 		header, err := tarReader.Next()
@@ -48,21 +60,15 @@ func FromLayer(layer v1.Layer, dest io.Writer) error {
 			return err
 		}
 
+		var record cpio.Record
+
 		// Determine CPIO file mode based on TAR typeflag
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{
-				cpio.Directory(header.Name, uint64(header.Mode)),
-			}); err != nil {
-				return err
-			}
+			record = cpio.Directory(header.Name, uint64(header.Mode))
 
 		case tar.TypeSymlink:
-			if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{
-				cpio.Symlink(header.Name, header.Linkname),
-			}); err != nil {
-				return err
-			}
+			record = cpio.Symlink(header.Name, header.Linkname)
 
 		case tar.TypeReg:
 			var original bytes.Buffer
@@ -75,24 +81,34 @@ func FromLayer(layer v1.Layer, dest io.Writer) error {
 				fmt.Println("Error reading file content:", err)
 				return err
 			}
+			contents[path.Clean(header.Name)] = original.String()
+			record = cpio.StaticFile(header.Name, original.String(), uint64(header.Mode))
 
-			if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{
-				cpio.StaticFile(header.Name, original.String(), uint64(header.Mode)),
-			}); err != nil {
-				return err
+		case tar.TypeLink:
+			content, ok := contents[path.Clean(header.Linkname)]
+			if !ok {
+				return fmt.Errorf("hardlink %s to %s: target not found", header.Name, header.Linkname)
 			}
+			record = cpio.StaticFile(header.Name, content, uint64(header.Mode))
 
 		case tar.TypeChar:
-			if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{
-				cpio.CharDev(header.Name, uint64(header.Mode), uint64(header.Devmajor), uint64(header.Devminor)),
-			}); err != nil {
-				return err
-			}
+			record = cpio.CharDev(header.Name, uint64(header.Mode), uint64(header.Devmajor), uint64(header.Devminor))
 
 		default:
 			fmt.Printf("Unsupported TAR typeflag: %c for %s\n", header.Typeflag, header.Name)
 			continue // Skip unsupported types
 		}
+
+		record.UID = uint64(header.Uid)              //nolint:gosec
+		record.GID = uint64(header.Gid)              //nolint:gosec
+		record.MTime = uint64(header.ModTime.Unix()) //nolint:gosec
+		if err := cpio.WriteRecordsAndDirs(w, []cpio.Record{record}); err != nil {
+			return err
+		}
+	}
+
+	if err := cpio.WriteRecordsAndDirs(w, extra); err != nil {
+		return err
 	}
 
 	return w.WriteRecord(cpio.TrailerRecord)
@@ -0,0 +1,68 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	gzip "github.com/klauspost/pgzip"
+)
+
+// Compression methods of initramfs and tarball outputs.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// compress returns a writer compressing to w with the given method, which
+// defaults to gzip. Neither method records timestamps or names, so the output
+// only depends on the input.
+func compress(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip, "":
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	default:
+		return nil, fmt.Errorf("unsupported compression %q (supported: %s, %s, %s)", compression, CompressionGzip, CompressionZstd, CompressionNone)
+	}
+}
+
+// TarballCompression returns the compression method matching the extension
+// of a tarball path: .tar, .tar.gz (or .tgz) or .tar.zst (or .tzst).
+func TarballCompression(path string) (string, error) {
+	switch {
+	case strings.HasSuffix(path, ".tar"):
+		return CompressionNone, nil
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return CompressionGzip, nil
+	case strings.HasSuffix(path, ".tar.zst"), strings.HasSuffix(path, ".tzst"):
+		return CompressionZstd, nil
+	default:
+		return "", fmt.Errorf("unable to determine the compression of %s from its extension (supported: .tar, .tar.gz, .tgz, .tar.zst, .tzst)", path)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,238 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	sectorSize = 512
+
+	// The partition starts at 1 MiB, and the same is reserved at the end of
+	// the disk for the backup GPT.
+	partitionStart = mib / sectorSize
+	gptEntries     = 128
+	gptEntrySize   = 128
+	gptSectors     = gptEntries * gptEntrySize / sectorSize
+)
+
+// rootPartitionTypes are the partition types of the discoverable partitions
+// specification (https://uapi-group.org/specifications/specs/discoverable_partitions_specification/),
+// so the root partition is found without a root= kernel argument.
+var rootPartitionTypes = map[string]string{
+	"386":     "44479540-F297-41B2-9AF7-D131D5F0458A",
+	"amd64":   "4F68BCE3-E8CD-4DB1-96E7-FBCAF984B709",
+	"arm":     "69DAD710-2CE4-4E3C-B16C-21A1D49ABED3",
+	"arm64":   "B921B045-1DF0-41C3-AF44-4C6F280D3FAE",
+	"loong64": "77055800-792C-4F94-B39A-98C91B762BB6",
+	"ppc64le": "C31C45E6-3F39-412E-80FB-4809C4980599",
+	"riscv64": "72EC70A6-CF74-40E6-BD49-4BDA08E8F224",
+	"s390x":   "5EEAD9A9-FE09-4A1E-A1D7-520D00531306",
+}
+
+// linuxFilesystemType is the generic Linux filesystem data partition type.
+const linuxFilesystemType = "0FC63DAF-8483-4772-8E79-3D69D8477DE4"
+
+// writeRaw writes a GPT-partitioned disk image with a single ext4 root
+// partition holding rootfs.
+func writeRaw(ctx context.Context, rootfs io.Reader, dest string, o *options) error {
+	fsSize := int64(0)
+	if o.size != 0 {
+		fsSize = (o.size - 2*mib) / mib * mib
+		if fsSize <= 0 {
+			return fmt.Errorf("disk size %d is too small", o.size)
+		}
+	}
+
+	tmp, err := os.MkdirTemp("", "apko-disk-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	part := filepath.Join(tmp, "root.ext4")
+	if err := mkfsExt4(ctx, rootfs, part, fsSize, o); err != nil {
+		return err
+	}
+	fi, err := os.Stat(part)
+	if err != nil {
+		return err
+	}
+	fsSize = fi.Size()
+
+	partType := linuxFilesystemType
+	if t, ok := rootPartitionTypes[o.arch]; ok {
+		partType = t
+	}
+	label := o.label
+	if label == "" {
+		label = defaultLabel
+	}
+
+	totalSectors := uint64(partitionStart + fsSize/sectorSize + partitionStart)
+	gpt, backup, err := gptTables(totalSectors, gptPartition{
+		typeGUID:   partType,
+		uniqueGUID: derivedUUID(o.uuid, "partition"),
+		firstLBA:   partitionStart,
+		lastLBA:    uint64(partitionStart + fsSize/sectorSize - 1),
+		name:       label,
+	}, derivedUUID(o.uuid, "disk"))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(totalSectors) * sectorSize); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(gpt, 0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(backup, int64(totalSectors-1-gptSectors)*sectorSize); err != nil {
+		return err
+	}
+
+	src, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := copySparse(f, src, partitionStart*sectorSize); err != nil {
+		return fmt.Errorf("writing root partition: %w", err)
+	}
+	return f.Close()
+}
+
+// copySparse copies src into dst at offset, skipping blocks of zeros so
+// that dst stays sparse.
+func copySparse(dst io.WriterAt, src io.Reader, offset int64) error {
+	buf := make([]byte, 64*1024)
+	zero := make([]byte, len(buf))
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n > 0 && !bytes.Equal(buf[:n], zero[:n]) {
+			if _, err := dst.WriteAt(buf[:n], offset); err != nil {
+				return err
+			}
+		}
+		offset += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type gptPartition struct {
+	typeGUID   string
+	uniqueGUID string
+	firstLBA   uint64
+	lastLBA    uint64
+	name       string
+}
+
+// gptTables returns the protective MBR, primary GPT header and partition
+// entries for the start of the disk, and the partition entries and backup
+// header for its end.
+func gptTables(totalSectors uint64, p gptPartition, diskGUID string) ([]byte, []byte, error) {
+	entries := make([]byte, gptEntries*gptEntrySize)
+	typeGUID, err := encodeGUID(p.typeGUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	uniqueGUID, err := encodeGUID(p.uniqueGUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	copy(entries[0:16], typeGUID)
+	copy(entries[16:32], uniqueGUID)
+	binary.LittleEndian.PutUint64(entries[32:40], p.firstLBA)
+	binary.LittleEndian.PutUint64(entries[40:48], p.lastLBA)
+	name := utf16.Encode([]rune(p.name))
+	if len(name) > 36 {
+		return nil, nil, fmt.Errorf("partition name %q is too long", p.name)
+	}
+	for i, c := range name {
+		binary.LittleEndian.PutUint16(entries[56+2*i:], c)
+	}
+	entriesCRC := crc32.ChecksumIEEE(entries)
+
+	disk, err := encodeGUID(diskGUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	lastLBA := totalSectors - 1
+	header := func(current, backup, entriesLBA uint64) []byte {
+		h := make([]byte, sectorSize)
+		copy(h[0:8], "EFI PART")
+		binary.LittleEndian.PutUint32(h[8:12], 0x00010000)
+		binary.LittleEndian.PutUint32(h[12:16], 92)
+		binary.LittleEndian.PutUint64(h[24:32], current)
+		binary.LittleEndian.PutUint64(h[32:40], backup)
+		binary.LittleEndian.PutUint64(h[40:48], 2+gptSectors)
+		binary.LittleEndian.PutUint64(h[48:56], lastLBA-1-gptSectors)
+		copy(h[56:72], disk)
+		binary.LittleEndian.PutUint64(h[72:80], entriesLBA)
+		binary.LittleEndian.PutUint32(h[80:84], gptEntries)
+		binary.LittleEndian.PutUint32(h[84:88], gptEntrySize)
+		binary.LittleEndian.PutUint32(h[88:92], entriesCRC)
+		binary.LittleEndian.PutUint32(h[16:20], crc32.ChecksumIEEE(h[:92]))
+		return h
+	}
+
+	mbr := make([]byte, sectorSize)
+	pe := mbr[446:462]
+	pe[1], pe[2], pe[3] = 0x00, 0x02, 0x00
+	pe[4] = 0xee
+	pe[5], pe[6], pe[7] = 0xff, 0xff, 0xff
+	binary.LittleEndian.PutUint32(pe[8:12], 1)
+	binary.LittleEndian.PutUint32(pe[12:16], uint32(min(totalSectors-1, 0xffffffff))) //nolint:gosec
+	mbr[510], mbr[511] = 0x55, 0xaa
+
+	primary := append(append(mbr, header(1, lastLBA, 2)...), entries...)
+	backup := append(append([]byte{}, entries...), header(lastLBA, 1, lastLBA-gptSectors)...)
+	return primary, backup, nil
+}
+
+// encodeGUID encodes a GUID in its mixed-endian on-disk form.
+func encodeGUID(s string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+	if err != nil || len(b) != 16 {
+		return nil, fmt.Errorf("invalid GUID %q", s)
+	}
+	out := make([]byte, 16)
+	binary.LittleEndian.PutUint32(out[0:4], binary.BigEndian.Uint32(b[0:4]))
+	binary.LittleEndian.PutUint16(out[4:6], binary.BigEndian.Uint16(b[4:6]))
+	binary.LittleEndian.PutUint16(out[6:8], binary.BigEndian.Uint16(b[6:8]))
+	copy(out[8:], b[8:])
+	return out, nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"context"
+	"io"
+	"strconv"
+)
+
+// writeEROFS streams rootfs into mkfs.erofs, which reads tar input from stdin
+// since erofs-utils 1.7. Every timestamp is set to the creation time and the
+// UUID is fixed, so the output is reproducible.
+func writeEROFS(ctx context.Context, rootfs io.Reader, dest string, o *options) error {
+	return run(ctx, rootfs, EROFS, "erofs-utils", "mkfs.erofs",
+		"--tar=f",
+		"-T", strconv.FormatInt(o.created.Unix(), 10),
+		"-U", o.uuid,
+		"--quiet",
+		dest,
+	)
+}
@@ -0,0 +1,137 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+const (
+	mib = 1 << 20
+
+	// defaultLabel is the filesystem (and partition) label used by default.
+	defaultLabel = "rootfs"
+)
+
+// writeExt4 writes rootfs as a bare ext4 filesystem image.
+func writeExt4(ctx context.Context, rootfs io.Reader, dest string, o *options) error {
+	return mkfsExt4(ctx, rootfs, dest, o.size, o)
+}
+
+// mkfsExt4 spools rootfs to a temporary tarball and populates a new ext4
+// filesystem of the given size (or one sized to fit, if 0) from it with
+// mke2fs, which reads tarballs since e2fsprogs 1.47.1. The UUID, hash seed and
+// timestamps are fixed so the output is reproducible.
+func mkfsExt4(ctx context.Context, rootfs io.Reader, dest string, size int64, o *options) error {
+	if err := checkMke2fs(); err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "apko-ext4-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	tarball := filepath.Join(tmp, "rootfs.tar")
+	f, err := os.Create(tarball)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, rootfs)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("spooling root filesystem: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if size == 0 {
+		size = defaultExt4Size(n)
+	}
+	if err := createSparse(dest, size); err != nil {
+		return err
+	}
+
+	label := o.label
+	if label == "" {
+		label = defaultLabel
+	}
+	if len(label) > 16 {
+		return fmt.Errorf("ext4 label %q is longer than 16 bytes", label)
+	}
+
+	cmd := []string{
+		"-t", "ext4",
+		"-F",
+		"-q",
+		"-L", label,
+		"-U", o.uuid,
+		"-E", "hash_seed=" + o.uuid + ",lazy_itable_init=0,lazy_journal_init=0",
+		"-d", tarball,
+		dest,
+	}
+	return runEnv(ctx, nil, Ext4, "e2fsprogs", []string{"E2FSPROGS_FAKE_TIME=" + strconv.FormatInt(o.created.Unix(), 10)}, "mke2fs", cmd...)
+}
+
+// defaultExt4Size leaves room for filesystem metadata and some free space on
+// top of the size of the root filesystem, rounded up to a whole MiB.
+func defaultExt4Size(contents int64) int64 {
+	size := contents + contents/2 + 32*mib
+	return (size + mib - 1) / mib * mib
+}
+
+func createSparse(path string, size int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+var mke2fsVersion = regexp.MustCompile(`mke2fs (\d+)\.(\d+)(?:\.(\d+))?`)
+
+// checkMke2fs verifies that mke2fs can populate a filesystem from a tarball.
+func checkMke2fs() error {
+	path, err := exec.LookPath("mke2fs")
+	if err != nil {
+		return fmt.Errorf("mke2fs is required to write %s images (install e2fsprogs): %w", Ext4, err)
+	}
+	out, _ := exec.Command(path, "-V").CombinedOutput()
+	m := mke2fsVersion.FindSubmatch(out)
+	if m == nil {
+		return fmt.Errorf("unable to determine mke2fs version from %q", out)
+	}
+	var v [3]int
+	for i := range v {
+		v[i], _ = strconv.Atoi(string(m[i+1]))
+	}
+	if v[0] < 1 || (v[0] == 1 && (v[1] < 47 || (v[1] == 47 && v[2] < 1))) {
+		return fmt.Errorf("mke2fs %d.%d.%d does not support populating from a tarball, e2fsprogs 1.47.1 or later is required", v[0], v[1], v[2])
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fsimage writes the root filesystem of a built image as a
+// filesystem image, for consumers that boot or mount it directly rather than
+// running it as a container.
+package fsimage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chainguard-dev/clog"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// Format is a filesystem image format.
+type Format string
+
+const (
+	// SquashFS is a compressed, read-only squashfs image.
+	SquashFS Format = "squashfs"
+	// EROFS is a read-only EROFS image, as used by composefs.
+	EROFS Format = "erofs"
+	// Initramfs is a compressed newc cpio archive to boot a kernel with.
+	Initramfs Format = "initramfs"
+	// Ext4 is a bare ext4 filesystem image.
+	Ext4 Format = "ext4"
+	// Raw is a GPT-partitioned disk image with an ext4 root partition.
+	Raw Format = "raw"
+	// ISO is a bootable live ISO image.
+	ISO Format = "iso"
+)
+
+var formats = map[Format]struct {
+	ext   func(o *options) string
+	write func(ctx context.Context, rootfs io.Reader, dest string, o *options) error
+}{
+	SquashFS:  {ext: fixedExt("squashfs"), write: writeSquashFS},
+	EROFS:     {ext: fixedExt("erofs"), write: writeEROFS},
+	Initramfs: {ext: initramfsExt, write: writeInitramfs},
+	Ext4:      {ext: fixedExt("ext4"), write: writeExt4},
+	Raw:       {ext: fixedExt("img"), write: writeRaw},
+	ISO:       {ext: fixedExt("iso"), write: writeISO},
+}
+
+func fixedExt(ext string) func(*options) string {
+	return func(*options) string { return ext }
+}
+
+// Formats returns the supported formats.
+func Formats() []Format {
+	out := make([]Format, 0, len(formats))
+	for f := range formats {
+		out = append(out, f)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// ParseFormat returns the Format named s.
+func ParseFormat(s string) (Format, error) {
+	f := Format(s)
+	if _, ok := formats[f]; !ok {
+		return "", fmt.Errorf("unsupported filesystem image format %q (supported: %v)", s, Formats())
+	}
+	return f, nil
+}
+
+// Ext returns the file extension used for images of the format written
+// with opts, e.g. "cpio.zst" for a zstd-compressed initramfs.
+func (f Format) Ext(opts ...Option) string {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return formats[f].ext(o)
+}
+
+type options struct {
+	created time.Time
+	// uuid identifies the filesystem, derived from the image digest so it
+	// is stable across rebuilds of the same image.
+	uuid string
+
+	// arch is the OCI architecture of the image.
+	arch string
+
+	compression string
+	init        string
+	size        int64
+	label       string
+	cmdline     string
+}
+
+// Option configures how a filesystem image is written.
+type Option func(*options)
+
+// WithCreated sets the timestamp recorded in the filesystem image's own
+// metadata (e.g. the superblock), so that it is reproducible. It defaults to
+// the creation time in the image config.
+func WithCreated(t time.Time) Option {
+	return func(o *options) {
+		o.created = t
+	}
+}
+
+// WithCompression sets the compression of an initramfs or tarball: "gzip"
+// (the default), "zstd" or "none".
+func WithCompression(compression string) Option {
+	return func(o *options) {
+		o.compression = compression
+	}
+}
+
+// WithInit sets the program the kernel runs from an initramfs, by linking
+// /init to it. By default the image must provide /init itself.
+func WithInit(path string) Option {
+	return func(o *options) {
+		o.init = path
+	}
+}
+
+// WithSize sets the size in bytes of an ext4 or raw disk image. By default
+// it is sized to fit the root filesystem with some free space.
+func WithSize(size int64) Option {
+	return func(o *options) {
+		o.size = size
+	}
+}
+
+// WithLabel sets the filesystem label of an ext4 image, and the partition
+// name of a raw disk image. It defaults to "rootfs". For an ISO image, it sets
+// the volume ID, which defaults to "ROOTFS".
+func WithLabel(label string) Option {
+	return func(o *options) {
+		o.label = label
+	}
+}
+
+// WithKernelCmdline sets the kernel command line an ISO image boots with.
+func WithKernelCmdline(cmdline string) Option {
+	return func(o *options) {
+		o.cmdline = cmdline
+	}
+}
+
+// Write writes the flattened root filesystem of img to dest as a filesystem
+// image of the given format.
+func Write(ctx context.Context, format Format, img v1.Image, dest string, opts ...Option) error {
+	f, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("unsupported filesystem image format %q", format)
+	}
+
+	o := &options{}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("getting image config: %w", err)
+	}
+	o.created = cfg.Created.Time
+	o.arch = cfg.Architecture
+	h, err := img.Digest()
+	if err != nil {
+		return fmt.Errorf("getting image digest: %w", err)
+	}
+	o.uuid = uuidFromHash(h)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	rootfs := mutate.Extract(img)
+	defer rootfs.Close()
+
+	clog.FromContext(ctx).Infof("writing %s image %s", format, dest)
+	return f.write(ctx, rootfs, dest, o)
+}
+
+// uuidFromHash formats the first 16 bytes of h as a version 4 UUID.
+func uuidFromHash(h v1.Hash) string {
+	b, err := hex.DecodeString(h.Hex)
+	if err != nil || len(b) < 16 {
+		b = make([]byte, 16)
+	}
+	return uuidFromBytes(b)
+}
+
+// derivedUUID returns a UUID derived from uuid for purpose, for filesystem
+// images that need more than one.
+func derivedUUID(uuid, purpose string) string {
+	sum := sha256.Sum256([]byte(uuid + "/" + purpose))
+	return uuidFromBytes(sum[:])
+}
+
+func uuidFromBytes(b []byte) string {
+	b = slices.Clone(b[:16])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// run runs the external tool name with stdin as its input.
+func run(ctx context.Context, stdin io.Reader, format Format, pkg, name string, args ...string) error {
+	return runEnv(ctx, stdin, format, pkg, nil, name, args...)
+}
+
+// runEnv is like run, with env added to the environment of the tool.
+func runEnv(ctx context.Context, stdin io.Reader, format Format, pkg string, env []string, name string, args ...string) error {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return fmt.Errorf("%s is required to write %s images (install %s): %w", name, format, pkg, err)
+	}
+
+	clog.FromContext(ctx).Debugf("running %s %s", path, strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin = stdin
+	if len(env) != 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("running %s: %w: %s", name, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/require"
+	ucpio "github.com/u-root/u-root/pkg/cpio"
+)
+
+var testEpoch = time.Unix(1700000000, 0).UTC()
+
+// testImage returns an image with a small root filesystem.
+func testImage(t *testing.T) v1.Image {
+	t.Helper()
+	return testImageWithFiles(t, nil)
+}
+
+// testImageWithFiles returns testImage with files added to it.
+func testImageWithFiles(t *testing.T, files map[string]string) v1.Image {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0o644, Size: 8},
+		{Name: "home/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "home/nonroot/", Typeflag: tar.TypeDir, Mode: 0o700, Uid: 65532, Gid: 65532},
+		{Name: "sbin/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "sbin/init", Typeflag: tar.TypeSymlink, Linkname: "/bin/sh", Mode: 0o777},
+	} {
+		hdr.ModTime = testEpoch
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Size != 0 {
+			_, err := tw.Write([]byte("ID=test\n"))
+			require.NoError(t, err)
+		}
+	}
+	names := slices.Sorted(maps.Keys(files))
+	for _, name := range names {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(files[name])), ModTime: testEpoch}))
+		_, err := tw.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	require.NoError(t, err)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+	img, err = mutate.CreatedAt(img, v1.Time{Time: testEpoch})
+	require.NoError(t, err)
+	return img
+}
+
+func requireTool(t *testing.T, name string) {
+	t.Helper()
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s not found: %v", name, err)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("squashfs")
+	require.NoError(t, err)
+	require.Equal(t, SquashFS, f)
+
+	_, err = ParseFormat("floppy")
+	require.ErrorContains(t, err, `unsupported filesystem image format "floppy"`)
+}
+
+// requireReproducible writes img as format twice and checks the results are identical.
+func requireReproducible(t *testing.T, format Format, opts ...Option) []byte {
+	t.Helper()
+	ctx := context.Background()
+	img := testImage(t)
+
+	var outs [][]byte
+	for range 2 {
+		dest := filepath.Join(t.TempDir(), "rootfs."+format.Ext(opts...))
+		require.NoError(t, Write(ctx, format, img, dest, opts...))
+		data, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		require.NotEmpty(t, data)
+		outs = append(outs, data)
+	}
+	require.Equal(t, outs[0], outs[1], "%s image is not reproducible", format)
+	return outs[0]
+}
+
+func TestSquashFS(t *testing.T) {
+	requireTool(t, "mksquashfs")
+	data := requireReproducible(t, SquashFS)
+	require.Equal(t, []byte("hsqs"), data[:4])
+}
+
+func TestEROFS(t *testing.T) {
+	requireTool(t, "mkfs.erofs")
+	data := requireReproducible(t, EROFS)
+	// The superblock is at offset 1024 and starts with the EROFS magic.
+	require.Equal(t, []byte{0xe2, 0xe1, 0xf5, 0xe0}, data[1024:1028])
+}
+
+func TestUUIDFromHash(t *testing.T) {
+	h, err := v1.NewHash("sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	require.NoError(t, err)
+	require.Equal(t, "01234567-89ab-4def-8123-456789abcdef", uuidFromHash(h))
+}
+
+func TestInitramfs(t *testing.T) {
+	for _, tt := range []struct {
+		compression string
+		ext         string
+		magic       []byte
+	}{
+		{compression: CompressionGzip, ext: "cpio.gz", magic: []byte{0x1f, 0x8b}},
+		{compression: CompressionZstd, ext: "cpio.zst", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}},
+		{compression: CompressionNone, ext: "cpio", magic: []byte("070701")},
+	} {
+		t.Run(tt.compression, func(t *testing.T) {
+			opts := []Option{WithCompression(tt.compression), WithInit("/sbin/init")}
+			require.Equal(t, tt.ext, Initramfs.Ext(opts...))
+			data := requireReproducible(t, Initramfs, opts...)
+			require.Equal(t, tt.magic, data[:len(tt.magic)])
+		})
+	}
+
+	t.Run("contents", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "initramfs.cpio")
+		require.NoError(t, Write(context.Background(), Initramfs, testImage(t), dest, WithCompression(CompressionNone), WithInit("/sbin/init")))
+		f, err := os.Open(dest)
+		require.NoError(t, err)
+		defer f.Close()
+
+		records := map[string]ucpio.Record{}
+		rr := ucpio.Newc.Reader(f)
+		require.NoError(t, ucpio.ForEachRecord(rr, func(r ucpio.Record) error {
+			records[r.Name] = r
+			return nil
+		}))
+
+		require.Contains(t, records, "etc/os-release")
+		require.Equal(t, uint64(65532), records["home/nonroot"].UID)
+		require.Equal(t, uint64(testEpoch.Unix()), records["etc/os-release"].MTime)
+		require.Contains(t, records, "init")
+	})
+}
+
+// requireMke2fs skips the test unless mke2fs can populate a filesystem from a tarball.
+func requireMke2fs(t *testing.T) {
+	t.Helper()
+	if err := checkMke2fs(); err != nil {
+		t.Skip(err)
+	}
+}
+
+func TestExt4(t *testing.T) {
+	requireMke2fs(t)
+	data := requireReproducible(t, Ext4, WithLabel("test"), WithSize(64<<20))
+	require.Len(t, data, 64<<20)
+	// The superblock is at offset 1024, with its magic at offset 56 in it.
+	require.Equal(t, []byte{0x53, 0xef}, data[1024+56:1024+58])
+}
+
+func TestRaw(t *testing.T) {
+	requireMke2fs(t)
+	data := requireReproducible(t, Raw, WithSize(64<<20))
+	require.Len(t, data, 64<<20)
+	require.Equal(t, []byte("EFI PART"), data[512:520])
+	require.Equal(t, []byte{0x53, 0xef}, data[1<<20+1024+56:1<<20+1024+58])
+}
+
+func TestGPTTables(t *testing.T) {
+	const totalSectors = 16 << 20 / sectorSize
+	diskGUID := "01234567-89ab-4def-8123-456789abcdef"
+	primary, backup, err := gptTables(totalSectors, gptPartition{
+		typeGUID:   rootPartitionTypes["amd64"],
+		uniqueGUID: derivedUUID(diskGUID, "partition"),
+		firstLBA:   partitionStart,
+		lastLBA:    totalSectors - partitionStart - 1,
+		name:       "rootfs",
+	}, diskGUID)
+	require.NoError(t, err)
+	require.Len(t, primary, (2+gptSectors)*sectorSize)
+	require.Len(t, backup, (gptSectors+1)*sectorSize)
+	require.Equal(t, []byte{0x55, 0xaa}, primary[510:512])
+	require.Equal(t, []byte("EFI PART"), primary[512:520])
+	require.Equal(t, []byte("EFI PART"), backup[gptSectors*sectorSize:gptSectors*sectorSize+8])
+
+	// The type GUID is stored mixed-endian.
+	require.Equal(t, []byte{0xe3, 0xbc, 0x68, 0x4f, 0xcd, 0xe8, 0xb1, 0x4d}, primary[1024:1032])
+
+	if _, err := exec.LookPath("blkid"); err != nil {
+		t.Skipf("blkid not found: %v", err)
+	}
+	disk := filepath.Join(t.TempDir(), "disk.img")
+	f, err := os.Create(disk)
+	require.NoError(t, err)
+	require.NoError(t, f.Truncate(totalSectors*sectorSize))
+	_, err = f.WriteAt(primary, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt(backup, (totalSectors-1-gptSectors)*sectorSize)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	out, err := exec.Command("blkid", "-p", "-o", "export", disk).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "PTTYPE=gpt")
+	require.Contains(t, string(out), "PTUUID="+diskGUID)
+}
+
+func TestISO(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("no kernel", func(t *testing.T) {
+		err := Write(ctx, ISO, testImage(t), filepath.Join(t.TempDir(), "live.iso"))
+		require.ErrorContains(t, err, "no kernel found in /boot")
+	})
+
+	t.Run("no syslinux", func(t *testing.T) {
+		img := testImageWithFiles(t, map[string]string{"boot/vmlinuz-virt": "kernel"})
+		err := Write(ctx, ISO, img, filepath.Join(t.TempDir(), "live.iso"))
+		require.ErrorContains(t, err, "add the syslinux package")
+	})
+
+	t.Run("isolinux config", func(t *testing.T) {
+		cfg := isolinuxConfig("/boot/initramfs.cpio.gz", &options{cmdline: "quiet"})
+		require.Contains(t, cfg, "KERNEL /boot/vmlinuz\n")
+		require.Contains(t, cfg, "INITRD /boot/initramfs.cpio.gz\n")
+		require.Contains(t, cfg, "APPEND quiet\n")
+	})
+
+	requireTool(t, "xorriso")
+	files := map[string]string{
+		"boot/vmlinuz-virt":               "kernel",
+		"usr/share/syslinux/isolinux.bin": strings.Repeat("\x00", 2048),
+		"usr/share/syslinux/ldlinux.c32":  "ldlinux",
+		"usr/share/syslinux/isohdpfx.bin": strings.Repeat("\x00", 432),
+	}
+	var outs [][]byte
+	for range 2 {
+		dest := filepath.Join(t.TempDir(), "live.iso")
+		require.NoError(t, Write(ctx, ISO, testImageWithFiles(t, files), dest, WithKernelCmdline("quiet")))
+		data, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		outs = append(outs, data)
+	}
+	require.Equal(t, outs[0], outs[1], "iso image is not reproducible")
+	// The primary volume descriptor is at sector 16.
+	require.Equal(t, []byte("CD001"), outs[0][16*2048+1:16*2048+6])
+}
+
+func TestTarballCompression(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{path: "rootfs.tar", want: CompressionNone},
+		{path: "rootfs.tar.gz", want: CompressionGzip},
+		{path: "rootfs.tgz", want: CompressionGzip},
+		{path: "out/rootfs.tar.zst", want: CompressionZstd},
+		{path: "rootfs.tzst", want: CompressionZstd},
+	} {
+		got, err := TarballCompression(tt.path)
+		require.NoError(t, err, tt.path)
+		require.Equal(t, tt.want, got, tt.path)
+	}
+
+	_, err := TarballCompression("rootfs.tar.xz")
+	require.Error(t, err)
+}
+
+func TestWriteTarball(t *testing.T) {
+	dir := t.TempDir()
+	var outputs [][]byte
+	for _, name := range []string{"a.tar.zst", "b.tar.zst"} {
+		dest := filepath.Join(dir, name)
+		require.NoError(t, WriteTarball(context.Background(), testImage(t), dest, WithCompression(CompressionZstd)))
+		data, err := os.ReadFile(dest)
+		require.NoError(t, err)
+		outputs = append(outputs, data)
+	}
+	require.Equal(t, outputs[0], outputs[1], "tarball is not reproducible")
+	require.Equal(t, []byte{0x28, 0xb5, 0x2f, 0xfd}, outputs[0][:4])
+
+	dest := filepath.Join(dir, "rootfs.tar")
+	require.NoError(t, WriteTarball(context.Background(), testImage(t), dest, WithCompression(CompressionNone)))
+	f, err := os.Open(dest)
+	require.NoError(t, err)
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, hdr.Name)
+	}
+	require.Contains(t, names, "etc/os-release")
+	require.Contains(t, names, "sbin/init")
+}
@@ -0,0 +1,67 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	ucpio "github.com/u-root/u-root/pkg/cpio"
+
+	"chainguard.dev/apko/pkg/cpio"
+)
+
+func initramfsExt(o *options) string {
+	switch o.compression {
+	case CompressionNone:
+		return "cpio"
+	case CompressionZstd:
+		return "cpio.zst"
+	default:
+		return "cpio.gz"
+	}
+}
+
+// writeInitramfs writes rootfs as a newc cpio archive, as the kernel expects
+// of an initramfs. If an init is configured, /init is linked to it, since
+// that is what the kernel runs from an initramfs.
+func writeInitramfs(ctx context.Context, rootfs io.Reader, dest string, o *options) error {
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := compress(f, o.compression)
+	if err != nil {
+		return err
+	}
+
+	var extra []ucpio.Record
+	if o.init != "" && strings.TrimPrefix(o.init, "/") != "init" {
+		extra = append(extra, ucpio.Symlink("init", o.init))
+	}
+
+	if err := cpio.FromTar(rootfs, w, extra...); err != nil {
+		return fmt.Errorf("writing cpio archive: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
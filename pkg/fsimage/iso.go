@@ -0,0 +1,212 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// syslinuxDir is where the syslinux package installs its boot files.
+	syslinuxDir = "usr/share/syslinux"
+
+	defaultVolumeID      = "ROOTFS"
+	defaultKernelCmdline = "console=tty0 console=ttyS0"
+)
+
+// writeISO writes a bootable live ISO image: the kernel installed in the
+// image's /boot, the whole root filesystem as its initramfs, and an isolinux
+// configuration booting them, so the image runs from memory. The image must
+// include a kernel and the syslinux package, which provides the bootloader.
+//
+// The ISO is assembled with xorriso, and SOURCE_DATE_EPOCH is set from the
+// creation time so that it is reproducible.
+func writeISO(ctx context.Context, rootfs io.Reader, dest string, o *options) error {
+	tmp, err := os.MkdirTemp("", "apko-iso-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	// The root filesystem is read twice, to find the boot files and to
+	// write the initramfs.
+	tarball := filepath.Join(tmp, "rootfs.tar")
+	f, err := os.Create(tarball)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, rootfs); err != nil {
+		f.Close()
+		return fmt.Errorf("spooling root filesystem: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	boot, err := findBootFiles(tarball)
+	if err != nil {
+		return err
+	}
+
+	staging := filepath.Join(tmp, "iso")
+	initramfs := "initramfs." + initramfsExt(o)
+	files := map[string][]byte{
+		"boot/vmlinuz":          boot.kernel,
+		"isolinux/isolinux.bin": boot.isolinux,
+		"isolinux/ldlinux.c32":  boot.ldlinux,
+		"isolinux/isolinux.cfg": []byte(isolinuxConfig("/boot/"+initramfs, o)),
+	}
+	for name, data := range files {
+		p := filepath.Join(staging, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(p, data, 0o644); err != nil {
+			return err
+		}
+	}
+
+	tf, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer tf.Close()
+	if err := writeInitramfs(ctx, tf, filepath.Join(staging, "boot", initramfs), o); err != nil {
+		return fmt.Errorf("writing initramfs: %w", err)
+	}
+
+	// Give every file the same timestamp, as xorriso records them.
+	if err := filepath.Walk(staging, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(p, o.created, o.created)
+	}); err != nil {
+		return err
+	}
+
+	label := o.label
+	if label == "" {
+		label = defaultVolumeID
+	}
+	args := []string{
+		"-as", "mkisofs",
+		"-o", dest,
+		"-V", label,
+		"-R", "-J",
+		"-b", "isolinux/isolinux.bin",
+		"-c", "isolinux/boot.cat",
+		"-no-emul-boot", "-boot-load-size", "4", "-boot-info-table",
+	}
+	if boot.isohdpfx != nil {
+		// Make the ISO bootable from a USB stick as well.
+		mbr := filepath.Join(tmp, "isohdpfx.bin")
+		if err := os.WriteFile(mbr, boot.isohdpfx, 0o644); err != nil {
+			return err
+		}
+		args = append(args, "-isohybrid-mbr", mbr)
+	}
+	args = append(args, staging)
+
+	return runEnv(ctx, nil, ISO, "xorriso", []string{"SOURCE_DATE_EPOCH=" + strconv.FormatInt(o.created.Unix(), 10)}, "xorriso", args...)
+}
+
+func isolinuxConfig(initramfs string, o *options) string {
+	cmdline := o.cmdline
+	if cmdline == "" {
+		cmdline = defaultKernelCmdline
+	}
+	return fmt.Sprintf(`DEFAULT linux
+PROMPT 0
+TIMEOUT 0
+
+LABEL linux
+  KERNEL /boot/vmlinuz
+  INITRD %s
+  APPEND %s
+`, initramfs, cmdline)
+}
+
+type bootFiles struct {
+	kernel   []byte
+	isolinux []byte
+	ldlinux  []byte
+	isohdpfx []byte
+}
+
+// findBootFiles reads the kernel and syslinux files from the tarball. If
+// several kernels are installed, the last one by name is used.
+func findBootFiles(tarball string) (*bootFiles, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var boot bootFiles
+	var kernelName string
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		var dst *[]byte
+		switch {
+		case path.Dir(name) == "boot" && (strings.HasPrefix(path.Base(name), "vmlinuz") || strings.HasPrefix(path.Base(name), "vmlinux")):
+			if name < kernelName {
+				continue
+			}
+			kernelName = name
+			dst = &boot.kernel
+		case name == path.Join(syslinuxDir, "isolinux.bin"):
+			dst = &boot.isolinux
+		case name == path.Join(syslinuxDir, "ldlinux.c32"):
+			dst = &boot.ldlinux
+		case name == path.Join(syslinuxDir, "isohdpfx.bin"):
+			dst = &boot.isohdpfx
+		default:
+			continue
+		}
+		if *dst, err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+
+	if boot.kernel == nil {
+		return nil, errors.New("no kernel found in /boot, add a kernel package to the image")
+	}
+	if boot.isolinux == nil || boot.ldlinux == nil {
+		return nil, fmt.Errorf("no isolinux.bin and ldlinux.c32 found in /%s, add the syslinux package to the image", syslinuxDir)
+	}
+	return &boot, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"context"
+	"io"
+	"strconv"
+)
+
+// writeSquashFS streams rootfs into mksquashfs, which reads tar input since
+// squashfs-tools 4.6. Taking ownership and modes from the tar headers means
+// no root privileges are needed, and the fixed mkfs time together with
+// -reproducible makes the output byte-for-byte reproducible.
+func writeSquashFS(ctx context.Context, rootfs io.Reader, dest string, o *options) error {
+	return run(ctx, rootfs, SquashFS, "squashfs-tools", "mksquashfs",
+		"-", dest,
+		"-tar",
+		"-noappend",
+		"-reproducible",
+		"-mkfs-time", strconv.FormatInt(o.created.Unix(), 10),
+		"-quiet",
+	)
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsimage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/chainguard-dev/clog"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// WriteTarball writes the flattened root filesystem of img to dest as a
+// tarball, compressed as set by WithCompression. Unlike an OCI image, it can
+// be unpacked directly for use with chroot, LXC or WSL.
+func WriteTarball(ctx context.Context, img v1.Image, dest string, opts ...Option) error {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := compress(f, o.compression)
+	if err != nil {
+		return err
+	}
+
+	rootfs := mutate.Extract(img)
+	defer rootfs.Close()
+
+	clog.FromContext(ctx).Infof("writing root filesystem tarball %s", dest)
+	if _, err := io.Copy(w, rootfs); err != nil {
+		return fmt.Errorf("writing root filesystem: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
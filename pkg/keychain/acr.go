@@ -0,0 +1,158 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keychain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"github.com/chainguard-dev/clog"
+)
+
+const (
+	// acrUsername is the user of ACR refresh tokens.
+	acrUsername = "00000000-0000-0000-0000-000000000000"
+	// acrScope is the Entra ID scope of tokens exchanged with ACR.
+	acrScope = "https://containerregistry.azure.net/.default"
+	// defaultAzureAuthorityHost is the Entra ID authority of Azure's public
+	// cloud, when AZURE_AUTHORITY_HOST is not set.
+	defaultAzureAuthorityHost = "https://login.microsoftonline.com/"
+	// acrRefreshTokenLifetime is how long ACR refresh tokens are valid for.
+	acrRefreshTokenLifetime = 3 * time.Hour
+)
+
+// acrHostSuffixes are the host suffixes of the ACR registries of the Azure
+// clouds.
+var acrHostSuffixes = []string{".azurecr.io", ".azurecr.cn", ".azurecr.us"}
+
+// ACR resolves the credentials of Azure Container Registry registries with an
+// ACR refresh token exchanged for the Azure Workload Identity of the
+// environment: the federated token of AZURE_FEDERATED_TOKEN_FILE for the
+// application AZURE_CLIENT_ID of AZURE_TENANT_ID.
+var ACR authn.Keychain = &acrKeychain{identity: newIdentity(), exchangeURL: acrExchangeURL}
+
+type acrKeychain struct {
+	identity
+	// exchangeURL returns the URL registry exchanges Entra ID tokens at.
+	exchangeURL func(registry string) string
+}
+
+func acrExchangeURL(registry string) string {
+	return "https://" + registry + "/oauth2/exchange"
+}
+
+func (k *acrKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	return k.ResolveContext(context.Background(), target)
+}
+
+func (k *acrKeychain) ResolveContext(ctx context.Context, target authn.Resource) (authn.Authenticator, error) {
+	registry := target.RegistryStr()
+	if !isACR(registry) {
+		return authn.Anonymous, nil
+	}
+	tenant, client := k.getenv("AZURE_TENANT_ID"), k.getenv("AZURE_CLIENT_ID")
+	if tenant == "" || client == "" || k.getenv("AZURE_FEDERATED_TOKEN_FILE") == "" {
+		return authn.Anonymous, nil
+	}
+
+	return k.cached(ctx, registry, func(ctx context.Context) (authn.Authenticator, time.Time, error) {
+		accessToken, err := k.entraToken(ctx, tenant, client)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("getting Entra ID token for %s: %w", registry, err)
+		}
+		refreshToken, err := k.exchange(ctx, registry, tenant, accessToken)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("exchanging Entra ID token with %s: %w", registry, err)
+		}
+		clog.FromContext(ctx).Debugf("using Azure workload identity of %s for %s", client, registry)
+		auth := authn.FromConfig(authn.AuthConfig{Username: acrUsername, Password: refreshToken})
+		return auth, k.now().Add(acrRefreshTokenLifetime), nil
+	})
+}
+
+func isACR(registry string) bool {
+	for _, suffix := range acrHostSuffixes {
+		if strings.HasSuffix(registry, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// entraToken exchanges the federated token for an Entra ID access token of
+// client, with the client credentials flow.
+func (k *acrKeychain) entraToken(ctx context.Context, tenant, client string) (string, error) {
+	assertion, err := k.readEnvFile("AZURE_FEDERATED_TOKEN_FILE", "")
+	if err != nil {
+		return "", fmt.Errorf("reading federated token: %w", err)
+	}
+	authority := k.getenv("AZURE_AUTHORITY_HOST")
+	if authority == "" {
+		authority = defaultAzureAuthorityHost
+	}
+	form := url.Values{
+		"client_id":             {client},
+		"scope":                 {acrScope},
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {assertion},
+	}
+	tokenURL := strings.TrimSuffix(authority, "/") + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token"
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := k.postForm(ctx, tokenURL, form, &resp); err != nil {
+		return "", err
+	}
+	return resp.AccessToken, nil
+}
+
+// exchange exchanges an Entra ID access token for an ACR refresh token of
+// registry.
+func (k *acrKeychain) exchange(ctx context.Context, registry, tenant, accessToken string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {registry},
+		"tenant":       {tenant},
+		"access_token": {accessToken},
+	}
+	var resp struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := k.postForm(ctx, k.exchangeURL(registry), form, &resp); err != nil {
+		return "", err
+	}
+	return resp.RefreshToken, nil
+}
+
+func (k *acrKeychain) postForm(ctx context.Context, u string, form url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := k.do(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
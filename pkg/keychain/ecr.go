@@ -0,0 +1,274 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keychain
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+
+	"github.com/chainguard-dev/clog"
+)
+
+// ecrHostRe matches the hosts of ECR private registries, of the account ID and
+// region.
+var ecrHostRe = regexp.MustCompile(`^[0-9]{12}\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com(?:\.cn)?$`)
+
+// ecsCredentialsHost serves the credentials of ECS task roles at
+// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI.
+const ecsCredentialsHost = "http://169.254.170.2"
+
+// ECR resolves the credentials of Amazon ECR registries with an authorization
+// token of the AWS identity of the environment: static credentials from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, a web identity role such as
+// IRSA (AWS_ROLE_ARN and AWS_WEB_IDENTITY_TOKEN_FILE), or container credentials
+// of EKS Pod Identity or ECS task roles.
+var ECR authn.Keychain = ecr
+
+var ecr = &ecrKeychain{identity: newIdentity(), endpoint: awsEndpoint}
+
+type ecrKeychain struct {
+	identity
+	// endpoint returns the URL of the API of an AWS service in region.
+	endpoint func(service, region string) string
+
+	credsMu sync.Mutex
+	creds   map[string]*awsCredentials
+}
+
+// awsEndpoint returns the regional endpoint of service.
+func awsEndpoint(service, region string) string {
+	if strings.HasPrefix(region, "cn-") {
+		return fmt.Sprintf("https://%s.%s.amazonaws.com.cn", service, region)
+	}
+	return fmt.Sprintf("https://%s.%s.amazonaws.com", service, region)
+}
+
+func (k *ecrKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	return k.ResolveContext(context.Background(), target)
+}
+
+func (k *ecrKeychain) ResolveContext(ctx context.Context, target authn.Resource) (authn.Authenticator, error) {
+	registry := target.RegistryStr()
+	m := ecrHostRe.FindStringSubmatch(registry)
+	if m == nil {
+		return authn.Anonymous, nil
+	}
+	region := m[1]
+
+	return k.cached(ctx, registry, func(ctx context.Context) (authn.Authenticator, time.Time, error) {
+		creds, err := k.cachedCredentials(ctx, region)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("getting AWS credentials for %s: %w", registry, err)
+		}
+		if creds == nil {
+			return authn.Anonymous, k.now().Add(time.Hour), nil
+		}
+		auth, expires, err := k.authorizationToken(ctx, *creds, region)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("getting ECR authorization token for %s: %w", registry, err)
+		}
+		clog.FromContext(ctx).Debugf("using AWS credentials of %s for %s", creds.AccessKeyID, registry)
+		return auth, expires, nil
+	})
+}
+
+// cachedCredentials returns the credentials of the environment for region,
+// fetching them again once temporary credentials are about to expire.
+func (k *ecrKeychain) cachedCredentials(ctx context.Context, region string) (*awsCredentials, error) {
+	k.credsMu.Lock()
+	defer k.credsMu.Unlock()
+
+	if c, ok := k.creds[region]; ok && (c == nil || c.Expiration.IsZero() || k.now().Add(refreshMargin).Before(c.Expiration)) {
+		return c, nil
+	}
+	creds, err := k.credentials(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	if k.creds == nil {
+		k.creds = map[string]*awsCredentials{}
+	}
+	k.creds[region] = creds
+	return creds, nil
+}
+
+// credentials returns the AWS credentials of the environment, or nil if there
+// are none.
+func (k *ecrKeychain) credentials(ctx context.Context, region string) (*awsCredentials, error) {
+	if id, secret := k.getenv("AWS_ACCESS_KEY_ID"), k.getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: k.getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if role, tokenFile := k.getenv("AWS_ROLE_ARN"), k.getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); role != "" && tokenFile != "" {
+		return k.assumeRoleWithWebIdentity(ctx, role, region)
+	}
+	if full, relative := k.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"), k.getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); full != "" || relative != "" {
+		if full == "" {
+			full = ecsCredentialsHost + relative
+		}
+		return k.containerCredentials(ctx, full)
+	}
+	return nil, nil
+}
+
+// assumeRoleWithWebIdentity exchanges the token of AWS_WEB_IDENTITY_TOKEN_FILE
+// for the temporary credentials of role with STS.
+func (k *ecrKeychain) assumeRoleWithWebIdentity(ctx context.Context, role, region string) (*awsCredentials, error) {
+	token, err := k.readEnvFile("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+	if err != nil {
+		return nil, fmt.Errorf("reading web identity token: %w", err)
+	}
+	session := k.getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = fmt.Sprintf("apko-%d", k.now().Unix())
+	}
+	query := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {token},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint("sts", region), strings.NewReader(query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var resp struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	body, err := k.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("assuming role %s: %w", role, err)
+	}
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("assuming role %s: %w", role, err)
+	}
+	return &awsCredentials{
+		AccessKeyID:     resp.Credentials.AccessKeyID,
+		SecretAccessKey: resp.Credentials.SecretAccessKey,
+		SessionToken:    resp.Credentials.SessionToken,
+		Expiration:      resp.Credentials.Expiration,
+	}, nil
+}
+
+// containerCredentials fetches the credentials served to containers at uri,
+// with the authorization token of EKS Pod Identity if there is one.
+func (k *ecrKeychain) containerCredentials(ctx context.Context, uri string) (*awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := k.readEnvFile("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", "AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if err != nil {
+		return nil, fmt.Errorf("reading container authorization token: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	body, err := k.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching container credentials: %w", err)
+	}
+	var resp struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("fetching container credentials: %w", err)
+	}
+	return &awsCredentials{
+		AccessKeyID:     resp.AccessKeyID,
+		SecretAccessKey: resp.SecretAccessKey,
+		SessionToken:    resp.Token,
+		Expiration:      resp.Expiration,
+	}, nil
+}
+
+// authorizationToken calls the GetAuthorizationToken API of ECR in region as
+// creds, and returns the registry credentials it gives and when they expire.
+func (k *ecrKeychain) authorizationToken(ctx context.Context, creds awsCredentials, region string) (authn.Authenticator, time.Time, error) {
+	payload := []byte("{}")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint("api.ecr", region), bytes.NewReader(payload))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken")
+	signV4(req, payloadHash(payload), creds, region, "ecr", k.now())
+
+	body, err := k.do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var resp struct {
+		AuthorizationData []struct {
+			AuthorizationToken string  `json:"authorizationToken"`
+			ExpiresAt          float64 `json:"expiresAt"`
+		} `json:"authorizationData"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, time.Time{}, err
+	}
+	if len(resp.AuthorizationData) == 0 {
+		return nil, time.Time{}, fmt.Errorf("no authorization data")
+	}
+	data := resp.AuthorizationData[0]
+	decoded, err := base64.StdEncoding.DecodeString(data.AuthorizationToken)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("decoding authorization token: %w", err)
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, time.Time{}, fmt.Errorf("authorization token is not of the form user:password")
+	}
+	return authn.FromConfig(authn.AuthConfig{Username: user, Password: password}), time.Unix(int64(data.ExpiresAt), 0), nil
+}
+
+// SignAWSRequest signs req for service in region with the AWS credentials of
+// the environment that ECR uses, leaving it unsigned if there are none. The
+// payload of req is not signed, which S3 allows.
+func SignAWSRequest(ctx context.Context, req *http.Request, service, region string) error {
+	creds, err := ecr.cachedCredentials(ctx, region)
+	if err != nil {
+		return fmt.Errorf("getting AWS credentials: %w", err)
+	}
+	if creds == nil {
+		return nil
+	}
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	signV4(req, unsignedPayload, *creds, region, service, ecr.now())
+	return nil
+}
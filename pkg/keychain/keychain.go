@@ -0,0 +1,118 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package keychain resolves the credentials of container registries, from the
+// docker config and from the ambient workload identity of cloud CI, without
+// docker credential helpers.
+package keychain
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/authn/github"
+	"github.com/google/go-containerregistry/pkg/v1/google"
+)
+
+// Cloud resolves the credentials of Amazon ECR, Google Artifact Registry and
+// GCR, and Azure Container Registry from the workload identity of the
+// environment: IRSA, EKS Pod Identity or ECS task roles, Google Application
+// Default Credentials (such as GKE Workload Identity), and Azure Workload
+// Identity federated tokens. Other registries, and environments without an
+// identity, are anonymous.
+var Cloud authn.Keychain = authn.NewMultiKeychain(ECR, google.Keychain, ACR)
+
+// Default is the docker config, then GitHub and then the Cloud keychain.
+var Default authn.Keychain = authn.NewMultiKeychain(authn.DefaultKeychain, github.Keychain, Cloud)
+
+// refreshMargin is how long before they expire tokens are refreshed.
+const refreshMargin = 5 * time.Minute
+
+// identity is what the cloud keychains share: the environment, an HTTP
+// client and the registry tokens they exchanged, until they expire.
+type identity struct {
+	getenv func(string) string
+	client *http.Client
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedAuth
+}
+
+type cachedAuth struct {
+	auth    authn.Authenticator
+	expires time.Time
+}
+
+func newIdentity() identity {
+	return identity{getenv: os.Getenv, client: http.DefaultClient, now: time.Now}
+}
+
+// cached returns the authenticator of registry, exchanging a new one with
+// exchange if there is none or it is about to expire.
+func (id *identity) cached(ctx context.Context, registry string, exchange func(context.Context) (authn.Authenticator, time.Time, error)) (authn.Authenticator, error) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	if c, ok := id.cache[registry]; ok && id.now().Add(refreshMargin).Before(c.expires) {
+		return c.auth, nil
+	}
+	auth, expires, err := exchange(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if id.cache == nil {
+		id.cache = map[string]cachedAuth{}
+	}
+	id.cache[registry] = cachedAuth{auth: auth, expires: expires}
+	return auth, nil
+}
+
+// readEnvFile returns the contents of the file named by the environment
+// variable env, or of the variable fallback if it is not set.
+func (id *identity) readEnvFile(env, fallback string) (string, error) {
+	if path := id.getenv(env); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return id.getenv(fallback), nil
+}
+
+// do sends req and returns the body of its response, or an error for
+// responses other than 200 OK.
+func (id *identity) do(req *http.Request) ([]byte, error) {
+	resp, err := id.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
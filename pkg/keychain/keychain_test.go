@@ -0,0 +1,150 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package keychain
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignV4(t *testing.T) {
+	// The get-vanilla case of the AWS Signature Version 4 test suite.
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	require.NoError(t, err)
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, payloadHash(nil), creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	require.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31", req.Header.Get("Authorization"))
+}
+
+func testEnv(env map[string]string) func(string) string {
+	return func(k string) string { return env[k] }
+}
+
+func resolve(t *testing.T, kc authn.Keychain, registry string) *authn.AuthConfig {
+	t.Helper()
+	reg, err := name.NewRegistry(registry)
+	require.NoError(t, err)
+	auth, err := kc.Resolve(reg)
+	require.NoError(t, err)
+	cfg, err := auth.Authorization()
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestECR(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("web-identity\n"), 0o600))
+
+	var requests []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.NoError(t, req.ParseForm())
+		switch req.URL.Path {
+		case "/sts/us-west-2":
+			requests = append(requests, "sts")
+			require.Equal(t, "AssumeRoleWithWebIdentity", req.Form.Get("Action"))
+			require.Equal(t, "arn:aws:iam::123456789012:role/ci", req.Form.Get("RoleArn"))
+			require.Equal(t, "web-identity", req.Form.Get("WebIdentityToken"))
+			fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse><AssumeRoleWithWebIdentityResult><Credentials>
+<AccessKeyId>ASIATEMP</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>
+</Credentials></AssumeRoleWithWebIdentityResult></AssumeRoleWithWebIdentityResponse>`)
+		case "/api.ecr/us-west-2":
+			requests = append(requests, "ecr")
+			require.Equal(t, "AmazonEC2ContainerRegistry_V20150921.GetAuthorizationToken", req.Header.Get("X-Amz-Target"))
+			require.Equal(t, "session", req.Header.Get("X-Amz-Security-Token"))
+			require.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ASIATEMP/"), req.Header.Get("Authorization"))
+			token := base64.StdEncoding.EncodeToString([]byte("AWS:password"))
+			fmt.Fprintf(w, `{"authorizationData":[{"authorizationToken":%q,"expiresAt":%d}]}`, token, time.Now().Add(12*time.Hour).Unix())
+		default:
+			t.Errorf("unexpected request %s", req.URL)
+		}
+	}))
+	defer s.Close()
+
+	kc := &ecrKeychain{
+		identity: identity{
+			getenv: testEnv(map[string]string{
+				"AWS_ROLE_ARN":                "arn:aws:iam::123456789012:role/ci",
+				"AWS_WEB_IDENTITY_TOKEN_FILE": tokenFile,
+			}),
+			client: s.Client(),
+			now:    time.Now,
+		},
+		endpoint: func(service, region string) string { return s.URL + "/" + service + "/" + region },
+	}
+
+	require.Equal(t, &authn.AuthConfig{Username: "AWS", Password: "password"}, resolve(t, kc, "123456789012.dkr.ecr.us-west-2.amazonaws.com"))
+	// The token is reused until it is about to expire.
+	require.Equal(t, &authn.AuthConfig{Username: "AWS", Password: "password"}, resolve(t, kc, "123456789012.dkr.ecr.us-west-2.amazonaws.com"))
+	require.Equal(t, []string{"sts", "ecr"}, requests)
+
+	require.Equal(t, &authn.AuthConfig{}, resolve(t, kc, "registry.example.com"))
+
+	// Without an identity, ECR is anonymous too.
+	kc = &ecrKeychain{identity: identity{getenv: testEnv(nil), client: s.Client(), now: time.Now}, endpoint: kc.endpoint}
+	require.Equal(t, &authn.AuthConfig{}, resolve(t, kc, "123456789012.dkr.ecr.us-west-2.amazonaws.com"))
+	require.Len(t, requests, 2)
+}
+
+func TestACR(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("federated"), 0o600))
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.NoError(t, req.ParseForm())
+		switch req.URL.Path {
+		case "/tenant/oauth2/v2.0/token":
+			require.Equal(t, "client", req.Form.Get("client_id"))
+			require.Equal(t, "federated", req.Form.Get("client_assertion"))
+			require.Equal(t, acrScope, req.Form.Get("scope"))
+			fmt.Fprint(w, `{"access_token":"entra"}`)
+		case "/example.azurecr.io/oauth2/exchange":
+			require.Equal(t, "entra", req.Form.Get("access_token"))
+			require.Equal(t, "example.azurecr.io", req.Form.Get("service"))
+			require.Equal(t, "tenant", req.Form.Get("tenant"))
+			fmt.Fprint(w, `{"refresh_token":"refresh"}`)
+		default:
+			t.Errorf("unexpected request %s", req.URL)
+		}
+	}))
+	defer s.Close()
+
+	kc := &acrKeychain{
+		identity: identity{
+			getenv: testEnv(map[string]string{
+				"AZURE_TENANT_ID":            "tenant",
+				"AZURE_CLIENT_ID":            "client",
+				"AZURE_FEDERATED_TOKEN_FILE": tokenFile,
+				"AZURE_AUTHORITY_HOST":       s.URL,
+			}),
+			client: s.Client(),
+			now:    time.Now,
+		},
+		exchangeURL: func(registry string) string { return s.URL + "/" + registry + "/oauth2/exchange" },
+	}
+
+	require.Equal(t, &authn.AuthConfig{Username: acrUsername, Password: "refresh"}, resolve(t, kc, "example.azurecr.io"))
+	require.Equal(t, &authn.AuthConfig{}, resolve(t, kc, "registry.example.com"))
+}
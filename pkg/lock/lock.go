@@ -1,11 +1,13 @@
 package lock
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
 	"slices"
 
+	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/build/types"
 )
 
@@ -50,6 +52,33 @@ type LockPkgRangeAndChecksum struct {
 	Checksum string `json:"checksum"`
 }
 
+// NewLockPkg locks the resolved package r, with the byte ranges and checksums
+// of its signature, control and data sections.
+func NewLockPkg(r *apk.APKResolved) LockPkg {
+	p := LockPkg{
+		Name:         r.Package.Name,
+		URL:          r.Package.URL(),
+		Architecture: r.Package.Arch,
+		Version:      r.Package.Version,
+		Control: LockPkgRangeAndChecksum{
+			Range:    fmt.Sprintf("bytes=%d-%d", r.SignatureSize, r.SignatureSize+r.ControlSize-1),
+			Checksum: "sha1-" + base64.StdEncoding.EncodeToString(r.ControlHash),
+		},
+		Data: LockPkgRangeAndChecksum{
+			Range:    fmt.Sprintf("bytes=%d-%d", r.SignatureSize+r.ControlSize, r.SignatureSize+r.ControlSize+r.DataSize-1),
+			Checksum: "sha256-" + base64.StdEncoding.EncodeToString(r.DataHash),
+		},
+		Checksum: r.Package.ChecksumString(),
+	}
+	if r.SignatureSize != 0 {
+		p.Signature = LockPkgRangeAndChecksum{
+			Range:    fmt.Sprintf("bytes=0-%d", r.SignatureSize-1),
+			Checksum: "sha1-" + base64.StdEncoding.EncodeToString(r.SignatureHash),
+		}
+	}
+	return p
+}
+
 type LockRepo struct {
 	Name         string `json:"name"`
 	URL          string `json:"url"`
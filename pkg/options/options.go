@@ -22,6 +22,9 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/auth"
 	"chainguard.dev/apko/pkg/build/types"
@@ -64,6 +67,15 @@ type Options struct {
 	SourceDateEpoch         time.Time             `json:"sourceDateEpoch,omitempty"`
 	SBOMPath                string                `json:"sbomPath,omitempty"`
 	SBOMGenerators          []generator.Generator `json:"-"`
+	SBOMFiles               bool                  `json:"sbomFiles,omitempty"`
+	SBOMDetectLicenses      bool                  `json:"sbomDetectLicenses,omitempty"`
+	SBOMCPEs                bool                  `json:"sbomCPEs,omitempty"`
+	SBOMCPEMapping          string                `json:"sbomCPEMapping,omitempty"`
+	SBOMSigningKey          string                `json:"sbomSigningKey,omitempty"`
+	SBOMKeyless             bool                  `json:"sbomKeyless,omitempty"`
+	SBOMFulcioURL           string                `json:"sbomFulcioURL,omitempty"`
+	SBOMRekorURL            string                `json:"sbomRekorURL,omitempty"`
+	SBOMNameTemplate        string                `json:"sbomNameTemplate,omitempty"`
 	ExtraKeyFiles           []string              `json:"extraKeyFiles,omitempty"`
 	ExtraBuildRepos         []string              `json:"extraBuildRepos,omitempty"`
 	ExtraRepos              []string              `json:"extraRepos,omitempty"`
@@ -78,6 +90,7 @@ type Options struct {
 	CacheDir                string                `json:"cacheDir,omitempty"`
 	Offline                 bool                  `json:"offline,omitempty"`
 	SharedCache             *apk.Cache            `json:"-"`
+	RemoteCache             apk.RemoteCache       `json:"-"`
 	Lockfile                string                `json:"lockfile,omitempty"`
 	Auth                    auth.Authenticator    `json:"-"`
 	IncludePaths            []string              `json:"includePaths,omitempty"`
@@ -85,6 +98,45 @@ type Options struct {
 	Transport               http.RoundTripper     `json:"-"`
 	PackageGetter           apk.PackageGetter     `json:"-"`
 	SizeLimits              SizeLimits            `json:"sizeLimits,omitempty"`
+	// LayerCacheDir, when set, is where built layers are cached between builds.
+	LayerCacheDir string `json:"layerCacheDir,omitempty"`
+	// Keychain authenticates registry requests, e.g. to pull a remote base image.
+	Keychain authn.Keychain `json:"-"`
+	// RemoteOptions are added to those of registry requests, after the ones
+	// apko sets, e.g. to set their transport or user agent.
+	RemoteOptions []remote.Option `json:"-"`
+	// StrictReproducibility fails the build on input that would make the
+	// layers nondeterministic, such as files newer than the build date.
+	StrictReproducibility bool `json:"strictReproducibility,omitempty"`
+	// BuildInfo embeds a build-info document in each image as a label and
+	// an annotation.
+	BuildInfo bool `json:"buildInfo,omitempty"`
+	// Bootc lays the image out for bootc and OSTree hosts, checking that it
+	// is /usr-merged and has a kernel in /usr/lib/modules, and labels it as
+	// a bootable container.
+	Bootc bool `json:"bootc,omitempty"`
+	// FIPS restricts TLS, and the verification of repository signatures, to
+	// FIPS-approved algorithms.
+	FIPS bool `json:"fips,omitempty"`
+	// RepositoryTLS configures TLS for the requests to the repositories and
+	// keyring URLs they match.
+	RepositoryTLS []apk.RepositoryTLS `json:"repositoryTLS,omitempty"`
+	// PackagePolicy is the path to a policy file listing the packages that
+	// may and may not be installed.
+	PackagePolicy string `json:"packagePolicy,omitempty"`
+	// MelangeWorkspace is a melange workspace, or its packages directory,
+	// whose packages and signing keys are added to the build.
+	MelangeWorkspace string `json:"melangeWorkspace,omitempty"`
+	// Scriptlets is the sandbox the install scriptlets of the packages are
+	// run in, or "" not to run them.
+	Scriptlets string `json:"scriptlets,omitempty"`
+	// NoScripts are packages whose scriptlets are not run, in addition to
+	// those of the image configuration.
+	NoScripts []string `json:"noScripts,omitempty"`
+	// BaseImageMirrors are local OCI layouts to load the base images with
+	// the registry references they are keyed by from, instead of pulling
+	// them.
+	BaseImageMirrors map[string]string `json:"baseImageMirrors,omitempty"`
 }
 
 type Auth struct{ User, Pass string }
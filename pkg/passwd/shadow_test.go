@@ -0,0 +1,56 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package passwd
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+func TestShadow(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	shadow, err := os.ReadFile("testdata/shadow")
+	require.NoError(t, err)
+	require.NoError(t, fsys.MkdirAll("etc", 0o755))
+	require.NoError(t, fsys.WriteFile("etc/shadow", shadow, 0o640))
+
+	sf, err := ReadOrCreateShadowFile(fsys, "etc/shadow")
+	require.NoError(t, err)
+	require.Len(t, sf.Entries, 4)
+	require.Equal(t, ShadowEntry{UserName: "nobody", Password: "!", Aging: [7]string{"19000", "0", "99999", "7"}}, sf.Entries[3])
+
+	// The entries round trip.
+	w := &bytes.Buffer{}
+	require.NoError(t, sf.Write(w))
+	require.Equal(t, string(shadow), w.String())
+
+	sf.Set(ShadowEntry{UserName: "root", Password: "$6$salt$hash"})
+	sf.Set(ShadowEntry{UserName: "admin", Password: "!"})
+	require.NoError(t, sf.WriteFile(fsys, "etc/shadow"))
+
+	got, err := fsys.ReadFile("etc/shadow")
+	require.NoError(t, err)
+	require.Equal(t, "root:$6$salt$hash:::::::\nbin:!::0:::::\ndaemon:!::0:::::\nnobody:!:19000:0:99999:7:::\nadmin:!:::::::\n", string(got))
+	fi, err := fsys.Stat("etc/shadow")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o640), fi.Mode())
+
+	require.Error(t, (&ShadowEntry{}).Parse("root:x:0:0:root:/root:/bin/ash"))
+}
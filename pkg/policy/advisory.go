@@ -0,0 +1,377 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// AdvisoryPolicy rejects packages with known vulnerabilities that are not
+// fixed in the version to be installed.
+type AdvisoryPolicy struct {
+	// Feeds are the URLs or paths of the security advisory feeds, either in
+	// the secdb format published by Alpine and Wolfi, such as
+	// "https://secdb.alpinelinux.org/v3.21/main.json" or
+	// "https://packages.wolfi.dev/os/security.json", or as OSV records.
+	Feeds []string `json:"feeds" yaml:"feeds"`
+	// Severity is the minimum severity of the vulnerabilities to reject:
+	// "low", "medium", "high" or "critical". By default all vulnerabilities
+	// are rejected, including those with no known severity, such as all of
+	// those from secdb feeds.
+	Severity string `json:"severity,omitempty" yaml:"severity,omitempty"`
+	// Ignore lists the IDs of vulnerabilities to ignore, such as those that
+	// do not apply to the image. Aliases are matched as well.
+	Ignore []string `json:"ignore,omitempty" yaml:"ignore,omitempty"`
+	// Warn reports the vulnerable packages as warnings instead of failing
+	// the build.
+	Warn bool `json:"warn,omitempty" yaml:"warn,omitempty"`
+
+	// advisories are the fetched advisories, by package name.
+	advisories map[string][]Advisory
+}
+
+// Severity is the severity of a vulnerability.
+type Severity int
+
+// The severities, from lowest to highest.
+const (
+	SeverityUnknown Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severities = []string{"unknown", "low", "medium", "high", "critical"}
+
+func (s Severity) String() string {
+	if int(s) < len(severities) {
+		return severities[s]
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// ParseSeverity parses a severity name, ignoring case. "moderate" is accepted
+// as a synonym of "medium", as used by GitHub advisories.
+func ParseSeverity(s string) (Severity, error) {
+	s = strings.ToLower(s)
+	if s == "moderate" {
+		s = "medium"
+	}
+	if i := slices.Index(severities, s); i >= 0 {
+		return Severity(i), nil
+	}
+	return SeverityUnknown, fmt.Errorf("unknown severity %q, expected low, medium, high or critical", s)
+}
+
+// Advisory is a vulnerability affecting a range of versions of a package.
+type Advisory struct {
+	ID       string
+	Aliases  []string
+	Severity Severity
+	// Introduced is the first affected version, or "" if all versions before
+	// Fixed are affected.
+	Introduced string
+	// Fixed is the first version that is no longer affected, or "" if there
+	// is no fix.
+	Fixed string
+}
+
+// affects reports whether version is affected by a.
+func (a Advisory) affects(version string) (bool, error) {
+	v, err := apk.ParseVersion(version)
+	if err != nil {
+		return false, err
+	}
+	if a.Introduced != "" && a.Introduced != "0" {
+		introduced, err := apk.ParseVersion(a.Introduced)
+		if err != nil {
+			return false, fmt.Errorf("advisory %s: %w", a.ID, err)
+		}
+		if apk.CompareVersions(v, introduced) < 0 {
+			return false, nil
+		}
+	}
+	if a.Fixed == "" {
+		return true, nil
+	}
+	fixed, err := apk.ParseVersion(a.Fixed)
+	if err != nil {
+		return false, fmt.Errorf("advisory %s: %w", a.ID, err)
+	}
+	return apk.CompareVersions(v, fixed) < 0, nil
+}
+
+func (a Advisory) String() string {
+	s := a.ID
+	if a.Severity != SeverityUnknown {
+		s += " (" + a.Severity.String() + ")"
+	}
+	if a.Fixed != "" {
+		return s + " fixed in " + a.Fixed
+	}
+	return s + " with no fix"
+}
+
+// Fetch fetches the advisory feeds of p with client, which is used for the
+// feeds that are URLs.
+func (p *AdvisoryPolicy) Fetch(ctx context.Context, client *http.Client) error {
+	ctx, span := otel.Tracer("apko").Start(ctx, "FetchAdvisories")
+	defer span.End()
+
+	p.advisories = map[string][]Advisory{}
+	for _, feed := range p.Feeds {
+		b, err := readFeed(ctx, client, feed)
+		if err != nil {
+			return fmt.Errorf("fetching advisory feed %s: %w", feed, err)
+		}
+		advisories, err := ParseAdvisories(b)
+		if err != nil {
+			return fmt.Errorf("parsing advisory feed %s: %w", feed, err)
+		}
+		for name, as := range advisories {
+			p.advisories[name] = append(p.advisories[name], as...)
+		}
+	}
+	return nil
+}
+
+func readFeed(ctx context.Context, client *http.Client, feed string) ([]byte, error) {
+	if !strings.HasPrefix(feed, "https://") && !strings.HasPrefix(feed, "http://") {
+		return os.ReadFile(feed)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// violation returns why pkg is rejected by p, or "" if it is not. Advisories
+// are looked up by the name of the package and by its origin, since feeds
+// usually list the packages built from the same origin under its name.
+func (p *AdvisoryPolicy) violation(pkg Package) (string, error) {
+	minimum := SeverityUnknown
+	if p.Severity != "" {
+		s, err := ParseSeverity(p.Severity)
+		if err != nil {
+			return "", err
+		}
+		minimum = s
+	}
+	advisories := p.advisories[pkg.Name]
+	if pkg.Origin != "" && pkg.Origin != pkg.Name {
+		advisories = append(slices.Clone(advisories), p.advisories[pkg.Origin]...)
+	}
+
+	var found []string
+	seen := map[string]bool{}
+	for _, a := range advisories {
+		if a.Severity < minimum || seen[a.ID] || p.ignored(a) {
+			continue
+		}
+		affected, err := a.affects(pkg.Version)
+		if err != nil {
+			return "", fmt.Errorf("checking %s-%s: %w", pkg.Name, pkg.Version, err)
+		}
+		if affected {
+			seen[a.ID] = true
+			found = append(found, a.String())
+		}
+	}
+	if len(found) == 0 {
+		return "", nil
+	}
+	return "vulnerable to " + strings.Join(found, ", "), nil
+}
+
+func (p *AdvisoryPolicy) ignored(a Advisory) bool {
+	for _, id := range p.Ignore {
+		if id == a.ID || slices.Contains(a.Aliases, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseAdvisories parses an advisory feed in the secdb format, or as OSV
+// records: a single record, an array of them, or one per line. It returns the
+// advisories by package name.
+func ParseAdvisories(b []byte) (map[string][]Advisory, error) {
+	b = bytes.TrimSpace(b)
+	if bytes.HasPrefix(b, []byte("[")) {
+		var records []osvRecord
+		if err := json.Unmarshal(b, &records); err != nil {
+			return nil, err
+		}
+		return osvAdvisories(records), nil
+	}
+
+	var probe struct {
+		Packages json.RawMessage `json:"packages"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if err := dec.Decode(&probe); err != nil {
+		return nil, err
+	}
+	if probe.Packages != nil {
+		var db secDB
+		if err := json.Unmarshal(b, &db); err != nil {
+			return nil, err
+		}
+		return db.advisories(), nil
+	}
+
+	var records []osvRecord
+	dec = json.NewDecoder(bytes.NewReader(b))
+	for dec.More() {
+		var r osvRecord
+		if err := dec.Decode(&r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return osvAdvisories(records), nil
+}
+
+// secDB is the secdb format, which lists the vulnerabilities fixed in each
+// version of a package. The vulnerabilities listed under version "0" do not
+// affect the package at all.
+type secDB struct {
+	Packages []struct {
+		Pkg struct {
+			Name     string              `json:"name"`
+			SecFixes map[string][]string `json:"secfixes"`
+		} `json:"pkg"`
+	} `json:"packages"`
+}
+
+func (db secDB) advisories() map[string][]Advisory {
+	out := map[string][]Advisory{}
+	for _, p := range db.Packages {
+		// Order the advisories deterministically.
+		versions := make([]string, 0, len(p.Pkg.SecFixes))
+		for v := range p.Pkg.SecFixes {
+			versions = append(versions, v)
+		}
+		slices.Sort(versions)
+		for _, version := range versions {
+			if version == "0" {
+				continue
+			}
+			for _, ids := range p.Pkg.SecFixes[version] {
+				// Aliases of the same vulnerability are listed together.
+				fields := strings.Fields(ids)
+				if len(fields) == 0 {
+					continue
+				}
+				out[p.Pkg.Name] = append(out[p.Pkg.Name], Advisory{ID: fields[0], Aliases: fields[1:], Fixed: version})
+			}
+		}
+	}
+	return out
+}
+
+// osvRecord is the part of an OSV record (https://ossf.github.io/osv-schema/)
+// used to check packages. The severity is taken from the textual severity of
+// the database or ecosystem specific fields, since computing one from a CVSS
+// vector is out of scope.
+type osvRecord struct {
+	ID               string      `json:"id"`
+	Aliases          []string    `json:"aliases"`
+	Withdrawn        string      `json:"withdrawn"`
+	DatabaseSpecific osvSpecific `json:"database_specific"`
+	Affected         []struct {
+		Package struct {
+			Name string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string `json:"type"`
+			Events []struct {
+				Introduced string `json:"introduced"`
+				Fixed      string `json:"fixed"`
+			} `json:"events"`
+		} `json:"ranges"`
+		EcosystemSpecific osvSpecific `json:"ecosystem_specific"`
+		DatabaseSpecific  osvSpecific `json:"database_specific"`
+	} `json:"affected"`
+}
+
+type osvSpecific struct {
+	Severity string `json:"severity"`
+}
+
+func osvAdvisories(records []osvRecord) map[string][]Advisory {
+	out := map[string][]Advisory{}
+	for _, r := range records {
+		if r.Withdrawn != "" {
+			continue
+		}
+		for _, a := range r.Affected {
+			severity := SeverityUnknown
+			for _, s := range []string{a.EcosystemSpecific.Severity, a.DatabaseSpecific.Severity, r.DatabaseSpecific.Severity} {
+				if parsed, err := ParseSeverity(s); s != "" && err == nil {
+					severity = parsed
+					break
+				}
+			}
+			for _, rng := range a.Ranges {
+				if rng.Type != "ECOSYSTEM" {
+					continue
+				}
+				// Each introduced event starts an affected range, which a
+				// fixed event ends.
+				var open *Advisory
+				for _, e := range rng.Events {
+					switch {
+					case e.Introduced != "":
+						if open != nil {
+							out[a.Package.Name] = append(out[a.Package.Name], *open)
+						}
+						open = &Advisory{ID: r.ID, Aliases: r.Aliases, Severity: severity, Introduced: e.Introduced}
+					case e.Fixed != "" && open != nil:
+						open.Fixed = e.Fixed
+						out[a.Package.Name] = append(out[a.Package.Name], *open)
+						open = nil
+					}
+				}
+				if open != nil {
+					out[a.Package.Name] = append(out[a.Package.Name], *open)
+				}
+			}
+		}
+	}
+	return out
+}
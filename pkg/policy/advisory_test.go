@@ -0,0 +1,139 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSecDB = `{
+  "apkurl": "{{urlprefix}}/{{reponame}}/{{arch}}/{{pkg.name}}-{{pkg.ver}}.apk",
+  "archs": ["x86_64"],
+  "reponame": "os",
+  "urlprefix": "https://packages.wolfi.dev",
+  "packages": [
+    {"pkg": {"name": "curl", "secfixes": {
+      "0": ["CVE-2017-1000101"],
+      "8.11.0-r0": ["CVE-2024-9681 GHSA-xxxx-yyyy-zzzz"],
+      "8.11.1-r0": ["CVE-2024-11053"]
+    }}},
+    {"pkg": {"name": "openssl", "secfixes": {"3.4.0-r0": ["CVE-2024-9143"]}}}
+  ]
+}`
+
+const testOSV = `[{
+  "id": "CGA-0001",
+  "aliases": ["CVE-2024-12797"],
+  "database_specific": {"severity": "High"},
+  "affected": [{
+    "package": {"ecosystem": "Wolfi", "name": "openssl"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "3.4.0-r0"}, {"fixed": "3.4.1-r0"}]}]
+  }]
+}, {
+  "id": "CGA-0002",
+  "aliases": ["CVE-2025-0001"],
+  "affected": [{
+    "package": {"ecosystem": "Wolfi", "name": "glibc"},
+    "ecosystem_specific": {"severity": "low"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]
+  }]
+}, {
+  "id": "CGA-0003",
+  "withdrawn": "2025-01-01T00:00:00Z",
+  "affected": [{
+    "package": {"ecosystem": "Wolfi", "name": "glibc"},
+    "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}]}]
+  }]
+}]`
+
+func TestParseAdvisories(t *testing.T) {
+	secdb, err := ParseAdvisories([]byte(testSecDB))
+	require.NoError(t, err)
+	require.Equal(t, map[string][]Advisory{
+		"curl": {
+			{ID: "CVE-2024-9681", Aliases: []string{"GHSA-xxxx-yyyy-zzzz"}, Fixed: "8.11.0-r0"},
+			{ID: "CVE-2024-11053", Aliases: []string{}, Fixed: "8.11.1-r0"},
+		},
+		"openssl": {{ID: "CVE-2024-9143", Aliases: []string{}, Fixed: "3.4.0-r0"}},
+	}, secdb)
+
+	osv, err := ParseAdvisories([]byte(testOSV))
+	require.NoError(t, err)
+	require.Equal(t, map[string][]Advisory{
+		"openssl": {{ID: "CGA-0001", Aliases: []string{"CVE-2024-12797"}, Severity: SeverityHigh, Introduced: "3.4.0-r0", Fixed: "3.4.1-r0"}},
+		"glibc":   {{ID: "CGA-0002", Aliases: []string{"CVE-2025-0001"}, Severity: SeverityLow, Introduced: "0"}},
+	}, osv)
+
+	// A single record, or one per line.
+	osv, err = ParseAdvisories([]byte(`{"id": "CGA-0004", "affected": [{"package": {"name": "zlib"}, "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.3.1-r0"}]}]}]}
+{"id": "CGA-0005", "affected": [{"package": {"name": "zlib"}, "ranges": [{"type": "ECOSYSTEM", "events": [{"introduced": "0"}, {"fixed": "1.3.1-r1"}]}]}]}`))
+	require.NoError(t, err)
+	require.Len(t, osv["zlib"], 2)
+}
+
+func TestCheckAdvisories(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testSecDB))
+	}))
+	defer srv.Close()
+	osvFile := filepath.Join(t.TempDir(), "osv.json")
+	require.NoError(t, os.WriteFile(osvFile, []byte(testOSV), 0o644))
+
+	check := func(t *testing.T, advisories *AdvisoryPolicy) (Violations, error) {
+		require.NoError(t, advisories.Fetch(t.Context(), srv.Client()))
+		return (&Policy{Advisories: advisories}).Check(testPackages)
+	}
+
+	t.Run("all severities", func(t *testing.T) {
+		_, err := check(t, &AdvisoryPolicy{Feeds: []string{srv.URL, osvFile}})
+		require.EqualError(t, err, `4 package(s) not allowed by the package policy:
+  curl-8.11.0-r0: vulnerable to CVE-2024-11053 fixed in 8.11.1-r0 (curl)
+  libcurl-openssl4-8.11.0-r0: vulnerable to CVE-2024-11053 fixed in 8.11.1-r0 (curl -> libcurl-openssl4)
+  libssl3-3.4.0-r0: vulnerable to CGA-0001 (high) fixed in 3.4.1-r0 (curl -> libcurl-openssl4 -> libssl3)
+  glibc-2.40-r0: vulnerable to CGA-0002 (low) with no fix (curl -> glibc)`)
+	})
+	t.Run("minimum severity", func(t *testing.T) {
+		_, err := check(t, &AdvisoryPolicy{Feeds: []string{srv.URL, osvFile}, Severity: "HIGH"})
+		require.EqualError(t, err, `1 package(s) not allowed by the package policy:
+  libssl3-3.4.0-r0: vulnerable to CGA-0001 (high) fixed in 3.4.1-r0 (curl -> libcurl-openssl4 -> libssl3)`)
+	})
+	t.Run("ignore and warn", func(t *testing.T) {
+		warnings, err := check(t, &AdvisoryPolicy{Feeds: []string{srv.URL, osvFile}, Ignore: []string{"CVE-2024-11053", "CVE-2024-12797"}, Warn: true})
+		require.NoError(t, err)
+		require.Equal(t, Violations{{
+			Package: testPackages[3],
+			Reason:  "vulnerable to CGA-0002 (low) with no fix",
+			Chain:   []string{"curl", "glibc"},
+		}}, warnings)
+	})
+	t.Run("missing feed", func(t *testing.T) {
+		err := (&AdvisoryPolicy{Feeds: []string{srv.URL, filepath.Join(t.TempDir(), "missing.json")}}).Fetch(t.Context(), srv.Client())
+		require.ErrorContains(t, err, "fetching advisory feed")
+	})
+}
+
+func TestParseSeverity(t *testing.T) {
+	s, err := ParseSeverity("Moderate")
+	require.NoError(t, err)
+	require.Equal(t, SeverityMedium, s)
+	_, err = ParseSeverity("severe")
+	require.ErrorContains(t, err, `unknown severity "severe"`)
+}
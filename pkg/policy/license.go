@@ -0,0 +1,230 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LicensePolicy lists the licenses the installed packages may and may not be
+// distributed under.
+type LicensePolicy struct {
+	// Allow, if not empty, lists the licenses that are allowed, as SPDX
+	// license identifiers or patterns such as "BSD-*".
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	// Deny lists the licenses that are not allowed, even if they match Allow.
+	Deny []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+	// Warn reports the packages whose license is not allowed as warnings
+	// instead of failing the build.
+	Warn bool `json:"warn,omitempty" yaml:"warn,omitempty"`
+}
+
+// violation returns why a package licensed under the SPDX license expression
+// license is not allowed by p, or "" if it is. A license that cannot be
+// parsed is not allowed.
+//
+// Each license of the expression is checked on its own. A package is allowed
+// if, for "A AND B", both A and B are allowed and, for "A OR B", either of
+// them is. Following the Alpine convention, licenses separated only by spaces
+// are treated as "AND". "A WITH exception" is allowed if a pattern matches it
+// as a whole or, for Allow, matches A.
+func (p *LicensePolicy) violation(license string) string {
+	expr, err := parseLicense(license)
+	if err != nil {
+		return err.Error()
+	}
+	if expr == nil {
+		if len(p.Allow) != 0 {
+			return "no license is not allowed"
+		}
+		return ""
+	}
+	return expr.violation(p)
+}
+
+// licenseExpr is a parsed SPDX license expression: either a license, with an
+// optional exception, or the operator op applied to args.
+type licenseExpr struct {
+	id, exception string
+	op            string
+	args          []*licenseExpr
+}
+
+func (e *licenseExpr) String() string {
+	if e.op == "" {
+		if e.exception != "" {
+			return e.id + " WITH " + e.exception
+		}
+		return e.id
+	}
+	parts := make([]string, 0, len(e.args))
+	for _, a := range e.args {
+		if a.op != "" {
+			parts = append(parts, "("+a.String()+")")
+		} else {
+			parts = append(parts, a.String())
+		}
+	}
+	return strings.Join(parts, " "+e.op+" ")
+}
+
+func (e *licenseExpr) violation(p *LicensePolicy) string {
+	switch e.op {
+	case "AND":
+		for _, a := range e.args {
+			if reason := a.violation(p); reason != "" {
+				return reason
+			}
+		}
+		return ""
+	case "OR":
+		var reasons []string
+		for _, a := range e.args {
+			reason := a.violation(p)
+			if reason == "" {
+				return ""
+			}
+			reasons = append(reasons, reason)
+		}
+		return strings.Join(reasons, " and ")
+	}
+
+	license := e.String()
+	for _, pattern := range p.Deny {
+		if matchLicense(pattern, license) || (e.exception == "" && matchLicense(pattern, e.id)) {
+			return fmt.Sprintf("license %s is denied by %s", license, pattern)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return ""
+	}
+	for _, pattern := range p.Allow {
+		if matchLicense(pattern, license) || matchLicense(pattern, e.id) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("license %s is not allowed", license)
+}
+
+// matchLicense reports whether license matches pattern. SPDX license
+// identifiers are not case sensitive.
+func matchLicense(pattern, license string) bool {
+	return match(strings.ToLower(pattern), strings.ToLower(license))
+}
+
+// parseLicense parses an SPDX license expression, returning nil for an empty
+// one.
+func parseLicense(s string) (*licenseExpr, error) {
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(s))
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	p := &licenseParser{tokens: tokens}
+	e, err := p.or()
+	if err == nil && p.pos != len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing license %q: %w", s, err)
+	}
+	return e, nil
+}
+
+type licenseParser struct {
+	tokens []string
+	pos    int
+}
+
+// peek returns the next token, with operators in upper case, or "" at the end.
+func (p *licenseParser) peek() string {
+	if p.pos == len(p.tokens) {
+		return ""
+	}
+	t := p.tokens[p.pos]
+	if u := strings.ToUpper(t); u == "AND" || u == "OR" || u == "WITH" {
+		return u
+	}
+	return t
+}
+
+func (p *licenseParser) or() (*licenseExpr, error) {
+	return p.binary("OR", p.and)
+}
+
+func (p *licenseParser) and() (*licenseExpr, error) {
+	return p.binary("AND", p.license)
+}
+
+// binary parses one or more operands separated by op. For "AND", operands
+// separated only by spaces are accepted as well.
+func (p *licenseParser) binary(op string, operand func() (*licenseExpr, error)) (*licenseExpr, error) {
+	e, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	args := []*licenseExpr{e}
+	for {
+		switch t := p.peek(); {
+		case t == op:
+			p.pos++
+		case op == "AND" && t != "" && t != "OR" && t != ")":
+		default:
+			if len(args) == 1 {
+				return e, nil
+			}
+			return &licenseExpr{op: op, args: args}, nil
+		}
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, next)
+	}
+}
+
+func (p *licenseParser) license() (*licenseExpr, error) {
+	switch t := p.peek(); t {
+	case "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case "AND", "OR", "WITH", ")":
+		return nil, fmt.Errorf("unexpected %q", t)
+	case "(":
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	default:
+		p.pos++
+		e := &licenseExpr{id: t}
+		if p.peek() == "WITH" {
+			p.pos++
+			switch exc := p.peek(); exc {
+			case "", "AND", "OR", "WITH", "(", ")":
+				return nil, fmt.Errorf("missing exception after WITH")
+			default:
+				e.exception = exc
+				p.pos++
+			}
+		}
+		return e, nil
+	}
+}
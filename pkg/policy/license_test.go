@@ -0,0 +1,99 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLicense(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"MIT", "MIT"},
+		{"MIT and Apache-2.0", "MIT AND Apache-2.0"},
+		{"MIT OR Apache-2.0 AND BSD-3-Clause", "MIT OR (Apache-2.0 AND BSD-3-Clause)"},
+		{"(MIT OR Apache-2.0) AND BSD-3-Clause", "(MIT OR Apache-2.0) AND BSD-3-Clause"},
+		{"GPL-2.0-only WITH Linux-syscall-note", "GPL-2.0-only WITH Linux-syscall-note"},
+		// Alpine packages often list licenses separated by spaces.
+		{"MIT BSD-2-Clause OR ISC", "(MIT AND BSD-2-Clause) OR ISC"},
+	} {
+		e, err := parseLicense(tc.in)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.want, e.String(), tc.in)
+	}
+
+	for _, in := range []string{"MIT AND", "(MIT", "MIT)", "OR MIT", "GPL-2.0-only WITH"} {
+		_, err := parseLicense(in)
+		require.Error(t, err, in)
+	}
+}
+
+func TestLicenseViolation(t *testing.T) {
+	p := &LicensePolicy{
+		Allow: []string{"MIT", "Apache-2.0", "BSD-*", "GPL-2.0-only"},
+		Deny:  []string{"GPL-3.0-*", "AGPL-*"},
+	}
+	for _, tc := range []struct {
+		license, want string
+	}{
+		{"MIT", ""},
+		{"mit", ""},
+		{"BSD-3-Clause AND Apache-2.0", ""},
+		{"GPL-3.0-or-later OR MIT", ""},
+		{"GPL-2.0-only WITH Linux-syscall-note", ""},
+		{"MIT AND GPL-3.0-only", "license GPL-3.0-only is denied by GPL-3.0-*"},
+		{"AGPL-3.0-only OR ISC", "license AGPL-3.0-only is denied by AGPL-* and license ISC is not allowed"},
+		{"LicenseRef-custom", "license LicenseRef-custom is not allowed"},
+		{"", "no license is not allowed"},
+		{"MIT AND (", `parsing license "MIT AND (": unexpected end of expression`},
+	} {
+		require.Equal(t, tc.want, p.violation(tc.license), tc.license)
+	}
+
+	// Without allowed licenses, only denied ones are violations.
+	require.Empty(t, (&LicensePolicy{Deny: []string{"GPL-*"}}).violation(""))
+	require.Empty(t, (&LicensePolicy{Deny: []string{"GPL-*"}}).violation("custom"))
+}
+
+func TestCheckLicenses(t *testing.T) {
+	pkgs := []Package{
+		{Name: "busybox", Version: "1.37.0-r0", License: "GPL-2.0-only", Dependencies: []string{"libcrypt1"}},
+		{Name: "libcrypt1", Version: "2.40-r0", License: "LGPL-2.1-or-later"},
+		{Name: "ca-certificates-bundle", Version: "20241121-r0", License: "MPL-2.0 AND MIT"},
+	}
+	licenses := &LicensePolicy{Allow: []string{"MIT", "MPL-2.0", "LGPL-*"}, Deny: []string{"GPL-*"}}
+
+	warnings, err := (&Policy{Licenses: licenses}).Check(pkgs)
+	require.Empty(t, warnings)
+	require.EqualError(t, err, `1 package(s) not allowed by the package policy:
+  busybox-1.37.0-r0: license GPL-2.0-only is denied by GPL-* (busybox)`)
+
+	licenses.Warn = true
+	warnings, err = (&Policy{Licenses: licenses}).Check(pkgs)
+	require.NoError(t, err)
+	require.Equal(t, Violations{{
+		Package: pkgs[0],
+		Reason:  "license GPL-2.0-only is denied by GPL-*",
+		Chain:   []string{"busybox"},
+	}}, warnings)
+
+	// Packages denied by the package rules are not reported twice.
+	warnings, err = (&Policy{Deny: []Rule{{Name: "busybox"}}, Licenses: licenses}).Check(pkgs)
+	require.Empty(t, warnings)
+	require.ErrorContains(t, err, "busybox-1.37.0-r0: denied by name=busybox (busybox)")
+}
@@ -0,0 +1,307 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package policy enforces a policy on the packages installed in an image,
+// such as allowing only packages from an internal repository or denying a
+// package and everything built from the same origin, on their licenses, and on
+// which of them may run install scriptlets.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy lists the packages that may and may not be installed.
+type Policy struct {
+	// Allow, if not empty, lists the packages that may be installed: a
+	// package must match at least one of the rules.
+	Allow []Rule `json:"allow,omitempty" yaml:"allow,omitempty"`
+	// Deny lists the packages that must not be installed, even if allowed.
+	Deny []Rule `json:"deny,omitempty" yaml:"deny,omitempty"`
+	// Licenses, if set, restricts the licenses of the packages.
+	Licenses *LicensePolicy `json:"licenses,omitempty" yaml:"licenses,omitempty"`
+	// Advisories, if set, rejects packages with known vulnerabilities. Its
+	// feeds must be fetched before checking packages.
+	Advisories *AdvisoryPolicy `json:"advisories,omitempty" yaml:"advisories,omitempty"`
+	// Scriptlets, if set, restricts the packages whose install scriptlets
+	// may run, when scriptlets are run at all.
+	Scriptlets *ScriptletPolicy `json:"scriptlets,omitempty" yaml:"scriptlets,omitempty"`
+}
+
+// ScriptletPolicy lists the packages whose install scriptlets may run. The
+// scriptlets of the packages it does not allow are skipped.
+type ScriptletPolicy struct {
+	// Allow, if not empty, lists the packages whose scriptlets may run.
+	Allow []Rule `json:"allow,omitempty" yaml:"allow,omitempty"`
+	// Deny lists the packages whose scriptlets must not run, even if allowed.
+	Deny []Rule `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// Rule matches packages by name, origin and repository. Each field is a
+// pattern in which "*" matches any run of characters and "?" any single
+// character; the empty pattern matches anything. A package matches the rule
+// if it matches all of its patterns.
+type Rule struct {
+	Name   string `json:"name,omitempty" yaml:"name,omitempty"`
+	Origin string `json:"origin,omitempty" yaml:"origin,omitempty"`
+	// Repository is matched against the URL of the repository the package
+	// is installed from, without the architecture, such as
+	// "https://packages.wolfi.dev/os".
+	Repository string `json:"repository,omitempty" yaml:"repository,omitempty"`
+}
+
+// Package is a package checked against a policy.
+type Package struct {
+	Name       string
+	Version    string
+	Origin     string
+	Repository string
+	// License is the SPDX license expression of the package.
+	License string
+	// Dependencies and Provides are used to explain why the package is
+	// installed.
+	Dependencies []string
+	Provides     []string
+}
+
+// Load reads a policy from the YAML file at path.
+func Load(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading package policy: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	var p Policy
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parsing package policy %s: %w", path, err)
+	}
+	if p.Advisories != nil && p.Advisories.Severity != "" {
+		if _, err := ParseSeverity(p.Advisories.Severity); err != nil {
+			return nil, fmt.Errorf("parsing package policy %s: %w", path, err)
+		}
+	}
+	return &p, nil
+}
+
+func (r Rule) String() string {
+	var parts []string
+	for _, f := range []struct{ k, v string }{{"name", r.Name}, {"origin", r.Origin}, {"repository", r.Repository}} {
+		if f.v != "" {
+			parts = append(parts, f.k+"="+f.v)
+		}
+	}
+	if len(parts) == 0 {
+		return "*"
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r Rule) matches(pkg Package) bool {
+	return match(r.Name, pkg.Name) && match(r.Origin, pkg.Origin) && match(r.Repository, pkg.Repository)
+}
+
+func match(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	re := "^" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(pattern)) + "$"
+	return regexp.MustCompile(re).MatchString(s)
+}
+
+// violation returns why pkg is not allowed by the package rules of p, or ""
+// if it is.
+func (p *Policy) violation(pkg Package) string {
+	for _, r := range p.Deny {
+		if r.matches(pkg) {
+			return "denied by " + r.String()
+		}
+	}
+	if len(p.Allow) == 0 {
+		return ""
+	}
+	for _, r := range p.Allow {
+		if r.matches(pkg) {
+			return ""
+		}
+	}
+	return "not allowed by any rule"
+}
+
+// AllowsScriptlets reports whether the install scriptlets of pkg may run. All
+// packages may run them if p has no scriptlet rules.
+func (p *Policy) AllowsScriptlets(pkg Package) bool {
+	if p == nil || p.Scriptlets == nil {
+		return true
+	}
+	for _, r := range p.Scriptlets.Deny {
+		if r.matches(pkg) {
+			return false
+		}
+	}
+	if len(p.Scriptlets.Allow) == 0 {
+		return true
+	}
+	for _, r := range p.Scriptlets.Allow {
+		if r.matches(pkg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Violation is a package that is not allowed by a policy.
+type Violation struct {
+	Package Package
+	Reason  string
+	// Chain is the dependency chain that pulls the package in, starting from
+	// a package no other package depends on, which is usually one requested
+	// by the image configuration.
+	Chain []string
+}
+
+// Violations is the error returned for the packages not allowed by a policy.
+type Violations []Violation
+
+func (v Violations) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d package(s) not allowed by the package policy:", len(v))
+	for _, v := range v {
+		fmt.Fprintf(&b, "\n  %s-%s: %s (%s)", v.Package.Name, v.Package.Version, v.Reason, strings.Join(v.Chain, " -> "))
+	}
+	return b.String()
+}
+
+// Check checks the packages to be installed against p. It returns Violations
+// as an error if any package is not allowed, and separately the license and
+// advisory violations that are only to be reported as warnings.
+func (p *Policy) Check(pkgs []Package) (warnings Violations, err error) {
+	var violations Violations
+	var chains map[string][]string
+	add := func(warn bool, pkg Package, reason string) {
+		if chains == nil {
+			chains = dependencyChains(pkgs)
+		}
+		chain, ok := chains[pkg.Name]
+		if !ok {
+			chain = []string{pkg.Name}
+		}
+		v := Violation{Package: pkg, Reason: reason, Chain: chain}
+		if warn {
+			warnings = append(warnings, v)
+		} else {
+			violations = append(violations, v)
+		}
+	}
+	for _, pkg := range pkgs {
+		if reason := p.violation(pkg); reason != "" {
+			add(false, pkg, reason)
+			continue
+		}
+		if p.Licenses != nil {
+			if reason := p.Licenses.violation(pkg.License); reason != "" {
+				add(p.Licenses.Warn, pkg, reason)
+			}
+		}
+		if p.Advisories != nil {
+			reason, err := p.Advisories.violation(pkg)
+			if err != nil {
+				return nil, err
+			}
+			if reason != "" {
+				add(p.Advisories.Warn, pkg, reason)
+			}
+		}
+	}
+	if len(violations) != 0 {
+		return warnings, violations
+	}
+	return warnings, nil
+}
+
+// dependencyChains returns the shortest dependency chain to each of pkgs, by
+// package name, from the packages no other package depends on.
+func dependencyChains(pkgs []Package) map[string][]string {
+	providers := map[string]int{}
+	for i, pkg := range pkgs {
+		for _, p := range pkg.Provides {
+			if _, ok := providers[depName(p)]; !ok {
+				providers[depName(p)] = i
+			}
+		}
+	}
+	// Packages win over virtual names they may collide with.
+	for i, pkg := range pkgs {
+		providers[pkg.Name] = i
+	}
+
+	// deps returns the packages pkg depends on, ignoring conflicts.
+	deps := func(pkg Package) []int {
+		var out []int
+		for _, dep := range pkg.Dependencies {
+			if strings.HasPrefix(dep, "!") {
+				continue
+			}
+			if i, ok := providers[depName(dep)]; ok {
+				out = append(out, i)
+			}
+		}
+		return out
+	}
+	dependedOn := make([]bool, len(pkgs))
+	for _, pkg := range pkgs {
+		for _, i := range deps(pkg) {
+			dependedOn[i] = true
+		}
+	}
+
+	chains := map[string][]string{}
+	var queue []int
+	visit := func(i int, parent []string) {
+		if _, seen := chains[pkgs[i].Name]; seen {
+			return
+		}
+		chains[pkgs[i].Name] = append(append([]string{}, parent...), pkgs[i].Name)
+		queue = append(queue, i)
+	}
+	for i := range pkgs {
+		if !dependedOn[i] {
+			visit(i, nil)
+		}
+	}
+	for len(queue) != 0 {
+		i := queue[0]
+		queue = queue[1:]
+		for _, dep := range deps(pkgs[i]) {
+			visit(dep, chains[pkgs[i].Name])
+		}
+	}
+	return chains
+}
+
+// depName returns the name of a dependency or provides, without its version
+// constraint or repository pin.
+func depName(dep string) string {
+	if i := strings.IndexAny(dep, "<>=~"); i >= 0 {
+		dep = dep[:i]
+	}
+	name, _, _ := strings.Cut(dep, "@")
+	return name
+}
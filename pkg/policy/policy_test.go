@@ -0,0 +1,126 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testPackages = []Package{
+	{Name: "curl", Version: "8.11.0-r0", Origin: "curl", Repository: "https://packages.wolfi.dev/os", Dependencies: []string{"libcurl-openssl4=8.11.0-r0", "so:libc.so.6"}},
+	{Name: "libcurl-openssl4", Version: "8.11.0-r0", Origin: "curl", Repository: "https://packages.wolfi.dev/os", Dependencies: []string{"so:libssl.so.3", "!libcurl-rustls4"}},
+	{Name: "libssl3", Version: "3.4.0-r0", Origin: "openssl", Repository: "https://packages.wolfi.dev/os", Provides: []string{"so:libssl.so.3=3"}},
+	{Name: "glibc", Version: "2.40-r0", Origin: "glibc", Repository: "https://apk.internal.example.com/os", Provides: []string{"so:libc.so.6=6"}},
+}
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy Policy
+		want   []Violation
+	}{{
+		name:   "empty policy",
+		policy: Policy{},
+	}, {
+		name:   "deny by origin",
+		policy: Policy{Deny: []Rule{{Origin: "openssl"}}},
+		want: []Violation{{
+			Package: testPackages[2],
+			Reason:  "denied by origin=openssl",
+			Chain:   []string{"curl", "libcurl-openssl4", "libssl3"},
+		}},
+	}, {
+		name:   "allow by repository",
+		policy: Policy{Allow: []Rule{{Repository: "https://apk.internal.example.com/*"}}},
+		want: []Violation{
+			{Package: testPackages[0], Reason: "not allowed by any rule", Chain: []string{"curl"}},
+			{Package: testPackages[1], Reason: "not allowed by any rule", Chain: []string{"curl", "libcurl-openssl4"}},
+			{Package: testPackages[2], Reason: "not allowed by any rule", Chain: []string{"curl", "libcurl-openssl4", "libssl3"}},
+		},
+	}, {
+		name: "deny wins over allow",
+		policy: Policy{
+			Allow: []Rule{{Name: "*"}},
+			Deny:  []Rule{{Name: "lib?url-*", Origin: "curl"}},
+		},
+		want: []Violation{{
+			Package: testPackages[1],
+			Reason:  "denied by name=lib?url-*,origin=curl",
+			Chain:   []string{"curl", "libcurl-openssl4"},
+		}},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := tc.policy.Check(testPackages)
+			if tc.want == nil {
+				require.NoError(t, err)
+				return
+			}
+			var v Violations
+			require.True(t, errors.As(err, &v))
+			require.Equal(t, Violations(tc.want), v)
+		})
+	}
+}
+
+func TestViolationsError(t *testing.T) {
+	_, err := (&Policy{Deny: []Rule{{Name: "glibc"}, {Origin: "openssl"}}}).Check(testPackages)
+	require.EqualError(t, err, `2 package(s) not allowed by the package policy:
+  libssl3-3.4.0-r0: denied by origin=openssl (curl -> libcurl-openssl4 -> libssl3)
+  glibc-2.40-r0: denied by name=glibc (curl -> glibc)`)
+}
+
+func TestAllowsScriptlets(t *testing.T) {
+	var unset *Policy
+	require.True(t, unset.AllowsScriptlets(testPackages[0]))
+	require.True(t, (&Policy{}).AllowsScriptlets(testPackages[0]))
+
+	p := &Policy{Scriptlets: &ScriptletPolicy{
+		Allow: []Rule{{Origin: "curl"}, {Name: "glibc"}},
+		Deny:  []Rule{{Name: "libcurl-*"}},
+	}}
+	var allowed []string
+	for _, pkg := range testPackages {
+		if p.AllowsScriptlets(pkg) {
+			allowed = append(allowed, pkg.Name)
+		}
+	}
+	require.Equal(t, []string{"curl", "glibc"}, allowed)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`allow:
+  - repository: https://packages.wolfi.dev/os
+deny:
+  - name: openssl
+  - origin: gnutls
+`), 0o644))
+	p, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, &Policy{
+		Allow: []Rule{{Repository: "https://packages.wolfi.dev/os"}},
+		Deny:  []Rule{{Name: "openssl"}, {Origin: "gnutls"}},
+	}, p)
+
+	require.NoError(t, os.WriteFile(path, []byte("deny:\n  - package: openssl\n"), 0o644))
+	_, err = Load(path)
+	require.ErrorContains(t, err, "field package not found")
+}
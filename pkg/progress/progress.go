@@ -0,0 +1,137 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package progress defines a pluggable interface for reporting the progress
+// of a build. A Reporter is carried on the context, the same way loggers are,
+// so any part of apko can report progress without plumbing it through.
+package progress
+
+import (
+	"context"
+	"io"
+)
+
+// Phase identifies which part of a build an Event belongs to.
+type Phase string
+
+const (
+	// PhaseResolve is emitted when resolving the packages to install.
+	PhaseResolve Phase = "resolve"
+	// PhaseDownload is emitted while package bytes are fetched.
+	PhaseDownload Phase = "download"
+	// PhaseInstall is emitted as each package is installed.
+	PhaseInstall Phase = "install"
+	// PhaseLayer is emitted when a layer has been built.
+	PhaseLayer Phase = "layer"
+	// PhasePublish is emitted while image bytes are uploaded.
+	PhasePublish Phase = "publish"
+)
+
+// Event describes progress within a Phase.
+type Event struct {
+	Phase Phase
+	// Arch is the architecture being built, if known.
+	Arch string
+	// Name identifies what is progressing, e.g. a package name or image reference.
+	Name string
+	// Current and Total count bytes for download, layer and publish events,
+	// and packages for resolve and install events. Total is 0 when unknown.
+	Current int64
+	Total   int64
+	// Done is set on the final event for Name.
+	Done bool
+}
+
+// Reporter receives progress events. Implementations must be safe for
+// concurrent use, since multiple architectures are built in parallel.
+type Reporter interface {
+	Report(Event)
+}
+
+// ReporterFunc adapts a function to a Reporter.
+type ReporterFunc func(Event)
+
+// Report implements Reporter.
+func (f ReporterFunc) Report(e Event) { f(e) }
+
+type nopReporter struct{}
+
+func (nopReporter) Report(Event) {}
+
+type contextKey struct{}
+
+// WithReporter returns a context that carries r.
+func WithReporter(ctx context.Context, r Reporter) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the Reporter carried by ctx, or one that discards
+// events if there is none.
+func FromContext(ctx context.Context) Reporter {
+	if r, ok := ctx.Value(contextKey{}).(Reporter); ok && r != nil {
+		return r
+	}
+	return nopReporter{}
+}
+
+// Enabled reports whether ctx carries a Reporter.
+func Enabled(ctx context.Context) bool {
+	_, ok := ctx.Value(contextKey{}).(Reporter)
+	return ok
+}
+
+// Report sends e to the Reporter carried by ctx.
+func Report(ctx context.Context, e Event) {
+	FromContext(ctx).Report(e)
+}
+
+// NewReader wraps rc so that reading from it reports byte counts as e,
+// with a final Done event on EOF or Close.
+func NewReader(ctx context.Context, rc io.ReadCloser, e Event) io.ReadCloser {
+	if !Enabled(ctx) {
+		return rc
+	}
+	return &reader{rc: rc, r: FromContext(ctx), e: e}
+}
+
+type reader struct {
+	rc io.ReadCloser
+	r  Reporter
+	e  Event
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	if n > 0 {
+		r.e.Current += int64(n)
+		r.r.Report(r.e)
+	}
+	if err == io.EOF {
+		r.done()
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	r.done()
+	return r.rc.Close()
+}
+
+func (r *reader) done() {
+	if r.e.Done {
+		return
+	}
+	r.e.Done = true
+	r.r.Report(r.e)
+}
@@ -0,0 +1,64 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package progress
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	var events []Event
+	ctx := WithReporter(context.Background(), ReporterFunc(func(e Event) {
+		events = append(events, e)
+	}))
+
+	rc := NewReader(ctx, io.NopCloser(strings.NewReader("hello world")), Event{Phase: PhaseDownload, Name: "foo", Total: 11})
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(b))
+	require.NoError(t, rc.Close())
+
+	require.NotEmpty(t, events)
+	last := events[len(events)-1]
+	require.True(t, last.Done)
+	require.Equal(t, int64(11), last.Current)
+	require.Equal(t, "foo", last.Name)
+
+	// Only one Done event, even though both EOF and Close were hit.
+	done := 0
+	for _, e := range events {
+		if e.Done {
+			done++
+		}
+	}
+	require.Equal(t, 1, done)
+}
+
+func TestNoReporter(t *testing.T) {
+	ctx := context.Background()
+	require.False(t, Enabled(ctx))
+
+	// Without a reporter, readers are passed through untouched.
+	rc := io.NopCloser(strings.NewReader(""))
+	require.Equal(t, rc, NewReader(ctx, rc, Event{}))
+
+	// And reporting is a no-op.
+	Report(ctx, Event{Phase: PhaseInstall})
+}
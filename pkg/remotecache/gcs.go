@@ -0,0 +1,73 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotecache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// gcsEndpoint serves the XML API of GCS.
+	gcsEndpoint = "https://storage.googleapis.com/"
+	// gcsScope is the OAuth scope to read and write GCS objects.
+	gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// newGCS returns the cache of the objects under prefix of a GCS bucket,
+// accessed with the Application Default Credentials of the environment.
+func newGCS(name, prefix string) (*bucket, error) {
+	if name == "" {
+		return nil, fmt.Errorf("the GCS remote cache needs a bucket, as in gs://bucket/prefix")
+	}
+	tokens := sync.OnceValue(func() oauth2.TokenSource {
+		creds, err := google.FindDefaultCredentials(context.Background(), gcsScope)
+		if err != nil {
+			// Public buckets can still be read anonymously.
+			return nil
+		}
+		return creds.TokenSource
+	})
+	return gcsBucket(gcsEndpoint, name, prefix, tokens), nil
+}
+
+// gcsBucket returns the cache of the objects under prefix of a bucket served
+// at endpoint, authorized with the tokens of the source tokens returns, if
+// any.
+func gcsBucket(endpoint, name, prefix string, tokens func() oauth2.TokenSource) *bucket {
+	return &bucket{
+		client: http.DefaultClient,
+		objectURL: func(key string) string {
+			return endpoint + name + "/" + escapeKey(objectKey(prefix, key))
+		},
+		authorize: func(req *http.Request) error {
+			ts := tokens()
+			if ts == nil {
+				return nil
+			}
+			token, err := ts.Token()
+			if err != nil {
+				return fmt.Errorf("getting Google credentials: %w", err)
+			}
+			token.SetAuthHeader(req)
+			return nil
+		},
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
+
+	"chainguard.dev/apko/pkg/build/oci"
+	"chainguard.dev/apko/pkg/keychain"
+)
+
+// ociCacheArtifactType is the artifact type of the images of cached files.
+const ociCacheArtifactType = "application/vnd.dev.chainguard.apko.cache.v1"
+
+// ociCache is a remote cache of the images of a repository, which each have the
+// file of a key as their single layer, tagged with the hash of the key.
+type ociCache struct {
+	repo name.Repository
+	opts []remote.Option
+}
+
+func newOCI(repo string, opts ...remote.Option) (*ociCache, error) {
+	r, err := name.NewRepository(repo)
+	if err != nil {
+		return nil, fmt.Errorf("parsing remote cache repository %q: %w", repo, err)
+	}
+	if len(opts) == 0 {
+		opts = []remote.Option{
+			remote.WithAuthFromKeychain(keychain.Default),
+			remote.WithTransport(oci.RateLimitTransport(remote.DefaultTransport)),
+		}
+	}
+	return &ociCache{repo: r, opts: opts}, nil
+}
+
+// tag returns the tag of the image of key, as keys are not valid tags.
+func (c *ociCache) tag(key string) name.Tag {
+	h := sha256.Sum256([]byte(key))
+	return c.repo.Tag(hex.EncodeToString(h[:]))
+}
+
+func (c *ociCache) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	img, err := remote.Image(c.tag(key), append(c.opts, remote.WithContext(ctx))...)
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) != 1 {
+		return nil, fmt.Errorf("%s: cached image has %d layers, not 1", key, len(layers))
+	}
+	return layers[0].Compressed()
+}
+
+func (c *ociCache) Put(ctx context.Context, key, path string) error {
+	l, err := newFileLayer(path)
+	if err != nil {
+		return err
+	}
+	img, err := mutate.Append(empty.Image, mutate.Addendum{Layer: l})
+	if err != nil {
+		return err
+	}
+	img = mutate.MediaType(img, ggcrtypes.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, ociCacheArtifactType)
+	img = mutate.Annotations(img, map[string]string{"org.opencontainers.image.title": key}).(v1.Image)
+	return remote.Write(c.tag(key), oci.WithArtifactType(img, ociCacheArtifactType), append(c.opts, remote.WithContext(ctx))...)
+}
+
+// fileLayer is a layer of the contents of a file, as they are.
+type fileLayer struct {
+	path string
+	hash v1.Hash
+	size int64
+}
+
+func newFileLayer(path string) (*fileLayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLayer{
+		path: path,
+		hash: v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(h.Sum(nil))},
+		size: size,
+	}, nil
+}
+
+func (l *fileLayer) Digest() (v1.Hash, error)                { return l.hash, nil }
+func (l *fileLayer) DiffID() (v1.Hash, error)                { return l.hash, nil }
+func (l *fileLayer) Compressed() (io.ReadCloser, error)      { return os.Open(l.path) }
+func (l *fileLayer) Uncompressed() (io.ReadCloser, error)    { return os.Open(l.path) }
+func (l *fileLayer) Size() (int64, error)                    { return l.size, nil }
+func (l *fileLayer) MediaType() (ggcrtypes.MediaType, error) { return "application/octet-stream", nil }
@@ -0,0 +1,161 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package remotecache implements apk.RemoteCache with S3 and GCS buckets and
+// OCI registries, so that ephemeral CI runners share the packages and indexes
+// they download.
+package remotecache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// New returns the remote cache of uri, one of:
+//
+//   - s3://bucket/prefix, an S3 bucket, with the region of the region query
+//     parameter or else of AWS_REGION, and the endpoint of the endpoint query
+//     parameter for S3-compatible storage, which is addressed in path style,
+//   - gs://bucket/prefix, a GCS bucket,
+//   - oci://registry/repository, a repository that stores each cached file as
+//     an image tagged with the hash of its key.
+//
+// Buckets are accessed with the ambient credentials of the environment, as
+// registries are with the keychain of apko, or anonymously if there are none.
+func New(uri string) (apk.RemoteCache, error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, fmt.Errorf("remote cache %q is not a URL, such as s3://bucket/prefix", uri)
+	}
+	switch scheme {
+	case "s3":
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("parsing remote cache %q: %w", uri, err)
+		}
+		return newS3(u.Host, strings.Trim(u.Path, "/"), u.Query().Get("region"), u.Query().Get("endpoint"))
+	case "gs":
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, fmt.Errorf("parsing remote cache %q: %w", uri, err)
+		}
+		return newGCS(u.Host, strings.Trim(u.Path, "/"))
+	case "oci":
+		return newOCI(rest)
+	}
+	return nil, fmt.Errorf("unsupported remote cache %q: the scheme must be s3, gs or oci", uri)
+}
+
+// bucket is a remote cache of the objects of an object storage bucket, which
+// are read and written with plain GET and PUT requests.
+type bucket struct {
+	client *http.Client
+	// objectURL returns the URL of the object of key.
+	objectURL func(key string) string
+	// authorize authorizes req.
+	authorize func(req *http.Request) error
+}
+
+func (b *bucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+	return nil, statusError(req, resp)
+}
+
+func (b *bucket) Put(ctx context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, b.objectURL(key), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := b.do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return statusError(req, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *bucket) do(req *http.Request) (*http.Response, error) {
+	if err := b.authorize(req); err != nil {
+		return nil, err
+	}
+	return b.client.Do(req)
+}
+
+// statusError returns the error of an unexpected response to req, and closes
+// its body.
+func statusError(req *http.Request, resp *http.Response) error {
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(body)))
+}
+
+// escapeKey escapes each segment of key as object storage signs them: all but
+// the unreserved characters.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// objectKey returns the key of an object under prefix.
+func objectKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
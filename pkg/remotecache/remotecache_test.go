@@ -0,0 +1,142 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotecache
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+)
+
+// objectStore serves the objects PUT to it, recording the authorization of
+// each request.
+type objectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	auth    []string
+}
+
+func (s *objectStore) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.auth = append(s.auth, req.Header.Get("Authorization"))
+	switch req.Method {
+	case http.MethodGet:
+		b, ok := s.objects[req.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(b) //nolint:errcheck
+	case http.MethodPut:
+		b, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[req.URL.EscapedPath()] = b
+	}
+}
+
+// testRemoteCache checks that rc misses key, and then has it once it is put.
+func testRemoteCache(t *testing.T, rc apk.RemoteCache, key string) {
+	t.Helper()
+	ctx := context.Background()
+
+	_, err := rc.Get(ctx, key)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("contents"), 0o644))
+	require.NoError(t, rc.Put(ctx, key, path))
+
+	r, err := rc.Get(ctx, key)
+	require.NoError(t, err)
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, "contents", string(b))
+}
+
+const testKey = "packages.wolfi.dev/os/x86_64/libstdc++-15.1.0-r0.apk"
+
+func TestS3(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	store := &objectStore{objects: map[string][]byte{}}
+	s := httptest.NewServer(store)
+	defer s.Close()
+
+	rc, err := New("s3://bucket/cache?region=eu-test-1&endpoint=" + s.URL)
+	require.NoError(t, err)
+	testRemoteCache(t, rc, testKey)
+
+	require.Contains(t, store.objects, "/bucket/cache/packages.wolfi.dev/os/x86_64/libstdc%2B%2B-15.1.0-r0.apk")
+	for _, auth := range store.auth {
+		require.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"), auth)
+		require.Contains(t, auth, "/eu-test-1/s3/aws4_request")
+	}
+}
+
+func TestGCS(t *testing.T) {
+	store := &objectStore{objects: map[string][]byte{}}
+	s := httptest.NewServer(store)
+	defer s.Close()
+
+	rc := gcsBucket(s.URL+"/", "bucket", "", func() oauth2.TokenSource {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	})
+	testRemoteCache(t, rc, testKey)
+
+	require.Contains(t, store.objects, "/bucket/packages.wolfi.dev/os/x86_64/libstdc%2B%2B-15.1.0-r0.apk")
+	for _, auth := range store.auth {
+		require.Equal(t, "Bearer token", auth)
+	}
+}
+
+func TestOCI(t *testing.T) {
+	s := httptest.NewServer(registry.New())
+	defer s.Close()
+
+	rc, err := New("oci://" + strings.TrimPrefix(s.URL, "http://") + "/apko/cache")
+	require.NoError(t, err)
+	testRemoteCache(t, rc, testKey)
+}
+
+func TestNew(t *testing.T) {
+	for _, uri := range []string{
+		"bucket/prefix",
+		"ftp://host/path",
+		"s3:///prefix",
+		"gs://",
+		"oci://Not A Repository",
+	} {
+		_, err := New(uri)
+		require.Error(t, err, uri)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package remotecache
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"chainguard.dev/apko/pkg/keychain"
+)
+
+// defaultAWSRegion is the region of S3 buckets when none is configured.
+const defaultAWSRegion = "us-east-1"
+
+// newS3 returns the cache of the objects under prefix of an S3 bucket in
+// region, at endpoint if it is not empty.
+func newS3(name, prefix, region, endpoint string) (*bucket, error) {
+	if name == "" {
+		return nil, fmt.Errorf("the S3 remote cache needs a bucket, as in s3://bucket/prefix")
+	}
+	for _, r := range []string{region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), defaultAWSRegion} {
+		if r != "" {
+			region = r
+			break
+		}
+	}
+	base := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", name, region)
+	if strings.HasPrefix(region, "cn-") {
+		base = fmt.Sprintf("https://%s.s3.%s.amazonaws.com.cn/", name, region)
+	}
+	if endpoint != "" {
+		base = strings.TrimSuffix(endpoint, "/") + "/" + name + "/"
+	}
+	return &bucket{
+		client: http.DefaultClient,
+		objectURL: func(key string) string {
+			return base + escapeKey(objectKey(prefix, key))
+		},
+		authorize: func(req *http.Request) error {
+			return keychain.SignAWSRequest(req.Context(), req, "s3", region)
+		},
+	}, nil
+}
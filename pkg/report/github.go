@@ -0,0 +1,134 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteGitHubOutputs writes the results of the build as GitHub Actions step
+// outputs, in the format of the $GITHUB_OUTPUT file:
+//
+//   - digest: the digest of the image index
+//   - digest-<arch>: the digest of the image of each architecture
+//   - tags: the tags of the image, one per line
+//   - references: the published image references, one per line
+//   - packages: a JSON object mapping each architecture to the installed
+//     packages and their versions
+//   - error: the error the build failed with, if any
+func (r *Report) WriteGitHubOutputs(w io.Writer, tags []string) error {
+	var b strings.Builder
+	output := func(name, value string) {
+		if !strings.Contains(value, "\n") {
+			fmt.Fprintf(&b, "%s=%s\n", name, value)
+			return
+		}
+		delim := githubDelimiter()
+		fmt.Fprintf(&b, "%s<<%s\n%s\n%s\n", name, delim, value, delim)
+	}
+
+	if r.Index != "" {
+		output("digest", r.Index)
+	}
+	packages := map[string]map[string]string{}
+	for _, img := range r.Images {
+		if img.Digest != "" {
+			output("digest-"+img.Arch, img.Digest)
+		}
+		versions := map[string]string{}
+		for _, p := range img.Packages {
+			versions[p.Name] = p.Version
+		}
+		packages[img.Arch] = versions
+	}
+	if len(tags) != 0 {
+		output("tags", strings.Join(tags, "\n"))
+	}
+	if len(r.References) != 0 {
+		output("references", strings.Join(r.References, "\n"))
+	}
+	pj, err := json.Marshal(packages)
+	if err != nil {
+		return err
+	}
+	output("packages", string(pj))
+	if r.Error != "" {
+		output("error", r.Error)
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// githubDelimiter returns a random delimiter for a multiline output, so that
+// it cannot appear in the value.
+func githubDelimiter() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "ghadelimiter_" + hex.EncodeToString(b)
+}
+
+// WriteGitHubSummary writes a Markdown summary of the build, for the
+// $GITHUB_STEP_SUMMARY file: the images and their digests, the tags and
+// references, the warnings, and the installed packages of each architecture.
+func (r *Report) WriteGitHubSummary(w io.Writer, tags []string) error {
+	var b strings.Builder
+	b.WriteString("### apko\n\n")
+	if r.Error != "" {
+		fmt.Fprintf(&b, "The build failed:\n\n```\n%s\n```\n\n", r.Error)
+	}
+	if r.Index != "" {
+		fmt.Fprintf(&b, "Index: `%s`\n\n", r.Index)
+	}
+	if len(r.Images) != 0 {
+		b.WriteString("| Architecture | Digest | Packages |\n| --- | --- | --- |\n")
+		for _, img := range r.Images {
+			fmt.Fprintf(&b, "| %s | `%s` | %d |\n", img.Arch, img.Digest, len(img.Packages))
+		}
+		b.WriteString("\n")
+	}
+	list := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "%s:\n\n", title)
+		for _, item := range items {
+			fmt.Fprintf(&b, "- `%s`\n", item)
+		}
+		b.WriteString("\n")
+	}
+	list("Tags", tags)
+	list("References", r.References)
+	list("Warnings", r.Warnings)
+	for _, img := range r.Images {
+		if len(img.Packages) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "<details><summary>Packages (%s)</summary>\n\n", img.Arch)
+		b.WriteString("| Package | Version | License |\n| --- | --- | --- |\n")
+		for _, p := range img.Packages {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", p.Name, p.Version, strings.ReplaceAll(p.License, "|", `\|`))
+		}
+		b.WriteString("\n</details>\n\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,92 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testReport = &Report{
+	Index: "sha256:index",
+	Images: []Image{
+		{Arch: "aarch64", Digest: "sha256:arm", Packages: []Package{{Name: "busybox", Version: "1.37.0-r1", License: "GPL-2.0-only"}}},
+		{Arch: "x86_64", Digest: "sha256:amd", Packages: []Package{{Name: "busybox", Version: "1.37.0-r0", License: "GPL-2.0-only"}, {Name: "tzdata", Version: "2025a-r0", License: "Public-Domain OR BSD-3-Clause"}}},
+	},
+	References: []string{"example.com/app:latest@sha256:index", "example.com/app:v1@sha256:index"},
+	Warnings:   []string{"something happened"},
+}
+
+// parseGitHubOutputs parses the $GITHUB_OUTPUT format.
+func parseGitHubOutputs(t *testing.T, s string) map[string]string {
+	out := map[string]string{}
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := sc.Text()
+		if name, value, ok := strings.Cut(line, "="); ok && !strings.Contains(name, "<<") {
+			out[name] = value
+			continue
+		}
+		name, delim, ok := strings.Cut(line, "<<")
+		require.True(t, ok, line)
+		var lines []string
+		for sc.Scan() && sc.Text() != delim {
+			lines = append(lines, sc.Text())
+		}
+		out[name] = strings.Join(lines, "\n")
+	}
+	return out
+}
+
+func TestWriteGitHubOutputs(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, testReport.WriteGitHubOutputs(&b, []string{"example.com/app:latest", "example.com/app:v1"}))
+	require.Equal(t, map[string]string{
+		"digest":         "sha256:index",
+		"digest-aarch64": "sha256:arm",
+		"digest-x86_64":  "sha256:amd",
+		"tags":           "example.com/app:latest\nexample.com/app:v1",
+		"references":     "example.com/app:latest@sha256:index\nexample.com/app:v1@sha256:index",
+		"packages":       `{"aarch64":{"busybox":"1.37.0-r1"},"x86_64":{"busybox":"1.37.0-r0","tzdata":"2025a-r0"}}`,
+	}, parseGitHubOutputs(t, b.String()))
+
+	b.Reset()
+	require.NoError(t, (&Report{Error: "building image:\nno space left on device"}).WriteGitHubOutputs(&b, nil))
+	require.Equal(t, map[string]string{
+		"packages": "{}",
+		"error":    "building image:\nno space left on device",
+	}, parseGitHubOutputs(t, b.String()))
+}
+
+func TestWriteGitHubSummary(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, testReport.WriteGitHubSummary(&b, []string{"example.com/app:latest"}))
+	s := b.String()
+	require.Contains(t, s, "Index: `sha256:index`")
+	require.Contains(t, s, "| x86_64 | `sha256:amd` | 2 |")
+	require.Contains(t, s, "Tags:\n\n- `example.com/app:latest`")
+	require.Contains(t, s, "- `example.com/app:v1@sha256:index`")
+	require.Contains(t, s, "Warnings:\n\n- `something happened`")
+	require.Contains(t, s, "<details><summary>Packages (aarch64)</summary>")
+	require.Contains(t, s, "| tzdata | 2025a-r0 | Public-Domain OR BSD-3-Clause |")
+	require.NotContains(t, s, "failed")
+
+	b.Reset()
+	require.NoError(t, (&Report{Error: "no space left on device"}).WriteGitHubSummary(&b, nil))
+	require.Equal(t, "### apko\n\nThe build failed:\n\n```\nno space left on device\n```\n\n", b.String())
+}
@@ -0,0 +1,389 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package report collects a machine-readable report of a build: the digests
+// of the images and their layers, the installed packages, timings, cache
+// statistics and warnings. A Collector is carried on the context, the same way
+// progress reporters are, so any part of apko can contribute to the report
+// without plumbing it through.
+package report
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Caches tracked in Report.Cache.
+const (
+	// CachePackages counts packages found in, or missing from, the package cache.
+	CachePackages = "packages"
+	// CacheLayers counts builds whose layers were found in, or missing from,
+	// the layer cache.
+	CacheLayers = "layers"
+)
+
+// Phases of the build timed in Report.Timings, in the order they run.
+const (
+	// PhaseResolve is resolving the packages to install.
+	PhaseResolve = "resolve"
+	// PhaseDownload is fetching package files.
+	PhaseDownload = "download"
+	// PhaseExtract is expanding the fetched packages.
+	PhaseExtract = "extract"
+	// PhaseTar is writing the layer tarballs.
+	PhaseTar = "tar"
+	// PhaseBuild is building the layers of an image, which includes the
+	// phases above.
+	PhaseBuild = "build"
+	// PhaseCompress is compressing the layers.
+	PhaseCompress = "compress"
+	// PhaseSBOM is generating SBOMs.
+	PhaseSBOM = "sbom"
+	// PhasePublish is pushing the images to the registry.
+	PhasePublish = "publish"
+)
+
+// Report is the machine-readable report of a build.
+type Report struct {
+	// Index is the digest of the multi-architecture image index.
+	Index string `json:"index,omitempty"`
+	// Images are the per-architecture images, sorted by architecture.
+	Images []Image `json:"images"`
+	// References are the image references that were published, if any.
+	References []string `json:"references,omitempty"`
+	// Seconds is the duration of the whole build.
+	Seconds float64 `json:"seconds"`
+	// Timings are the durations of the phases of the build, in the order
+	// they first finished.
+	Timings []Timing `json:"timings"`
+	// Cache holds the hit and miss counts of each cache, by cache name.
+	Cache map[string]CacheStats `json:"cache"`
+	// Warnings are the warnings logged during the build.
+	Warnings []string `json:"warnings"`
+	// Error is the error the build failed with, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Image describes the image built for one architecture.
+type Image struct {
+	Arch     string    `json:"arch"`
+	Platform string    `json:"platform,omitempty"`
+	Digest   string    `json:"digest,omitempty"`
+	Layers   []Layer   `json:"layers,omitempty"`
+	Packages []Package `json:"packages,omitempty"`
+	// SkippedScriptlets are the install scriptlets that were not run, as
+	// "<name>-<version>.<scriptlet>", when scriptlets are run.
+	SkippedScriptlets []string `json:"skippedScriptlets,omitempty"`
+	// StrippedSetuid are the files whose setuid and setgid bits were
+	// cleared, when the image strips them.
+	StrippedSetuid []string `json:"strippedSetuid,omitempty"`
+	// ExcludedPaths are the paths removed from the image by its
+	// exclude-paths.
+	ExcludedPaths []string `json:"excludedPaths,omitempty"`
+}
+
+// Layer describes a layer of an image.
+type Layer struct {
+	Digest string `json:"digest"`
+	DiffID string `json:"diffID"`
+	Size   int64  `json:"size"`
+}
+
+// Package describes an installed package.
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Origin  string `json:"origin,omitempty"`
+	// License is the SPDX license expression of the package.
+	License string `json:"license,omitempty"`
+	// Size is the size of the package file.
+	Size uint64 `json:"size"`
+	// InstalledSize is the size of the files the package installs.
+	InstalledSize uint64 `json:"installedSize"`
+}
+
+// Timing is the duration of a phase of the build.
+type Timing struct {
+	Phase string `json:"phase"`
+	// Arch is the architecture the phase ran for, if it is per-architecture.
+	Arch string `json:"arch,omitempty"`
+	// Seconds is the time spent in the phase. Phases that run once per
+	// package, such as downloads, add up the time spent on each package,
+	// even though packages are processed in parallel.
+	Seconds float64 `json:"seconds"`
+	// Count is the number of times the phase ran.
+	Count int `json:"count"`
+}
+
+// CacheStats counts the hits and misses of a cache.
+type CacheStats struct {
+	Hits   int `json:"hits"`
+	Misses int `json:"misses"`
+}
+
+// Collector accumulates a Report. It is safe for concurrent use, since
+// multiple architectures are built in parallel. The methods of a nil
+// Collector do nothing, so callers need not check whether a report was
+// requested.
+type Collector struct {
+	mu         sync.Mutex
+	start      time.Time
+	index      string
+	images     map[string]*Image
+	references []string
+	timings    []Timing
+	cache      map[string]CacheStats
+	warnings   []string
+}
+
+// New returns a Collector for a build starting now.
+func New() *Collector {
+	return &Collector{
+		start:  time.Now(),
+		images: map[string]*Image{},
+		cache:  map[string]CacheStats{},
+	}
+}
+
+type contextKey struct{}
+
+// WithCollector returns a context that carries c.
+func WithCollector(ctx context.Context, c *Collector) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the Collector carried by ctx, or nil if there is none.
+func FromContext(ctx context.Context) *Collector {
+	c, _ := ctx.Value(contextKey{}).(*Collector)
+	return c
+}
+
+func (c *Collector) image(arch string) *Image {
+	img, ok := c.images[arch]
+	if !ok {
+		img = &Image{Arch: arch}
+		c.images[arch] = img
+	}
+	return img
+}
+
+// AddImage records the digest, platform and layers of the image built for arch.
+func (c *Collector) AddImage(arch string, img v1.Image) error {
+	if c == nil {
+		return nil
+	}
+	dig, err := img.Digest()
+	if err != nil {
+		return fmt.Errorf("getting image digest: %w", err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("getting image config: %w", err)
+	}
+	ls, err := img.Layers()
+	if err != nil {
+		return fmt.Errorf("getting image layers: %w", err)
+	}
+	layers := make([]Layer, 0, len(ls))
+	for _, l := range ls {
+		d, err := l.Digest()
+		if err != nil {
+			return fmt.Errorf("getting layer digest: %w", err)
+		}
+		diffID, err := l.DiffID()
+		if err != nil {
+			return fmt.Errorf("getting layer diffid: %w", err)
+		}
+		size, err := l.Size()
+		if err != nil {
+			return fmt.Errorf("getting layer size: %w", err)
+		}
+		layers = append(layers, Layer{Digest: d.String(), DiffID: diffID.String(), Size: size})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := c.image(arch)
+	i.Digest = dig.String()
+	if p := cfg.Platform(); p != nil {
+		i.Platform = p.String()
+	}
+	i.Layers = layers
+	return nil
+}
+
+// AddPackages records the packages installed in the image built for arch.
+func (c *Collector) AddPackages(arch string, pkgs []Package) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.image(arch).Packages = pkgs
+}
+
+// AddSkippedScriptlets records the install scriptlets that were not run in the
+// image built for arch.
+func (c *Collector) AddSkippedScriptlets(arch string, scriptlets []string) {
+	if c == nil || len(scriptlets) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.image(arch).SkippedScriptlets = scriptlets
+}
+
+// AddStrippedSetuid records the files whose setuid and setgid bits were
+// cleared in the image built for arch.
+func (c *Collector) AddStrippedSetuid(arch string, paths []string) {
+	if c == nil || len(paths) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.image(arch).StrippedSetuid = paths
+}
+
+// AddExcludedPaths records the paths removed from the image built for arch.
+func (c *Collector) AddExcludedPaths(arch string, paths []string) {
+	if c == nil || len(paths) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.image(arch).ExcludedPaths = paths
+}
+
+// SetIndex records the digest of the image index.
+func (c *Collector) SetIndex(dig string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index = dig
+}
+
+// AddReferences records image references that were published.
+func (c *Collector) AddReferences(refs ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.references = append(c.references, refs...)
+}
+
+// Time starts timing phase, for arch if it is not empty, and returns a
+// function that records its duration when called.
+func (c *Collector) Time(phase, arch string) func() {
+	if c == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { c.Add(phase, arch, time.Since(start)) }
+}
+
+// Add records d spent in phase, for arch if it is not empty.
+func (c *Collector) Add(phase, arch string, d time.Duration) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.timings {
+		if t := &c.timings[i]; t.Phase == phase && t.Arch == arch {
+			t.Seconds += d.Seconds()
+			t.Count++
+			return
+		}
+	}
+	c.timings = append(c.timings, Timing{Phase: phase, Arch: arch, Seconds: d.Seconds(), Count: 1})
+}
+
+// CacheHit records a hit in the named cache.
+func (c *Collector) CacheHit(cache string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.cache[cache]
+	s.Hits++
+	c.cache[cache] = s
+}
+
+// CacheMiss records a miss in the named cache.
+func (c *Collector) CacheMiss(cache string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.cache[cache]
+	s.Misses++
+	c.cache[cache] = s
+}
+
+// Warn records a warning.
+func (c *Collector) Warn(msg string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warnings = append(c.warnings, msg)
+}
+
+// Report returns the report collected so far.
+func (c *Collector) Report() *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &Report{
+		Index:      c.index,
+		Images:     []Image{},
+		References: slices.Clone(c.references),
+		Seconds:    time.Since(c.start).Seconds(),
+		Timings:    append([]Timing{}, c.timings...),
+		Cache:      maps.Clone(c.cache),
+		Warnings:   append([]string{}, c.warnings...),
+	}
+	for _, img := range c.images {
+		r.Images = append(r.Images, *img)
+	}
+	slices.SortFunc(r.Images, func(a, b Image) int { return strings.Compare(a.Arch, b.Arch) })
+	return r
+}
+
+// Write writes the report as JSON to path.
+func (r *Report) Write(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling report: %w", err)
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilCollector(t *testing.T) {
+	c := FromContext(context.Background())
+	require.Nil(t, c)
+
+	// None of these may panic.
+	require.NoError(t, c.AddImage("x86_64", nil))
+	c.AddPackages("x86_64", nil)
+	c.SetIndex("sha256:abc")
+	c.AddReferences("example.com/app")
+	c.Time("build", "x86_64")()
+	c.CacheHit(CachePackages)
+	c.CacheMiss(CachePackages)
+	c.Warn("warning")
+}
+
+func TestCollector(t *testing.T) {
+	c := New()
+	ctx := WithCollector(context.Background(), c)
+	require.Same(t, c, FromContext(ctx))
+
+	img, err := random.Image(1024, 2)
+	require.NoError(t, err)
+	require.NoError(t, c.AddImage("x86_64", img))
+	c.AddPackages("x86_64", []Package{{Name: "busybox", Version: "1.37.0-r0", Size: 10, InstalledSize: 20}})
+	c.AddPackages("aarch64", []Package{{Name: "busybox", Version: "1.37.0-r1"}})
+	c.AddSkippedScriptlets("x86_64", []string{"busybox-1.37.0-r0.post-install"})
+	c.AddSkippedScriptlets("aarch64", nil)
+	c.SetIndex("sha256:abc")
+	c.AddReferences("example.com/app@sha256:abc")
+	c.Time("build", "x86_64")()
+	c.CacheHit(CacheLayers)
+	c.CacheMiss(CachePackages)
+	c.CacheMiss(CachePackages)
+	c.Warn("careful")
+
+	r := c.Report()
+	require.Equal(t, "sha256:abc", r.Index)
+	require.Equal(t, []string{"example.com/app@sha256:abc"}, r.References)
+	require.Len(t, r.Images, 2)
+	require.Equal(t, "aarch64", r.Images[0].Arch)
+	require.Equal(t, "x86_64", r.Images[1].Arch)
+
+	dig, err := img.Digest()
+	require.NoError(t, err)
+	require.Equal(t, dig.String(), r.Images[1].Digest)
+	require.Len(t, r.Images[1].Layers, 2)
+	layers, err := img.Layers()
+	require.NoError(t, err)
+	diffID, err := layers[0].DiffID()
+	require.NoError(t, err)
+	require.Equal(t, diffID.String(), r.Images[1].Layers[0].DiffID)
+	require.Equal(t, "busybox", r.Images[1].Packages[0].Name)
+	require.Equal(t, []string{"busybox-1.37.0-r0.post-install"}, r.Images[1].SkippedScriptlets)
+	require.Empty(t, r.Images[0].SkippedScriptlets)
+
+	require.Len(t, r.Timings, 1)
+	require.Equal(t, PhaseBuild, r.Timings[0].Phase)
+	require.Equal(t, 1, r.Timings[0].Count)
+	require.Equal(t, map[string]CacheStats{
+		CacheLayers:   {Hits: 1},
+		CachePackages: {Misses: 2},
+	}, r.Cache)
+	require.Equal(t, []string{"careful"}, r.Warnings)
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, r.Write(path))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var got Report
+	require.NoError(t, json.Unmarshal(b, &got))
+	require.Equal(t, r.Images, got.Images)
+}
+
+func TestHandler(t *testing.T) {
+	c := New()
+	var buf bytes.Buffer
+	log := slog.New(c.Handler(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelError})))
+
+	log.Info("ignored")
+	log.With("arch", "x86_64").Warn("/etc/group is missing")
+	log.WithGroup("layer").Error("failed", "digest", "sha256:abc")
+
+	require.Equal(t, []string{
+		"/etc/group is missing arch=x86_64",
+		"failed layer.digest=sha256:abc",
+	}, c.Report().Warnings)
+
+	// Only the error reaches the wrapped handler, at its own level.
+	require.NotContains(t, buf.String(), "/etc/group")
+	require.Contains(t, buf.String(), "failed")
+}
+
+func TestWriteSummary(t *testing.T) {
+	c := New()
+	c.Add(PhasePublish, "", 3*time.Second)
+	c.Add(PhaseDownload, "", 500*time.Millisecond)
+	c.Add(PhaseDownload, "", 250*time.Millisecond)
+	c.Add(PhaseBuild, "x86_64", time.Second)
+	c.Add(PhaseBuild, "aarch64", 2*time.Second)
+	c.Add("custom", "", time.Millisecond)
+
+	r := c.Report()
+	require.Len(t, r.Timings, 5)
+	r.Seconds = 5
+
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteSummary(&buf))
+	require.Equal(t, `phase     time   count
+download  750ms  2
+build     3s     2
+publish   3s     1
+custom    1ms    1
+total     5s     
+`, buf.String())
+}
@@ -0,0 +1,71 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package report
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+var phaseOrder = []string{
+	PhaseResolve,
+	PhaseDownload,
+	PhaseExtract,
+	PhaseTar,
+	PhaseBuild,
+	PhaseCompress,
+	PhaseSBOM,
+	PhasePublish,
+}
+
+// WriteSummary writes a table of the time spent in each phase of the build,
+// across all architectures, followed by the duration of the whole build.
+// Phases that run in parallel add up, so they can exceed the total.
+func (r *Report) WriteSummary(w io.Writer) error {
+	type phase struct {
+		name  string
+		d     time.Duration
+		count int
+	}
+	var phases []*phase
+	for _, t := range r.Timings {
+		i := slices.IndexFunc(phases, func(p *phase) bool { return p.name == t.Phase })
+		if i < 0 {
+			phases = append(phases, &phase{name: t.Phase})
+			i = len(phases) - 1
+		}
+		phases[i].d += time.Duration(t.Seconds * float64(time.Second))
+		phases[i].count += t.Count
+	}
+	rank := func(name string) int {
+		if i := slices.Index(phaseOrder, name); i >= 0 {
+			return i
+		}
+		return len(phaseOrder)
+	}
+	slices.SortStableFunc(phases, func(a, b *phase) int { return cmp.Compare(rank(a.name), rank(b.name)) })
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "phase\ttime\tcount")
+	for _, p := range phases {
+		fmt.Fprintf(tw, "%s\t%s\t%d\n", p.name, p.d.Round(time.Millisecond), p.count)
+	}
+	fmt.Fprintf(tw, "total\t%s\t\n", time.Duration(r.Seconds*float64(time.Second)).Round(time.Millisecond))
+	return tw.Flush()
+}
@@ -0,0 +1,383 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reproducible compares a rebuilt image against a published one and
+// reports where they first diverge.
+package reproducible
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"go.opentelemetry.io/otel"
+)
+
+// Divergence describes the first difference found between a published image
+// and its rebuild.
+type Divergence struct {
+	// Platform is the platform of the diverging image, if any.
+	Platform string
+	// Layer is the index of the diverging layer, or -1.
+	Layer int
+	// Path is the diverging file of the layer, if any.
+	Path string
+	// Reason describes the difference.
+	Reason string
+}
+
+func (d *Divergence) Error() string {
+	var where []string
+	if d.Platform != "" {
+		where = append(where, "platform "+d.Platform)
+	}
+	if d.Layer >= 0 {
+		where = append(where, fmt.Sprintf("layer %d", d.Layer))
+	}
+	if d.Path != "" {
+		where = append(where, "file "+d.Path)
+	}
+	if len(where) == 0 {
+		return "image is not reproducible: " + d.Reason
+	}
+	return fmt.Sprintf("image is not reproducible: %s: %s", strings.Join(where, ", "), d.Reason)
+}
+
+// CompareIndex compares the published index want against the rebuilt index
+// got, returning the first Divergence, or nil if they are identical.
+func CompareIndex(ctx context.Context, want, got v1.ImageIndex) (*Divergence, error) {
+	ctx, span := otel.Tracer("apko").Start(ctx, "CompareIndex")
+	defer span.End()
+
+	wd, err := want.Digest()
+	if err != nil {
+		return nil, err
+	}
+	gd, err := got.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if wd == gd {
+		return nil, nil
+	}
+
+	wm, err := want.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("getting published index manifest: %w", err)
+	}
+	gm, err := got.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("getting rebuilt index manifest: %w", err)
+	}
+
+	gotByPlatform := map[string]v1.Descriptor{}
+	for _, desc := range gm.Manifests {
+		gotByPlatform[platformString(desc.Platform)] = desc
+	}
+	for _, wdesc := range wm.Manifests {
+		platform := platformString(wdesc.Platform)
+		gdesc, ok := gotByPlatform[platform]
+		if !ok {
+			return &Divergence{Platform: platform, Layer: -1, Reason: "missing from the rebuild"}, nil
+		}
+		delete(gotByPlatform, platform)
+		if wdesc.Digest == gdesc.Digest {
+			continue
+		}
+		if !wdesc.MediaType.IsImage() || !gdesc.MediaType.IsImage() {
+			return &Divergence{Platform: platform, Layer: -1, Reason: fmt.Sprintf("manifest digest %s, rebuilt %s", wdesc.Digest, gdesc.Digest)}, nil
+		}
+		wimg, err := want.Image(wdesc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("getting published %s image: %w", platform, err)
+		}
+		gimg, err := got.Image(gdesc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("getting rebuilt %s image: %w", platform, err)
+		}
+		d, err := CompareImage(ctx, wimg, gimg)
+		if err != nil || d == nil {
+			return d, err
+		}
+		d.Platform = platform
+		return d, nil
+	}
+	if len(gotByPlatform) != 0 {
+		extra := slices.Sorted(maps.Keys(gotByPlatform))
+		return &Divergence{Platform: extra[0], Layer: -1, Reason: "not in the published index"}, nil
+	}
+
+	// The images are identical, so the index itself differs.
+	if reason := diffJSON("index", wm, gm); reason != "" {
+		return &Divergence{Layer: -1, Reason: reason}, nil
+	}
+	return &Divergence{Layer: -1, Reason: fmt.Sprintf("index digest %s, rebuilt %s", wd, gd)}, nil
+}
+
+// CompareImage compares the published image want against the rebuilt image
+// got, returning the first Divergence, or nil if they are identical.
+func CompareImage(ctx context.Context, want, got v1.Image) (*Divergence, error) {
+	_, span := otel.Tracer("apko").Start(ctx, "CompareImage")
+	defer span.End()
+
+	wd, err := want.Digest()
+	if err != nil {
+		return nil, err
+	}
+	gd, err := got.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if wd == gd {
+		return nil, nil
+	}
+
+	wl, err := want.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("getting published layers: %w", err)
+	}
+	gl, err := got.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("getting rebuilt layers: %w", err)
+	}
+	for i := range min(len(wl), len(gl)) {
+		d, err := compareLayer(wl[i], gl[i])
+		if err != nil {
+			return nil, fmt.Errorf("comparing layer %d: %w", i, err)
+		}
+		if d != nil {
+			d.Layer = i
+			return d, nil
+		}
+	}
+	if len(wl) != len(gl) {
+		return &Divergence{Layer: -1, Reason: fmt.Sprintf("%d layers, rebuilt %d", len(wl), len(gl))}, nil
+	}
+
+	// The layers are identical, so the config or manifest differs.
+	wc, err := want.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("getting published config: %w", err)
+	}
+	gc, err := got.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("getting rebuilt config: %w", err)
+	}
+	if reason := diffJSON("config", wc, gc); reason != "" {
+		return &Divergence{Layer: -1, Reason: reason}, nil
+	}
+	wm, err := want.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("getting published manifest: %w", err)
+	}
+	gm, err := got.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("getting rebuilt manifest: %w", err)
+	}
+	if reason := diffJSON("manifest", wm, gm); reason != "" {
+		return &Divergence{Layer: -1, Reason: reason}, nil
+	}
+	return &Divergence{Layer: -1, Reason: fmt.Sprintf("manifest digest %s, rebuilt %s", wd, gd)}, nil
+}
+
+// compareLayer compares two layers by their uncompressed contents, and when
+// those differ, by each entry of their tarballs in order.
+func compareLayer(want, got v1.Layer) (*Divergence, error) {
+	wdiff, err := want.DiffID()
+	if err != nil {
+		return nil, err
+	}
+	gdiff, err := got.DiffID()
+	if err != nil {
+		return nil, err
+	}
+	if wdiff == gdiff {
+		wd, err := want.Digest()
+		if err != nil {
+			return nil, err
+		}
+		gd, err := got.Digest()
+		if err != nil {
+			return nil, err
+		}
+		if wd != gd {
+			return &Divergence{Reason: fmt.Sprintf("same contents compressed differently (digest %s, rebuilt %s)", wd, gd)}, nil
+		}
+		return nil, nil
+	}
+
+	wr, err := want.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer wr.Close()
+	gr, err := got.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+
+	d, err := compareTar(tar.NewReader(wr), tar.NewReader(gr))
+	if err != nil || d != nil {
+		return d, err
+	}
+	return &Divergence{Reason: fmt.Sprintf("uncompressed digest %s, rebuilt %s", wdiff, gdiff)}, nil
+}
+
+func compareTar(want, got *tar.Reader) (*Divergence, error) {
+	for {
+		wh, werr := want.Next()
+		gh, gerr := got.Next()
+		if werr != nil && !errors.Is(werr, io.EOF) {
+			return nil, werr
+		}
+		if gerr != nil && !errors.Is(gerr, io.EOF) {
+			return nil, gerr
+		}
+		switch {
+		case werr != nil && gerr != nil:
+			return nil, nil
+		case werr != nil:
+			return &Divergence{Path: gh.Name, Reason: "only in the rebuild"}, nil
+		case gerr != nil:
+			return &Divergence{Path: wh.Name, Reason: "missing from the rebuild"}, nil
+		case wh.Name != gh.Name:
+			return &Divergence{Path: wh.Name, Reason: fmt.Sprintf("rebuild has %s in its place", gh.Name)}, nil
+		}
+		if reason := diffHeader(wh, gh); reason != "" {
+			return &Divergence{Path: wh.Name, Reason: reason}, nil
+		}
+		wsum, err := sha256Sum(want)
+		if err != nil {
+			return nil, err
+		}
+		gsum, err := sha256Sum(got)
+		if err != nil {
+			return nil, err
+		}
+		if wsum != gsum {
+			return &Divergence{Path: wh.Name, Reason: fmt.Sprintf("content sha256 %s, rebuilt %s", wsum, gsum)}, nil
+		}
+	}
+}
+
+// diffHeader describes the first difference between two tar headers.
+func diffHeader(want, got *tar.Header) string {
+	for _, f := range []struct {
+		name      string
+		want, got any
+	}{
+		{"type", string(want.Typeflag), string(got.Typeflag)},
+		{"link target", want.Linkname, got.Linkname},
+		{"size", want.Size, got.Size},
+		{"mode", fmt.Sprintf("%#o", want.Mode), fmt.Sprintf("%#o", got.Mode)},
+		{"uid", want.Uid, got.Uid},
+		{"gid", want.Gid, got.Gid},
+		{"user name", want.Uname, got.Uname},
+		{"group name", want.Gname, got.Gname},
+		{"modification time", want.ModTime.UTC(), got.ModTime.UTC()},
+		{"device major", want.Devmajor, got.Devmajor},
+		{"device minor", want.Devminor, got.Devminor},
+		{"PAX records", want.PAXRecords, got.PAXRecords},
+	} {
+		if !reflect.DeepEqual(f.want, f.got) {
+			return fmt.Sprintf("%s %v, rebuilt %v", f.name, f.want, f.got)
+		}
+	}
+	return ""
+}
+
+func sha256Sum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diffJSON describes the first field that differs between the JSON encodings
+// of want and got, or returns "" if they are equal.
+func diffJSON(prefix string, want, got any) string {
+	var w, g any
+	for _, v := range []struct {
+		in  any
+		out *any
+	}{{want, &w}, {got, &g}} {
+		b, err := json.Marshal(v.in)
+		if err != nil {
+			return fmt.Sprintf("%s: %v", prefix, err)
+		}
+		if err := json.Unmarshal(b, v.out); err != nil {
+			return fmt.Sprintf("%s: %v", prefix, err)
+		}
+	}
+	return firstDiff(prefix, w, g)
+}
+
+func firstDiff(path string, want, got any) string {
+	wm, wok := want.(map[string]any)
+	gm, gok := got.(map[string]any)
+	if wok && gok {
+		keys := maps.Clone(wm)
+		maps.Copy(keys, gm)
+		for _, k := range slices.Sorted(maps.Keys(keys)) {
+			if d := firstDiff(path+"."+k, wm[k], gm[k]); d != "" {
+				return d
+			}
+		}
+		return ""
+	}
+	wa, wok := want.([]any)
+	ga, gok := got.([]any)
+	if wok && gok && len(wa) == len(ga) {
+		for i := range wa {
+			if d := firstDiff(fmt.Sprintf("%s[%d]", path, i), wa[i], ga[i]); d != "" {
+				return d
+			}
+		}
+		return ""
+	}
+	if reflect.DeepEqual(want, got) {
+		return ""
+	}
+	return fmt.Sprintf("%s is %s, rebuilt %s", path, encode(want), encode(got))
+}
+
+func encode(v any) string {
+	if v == nil {
+		return "unset"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func platformString(p *v1.Platform) string {
+	if p == nil {
+		return "unknown"
+	}
+	return p.String()
+}
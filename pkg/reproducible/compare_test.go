@@ -0,0 +1,161 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reproducible
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/require"
+)
+
+type file struct {
+	name    string
+	content string
+	mode    int64
+}
+
+func testLayer(t *testing.T, files ...file) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     f.name,
+			Typeflag: tar.TypeReg,
+			Mode:     f.mode,
+			Size:     int64(len(f.content)),
+			ModTime:  time.Unix(0, 0),
+		}))
+		_, err := tw.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	require.NoError(t, err)
+	return layer
+}
+
+func testImage(t *testing.T, layers ...v1.Layer) v1.Image {
+	t.Helper()
+	img, err := mutate.AppendLayers(empty.Image, layers...)
+	require.NoError(t, err)
+	return img
+}
+
+func TestCompareImage(t *testing.T) {
+	ctx := context.Background()
+	base := testLayer(t, file{"etc/os-release", "ID=test\n", 0o644})
+	top := []file{{"usr/bin/a", "a", 0o755}, {"usr/bin/b", "b", 0o755}}
+
+	for _, tt := range []struct {
+		name string
+		got  v1.Image
+		want *Divergence
+	}{{
+		name: "identical",
+		got:  testImage(t, base, testLayer(t, top...)),
+	}, {
+		name: "content",
+		got:  testImage(t, base, testLayer(t, top[0], file{"usr/bin/b", "c", 0o755})),
+		want: &Divergence{Layer: 1, Path: "usr/bin/b"},
+	}, {
+		name: "mode",
+		got:  testImage(t, base, testLayer(t, file{"usr/bin/a", "a", 0o700}, top[1])),
+		want: &Divergence{Layer: 1, Path: "usr/bin/a", Reason: "mode 0755, rebuilt 0700"},
+	}, {
+		name: "missing file",
+		got:  testImage(t, base, testLayer(t, top[0])),
+		want: &Divergence{Layer: 1, Path: "usr/bin/b", Reason: "missing from the rebuild"},
+	}, {
+		name: "missing layer",
+		got:  testImage(t, base),
+		want: &Divergence{Layer: -1, Reason: "2 layers, rebuilt 1"},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := CompareImage(ctx, testImage(t, base, testLayer(t, top...)), tt.got)
+			require.NoError(t, err)
+			if tt.want == nil {
+				require.Nil(t, d)
+				return
+			}
+			require.NotNil(t, d)
+			require.Equal(t, tt.want.Layer, d.Layer)
+			require.Equal(t, tt.want.Path, d.Path)
+			if tt.want.Reason != "" {
+				require.Equal(t, tt.want.Reason, d.Reason)
+			}
+		})
+	}
+
+	t.Run("config", func(t *testing.T) {
+		want := testImage(t, base)
+		got, err := mutate.Config(want, v1.Config{Env: []string{"FOO=bar"}})
+		require.NoError(t, err)
+		d, err := CompareImage(ctx, want, got)
+		require.NoError(t, err)
+		require.NotNil(t, d)
+		require.Equal(t, -1, d.Layer)
+		require.Equal(t, `config.config.Env is unset, rebuilt ["FOO=bar"]`, d.Reason)
+	})
+}
+
+func TestCompareIndex(t *testing.T) {
+	ctx := context.Background()
+	amd64 := testImage(t, testLayer(t, file{"a", "amd64", 0o644}))
+	arm64 := testImage(t, testLayer(t, file{"a", "arm64", 0o644}))
+
+	index := func(images map[string]v1.Image) v1.ImageIndex {
+		var adds []mutate.IndexAddendum
+		for _, arch := range []string{"amd64", "arm64"} {
+			if img, ok := images[arch]; ok {
+				adds = append(adds, mutate.IndexAddendum{
+					Add:        img,
+					Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+				})
+			}
+		}
+		return mutate.AppendManifests(empty.Index, adds...)
+	}
+
+	want := index(map[string]v1.Image{"amd64": amd64, "arm64": arm64})
+	d, err := CompareIndex(ctx, want, index(map[string]v1.Image{"amd64": amd64, "arm64": arm64}))
+	require.NoError(t, err)
+	require.Nil(t, d)
+
+	d, err = CompareIndex(ctx, want, index(map[string]v1.Image{"amd64": amd64, "arm64": amd64}))
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	require.Equal(t, "linux/arm64", d.Platform)
+	require.Equal(t, 0, d.Layer)
+	require.Equal(t, "a", d.Path)
+
+	d, err = CompareIndex(ctx, want, index(map[string]v1.Image{"amd64": amd64}))
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	require.Equal(t, "linux/arm64", d.Platform)
+	require.Equal(t, "missing from the rebuild", d.Reason)
+	require.Equal(t, "image is not reproducible: platform linux/arm64: missing from the rebuild", d.Error())
+}
@@ -0,0 +1,117 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spdx
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	"chainguard.dev/apko/pkg/sbom/options"
+)
+
+// defaultCPEProducts are the CPE vendors and products of well known packages
+// whose names differ from them, by package name or origin. Others are assumed
+// to have their origin as both vendor and product.
+var defaultCPEProducts = map[string]options.CPEProduct{
+	"bash":      {Vendor: "gnu", Product: "bash"},
+	"bzip2":     {Vendor: "bzip", Product: "bzip2"},
+	"coreutils": {Vendor: "gnu", Product: "coreutils"},
+	"curl":      {Vendor: "haxx", Product: "curl"},
+	"expat":     {Vendor: "libexpat_project", Product: "libexpat"},
+	"gcc":       {Vendor: "gnu", Product: "gcc"},
+	"glibc":     {Vendor: "gnu", Product: "glibc"},
+	"gnutls":    {Vendor: "gnu", Product: "gnutls"},
+	"libxml2":   {Vendor: "xmlsoft", Product: "libxml2"},
+	"ncurses":   {Vendor: "gnu", Product: "ncurses"},
+	"nginx":     {Vendor: "f5", Product: "nginx"},
+	"openjdk":   {Vendor: "oracle", Product: "openjdk"},
+	"openssh":   {Vendor: "openbsd", Product: "openssh"},
+	"openssl":   {Vendor: "openssl", Product: "openssl"},
+	"pcre2":     {Vendor: "pcre", Product: "pcre2"},
+	"python":    {Vendor: "python", Product: "python"},
+	"sqlite":    {Vendor: "sqlite", Product: "sqlite"},
+	"tzdata":    {Vendor: "iana", Product: "time_zone_database"},
+	"xz":        {Vendor: "tukaani", Product: "xz"},
+	"zlib":      {Vendor: "zlib", Product: "zlib"},
+}
+
+var (
+	apkReleaseRe = regexp.MustCompile(`-r[0-9]+$`)
+	// Versioned origins, such as python-3.12 or openjdk-21, are looked up
+	// without their version.
+	versionedOriginRe = regexp.MustCompile(`-[0-9][0-9.]*$`)
+	cpeSpecialRe      = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+)
+
+// cpeProduct returns the CPE vendor and product of pkg: from mapping by name
+// then by origin, then from the defaults, or else its origin.
+func cpeProduct(pkg *apk.Package, mapping map[string]options.CPEProduct) options.CPEProduct {
+	origin := pkg.Origin
+	if origin == "" {
+		origin = pkg.Name
+	}
+	for _, m := range []map[string]options.CPEProduct{mapping, defaultCPEProducts} {
+		for _, key := range []string{pkg.Name, origin, versionedOriginRe.ReplaceAllString(origin, "")} {
+			if p, ok := m[key]; ok {
+				return p
+			}
+		}
+	}
+	return options.CPEProduct{Vendor: origin, Product: origin}
+}
+
+// cpeEscape quotes the characters of a CPE 2.3 formatted string attribute
+// that are not letters, digits, underscores, dots or hyphens.
+func cpeEscape(s string) string {
+	return cpeSpecialRe.ReplaceAllStringFunc(strings.ToLower(s), func(c string) string { return `\` + c })
+}
+
+// packageCPE returns a best effort CPE 2.3 name of pkg, with its upstream
+// version, such as "cpe:2.3:a:haxx:curl:8.11.0:*:*:*:*:*:*:*".
+func packageCPE(pkg *apk.Package, mapping map[string]options.CPEProduct) string {
+	p := cpeProduct(pkg, mapping)
+	version := apkReleaseRe.ReplaceAllString(pkg.Version, "")
+	fields := []string{"cpe", "2.3", "a", cpeEscape(p.Vendor), cpeEscape(p.Product), cpeEscape(version)}
+	for len(fields) < 13 {
+		fields = append(fields, "*")
+	}
+	return strings.Join(fields, ":")
+}
+
+// addCPEs adds a CPE, alongside its purl, to the package copied from the SBOM
+// of each apk that does not already have one.
+func addCPEs(doc *Document, opts *options.Options) {
+	if !opts.CPEs {
+		return
+	}
+	for _, ipkg := range opts.Packages {
+		for i := range doc.Packages {
+			p := &doc.Packages[i]
+			if p.Name != ipkg.Name || p.Version != ipkg.Version {
+				continue
+			}
+			if slices.ContainsFunc(p.ExternalRefs, func(ref ExternalRef) bool { return ref.Type == ExtRefTypeCPE23 }) {
+				continue
+			}
+			p.ExternalRefs = append(p.ExternalRefs, ExternalRef{
+				Category: ExtRefSecurity,
+				Type:     ExtRefTypeCPE23,
+				Locator:  packageCPE(&ipkg.Package, opts.CPEMapping),
+			})
+		}
+	}
+}
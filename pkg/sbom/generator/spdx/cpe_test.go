@@ -0,0 +1,62 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spdx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/sbom/options"
+)
+
+func TestPackageCPE(t *testing.T) {
+	mapping := map[string]options.CPEProduct{"libcurl-openssl4": {Vendor: "haxx", Product: "libcurl"}}
+	for _, tt := range []struct {
+		pkg  apk.Package
+		want string
+	}{
+		{apk.Package{Name: "curl", Version: "8.11.0-r0", Origin: "curl"}, "cpe:2.3:a:haxx:curl:8.11.0:*:*:*:*:*:*:*"},
+		{apk.Package{Name: "libcurl-openssl4", Version: "8.11.0-r0", Origin: "curl"}, "cpe:2.3:a:haxx:libcurl:8.11.0:*:*:*:*:*:*:*"},
+		{apk.Package{Name: "python-3.12-base", Version: "3.12.7-r1", Origin: "python-3.12"}, "cpe:2.3:a:python:python:3.12.7:*:*:*:*:*:*:*"},
+		{apk.Package{Name: "hello", Version: "2.12_p1-r3"}, "cpe:2.3:a:hello:hello:2.12_p1:*:*:*:*:*:*:*"},
+		{apk.Package{Name: "gtk+3.0", Version: "3.24.43-r0"}, `cpe:2.3:a:gtk\+3.0:gtk\+3.0:3.24.43:*:*:*:*:*:*:*`},
+	} {
+		t.Run(tt.pkg.Name, func(t *testing.T) {
+			require.Equal(t, tt.want, packageCPE(&tt.pkg, mapping))
+		})
+	}
+}
+
+func TestAddCPEs(t *testing.T) {
+	opts := testOpts(apkfs.NewMemFS())
+	purlRef := ExternalRef{Category: ExtRefPackageManager, Type: ExtRefTypePurl, Locator: "pkg:apk/wolfi/musl@1.2.2-r7?arch=x86_64"}
+	doc := &Document{Packages: []Package{
+		{ID: "SPDXRef-Package-musl", Name: "musl", Version: "1.2.2-r7", ExternalRefs: []ExternalRef{purlRef}},
+		{ID: "SPDXRef-Package-other", Name: "other", Version: "1.0-r0"},
+	}}
+
+	// CPEs are only added when asked for.
+	addCPEs(doc, opts)
+	require.Equal(t, []ExternalRef{purlRef}, doc.Packages[0].ExternalRefs)
+
+	opts.CPEs = true
+	addCPEs(doc, opts)
+	addCPEs(doc, opts)
+	require.Equal(t, []ExternalRef{purlRef, {Category: ExtRefSecurity, Type: ExtRefTypeCPE23, Locator: "cpe:2.3:a:musl:musl:1.2.2:*:*:*:*:*:*:*"}}, doc.Packages[0].ExternalRefs)
+	require.Empty(t, doc.Packages[1].ExternalRefs)
+}
@@ -0,0 +1,268 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spdx
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/release-utils/version"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/sbom/options"
+)
+
+// maxLicenseFileSize is how much of a license file is classified.
+const maxLicenseFileSize = 1 << 20
+
+// licenseTitles identify the full texts of licenses by their title, in
+// normalized form. The titles of the GNU licenses include their date, so that
+// the licenses that a text only refers to, such as the GPL-3.0 referring to
+// the Lesser GPL, do not match. The full text of a license does not say
+// whether later versions apply, as its appendix is only an example notice, so
+// the GNU licenses are classified as their -only variant.
+var licenseTitles = []struct {
+	id    string
+	title string
+}{
+	{"GPL-1.0-only", "gnu general public license version 1 february 1989"},
+	{"GPL-2.0-only", "gnu general public license version 2 june 1991"},
+	{"GPL-3.0-only", "gnu general public license version 3 29 june 2007"},
+	{"LGPL-2.0-only", "gnu library general public license version 2 june 1991"},
+	{"LGPL-2.1-only", "gnu lesser general public license version 2.1 february 1999"},
+	{"LGPL-3.0-only", "gnu lesser general public license version 3 29 june 2007"},
+	{"AGPL-3.0-only", "gnu affero general public license version 3 19 november 2007"},
+	{"Apache-2.0", "apache license version 2.0 january 2004"},
+	{"MPL-1.1", "mozilla public license version 1.1 "},
+	{"MPL-2.0", "mozilla public license version 2.0 "},
+	{"CC0-1.0", "cc0 1.0 universal"},
+}
+
+// gnuNoticeIDs are the SPDX identifiers of the GNU licenses that notices
+// refer to, by the kind of license and its version.
+var gnuNoticeIDs = map[string]string{
+	" 1":         "GPL-1.0",
+	" 2":         "GPL-2.0",
+	" 3":         "GPL-3.0",
+	"library 2":  "LGPL-2.0",
+	"lesser 2.1": "LGPL-2.1",
+	"lesser 3":   "LGPL-3.0",
+	"affero 3":   "AGPL-3.0",
+}
+
+// licensePatterns identify the licenses without a title by phrases of their
+// text, in normalized form. The first pattern whose phrases all appear wins,
+// so those whose text contains another's come first.
+var licensePatterns = []struct {
+	id      string
+	phrases []string
+}{
+	{"MIT", []string{"permission is hereby granted free of charge to any person obtaining a copy"}},
+	{"ISC", []string{"permission to use copy modify and or distribute this software for any purpose with or without fee is hereby granted"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name of"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"Zlib", []string{"this software is provided as is without any express or implied warranty", "altered source versions must be plainly marked"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"MPL-2.0", []string{"subject to the terms of the mozilla public license v 2.0"}},
+}
+
+var (
+	spdxIdentifierRe = regexp.MustCompile(`SPDX-License-Identifier:\s*([A-Za-z0-9.+-]+)`)
+	nonWordRe        = regexp.MustCompile(`[^a-z0-9.]+`)
+	periodRe         = regexp.MustCompile(`\.+( |$)`)
+	// gnuNoticeRe matches the notices that license a program under a GNU
+	// license, such as "under the terms of the GNU General Public License as
+	// published by the Free Software Foundation; either version 2 of the
+	// License, or (at your option) any later version", in normalized form.
+	gnuNoticeRe = regexp.MustCompile(`gnu (lesser |library |affero )?general public license as published by the free software foundation (?:either )?version (\d+(?:\.\d+)?)(?: of the license)?( or at your option any later version)?`)
+)
+
+// normalizeLicenseText lowercases text and collapses everything but letters,
+// digits and the dots of version numbers into single spaces, so wrapping and
+// punctuation do not matter.
+func normalizeLicenseText(text string) string {
+	text = nonWordRe.ReplaceAllString(strings.ToLower(text), " ")
+	return " " + strings.TrimSpace(periodRe.ReplaceAllString(text, " ")) + " "
+}
+
+// classifyLicense returns the SPDX identifiers of the licenses in text: those
+// of its SPDX-License-Identifier lines if it has any, otherwise those of the
+// GNU license notices before the first license title, otherwise the license
+// of that title, otherwise the first license whose phrases it contains.
+func classifyLicense(text string) []string {
+	var ids []string
+	for _, m := range spdxIdentifierRe.FindAllStringSubmatch(text, -1) {
+		if !slices.Contains(ids, m[1]) {
+			ids = append(ids, m[1])
+		}
+	}
+	if len(ids) > 0 {
+		return ids
+	}
+	normalized := normalizeLicenseText(text)
+
+	title, titleAt := "", len(normalized)
+	for _, t := range licenseTitles {
+		if i := strings.Index(normalized, t.title); i >= 0 && i < titleAt {
+			title, titleAt = t.id, i
+		}
+	}
+	// A notice ahead of the text of the license it refers to says which
+	// versions apply.
+	for _, m := range gnuNoticeRe.FindAllStringSubmatch(normalized[:titleAt], -1) {
+		id, ok := gnuNoticeIDs[strings.TrimSpace(m[1])+" "+m[2]]
+		if !ok {
+			continue
+		}
+		if m[3] != "" {
+			id += "-or-later"
+		} else {
+			id += "-only"
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		return ids
+	}
+	if title != "" {
+		return []string{title}
+	}
+
+	for _, p := range licensePatterns {
+		if !slices.ContainsFunc(p.phrases, func(phrase string) bool {
+			return !strings.Contains(normalized, normalizeLicenseText(phrase))
+		}) {
+			return []string{p.id}
+		}
+	}
+	return nil
+}
+
+// isLicenseFile returns whether name is where packages install their license
+// texts: under usr/share/licenses, or a copyright, LICENSE or COPYING file of
+// usr/share/doc.
+func isLicenseFile(name string) bool {
+	if strings.HasPrefix(name, "usr/share/licenses/") {
+		return true
+	}
+	if !strings.HasPrefix(name, "usr/share/doc/") {
+		return false
+	}
+	base := strings.ToUpper(path.Base(name))
+	return base == "COPYRIGHT" || strings.HasPrefix(base, "LICENSE") || strings.HasPrefix(base, "LICENCE") || strings.HasPrefix(base, "COPYING")
+}
+
+// detectLicense classifies the license files installed by ipkg. It returns
+// the licenses found as an SPDX expression, and the files they were found in.
+func detectLicense(fsys apkfs.ReaderFS, ipkg *apk.InstalledPackage) (string, []string, error) {
+	var ids, sources []string
+	for _, hdr := range ipkg.Files {
+		if hdr.Typeflag == tar.TypeDir || !isLicenseFile(hdr.Name) {
+			continue
+		}
+		fi, err := fsys.Lstat(hdr.Name)
+		if err != nil || !fi.Mode().IsRegular() {
+			// Removed or replaced after installation.
+			continue
+		}
+		f, err := fsys.Open(hdr.Name)
+		if err != nil {
+			return "", nil, err
+		}
+		b, err := io.ReadAll(io.LimitReader(f, maxLicenseFileSize))
+		f.Close()
+		if err != nil {
+			return "", nil, fmt.Errorf("reading %s: %w", hdr.Name, err)
+		}
+		found := classifyLicense(string(b))
+		if len(found) == 0 {
+			continue
+		}
+		sources = append(sources, "/"+hdr.Name)
+		for _, id := range found {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return strings.Join(ids, " AND "), sources, nil
+}
+
+// hasDeclaredLicense returns whether license is a license rather than a
+// placeholder for a missing one.
+func hasDeclaredLicense(license string) bool {
+	switch strings.ToUpper(strings.TrimSpace(license)) {
+	case "", "UNKNOWN", NOASSERTION:
+		return false
+	}
+	return true
+}
+
+// addDetectedLicenses classifies the license files of the packages that
+// declare no license. The license found is concluded for the package copied
+// from the apk's SBOM, and annotated as detected on it, or only annotated on
+// the described element if the apk had no SBOM.
+func addDetectedLicenses(doc *Document, opts *options.Options) error {
+	if !opts.DetectLicenses || len(doc.DocumentDescribes) == 0 {
+		return nil
+	}
+	for _, ipkg := range opts.Packages {
+		if hasDeclaredLicense(ipkg.License) {
+			continue
+		}
+		license, sources, err := detectLicense(opts.FS, ipkg)
+		if err != nil {
+			return fmt.Errorf("detecting license of %s: %w", ipkg.Name, err)
+		}
+		if license == "" {
+			continue
+		}
+
+		owner := -1
+		for i, p := range doc.Packages {
+			if p.Name == ipkg.Name && p.Version == ipkg.Version {
+				owner = i
+				break
+			}
+		}
+		comment := fmt.Sprintf("detected license %s from %s", license, strings.Join(sources, ", "))
+		if owner < 0 {
+			comment = fmt.Sprintf("%s-%s: %s", ipkg.Name, ipkg.Version, comment)
+			owner = slices.IndexFunc(doc.Packages, func(p Package) bool { return p.ID == doc.DocumentDescribes[0] })
+			if owner < 0 {
+				continue
+			}
+		} else {
+			doc.Packages[owner].LicenseConcluded = license
+		}
+		doc.Packages[owner].Annotations = append(doc.Packages[owner].Annotations, Annotation{
+			Date:      opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
+			Type:      "OTHER",
+			Annotator: fmt.Sprintf("Tool: apko (%s)", version.GetVersionInfo().GitVersion),
+			Comment:   comment,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spdx
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"chainguard.dev/apko/pkg/apk/apk"
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+const mitText = `Copyright (c) 2024 Example
+
+Permission is hereby granted, free of charge, to any person obtaining a
+copy of this software and associated documentation files (the "Software"),
+to deal in the Software without restriction.`
+
+func TestClassifyLicense(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"mit", mitText, []string{"MIT"}},
+		{"spdx identifier", "// SPDX-License-Identifier: Apache-2.0\n// SPDX-License-Identifier: MIT\n", []string{"Apache-2.0", "MIT"}},
+		{"bsd-2", "Redistribution and use in source and binary\nforms, with or without modification, are permitted", []string{"BSD-2-Clause"}},
+		{"mpl-2.0 notice", "This Source Code Form is subject to the terms of the Mozilla Public\nLicense, v. 2.0.", []string{"MPL-2.0"}},
+		{"unknown", "All rights reserved.", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, classifyLicense(tt.text))
+		})
+	}
+}
+
+func TestClassifyLicenseTexts(t *testing.T) {
+	read := func(t *testing.T, name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join("testdata", "licenses", name))
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	// The full texts of the licenses, as in /usr/share/common-licenses.
+	for name, want := range map[string]string{
+		"Apache-2.0": "Apache-2.0",
+		"BSD":        "BSD-3-Clause",
+		"CC0-1.0":    "CC0-1.0",
+		"GPL-2":      "GPL-2.0-only",
+		"GPL-3":      "GPL-3.0-only",
+		"LGPL-2":     "LGPL-2.0-only",
+		"LGPL-2.1":   "LGPL-2.1-only",
+		"LGPL-3":     "LGPL-3.0-only",
+		"MPL-1.1":    "MPL-1.1",
+		"MPL-2.0":    "MPL-2.0",
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, []string{want}, classifyLicense(read(t, name)))
+		})
+	}
+
+	t.Run("notices", func(t *testing.T) {
+		require.Equal(t, []string{"GPL-2.0-or-later", "LGPL-2.1-only"}, classifyLicense(read(t, "copyright")))
+	})
+
+	t.Run("notice before the text", func(t *testing.T) {
+		notice := "This program is free software: you can redistribute it and/or modify it under the terms of the GNU General Public License as published by the Free Software Foundation, either version 3 of the License, or (at your option) any later version.\n\n"
+		require.Equal(t, []string{"GPL-3.0-or-later"}, classifyLicense(notice+read(t, "GPL-3")))
+	})
+}
+
+func TestIsLicenseFile(t *testing.T) {
+	require.True(t, isLicenseFile("usr/share/licenses/foo/LICENSE"))
+	require.True(t, isLicenseFile("usr/share/doc/foo/copyright"))
+	require.True(t, isLicenseFile("usr/share/doc/foo/COPYING.LIB"))
+	require.False(t, isLicenseFile("usr/share/doc/foo/README"))
+	require.False(t, isLicenseFile("usr/lib/LICENSE"))
+}
+
+func TestDetectedLicenses(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("usr/share/licenses/foo", 0o755))
+	require.NoError(t, fsys.WriteFile("usr/share/licenses/foo/LICENSE", []byte(mitText), 0o644))
+	require.NoError(t, fsys.MkdirAll("usr/share/doc/bar", 0o755))
+	require.NoError(t, fsys.WriteFile("usr/share/doc/bar/copyright", []byte("SPDX-License-Identifier: GPL-2.0-or-later"), 0o644))
+
+	opts := testOpts(fsys)
+	opts.Packages = []*apk.InstalledPackage{{
+		Package: apk.Package{Name: "foo", Version: "1.0-r0", License: "UNKNOWN"},
+		Files:   []tar.Header{{Name: "usr/share/licenses/foo", Typeflag: tar.TypeDir}, {Name: "usr/share/licenses/foo/LICENSE"}},
+	}, {
+		Package: apk.Package{Name: "bar", Version: "2.0-r0"},
+		Files:   []tar.Header{{Name: "usr/share/doc/bar/copyright"}},
+	}, {
+		// Declared licenses are left as they are.
+		Package: apk.Package{Name: "baz", Version: "3.0-r0", License: "Apache-2.0"},
+		Files:   []tar.Header{{Name: "usr/share/licenses/foo/LICENSE"}},
+	}}
+	doc := &Document{
+		DocumentDescribes: []string{"SPDXRef-Package-image"},
+		Packages: []Package{
+			{ID: "SPDXRef-Package-image", Name: "image"},
+			{ID: "SPDXRef-Package-foo", Name: "foo", Version: "1.0-r0", LicenseDeclared: NOASSERTION},
+		},
+	}
+
+	// Licenses are only detected when asked for.
+	require.NoError(t, addDetectedLicenses(doc, opts))
+	require.Empty(t, doc.Packages[1].LicenseConcluded)
+
+	opts.DetectLicenses = true
+	require.NoError(t, addDetectedLicenses(doc, opts))
+	require.Equal(t, "MIT", doc.Packages[1].LicenseConcluded)
+	require.Equal(t, NOASSERTION, doc.Packages[1].LicenseDeclared)
+	require.Len(t, doc.Packages[1].Annotations, 1)
+	require.Equal(t, "detected license MIT from /usr/share/licenses/foo/LICENSE", doc.Packages[1].Annotations[0].Comment)
+
+	// Without a package of its own, the detected license is only annotated on
+	// the described element.
+	require.Empty(t, doc.Packages[0].LicenseConcluded)
+	require.Len(t, doc.Packages[0].Annotations, 1)
+	require.Equal(t, "bar-2.0-r0: detected license GPL-2.0-or-later from /usr/share/doc/bar/copyright", doc.Packages[0].Annotations[0].Comment)
+}
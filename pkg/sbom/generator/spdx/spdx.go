@@ -15,12 +15,20 @@
 package spdx
 
 import (
+	"archive/tar"
 	"context"
+	"crypto/sha1" //nolint:gosec // SPDX requires SHA1 file checksums
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 	"unicode/utf8"
@@ -49,6 +57,8 @@ const (
 	NOASSERTION          = "NOASSERTION"
 	ExtRefPackageManager = "PACKAGE-MANAGER"
 	ExtRefTypePurl       = "purl"
+	ExtRefSecurity       = "SECURITY"
+	ExtRefTypeCPE23      = "cpe23Type"
 	apkSBOMdir           = "/var/lib/db/sbom"
 )
 
@@ -156,6 +166,24 @@ func (sx *SPDX) Generate(ctx context.Context, opts *options.Options, path string
 		}
 	}
 
+	if opts.BaseImage != nil && imagePackage != nil {
+		if err := sx.addBaseImage(ctx, doc, opts, imagePackage); err != nil {
+			return fmt.Errorf("adding base image: %w", err)
+		}
+	}
+
+	addImageAnnotations(doc, opts)
+
+	if err := addFiles(doc, opts); err != nil {
+		return fmt.Errorf("adding files: %w", err)
+	}
+
+	addCPEs(doc, opts)
+
+	if err := addDetectedLicenses(doc, opts); err != nil {
+		return fmt.Errorf("adding detected licenses: %w", err)
+	}
+
 	dedupedPackages := make([]Package, 0, len(doc.Packages))
 	seenIDs := make(map[string]struct{})
 	for i := range doc.Packages {
@@ -175,6 +203,219 @@ func (sx *SPDX) Generate(ctx context.Context, opts *options.Options, path string
 	return nil
 }
 
+// addImageAnnotations annotates the package the document describes with the
+// install scriptlets that were not run in the image, and the paths excluded
+// from it.
+func addImageAnnotations(doc *Document, opts *options.Options) {
+	if len(opts.SkippedScriptlets)+len(opts.ExcludedPaths) == 0 || len(doc.DocumentDescribes) == 0 {
+		return
+	}
+	var comments []string
+	for _, s := range opts.SkippedScriptlets {
+		comments = append(comments, "skipped install scriptlet: "+s)
+	}
+	for _, p := range opts.ExcludedPaths {
+		comments = append(comments, "excluded path: "+p)
+	}
+	for i := range doc.Packages {
+		if doc.Packages[i].ID != doc.DocumentDescribes[0] {
+			continue
+		}
+		for _, c := range comments {
+			doc.Packages[i].Annotations = append(doc.Packages[i].Annotations, Annotation{
+				Date:      opts.ImageInfo.SourceDateEpoch.Format(time.RFC3339),
+				Type:      "OTHER",
+				Annotator: fmt.Sprintf("Tool: apko (%s)", version.GetVersionInfo().GitVersion),
+				Comment:   c,
+			})
+		}
+		return
+	}
+}
+
+// addFiles lists the regular files installed by the packages, with their
+// checksums. Each file is contained by the package of its apk, as described
+// by the apk's own SBOM, or by the described element if there is none.
+func addFiles(doc *Document, opts *options.Options) error {
+	if !opts.Files || len(doc.DocumentDescribes) == 0 {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, ipkg := range opts.Packages {
+		owner := doc.DocumentDescribes[0]
+		for _, p := range doc.Packages {
+			if p.Name == ipkg.Name && p.Version == ipkg.Version {
+				owner = p.ID
+				break
+			}
+		}
+		for _, hdr := range ipkg.Files {
+			if hdr.Typeflag == tar.TypeDir || seen[hdr.Name] {
+				continue
+			}
+			if _, err := opts.FS.Readlink(hdr.Name); err == nil {
+				// Symlinks have no content of their own.
+				continue
+			}
+			fi, err := opts.FS.Lstat(hdr.Name)
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed after installation, such as by a scriptlet.
+				continue
+			} else if err != nil {
+				return err
+			}
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+			checksums, err := fileChecksums(opts.FS, hdr.Name)
+			if err != nil {
+				return err
+			}
+			seen[hdr.Name] = true
+			id := "SPDXRef-File-" + stringToIdentifier(hdr.Name)
+			doc.Files = append(doc.Files, File{ID: id, Name: "/" + hdr.Name, Checksums: checksums})
+			doc.Relationships = append(doc.Relationships, Relationship{
+				Element: owner,
+				Type:    "CONTAINS",
+				Related: id,
+			})
+		}
+	}
+	return nil
+}
+
+// fileChecksums returns the SHA1 checksum SPDX requires of the file at path,
+// and its SHA256 checksum.
+func fileChecksums(fsys apkfs.ReaderFS, path string) ([]Checksum, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s1, s256 := sha1.New(), sha256.New() //nolint:gosec // SPDX requires SHA1 file checksums
+	if _, err := io.Copy(io.MultiWriter(s1, s256), f); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return []Checksum{
+		{Algorithm: "SHA1", Value: hex.EncodeToString(s1.Sum(nil))},
+		{Algorithm: "SHA256", Value: hex.EncodeToString(s256.Sum(nil))},
+	}, nil
+}
+
+// addBaseImage describes the image the SBOM's image was built on top of. The
+// base image's own SBOM is merged in when available so its packages are
+// described too, and the image is related to it with DESCENDANT_OF.
+func (sx *SPDX) addBaseImage(ctx context.Context, doc *Document, opts *options.Options, imagePackage *Package) error {
+	var baseIDs []string
+
+	if opts.BaseImage.SBOM != nil {
+		baseDoc := &Document{}
+		if err := json.Unmarshal(opts.BaseImage.SBOM, baseDoc); err != nil {
+			clog.FromContext(ctx).Warnf("ignoring unparseable SBOM of base image %s: %v", opts.BaseImage.Reference, err)
+		} else {
+			todo := map[string]struct{}{}
+			for _, id := range baseDoc.DocumentDescribes {
+				todo[id] = struct{}{}
+			}
+			for _, rel := range baseDoc.Relationships {
+				if rel.Element == "SPDXRef-DOCUMENT" && rel.Type == "DESCRIBES" {
+					todo[rel.Related] = struct{}{}
+				}
+			}
+			baseIDs = slices.Sorted(maps.Keys(todo))
+
+			if err := copySBOMElements(baseDoc, doc, todo); err != nil {
+				return fmt.Errorf("copying base image SBOM: %w", err)
+			}
+			if err := mergeLicensingInfos(baseDoc, doc); err != nil {
+				return fmt.Errorf("merging base image LicensingInfos: %w", err)
+			}
+		}
+	}
+
+	if len(baseIDs) == 0 {
+		basePackage := sx.baseImagePackage(opts)
+		doc.Packages = append(doc.Packages, *basePackage)
+		baseIDs = []string{basePackage.ID}
+	}
+
+	for _, id := range baseIDs {
+		doc.Relationships = append(doc.Relationships, Relationship{
+			Element: imagePackage.ID,
+			Type:    "DESCENDANT_OF",
+			Related: id,
+		})
+	}
+
+	// Relate the packages that came with the base image to it, so they can be
+	// told apart from those this build added.
+	for _, ipkg := range opts.BaseImage.Packages {
+		i := slices.IndexFunc(doc.Packages, func(p Package) bool {
+			return p.Name == ipkg.Name && p.Version == ipkg.Version
+		})
+		if i < 0 {
+			doc.Packages = append(doc.Packages, basePackageElement(opts, ipkg))
+			i = len(doc.Packages) - 1
+		}
+		doc.Relationships = append(doc.Relationships, Relationship{
+			Element: doc.Packages[i].ID,
+			Type:    "CONTAINED_BY",
+			Related: baseIDs[0],
+		})
+	}
+
+	return nil
+}
+
+// basePackageElement returns a package describing ipkg, from the base image,
+// when the base image's SBOM does not describe it.
+func basePackageElement(opts *options.Options, ipkg *apk.InstalledPackage) Package {
+	license := ipkg.License
+	if !hasDeclaredLicense(license) {
+		license = NOASSERTION
+	}
+	return Package{
+		ID:               stringToIdentifier(fmt.Sprintf("SPDXRef-Package-%s-%s", ipkg.Name, ipkg.Version)),
+		Name:             ipkg.Name,
+		Version:          ipkg.Version,
+		Supplier:         supplier(opts),
+		DownloadLocation: NOASSERTION,
+		LicenseDeclared:  license,
+		Description:      ipkg.Description,
+		ExternalRefs: []ExternalRef{{
+			Category: ExtRefPackageManager,
+			Type:     ExtRefTypePurl,
+			Locator: purl.NewPackageURL(
+				"apk", opts.OS.ID, ipkg.Name, ipkg.Version,
+				purl.QualifiersFromMap(map[string]string{"arch": ipkg.Arch}), "",
+			).String(),
+		}},
+	}
+}
+
+// baseImagePackage returns a package describing the base image when it has
+// no SBOM of its own.
+func (sx *SPDX) baseImagePackage(opts *options.Options) *Package {
+	return &Package{
+		ID: stringToIdentifier(fmt.Sprintf(
+			"SPDXRef-Package-%s", opts.BaseImage.Digest,
+		)),
+		Name:             opts.BaseImage.Reference,
+		Version:          opts.BaseImage.Digest,
+		Supplier:         NOASSERTION,
+		DownloadLocation: NOASSERTION,
+		PrimaryPurpose:   "CONTAINER",
+		FilesAnalyzed:    false,
+		Description:      "base container image",
+		Checksums: []Checksum{
+			{
+				Algorithm: "SHA256",
+				Value:     strings.TrimPrefix(opts.BaseImage.Digest, "sha256:"),
+			},
+		},
+	}
+}
+
 // locateApkSBOM returns the path to the SBOM in the given filesystem, using the
 // given Package's name and version. It returns an empty string if the SBOM is
 // not found.
@@ -475,6 +716,7 @@ type Document struct {
 	Namespace            string                `json:"documentNamespace"`
 	DocumentDescribes    []string              `json:"documentDescribes"`
 	Packages             []Package             `json:"packages"`
+	Files                []File                `json:"files,omitempty"`
 	Relationships        []Relationship        `json:"relationships"`
 	ExternalDocumentRefs []ExternalDocumentRef `json:"externalDocumentRefs,omitempty"`
 	LicensingInfos       []LicensingInfo       `json:"hasExtractedLicensingInfos,omitempty"`
@@ -528,6 +770,14 @@ type Package struct {
 	Checksums        []Checksum               `json:"checksums,omitempty"`
 	ExternalRefs     []ExternalRef            `json:"externalRefs,omitempty"`
 	VerificationCode *PackageVerificationCode `json:"packageVerificationCode,omitempty"`
+	Annotations      []Annotation             `json:"annotations,omitempty"`
+}
+
+type Annotation struct {
+	Date      string `json:"annotationDate"`
+	Type      string `json:"annotationType"`
+	Annotator string `json:"annotator"`
+	Comment   string `json:"comment"`
 }
 
 type PackageVerificationCode struct {
@@ -15,6 +15,7 @@
 package spdx
 
 import (
+	"archive/tar"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -387,3 +388,164 @@ func TestSourcePackage(t *testing.T) {
 	require.Equal(t, imagePackage.ID, doc.Relationships[0].Element)
 	require.Equal(t, doc.Packages[0].ID, doc.Relationships[0].Related)
 }
+
+func TestBaseImage(t *testing.T) {
+	imagePackage := Package{ID: "SPDXRef-Package-image"}
+	baseDigest := "sha256:5a99438a9ced8193f1d71209d0b558fdc0b184aee5cf258e5f7aa9a6ab0f0671"
+
+	t.Run("with SBOM", func(t *testing.T) {
+		baseSBOM, err := json.Marshal(Document{
+			DocumentDescribes: []string{"SPDXRef-Package-base"},
+			Packages: []Package{
+				{ID: "SPDXRef-Package-base", Name: "base"},
+				{ID: "SPDXRef-Package-layer", Name: "layer"},
+				{ID: "SPDXRef-Package-busybox", Name: "busybox"},
+				{ID: "SPDXRef-Package-unrelated", Name: "unrelated"},
+			},
+			Relationships: []Relationship{
+				{Element: "SPDXRef-Package-base", Type: "CONTAINS", Related: "SPDXRef-Package-layer"},
+				{Element: "SPDXRef-Package-layer", Type: "CONTAINS", Related: "SPDXRef-Package-busybox"},
+			},
+		})
+		require.NoError(t, err)
+
+		doc := Document{}
+		opts := &options.Options{BaseImage: &options.BaseImageInfo{Reference: "example.com/base", Digest: baseDigest, SBOM: baseSBOM}}
+		require.NoError(t, New().addBaseImage(t.Context(), &doc, opts, &imagePackage))
+
+		var names []string
+		for _, p := range doc.Packages {
+			names = append(names, p.Name)
+		}
+		require.Equal(t, []string{"base", "layer", "busybox"}, names)
+		require.Contains(t, doc.Relationships, Relationship{Element: imagePackage.ID, Type: "DESCENDANT_OF", Related: "SPDXRef-Package-base"})
+		require.Contains(t, doc.Relationships, Relationship{Element: "SPDXRef-Package-layer", Type: "CONTAINS", Related: "SPDXRef-Package-busybox"})
+	})
+
+	t.Run("without SBOM", func(t *testing.T) {
+		doc := Document{}
+		opts := &options.Options{BaseImage: &options.BaseImageInfo{Reference: "example.com/base", Digest: baseDigest}}
+		require.NoError(t, New().addBaseImage(t.Context(), &doc, opts, &imagePackage))
+
+		require.Len(t, doc.Packages, 1)
+		require.Equal(t, "example.com/base", doc.Packages[0].Name)
+		require.Equal(t, "CONTAINER", doc.Packages[0].PrimaryPurpose)
+		require.Equal(t, []Relationship{{Element: imagePackage.ID, Type: "DESCENDANT_OF", Related: doc.Packages[0].ID}}, doc.Relationships)
+	})
+
+	t.Run("base packages", func(t *testing.T) {
+		baseSBOM, err := json.Marshal(Document{
+			DocumentDescribes: []string{"SPDXRef-Package-base"},
+			Packages: []Package{
+				{ID: "SPDXRef-Package-base", Name: "base"},
+				{ID: "SPDXRef-Package-busybox", Name: "busybox", Version: "1.37.0-r0"},
+			},
+			Relationships: []Relationship{
+				{Element: "SPDXRef-Package-base", Type: "CONTAINS", Related: "SPDXRef-Package-busybox"},
+			},
+		})
+		require.NoError(t, err)
+
+		doc := Document{}
+		opts := &options.Options{
+			OS: options.OSInfo{ID: "wolfi", Name: "Wolfi"},
+			BaseImage: &options.BaseImageInfo{
+				Reference: "example.com/base",
+				Digest:    baseDigest,
+				SBOM:      baseSBOM,
+				Packages: []*apk.InstalledPackage{
+					{Package: apk.Package{Name: "busybox", Version: "1.37.0-r0", Arch: "x86_64"}},
+					{Package: apk.Package{Name: "zlib", Version: "1.3.1-r5", Arch: "x86_64", License: "Zlib"}},
+				},
+			},
+		}
+		require.NoError(t, New().addBaseImage(t.Context(), &doc, opts, &imagePackage))
+
+		// Packages the base image's SBOM does not describe are added.
+		require.Len(t, doc.Packages, 3)
+		require.Equal(t, Package{
+			ID:               "SPDXRef-Package-zlib-1.3.1-r5",
+			Name:             "zlib",
+			Version:          "1.3.1-r5",
+			Supplier:         "Organization: Wolfi",
+			DownloadLocation: NOASSERTION,
+			LicenseDeclared:  "Zlib",
+			ExternalRefs:     []ExternalRef{{Category: ExtRefPackageManager, Type: ExtRefTypePurl, Locator: "pkg:apk/wolfi/zlib@1.3.1-r5?arch=x86_64"}},
+		}, doc.Packages[2])
+		require.Contains(t, doc.Relationships, Relationship{Element: "SPDXRef-Package-busybox", Type: "CONTAINED_BY", Related: "SPDXRef-Package-base"})
+		require.Contains(t, doc.Relationships, Relationship{Element: "SPDXRef-Package-zlib-1.3.1-r5", Type: "CONTAINED_BY", Related: "SPDXRef-Package-base"})
+	})
+}
+
+func TestImageAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	opts := testOpts(apkfs.NewMemFS())
+	opts.ImageInfo.ImageDigest = "sha256:5a99438a9ced8193f1d71209d0b558fdc0b184aee5cf258e5f7aa9a6ab0f0671"
+	opts.SkippedScriptlets = []string{"musl-1.2.2-r7.post-install"}
+	opts.ExcludedPaths = []string{"/usr/share/zoneinfo/right"}
+	sx := New()
+	path := filepath.Join(dir, opts.FileName+"."+sx.Ext())
+	require.NoError(t, sx.Generate(t.Context(), opts, path))
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var doc Document
+	require.NoError(t, json.Unmarshal(b, &doc))
+	require.Len(t, doc.DocumentDescribes, 1)
+	for _, p := range doc.Packages {
+		if p.ID != doc.DocumentDescribes[0] {
+			require.Empty(t, p.Annotations, p.ID)
+			continue
+		}
+		require.Len(t, p.Annotations, 2)
+		require.Equal(t, "OTHER", p.Annotations[0].Type)
+		require.Equal(t, "skipped install scriptlet: musl-1.2.2-r7.post-install", p.Annotations[0].Comment)
+		require.Equal(t, "excluded path: /usr/share/zoneinfo/right", p.Annotations[1].Comment)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	fsys := apkfs.NewMemFS()
+	require.NoError(t, fsys.MkdirAll("usr/bin", 0o755))
+	require.NoError(t, fsys.WriteFile("usr/bin/hello", []byte("hello"), 0o755))
+	require.NoError(t, fsys.Symlink("hello", "usr/bin/hi"))
+
+	dir := t.TempDir()
+	opts := testOpts(fsys)
+	opts.ImageInfo.ImageDigest = "sha256:5a99438a9ced8193f1d71209d0b558fdc0b184aee5cf258e5f7aa9a6ab0f0671"
+	opts.Packages = []*apk.InstalledPackage{{
+		Package: apk.Package{Name: "hello", Version: "1.0-r0"},
+		Files: []tar.Header{
+			{Name: "usr/bin", Typeflag: tar.TypeDir},
+			{Name: "usr/bin/hello"},
+			{Name: "usr/bin/hi"},
+			{Name: "usr/bin/removed"},
+		},
+	}}
+	sx := New()
+	path := filepath.Join(dir, opts.FileName+"."+sx.Ext())
+
+	// Files are only listed when asked for.
+	require.NoError(t, sx.Generate(t.Context(), opts, path))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var doc Document
+	require.NoError(t, json.Unmarshal(b, &doc))
+	require.Empty(t, doc.Files)
+
+	opts.Files = true
+	require.NoError(t, sx.Generate(t.Context(), opts, path))
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	doc = Document{}
+	require.NoError(t, json.Unmarshal(b, &doc))
+	require.Equal(t, []File{{
+		ID:   "SPDXRef-File-usrC47binC47hello",
+		Name: "/usr/bin/hello",
+		Checksums: []Checksum{
+			{Algorithm: "SHA1", Value: "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"},
+			{Algorithm: "SHA256", Value: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		},
+	}}, doc.Files)
+	require.Contains(t, doc.Relationships, Relationship{Element: doc.DocumentDescribes[0], Type: "CONTAINS", Related: "SPDXRef-File-usrC47binC47hello"})
+}
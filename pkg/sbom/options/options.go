@@ -17,6 +17,7 @@ package options
 import (
 	"fmt"
 	"net/url"
+	"os"
 	"path/filepath"
 	"sort"
 	"time"
@@ -25,6 +26,7 @@ import (
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	ggcrtypes "github.com/google/go-containerregistry/pkg/v1/types"
 	purl "github.com/package-url/packageurl-go"
+	"gopkg.in/yaml.v3"
 
 	"chainguard.dev/apko/pkg/apk/apk"
 	"chainguard.dev/apko/pkg/apk/fs"
@@ -50,6 +52,68 @@ type Options struct {
 
 	// Packages is a list of packages which will be listed in the SBOM
 	Packages []*apk.InstalledPackage
+
+	// BaseImage describes the image this one was built on top of, if any
+	BaseImage *BaseImageInfo
+
+	// Files, if set, lists the files installed by Packages in the SBOM.
+	Files bool
+
+	// DetectLicenses, if set, classifies the license files of the Packages
+	// that declare no license, and records what was found as detected.
+	DetectLicenses bool
+
+	// CPEs, if set, adds a best effort CPE to the packages alongside their
+	// purls.
+	CPEs bool
+
+	// CPEMapping maps package names or origins to their CPE vendor and
+	// product, taking precedence over the built-in mapping.
+	CPEMapping map[string]CPEProduct
+
+	// SkippedScriptlets are the install scriptlets that were not run, as
+	// "<name>-<version>.<scriptlet>"
+	SkippedScriptlets []string
+
+	// ExcludedPaths are the paths removed from the image by its
+	// exclude-paths, even though packages installed them.
+	ExcludedPaths []string
+}
+
+// CPEProduct is the vendor and product of the CPEs of a package.
+type CPEProduct struct {
+	Vendor  string `yaml:"vendor"`
+	Product string `yaml:"product"`
+}
+
+// LoadCPEMapping reads a YAML file mapping package names or origins to their
+// CPE vendor and product.
+func LoadCPEMapping(path string) (map[string]CPEProduct, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading CPE mapping: %w", err)
+	}
+	mapping := map[string]CPEProduct{}
+	if err := yaml.Unmarshal(b, &mapping); err != nil {
+		return nil, fmt.Errorf("parsing CPE mapping %s: %w", path, err)
+	}
+	for name, p := range mapping {
+		if p.Vendor == "" || p.Product == "" {
+			return nil, fmt.Errorf("CPE mapping %s: %s needs both a vendor and a product", path, name)
+		}
+	}
+	return mapping, nil
+}
+
+type BaseImageInfo struct {
+	// Reference is the base image as configured
+	Reference string
+	// Digest is the digest of the base image for the architecture
+	Digest string
+	// SBOM is the SPDX SBOM of the base image, nil if none was found
+	SBOM []byte
+	// Packages are the packages installed in the base image
+	Packages []*apk.InstalledPackage
 }
 
 type PurlQualifiers map[string]string
@@ -15,6 +15,8 @@
 package options
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -45,3 +47,17 @@ func TestPurlQualifierString(t *testing.T) {
 		require.Equal(t, tc.e, tc.q.String())
 	}
 }
+
+func TestLoadCPEMapping(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.yaml")
+	require.NoError(t, os.WriteFile(good, []byte("libcurl-openssl4:\n  vendor: haxx\n  product: libcurl\n"), 0o644))
+	mapping, err := LoadCPEMapping(good)
+	require.NoError(t, err)
+	require.Equal(t, map[string]CPEProduct{"libcurl-openssl4": {Vendor: "haxx", Product: "libcurl"}}, mapping)
+
+	bad := filepath.Join(dir, "bad.yaml")
+	require.NoError(t, os.WriteFile(bad, []byte("foo:\n  vendor: foo\n"), 0o644))
+	_, err = LoadCPEMapping(bad)
+	require.ErrorContains(t, err, "foo needs both a vendor and a product")
+}
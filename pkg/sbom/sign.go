@@ -0,0 +1,243 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sigstore/sigstore-go/pkg/sign"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/kms"
+	"github.com/sigstore/sigstore/pkg/signature/options"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	// SignatureExt is appended to the path of an SBOM for its detached
+	// signature.
+	SignatureExt = ".sig"
+	// BundleExt is appended to the path of an SBOM for its Sigstore bundle.
+	BundleExt = ".sigstore.json"
+
+	// DefaultFulcioURL is the public Fulcio instance of Sigstore.
+	DefaultFulcioURL = "https://fulcio.sigstore.dev"
+	// DefaultRekorURL is the public Rekor instance of Sigstore.
+	DefaultRekorURL = "https://rekor.sigstore.dev"
+)
+
+// Signer signs SBOMs, writing the signature next to each.
+type Signer interface {
+	// SignFile signs the file at path and returns the path of the signature.
+	SignFile(ctx context.Context, path string) (string, error)
+}
+
+// KeySigner signs SBOMs with a private key, local or in a KMS, writing a
+// detached signature in the form of cosign sign-blob.
+type KeySigner struct {
+	signer signature.Signer
+}
+
+// LoadSigningKey returns a KeySigner for ref, which is either the path of an
+// unencrypted PEM private key (PKCS#8, SEC 1 or PKCS#1) or the URI of a KMS
+// key, such as awskms:///alias/sbom. KMS providers must be registered with
+// kms.AddProvider, usually by importing their package.
+func LoadSigningKey(ctx context.Context, ref string) (*KeySigner, error) {
+	if strings.Contains(ref, "://") {
+		signer, err := kms.Get(ctx, ref, crypto.SHA256)
+		if err != nil {
+			return nil, fmt.Errorf("loading signing key %s: %w", ref, err)
+		}
+		return &KeySigner{signer: signer}, nil
+	}
+
+	key, err := loadPEMKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := signature.LoadSigner(key, crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("loading signing key %s: %w", ref, err)
+	}
+	return &KeySigner{signer: signer}, nil
+}
+
+func loadPEMKey(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s: no PEM block found", path)
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing signing key %s: %w", path, err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("signing key %s: %T cannot sign", path, key)
+		}
+		return signer, nil
+	default:
+		return nil, fmt.Errorf("signing key %s: unsupported PEM block %q, encrypted keys must be decrypted first", path, block.Type)
+	}
+}
+
+// SignFile writes a detached signature of the file at path next to it, and
+// returns its path. The signature is base64 encoded and made over the SHA-256
+// digest of the file, or the file itself for Ed25519 keys, as cosign
+// sign-blob does.
+func (s *KeySigner) SignFile(ctx context.Context, path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	sig, err := s.signer.SignMessage(bytes.NewReader(b), options.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("signing %s: %w", path, err)
+	}
+
+	sigPath := path + SignatureExt
+	//nolint:gosec // Make signature readable by non-root, like the SBOM
+	if err := os.WriteFile(sigPath, []byte(base64.StdEncoding.EncodeToString(sig)), 0o644); err != nil {
+		return "", fmt.Errorf("writing signature: %w", err)
+	}
+	return sigPath, nil
+}
+
+// KeylessOptions configure keyless signing with Sigstore.
+type KeylessOptions struct {
+	// FulcioURL is the Fulcio instance issuing the signing certificate.
+	FulcioURL string
+	// RekorURL is the Rekor instance logging the signature. If empty, the
+	// signature is not logged.
+	RekorURL string
+	// IDToken is the OIDC identity token of the signer, defaulting to
+	// $SIGSTORE_ID_TOKEN.
+	IDToken string
+}
+
+// KeylessSigner signs SBOMs with an ephemeral key certified by Fulcio for an
+// OIDC identity, writing a Sigstore bundle.
+type KeylessSigner struct {
+	opts KeylessOptions
+}
+
+// NewKeylessSigner returns a KeylessSigner for opts.
+func NewKeylessSigner(opts KeylessOptions) (*KeylessSigner, error) {
+	if opts.FulcioURL == "" {
+		return nil, errors.New("keyless signing needs a Fulcio URL")
+	}
+	if opts.IDToken == "" {
+		opts.IDToken = os.Getenv("SIGSTORE_ID_TOKEN")
+	}
+	if opts.IDToken == "" {
+		return nil, errors.New("keyless signing needs an OIDC identity token, in $SIGSTORE_ID_TOKEN")
+	}
+	return &KeylessSigner{opts: opts}, nil
+}
+
+// SignFile signs the file at path with a fresh ephemeral key, and writes the
+// Sigstore bundle of the signature, certificate and log entry next to it. It
+// returns the path of the bundle, which cosign verify-blob --bundle checks.
+func (s *KeylessSigner) SignFile(ctx context.Context, path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	keypair, err := sign.NewEphemeralKeypair(nil)
+	if err != nil {
+		return "", fmt.Errorf("generating ephemeral key: %w", err)
+	}
+	opts := sign.BundleOptions{
+		CertificateProvider:        sign.NewFulcio(&sign.FulcioOptions{BaseURL: s.opts.FulcioURL}),
+		CertificateProviderOptions: &sign.CertificateProviderOptions{IDToken: s.opts.IDToken},
+		Context:                    ctx,
+	}
+	if s.opts.RekorURL != "" {
+		opts.TransparencyLogs = []sign.Transparency{sign.NewRekor(&sign.RekorOptions{BaseURL: s.opts.RekorURL})}
+	}
+	bundle, err := sign.Bundle(&sign.PlainData{Data: b}, keypair, opts)
+	if err != nil {
+		return "", fmt.Errorf("signing %s: %w", path, err)
+	}
+	bb, err := protojson.Marshal(bundle)
+	if err != nil {
+		return "", fmt.Errorf("encoding bundle: %w", err)
+	}
+
+	bundlePath := path + BundleExt
+	//nolint:gosec // Make bundle readable by non-root, like the SBOM
+	if err := os.WriteFile(bundlePath, bb, 0o644); err != nil {
+		return "", fmt.Errorf("writing bundle: %w", err)
+	}
+	return bundlePath, nil
+}
+
+// VerifyFile checks the detached signature written by SignFile of the file
+// at path against pub.
+func VerifyFile(pub crypto.PublicKey, path, sigPath string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	encoded, err := os.ReadFile(sigPath)
+	if err != nil {
+		return fmt.Errorf("reading signature: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	digest := sha256.Sum256(b)
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, b, sig) {
+			return errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return errors.New("invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("invalid signature: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported public key %T", pub)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sigstore/sigstore-go/pkg/bundle"
+	"github.com/sigstore/sigstore/pkg/signature/kms/fake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignFile(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name  string
+		block *pem.Block
+		pub   crypto.PublicKey
+	}{
+		{"ecdsa", &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}, ecKey.Public()},
+		{"ed25519", &pem.Block{Type: "PRIVATE KEY", Bytes: edDER}, edKey.Public()},
+		{"rsa", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}, rsaKey.Public()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			keyPath := filepath.Join(dir, "key.pem")
+			require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(tt.block), 0o600))
+			sbomPath := filepath.Join(dir, "sbom-x86_64.spdx.json")
+			require.NoError(t, os.WriteFile(sbomPath, []byte(`{"spdxVersion":"SPDX-2.3"}`), 0o644))
+
+			signer, err := LoadSigningKey(t.Context(), keyPath)
+			require.NoError(t, err)
+			sigPath, err := signer.SignFile(t.Context(), sbomPath)
+			require.NoError(t, err)
+			require.Equal(t, sbomPath+".sig", sigPath)
+			require.NoError(t, VerifyFile(tt.pub, sbomPath, sigPath))
+
+			// Changing the SBOM invalidates the signature.
+			require.NoError(t, os.WriteFile(sbomPath, []byte(`{"spdxVersion":"SPDX-2.2"}`), 0o644))
+			require.Error(t, VerifyFile(tt.pub, sbomPath, sigPath))
+		})
+	}
+
+	t.Run("encrypted", func(t *testing.T) {
+		keyPath := filepath.Join(t.TempDir(), "key.pem")
+		require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte("x")}), 0o600))
+		_, err := LoadSigningKey(t.Context(), keyPath)
+		require.ErrorContains(t, err, "encrypted keys must be decrypted first")
+	})
+
+	t.Run("kms", func(t *testing.T) {
+		ctx := context.WithValue(t.Context(), fake.KmsCtxKey{}, crypto.PrivateKey(ecKey))
+		sbomPath := filepath.Join(t.TempDir(), "sbom-x86_64.spdx.json")
+		require.NoError(t, os.WriteFile(sbomPath, []byte(`{"spdxVersion":"SPDX-2.3"}`), 0o644))
+
+		signer, err := LoadSigningKey(ctx, fake.ReferenceScheme+"sbom")
+		require.NoError(t, err)
+		sigPath, err := signer.SignFile(ctx, sbomPath)
+		require.NoError(t, err)
+		require.NoError(t, VerifyFile(ecKey.Public(), sbomPath, sigPath))
+	})
+
+	t.Run("unknown kms", func(t *testing.T) {
+		_, err := LoadSigningKey(t.Context(), "nokms://sbom")
+		require.ErrorContains(t, err, "nokms://sbom")
+	})
+}
+
+func TestKeylessSignFile(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// A fake Fulcio, certifying the public key of each request for a day.
+	fulcio := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v2/signingCert", r.URL.Path)
+		var req struct {
+			PublicKeyRequest struct {
+				PublicKey struct {
+					Content string `json:"content"`
+				} `json:"publicKey"`
+			} `json:"publicKeyRequest"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		block, _ := pem.Decode([]byte(req.PublicKeyRequest.PublicKey.Content))
+		require.NotNil(t, block)
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber:   big.NewInt(1),
+			NotBefore:      time.Now().Add(-time.Minute),
+			NotAfter:       time.Now().Add(24 * time.Hour),
+			KeyUsage:       x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+			EmailAddresses: []string{"builder@example.com"},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, pub, caKey)
+		require.NoError(t, err)
+		resp := map[string]any{"signedCertificateEmbeddedSct": map[string]any{"chain": map[string]any{
+			"certificates": []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))},
+		}}}
+		require.NoError(t, json.NewEncoder(w).Encode(resp))
+	}))
+	defer fulcio.Close()
+
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"builder@example.com","email":"builder@example.com","email_verified":true}`))
+	t.Setenv("SIGSTORE_ID_TOKEN", "e30."+claims+".sig")
+
+	sbomPath := filepath.Join(t.TempDir(), "sbom-x86_64.spdx.json")
+	sbom := []byte(`{"spdxVersion":"SPDX-2.3"}`)
+	require.NoError(t, os.WriteFile(sbomPath, sbom, 0o644))
+
+	signer, err := NewKeylessSigner(KeylessOptions{FulcioURL: fulcio.URL})
+	require.NoError(t, err)
+	bundlePath, err := signer.SignFile(t.Context(), sbomPath)
+	require.NoError(t, err)
+	require.Equal(t, sbomPath+BundleExt, bundlePath)
+
+	// The bundle holds the certificate and the signature of the SBOM by its
+	// key.
+	var b bundle.Bundle
+	bb, err := os.ReadFile(bundlePath)
+	require.NoError(t, err)
+	require.NoError(t, b.UnmarshalJSON(bb))
+	cert, err := x509.ParseCertificate(b.GetVerificationMaterial().GetCertificate().GetRawBytes())
+	require.NoError(t, err)
+	require.Equal(t, []string{"builder@example.com"}, cert.EmailAddresses)
+	digest := sha256.Sum256(sbom)
+	require.Equal(t, digest[:], b.GetMessageSignature().GetMessageDigest().GetDigest())
+	require.True(t, ecdsa.VerifyASN1(cert.PublicKey.(*ecdsa.PublicKey), digest[:], b.GetMessageSignature().GetSignature()))
+
+	t.Run("no token", func(t *testing.T) {
+		t.Setenv("SIGSTORE_ID_TOKEN", "")
+		_, err := NewKeylessSigner(KeylessOptions{FulcioURL: fulcio.URL})
+		require.ErrorContains(t, err, "SIGSTORE_ID_TOKEN")
+	})
+}
@@ -0,0 +1,203 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package supervision writes the service trees of the supervisors that run
+// the services of service-bundle images.
+package supervision
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"regexp"
+	"slices"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+	"chainguard.dev/apko/pkg/s6"
+)
+
+// The supervisors of service bundles.
+const (
+	// S6 runs the services with s6-svscan, from execline run scripts in
+	// /sv. It is the default.
+	S6 = "s6"
+	// S6Overlay runs the services as s6-rc longruns of s6-overlay v3, from
+	// execline run scripts, once its base bundle is up.
+	S6Overlay = "s6-overlay"
+	// DumbInit runs a single service with dumb-init, from a shell run script
+	// in /sv.
+	DumbInit = "dumb-init"
+	// RC runs the services as OpenRC services of the default runlevel, from
+	// shell run scripts in /sv, with openrc-init.
+	RC = "rc"
+)
+
+// Supervisor is a supervisor of the services of an image.
+type Supervisor interface {
+	// Packages returns the packages that provide the supervisor.
+	Packages() []string
+	// Entrypoint returns the entrypoint command of an image running
+	// services.
+	Entrypoint(services map[string]string) (string, error)
+	// WriteTree writes the files the supervisor runs services from.
+	WriteTree(ctx context.Context, fsys apkfs.FullFS, services map[string]string) error
+}
+
+var supervisors = map[string]Supervisor{
+	S6:        s6Supervisor{},
+	S6Overlay: s6OverlaySupervisor{},
+	DumbInit:  dumbInitSupervisor{},
+	RC:        rcSupervisor{},
+}
+
+// New returns the supervisor of name, S6 if it is empty.
+func New(name string) (Supervisor, error) {
+	if name == "" {
+		name = S6
+	}
+	sup, ok := supervisors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown supervisor %q, expected one of %q", name, slices.Sorted(maps.Keys(supervisors)))
+	}
+	return sup, nil
+}
+
+// serviceNameRegex matches the names of services, which name their files.
+var serviceNameRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// ValidateServices checks that the names of services can name their files,
+// and that they have commands.
+func ValidateServices(services map[string]string) error {
+	for _, name := range slices.Sorted(maps.Keys(services)) {
+		if !serviceNameRegex.MatchString(name) {
+			return fmt.Errorf("service %q must be letters, digits, '_', '.' and '-'", name)
+		}
+		if services[name] == "" {
+			return fmt.Errorf("service %q has no command", name)
+		}
+	}
+	return nil
+}
+
+// writeFiles writes the contents of files by path with mode, creating their
+// parents.
+func writeFiles(fsys apkfs.FullFS, files map[string]string, mode fs.FileMode) error {
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		if err := fsys.MkdirAll(path.Dir(name), 0o755); err != nil {
+			return fmt.Errorf("creating %s: %w", path.Dir(name), err)
+		}
+		if err := fsys.WriteFile(name, []byte(files[name]), mode); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// shellRunScripts returns the shell run scripts of services in /sv, which
+// exec their commands so that the supervisor signals them directly.
+func shellRunScripts(services map[string]string) map[string]string {
+	files := map[string]string{}
+	for name, cmd := range services {
+		files[path.Join("sv", name, "run")] = fmt.Sprintf("#!/bin/sh\nexec %s\n", cmd)
+	}
+	return files
+}
+
+type s6Supervisor struct{}
+
+func (s6Supervisor) Packages() []string { return []string{"s6"} }
+
+func (s6Supervisor) Entrypoint(map[string]string) (string, error) { return "/bin/s6-svscan /sv", nil }
+
+func (s6Supervisor) WriteTree(ctx context.Context, fsys apkfs.FullFS, services map[string]string) error {
+	return s6.New(fsys).WriteSupervisionTree(ctx, services)
+}
+
+type s6OverlaySupervisor struct{}
+
+// s6RCDir is the source directory of the s6-rc services of s6-overlay.
+const s6RCDir = "etc/s6-overlay/s6-rc.d"
+
+func (s6OverlaySupervisor) Packages() []string { return []string{"s6-overlay"} }
+
+func (s6OverlaySupervisor) Entrypoint(map[string]string) (string, error) { return "/init", nil }
+
+func (s6OverlaySupervisor) WriteTree(_ context.Context, fsys apkfs.FullFS, services map[string]string) error {
+	scripts := map[string]string{}
+	files := map[string]string{
+		// The bundle s6-overlay starts, which it usually provides.
+		path.Join(s6RCDir, "user", "type"): "bundle\n",
+	}
+	for name, cmd := range services {
+		dir := path.Join(s6RCDir, name)
+		scripts[path.Join(dir, "run")] = fmt.Sprintf("#!/command/execlineb -P\n%s\n", cmd)
+		files[path.Join(dir, "type")] = "longrun\n"
+		// Once the base bundle is up, as s6-overlay recommends.
+		files[path.Join(dir, "dependencies.d", "base")] = ""
+		files[path.Join(s6RCDir, "user", "contents.d", name)] = ""
+	}
+	if err := writeFiles(fsys, files, 0o644); err != nil {
+		return err
+	}
+	return writeFiles(fsys, scripts, 0o755)
+}
+
+type dumbInitSupervisor struct{}
+
+func (dumbInitSupervisor) Packages() []string { return []string{"dumb-init"} }
+
+func (dumbInitSupervisor) Entrypoint(services map[string]string) (string, error) {
+	if len(services) != 1 {
+		return "", fmt.Errorf("the %s supervisor runs a single service, not %d", DumbInit, len(services))
+	}
+	name := slices.Collect(maps.Keys(services))[0]
+	return "/usr/bin/dumb-init " + path.Join("/sv", name, "run"), nil
+}
+
+func (dumbInitSupervisor) WriteTree(_ context.Context, fsys apkfs.FullFS, services map[string]string) error {
+	return writeFiles(fsys, shellRunScripts(services), 0o755)
+}
+
+type rcSupervisor struct{}
+
+func (rcSupervisor) Packages() []string { return []string{"openrc"} }
+
+func (rcSupervisor) Entrypoint(map[string]string) (string, error) { return "/sbin/openrc-init", nil }
+
+func (rcSupervisor) WriteTree(_ context.Context, fsys apkfs.FullFS, services map[string]string) error {
+	scripts := shellRunScripts(services)
+	for name := range services {
+		scripts[path.Join("etc/init.d", name)] = fmt.Sprintf(`#!/sbin/openrc-run
+description="%s, generated by apko"
+command="/sv/%s/run"
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+`, name, name)
+	}
+	if err := writeFiles(fsys, scripts, 0o755); err != nil {
+		return err
+	}
+
+	if err := fsys.MkdirAll("etc/runlevels/default", 0o755); err != nil {
+		return fmt.Errorf("creating etc/runlevels/default: %w", err)
+	}
+	for _, name := range slices.Sorted(maps.Keys(services)) {
+		if err := fsys.Symlink(path.Join("/etc/init.d", name), path.Join("etc/runlevels/default", name)); err != nil {
+			return fmt.Errorf("adding %s to the default runlevel: %w", name, err)
+		}
+	}
+	return nil
+}
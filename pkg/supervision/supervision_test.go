@@ -0,0 +1,163 @@
+// Copyright 2025 Chainguard, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package supervision
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	apkfs "chainguard.dev/apko/pkg/apk/fs"
+)
+
+// tree returns the files of fsys by path, with their mode and content, or
+// their link target.
+func tree(t *testing.T, fsys apkfs.FullFS) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	require.NoError(t, fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		switch {
+		case d.IsDir():
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := fsys.Readlink(name)
+			require.NoError(t, err)
+			files[name] = "-> " + target
+		default:
+			fi, err := d.Info()
+			require.NoError(t, err)
+			content, err := fsys.ReadFile(name)
+			require.NoError(t, err)
+			files[name] = fi.Mode().Perm().String() + " " + string(content)
+		}
+		return nil
+	}))
+	return files
+}
+
+func TestWriteTree(t *testing.T) {
+	services := map[string]string{
+		"nginx": "/usr/sbin/nginx -g \"daemon off;\"",
+		"php":   "/usr/sbin/php-fpm -F",
+	}
+
+	for _, tt := range []struct {
+		supervisor string
+		services   map[string]string
+		entrypoint string
+		packages   []string
+		want       map[string]string
+	}{{
+		supervisor: S6,
+		services:   services,
+		entrypoint: "/bin/s6-svscan /sv",
+		packages:   []string{"s6"},
+		want: map[string]string{
+			"sv/nginx/run": "-rwxr-xr-x #!/bin/execlineb\n/usr/sbin/nginx -g \"daemon off;\"\n",
+			"sv/php/run":   "-rwxr-xr-x #!/bin/execlineb\n/usr/sbin/php-fpm -F\n",
+		},
+	}, {
+		supervisor: S6Overlay,
+		services:   services,
+		entrypoint: "/init",
+		packages:   []string{"s6-overlay"},
+		want: map[string]string{
+			"etc/s6-overlay/s6-rc.d/user/type":                 "-rw-r--r-- bundle\n",
+			"etc/s6-overlay/s6-rc.d/user/contents.d/nginx":     "-rw-r--r-- ",
+			"etc/s6-overlay/s6-rc.d/user/contents.d/php":       "-rw-r--r-- ",
+			"etc/s6-overlay/s6-rc.d/nginx/type":                "-rw-r--r-- longrun\n",
+			"etc/s6-overlay/s6-rc.d/nginx/dependencies.d/base": "-rw-r--r-- ",
+			"etc/s6-overlay/s6-rc.d/nginx/run":                 "-rwxr-xr-x #!/command/execlineb -P\n/usr/sbin/nginx -g \"daemon off;\"\n",
+			"etc/s6-overlay/s6-rc.d/php/type":                  "-rw-r--r-- longrun\n",
+			"etc/s6-overlay/s6-rc.d/php/dependencies.d/base":   "-rw-r--r-- ",
+			"etc/s6-overlay/s6-rc.d/php/run":                   "-rwxr-xr-x #!/command/execlineb -P\n/usr/sbin/php-fpm -F\n",
+		},
+	}, {
+		supervisor: DumbInit,
+		services:   map[string]string{"nginx": services["nginx"]},
+		entrypoint: "/usr/bin/dumb-init /sv/nginx/run",
+		packages:   []string{"dumb-init"},
+		want: map[string]string{
+			"sv/nginx/run": "-rwxr-xr-x #!/bin/sh\nexec /usr/sbin/nginx -g \"daemon off;\"\n",
+		},
+	}, {
+		supervisor: RC,
+		services:   services,
+		entrypoint: "/sbin/openrc-init",
+		packages:   []string{"openrc"},
+		want: map[string]string{
+			"sv/nginx/run":                "-rwxr-xr-x #!/bin/sh\nexec /usr/sbin/nginx -g \"daemon off;\"\n",
+			"sv/php/run":                  "-rwxr-xr-x #!/bin/sh\nexec /usr/sbin/php-fpm -F\n",
+			"etc/runlevels/default/nginx": "-> /etc/init.d/nginx",
+			"etc/runlevels/default/php":   "-> /etc/init.d/php",
+			"etc/init.d/nginx": `-rwxr-xr-x #!/sbin/openrc-run
+description="nginx, generated by apko"
+command="/sv/nginx/run"
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+`,
+			"etc/init.d/php": `-rwxr-xr-x #!/sbin/openrc-run
+description="php, generated by apko"
+command="/sv/php/run"
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+`,
+		},
+	}} {
+		t.Run(tt.supervisor, func(t *testing.T) {
+			sup, err := New(tt.supervisor)
+			require.NoError(t, err)
+			require.Equal(t, tt.packages, sup.Packages())
+			entrypoint, err := sup.Entrypoint(tt.services)
+			require.NoError(t, err)
+			require.Equal(t, tt.entrypoint, entrypoint)
+
+			fsys := apkfs.NewMemFS()
+			require.NoError(t, sup.WriteTree(context.Background(), fsys, tt.services))
+			files := tree(t, fsys)
+			require.Equal(t, tt.want, files)
+
+			// Every script the supervisor runs can be run.
+			for name, file := range files {
+				if strings.HasSuffix(name, "/run") || strings.HasPrefix(name, "etc/init.d/") {
+					require.True(t, strings.HasPrefix(file, "-rwxr-xr-x #!/"), name)
+				}
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	sup, err := New("")
+	require.NoError(t, err)
+	require.Equal(t, s6Supervisor{}, sup)
+
+	_, err = New("systemd")
+	require.EqualError(t, err, `unknown supervisor "systemd", expected one of ["dumb-init" "rc" "s6" "s6-overlay"]`)
+}
+
+func TestDumbInitSingleService(t *testing.T) {
+	_, err := dumbInitSupervisor{}.Entrypoint(map[string]string{"a": "a", "b": "b"})
+	require.EqualError(t, err, "the dumb-init supervisor runs a single service, not 2")
+}
+
+func TestValidateServices(t *testing.T) {
+	require.NoError(t, ValidateServices(map[string]string{"nginx-1.2_a": "nginx"}))
+	require.EqualError(t, ValidateServices(map[string]string{"../etc": "evil"}), `service "../etc" must be letters, digits, '_', '.' and '-'`)
+	require.EqualError(t, ValidateServices(map[string]string{"nginx": ""}), `service "nginx" has no command`)
+}
@@ -27,7 +27,6 @@ import (
 	"os"
 	"path"
 	"path/filepath"
-	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -493,19 +492,11 @@ func (m *memFS) writeHeader(name string, te tarEntry) (bool, error) {
 		return false, nil
 	}
 
-	// If the existing file's package replaces the package we want to install, we don't need to write this file.
-	if slices.Contains(got.pkg.Replaces, want.pkg.Name) {
+	// At this point we know the files conflict, replaces decides which one is kept.
+	switch apk.ReplacesFile(got.pkg, want.pkg) {
+	case apk.ReplaceKeep:
 		return false, nil
-	}
-
-	// Otherwise, determine if the package we are installing replaces the existing package.
-	replaces := slices.Contains(want.pkg.Replaces, got.pkg.Name)
-
-	// Or if they're from the same origin.
-	sameOrigin := got.pkg.Origin == want.pkg.Origin
-
-	// At this point we know the files conflict, but it's okay if this file replaces that one.
-	if !sameOrigin && !replaces {
+	case apk.ReplaceConflict:
 		return false, apk.FileConflictError{
 			Path: name,
 			Origins: map[string]string{
@@ -1035,3 +1026,13 @@ func (m *memFileInfo) Package() *apk.Package {
 
 	return m.te.pkg
 }
+
+// Checksum returns the checksum of the file's contents from the header of its
+// package, or nil if it has none or the file has been written to since.
+func (m *memFileInfo) Checksum() []byte {
+	if m.te == nil || len(m.data) != 0 {
+		return nil
+	}
+
+	return m.te.checksum
+}